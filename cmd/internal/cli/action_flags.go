@@ -15,6 +15,7 @@ import (
 var (
 	AppName            string
 	BindPaths          []string
+	Mounts             []string
 	HomePath           string
 	OverlayPath        []string
 	ScratchPath        []string
@@ -25,18 +26,34 @@ var (
 	Network            string
 	NetworkArgs        []string
 	DNS                string
+	Timezone           string
+	Locale             string
 	Security           []string
 	CgroupsPath        string
 	VMRAM              string
 	VMCPU              string
 	VMIP               string
 	ContainLibsPath    []string
+	HostLibs           []string
 	FuseMount          []string
 	SingularityEnv     []string
 	SingularityEnvFile string
 	NoMount            []string
+	Ulimits            []string
+	SandboxRuntime     string
+	PidFile            string
+	InfoFile           string
+	RusageFile         string
+	Entrypoint         string
+	CompatEntrypoint   string
+	ArgsFile           string
+	PublishWeb         int
 
 	IsBoot          bool
+	Rusage          bool
+	History         bool
+	TrustImageFlags bool
+	Compat          bool
 	IsFakeroot      bool
 	IsCleanEnv      bool
 	IsContained     bool
@@ -46,10 +63,14 @@ var (
 	Nvidia          bool
 	Rocm            bool
 	NoHome          bool
+	NoHostLibs      bool
 	NoInit          bool
 	NoNvidia        bool
 	NoRocm          bool
 	NoUmask         bool
+	SecurityAudit   bool
+	DryRun          bool
+	DryRunJSON      bool
 	VM              bool
 	VMErr           bool
 	NoNet           bool
@@ -87,12 +108,24 @@ var actionBindFlag = cmdline.Flag{
 	DefaultValue: cmdline.StringArray{}, // to allow commas in bind path
 	Name:         "bind",
 	ShortHand:    "B",
-	Usage:        "a user-bind path specification.  spec has the format src[:dest[:opts]], where src and dest are outside and inside paths.  If dest is not given, it is set equal to src.  Mount options ('opts') may be specified as 'ro' (read-only) or 'rw' (read/write, which is the default). Multiple bind paths can be given by a comma separated list.",
+	Usage:        "a user-bind path specification.  spec has the format src[:dest[:opts]], where src and dest are outside and inside paths.  If dest is not given, it is set equal to src.  Mount options ('opts') may be specified as 'ro' (read-only) or 'rw' (read/write, which is the default), and 'missing=error|skip|create' sets what happens when dest doesn't exist in the container: fail (the default), skip the bind with a warning, or create dest in a writable image. Multiple bind paths can be given by a comma separated list. Paths can reference ${NAME} variables expanded from the user identity (USER, UID, GID, HOME) and the host environment.",
 	EnvKeys:      []string{"BIND", "BINDPATH"},
 	Tag:          "<spec>",
 	EnvHandler:   cmdline.EnvAppendValue,
 }
 
+// --mount
+var actionMountFlag = cmdline.Flag{
+	ID:           "actionMountFlag",
+	Value:        &Mounts,
+	DefaultValue: cmdline.StringArray{},
+	Name:         "mount",
+	Usage:        "a mount specification of comma separated key=value fields: type=volume mounts the named volume src, type=bind the host path src, at the absolute path dst in the container, ro mounts read-only, stripe-count and stripe-size set the Lustre striping hints of the directory (e.g. type=volume,src=mydata,dst=/data)",
+	EnvKeys:      []string{"MOUNT"},
+	Tag:          "<spec>",
+	EnvHandler:   cmdline.EnvAppendValue,
+}
+
 // -H|--home
 var actionHomeFlag = cmdline.Flag{
 	ID:           "actionHomeFlag",
@@ -100,7 +133,7 @@ var actionHomeFlag = cmdline.Flag{
 	DefaultValue: CurrentUser.HomeDir,
 	Name:         "home",
 	ShortHand:    "H",
-	Usage:        "a home directory specification.  spec can either be a src path or src:dest pair.  src is the source path of the home directory outside the container and dest overrides the home directory within the container.",
+	Usage:        "a home directory specification.  spec can either be a src path or src:dest pair.  src is the source path of the home directory outside the container and dest overrides the home directory within the container.  src can also be tmpfs for a temporary home directory discarded on exit, image:<path> for a home directory stored in an image or job for a home directory shared by the containers of a batch job.",
 	EnvKeys:      []string{"HOME"},
 	Tag:          "<spec>",
 }
@@ -226,24 +259,205 @@ var actionDNSFlag = cmdline.Flag{
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --tz
+var actionTimezoneFlag = cmdline.Flag{
+	ID:           "actionTimezoneFlag",
+	Value:        &Timezone,
+	DefaultValue: "",
+	Name:         "tz",
+	Usage:        "set the container time zone: host, UTC or a zone name like Europe/Paris (sets /etc/localtime and TZ)",
+	Tag:          "<zone>",
+	EnvKeys:      []string{"TZ"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --locale
+var actionLocaleFlag = cmdline.Flag{
+	ID:           "actionLocaleFlag",
+	Value:        &Locale,
+	DefaultValue: "",
+	Name:         "locale",
+	Usage:        "set the container locale: host to pass the host LANG and LC_* variables, or a locale name like C.UTF-8 (sets LANG)",
+	Tag:          "<locale>",
+	EnvKeys:      []string{"LOCALE"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 // --security
 var actionSecurityFlag = cmdline.Flag{
 	ID:           "actionSecurityFlag",
 	Value:        &Security,
 	DefaultValue: []string{},
 	Name:         "security",
-	Usage:        "enable security features (SELinux, Apparmor, Seccomp)",
+	Usage:        "enable security features (SELinux, Apparmor, Seccomp, Landlock, capability profiles)",
 	EnvKeys:      []string{"SECURITY"},
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --security-audit
+var actionSecurityAuditFlag = cmdline.Flag{
+	ID:           "actionSecurityAuditFlag",
+	Value:        &SecurityAudit,
+	DefaultValue: false,
+	Name:         "security-audit",
+	Usage:        "log every privilege escalation and privileged operation done by starter",
+	EnvKeys:      []string{"SECURITY_AUDIT"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --pid-file
+var actionPidFileFlag = cmdline.Flag{
+	ID:           "actionPidFileFlag",
+	Value:        &PidFile,
+	DefaultValue: "",
+	Name:         "pid-file",
+	Usage:        "write the container process PID to the given file",
+	EnvKeys:      []string{"PID_FILE"},
+	Tag:          "<path>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --rusage
+var actionRusageFlag = cmdline.Flag{
+	ID:           "actionRusageFlag",
+	Value:        &Rusage,
+	DefaultValue: false,
+	Name:         "rusage",
+	Usage:        "print the container resource usage (wall and CPU time, max RSS, I/O) once it exits",
+	EnvKeys:      []string{"RUSAGE"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --history
+var actionHistoryFlag = cmdline.Flag{
+	ID:           "actionHistoryFlag",
+	Value:        &History,
+	DefaultValue: false,
+	Name:         "history",
+	Usage:        "record the image digest, verified signers, command, binds, times and exit status of the run in the history shown by 'singularity history'",
+	EnvKeys:      []string{"HISTORY"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --rusage-file
+var actionRusageFileFlag = cmdline.Flag{
+	ID:           "actionRusageFileFlag",
+	Value:        &RusageFile,
+	DefaultValue: "",
+	Name:         "rusage-file",
+	Usage:        "write the container resource usage as JSON to the given file once it exits",
+	EnvKeys:      []string{"RUSAGE_FILE"},
+	Tag:          "<path>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --ulimit
+var actionUlimitFlag = cmdline.Flag{
+	ID:           "actionUlimitFlag",
+	Value:        &Ulimits,
+	DefaultValue: []string{},
+	Name:         "ulimit",
+	Usage:        "set a container process resource limit, in the form name=soft[:hard] (e.g. nofile=4096:8192)",
+	Tag:          "<limit>",
+	EnvKeys:      []string{"ULIMIT"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --entrypoint
+var actionEntrypointFlag = cmdline.Flag{
+	ID:           "actionEntrypointFlag",
+	Value:        &Entrypoint,
+	DefaultValue: "",
+	Name:         "entrypoint",
+	Usage:        "run the given command instead of the container runscript",
+	Tag:          "<command>",
+	EnvKeys:      []string{"ENTRYPOINT"},
+}
+
+// --compat-entrypoint
+var actionCompatEntrypointFlag = cmdline.Flag{
+	ID:           "actionCompatEntrypointFlag",
+	Value:        &CompatEntrypoint,
+	DefaultValue: compatEntrypointDocker,
+	Name:         "compat-entrypoint",
+	Usage:        "--entrypoint behavior with images built from OCI images: docker drops the image CMD, singularity uses it as default arguments",
+	Tag:          "<mode>",
+	EnvKeys:      []string{"COMPAT_ENTRYPOINT"},
+}
+
+// --args-file
+var actionArgsFileFlag = cmdline.Flag{
+	ID:           "actionArgsFileFlag",
+	Value:        &ArgsFile,
+	DefaultValue: "",
+	Name:         "args-file",
+	Usage:        "append the arguments read from the given file, one per line, to the command line arguments",
+	Tag:          "<path>",
+	EnvKeys:      []string{"ARGS_FILE"},
+}
+
+// --publish-web
+var actionPublishWebFlag = cmdline.Flag{
+	ID:           "actionPublishWebFlag",
+	Value:        &PublishWeb,
+	DefaultValue: 0,
+	Name:         "publish-web",
+	Usage:        "publish the web service of the container listening on the given port: allocate a free host port forwarded to it with a network namespace, pass the port and an access token to the service with SINGULARITY_WEB_PORT/SINGULARITY_WEB_TOKEN and JUPYTER_PORT/JUPYTER_TOKEN, and print its URL",
+	Tag:          "<port>",
+	EnvKeys:      []string{"PUBLISH_WEB"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --compat
+var actionCompatFlag = cmdline.Flag{
+	ID:           "actionCompatFlag",
+	Value:        &Compat,
+	DefaultValue: false,
+	Name:         "compat",
+	Usage:        "apply settings approximating docker run behavior (--containall --writable-tmpfs --no-home --no-mount cwd --no-init --no-umask)",
+	EnvKeys:      []string{"COMPAT"},
+}
+
+// --trust-image-flags
+var actionTrustImageFlagsFlag = cmdline.Flag{
+	ID:           "actionTrustImageFlagsFlag",
+	Value:        &TrustImageFlags,
+	DefaultValue: false,
+	Name:         "trust-image-flags",
+	Usage:        "apply the runtime flags recommended by the image (io.sylabs.runtime.flags label) without confirmation",
+	EnvKeys:      []string{"TRUST_IMAGE_FLAGS"},
+}
+
+// --info-file
+var actionInfoFileFlag = cmdline.Flag{
+	ID:           "actionInfoFileFlag",
+	Value:        &InfoFile,
+	DefaultValue: "",
+	Name:         "info-file",
+	Usage:        "write the container PID, instance name and cgroup path as JSON to the given file",
+	EnvKeys:      []string{"INFO_FILE"},
+	Tag:          "<path>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --runtime
+var actionRuntimeFlag = cmdline.Flag{
+	ID:           "actionRuntimeFlag",
+	Value:        &SandboxRuntime,
+	DefaultValue: "",
+	Name:         "runtime",
+	Usage:        "run a SIF image with a sandboxed OCI runtime for stronger isolation: runsc (gVisor), kata (Kata Containers) or the absolute path of an OCI runtime (root only), or run the WebAssembly module packaged in a SIF image with wasm",
+	EnvKeys:      []string{"RUNTIME"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 // --apply-cgroups
 var actionApplyCgroupsFlag = cmdline.Flag{
 	ID:           "actionApplyCgroupsFlag",
 	Value:        &CgroupsPath,
 	DefaultValue: "",
 	Name:         "apply-cgroups",
-	Usage:        "apply cgroups from file for container processes (root only)",
+	Usage:        "apply cgroups from TOML or OCI JSON resources file for container processes (unprivileged users require cgroups v2 delegation)",
 	EnvKeys:      []string{"APPLY_CGROUPS"},
 	ExcludedOS:   []string{cmdline.Darwin},
 }
@@ -302,6 +516,29 @@ var actionContainLibsFlag = cmdline.Flag{
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --host-libs
+var actionHostLibsFlag = cmdline.Flag{
+	ID:           "actionHostLibsFlag",
+	Value:        &HostLibs,
+	DefaultValue: []string{},
+	Name:         "host-libs",
+	Usage:        "inject the host library sets declared in hostlibs.conf, in addition to the sets enabled by the administrator",
+	EnvKeys:      []string{"HOST_LIBS"},
+	Tag:          "<set>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --no-host-libs
+var actionNoHostLibsFlag = cmdline.Flag{
+	ID:           "actionNoHostLibsFlag",
+	Value:        &NoHostLibs,
+	DefaultValue: false,
+	Name:         "no-host-libs",
+	Usage:        "do NOT inject the host library sets enabled by the administrator",
+	EnvKeys:      []string{"NO_HOST_LIBS"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 // --fusemount
 var actionFuseMountFlag = cmdline.Flag{
 	ID:           "actionFuseMountFlag",
@@ -660,6 +897,26 @@ var actionNoUmaskFlag = cmdline.Flag{
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --dry-run
+var actionDryRunFlag = cmdline.Flag{
+	ID:           "actionDryRunFlag",
+	Value:        &DryRun,
+	DefaultValue: false,
+	Name:         "dry-run",
+	Usage:        "print the namespaces, mounts, environment and security options of the container without starting it",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --dry-run-json
+var actionDryRunJSONFlag = cmdline.Flag{
+	ID:           "actionDryRunJSONFlag",
+	Value:        &DryRunJSON,
+	DefaultValue: false,
+	Name:         "dry-run-json",
+	Usage:        "like --dry-run with a JSON output",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 func init() {
 	addCmdInit(func(cmdManager *cmdline.CommandManager) {
 		cmdManager.RegisterCmd(ExecCmd)
@@ -685,16 +942,20 @@ func init() {
 		cmdManager.RegisterFlagForCmd(&actionAppFlag, actionsCmd...)
 		cmdManager.RegisterFlagForCmd(&actionApplyCgroupsFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionBindFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionMountFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionCleanEnvFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionContainAllFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionContainFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionContainLibsFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionDisableCacheFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionDNSFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionTimezoneFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionLocaleFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionDropCapsFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionFakerootFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionFuseMountFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionHomeFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionHostLibsFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionHostnameFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionIpcNamespaceFlag, actionsCmd...)
 		cmdManager.RegisterFlagForCmd(&actionKeepPrivsFlag, actionsInstanceCmd...)
@@ -702,6 +963,7 @@ func init() {
 		cmdManager.RegisterFlagForCmd(&actionNetworkArgsFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionNetworkFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionNoHomeFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionNoHostLibsFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionNoMountFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionNoInitFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionNONETFlag, actionsCmd...)
@@ -713,10 +975,24 @@ func init() {
 		cmdManager.RegisterFlagForCmd(&actionOverlayFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&commonPromptForPassphraseFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&commonPEMFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionPidFileFlag, actionsCmd...)
+		cmdManager.RegisterFlagForCmd(&actionInfoFileFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionRusageFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionHistoryFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionRusageFileFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionUlimitFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionTrustImageFlagsFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionCompatFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionPidNamespaceFlag, actionsCmd...)
 		cmdManager.RegisterFlagForCmd(&actionPwdFlag, actionsCmd...)
 		cmdManager.RegisterFlagForCmd(&actionScratchFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionSecurityFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionRuntimeFlag, actionsCmd...)
+		cmdManager.RegisterFlagForCmd(&actionSecurityAuditFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionEntrypointFlag, RunCmd)
+		cmdManager.RegisterFlagForCmd(&actionCompatEntrypointFlag, RunCmd)
+		cmdManager.RegisterFlagForCmd(&actionArgsFileFlag, ExecCmd, RunCmd)
+		cmdManager.RegisterFlagForCmd(&actionPublishWebFlag, RunCmd, instanceStartCmd)
 		cmdManager.RegisterFlagForCmd(&actionShellFlag, ShellCmd)
 		cmdManager.RegisterFlagForCmd(&actionSyOSFlag, ShellCmd)
 		cmdManager.RegisterFlagForCmd(&actionTmpDirFlag, actionsInstanceCmd...)
@@ -737,5 +1013,7 @@ func init() {
 		cmdManager.RegisterFlagForCmd(&actionEnvFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionEnvFileFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionNoUmaskFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionDryRunFlag, actionsCmd...)
+		cmdManager.RegisterFlagForCmd(&actionDryRunJSONFlag, actionsCmd...)
 	})
 }
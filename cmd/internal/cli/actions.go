@@ -10,18 +10,13 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"runtime"
 	"strings"
 
 	"github.com/hpcng/singularity/docs"
 	"github.com/hpcng/singularity/internal/pkg/cache"
-	"github.com/hpcng/singularity/internal/pkg/client/library"
-	"github.com/hpcng/singularity/internal/pkg/client/net"
-	"github.com/hpcng/singularity/internal/pkg/client/oci"
-	"github.com/hpcng/singularity/internal/pkg/client/oras"
-	"github.com/hpcng/singularity/internal/pkg/client/shub"
 	"github.com/hpcng/singularity/internal/pkg/util/uri"
 	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/util/singularityconf"
 	"github.com/spf13/cobra"
 )
 
@@ -30,9 +25,26 @@ const (
 )
 
 func getCacheHandle(cfg cache.Config) *cache.Handle {
+	var quotas []string
+	var systemDir string
+	if c := singularityconf.GetCurrentConfig(); c != nil {
+		quotas = c.CacheQuota
+		systemDir = c.SystemCacheDir
+	}
+	// user quotas override the ones set by the administrator
+	if env := os.Getenv(cache.QuotaEnv); env != "" {
+		quotas = append(quotas, strings.Split(env, ",")...)
+	}
+	cacheQuotas, err := cache.ParseQuotas(quotas)
+	if err != nil {
+		sylog.Fatalf("Failed to parse cache quotas: %s", err)
+	}
+
 	h, err := cache.New(cache.Config{
 		ParentDir: os.Getenv(cache.DirEnv),
 		Disable:   cfg.Disable,
+		Quotas:    cacheQuotas,
+		SystemDir: systemDir,
 	})
 	if err != nil {
 		sylog.Fatalf("Failed to create an image cache handle: %s", err)
@@ -53,55 +65,16 @@ func actionPreRun(cmd *cobra.Command, args []string) {
 	ctx := context.TODO()
 
 	replaceURIWithImage(ctx, cmd, args)
+	if err := setCompat(cmd); err != nil {
+		sylog.Fatalf("While applying --compat: %s", err)
+	}
+	applyImageFlags(cmd, args[0])
 
 	// set PATH after pulling images to be able to find potential
 	// docker credential helpers outside of standard paths
 	os.Setenv("PATH", defaultPath)
 }
 
-func handleOCI(ctx context.Context, imgCache *cache.Handle, cmd *cobra.Command, pullFrom string) (string, error) {
-	ociAuth, err := makeDockerCredentials(cmd)
-	if err != nil {
-		sylog.Fatalf("While creating Docker credentials: %v", err)
-	}
-	return oci.Pull(ctx, imgCache, pullFrom, tmpDir, ociAuth, noHTTPS, false)
-}
-
-func handleOras(ctx context.Context, imgCache *cache.Handle, cmd *cobra.Command, pullFrom string) (string, error) {
-	ociAuth, err := makeDockerCredentials(cmd)
-	if err != nil {
-		return "", fmt.Errorf("while creating docker credentials: %v", err)
-	}
-	return oras.Pull(ctx, imgCache, pullFrom, tmpDir, ociAuth)
-}
-
-func handleLibrary(ctx context.Context, imgCache *cache.Handle, pullFrom string) (string, error) {
-	r, err := library.NormalizeLibraryRef(pullFrom)
-	if err != nil {
-		return "", err
-	}
-
-	// Default "" = use current remote endpoint
-	var libraryURI string
-	if r.Host != "" {
-		libraryURI = "https://" + r.Host
-	}
-
-	c, err := getLibraryClientConfig(libraryURI)
-	if err != nil {
-		return "", err
-	}
-	return library.Pull(ctx, imgCache, r, runtime.GOARCH, tmpDir, c)
-}
-
-func handleShub(ctx context.Context, imgCache *cache.Handle, pullFrom string) (string, error) {
-	return shub.Pull(ctx, imgCache, pullFrom, tmpDir, noHTTPS)
-}
-
-func handleNet(ctx context.Context, imgCache *cache.Handle, pullFrom string) (string, error) {
-	return net.Pull(ctx, imgCache, pullFrom, tmpDir)
-}
-
 func replaceURIWithImage(ctx context.Context, cmd *cobra.Command, args []string) {
 	// If args[0] is not transport:ref (ex. instance://...) formatted return, not a URI
 	t, _ := uri.Split(args[0])
@@ -109,8 +82,9 @@ func replaceURIWithImage(ctx context.Context, cmd *cobra.Command, args []string)
 		return
 	}
 
-	var image string
-	var err error
+	if pullQuarantine() {
+		sylog.Fatalf("Pulled images are held in quarantine, pull %s and release it before running it", args[0])
+	}
 
 	// Create a cache handle only when we know we are are using a URI
 	imgCache := getCacheHandle(cache.Config{Disable: disableCache})
@@ -118,23 +92,7 @@ func replaceURIWithImage(ctx context.Context, cmd *cobra.Command, args []string)
 		sylog.Fatalf("failed to create a new image cache handle")
 	}
 
-	switch t {
-	case uri.Library:
-		image, err = handleLibrary(ctx, imgCache, args[0])
-	case uri.Oras:
-		image, err = handleOras(ctx, imgCache, cmd, args[0])
-	case uri.Shub:
-		image, err = handleShub(ctx, imgCache, args[0])
-	case oci.IsSupported(t):
-		image, err = handleOCI(ctx, imgCache, cmd, args[0])
-	case uri.HTTP:
-		image, err = handleNet(ctx, imgCache, args[0])
-	case uri.HTTPS:
-		image, err = handleNet(ctx, imgCache, args[0])
-	default:
-		sylog.Fatalf("Unsupported transport type: %s", t)
-	}
-
+	image, err := pullToCache(ctx, imgCache, cmd, args[0])
 	if err != nil {
 		sylog.Fatalf("Unable to handle %s uri: %v", args[0], err)
 	}
@@ -142,6 +100,12 @@ func replaceURIWithImage(ctx context.Context, cmd *cobra.Command, args []string)
 	args[0] = image
 }
 
+// pullToCache pulls the image ref into the cache, converting it to SIF if
+// required, and returns the path of the image.
+func pullToCache(ctx context.Context, imgCache *cache.Handle, cmd *cobra.Command, ref string) (string, error) {
+	return transportPullToCache(ctx, imgCache, cmd, ref)
+}
+
 // setVM will set the --vm option if needed by other options
 func setVM(cmd *cobra.Command) {
 	// check if --vm-ram or --vm-cpu changed from default value
@@ -161,6 +125,39 @@ func setVM(cmd *cobra.Command) {
 	}
 }
 
+// compatFlags are the flags set by --compat to approximate the docker run
+// behavior, the entrypoint compatibility mode only applies to run.
+var compatFlags = []struct {
+	name  string
+	value string
+}{
+	{"containall", "true"},
+	{"writable-tmpfs", "true"},
+	{"no-home", "true"},
+	{"no-mount", "cwd"},
+	{"no-init", "true"},
+	{"no-umask", "true"},
+	{"compat-entrypoint", compatEntrypointDocker},
+}
+
+// setCompat sets the flags of the docker compatibility profile, flags
+// explicitly set by the user take precedence, except list flags.
+func setCompat(cmd *cobra.Command) error {
+	if !Compat {
+		return nil
+	}
+	for _, f := range compatFlags {
+		flag := cmd.Flag(f.name)
+		if flag == nil || (flag.Changed && !strings.HasSuffix(flag.Value.Type(), "Slice")) {
+			continue
+		}
+		if err := cmd.Flags().Set(f.name, f.value); err != nil {
+			return fmt.Errorf("while setting --%s=%s: %s", f.name, f.value, err)
+		}
+	}
+	return nil
+}
+
 // ExecCmd represents the exec command
 var ExecCmd = &cobra.Command{
 	DisableFlagsInUseLine: true,
@@ -169,6 +166,7 @@ var ExecCmd = &cobra.Command{
 	PreRun:                actionPreRun,
 	Run: func(cmd *cobra.Command, args []string) {
 		a := append([]string{"/.singularity.d/actions/exec"}, args[1:]...)
+		a = append(a, readArgsFile()...)
 		setVM(cmd)
 		if VM {
 			execVM(cmd, args[0], a)
@@ -212,7 +210,7 @@ var RunCmd = &cobra.Command{
 	Args:                  cobra.MinimumNArgs(1),
 	PreRun:                actionPreRun,
 	Run: func(cmd *cobra.Command, args []string) {
-		a := append([]string{"/.singularity.d/actions/run"}, args[1:]...)
+		a := runActionArgs(args[0], args[1:])
 		setVM(cmd)
 		if VM {
 			execVM(cmd, args[0], a)
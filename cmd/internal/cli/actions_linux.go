@@ -6,6 +6,7 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -16,7 +17,10 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/hpcng/singularity/internal/app/singularity"
 	"github.com/hpcng/singularity/internal/pkg/buildcfg"
+	"github.com/hpcng/singularity/internal/pkg/cgroups"
+	"github.com/hpcng/singularity/internal/pkg/history"
 	"github.com/hpcng/singularity/internal/pkg/instance"
 	"github.com/hpcng/singularity/internal/pkg/plugin"
 	"github.com/hpcng/singularity/internal/pkg/runtime/engine/config/oci"
@@ -24,9 +28,11 @@ import (
 	"github.com/hpcng/singularity/internal/pkg/security"
 	"github.com/hpcng/singularity/internal/pkg/util/env"
 	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	fsoverlay "github.com/hpcng/singularity/internal/pkg/util/fs/overlay"
 	"github.com/hpcng/singularity/internal/pkg/util/shell/interpreter"
 	"github.com/hpcng/singularity/internal/pkg/util/starter"
 	"github.com/hpcng/singularity/internal/pkg/util/user"
+	"github.com/hpcng/singularity/internal/pkg/volume"
 	imgutil "github.com/hpcng/singularity/pkg/image"
 	"github.com/hpcng/singularity/pkg/image/unpacker"
 	clicallback "github.com/hpcng/singularity/pkg/plugin/callback/cli"
@@ -41,7 +47,9 @@ import (
 	"github.com/hpcng/singularity/pkg/util/namespaces"
 	"github.com/hpcng/singularity/pkg/util/rlimit"
 	"github.com/hpcng/singularity/pkg/util/singularityconf"
+	"github.com/hpcng/singularity/pkg/util/slice"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"golang.org/x/sys/unix"
 )
 
@@ -92,8 +100,15 @@ func convertImage(filename string, unsquashfsPath string) (tempDir, imageDir str
 		}
 	}
 
+	// extracted root filesystem is estimated to be a few times
+	// larger than the squashfs partition
+	parent, err := newTmpDirManager(tmpdir).Select(int64(part.Size) * squashfsRatio)
+	if err != nil {
+		return "", "", fmt.Errorf("could not select temporary directory: %s", err)
+	}
+
 	// create temporary sandbox
-	tempDir, err = ioutil.TempDir(tmpdir, "rootfs-")
+	tempDir, err = ioutil.TempDir(parent, "rootfs-")
 	if err != nil {
 		return "", "", fmt.Errorf("could not create temporary sandbox: %s", err)
 	}
@@ -117,6 +132,23 @@ func convertImage(filename string, unsquashfsPath string) (tempDir, imageDir str
 	return tempDir, imageDir, err
 }
 
+// bindEnv returns the host environment variables referenced by the
+// ${NAME} variables of the bind paths, they are expanded by the runtime.
+func bindEnv(paths ...[]string) map[string]string {
+	env := make(map[string]string)
+
+	for _, list := range paths {
+		for _, path := range list {
+			for _, name := range singularityConfig.BindVarNames(path) {
+				if value, ok := os.LookupEnv(name); ok {
+					env[name] = value
+				}
+			}
+		}
+	}
+	return env
+}
+
 // checkHidepid checks if hidepid is set on /proc mount point, when this
 // option is an instance started with setuid workflow could not even be
 // joined later or stopped correctly.
@@ -165,10 +197,180 @@ func setNoMountFlags(c *singularityConfig.EngineConfig) {
 	}
 }
 
+// setTimezoneLocale sets the container time zone requested with --tz
+// and injects the TZ and locale variables in the container environment,
+// variables set with --env take precedence.
+func setTimezoneLocale(c *singularityConfig.EngineConfig) {
+	switch Timezone {
+	case "":
+	case "host":
+		c.SetTimezone(Timezone)
+		if tz, ok := os.LookupEnv("TZ"); ok {
+			os.Setenv("SINGULARITYENV_TZ", tz)
+		}
+	default:
+		if _, err := time.LoadLocation(Timezone); err != nil {
+			sylog.Fatalf("Invalid time zone %s: %s", Timezone, err)
+		}
+		c.SetTimezone(Timezone)
+		os.Setenv("SINGULARITYENV_TZ", Timezone)
+	}
+
+	isLocaleVar := func(name string) bool {
+		return name == "LANG" || name == "LANGUAGE" || strings.HasPrefix(name, "LC_")
+	}
+
+	switch Locale {
+	case "":
+	case "host":
+		// passed explicitly so they are also set with --cleanenv
+		// and take precedence over the container environment
+		for _, e := range os.Environ() {
+			kv := strings.SplitN(e, "=", 2)
+			if len(kv) == 2 && isLocaleVar(kv[0]) {
+				os.Setenv("SINGULARITYENV_"+kv[0], kv[1])
+			}
+		}
+	default:
+		if strings.ContainsAny(Locale, "= \t\n") {
+			sylog.Fatalf("Invalid locale %q", Locale)
+		}
+		// host LC_* variables would override LANG
+		for _, e := range os.Environ() {
+			name := strings.SplitN(e, "=", 2)[0]
+			if isLocaleVar(name) {
+				os.Unsetenv(name)
+			}
+		}
+		os.Setenv("SINGULARITYENV_LANG", Locale)
+	}
+}
+
+// sandboxedRuntimeFlags are the action flags honoured by execSandboxedRuntime.
+var sandboxedRuntimeFlags = []string{"runtime", "bind", "env", "pwd", "hostname", "writable", "writable-tmpfs"}
+
+// wasmFlags are the action flags honoured by execWasm.
+var wasmFlags = []string{"runtime", "bind", "env", "cleanenv", "contain", "containall"}
+
+// unsupportedFlags returns the names of the flags set for the command
+// which are not part of supported, the global flags are ignored.
+func unsupportedFlags(cmd *cobra.Command, supported []string) []string {
+	var names []string
+
+	inherited := cmd.InheritedFlags()
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if inherited.Lookup(f.Name) != nil || slice.ContainsString(supported, f.Name) {
+			return
+		}
+		names = append(names, "--"+f.Name)
+	})
+	return names
+}
+
+// execSandboxedRuntime runs the image with the sandboxed OCI runtime
+// requested with --runtime instead of the singularity engine.
+func execSandboxedRuntime(cmd *cobra.Command, image string, args []string, name string) {
+	if name != "" || strings.HasPrefix(image, "instance://") {
+		sylog.Fatalf("--runtime option is not supported with instances")
+	}
+	if flags := unsupportedFlags(cmd, sandboxedRuntimeFlags); len(flags) > 0 {
+		sylog.Fatalf("%s not supported with --runtime %s", strings.Join(flags, ", "), SandboxRuntime)
+	}
+
+	abspath, err := filepath.Abs(image)
+	if err != nil {
+		sylog.Fatalf("Failed to determine image absolute path for %s: %s", image, err)
+	}
+
+	binds, err := singularityConfig.ParseBindPath(BindPaths)
+	if err != nil {
+		sylog.Fatalf("while parsing bind path: %s", err)
+	}
+
+	code, err := singularity.SandboxedRun(singularity.SandboxedRunOptions{
+		Runtime:  SandboxRuntime,
+		Image:    abspath,
+		Args:     args,
+		Env:      SingularityEnv,
+		Binds:    binds,
+		Cwd:      PwdPath,
+		Hostname: Hostname,
+		Writable: IsWritable || IsWritableTmpfs,
+	})
+	if err != nil {
+		sylog.Fatalf("%s", err)
+	}
+	os.Exit(code)
+}
+
+// execWasm runs the WebAssembly module packaged in the image with
+// the embedded WASI runtime instead of the singularity engine.
+func execWasm(cmd *cobra.Command, image string, args []string, name string) {
+	if name != "" || strings.HasPrefix(image, "instance://") {
+		sylog.Fatalf("WebAssembly modules can't be run as instances")
+	}
+	if flags := unsupportedFlags(cmd, wasmFlags); len(flags) > 0 {
+		sylog.Fatalf("%s not supported for WebAssembly modules", strings.Join(flags, ", "))
+	}
+
+	abspath, err := filepath.Abs(image)
+	if err != nil {
+		sylog.Fatalf("Failed to determine image absolute path for %s: %s", image, err)
+	}
+
+	binds, err := singularityConfig.ParseBindPath(BindPaths)
+	if err != nil {
+		sylog.Fatalf("while parsing bind path: %s", err)
+	}
+
+	code, err := singularity.WasmRun(context.Background(), singularity.WasmRunOptions{
+		Image:    abspath,
+		Action:   filepath.Base(args[0]),
+		Args:     args[1:],
+		Env:      SingularityEnv,
+		Binds:    binds,
+		CleanEnv: IsCleanEnv,
+		Contain:  IsContained || IsContainAll,
+	})
+	if err != nil {
+		sylog.Fatalf("%s", err)
+	}
+	os.Exit(code)
+}
+
 // TODO: Let's stick this in another file so that that CLI is just CLI
+// warnOverlayDirs warns about the writable overlay directories located
+// on filesystems which can't hold an overlay upper directory, like the
+// network and parallel filesystems.
+func warnOverlayDirs(overlays []string) {
+	for _, o := range overlays {
+		splitted := strings.SplitN(o, ":", 2)
+		if len(splitted) == 2 && splitted[1] == "ro" || !fs.IsDir(splitted[0]) {
+			continue
+		}
+		if err := fsoverlay.CheckUpper(splitted[0]); fsoverlay.IsIncompatible(err) {
+			sylog.Warningf("%s, use an EXT3 overlay image created with 'singularity overlay create' instead", err)
+		}
+	}
+}
+
 func execStarter(cobraCmd *cobra.Command, image string, args []string, name string) {
 	var err error
 
+	dryRun := DryRun || DryRunJSON
+
+	if SandboxRuntime == singularity.WasmRuntime || SandboxRuntime == "" && singularity.HasWasmModule(image) {
+		if dryRun {
+			sylog.Fatalf("--dry-run is not supported with WebAssembly images")
+		}
+		execWasm(cobraCmd, image, args, name)
+	} else if SandboxRuntime != "" {
+		if dryRun {
+			sylog.Fatalf("--dry-run is not supported with --runtime")
+		}
+		execSandboxedRuntime(cobraCmd, image, args, name)
+	}
+
 	targetUID := 0
 	targetGID := make([]int, 0)
 
@@ -197,6 +399,14 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 	imageArg := os.Getenv("IMAGE_ARG")
 	os.Unsetenv("IMAGE_ARG")
 	engineConfig.SetImageArg(imageArg)
+
+	// only the flag names are reported in the accounting records, values
+	// could hold sensitive data like passphrases or environment variables
+	var cliFlags []string
+	cobraCmd.Flags().Visit(func(f *pflag.Flag) {
+		cliFlags = append(cliFlags, "--"+f.Name)
+	})
+	engineConfig.SetCLIFlags(cliFlags)
 	engineConfig.File = singularityconf.GetCurrentConfig()
 	if engineConfig.File == nil {
 		sylog.Fatalf("Unable to get singularity configuration")
@@ -254,10 +464,13 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 			sylog.Fatalf("Starting an instance from another is not allowed")
 		}
 		instanceName := instance.ExtractName(image)
-		file, err := instance.Get(instanceName, instance.SingSubDir)
+		file, err := instance.Find(instanceName, instance.SingSubDir)
 		if err != nil {
 			sylog.Fatalf("%s", err)
 		}
+		if !file.Authorized() {
+			sylog.Fatalf("Not authorized to join system instance %s", instanceName)
+		}
 		UserNamespace = file.UserNs
 		generator.AddProcessEnv("SINGULARITY_CONTAINER", file.Image)
 		generator.AddProcessEnv("SINGULARITY_NAME", filepath.Base(file.Image))
@@ -373,6 +586,8 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		}
 	}
 
+	injectHostLibs(engineConfig, userPath)
+
 	// early check for key material before we start engine so we can fail fast if missing
 	// we do not need this check when joining a running instance, just for starting a container
 	if !engineConfig.GetInstanceJoin() {
@@ -387,8 +602,8 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 			sylog.Fatalf("while getting root filesystem in %s: %s", engineConfig.GetImage(), err)
 		}
 
-		// ensure we have decryption material
-		if part.Type == imgutil.ENCRYPTSQUASHFS {
+		// ensure we have decryption material, a dry run doesn't decrypt
+		if part.Type == imgutil.ENCRYPTSQUASHFS && !dryRun {
 			sylog.Debugf("Encrypted container filesystem detected")
 
 			keyInfo, err := getEncryptionMaterial(cobraCmd)
@@ -415,7 +630,21 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 	if err != nil {
 		sylog.Fatalf("while parsing bind path: %s", err)
 	}
+	for _, spec := range Mounts {
+		m, err := volume.ParseMount(volume.Dir(), spec)
+		if err != nil {
+			sylog.Fatalf("while parsing mount: %s", err)
+		}
+		// striping hints are preferences, the mount is done anyway
+		if m.Stripe != nil {
+			if err := m.Stripe.Apply(m.Bind.Source); err != nil {
+				sylog.Warningf("Ignoring striping hints: %s", err)
+			}
+		}
+		binds = append(binds, m.Bind)
+	}
 	engineConfig.SetBindPath(binds)
+	engineConfig.SetBindEnv(bindEnv(BindPaths, engineConfig.File.BindPath))
 	generator.AddProcessEnv("SINGULARITY_BIND", strings.Join(BindPaths, ","))
 
 	if len(FuseMount) > 0 {
@@ -428,9 +657,41 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 	engineConfig.SetNetwork(Network)
 	engineConfig.SetDNS(DNS)
 	engineConfig.SetNetworkArgs(NetworkArgs)
+	warnOverlayDirs(OverlayPath)
 	engineConfig.SetOverlayImage(OverlayPath)
 	engineConfig.SetWritableImage(IsWritable)
 	engineConfig.SetNoHome(NoHome)
+	engineConfig.SetSecurityAudit(SecurityAudit)
+	if PidFile != "" {
+		path, err := filepath.Abs(PidFile)
+		if err != nil {
+			sylog.Fatalf("while determining absolute path of %s: %s", PidFile, err)
+		}
+		engineConfig.SetPidFile(path)
+	}
+	if InfoFile != "" {
+		path, err := filepath.Abs(InfoFile)
+		if err != nil {
+			sylog.Fatalf("while determining absolute path of %s: %s", InfoFile, err)
+		}
+		engineConfig.SetInfoFile(path)
+	}
+	engineConfig.SetRusage(Rusage)
+	if History {
+		dir := history.Dir()
+		if err := fs.MkdirAll(dir, 0700); err != nil {
+			sylog.Fatalf("While creating history directory: %s", err)
+		}
+		engineConfig.SetHistoryDir(dir)
+	}
+	if RusageFile != "" {
+		path, err := filepath.Abs(RusageFile)
+		if err != nil {
+			sylog.Fatalf("while determining absolute path of %s: %s", RusageFile, err)
+		}
+		engineConfig.SetRusageFile(path)
+	}
+	engineConfig.SetUlimits(Ulimits)
 	setNoMountFlags(engineConfig)
 	engineConfig.SetNv(Nvidia)
 	engineConfig.SetRocm(Rocm)
@@ -456,9 +717,17 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		generator.AddProcessEnv("SINGULARITY_SHELL", ShellPath)
 	}
 
-	checkPrivileges(CgroupsPath != "", "--apply-cgroups", func() {
-		engineConfig.SetCgroupsPath(CgroupsPath)
-	})
+	if CgroupsPath != "" {
+		// unprivileged users require cgroups v2 delegation
+		if !isPrivileged && !cgroups.IsUnified() {
+			sylog.Fatalf("--apply-cgroups requires root privileges or cgroups v2 unified hierarchy")
+		}
+		path, err := filepath.Abs(CgroupsPath)
+		if err != nil {
+			sylog.Fatalf("while determining absolute path of %s: %s", CgroupsPath, err)
+		}
+		engineConfig.SetCgroupsPath(path)
+	}
 
 	if IsWritable && IsWritableTmpfs {
 		sylog.Warningf("Disabling --writable-tmpfs flag, mutually exclusive with --writable")
@@ -519,6 +788,17 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 	engineConfig.SetScratchDir(ScratchPath)
 	engineConfig.SetWorkdir(WorkdirPath)
 
+	if homeFlag.Changed {
+		defaultHome := CurrentUser.HomeDir
+		if IsFakeroot {
+			defaultHome = "/root"
+		}
+		HomePath, err = homeBackend(engineConfig, HomePath, defaultHome)
+		if err != nil {
+			sylog.Fatalf("While setting home directory: %s", err)
+		}
+	}
+
 	homeSlice := strings.Split(HomePath, ":")
 
 	if len(homeSlice) > 2 || len(homeSlice) == 0 {
@@ -547,8 +827,21 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 			sylog.Fatalf("hidepid option set on /proc mount, require 'hidepid=0' to start instance with setuid workflow")
 		}
 
-		_, err := instance.Get(name, instance.SingSubDir)
-		if err == nil {
+		if instanceStartSystem {
+			if !isPrivileged {
+				sylog.Fatalf("Only root user can start system instances")
+			} else if UserNamespace || IsFakeroot {
+				sylog.Fatalf("System instances can't be started with user namespace")
+			}
+			if _, err := instance.GetSystem(name, instance.SingSubDir); err == nil {
+				sylog.Fatalf("system instance %s already exists", name)
+			}
+			engineConfig.SetSystemInstance(true)
+			engineConfig.SetAllowUsers(instanceStartAllowUsers)
+			engineConfig.SetAllowGroups(instanceStartAllowGroups)
+		} else if len(instanceStartAllowUsers) > 0 || len(instanceStartAllowGroups) > 0 {
+			sylog.Fatalf("--allow-users and --allow-groups require --system")
+		} else if _, err := instance.Get(name, instance.SingSubDir); err == nil {
 			sylog.Fatalf("instance %s already exists", name)
 		}
 
@@ -593,6 +886,23 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		}
 		generator.AddOrReplaceLinuxNamespace("network", "")
 	}
+
+	var web *singularity.WebService
+	if PublishWeb != 0 {
+		if NetNamespace && engineConfig.GetNetwork() == "none" {
+			sylog.Fatalf("--publish-web can't forward a port to the container with the network 'none'")
+		}
+		web, err = singularity.PublishWeb(PublishWeb, NetNamespace)
+		if err != nil {
+			sylog.Fatalf("While publishing web service: %s", err)
+		}
+		if NetNamespace {
+			engineConfig.SetNetworkArgs(append(engineConfig.GetNetworkArgs(), web.NetworkArg()))
+		} else if web.ContainerPort != PublishWeb {
+			sylog.Warningf("Port %d is in use on the host, the web service must listen on port %d set in SINGULARITY_WEB_PORT", PublishWeb, web.ContainerPort)
+		}
+	}
+
 	if UtsNamespace {
 		generator.AddOrReplaceLinuxNamespace("uts", "")
 	}
@@ -634,6 +944,8 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		SingularityEnv = append(env, SingularityEnv...)
 	}
 
+	setTimezoneLocale(engineConfig)
+
 	// process --env and --env-file variables for injection
 	// into the environment by prefixing them with SINGULARITYENV_
 	for _, env := range SingularityEnv {
@@ -674,10 +986,16 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 	}
 
 	generator.AddProcessEnv("SINGULARITY_APPNAME", AppName)
+	if web != nil {
+		for k, v := range web.Env() {
+			generator.AddProcessEnv(k, v)
+		}
+	}
 
 	// convert image file to sandbox if we are using user
 	// namespace or if we are currently running inside a
 	// user namespace
+	extractImage := false
 	if (UserNamespace || insideUserNs) && fs.IsFile(image) {
 		convert := true
 
@@ -702,7 +1020,8 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 			}
 		}
 
-		if convert {
+		extractImage = convert
+		if convert && !dryRun {
 			unsquashfsPath := ""
 			if engineConfig.File.MksquashfsPath != "" {
 				d := filepath.Dir(engineConfig.File.MksquashfsPath)
@@ -755,8 +1074,22 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		c.(clicallback.SingularityEngineConfig)(cfg)
 	}
 
+	if dryRun {
+		printDryRun(procname, cfg, useSuid, loadOverlay, extractImage)
+		return
+	}
+
+	if web != nil {
+		fmt.Fprintf(os.Stderr, "Web service available at %s\n", web.URL())
+	}
+
 	if engineConfig.GetInstance() {
-		stdout, stderr, err := instance.SetLogFile(name, int(uid), instance.LogSubDir)
+		var stdout, stderr *os.File
+		if engineConfig.GetSystemInstance() {
+			stdout, stderr, err = instance.SetSystemLogFile(name, instance.LogSubDir)
+		} else {
+			stdout, stderr, err = instance.SetLogFile(name, int(uid), instance.LogSubDir)
+		}
 		if err != nil {
 			sylog.Fatalf("failed to create instance log files: %s", err)
 		}
@@ -0,0 +1,58 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestUnsupportedFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected []string
+	}{
+		{
+			name: "Supported",
+			args: []string{"--runtime", "runsc", "--bind", "/data", "--debug"},
+		},
+		{
+			name:     "Unsupported",
+			args:     []string{"--runtime", "runsc", "--nv", "--env-file", "env", "--bind", "/data"},
+			expected: []string{"--env-file", "--nv"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := &cobra.Command{Use: "root"}
+			root.PersistentFlags().Bool("debug", false, "")
+
+			cmd := &cobra.Command{
+				Use: "run",
+				Run: func(*cobra.Command, []string) {},
+			}
+			cmd.Flags().String("runtime", "", "")
+			cmd.Flags().StringSlice("bind", nil, "")
+			cmd.Flags().Bool("nv", false, "")
+			cmd.Flags().String("env-file", "", "")
+			root.AddCommand(cmd)
+
+			root.SetArgs(append([]string{"run"}, tt.args...))
+			if err := root.Execute(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			got := unsupportedFlags(cmd, sandboxedRuntimeFlags)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("got %v instead of %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestSetCompat(t *testing.T) {
+	newCmd := func() *cobra.Command {
+		cmd := &cobra.Command{Use: "run"}
+		cmd.Flags().Bool("containall", false, "")
+		cmd.Flags().Bool("writable-tmpfs", false, "")
+		cmd.Flags().Bool("no-home", false, "")
+		cmd.Flags().StringSlice("no-mount", nil, "")
+		cmd.Flags().Bool("no-init", false, "")
+		cmd.Flags().Bool("no-umask", false, "")
+		cmd.Flags().String("compat-entrypoint", "", "")
+		return cmd
+	}
+
+	defer func() { Compat = false }()
+
+	// nothing is set without --compat
+	Compat = false
+	cmd := newCmd()
+	if err := setCompat(cmd); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cmd.Flag("containall").Changed {
+		t.Errorf("flags set without --compat")
+	}
+
+	Compat = true
+	cmd = newCmd()
+	if err := cmd.ParseFlags([]string{"--writable-tmpfs=false", "--no-mount", "tmp"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := setCompat(cmd); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for name, want := range map[string]string{
+		"containall":        "true",
+		"writable-tmpfs":    "false",
+		"no-home":           "true",
+		"no-init":           "true",
+		"no-umask":          "true",
+		"compat-entrypoint": compatEntrypointDocker,
+	} {
+		if got := cmd.Flag(name).Value.String(); got != want {
+			t.Errorf("got --%s=%s instead of %s", name, got, want)
+		}
+	}
+	noMount, err := cmd.Flags().GetStringSlice("no-mount")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := []string{"tmp", "cwd"}; !reflect.DeepEqual(noMount, want) {
+		t.Errorf("got --no-mount %v instead of %v", noMount, want)
+	}
+
+	// flags missing for the command are ignored
+	if err := setCompat(&cobra.Command{Use: "exec"}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	// failures to set a flag are reported
+	cmd = &cobra.Command{Use: "run"}
+	cmd.Flags().Int("no-init", 0, "")
+	if err := setCompat(cmd); err == nil {
+		t.Errorf("unexpected success with invalid flag value")
+	}
+}
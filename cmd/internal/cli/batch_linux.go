@@ -0,0 +1,251 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/pkg/batch"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterCmd(BatchCmd)
+
+		cmdManager.RegisterFlagForCmd(&batchSchedulerFlag, BatchCmd)
+		cmdManager.RegisterFlagForCmd(&batchJobNameFlag, BatchCmd)
+		cmdManager.RegisterFlagForCmd(&batchNodesFlag, BatchCmd)
+		cmdManager.RegisterFlagForCmd(&batchTasksFlag, BatchCmd)
+		cmdManager.RegisterFlagForCmd(&batchCPUsPerTaskFlag, BatchCmd)
+		cmdManager.RegisterFlagForCmd(&batchGPUsFlag, BatchCmd)
+		cmdManager.RegisterFlagForCmd(&batchMemoryFlag, BatchCmd)
+		cmdManager.RegisterFlagForCmd(&batchTimeFlag, BatchCmd)
+		cmdManager.RegisterFlagForCmd(&batchQueueFlag, BatchCmd)
+		cmdManager.RegisterFlagForCmd(&batchAccountFlag, BatchCmd)
+		cmdManager.RegisterFlagForCmd(&batchMPIFlag, BatchCmd)
+		cmdManager.RegisterFlagForCmd(&batchBindFlag, BatchCmd)
+		cmdManager.RegisterFlagForCmd(&batchEnvFlag, BatchCmd)
+		cmdManager.RegisterFlagForCmd(&batchNvidiaFlag, BatchCmd)
+		cmdManager.RegisterFlagForCmd(&batchRocmFlag, BatchCmd)
+		cmdManager.RegisterFlagForCmd(&batchOutputFlag, BatchCmd)
+	})
+}
+
+var (
+	batchJob    batch.Job
+	batchOutput string
+)
+
+// --scheduler
+var batchSchedulerFlag = cmdline.Flag{
+	ID:           "batchSchedulerFlag",
+	Value:        &batchJob.Scheduler,
+	DefaultValue: batch.Slurm,
+	Name:         "scheduler",
+	Usage:        "scheduler of the job script: slurm, pbs or lsf",
+	Tag:          "<scheduler>",
+	EnvKeys:      []string{"BATCH_SCHEDULER"},
+}
+
+// --job-name
+var batchJobNameFlag = cmdline.Flag{
+	ID:           "batchJobNameFlag",
+	Value:        &batchJob.Name,
+	DefaultValue: "",
+	Name:         "job-name",
+	Usage:        "job name, the container name by default",
+	Tag:          "<name>",
+}
+
+// --nodes
+var batchNodesFlag = cmdline.Flag{
+	ID:           "batchNodesFlag",
+	Value:        &batchJob.Nodes,
+	DefaultValue: 1,
+	Name:         "nodes",
+	Usage:        "number of nodes",
+	Tag:          "<number>",
+}
+
+// --ntasks
+var batchTasksFlag = cmdline.Flag{
+	ID:           "batchTasksFlag",
+	Value:        &batchJob.Tasks,
+	DefaultValue: 1,
+	Name:         "ntasks",
+	Usage:        "total number of MPI tasks, spread evenly over the nodes (requires --mpi above 1)",
+	Tag:          "<number>",
+}
+
+// --cpus-per-task
+var batchCPUsPerTaskFlag = cmdline.Flag{
+	ID:           "batchCPUsPerTaskFlag",
+	Value:        &batchJob.CPUsPerTask,
+	DefaultValue: 1,
+	Name:         "cpus-per-task",
+	Usage:        "number of CPUs of each task",
+	Tag:          "<number>",
+}
+
+// --gpus
+var batchGPUsFlag = cmdline.Flag{
+	ID:           "batchGPUsFlag",
+	Value:        &batchJob.GPUs,
+	DefaultValue: 0,
+	Name:         "gpus",
+	Usage:        "number of GPUs of each node, implies --nv unless --rocm is set",
+	Tag:          "<number>",
+}
+
+// --mem
+var batchMemoryFlag = cmdline.Flag{
+	ID:           "batchMemoryFlag",
+	Value:        &batchJob.Memory,
+	DefaultValue: "",
+	Name:         "mem",
+	Usage:        "memory of each node in MiB, or with a K, M, G or T suffix (e.g. 16G)",
+	Tag:          "<size>",
+}
+
+// --time
+var batchTimeFlag = cmdline.Flag{
+	ID:           "batchTimeFlag",
+	Value:        &batchJob.Time,
+	DefaultValue: "",
+	Name:         "time",
+	Usage:        "wall clock time limit",
+	Tag:          "<HH:MM:SS>",
+}
+
+// --queue
+var batchQueueFlag = cmdline.Flag{
+	ID:           "batchQueueFlag",
+	Value:        &batchJob.Queue,
+	DefaultValue: "",
+	Name:         "queue",
+	Usage:        "partition or queue of the job",
+	Tag:          "<name>",
+}
+
+// --account
+var batchAccountFlag = cmdline.Flag{
+	ID:           "batchAccountFlag",
+	Value:        &batchJob.Account,
+	DefaultValue: "",
+	Name:         "account",
+	Usage:        "account or project charged for the job",
+	Tag:          "<name>",
+}
+
+// --mpi
+var batchMPIFlag = cmdline.Flag{
+	ID:           "batchMPIFlag",
+	Value:        &batchJob.MPI,
+	DefaultValue: false,
+	Name:         "mpi",
+	Usage:        "launch the tasks with srun (slurm) or the host mpirun (pbs, lsf), the container MPI must be compatible with the host one",
+}
+
+// -B|--bind
+var batchBindFlag = cmdline.Flag{
+	ID:           "batchBindFlag",
+	Value:        &batchJob.Binds,
+	DefaultValue: cmdline.StringArray{},
+	Name:         "bind",
+	ShortHand:    "B",
+	Usage:        "a user-bind path specification of the job container, see singularity exec --help",
+	Tag:          "<spec>",
+}
+
+// --env
+var batchEnvFlag = cmdline.Flag{
+	ID:           "batchEnvFlag",
+	Value:        &batchJob.Env,
+	DefaultValue: cmdline.StringArray{},
+	Name:         "env",
+	Usage:        "pass environment variable to the job container",
+	Tag:          "<name=value>",
+}
+
+// --nv
+var batchNvidiaFlag = cmdline.Flag{
+	ID:           "batchNvidiaFlag",
+	Value:        &batchJob.Nvidia,
+	DefaultValue: false,
+	Name:         "nv",
+	Usage:        "enable Nvidia GPU support in the job container",
+}
+
+// --rocm
+var batchRocmFlag = cmdline.Flag{
+	ID:           "batchRocmFlag",
+	Value:        &batchJob.Rocm,
+	DefaultValue: false,
+	Name:         "rocm",
+	Usage:        "enable AMD GPU support in the job container",
+}
+
+// -o|--output
+var batchOutputFlag = cmdline.Flag{
+	ID:           "batchOutputFlag",
+	Value:        &batchOutput,
+	DefaultValue: "",
+	Name:         "output",
+	ShortHand:    "o",
+	Usage:        "write the job script to <path> instead of the standard output",
+	Tag:          "<path>",
+}
+
+var batchNameRegexp = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// BatchCmd is the 'batch' command that allows to generate scheduler job scripts running containers.
+var BatchCmd = &cobra.Command{
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		batchJob.Image = args[0]
+		batchJob.Command = args[1:]
+		if batchJob.Name == "" {
+			name := filepath.Base(batchJob.Image)
+			name = strings.TrimSuffix(name, filepath.Ext(name))
+			batchJob.Name = strings.Trim(batchNameRegexp.ReplaceAllString(name, "-"), "-.")
+			if batchJob.Name == "" {
+				batchJob.Name = "singularity"
+			}
+		}
+		if self, err := os.Executable(); err == nil {
+			batchJob.Singularity = self
+		}
+		batchJob.Scheduler = strings.ToLower(batchJob.Scheduler)
+
+		script, err := batchJob.Script()
+		if err != nil {
+			sylog.Fatalf("%s", err)
+		}
+		if batchOutput == "" {
+			fmt.Print(script)
+			return
+		}
+		if err := ioutil.WriteFile(batchOutput, []byte(script), 0755); err != nil {
+			sylog.Fatalf("While writing job script: %s", err)
+		}
+		sylog.Infof("Job script written to %s", batchOutput)
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:     docs.BatchUse,
+	Short:   docs.BatchShort,
+	Long:    docs.BatchLong,
+	Example: docs.BatchExample,
+}
@@ -10,10 +10,14 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
 
 	ocitypes "github.com/containers/image/v5/types"
 	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/pkg/build/sources"
+	"github.com/hpcng/singularity/internal/pkg/cache"
 	"github.com/hpcng/singularity/internal/pkg/remote/endpoint"
 	"github.com/hpcng/singularity/internal/pkg/util/fs"
 	"github.com/hpcng/singularity/internal/pkg/util/interactive"
@@ -33,19 +37,37 @@ var buildArgs struct {
 	bindPaths    []string
 	arch         string
 	builderURL   string
+	cpus         string
+	disk         string
+	memory       string
+	deltaFrom    string
+	dockerConfig string
 	libraryURL   string
 	keyServerURL string
+	squashfsMem  string
+	packer       string
 	webURL       string
+	permsReport  string
+	sandboxUmask string
+	whiteoutMode string
+	registries   string
+	squashfsProc uint32
+	buildLog     bool
+	debugShell   bool
 	detached     bool
 	encrypt      bool
 	fakeroot     bool
 	fixPerms     bool
+	fixWritable  bool
 	isJSON       bool
 	noCleanUp    bool
 	noTest       bool
 	remote       bool
+	resume       bool
 	sandbox      bool
+	stripSetuid  bool
 	update       bool
+	verity       bool
 	nvidia       bool
 	rocm         bool
 }
@@ -103,6 +125,55 @@ var buildNoTestFlag = cmdline.Flag{
 	EnvKeys:      []string{"NOTEST"},
 }
 
+// --build-log
+var buildBuildLogFlag = cmdline.Flag{
+	ID:           "buildBuildLogFlag",
+	Value:        &buildArgs.buildLog,
+	DefaultValue: false,
+	Name:         "build-log",
+	Usage:        "store the timestamped output of the build sections in the image, shown with 'inspect --build-log'",
+	EnvKeys:      []string{"BUILD_LOG"},
+}
+
+// --debug-shell
+var buildDebugShellFlag = cmdline.Flag{
+	ID:           "buildDebugShellFlag",
+	Value:        &buildArgs.debugShell,
+	DefaultValue: false,
+	Name:         "debug-shell",
+	Usage:        "start an interactive shell in the build sandbox when the %post or %test section fails",
+}
+
+// --resume
+var buildResumeFlag = cmdline.Flag{
+	ID:           "buildResumeFlag",
+	Value:        &buildArgs.resume,
+	DefaultValue: false,
+	Name:         "resume",
+	Usage:        "checkpoint the build after each section and resume a failed build of the same definition from the last completed section",
+	EnvKeys:      []string{"RESUME"},
+}
+
+// --docker-config
+var buildDockerConfigFlag = cmdline.Flag{
+	ID:           "buildDockerConfigFlag",
+	Value:        &buildArgs.dockerConfig,
+	DefaultValue: "",
+	Name:         "docker-config",
+	Usage:        "path to the docker config.json holding the registry credentials of the bootstrap images (default ~/.docker/config.json of the invoking user)",
+	EnvKeys:      []string{"BUILD_DOCKER_CONFIG"},
+}
+
+// --registries-conf
+var buildRegistriesConfFlag = cmdline.Flag{
+	ID:           "buildRegistriesConfFlag",
+	Value:        &buildArgs.registries,
+	DefaultValue: "",
+	Name:         "registries-conf",
+	Usage:        "path to a registries.conf adding registry mirrors for the bootstrap images to the system configuration (default ~/.config/containers/registries.conf of the invoking user if present)",
+	EnvKeys:      []string{"BUILD_REGISTRIES_CONF"},
+}
+
 // -r|--remote
 var buildRemoteFlag = cmdline.Flag{
 	ID:           "buildRemoteFlag",
@@ -120,7 +191,7 @@ var buildArchFlag = cmdline.Flag{
 	Value:        &buildArgs.arch,
 	DefaultValue: runtime.GOARCH,
 	Name:         "arch",
-	Usage:        "architecture for remote build",
+	Usage:        "architecture to build for, local builds of a foreign architecture require binfmt_misc and qemu-user-static",
 	EnvKeys:      []string{"BUILD_ARCH"},
 }
 
@@ -175,6 +246,46 @@ var buildNoCleanupFlag = cmdline.Flag{
 	EnvKeys:      []string{"NO_CLEANUP"},
 }
 
+// --delta-from
+var buildDeltaFromFlag = cmdline.Flag{
+	ID:           "buildDeltaFromFlag",
+	Value:        &buildArgs.deltaFrom,
+	DefaultValue: "",
+	Name:         "delta-from",
+	Usage:        "reuse the root filesystem of the given SIF image and only store changes in an overlay partition (requires overlay support at runtime)",
+	EnvKeys:      []string{"DELTA_FROM"},
+}
+
+// --mksquashfs-procs
+var buildMksquashfsProcsFlag = cmdline.Flag{
+	ID:           "buildMksquashfsProcsFlag",
+	Value:        &buildArgs.squashfsProc,
+	DefaultValue: uint32(0),
+	Name:         "mksquashfs-procs",
+	Usage:        "number of processors used to create the squashfs image (overrides singularity.conf, 0 keeps the configured value)",
+	EnvKeys:      []string{"MKSQUASHFS_PROCS"},
+}
+
+// --mksquashfs-mem
+var buildMksquashfsMemFlag = cmdline.Flag{
+	ID:           "buildMksquashfsMemFlag",
+	Value:        &buildArgs.squashfsMem,
+	DefaultValue: "",
+	Name:         "mksquashfs-mem",
+	Usage:        "maximum amount of memory used by mksquashfs to create the squashfs image, e.g. 1G (overrides singularity.conf)",
+	EnvKeys:      []string{"MKSQUASHFS_MEM"},
+}
+
+// --squashfs-packer
+var buildSquashfsPackerFlag = cmdline.Flag{
+	ID:           "buildSquashfsPackerFlag",
+	Value:        &buildArgs.packer,
+	DefaultValue: "",
+	Name:         "squashfs-packer",
+	Usage:        "tool used to create the squashfs image: mksquashfs, tar2sqfs, builtin or an absolute path to mksquashfs or tar2sqfs (overrides singularity.conf)",
+	EnvKeys:      []string{"SQUASHFS_PACKER"},
+}
+
 // --fakeroot
 var buildFakerootFlag = cmdline.Flag{
 	ID:           "buildFakerootFlag",
@@ -207,6 +318,95 @@ var buildFixPermsFlag = cmdline.Flag{
 	EnvKeys:      []string{"FIXPERMS"},
 }
 
+// --sandbox-umask
+var buildSandboxUmaskFlag = cmdline.Flag{
+	ID:           "buildSandboxUmaskFlag",
+	Value:        &buildArgs.sandboxUmask,
+	DefaultValue: "",
+	Name:         "sandbox-umask",
+	Usage:        "remove the permissions of the octal umask from all sandbox content, like 022",
+	EnvKeys:      []string{"SANDBOX_UMASK"},
+}
+
+// --strip-setuid
+var buildStripSetuidFlag = cmdline.Flag{
+	ID:           "buildStripSetuidFlag",
+	Value:        &buildArgs.stripSetuid,
+	DefaultValue: false,
+	Name:         "strip-setuid",
+	Usage:        "remove the setuid and setgid bits of sandbox files",
+	EnvKeys:      []string{"STRIP_SETUID"},
+}
+
+// --fix-world-writable
+var buildFixWorldWritableFlag = cmdline.Flag{
+	ID:           "buildFixWorldWritableFlag",
+	Value:        &buildArgs.fixWritable,
+	DefaultValue: false,
+	Name:         "fix-world-writable",
+	Usage:        "remove the world writable bit of sandbox content, except sticky directories like /tmp",
+	EnvKeys:      []string{"FIX_WORLD_WRITABLE"},
+}
+
+// --perms-report
+var buildPermsReportFlag = cmdline.Flag{
+	ID:           "buildPermsReportFlag",
+	Value:        &buildArgs.permsReport,
+	DefaultValue: "",
+	Name:         "perms-report",
+	Usage:        "write a JSON report of the sandbox permissions modified to the given file",
+}
+
+// --whiteout-mode
+var buildWhiteoutModeFlag = cmdline.Flag{
+	ID:           "buildWhiteoutModeFlag",
+	Value:        &buildArgs.whiteoutMode,
+	DefaultValue: sources.WhiteoutApply,
+	Name:         "whiteout-mode",
+	Usage:        "how whiteouts of oci/docker layers are extracted: apply, overlayfs (requires root) or wh",
+	EnvKeys:      []string{"WHITEOUT_MODE"},
+}
+
+// --cpus
+var buildCPUsFlag = cmdline.Flag{
+	ID:           "buildCPUsFlag",
+	Value:        &buildArgs.cpus,
+	DefaultValue: "",
+	Name:         "cpus",
+	Usage:        "limit the %post and %test sections to this number of CPUs, e.g. 2 or 0.5",
+	EnvKeys:      []string{"BUILD_CPUS"},
+}
+
+// --memory
+var buildMemoryFlag = cmdline.Flag{
+	ID:           "buildMemoryFlag",
+	Value:        &buildArgs.memory,
+	DefaultValue: "",
+	Name:         "memory",
+	Usage:        "limit the memory of the %post and %test sections, e.g. 4G",
+	EnvKeys:      []string{"BUILD_MEMORY"},
+}
+
+// --disk
+var buildDiskFlag = cmdline.Flag{
+	ID:           "buildDiskFlag",
+	Value:        &buildArgs.disk,
+	DefaultValue: "",
+	Name:         "disk",
+	Usage:        "fail the build when the build sandbox exceeds this size, e.g. 20G",
+	EnvKeys:      []string{"BUILD_DISK"},
+}
+
+// --verity
+var buildVerityFlag = cmdline.Flag{
+	ID:           "buildVerityFlag",
+	Value:        &buildArgs.verity,
+	DefaultValue: false,
+	Name:         "verity",
+	Usage:        "add a dm-verity hash tree to the SIF image to detect root filesystem tampering at runtime",
+	EnvKeys:      []string{"VERITY"},
+}
+
 // --nv
 var buildNvFlag = cmdline.Flag{
 	ID:           "nvFlag",
@@ -251,19 +451,37 @@ func init() {
 
 		cmdManager.RegisterFlagForCmd(&buildArchFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildBuilderFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildBuildLogFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildCPUsFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildDebugShellFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildDeltaFromFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildDetachedFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildDiskFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildDisableCacheFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildDockerConfigFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildEncryptFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildFakerootFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildFixPermsFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildJSONFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildLibraryFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildMemoryFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildMksquashfsMemFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildMksquashfsProcsFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildNoCleanupFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildNoTestFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildRegistriesConfFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildRemoteFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildResumeFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildSandboxFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildSandboxUmaskFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildStripSetuidFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildFixWorldWritableFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildPermsReportFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildSquashfsPackerFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildSectionFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildUpdateFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildVerityFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildWhiteoutModeFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&commonForceFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&commonNoHTTPSFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&commonTmpDirFlag, buildCmd)
@@ -371,6 +589,70 @@ func checkBuildTarget(path string) error {
 	return nil
 }
 
+// sandboxPerms returns the normalization of the sandbox permissions set by
+// the command line.
+func sandboxPerms() (types.SandboxPerms, error) {
+	p := types.SandboxPerms{
+		StripSetuid:      buildArgs.stripSetuid,
+		FixWorldWritable: buildArgs.fixWritable,
+	}
+
+	if buildArgs.sandboxUmask != "" {
+		umask, err := strconv.ParseUint(buildArgs.sandboxUmask, 8, 32)
+		if err != nil || umask > 0777 {
+			return p, fmt.Errorf("invalid sandbox umask %s: must be an octal permission like 022", buildArgs.sandboxUmask)
+		}
+		if umask&0700 != 0 {
+			return p, fmt.Errorf("invalid sandbox umask %s: owner permissions can't be removed", buildArgs.sandboxUmask)
+		}
+		p.Umask = os.FileMode(umask)
+	}
+
+	if buildArgs.permsReport != "" {
+		if !p.Enabled() {
+			return p, fmt.Errorf("--perms-report requires --sandbox-umask, --strip-setuid or --fix-world-writable")
+		}
+		report, err := filepath.Abs(buildArgs.permsReport)
+		if err != nil {
+			return p, fmt.Errorf("while getting absolute path of %s: %s", buildArgs.permsReport, err)
+		}
+		p.Report = report
+	}
+
+	if p.Enabled() && !buildArgs.sandbox {
+		return p, fmt.Errorf("sandbox permissions options require the --sandbox flag")
+	}
+	return p, nil
+}
+
+// buildLimits returns the resource limits of the build set by the command line.
+func buildLimits() (types.Limits, error) {
+	var l types.Limits
+
+	if buildArgs.cpus != "" {
+		cpus, err := strconv.ParseFloat(buildArgs.cpus, 64)
+		if err != nil || cpus <= 0 {
+			return l, fmt.Errorf("invalid number of CPUs %s", buildArgs.cpus)
+		}
+		l.CPUs = cpus
+	}
+	if buildArgs.memory != "" {
+		memory, err := cache.ParseSize(buildArgs.memory)
+		if err != nil || memory <= 0 {
+			return l, fmt.Errorf("invalid memory limit %s", buildArgs.memory)
+		}
+		l.Memory = memory
+	}
+	if buildArgs.disk != "" {
+		disk, err := cache.ParseSize(buildArgs.disk)
+		if err != nil || disk <= 0 {
+			return l, fmt.Errorf("invalid disk limit %s", buildArgs.disk)
+		}
+		l.Disk = disk
+	}
+	return l, nil
+}
+
 // definitionFromSpec is specifically for parsing specs for the remote builder
 // it uses a different version the the definition struct and parser
 func definitionFromSpec(spec string) (types.Definition, error) {
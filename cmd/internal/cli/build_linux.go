@@ -12,19 +12,23 @@ import (
 	"io/ioutil"
 	"os"
 	osExec "os/exec"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
 
+	"github.com/hpcng/singularity/internal/app/singularity"
 	"github.com/hpcng/singularity/internal/pkg/build"
 	"github.com/hpcng/singularity/internal/pkg/build/remotebuilder"
+	"github.com/hpcng/singularity/internal/pkg/build/sources"
 	"github.com/hpcng/singularity/internal/pkg/buildcfg"
 	"github.com/hpcng/singularity/internal/pkg/cache"
 	"github.com/hpcng/singularity/internal/pkg/remote/endpoint"
 	fakerootConfig "github.com/hpcng/singularity/internal/pkg/runtime/engine/fakeroot/config"
 	"github.com/hpcng/singularity/internal/pkg/util/fs"
 	"github.com/hpcng/singularity/internal/pkg/util/interactive"
+	"github.com/hpcng/singularity/internal/pkg/util/machine"
 	"github.com/hpcng/singularity/internal/pkg/util/starter"
 	"github.com/hpcng/singularity/internal/pkg/util/user"
 	"github.com/hpcng/singularity/pkg/build/types"
@@ -33,6 +37,7 @@ import (
 	"github.com/hpcng/singularity/pkg/sylog"
 	"github.com/hpcng/singularity/pkg/util/crypt"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 func fakerootExec(cmdArgs []string) {
@@ -116,8 +121,20 @@ func runBuild(cmd *cobra.Command, args []string) {
 		os.Setenv("SINGULARITY_BINDPATH", strings.Join(buildArgs.bindPaths, ","))
 	}
 
+	if buildArgs.debugShell {
+		if buildArgs.remote {
+			sylog.Fatalf("--debug-shell option is not supported for remote build")
+		}
+		if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+			sylog.Fatalf("--debug-shell option requires an interactive terminal")
+		}
+	}
+
 	if buildArgs.arch != runtime.GOARCH && !buildArgs.remote {
-		sylog.Fatalf("Requested architecture (%s) does not match host (%s). Cannot build locally.", buildArgs.arch, runtime.GOARCH)
+		if err := machine.EnsureEmulation(buildArgs.arch); err != nil {
+			sylog.Fatalf("Requested architecture (%s) does not match host (%s) and cannot be emulated: %s", buildArgs.arch, runtime.GOARCH, err)
+		}
+		sylog.Infof("Building %s image using binfmt_misc emulation", buildArgs.arch)
 	}
 
 	dest := args[0]
@@ -128,6 +145,15 @@ func runBuild(cmd *cobra.Command, args []string) {
 		sylog.Fatalf("While checking build target: %s", err)
 	}
 
+	// images built from remote images are held in quarantine
+	if pullQuarantine() && buildPullsImage(spec) {
+		if buildArgs.sandbox {
+			sylog.Fatalf("Pulled images are held in quarantine, pull %s and release it before building a sandbox from it", spec)
+		}
+		quarantineBuild(ctx, cmd, dest, spec)
+		return
+	}
+
 	if buildArgs.remote {
 		runBuildRemote(ctx, cmd, dest, spec)
 	} else {
@@ -136,7 +162,75 @@ func runBuild(cmd *cobra.Command, args []string) {
 	sylog.Infof("Build complete: %s", dest)
 }
 
+// buildPullsImage returns whether building from spec pulls an image
+// from a registry, a library or a remote builder.
+func buildPullsImage(spec string) bool {
+	if buildArgs.remote {
+		return true
+	}
+	defs, err := build.MakeAllDefs(spec)
+	if err != nil {
+		// reported by the build
+		return false
+	}
+	for _, d := range defs {
+		switch d.Header["bootstrap"] {
+		case "docker", "library", "oras", "shub":
+			return true
+		}
+	}
+	return false
+}
+
+// quarantineBuild builds the image from spec in the quarantine directory,
+// it is moved to dest once released with the release command.
+func quarantineBuild(ctx context.Context, cmd *cobra.Command, dest, spec string) {
+	dir := quarantineDir()
+	tmp, err := singularity.QuarantineTempFile(dir)
+	if err != nil {
+		sylog.Fatalf("%s", err)
+	}
+	// the name is reserved in the private quarantine directory, the
+	// build creates the file
+	os.Remove(tmp)
+	defer os.Remove(tmp)
+
+	if buildArgs.remote {
+		runBuildRemote(ctx, cmd, tmp, spec)
+	} else {
+		runBuildLocal(ctx, cmd, tmp, spec)
+	}
+
+	e, err := singularity.QuarantineImage(dir, tmp, spec, dest)
+	if err != nil {
+		sylog.Fatalf("While quarantining image: %s", err)
+	}
+	sylog.Infof("Build complete, image %s held in quarantine, release it with 'singularity release %s'", e.ID, e.ID)
+}
+
 func runBuildRemote(ctx context.Context, cmd *cobra.Command, dst, spec string) {
+	if buildArgs.deltaFrom != "" {
+		sylog.Fatalf("--delta-from option is not supported for remote build")
+	}
+	if buildArgs.verity {
+		sylog.Fatalf("--verity option is not supported for remote build")
+	}
+	if buildArgs.buildLog {
+		sylog.Fatalf("--build-log option is not supported for remote build")
+	}
+	if buildArgs.resume {
+		sylog.Fatalf("--resume option is not supported for remote build")
+	}
+	if buildArgs.cpus != "" || buildArgs.memory != "" || buildArgs.disk != "" {
+		sylog.Fatalf("--cpus, --memory and --disk options are not supported for remote build")
+	}
+	if buildArgs.dockerConfig != "" || buildArgs.registries != "" {
+		sylog.Fatalf("--docker-config and --registries-conf options are not supported for remote build")
+	}
+	if buildArgs.packer != "" || buildArgs.squashfsProc != 0 || buildArgs.squashfsMem != "" {
+		sylog.Warningf("squashfs packer options are ignored for remote build")
+	}
+
 	// building encrypted containers on the remote builder is not currently supported
 	if buildArgs.encrypt {
 		sylog.Fatalf("Building encrypted container with the remote builder is not currently supported.")
@@ -177,6 +271,11 @@ func runBuildRemote(ctx context.Context, cmd *cobra.Command, dst, spec string) {
 			sylog.Fatalf("Library URI detected as destination, sandbox builds are incompatible with library destinations.")
 		}
 
+		perms, err := sandboxPerms()
+		if err != nil {
+			sylog.Fatalf("%s", err)
+		}
+
 		// create temporary file to download sif
 		f, err := ioutil.TempFile(tmpDir, "remote-build-")
 		if err != nil {
@@ -211,11 +310,12 @@ func runBuildRemote(ctx context.Context, cmd *cobra.Command, dst, spec string) {
 					Format:    "sandbox",
 					NoCleanUp: buildArgs.noCleanUp,
 					Opts: types.Options{
-						ImgCache: imgCache,
-						NoCache:  disableCache,
-						TmpDir:   tmpDir,
-						Update:   buildArgs.update,
-						Force:    forceOverwrite,
+						ImgCache:     imgCache,
+						NoCache:      disableCache,
+						TmpDir:       tmpDir,
+						Update:       buildArgs.update,
+						Force:        forceOverwrite,
+						SandboxPerms: perms,
 					},
 				})
 			if err != nil {
@@ -276,6 +376,10 @@ func runBuildLocal(ctx context.Context, cmd *cobra.Command, dst, spec string) {
 	if err != nil {
 		sylog.Fatalf("While creating Docker credentials: %v", err)
 	}
+	dockerConfig, registriesConf, err := registryConfigs()
+	if err != nil {
+		sylog.Fatalf("%s", err)
+	}
 
 	// parse definition to determine build source
 	defs, err := build.MakeAllDefs(spec)
@@ -317,39 +421,162 @@ func runBuildLocal(ctx context.Context, cmd *cobra.Command, dst, spec string) {
 
 	}
 
+	perms, err := sandboxPerms()
+	if err != nil {
+		sylog.Fatalf("%s", err)
+	}
+	limits, err := buildLimits()
+	if err != nil {
+		sylog.Fatalf("%s", err)
+	}
+
+	// whiteouts can't be created as overlayfs whiteouts in a user namespace
+	if err := sources.CheckWhiteoutMode(buildArgs.whiteoutMode, os.Geteuid() != 0 || buildArgs.fakeroot); err != nil {
+		sylog.Fatalf("%s", err)
+	}
+
+	if buildArgs.deltaFrom != "" {
+		if sandboxTarget {
+			sylog.Fatalf("--delta-from option requires a SIF image as build target")
+		}
+		if keyInfo != nil {
+			sylog.Fatalf("--delta-from option is not supported with encrypted images")
+		}
+	}
+	if buildArgs.verity {
+		if sandboxTarget {
+			sylog.Fatalf("--verity option requires a SIF image as build target")
+		}
+		if keyInfo != nil {
+			sylog.Fatalf("--verity option is not supported with encrypted images")
+		}
+	}
+
+	explicitTmpDir := ""
+	if cmd.Flags().Lookup("tmpdir").Changed {
+		explicitTmpDir = tmpDir
+	}
+	tmpManager := newTmpDirManager(explicitTmpDir)
+	buildTmpDir, err := tmpManager.TempDir(estimateBuildSize(defs))
+	if err != nil {
+		sylog.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	cleanup := func() {
+		if buildArgs.noCleanUp {
+			sylog.Infof("Build temporary directory kept in %s", buildTmpDir)
+			return
+		}
+		tmpManager.Cleanup()
+	}
+
 	b, err := build.New(
 		defs,
 		build.Config{
-			Dest:      dst,
-			Format:    buildFormat,
-			NoCleanUp: buildArgs.noCleanUp,
+			Dest:            dst,
+			Format:          buildFormat,
+			NoCleanUp:       buildArgs.noCleanUp,
+			DeltaFrom:       buildArgs.deltaFrom,
+			Verity:          buildArgs.verity,
+			BuildLog:        buildArgs.buildLog,
+			Resume:          buildArgs.resume,
+			SquashfsPacker:  buildArgs.packer,
+			MksquashfsProcs: uint(buildArgs.squashfsProc),
+			MksquashfsMem:   buildArgs.squashfsMem,
 			Opts: types.Options{
 				ImgCache:          imgCache,
-				TmpDir:            tmpDir,
+				TmpDir:            buildTmpDir,
 				NoCache:           disableCache,
 				Update:            buildArgs.update,
 				Force:             forceOverwrite,
 				Sections:          buildArgs.sections,
 				NoTest:            buildArgs.noTest,
+				DebugShell:        buildArgs.debugShell,
 				NoHTTPS:           noHTTPS,
 				LibraryURL:        buildArgs.libraryURL,
 				LibraryAuthToken:  authToken,
 				KeyServerOpts:     co,
 				DockerAuthConfig:  authConf,
+				DockerConfig:      dockerConfig,
+				RegistriesConf:    registriesConf,
 				EncryptionKeyInfo: keyInfo,
 				FixPerms:          buildArgs.fixPerms,
 				SandboxTarget:     sandboxTarget,
+				Arch:              buildArgs.arch,
+				SandboxPerms:      perms,
+				Limits:            limits,
+				WhiteoutMode:      buildArgs.whiteoutMode,
 			},
 		})
 	if err != nil {
+		cleanup()
 		sylog.Fatalf("Unable to create build: %v", err)
 	}
 
-	if err = b.Full(ctx); err != nil {
+	err = b.Full(ctx)
+	cleanup()
+	if err != nil {
 		sylog.Fatalf("While performing build: %v", err)
 	}
 }
 
+// invokingUserHome returns the home directory of the user invoking the build,
+// the user running sudo or the original user of a fakeroot build.
+func invokingUserHome() (string, error) {
+	if name := os.Getenv("SUDO_USER"); name != "" && os.Getuid() == 0 {
+		u, err := user.GetPwNam(name)
+		if err != nil {
+			return "", fmt.Errorf("while looking up sudo user %s: %s", name, err)
+		}
+		return u.Dir, nil
+	}
+	u, err := user.CurrentOriginal()
+	if err != nil {
+		return "", fmt.Errorf("while looking up current user: %s", err)
+	}
+	return u.Dir, nil
+}
+
+// registryConfigs returns the docker configuration and the registries
+// configuration used to pull the bootstrap images. Unless set on the command
+// line they are looked up in the home directory of the invoking user, as the
+// build may run as root through sudo.
+func registryConfigs() (dockerConfig, registriesConf string, err error) {
+	dockerConfig = buildArgs.dockerConfig
+	registriesConf = buildArgs.registries
+	if dockerConfig != "" && !fs.IsFile(dockerConfig) {
+		return "", "", fmt.Errorf("docker configuration %s doesn't exist", dockerConfig)
+	}
+	if registriesConf != "" && !fs.IsFile(registriesConf) {
+		return "", "", fmt.Errorf("registries configuration %s doesn't exist", registriesConf)
+	}
+	if dockerConfig != "" && registriesConf != "" {
+		return dockerConfig, registriesConf, nil
+	}
+
+	home, err := invokingUserHome()
+	if err != nil {
+		return "", "", err
+	}
+	if dockerConfig == "" {
+		if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+			dockerConfig = filepath.Join(dir, "config.json")
+		} else {
+			dockerConfig = filepath.Join(home, ".docker", "config.json")
+		}
+		if !fs.IsFile(dockerConfig) {
+			dockerConfig = ""
+		}
+	}
+	if registriesConf == "" {
+		registriesConf = filepath.Join(home, ".config", "containers", "registries.conf")
+		if !fs.IsFile(registriesConf) {
+			registriesConf = ""
+		}
+	}
+	sylog.Debugf("Docker configuration: %q, registries configuration: %q", dockerConfig, registriesConf)
+	return dockerConfig, registriesConf, nil
+}
+
 func checkSections() error {
 	var all, none bool
 	for _, section := range buildArgs.sections {
@@ -65,6 +65,8 @@ func printParam(name string) error {
 		{"MANDIR", buildcfg.MANDIR},
 		{"SINGULARITY_CONFDIR", buildcfg.SINGULARITY_CONFDIR},
 		{"SESSIONDIR", buildcfg.SESSIONDIR},
+		{"QUOTADIR", buildcfg.QUOTADIR},
+		{"QUARANTINEDIR", buildcfg.QUARANTINEDIR},
 		{"PLUGIN_ROOTDIR", buildcfg.PLUGIN_ROOTDIR},
 		{"SINGULARITY_CONF_FILE", buildcfg.SINGULARITY_CONF_FILE},
 		{"SINGULARITY_SUID_INSTALL", fmt.Sprintf("%d", buildcfg.SINGULARITY_SUID_INSTALL)},
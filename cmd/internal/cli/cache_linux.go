@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2019, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -18,6 +18,7 @@ func init() {
 		cmdManager.RegisterCmd(CacheCmd)
 		cmdManager.RegisterSubCmd(CacheCmd, cacheCleanCmd)
 		cmdManager.RegisterSubCmd(CacheCmd, CacheListCmd)
+		cmdManager.RegisterSubCmd(CacheCmd, CacheStatsCmd)
 	})
 }
 
@@ -0,0 +1,55 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"os"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/app/singularity"
+	"github.com/hpcng/singularity/internal/pkg/cache"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+var cacheStatsJSON bool
+
+// --json
+var cacheStatsJSONFlag = cmdline.Flag{
+	ID:           "cacheStatsJSON",
+	Value:        &cacheStatsJSON,
+	DefaultValue: false,
+	Name:         "json",
+	ShortHand:    "j",
+	Usage:        "print the cache statistics in JSON format",
+}
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterFlagForCmd(&cacheStatsJSONFlag, CacheStatsCmd)
+	})
+}
+
+// CacheStatsCmd is 'singularity cache stats' and will show the usage of your local singularity cache
+var CacheStatsCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		imgCache := getCacheHandle(cache.Config{})
+		if imgCache == nil {
+			sylog.Fatalf("failed to create image cache handle")
+		}
+		if err := singularity.CacheStats(os.Stdout, imgCache, cacheStatsJSON); err != nil {
+			sylog.Fatalf("An error occurred while reading cache statistics: %v", err)
+		}
+	},
+
+	Use:     docs.CacheStatsUse,
+	Short:   docs.CacheStatsShort,
+	Long:    docs.CacheStatsLong,
+	Example: docs.CacheStatsExample,
+}
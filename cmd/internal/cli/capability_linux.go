@@ -18,8 +18,9 @@ import (
 
 // CapConfig contains flag variables for capability commands
 type CapConfig struct {
-	CapUser  string
-	CapGroup string
+	CapUser    string
+	CapGroup   string
+	CapProfile string
 }
 
 var capConfig = new(CapConfig)
@@ -46,6 +47,17 @@ var capGroupFlag = cmdline.Flag{
 	EnvKeys:      []string{"CAP_GROUP"},
 }
 
+// -p|--profile
+var capProfileFlag = cmdline.Flag{
+	ID:           "capProfileFlag",
+	Value:        &capConfig.CapProfile,
+	DefaultValue: "",
+	Name:         "profile",
+	ShortHand:    "p",
+	Usage:        "manage capabilities for a named profile requested with --security capabilities:<profile>",
+	EnvKeys:      []string{"CAP_PROFILE"},
+}
+
 // CapabilityAvailCmd singularity capability avail
 var CapabilityAvailCmd = &cobra.Command{
 	Args:                  cobra.RangeArgs(0, 1),
@@ -76,9 +88,10 @@ var CapabilityAddCmd = &cobra.Command{
 	DisableFlagsInUseLine: true,
 	Run: func(cmd *cobra.Command, args []string) {
 		c := singularity.CapManageConfig{
-			Caps:  args[0],
-			User:  capConfig.CapUser,
-			Group: capConfig.CapGroup,
+			Caps:    args[0],
+			User:    capConfig.CapUser,
+			Group:   capConfig.CapGroup,
+			Profile: capConfig.CapProfile,
 		}
 
 		if err := singularity.CapabilityAdd(buildcfg.CAPABILITY_FILE, c); err != nil {
@@ -98,9 +111,10 @@ var CapabilityDropCmd = &cobra.Command{
 	DisableFlagsInUseLine: true,
 	Run: func(cmd *cobra.Command, args []string) {
 		c := singularity.CapManageConfig{
-			Caps:  args[0],
-			User:  capConfig.CapUser,
-			Group: capConfig.CapGroup,
+			Caps:    args[0],
+			User:    capConfig.CapUser,
+			Group:   capConfig.CapGroup,
+			Profile: capConfig.CapProfile,
 		}
 
 		if err := singularity.CapabilityDrop(buildcfg.CAPABILITY_FILE, c); err != nil {
@@ -124,9 +138,10 @@ var CapabilityListCmd = &cobra.Command{
 			userGroup = args[0]
 		}
 		c := singularity.CapListConfig{
-			User:  userGroup,
-			Group: userGroup,
-			All:   len(args) == 0,
+			User:    userGroup,
+			Group:   userGroup,
+			Profile: userGroup,
+			All:     len(args) == 0,
 		}
 
 		if err := singularity.CapabilityList(buildcfg.CAPABILITY_FILE, c); err != nil {
@@ -166,5 +181,6 @@ func init() {
 
 		cmdManager.RegisterFlagForCmd(&capUserFlag, CapabilityAddCmd, CapabilityDropCmd)
 		cmdManager.RegisterFlagForCmd(&capGroupFlag, CapabilityAddCmd, CapabilityDropCmd)
+		cmdManager.RegisterFlagForCmd(&capProfileFlag, CapabilityAddCmd, CapabilityDropCmd)
 	})
 }
@@ -0,0 +1,89 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"fmt"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/app/singularity"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterCmd(commitCmd)
+
+		cmdManager.RegisterFlagForCmd(&commitOverlayFlag, commitCmd)
+		cmdManager.RegisterFlagForCmd(&commitDeltaFlag, commitCmd)
+		cmdManager.RegisterFlagForCmd(&commitSignFlag, commitCmd)
+		cmdManager.RegisterFlagForCmd(&signKeyIdxFlag, commitCmd)
+	})
+}
+
+// --overlay
+var commitOverlay string
+var commitOverlayFlag = cmdline.Flag{
+	ID:           "commitOverlayFlag",
+	Value:        &commitOverlay,
+	DefaultValue: "",
+	Name:         "overlay",
+	Usage:        "overlay directory holding the changes made to the sandbox, stored in an overlay partition",
+	Tag:          "<dir>",
+}
+
+// --delta
+var commitDelta bool
+var commitDeltaFlag = cmdline.Flag{
+	ID:           "commitDeltaFlag",
+	Value:        &commitDelta,
+	DefaultValue: false,
+	Name:         "delta",
+	Usage:        "store the changes of a running instance in an overlay partition added to a copy of its SIF image",
+}
+
+// --sign
+var commitSign bool
+var commitSignFlag = cmdline.Flag{
+	ID:           "commitSignFlag",
+	Value:        &commitSign,
+	DefaultValue: false,
+	Name:         "sign",
+	Usage:        "sign the new image, with the private key selected with --keyidx or interactively",
+}
+
+// singularity commit
+var commitCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(2),
+	DisableFlagsInUseLine: true,
+	ValidArgsFunction:     completeInstanceNames,
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := singularity.CommitOptions{
+			Overlay: commitOverlay,
+			Delta:   commitDelta,
+		}
+		if commitSign {
+			opts.SignEntity = signEntity(cmd)
+		} else if cmd.Flag(signKeyIdxFlag.Name).Changed {
+			sylog.Fatalf("--keyidx requires --sign")
+		}
+
+		if err := singularity.CommitContainer(args[0], args[1], opts); err != nil {
+			sylog.Fatalf("While committing %s: %s", args[0], err)
+		}
+		if commitSign {
+			fmt.Printf("Signature created and applied to %s\n", args[1])
+		}
+		sylog.Infof("Image committed to %s", args[1])
+	},
+
+	Use:     docs.CommitUse,
+	Short:   docs.CommitShort,
+	Long:    docs.CommitLong,
+	Example: docs.CommitExample,
+}
@@ -0,0 +1,256 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/cache"
+	"github.com/hpcng/singularity/internal/pkg/remote"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/spf13/cobra"
+	"github.com/sylabs/scs-library-client/client"
+)
+
+// completionTimeout is the maximum time spent querying a library
+// server for dynamic completions.
+const completionTimeout = 5 * time.Second
+
+// completionSchemes are the image URI schemes proposed when completing
+// an image argument.
+var completionSchemes = []string{
+	"docker://",
+	"docker-archive:",
+	"docker-daemon:",
+	"instance://",
+	"library://",
+	"oci:",
+	"oci-archive:",
+	"oras://",
+	"shub://",
+}
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		for _, cmd := range []*cobra.Command{
+			ExecCmd,
+			ShellCmd,
+			RunCmd,
+			TestCmd,
+			InspectCmd,
+		} {
+			cmd.ValidArgsFunction = completeImageArg(0)
+		}
+		PullCmd.ValidArgsFunction = completeImageArg(-1)
+		PushCmd.ValidArgsFunction = completePushArgs
+
+		for _, cmd := range []*cobra.Command{
+			RemoteRemoveCmd,
+			RemoteUseCmd,
+			RemoteLoginCmd,
+			RemoteLogoutCmd,
+			RemoteStatusCmd,
+		} {
+			cmd.ValidArgsFunction = completeRemoteNames
+		}
+	})
+}
+
+// filterPrefix returns the candidates starting with prefix.
+func filterPrefix(candidates []string, prefix string) []string {
+	var matches []string
+
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) {
+			matches = append(matches, c)
+		}
+	}
+	return matches
+}
+
+// completeImageArg returns a completion function completing the image
+// argument at position pos, a negative position means that any argument
+// can be an image.
+func completeImageArg(pos int) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if pos >= 0 && len(args) != pos {
+			return nil, cobra.ShellCompDirectiveDefault
+		}
+		return completeImage(toComplete)
+	}
+}
+
+// completePushArgs completes the local image and the library destination
+// of the push command.
+func completePushArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	switch len(args) {
+	case 0:
+		return nil, cobra.ShellCompDirectiveDefault
+	case 1:
+		return completeImage(toComplete)
+	}
+	return nil, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeImage completes an image reference: instance names for
+// instance:// URIs, containers and tags for library:// URIs, cached
+// images for paths within the image cache and local files otherwise.
+func completeImage(toComplete string) ([]string, cobra.ShellCompDirective) {
+	switch {
+	case strings.HasPrefix(toComplete, "instance://"):
+		return filterPrefix(instanceURIs(), toComplete), cobra.ShellCompDirectiveNoFileComp
+	case strings.HasPrefix(toComplete, "library://"):
+		return libraryRefs(toComplete), cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+	case strings.Contains(toComplete, ":"):
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	if images := cachedImages(toComplete); len(images) > 0 {
+		return images, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	// propose URI schemes only once the input can't be a local file
+	if toComplete != "" {
+		if schemes := filterPrefix(completionSchemes, toComplete); len(schemes) > 0 {
+			matches, _ := filepath.Glob(toComplete + "*")
+			if len(matches) == 0 {
+				return schemes, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+			}
+		}
+	}
+	return nil, cobra.ShellCompDirectiveDefault
+}
+
+// cachedImages returns the images stored in the image cache whose path
+// starts with prefix, nothing is returned if prefix doesn't point within
+// the cache directory.
+func cachedImages(prefix string) []string {
+	if !strings.Contains(prefix, cache.SubDirName) {
+		return nil
+	}
+
+	h, err := cache.New(cache.Config{})
+	if err != nil || h.IsDisabled() {
+		return nil
+	}
+
+	var images []string
+
+	for _, t := range cache.FileCacheTypes {
+		dir, err := h.GetFileCacheDir(t)
+		if err != nil {
+			continue
+		}
+		if !strings.HasPrefix(prefix, filepath.Dir(dir)+"/") {
+			return nil
+		}
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			if !f.Mode().IsRegular() {
+				continue
+			}
+			images = append(images, filepath.Join(dir, f.Name()))
+		}
+	}
+	return filterPrefix(images, prefix)
+}
+
+// completeRemoteNames completes the remote endpoint names defined in the
+// user and the system remote configurations.
+func completeRemoteNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	seen := make(map[string]bool)
+	for _, path := range []string{remoteConfig, remote.SystemConfigPath} {
+		c, err := loadRemoteConf(path)
+		if err != nil {
+			continue
+		}
+		for name := range c.Remotes {
+			seen[name] = true
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return filterPrefix(names, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// libraryRefs queries the library of the current remote endpoint and
+// returns the container references matching ref, or the container tags
+// once ref contains a tag separator.
+func libraryRefs(ref string) []string {
+	path := strings.TrimPrefix(ref, "library://")
+	tagIndex := strings.LastIndex(path, ":")
+	if tagIndex >= 0 {
+		path = path[:tagIndex]
+	}
+
+	name := path[strings.LastIndex(path, "/")+1:]
+	// the library requires at least 3 characters to search
+	if len(name) < 3 {
+		return nil
+	}
+
+	config, err := getLibraryClientConfig("")
+	if err != nil {
+		return nil
+	}
+	c, err := client.NewClient(config)
+	if err != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+	defer cancel()
+
+	res, err := c.Search(ctx, map[string]string{"value": name})
+	if err != nil {
+		return nil
+	}
+
+	var refs []string
+
+	for _, ct := range res.Containers {
+		containerRefs := []string{ct.EntityName + "/" + ct.CollectionName + "/" + ct.Name}
+		if ct.EntityName == "library" && ct.CollectionName == "default" {
+			containerRefs = append(containerRefs, ct.Name)
+		}
+		for _, containerRef := range containerRefs {
+			if tagIndex < 0 {
+				refs = append(refs, "library://"+containerRef)
+				continue
+			} else if containerRef != path {
+				continue
+			}
+			tags := ct.ArchTags[runtime.GOARCH]
+			if tags == nil {
+				tags = ct.ImageTags
+			}
+			for tag := range tags {
+				refs = append(refs, "library://"+containerRef+":"+tag)
+			}
+		}
+	}
+	sort.Strings(refs)
+
+	return filterPrefix(refs, ref)
+}
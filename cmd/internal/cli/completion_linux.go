@@ -0,0 +1,57 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"github.com/hpcng/singularity/internal/pkg/instance"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		instanceStartCmd.ValidArgsFunction = completeImageArg(0)
+		instanceStopCmd.ValidArgsFunction = completeInstanceNames
+		instanceListCmd.ValidArgsFunction = completeInstanceNames
+	})
+}
+
+// instanceNames returns the names of the running user and system
+// instances the current user is allowed to join.
+func instanceNames() []string {
+	var names []string
+
+	if files, err := instance.List("", "*", instance.SingSubDir); err == nil {
+		for _, f := range files {
+			names = append(names, f.Name)
+		}
+	}
+	if files, err := instance.ListSystem("*", instance.SingSubDir); err == nil {
+		for _, f := range files {
+			if f.Authorized() {
+				names = append(names, f.Name)
+			}
+		}
+	}
+	return names
+}
+
+// instanceURIs returns the instance:// URIs of the running instances.
+func instanceURIs() []string {
+	names := instanceNames()
+	for i := range names {
+		names[i] = "instance://" + names[i]
+	}
+	return names
+}
+
+// completeInstanceNames completes the instance name argument.
+func completeInstanceNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+	return filterPrefix(instanceNames(), toComplete), cobra.ShellCompDirectiveNoFileComp
+}
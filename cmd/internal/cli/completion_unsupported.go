@@ -0,0 +1,14 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build !linux
+
+package cli
+
+// instanceURIs returns the instance:// URIs of the running instances,
+// instances are not supported on this platform.
+func instanceURIs() []string {
+	return nil
+}
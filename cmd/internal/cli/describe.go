@@ -0,0 +1,214 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/pkg/cache"
+	"github.com/hpcng/singularity/internal/pkg/client/oras"
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	"github.com/hpcng/singularity/internal/pkg/util/uri"
+	"github.com/hpcng/singularity/internal/pkg/workflow"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+	"mvdan.cc/sh/v3/shell"
+)
+
+var (
+	describeCWL       bool
+	describeNextflow  bool
+	describeSnakemake bool
+	describeResolve   bool
+)
+
+// --cwl
+var describeCWLFlag = cmdline.Flag{
+	ID:           "describeCWLFlag",
+	Value:        &describeCWL,
+	DefaultValue: false,
+	Name:         "cwl",
+	Usage:        "print the CWL DockerRequirement of the image",
+}
+
+// --nextflow
+var describeNextflowFlag = cmdline.Flag{
+	ID:           "describeNextflowFlag",
+	Value:        &describeNextflow,
+	DefaultValue: false,
+	Name:         "nextflow",
+	Usage:        "print the Nextflow configuration of the image",
+}
+
+// --snakemake
+var describeSnakemakeFlag = cmdline.Flag{
+	ID:           "describeSnakemakeFlag",
+	Value:        &describeSnakemake,
+	DefaultValue: false,
+	Name:         "snakemake",
+	Usage:        "print the Snakemake container directive of the image",
+}
+
+// --resolve
+var describeResolveFlag = cmdline.Flag{
+	ID:           "describeResolveFlag",
+	Value:        &describeResolve,
+	DefaultValue: false,
+	Name:         "resolve",
+	Usage:        "print the path of the image, pulled into the cache if needed",
+}
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterCmd(DescribeCmd)
+
+		cmdManager.RegisterFlagForCmd(&describeCWLFlag, DescribeCmd)
+		cmdManager.RegisterFlagForCmd(&describeNextflowFlag, DescribeCmd)
+		cmdManager.RegisterFlagForCmd(&describeSnakemakeFlag, DescribeCmd)
+		cmdManager.RegisterFlagForCmd(&describeResolveFlag, DescribeCmd)
+
+		cmdManager.RegisterFlagForCmd(&pullLibraryURIFlag, DescribeCmd)
+		cmdManager.RegisterFlagForCmd(&commonNoHTTPSFlag, DescribeCmd)
+		cmdManager.RegisterFlagForCmd(&commonTmpDirFlag, DescribeCmd)
+		cmdManager.RegisterFlagForCmd(&dockerUsernameFlag, DescribeCmd)
+		cmdManager.RegisterFlagForCmd(&dockerPasswordFlag, DescribeCmd)
+		cmdManager.RegisterFlagForCmd(&dockerLoginFlag, DescribeCmd)
+		cmdManager.RegisterFlagForCmd(&pullArchFlag, DescribeCmd)
+	})
+}
+
+// DescribeCmd singularity describe
+var DescribeCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(1),
+	Run:                   describeRun,
+	Use:                   docs.DescribeUse,
+	Short:                 docs.DescribeShort,
+	Long:                  docs.DescribeLong,
+	Example:               docs.DescribeExample,
+}
+
+func describeRun(cmd *cobra.Command, args []string) {
+	modes := 0
+	for _, set := range []bool{describeCWL, describeNextflow, describeSnakemake, describeResolve} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 {
+		sylog.Fatalf("Only one of --cwl, --nextflow, --snakemake and --resolve can be set")
+	}
+
+	path, img, err := describeImage(cmd.Context(), cmd, args[0])
+	if err != nil {
+		sylog.Fatalf("While describing %s: %s", args[0], err)
+	}
+
+	switch {
+	case describeResolve:
+		fmt.Println(path)
+	case describeCWL:
+		fmt.Print(img.CWL())
+	case describeNextflow:
+		fmt.Print(img.Nextflow())
+	case describeSnakemake:
+		fmt.Print(img.Snakemake())
+	default:
+		fmt.Printf("URI:\t%s\n", img.URI)
+		if img.Digest != "" {
+			fmt.Printf("Digest:\t%s\n", img.Digest)
+		}
+		fmt.Printf("Path:\t%s\n", path)
+		if options := img.RuntimeOptions(); len(options) > 0 {
+			fmt.Printf("Options:\t%s\n", strings.Join(options, " "))
+		}
+	}
+}
+
+// describeImage returns the local path and the workflow description of
+// the image ref, image URIs are pulled into the cache.
+func describeImage(ctx context.Context, cmd *cobra.Command, ref string) (string, workflow.Image, error) {
+	var img workflow.Image
+	var path string
+
+	scheme, _ := uri.Split(ref)
+	if scheme == "" {
+		abs, err := filepath.Abs(ref)
+		if err != nil {
+			return "", img, fmt.Errorf("while getting absolute path: %s", err)
+		}
+		path = abs
+		img.URI = abs
+		if fs.IsFile(abs) {
+			if img.Digest, err = oras.ImageHash(abs); err != nil {
+				return "", img, fmt.Errorf("while computing image digest: %s", err)
+			}
+		}
+	} else {
+		if describeResolve && pullQuarantine() {
+			return "", img, fmt.Errorf("pulled images are held in quarantine, pull the image and release it before running it")
+		}
+		imgCache := getCacheHandle(cache.Config{})
+		if imgCache == nil {
+			return "", img, fmt.Errorf("failed to create an image cache handle")
+		}
+		cached, err := pullToCache(ctx, imgCache, cmd, ref)
+		if err != nil {
+			return "", img, err
+		}
+		path = cached
+
+		scheme, t, err := lookupTransport(ref)
+		if err != nil {
+			return "", img, err
+		}
+		tctx := withCommand(ctx, cmd)
+		resolved, err := t.Resolve(tctx, ref)
+		if err != nil {
+			return "", img, fmt.Errorf("while resolving %s: %s", ref, err)
+		}
+		digest, err := t.Digest(tctx, resolved)
+		if err != nil {
+			return "", img, fmt.Errorf("while getting digest of %s: %s", resolved, err)
+		}
+		img.URI, img.Digest = workflow.Pin(scheme, resolved, digest)
+	}
+
+	if describeResolve && scheme != "" {
+		return path, img, nil
+	}
+
+	labels, err := getImageLabels(path)
+	if err != nil && scheme == "" {
+		return "", img, err
+	} else if err != nil {
+		sylog.Debugf("Could not read image labels: %s", err)
+		return path, img, nil
+	}
+	label := strings.TrimSpace(labels[imageFlagsLabel])
+	if label == "" {
+		return path, img, nil
+	}
+	words, err := shell.Fields(label, nil)
+	if err != nil {
+		sylog.Warningf("Ignoring image runtime flags %q: %s", label, err)
+		return path, img, nil
+	}
+	flags, err := parseImageFlags(ExecCmd.Flags(), words)
+	if err != nil {
+		sylog.Warningf("Ignoring image runtime flags %q: %s", label, err)
+		return path, img, nil
+	}
+	for _, f := range flags {
+		img.Options = append(img.Options, workflow.Option{Name: f.name, Value: f.value})
+	}
+	return path, img, nil
+}
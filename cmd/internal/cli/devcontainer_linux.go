@@ -0,0 +1,82 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"errors"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/app/singularity"
+	"github.com/hpcng/singularity/internal/pkg/devcontainer"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterCmd(DevcontainerCmd)
+		cmdManager.RegisterSubCmd(DevcontainerCmd, DevcontainerUpCmd)
+
+		cmdManager.RegisterFlagForCmd(&devcontainerRebuildFlag, DevcontainerUpCmd)
+	})
+}
+
+// --rebuild
+var devcontainerRebuild bool
+var devcontainerRebuildFlag = cmdline.Flag{
+	ID:           "devcontainerRebuildFlag",
+	Value:        &devcontainerRebuild,
+	DefaultValue: false,
+	Name:         "rebuild",
+	Usage:        "rebuild the image of a development container built from a definition file",
+}
+
+// DevcontainerCmd is the 'devcontainer' command that allows to run development containers.
+var DevcontainerCmd = &cobra.Command{
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("Invalid command")
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:     docs.DevcontainerUse,
+	Short:   docs.DevcontainerShort,
+	Long:    docs.DevcontainerLong,
+	Example: docs.DevcontainerExample,
+}
+
+// DevcontainerUpCmd is the 'devcontainer up' command that allows to start development containers.
+var DevcontainerUpCmd = &cobra.Command{
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		workspace := "."
+		if len(args) == 1 {
+			workspace = args[0]
+		}
+
+		path, err := devcontainer.Find(workspace)
+		if err != nil {
+			sylog.Fatalf("%s", err)
+		}
+		c, err := devcontainer.Load(path)
+		if err != nil {
+			sylog.Fatalf("%s", err)
+		}
+		plan, err := c.Plan(path, workspace)
+		if err != nil {
+			sylog.Fatalf("While mapping %s: %s", path, err)
+		}
+		if err := singularity.DevcontainerUp(plan, devcontainerRebuild); err != nil {
+			sylog.Fatalf("%s", err)
+		}
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:     docs.DevcontainerUpUse,
+	Short:   docs.DevcontainerUpShort,
+	Long:    docs.DevcontainerUpLong,
+	Example: docs.DevcontainerUpExample,
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/pkg/buildcfg"
+	"github.com/hpcng/singularity/internal/pkg/doctor"
+	"github.com/hpcng/singularity/internal/pkg/fakeroot"
+	"github.com/hpcng/singularity/internal/pkg/remote/endpoint"
+	"github.com/hpcng/singularity/internal/pkg/security/seccomp"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/util/singularityconf"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterCmd(doctorCmd)
+
+		cmdManager.RegisterFlagForCmd(&doctorNoRemoteFlag, doctorCmd)
+	})
+}
+
+// --no-remote
+var doctorNoRemote bool
+var doctorNoRemoteFlag = cmdline.Flag{
+	ID:           "doctorNoRemoteFlag",
+	Value:        &doctorNoRemote,
+	DefaultValue: false,
+	Name:         "no-remote",
+	Usage:        "skip the connectivity checks of the remote endpoint",
+}
+
+// singularity doctor
+var doctorCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(0),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		s := &doctor.System{
+			UID:         uint32(os.Getuid()),
+			Config:      singularityconf.GetCurrentConfig(),
+			ConfigFile:  configurationFile,
+			SubUID:      fakeroot.SubUIDFile,
+			SubGID:      fakeroot.SubGIDFile,
+			StarterSuid: filepath.Join(buildcfg.LIBEXECDIR, "singularity/bin/starter-suid"),
+			SuidInstall: buildcfg.SINGULARITY_SUID_INSTALL == 1,
+			Seccomp:     seccomp.Enabled(),
+		}
+		if !doctorNoRemote {
+			ep, err := sylabsRemote()
+			if err == nil {
+				s.RemoteURI = ep.URI
+			}
+			s.Services = func() (map[string][]endpoint.Service, error) {
+				if err != nil {
+					return nil, fmt.Errorf("while loading remote configuration: %s", err)
+				}
+				return ep.GetAllServices()
+			}
+		}
+
+		if failed := doctor.Run(os.Stdout, s.Checks()); failed > 0 {
+			sylog.Fatalf("%d checks failed, see the fixes above", failed)
+		}
+	},
+
+	Use:     docs.DoctorUse,
+	Short:   docs.DoctorShort,
+	Long:    docs.DoctorLong,
+	Example: docs.DoctorExample,
+}
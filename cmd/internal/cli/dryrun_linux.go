@@ -0,0 +1,132 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hpcng/singularity/internal/pkg/util/starter"
+	"github.com/hpcng/singularity/pkg/runtime/engine/config"
+	singularityConfig "github.com/hpcng/singularity/pkg/runtime/engine/singularity/config"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+// printDryRun prints the plan of the container set up by cfg with
+// --dry-run or --dry-run-json instead of starting it. The plan is
+// reported by the engine once the configuration is prepared, starter
+// exits without creating the container.
+func printDryRun(name string, cfg *config.Common, useSuid, loadOverlay, extracted bool) {
+	var out bytes.Buffer
+
+	cfg.EngineConfig.(*singularityConfig.EngineConfig).SetDryRun(true)
+
+	err := starter.Run(
+		name,
+		cfg,
+		starter.UseSuid(useSuid),
+		starter.WithStdout(&out),
+		starter.WithStderr(os.Stderr),
+		starter.LoadOverlayModule(loadOverlay),
+	)
+	if err != nil {
+		sylog.Fatalf("While planning container: %s", err)
+	}
+
+	plan := new(singularityConfig.ContainerPlan)
+	if err := json.Unmarshal(out.Bytes(), plan); err != nil {
+		sylog.Fatalf("While reading container plan: %s", err)
+	}
+	if extracted {
+		plan.Image += " (extracted to a temporary sandbox)"
+	}
+
+	if DryRunJSON {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		if err := e.Encode(plan); err != nil {
+			sylog.Fatalf("Failed to output JSON: %v", err)
+		}
+		return
+	}
+	if err := writeDryRun(os.Stdout, plan); err != nil {
+		sylog.Fatalf("Failed to output plan: %v", err)
+	}
+}
+
+// writeDryRun writes the text report of plan to w.
+func writeDryRun(w io.Writer, plan *singularityConfig.ContainerPlan) error {
+	tw := tabwriter.NewWriter(w, 2, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Image:\t%s\n", plan.Image)
+	fmt.Fprintf(tw, "Starter:\t%s\n", plan.Starter)
+	fmt.Fprintf(tw, "Namespaces:\t%s\n", strings.Join(plan.Namespaces, ", "))
+	if plan.Network != "" {
+		fmt.Fprintf(tw, "Network:\t%s\n", plan.Network)
+	}
+	for _, m := range plan.UIDMap {
+		fmt.Fprintf(tw, "UID mapping:\t%d -> %d (%d)\n", m.HostID, m.ContainerID, m.Size)
+	}
+	for _, m := range plan.GIDMap {
+		fmt.Fprintf(tw, "GID mapping:\t%d -> %d (%d)\n", m.HostID, m.ContainerID, m.Size)
+	}
+	fmt.Fprintf(tw, "Process:\t%s\n", strings.Join(plan.Args, " "))
+	fmt.Fprintf(tw, "Working directory:\t%s\n", plan.Cwd)
+
+	fmt.Fprintf(tw, "\nMounts, in order:\n")
+	fmt.Fprintf(tw, "  STAGE\tSOURCE\tDESTINATION\tTYPE\tOPTIONS\n")
+	for _, m := range plan.Mounts {
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\n", m.Stage, m.Source, m.Destination, m.Type, strings.Join(m.Options, ","))
+	}
+
+	fmt.Fprintf(tw, "\nEnvironment:\n")
+	for _, e := range plan.Env {
+		fmt.Fprintf(tw, "  %s\n", e)
+	}
+	if len(plan.ContainerEnv) > 0 {
+		fmt.Fprintf(tw, "\nEnvironment set by the container scripts:\n")
+		keys := make([]string, 0, len(plan.ContainerEnv))
+		for k := range plan.ContainerEnv {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(tw, "  %s=%s\n", k, plan.ContainerEnv[k])
+		}
+	}
+
+	s := plan.Security
+	fmt.Fprintf(tw, "\nSecurity:\n")
+	fmt.Fprintf(tw, "  Fakeroot:\t%t\n", s.Fakeroot)
+	fmt.Fprintf(tw, "  No privileges:\t%t\n", s.NoPrivs)
+	fmt.Fprintf(tw, "  Keep privileges:\t%t\n", s.KeepPrivs)
+	fmt.Fprintf(tw, "  Allow setuid:\t%t\n", s.AllowSUID)
+	fmt.Fprintf(tw, "  No new privileges:\t%t\n", s.NoNewPrivs)
+	fmt.Fprintf(tw, "  Seccomp filter:\t%t\n", s.Seccomp)
+	if len(s.Capabilities) > 0 {
+		fmt.Fprintf(tw, "  Capabilities:\t%s\n", strings.Join(s.Capabilities, ", "))
+	}
+	if len(s.Options) > 0 {
+		fmt.Fprintf(tw, "  Security options:\t%s\n", strings.Join(s.Options, ", "))
+	}
+	if s.TargetUID != 0 || len(s.TargetGID) > 0 {
+		fmt.Fprintf(tw, "  Target UID/GID:\t%d/%v\n", s.TargetUID, s.TargetGID)
+	}
+	if s.Cgroups != "" {
+		fmt.Fprintf(tw, "  Cgroups:\t%s\n", s.Cgroups)
+	}
+	if len(s.Ulimits) > 0 {
+		fmt.Fprintf(tw, "  Limits:\t%s\n", strings.Join(s.Ulimits, ", "))
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"os"
+	"strings"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/app/singularity"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterCmd(duCmd)
+
+		cmdManager.RegisterFlagForCmd(&duTopFlag, duCmd)
+	})
+}
+
+// --top
+var duTop int
+var duTopFlag = cmdline.Flag{
+	ID:           "duTopFlag",
+	Value:        &duTop,
+	DefaultValue: 10,
+	Name:         "top",
+	Usage:        "number of top directories reported, 0 to report only the partitions or mounts usage",
+	Tag:          "<N>",
+}
+
+// singularity du
+var duCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+
+		if strings.HasPrefix(args[0], "instance://") {
+			name := strings.TrimPrefix(args[0], "instance://")
+			err = singularity.PrintInstanceUsage(os.Stdout, name, duTop)
+		} else {
+			err = singularity.PrintImageUsage(os.Stdout, args[0], duTop)
+		}
+		if err != nil {
+			sylog.Fatalf("%s", err)
+		}
+	},
+
+	Use:     docs.DuUse,
+	Short:   docs.DuShort,
+	Long:    docs.DuLong,
+	Example: docs.DuExample,
+}
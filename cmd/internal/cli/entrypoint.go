@@ -0,0 +1,87 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+
+	"github.com/hpcng/singularity/pkg/image"
+	"github.com/hpcng/singularity/pkg/sylog"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// compatEntrypointDocker drops the image CMD when --entrypoint
+	// is set, like docker run --entrypoint.
+	compatEntrypointDocker = "docker"
+	// compatEntrypointSingularity keeps the image CMD as default
+	// arguments of the command set with --entrypoint.
+	compatEntrypointSingularity = "singularity"
+)
+
+// readArgsFile returns the arguments read from the file set with
+// --args-file, one argument per line.
+func readArgsFile() []string {
+	if ArgsFile == "" {
+		return nil
+	}
+	content, err := ioutil.ReadFile(ArgsFile)
+	if err != nil {
+		sylog.Fatalf("Could not read arguments file: %s", err)
+	}
+	s := strings.TrimSuffix(string(content), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// imageOCICmd returns the CMD of the OCI image the SIF image at path
+// was built from, if any.
+func imageOCICmd(path string) []string {
+	img, err := image.Init(path, false)
+	if err != nil {
+		sylog.Debugf("Could not open image %s: %s", path, err)
+		return nil
+	}
+	defer img.File.Close()
+
+	r, err := image.NewSectionReader(img, image.SIFDescOCIConfigJSON, -1)
+	if err != nil {
+		sylog.Debugf("No OCI configuration found in image: %s", err)
+		return nil
+	}
+	var config imgspecv1.ImageConfig
+	if err := json.NewDecoder(r).Decode(&config); err != nil {
+		sylog.Warningf("Could not decode image OCI configuration: %s", err)
+		return nil
+	}
+	return config.Cmd
+}
+
+// runActionArgs returns the action script and the arguments executed by
+// the run command for the image at path. The runscript is replaced by
+// the command set with --entrypoint, the image CMD being its default
+// arguments with the singularity entrypoint compatibility mode.
+func runActionArgs(path string, args []string) []string {
+	args = append(args, readArgsFile()...)
+	if Entrypoint == "" {
+		return append([]string{"/.singularity.d/actions/run"}, args...)
+	}
+
+	switch CompatEntrypoint {
+	case compatEntrypointDocker:
+	case compatEntrypointSingularity:
+		if len(args) == 0 {
+			args = imageOCICmd(path)
+		}
+	default:
+		sylog.Fatalf("Unknown entrypoint compatibility mode %q, must be %s or %s", CompatEntrypoint, compatEntrypointDocker, compatEntrypointSingularity)
+	}
+	return append([]string{"/.singularity.d/actions/exec", Entrypoint}, args...)
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/app/singularity"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterCmd(containerExportCmd)
+
+		cmdManager.RegisterFlagForCmd(&containerExportOutputFlag, containerExportCmd)
+		cmdManager.RegisterFlagForCmd(&containerExportWritableLayerFlag, containerExportCmd)
+	})
+}
+
+// -o|--output
+var containerExportOutput string
+var containerExportOutputFlag = cmdline.Flag{
+	ID:           "containerExportOutputFlag",
+	Value:        &containerExportOutput,
+	DefaultValue: "",
+	Name:         "output",
+	ShortHand:    "o",
+	Usage:        "path of the tar archive, compressed with gzip for a .tar.gz or .tgz suffix, - for the standard output",
+	Tag:          "<file>",
+}
+
+// --writable-layer
+var containerExportWritableLayer bool
+var containerExportWritableLayerFlag = cmdline.Flag{
+	ID:           "containerExportWritableLayerFlag",
+	Value:        &containerExportWritableLayer,
+	DefaultValue: false,
+	Name:         "writable-layer",
+	Usage:        "export only the files created or modified since the container started",
+}
+
+// singularity export
+var containerExportCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	ValidArgsFunction:     completeInstanceNames,
+	Run: func(cmd *cobra.Command, args []string) {
+		if containerExportOutput == "" {
+			sylog.Fatalf("You must specify the archive path with --output")
+		}
+
+		var w io.Writer = os.Stdout
+		if containerExportOutput != "-" {
+			f, err := os.OpenFile(containerExportOutput, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+			if err != nil {
+				sylog.Fatalf("While creating archive: %s", err)
+			}
+			defer f.Close()
+			w = f
+		}
+		var gw *gzip.Writer
+		if strings.HasSuffix(containerExportOutput, ".tar.gz") || strings.HasSuffix(containerExportOutput, ".tgz") {
+			gw = gzip.NewWriter(w)
+			w = gw
+		}
+
+		err := singularity.ExportContainer(w, args[0], containerExportWritableLayer)
+		if err == nil && gw != nil {
+			err = gw.Close()
+		}
+		if err != nil {
+			if containerExportOutput != "-" {
+				os.Remove(containerExportOutput)
+			}
+			sylog.Fatalf("%s", err)
+		}
+	},
+
+	Use:     docs.ContainerExportUse,
+	Short:   docs.ContainerExportShort,
+	Long:    docs.ContainerExportLong,
+	Example: docs.ContainerExportExample,
+}
@@ -0,0 +1,181 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/pkg/history"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterCmd(historyCmd)
+
+		cmdManager.RegisterFlagForCmd(&historyJSONFlag, historyCmd)
+		cmdManager.RegisterFlagForCmd(&historyClearFlag, historyCmd)
+		cmdManager.RegisterFlagForCmd(&historyOlderThanFlag, historyCmd)
+	})
+}
+
+// -j|--json
+var historyJSON bool
+var historyJSONFlag = cmdline.Flag{
+	ID:           "historyJSONFlag",
+	Value:        &historyJSON,
+	DefaultValue: false,
+	Name:         "json",
+	ShortHand:    "j",
+	Usage:        "print the records in JSON format",
+}
+
+// --clear
+var historyClear bool
+var historyClearFlag = cmdline.Flag{
+	ID:           "historyClearFlag",
+	Value:        &historyClear,
+	DefaultValue: false,
+	Name:         "clear",
+	Usage:        "remove the records from the history",
+}
+
+// --older-than
+var historyOlderThan string
+var historyOlderThanFlag = cmdline.Flag{
+	ID:           "historyOlderThanFlag",
+	Value:        &historyOlderThan,
+	DefaultValue: "",
+	Name:         "older-than",
+	Usage:        "only remove the records of runs finished before this duration with --clear (eg: 720h)",
+	Tag:          "<duration>",
+}
+
+// singularity history
+var historyCmd = &cobra.Command{
+	Args: func(cmd *cobra.Command, args []string) error {
+		if historyClear {
+			return cobra.ExactArgs(0)(cmd, args)
+		}
+		return cobra.MaximumNArgs(1)(cmd, args)
+	},
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := history.Dir()
+
+		switch {
+		case historyClear:
+			t := time.Now()
+			if historyOlderThan != "" {
+				d, err := time.ParseDuration(historyOlderThan)
+				if err != nil {
+					sylog.Fatalf("Invalid duration %s: %s", historyOlderThan, err)
+				}
+				t = t.Add(-d)
+			}
+			n, err := history.Remove(dir, t)
+			if err != nil {
+				sylog.Fatalf("While clearing history: %s", err)
+			}
+			sylog.Infof("Removed %d history records", n)
+		case len(args) == 1:
+			r, err := history.Find(dir, args[0])
+			if err != nil {
+				sylog.Fatalf("%s", err)
+			}
+			if err := printHistoryRecord(r); err != nil {
+				sylog.Fatalf("While printing history record: %s", err)
+			}
+		default:
+			if err := listHistory(dir); err != nil {
+				sylog.Fatalf("While listing history: %s", err)
+			}
+		}
+	},
+
+	Use:     docs.HistoryUse,
+	Short:   docs.HistoryShort,
+	Long:    docs.HistoryLong,
+	Example: docs.HistoryExample,
+}
+
+// listHistory prints the records of the history directory dir.
+func listHistory(dir string) error {
+	records, err := history.List(dir)
+	if err != nil {
+		return err
+	}
+	if historyJSON {
+		return printHistoryJSON(records)
+	}
+	if len(records) == 0 {
+		fmt.Println("No run recorded, use --history with the action commands to record runs")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTARTED\tDURATION\tSTATUS\tIMAGE\tCOMMAND")
+	for _, r := range records {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.ID,
+			r.Started.Local().Format("2006-01-02 15:04:05"),
+			r.Duration().Round(time.Second),
+			r.Status(),
+			r.Image,
+			strings.Join(r.Command, " "),
+		)
+	}
+	return tw.Flush()
+}
+
+// printHistoryRecord prints the details of the record r.
+func printHistoryRecord(r *history.Record) error {
+	if historyJSON {
+		return printHistoryJSON(r)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID:\t%s\n", r.ID)
+	fmt.Fprintf(tw, "Image:\t%s\n", r.Image)
+	if r.ImageDigest != "" {
+		fmt.Fprintf(tw, "Image digest:\t%s\n", r.ImageDigest)
+	}
+	if len(r.Signers) > 0 {
+		fmt.Fprintf(tw, "Verified signers:\t%s\n", strings.Join(r.Signers, ", "))
+	} else {
+		fmt.Fprintf(tw, "Verified signers:\tnone\n")
+	}
+	if r.Instance != "" {
+		fmt.Fprintf(tw, "Instance:\t%s\n", r.Instance)
+	}
+	fmt.Fprintf(tw, "Command:\t%s\n", strings.Join(r.Command, " "))
+	fmt.Fprintf(tw, "Working directory:\t%s\n", r.Cwd)
+	for _, b := range r.Binds {
+		fmt.Fprintf(tw, "Bind:\t%s\n", b)
+	}
+	fmt.Fprintf(tw, "Flags:\t%s\n", strings.Join(r.Flags, ", "))
+	fmt.Fprintf(tw, "Started:\t%s\n", r.Started.Local().Format(time.RFC3339))
+	fmt.Fprintf(tw, "Finished:\t%s\n", r.Finished.Local().Format(time.RFC3339))
+	fmt.Fprintf(tw, "Status:\t%s\n", r.Status())
+	return tw.Flush()
+}
+
+func printHistoryJSON(v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", b)
+	return nil
+}
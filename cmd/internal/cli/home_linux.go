@@ -0,0 +1,126 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/hpcng/singularity/internal/pkg/util/fs/tmpdir"
+	singularityConfig "github.com/hpcng/singularity/pkg/runtime/engine/singularity/config"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+// jobHomePrefix is the name prefix of the per-job home directories.
+const jobHomePrefix = "singularity-home-"
+
+// jobIDEnvs are the environment variables set by batch systems to
+// the job identifier.
+var jobIDEnvs = []string{
+	"SLURM_JOB_ID",
+	"PBS_JOBID",
+	"LSB_JOBID",
+	"JOB_ID",
+}
+
+// jobHomeDir returns the home directory of the current batch job,
+// created in the job scratch directory on first use so the containers
+// of a job share it.
+func jobHomeDir() (string, error) {
+	id := ""
+	for _, env := range jobIDEnvs {
+		if id = os.Getenv(env); id != "" {
+			break
+		}
+	}
+	if id == "" {
+		return "", fmt.Errorf("no batch job detected, none of %s is set", strings.Join(jobIDEnvs, ", "))
+	}
+
+	name := fmt.Sprintf("%s%d-%s", jobHomePrefix, os.Getuid(), strings.Replace(id, "/", "_", -1))
+	dir := filepath.Join(tmpdir.ScratchDir(), name)
+
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return "", fmt.Errorf("while creating job home directory %s: %s", dir, err)
+	}
+	// the scratch directory may be shared, don't use a
+	// directory created by another user
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return "", fmt.Errorf("while checking job home directory %s: %s", dir, err)
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); !fi.IsDir() || !ok || int(st.Uid) != os.Getuid() {
+		return "", fmt.Errorf("job home directory %s is not a directory owned by the current user", dir)
+	}
+	return dir, nil
+}
+
+// homeBackend handles the home specs selecting a home directory backend
+// instead of a host directory:
+//
+//	tmpfs[:dest]       a temporary home directory discarded on exit
+//	image:path[:dest]  a home directory stored in an image
+//	job[:dest]         a home directory shared by the containers of a job
+//
+// It returns the src:dest home spec to use, spec itself for host home
+// directories.
+func homeBackend(engineConfig *singularityConfig.EngineConfig, spec, defaultDest string) (string, error) {
+	elems := strings.Split(spec, ":")
+
+	dest := defaultDest
+	switch elems[0] {
+	case "tmpfs", "job":
+		if len(elems) > 2 {
+			return "", fmt.Errorf("%s home argument has incorrect number of elements: %v", elems[0], len(elems))
+		} else if len(elems) == 2 {
+			dest = elems[1]
+		}
+	case "image":
+		if len(elems) < 2 || len(elems) > 3 || elems[1] == "" {
+			return "", fmt.Errorf("image home argument must be image:path[:dest]")
+		} else if len(elems) == 3 {
+			dest = elems[2]
+		}
+	default:
+		return spec, nil
+	}
+
+	switch elems[0] {
+	case "tmpfs":
+		sylog.Debugf("Using a temporary home directory at %s", dest)
+		engineConfig.SetHomeTmpfs(true)
+		return dest + ":" + dest, nil
+	case "job":
+		dir, err := jobHomeDir()
+		if err != nil {
+			return "", err
+		}
+		sylog.Debugf("Using job home directory %s", dir)
+		return dir + ":" + dest, nil
+	}
+
+	// the image is mounted as a data image bind, the home
+	// directory mount itself is disabled
+	path, err := filepath.Abs(elems[1])
+	if err != nil {
+		return "", fmt.Errorf("while determining absolute path of %s: %s", elems[1], err)
+	}
+	sylog.Debugf("Using home directory image %s", path)
+	binds := append(engineConfig.GetBindPath(), singularityConfig.BindPath{
+		Source:      path,
+		Destination: dest,
+		Options: map[string]*singularityConfig.BindOption{
+			"image-src": {Value: "/"},
+		},
+	})
+	engineConfig.SetBindPath(binds)
+	engineConfig.SetNoHome(true)
+
+	return dest + ":" + dest, nil
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	singularityConfig "github.com/hpcng/singularity/pkg/runtime/engine/singularity/config"
+)
+
+func TestHomeBackend(t *testing.T) {
+	scratch, err := ioutil.TempDir("", "home-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(scratch)
+
+	for _, env := range append([]string{"SLURM_TMPDIR", "SLURM_JOB_ID"}, jobIDEnvs...) {
+		if v, ok := os.LookupEnv(env); ok {
+			os.Unsetenv(env)
+			defer os.Setenv(env, v)
+		}
+	}
+	os.Setenv("SLURM_TMPDIR", scratch)
+	defer os.Unsetenv("SLURM_TMPDIR")
+
+	tests := []struct {
+		name         string
+		spec         string
+		jobID        string
+		expectedSpec string
+		expectTmpfs  bool
+		expectImage  bool
+		expectError  bool
+	}{
+		{
+			name:         "Host",
+			spec:         "/data/home:/home/user",
+			expectedSpec: "/data/home:/home/user",
+		},
+		{
+			name:         "Tmpfs",
+			spec:         "tmpfs",
+			expectedSpec: "/home/user:/home/user",
+			expectTmpfs:  true,
+		},
+		{
+			name:         "TmpfsDest",
+			spec:         "tmpfs:/home/other",
+			expectedSpec: "/home/other:/home/other",
+			expectTmpfs:  true,
+		},
+		{
+			name:         "Image",
+			spec:         "image:/data/home.img",
+			expectedSpec: "/home/user:/home/user",
+			expectImage:  true,
+		},
+		{
+			name:        "ImageNoPath",
+			spec:        "image",
+			expectError: true,
+		},
+		{
+			name:         "Job",
+			spec:         "job",
+			jobID:        "42",
+			expectedSpec: filepath.Join(scratch, jobHomePrefix+strconv.Itoa(os.Getuid())+"-42") + ":/home/user",
+		},
+		{
+			name:        "NoJob",
+			spec:        "job",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.jobID != "" {
+				os.Setenv("SLURM_JOB_ID", tt.jobID)
+				defer os.Unsetenv("SLURM_JOB_ID")
+			}
+
+			engineConfig := singularityConfig.NewConfig()
+			spec, err := homeBackend(engineConfig, tt.spec, "/home/user")
+			if err != nil && !tt.expectError {
+				t.Fatalf("unexpected error: %s", err)
+			} else if err == nil && tt.expectError {
+				t.Fatalf("unexpected success")
+			}
+			if spec != tt.expectedSpec {
+				t.Errorf("unexpected home spec %q instead of %q", spec, tt.expectedSpec)
+			}
+			if engineConfig.GetHomeTmpfs() != tt.expectTmpfs {
+				t.Errorf("unexpected tmpfs home %v", engineConfig.GetHomeTmpfs())
+			}
+			if binds := engineConfig.GetBindPath(); tt.expectImage && (len(binds) != 1 || binds[0].ImageSrc() != "/" || !engineConfig.GetNoHome()) {
+				t.Errorf("home image not mounted as data image: %+v", binds)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hpcng/singularity/internal/pkg/buildcfg"
+	"github.com/hpcng/singularity/internal/pkg/util/hostlibs"
+	singularityConfig "github.com/hpcng/singularity/pkg/runtime/engine/singularity/config"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+// injectHostLibs adds the host library sets enabled in hostlibs.conf
+// and the sets requested with --host-libs to the container. Libraries
+// are bound into /.singularity.d/libs, binaries into /usr/bin and library
+// directories at the same location, the library directories being
+// prepended to the container library search path.
+func injectHostLibs(engineConfig *singularityConfig.EngineConfig, userPath string) {
+	confFile := filepath.Join(buildcfg.SINGULARITY_CONFDIR, "hostlibs.conf")
+
+	sets, err := hostlibs.Load(confFile)
+	if os.IsNotExist(err) && len(HostLibs) == 0 {
+		return
+	} else if err != nil {
+		sylog.Fatalf("While loading host library sets: %s", err)
+	}
+
+	if NoHostLibs {
+		// keep the explicitly requested sets only
+		for i := range sets {
+			sets[i].Enabled = false
+		}
+	}
+
+	selected, err := hostlibs.Select(sets, HostLibs)
+	if err != nil {
+		sylog.Fatalf("While selecting host library sets: %s", err)
+	}
+	if len(selected) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(selected))
+	for _, s := range selected {
+		names = append(names, s.Name)
+	}
+	sylog.Verbosef("Injecting host library sets: %s", strings.Join(names, ", "))
+
+	libs, bins, dirs, err := hostlibs.Paths(selected, userPath)
+	if err != nil {
+		sylog.Warningf("Unable to capture host library bind points: %v", err)
+		return
+	}
+	if len(libs) == 0 && len(dirs) == 0 {
+		sylog.Warningf("Could not find any host libraries of sets %s on this host!", strings.Join(names, ", "))
+		sylog.Warningf("You may need to manually edit %s", confFile)
+	}
+	if IsWritable && len(libs)+len(bins)+len(dirs) > 0 {
+		sylog.Warningf("host libraries may not be bound with --writable")
+	}
+
+	for _, binary := range bins {
+		usrBinBinary := filepath.Join("/usr/bin", filepath.Base(binary))
+		engineConfig.AppendFilesPath(strings.Join([]string{binary, usrBinBinary}, ":"))
+	}
+	engineConfig.AppendFilesPath(dirs...)
+	engineConfig.AppendLibrariesPath(libs...)
+
+	// libraries take precedence over the container ones, the
+	// libraries bound into /.singularity.d/libs as well
+	path := dirs
+	if len(libs) > 0 {
+		path = append(path, "/.singularity.d/libs")
+	}
+	engineConfig.SetHostLibraryPath(path)
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hpcng/singularity/internal/pkg/util/interactive"
+	"github.com/hpcng/singularity/pkg/image"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/crypto/ssh/terminal"
+	"mvdan.cc/sh/v3/shell"
+)
+
+// imageFlagsLabel is the label holding the runtime flags recommended
+// by the image author, like "--nv --bind /data".
+const imageFlagsLabel = "io.sylabs.runtime.flags"
+
+// imageFlagsAllowed lists the flags an image can request, flags granting
+// additional privileges or changing the image to run are not allowed.
+var imageFlagsAllowed = map[string]bool{
+	"bind":           true,
+	"cleanenv":       true,
+	"contain":        true,
+	"containall":     true,
+	"env":            true,
+	"locale":         true,
+	"mount":          true,
+	"no-home":        true,
+	"no-mount":       true,
+	"nv":             true,
+	"pwd":            true,
+	"rocm":           true,
+	"scratch":        true,
+	"tz":             true,
+	"ulimit":         true,
+	"writable-tmpfs": true,
+}
+
+// imageFlag is a flag requested by the image.
+type imageFlag struct {
+	name  string
+	value string
+}
+
+// getImageLabels returns the labels of the SIF or sandbox image at path.
+func getImageLabels(path string) (map[string]string, error) {
+	img, err := image.Init(path, false)
+	if err != nil {
+		return nil, err
+	}
+	defer img.File.Close()
+
+	return img.Labels()
+}
+
+// parseImageFlags parses the words of the image flags label, flags absent
+// from imageFlagsAllowed or not supported by the command are rejected.
+func parseImageFlags(flags *pflag.FlagSet, words []string) ([]imageFlag, error) {
+	var parsed []imageFlag
+
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+
+		var f *pflag.Flag
+		var value string
+		hasValue := false
+
+		switch {
+		case strings.HasPrefix(word, "--"):
+			name := word[2:]
+			if n := strings.IndexByte(name, '='); n >= 0 {
+				name, value, hasValue = name[:n], name[n+1:], true
+			}
+			f = flags.Lookup(name)
+		case strings.HasPrefix(word, "-") && len(word) == 2:
+			f = flags.ShorthandLookup(word[1:])
+		default:
+			return nil, fmt.Errorf("unexpected argument %q", word)
+		}
+		if f == nil || !imageFlagsAllowed[f.Name] {
+			return nil, fmt.Errorf("flag %s is not allowed", word)
+		}
+
+		if !hasValue {
+			if f.NoOptDefVal != "" {
+				value = f.NoOptDefVal
+			} else if i+1 < len(words) {
+				i++
+				value = words[i]
+			} else {
+				return nil, fmt.Errorf("flag %s requires a value", word)
+			}
+		}
+		parsed = append(parsed, imageFlag{name: f.Name, value: value})
+	}
+	return parsed, nil
+}
+
+// applyImageFlags applies the runtime flags recommended by the image
+// author to cmd once the user confirmed them or if --trust-image-flags
+// is set. Flags set by the user take precedence, except list flags like
+// --bind for which the image values are appended.
+func applyImageFlags(cmd *cobra.Command, path string) {
+	labels, err := getImageLabels(path)
+	if err != nil {
+		sylog.Debugf("Could not read image labels: %s", err)
+		return
+	}
+	label := strings.TrimSpace(labels[imageFlagsLabel])
+	if label == "" {
+		return
+	}
+
+	words, err := shell.Fields(label, nil)
+	if err != nil {
+		sylog.Warningf("Ignoring image runtime flags %q: %s", label, err)
+		return
+	}
+	flags, err := parseImageFlags(cmd.Flags(), words)
+	if err != nil {
+		sylog.Warningf("Ignoring image runtime flags %q: %s", label, err)
+		return
+	}
+
+	if !TrustImageFlags {
+		if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+			sylog.Infof("Image recommends the runtime flags %q, use --trust-image-flags to apply them", label)
+			return
+		}
+		ans, err := interactive.AskYNQuestion("n", "The image recommends the runtime flags:\n  %s\nApply them? [N/y] ", label)
+		if err != nil {
+			sylog.Fatalf("While reading answer: %s", err)
+		}
+		if ans != "y" {
+			return
+		}
+	}
+
+	for _, f := range flags {
+		flag := cmd.Flags().Lookup(f.name)
+		if flag.Changed && !strings.HasSuffix(flag.Value.Type(), "Slice") {
+			sylog.Debugf("Ignoring image flag --%s, already set", f.name)
+			continue
+		}
+		sylog.Verbosef("Applying image flag --%s=%s", f.name, f.value)
+		if err := cmd.Flags().Set(f.name, f.value); err != nil {
+			sylog.Fatalf("While applying image flag --%s: %s", f.name, err)
+		}
+	}
+}
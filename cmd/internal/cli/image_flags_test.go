@@ -0,0 +1,77 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestParseImageFlags(t *testing.T) {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringSliceP("bind", "B", nil, "")
+	flags.Bool("nv", false, "")
+	flags.String("tz", "", "")
+	flags.Bool("fakeroot", false, "")
+
+	tests := []struct {
+		name     string
+		words    []string
+		expected []imageFlag
+		wantErr  bool
+	}{
+		{
+			name:  "Valid",
+			words: []string{"--nv", "-B", "/data", "--bind=/scratch:/tmp", "--tz", "UTC"},
+			expected: []imageFlag{
+				{name: "nv", value: "true"},
+				{name: "bind", value: "/data"},
+				{name: "bind", value: "/scratch:/tmp"},
+				{name: "tz", value: "UTC"},
+			},
+		},
+		{
+			name:    "NotAllowed",
+			words:   []string{"--fakeroot"},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown",
+			words:   []string{"--unknown"},
+			wantErr: true,
+		},
+		{
+			name:    "MissingValue",
+			words:   []string{"--tz"},
+			wantErr: true,
+		},
+		{
+			name:    "Argument",
+			words:   []string{"/bin/sh"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := parseImageFlags(flags, tt.words)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(parsed, tt.expected) {
+				t.Errorf("got %v, expected %v", parsed, tt.expected)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ package cli
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -18,9 +19,11 @@ import (
 	"runtime"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/hpcng/sif/pkg/sif"
 	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/pkg/build/buildlog"
 	"github.com/hpcng/singularity/internal/pkg/util/env"
 	"github.com/hpcng/singularity/pkg/cmdline"
 	"github.com/hpcng/singularity/pkg/image"
@@ -33,16 +36,19 @@ var errNoSIFMetadata = errors.New("no SIF metadata found")
 var errNoSIF = errors.New("invalid SIF")
 
 var (
-	allData     bool
-	runscript   bool
-	startscript bool
-	testfile    bool
-	environment bool
-	helpfile    bool
-	listApps    bool
-	labels      bool
-	deffile     bool
-	jsonfmt     bool
+	allData        bool
+	runscript      bool
+	startscript    bool
+	shutdownscript bool
+	testfile       bool
+	environment    bool
+	helpfile       bool
+	listApps       bool
+	labels         bool
+	deffile        bool
+	jsonfmt        bool
+	buildLog       bool
+	runtimeInfo    bool
 )
 
 // -l|--labels
@@ -114,6 +120,15 @@ var inspectStartscriptFlag = cmdline.Flag{
 	Usage:        "show the startscript for the image",
 }
 
+// --shutdownscript
+var inspectShutdownscriptFlag = cmdline.Flag{
+	ID:           "inspectShutdownscriptFlag",
+	Value:        &shutdownscript,
+	DefaultValue: false,
+	Name:         "shutdownscript",
+	Usage:        "show the shutdownscript for the image",
+}
+
 // -t|--test
 var inspectTestFlag = cmdline.Flag{
 	ID:           "inspectTestFlag",
@@ -144,6 +159,24 @@ var inspectHelpfileFlag = cmdline.Flag{
 	Usage:        "inspect the runscript helpfile, if it exists",
 }
 
+// --build-log
+var inspectBuildLogFlag = cmdline.Flag{
+	ID:           "inspectBuildLogFlag",
+	Value:        &buildLog,
+	DefaultValue: false,
+	Name:         "build-log",
+	Usage:        "show the build log stored in the image, if it was built with --build-log",
+}
+
+// --runtime
+var inspectRuntimeFlag = cmdline.Flag{
+	ID:           "inspectRuntimeFlag",
+	Value:        &runtimeInfo,
+	DefaultValue: false,
+	Name:         "runtime",
+	Usage:        "show how the current container was launched, from inside the container",
+}
+
 // --all
 var inspectAllFlag = cmdline.Flag{
 	ID:           "inspectAllFlag",
@@ -165,9 +198,12 @@ func init() {
 		cmdManager.RegisterFlagForCmd(&inspectLabelsFlag, InspectCmd)
 		cmdManager.RegisterFlagForCmd(&inspectRunscriptFlag, InspectCmd)
 		cmdManager.RegisterFlagForCmd(&inspectStartscriptFlag, InspectCmd)
+		cmdManager.RegisterFlagForCmd(&inspectShutdownscriptFlag, InspectCmd)
 		cmdManager.RegisterFlagForCmd(&inspectTestFlag, InspectCmd)
 		cmdManager.RegisterFlagForCmd(&inspectAppsListFlag, InspectCmd)
 		cmdManager.RegisterFlagForCmd(&inspectAllFlag, InspectCmd)
+		cmdManager.RegisterFlagForCmd(&inspectBuildLogFlag, InspectCmd)
+		cmdManager.RegisterFlagForCmd(&inspectRuntimeFlag, InspectCmd)
 	})
 }
 
@@ -308,6 +344,8 @@ func (c *command) setAttribute(section, value, file string) error {
 		}
 	case "startscript":
 		c.metadata.Data.Attributes.Startscript = value
+	case "shutdownscript":
+		c.metadata.Data.Attributes.Shutdownscript = value
 	case "environment":
 		if app != "" {
 			c.metadata.Data.Attributes.Apps[app].Environment[file] = value
@@ -461,6 +499,17 @@ func (c *command) addStartscriptCommand() {
 	}
 }
 
+func (c *command) addShutdownscriptCommand() {
+	if c.sifMetadata == nil {
+		c.addSingleFileCommand("shutdownscript", "shutdownscript")
+		return
+	}
+
+	if c.appName == "" {
+		c.metadata.Attributes.Shutdownscript = c.sifMetadata.Attributes.Shutdownscript
+	}
+}
+
 func (c *command) addTestCommand() {
 	if c.sifMetadata == nil {
 		c.addSingleFileCommand("test", "test")
@@ -586,6 +635,90 @@ func inspectDeffilePartition(img *image.Image) (string, error) {
 	return string(data), nil
 }
 
+// printBuildLog prints the build log stored in the image img.
+func printBuildLog(img *image.Image) error {
+	var r io.Reader
+
+	switch img.Type {
+	case image.SIF:
+		sr, err := image.NewSectionReader(img, buildlog.DescriptorName, -1)
+		if err == image.ErrNoSection {
+			return fmt.Errorf("no build log found in %s, it was not built with --build-log", img.Path)
+		} else if err != nil {
+			return fmt.Errorf("while reading build log: %s", err)
+		}
+		r = sr
+	case image.SANDBOX:
+		f, err := os.Open(filepath.Join(img.Path, buildlog.SandboxPath))
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no build log found in %s, it was not built with --build-log", img.Path)
+		} else if err != nil {
+			return fmt.Errorf("while reading build log: %s", err)
+		}
+		defer f.Close()
+		r = f
+	default:
+		return fmt.Errorf("build logs are only stored in SIF and sandbox images")
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("while decompressing build log: %s", err)
+	}
+	if _, err := io.Copy(os.Stdout, gz); err != nil {
+		return fmt.Errorf("while reading build log: %s", err)
+	}
+	return nil
+}
+
+// printRuntimeInfo prints the runtime information of the container
+// the command runs in.
+func printRuntimeInfo() error {
+	if _, err := os.Stat(inspect.RuntimeInfoPath); os.IsNotExist(err) {
+		return fmt.Errorf("no runtime information found, not running in a container or disabled by the administrator")
+	}
+	info, err := inspect.ReadRuntimeInfo()
+	if err != nil {
+		return err
+	}
+
+	if jsonfmt {
+		data, err := json.MarshalIndent(info, "", "\t")
+		if err != nil {
+			return fmt.Errorf("could not format runtime information as JSON: %s", err)
+		}
+		fmt.Printf("%s\n", data)
+		return nil
+	}
+
+	fmt.Printf("Singularity version: %s\n", info.Version)
+	fmt.Printf("Started: %s\n", info.Started.Local().Format(time.RFC3339))
+	fmt.Printf("Image: %s\n", info.Image)
+	if info.ImageDigest != "" {
+		fmt.Printf("Image digest: %s\n", info.ImageDigest)
+	}
+	if info.Instance != "" {
+		fmt.Printf("Instance: %s\n", info.Instance)
+	}
+	for _, b := range info.Binds {
+		bind := b.Source + ":" + b.Destination
+		if b.Options != "" {
+			bind += ":" + b.Options
+		}
+		fmt.Printf("Bind: %s\n", bind)
+	}
+	for _, o := range info.Overlays {
+		fmt.Printf("Overlay: %s\n", o)
+	}
+	fmt.Printf("Writable: %t\n", info.Writable)
+	fmt.Printf("Writable tmpfs: %t\n", info.WritableTmpfs)
+	fmt.Printf("Contain: %t\n", info.Contain)
+	fmt.Printf("Fakeroot: %t\n", info.Fakeroot)
+	fmt.Printf("Namespaces: %s\n", strings.Join(info.Namespaces, ", "))
+	fmt.Printf("Flags: %s\n", strings.Join(info.Flags, ", "))
+	return nil
+}
+
 func printSortedApp(m map[string]*inspect.AppAttributes) {
 	sorted := make([]string, 0, len(m))
 	for k := range m {
@@ -610,14 +743,19 @@ func printSortedMap(m map[string]string, fn func(key string)) {
 
 // returns true if flags for other forms of information are unset.
 func defaultToLabels() bool {
-	return !(helpfile || deffile || runscript || startscript || testfile || environment || listApps)
+	return !(helpfile || deffile || runscript || startscript || shutdownscript || testfile || environment || listApps)
 }
 
 // InspectCmd represents the 'inspect' command.
 // TODO: This should be in its own package, not cli.
 var InspectCmd = &cobra.Command{
 	DisableFlagsInUseLine: true,
-	Args:                  cobra.ExactArgs(1),
+	Args: func(cmd *cobra.Command, args []string) error {
+		if runtimeInfo {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
 
 	Use:     docs.InspectUse,
 	Short:   docs.InspectShort,
@@ -625,11 +763,25 @@ var InspectCmd = &cobra.Command{
 	Example: docs.InspectExample,
 
 	Run: func(cmd *cobra.Command, args []string) {
+		if runtimeInfo {
+			if err := printRuntimeInfo(); err != nil {
+				sylog.Fatalf("%s", err)
+			}
+			return
+		}
+
 		img, err := image.Init(args[0], false)
 		if err != nil {
 			sylog.Fatalf("Failed to open image %s: %s", args[0], err)
 		}
 
+		if buildLog {
+			if err := printBuildLog(img); err != nil {
+				sylog.Fatalf("%s", err)
+			}
+			return
+		}
+
 		if allData {
 			// display all data in JSON format only
 			jsonfmt = true
@@ -670,6 +822,13 @@ var InspectCmd = &cobra.Command{
 			}
 		}
 
+		if shutdownscript || allData {
+			if AppName == "" {
+				sylog.Debugf("Inspection of shutdownscript selected.")
+				inspectCmd.addShutdownscriptCommand()
+			}
+		}
+
 		if testfile || allData {
 			sylog.Debugf("Inspection of test selected.")
 			inspectCmd.addTestCommand()
@@ -720,6 +879,9 @@ var InspectCmd = &cobra.Command{
 			if inspectData.Data.Attributes.Startscript != "" {
 				fmt.Printf("%s\n", inspectData.Data.Attributes.Startscript)
 			}
+			if inspectData.Data.Attributes.Shutdownscript != "" {
+				fmt.Printf("%s\n", inspectData.Data.Attributes.Shutdownscript)
+			}
 			if inspectData.Data.Attributes.Test != "" {
 				fmt.Printf("%s\n", inspectData.Data.Attributes.Test)
 			} else if appAttr != nil && appAttr.Test != "" {
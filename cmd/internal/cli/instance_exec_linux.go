@@ -0,0 +1,48 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"strings"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterFlagForCmd(&actionCleanEnvFlag, instanceExecCmd)
+		cmdManager.RegisterFlagForCmd(&actionEnvFlag, instanceExecCmd)
+		cmdManager.RegisterFlagForCmd(&actionEnvFileFlag, instanceExecCmd)
+		cmdManager.RegisterFlagForCmd(&actionPwdFlag, instanceExecCmd)
+	})
+}
+
+// instanceURI returns the instance URI for the instance name argument,
+// the instance:// prefix being optional.
+func instanceURI(name string) string {
+	return "instance://" + strings.TrimPrefix(name, "instance://")
+}
+
+// singularity instance exec
+var instanceExecCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.MinimumNArgs(2),
+	ValidArgsFunction:     completeInstanceNames,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		actionPreRun(cmd, append([]string{instanceURI(args[0])}, args[1:]...))
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		a := append([]string{"/.singularity.d/actions/exec"}, args[1:]...)
+		execStarter(cmd, instanceURI(args[0]), a, "")
+	},
+
+	Use:     docs.InstanceExecUse,
+	Short:   docs.InstanceExecShort,
+	Long:    docs.InstanceExecLong,
+	Example: docs.InstanceExecExample,
+}
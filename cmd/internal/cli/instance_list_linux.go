@@ -20,6 +20,7 @@ func init() {
 		cmdManager.RegisterFlagForCmd(&instanceListUserFlag, instanceListCmd)
 		cmdManager.RegisterFlagForCmd(&instanceListJSONFlag, instanceListCmd)
 		cmdManager.RegisterFlagForCmd(&instanceListLogsFlag, instanceListCmd)
+		cmdManager.RegisterFlagForCmd(&instanceListAllFlag, instanceListCmd)
 	})
 }
 
@@ -60,6 +61,18 @@ var instanceListLogsFlag = cmdline.Flag{
 	EnvKeys:      []string{"LOGS"},
 }
 
+// -a|--all
+var instanceListAll bool
+var instanceListAllFlag = cmdline.Flag{
+	ID:           "instanceListAllFlag",
+	Value:        &instanceListAll,
+	DefaultValue: false,
+	Name:         "all",
+	ShortHand:    "a",
+	Usage:        "also list system instances",
+	EnvKeys:      []string{"ALL"},
+}
+
 // singularity instance list
 var instanceListCmd = &cobra.Command{
 	Args: cobra.RangeArgs(0, 1),
@@ -74,7 +87,7 @@ var instanceListCmd = &cobra.Command{
 			sylog.Fatalf("Only root user can list user's instances")
 		}
 
-		err := singularity.PrintInstanceList(os.Stdout, name, instanceListUser, instanceListJSON, instanceListLogs)
+		err := singularity.PrintInstanceList(os.Stdout, name, instanceListUser, instanceListAll, instanceListJSON, instanceListLogs)
 		if err != nil {
 			sylog.Fatalf("Could not list instances: %v", err)
 		}
@@ -6,6 +6,8 @@
 package cli
 
 import (
+	"os"
+
 	"github.com/hpcng/singularity/docs"
 	"github.com/hpcng/singularity/internal/app/singularity"
 	"github.com/hpcng/singularity/pkg/cmdline"
@@ -16,6 +18,13 @@ import (
 func init() {
 	addCmdInit(func(cmdManager *cmdline.CommandManager) {
 		cmdManager.RegisterFlagForCmd(&instanceStartPidFileFlag, instanceStartCmd)
+		cmdManager.RegisterFlagForCmd(&instanceStartSystemFlag, instanceStartCmd)
+		cmdManager.RegisterFlagForCmd(&instanceStartAllowUsersFlag, instanceStartCmd)
+		cmdManager.RegisterFlagForCmd(&instanceStartAllowGroupsFlag, instanceStartCmd)
+		cmdManager.RegisterFlagForCmd(&instanceStartReplicasFlag, instanceStartCmd)
+		cmdManager.RegisterFlagForCmd(&instanceStartParallelFlag, instanceStartCmd)
+		cmdManager.RegisterFlagForCmd(&instanceStartSSHDFlag, instanceStartCmd)
+		cmdManager.RegisterFlagForCmd(&instanceStartSSHDPortFlag, instanceStartCmd)
 	})
 }
 
@@ -30,6 +39,86 @@ var instanceStartPidFileFlag = cmdline.Flag{
 	EnvKeys:      []string{"PID_FILE"},
 }
 
+// --system
+var instanceStartSystem bool
+var instanceStartSystemFlag = cmdline.Flag{
+	ID:           "instanceStartSystemFlag",
+	Value:        &instanceStartSystem,
+	DefaultValue: false,
+	Name:         "system",
+	Usage:        "start a system instance visible to other users (root only)",
+	EnvKeys:      []string{"SYSTEM_INSTANCE"},
+}
+
+// --allow-users
+var instanceStartAllowUsers []string
+var instanceStartAllowUsersFlag = cmdline.Flag{
+	ID:           "instanceStartAllowUsersFlag",
+	Value:        &instanceStartAllowUsers,
+	DefaultValue: []string{},
+	Name:         "allow-users",
+	Usage:        "comma separated list of users allowed to join the system instance",
+	Tag:          "<users>",
+	EnvKeys:      []string{"ALLOW_USERS"},
+}
+
+// --allow-groups
+var instanceStartAllowGroups []string
+var instanceStartAllowGroupsFlag = cmdline.Flag{
+	ID:           "instanceStartAllowGroupsFlag",
+	Value:        &instanceStartAllowGroups,
+	DefaultValue: []string{},
+	Name:         "allow-groups",
+	Usage:        "comma separated list of groups allowed to join the system instance",
+	Tag:          "<groups>",
+	EnvKeys:      []string{"ALLOW_GROUPS"},
+}
+
+// --replicas
+var instanceStartReplicas int
+var instanceStartReplicasFlag = cmdline.Flag{
+	ID:           "instanceStartReplicasFlag",
+	Value:        &instanceStartReplicas,
+	DefaultValue: 0,
+	Name:         "replicas",
+	Usage:        "start N instances named after the instance name, holding a %d verb replaced by the replica number or suffixed with -<number>",
+	Tag:          "<N>",
+}
+
+// --parallel
+var instanceStartParallel int
+var instanceStartParallelFlag = cmdline.Flag{
+	ID:           "instanceStartParallelFlag",
+	Value:        &instanceStartParallel,
+	DefaultValue: 4,
+	Name:         "parallel",
+	Usage:        "maximum number of replicas started concurrently",
+	Tag:          "<N>",
+}
+
+// --sshd
+var instanceStartSSHD bool
+var instanceStartSSHDFlag = cmdline.Flag{
+	ID:           "instanceStartSSHDFlag",
+	Value:        &instanceStartSSHD,
+	DefaultValue: false,
+	Name:         "sshd",
+	Usage:        "start the OpenSSH or Dropbear server of the container accepting the public keys of the current user",
+	EnvKeys:      []string{"SSHD"},
+}
+
+// --sshd-port
+var instanceStartSSHDPort int
+var instanceStartSSHDPortFlag = cmdline.Flag{
+	ID:           "instanceStartSSHDPortFlag",
+	Value:        &instanceStartSSHDPort,
+	DefaultValue: 0,
+	Name:         "sshd-port",
+	Usage:        "port of the SSH server started with --sshd, a free port by default or 22 with a network namespace",
+	Tag:          "<port>",
+	EnvKeys:      []string{"SSHD_PORT"},
+}
+
 // singularity instance start
 var instanceStartCmd = &cobra.Command{
 	Args:                  cobra.MinimumNArgs(2),
@@ -39,16 +128,49 @@ var instanceStartCmd = &cobra.Command{
 		image := args[0]
 		name := args[1]
 
+		// replicas are started by executing this command again
+		// for each replica with its index set in environment
+		if index := singularity.InstanceReplicaIndex(); index > 0 {
+			name = singularity.InstanceReplicaName(name, index)
+		} else if instanceStartReplicas > 0 {
+			if instanceStartPidFile != "" {
+				sylog.Fatalf("--pid-file is not supported with --replicas")
+			}
+			if err := singularity.StartInstanceReplicas(os.Args[1:], name, instanceStartReplicas, instanceStartParallel); err != nil {
+				sylog.Fatalf("%s", err)
+			}
+			return
+		} else if instanceStartReplicas < 0 {
+			sylog.Fatalf("The number of replicas must be a positive number")
+		}
+
 		a := append([]string{"/.singularity.d/actions/start"}, args[2:]...)
 		setVM(cmd)
 		if VM {
 			execVM(cmd, image, a)
 			return
 		}
+		if instanceStartSSHD {
+			dir, err := singularity.PrepareInstanceSSHD(name, instanceStartSystem)
+			if err != nil {
+				sylog.Fatalf("While preparing SSH server: %s", err)
+			}
+			BindPaths = append(BindPaths, dir)
+		}
+
 		execStarter(cmd, image, a, name)
 
+		if instanceStartSSHD {
+			ssh, err := singularity.StartInstanceSSHD(name, instanceStartSystem, instanceStartSSHDPort)
+			if err != nil {
+				sylog.Errorf("Failed to start SSH server of %s instance: %s", name, err)
+			} else {
+				sylog.Infof("SSH server of %s instance started, connect with: %s", name, ssh)
+			}
+		}
+
 		if instanceStartPidFile != "" {
-			err := singularity.WriteInstancePidFile(name, instanceStartPidFile)
+			err := singularity.WriteInstancePidFile(name, instanceStartSystem, instanceStartPidFile)
 			if err != nil {
 				sylog.Warningf("Failed to write pid file: %v", err)
 			}
@@ -23,9 +23,12 @@ func init() {
 	addCmdInit(func(cmdManager *cmdline.CommandManager) {
 		cmdManager.RegisterFlagForCmd(&instanceStopUserFlag, instanceStopCmd)
 		cmdManager.RegisterFlagForCmd(&instanceStopAllFlag, instanceStopCmd)
+		cmdManager.RegisterFlagForCmd(&instanceStopSystemFlag, instanceStopCmd)
 		cmdManager.RegisterFlagForCmd(&instanceStopForceFlag, instanceStopCmd)
 		cmdManager.RegisterFlagForCmd(&instanceStopSignalFlag, instanceStopCmd)
 		cmdManager.RegisterFlagForCmd(&instanceStopTimeoutFlag, instanceStopCmd)
+		cmdManager.RegisterFlagForCmd(&instanceStopGracePeriodFlag, instanceStopCmd)
+		cmdManager.RegisterFlagForCmd(&instanceStopParallelFlag, instanceStopCmd)
 	})
 }
 
@@ -54,6 +57,17 @@ var instanceStopAllFlag = cmdline.Flag{
 	EnvKeys:      []string{"ALL"},
 }
 
+// --system
+var instanceStopSystem bool
+var instanceStopSystemFlag = cmdline.Flag{
+	ID:           "instanceStopSystemFlag",
+	Value:        &instanceStopSystem,
+	DefaultValue: false,
+	Name:         "system",
+	Usage:        "stop system instances (root only)",
+	EnvKeys:      []string{"SYSTEM_INSTANCE"},
+}
+
 // -f|--force
 var instanceStopForce bool
 var instanceStopForceFlag = cmdline.Flag{
@@ -90,6 +104,30 @@ var instanceStopTimeoutFlag = cmdline.Flag{
 	Usage:        "force kill non stopped instances after X seconds",
 }
 
+// --grace-period
+var instanceStopGracePeriod int
+var instanceStopGracePeriodFlag = cmdline.Flag{
+	ID:           "instanceStopGracePeriodFlag",
+	Value:        &instanceStopGracePeriod,
+	DefaultValue: 30,
+	Name:         "grace-period",
+	Usage:        "time in seconds given to the instance shutdown script before sending the signal, 0 skips the shutdown script",
+	Tag:          "<seconds>",
+	EnvKeys:      []string{"GRACE_PERIOD"},
+}
+
+// --parallel
+var instanceStopParallel int
+var instanceStopParallelFlag = cmdline.Flag{
+	ID:           "instanceStopParallelFlag",
+	Value:        &instanceStopParallel,
+	DefaultValue: 0,
+	Name:         "parallel",
+	Usage:        "maximum number of instance shutdown scripts run concurrently, 0 for no limit",
+	Tag:          "<N>",
+	EnvKeys:      []string{"STOP_PARALLEL"},
+}
+
 // singularity instance stop
 var instanceStopCmd = &cobra.Command{
 	Args:                  cobra.RangeArgs(0, 1),
@@ -103,6 +141,11 @@ var instanceStopCmd = &cobra.Command{
 		if instanceStopUser != "" && uid != 0 {
 			sylog.Fatalf("Only root user can stop user's instances")
 		}
+		if instanceStopSystem && uid != 0 {
+			sylog.Fatalf("Only root user can stop system instances")
+		} else if instanceStopSystem && instanceStopUser != "" {
+			sylog.Fatalf("--system and --user are mutually exclusive")
+		}
 
 		sig := syscall.SIGINT
 		if instanceStopSignal != "" {
@@ -112,8 +155,12 @@ var instanceStopCmd = &cobra.Command{
 				sylog.Fatalf("Could not convert stop signal: %s", err)
 			}
 		}
+		gracePeriod := time.Duration(instanceStopGracePeriod) * time.Second
 		if instanceStopForce {
 			sig = syscall.SIGKILL
+			gracePeriod = 0
+		} else if gracePeriod < 0 {
+			sylog.Fatalf("Grace period must be a positive number of seconds")
 		}
 
 		name := "*"
@@ -122,7 +169,7 @@ var instanceStopCmd = &cobra.Command{
 		}
 
 		timeout := time.Duration(instanceStopTimeout) * time.Second
-		return singularity.StopInstance(name, instanceStopUser, sig, timeout)
+		return singularity.StopInstance(name, instanceStopUser, instanceStopSystem, sig, gracePeriod, timeout, instanceStopParallel)
 	},
 
 	Use:     docs.InstanceStopUse,
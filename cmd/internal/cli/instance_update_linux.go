@@ -0,0 +1,92 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"os"
+	"strings"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/app/singularity"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterFlagForCmd(&instanceUpdateUserFlag, instanceUpdateCmd)
+		cmdManager.RegisterFlagForCmd(&instanceUpdateSystemFlag, instanceUpdateCmd)
+		cmdManager.RegisterFlagForCmd(&instanceUpdateUlimitFlag, instanceUpdateCmd)
+	})
+}
+
+// -u|--user
+var instanceUpdateUser string
+var instanceUpdateUserFlag = cmdline.Flag{
+	ID:           "instanceUpdateUserFlag",
+	Value:        &instanceUpdateUser,
+	DefaultValue: "",
+	Name:         "user",
+	ShortHand:    "u",
+	Usage:        "if running as root, update an instance belonging to user",
+	Tag:          "<username>",
+	EnvKeys:      []string{"USER"},
+}
+
+// --system
+var instanceUpdateSystem bool
+var instanceUpdateSystemFlag = cmdline.Flag{
+	ID:           "instanceUpdateSystemFlag",
+	Value:        &instanceUpdateSystem,
+	DefaultValue: false,
+	Name:         "system",
+	Usage:        "update a system instance (root only)",
+	EnvKeys:      []string{"SYSTEM_INSTANCE"},
+}
+
+// --ulimit
+var instanceUpdateUlimits []string
+var instanceUpdateUlimitFlag = cmdline.Flag{
+	ID:           "instanceUpdateUlimitFlag",
+	Value:        &instanceUpdateUlimits,
+	DefaultValue: []string{},
+	Name:         "ulimit",
+	Usage:        "set a resource limit of the instance processes, in the form name=soft[:hard] (e.g. nofile=4096:8192)",
+	Tag:          "<limit>",
+	EnvKeys:      []string{"ULIMIT"},
+}
+
+// singularity instance update
+var instanceUpdateCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	ValidArgsFunction:     completeInstanceNames,
+	Run: func(cmd *cobra.Command, args []string) {
+		uid := os.Getuid()
+		if instanceUpdateUser != "" && uid != 0 {
+			sylog.Fatalf("Only root user can update user's instances")
+		}
+		if instanceUpdateSystem && uid != 0 {
+			sylog.Fatalf("Only root user can update system instances")
+		} else if instanceUpdateSystem && instanceUpdateUser != "" {
+			sylog.Fatalf("--system and --user are mutually exclusive")
+		}
+		if len(instanceUpdateUlimits) == 0 {
+			sylog.Fatalf("You must specify --ulimit")
+		}
+
+		name := strings.TrimPrefix(args[0], "instance://")
+		if err := singularity.InstanceUpdate(name, instanceUpdateUser, instanceUpdateSystem, instanceUpdateUlimits); err != nil {
+			sylog.Fatalf("%s", err)
+		}
+	},
+
+	Use:     docs.InstanceUpdateUse,
+	Short:   docs.InstanceUpdateShort,
+	Long:    docs.InstanceUpdateLong,
+	Example: docs.InstanceUpdateExample,
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"strings"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/app/singularity"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterCmd(KernelSpecCmd)
+
+		cmdManager.RegisterFlagForCmd(&kernelSpecDisplayNameFlag, KernelSpecCmd)
+		cmdManager.RegisterFlagForCmd(&kernelSpecLanguageFlag, KernelSpecCmd)
+		cmdManager.RegisterFlagForCmd(&kernelSpecCmdFlag, KernelSpecCmd)
+		cmdManager.RegisterFlagForCmd(&kernelSpecBindFlag, KernelSpecCmd)
+		cmdManager.RegisterFlagForCmd(&kernelSpecNvidiaFlag, KernelSpecCmd)
+		cmdManager.RegisterFlagForCmd(&kernelSpecRocmFlag, KernelSpecCmd)
+		cmdManager.RegisterFlagForCmd(&kernelSpecPrefixFlag, KernelSpecCmd)
+		cmdManager.RegisterFlagForCmd(&kernelSpecForceFlag, KernelSpecCmd)
+	})
+}
+
+var kernelSpecOpts singularity.KernelSpecOptions
+
+// --display-name
+var kernelSpecDisplayNameFlag = cmdline.Flag{
+	ID:           "kernelSpecDisplayNameFlag",
+	Value:        &kernelSpecOpts.DisplayName,
+	DefaultValue: "",
+	Name:         "display-name",
+	Usage:        "kernel name shown by notebook servers, '<name> (<image>)' by default",
+	Tag:          "<name>",
+}
+
+// --language
+var kernelSpecLanguageFlag = cmdline.Flag{
+	ID:           "kernelSpecLanguageFlag",
+	Value:        &kernelSpecOpts.Language,
+	DefaultValue: "python",
+	Name:         "language",
+	Usage:        "kernel language",
+	Tag:          "<language>",
+}
+
+// --kernel-cmd
+var kernelSpecCmd string
+var kernelSpecCmdFlag = cmdline.Flag{
+	ID:           "kernelSpecCmdFlag",
+	Value:        &kernelSpecCmd,
+	DefaultValue: "python -m ipykernel_launcher -f {connection_file}",
+	Name:         "kernel-cmd",
+	Usage:        "kernel command line executed in the container, {connection_file} is replaced by the connection file path",
+	Tag:          "<command>",
+}
+
+// -B|--bind
+var kernelSpecBindFlag = cmdline.Flag{
+	ID:           "kernelSpecBindFlag",
+	Value:        &kernelSpecOpts.Binds,
+	DefaultValue: cmdline.StringArray{},
+	Name:         "bind",
+	ShortHand:    "B",
+	Usage:        "a user-bind path specification of the kernel container, see singularity exec --help",
+	Tag:          "<spec>",
+}
+
+// --nv
+var kernelSpecNvidiaFlag = cmdline.Flag{
+	ID:           "kernelSpecNvidiaFlag",
+	Value:        &kernelSpecOpts.Nvidia,
+	DefaultValue: false,
+	Name:         "nv",
+	Usage:        "enable Nvidia GPU support in the kernel container",
+}
+
+// --rocm
+var kernelSpecRocmFlag = cmdline.Flag{
+	ID:           "kernelSpecRocmFlag",
+	Value:        &kernelSpecOpts.Rocm,
+	DefaultValue: false,
+	Name:         "rocm",
+	Usage:        "enable AMD GPU support in the kernel container",
+}
+
+// --prefix
+var kernelSpecPrefixFlag = cmdline.Flag{
+	ID:           "kernelSpecPrefixFlag",
+	Value:        &kernelSpecOpts.Prefix,
+	DefaultValue: "",
+	Name:         "prefix",
+	Usage:        "install the kernel in <prefix>/share/jupyter/kernels instead of the user Jupyter directory",
+	Tag:          "<path>",
+}
+
+// -F|--force
+var kernelSpecForceFlag = cmdline.Flag{
+	ID:           "kernelSpecForceFlag",
+	Value:        &kernelSpecOpts.Force,
+	DefaultValue: false,
+	Name:         "force",
+	ShortHand:    "F",
+	Usage:        "overwrite an existing kernel",
+}
+
+// KernelSpecCmd is the 'kernelspec' command that allows to generate Jupyter kernels running in containers.
+var KernelSpecCmd = &cobra.Command{
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		kernelSpecOpts.Image = args[0]
+		kernelSpecOpts.Name = args[1]
+		kernelSpecOpts.KernelCmd = strings.Fields(kernelSpecCmd)
+
+		dir, err := singularity.InstallKernelSpec(kernelSpecOpts)
+		if err != nil {
+			sylog.Fatalf("%s", err)
+		}
+		sylog.Infof("Kernel %s installed in %s", kernelSpecOpts.Name, dir)
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:     docs.KernelSpecUse,
+	Short:   docs.KernelSpecShort,
+	Long:    docs.KernelSpecLong,
+	Example: docs.KernelSpecExample,
+}
@@ -84,6 +84,10 @@ func init() {
 		cmdManager.RegisterSubCmd(KeyCmd, KeyImportCmd)
 		cmdManager.RegisterSubCmd(KeyCmd, KeyRemoveCmd)
 		cmdManager.RegisterSubCmd(KeyCmd, KeyExportCmd)
+		cmdManager.RegisterSubCmd(KeyCmd, KeyServerCmd)
+		cmdManager.RegisterFlagForCmd(keyServerListenFlag, KeyServerCmd)
+		cmdManager.RegisterFlagForCmd(keyServerStoreFlag, KeyServerCmd)
+		cmdManager.RegisterFlagForCmd(keyServerReadOnlyFlag, KeyServerCmd)
 
 		cmdManager.RegisterFlagForCmd(&keyServerURIFlag, KeySearchCmd, KeyPushCmd, KeyPullCmd)
 		cmdManager.RegisterFlagForCmd(&keySearchLongListFlag, KeySearchCmd)
@@ -0,0 +1,99 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/pkg/keyserver"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/syfs"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+var (
+	keyServerListen     string
+	keyServerListenFlag = &cmdline.Flag{
+		ID:           "keyServerListenFlag",
+		Value:        &keyServerListen,
+		DefaultValue: "127.0.0.1:11371",
+		Name:         "listen",
+		Usage:        "address the key server listens on",
+		EnvKeys:      []string{"KEYSERVER_LISTEN"},
+	}
+
+	keyServerStore     string
+	keyServerStoreFlag = &cmdline.Flag{
+		ID:           "keyServerStoreFlag",
+		Value:        &keyServerStore,
+		DefaultValue: "",
+		Name:         "store",
+		Usage:        "directory storing the keys (default ~/.singularity/keyserver)",
+		EnvKeys:      []string{"KEYSERVER_STORE"},
+	}
+
+	keyServerReadOnly     bool
+	keyServerReadOnlyFlag = &cmdline.Flag{
+		ID:           "keyServerReadOnlyFlag",
+		Value:        &keyServerReadOnly,
+		DefaultValue: false,
+		Name:         "read-only",
+		Usage:        "reject key submissions, keys are added by copying their armored file to the store",
+	}
+
+	// KeyServerCmd is 'singularity key server' and serves public keys over HKP
+	KeyServerCmd = &cobra.Command{
+		Args:                  cobra.ExactArgs(0),
+		DisableFlagsInUseLine: true,
+		Run:                   runKeyServerCmd,
+		Use:                   docs.KeyServerUse,
+		Short:                 docs.KeyServerShort,
+		Long:                  docs.KeyServerLong,
+		Example:               docs.KeyServerExample,
+	}
+)
+
+func runKeyServerCmd(cmd *cobra.Command, args []string) {
+	dir := keyServerStore
+	if dir == "" {
+		dir = filepath.Join(syfs.ConfigDir(), "keyserver")
+	}
+	store, err := keyserver.NewStore(dir)
+	if err != nil {
+		sylog.Fatalf("%s", err)
+	}
+
+	srv := &http.Server{
+		Addr:              keyServerListen,
+		Handler:           &keyserver.Server{Store: store, ReadOnly: keyServerReadOnly},
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		<-c
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+		close(done)
+	}()
+
+	sylog.Infof("Serving keys of %s on %s", dir, keyServerListen)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		sylog.Fatalf("While serving keys: %s", err)
+	}
+	<-done
+}
@@ -7,6 +7,8 @@ package cli
 
 import (
 	"context"
+	"fmt"
+	"os"
 
 	"github.com/hpcng/singularity/docs"
 	"github.com/hpcng/singularity/internal/app/singularity"
@@ -86,6 +88,81 @@ var ociPidFileFlag = cmdline.Flag{
 	EnvKeys:      []string{"PID_FILE"},
 }
 
+// --parallel-hooks
+var ociParallelHooksFlag = cmdline.Flag{
+	ID:           "ociParallelHooksFlag",
+	Value:        &ociArgs.ParallelHooks,
+	DefaultValue: false,
+	Name:         "parallel-hooks",
+	Usage:        "execute the hooks of a lifecycle stage concurrently",
+	EnvKeys:      []string{"PARALLEL_HOOKS"},
+}
+
+// --ulimit
+var ociUlimitFlag = cmdline.Flag{
+	ID:           "ociUlimitFlag",
+	Value:        &ociArgs.Ulimits,
+	DefaultValue: []string{},
+	Name:         "ulimit",
+	Usage:        "override a container process resource limit, in the form name=soft[:hard] (e.g. nofile=4096:8192)",
+	Tag:          "<limit>",
+	EnvKeys:      []string{"ULIMIT"},
+}
+
+// --stdin
+var ociStdinFlag = cmdline.Flag{
+	ID:           "ociStdinFlag",
+	Value:        &ociArgs.StdinMode,
+	DefaultValue: "pipe",
+	Name:         "stdin",
+	Usage:        "container process stdin mode: pipe, close or attach (connected to the first attached client only)",
+	Tag:          "<mode>",
+	EnvKeys:      []string{"STDIN"},
+}
+
+// --stdin-file
+var ociStdinFileFlag = cmdline.Flag{
+	ID:           "ociStdinFileFlag",
+	Value:        &ociArgs.StdinPath,
+	DefaultValue: "",
+	Name:         "stdin-file",
+	Usage:        "redirect the container process stdin from a file",
+	Tag:          "<path>",
+	EnvKeys:      []string{"STDIN_FILE"},
+}
+
+// --attach-socket-mode
+var ociAttachSocketModeFlag = cmdline.Flag{
+	ID:           "ociAttachSocketModeFlag",
+	Value:        &ociArgs.AttachMode,
+	DefaultValue: "0600",
+	Name:         "attach-socket-mode",
+	Usage:        "octal permissions of the container attach socket",
+	Tag:          "<mode>",
+	EnvKeys:      []string{"ATTACH_SOCKET_MODE"},
+}
+
+// --attach-token
+var ociAttachTokenFlag = cmdline.Flag{
+	ID:           "ociAttachTokenFlag",
+	Value:        &ociArgs.AttachToken,
+	DefaultValue: false,
+	Name:         "attach-token",
+	Usage:        "require attach clients to send a token generated at container creation",
+	EnvKeys:      []string{"ATTACH_TOKEN"},
+}
+
+// --console-buffer
+var ociConsoleBufferFlag = cmdline.Flag{
+	ID:           "ociConsoleBufferFlag",
+	Value:        &ociArgs.ConsoleBuffer,
+	DefaultValue: uint32(0),
+	Name:         "console-buffer",
+	Usage:        "size in bytes of the recent terminal output replayed to attached clients, 0 replays the last line only",
+	Tag:          "<size>",
+	EnvKeys:      []string{"CONSOLE_BUFFER"},
+}
+
 // -s|--signal
 var ociKillSignalFlag = cmdline.Flag{
 	ID:           "ociKillSignalFlag",
@@ -119,6 +196,16 @@ var ociKillTimeoutFlag = cmdline.Flag{
 	Usage:        "timeout in second before killing container",
 }
 
+// -t|--timeout
+var ociProbeTimeoutFlag = cmdline.Flag{
+	ID:           "ociProbeTimeoutFlag",
+	Value:        &ociArgs.ProbeTimeout,
+	DefaultValue: uint32(0),
+	Name:         "timeout",
+	ShortHand:    "t",
+	Usage:        "timeout in second before killing the probe command (default 10)",
+}
+
 // -f|--from-file
 var ociUpdateFromFileFlag = cmdline.Flag{
 	ID:           "ociUpdateFromFileFlag",
@@ -130,6 +217,17 @@ var ociUpdateFromFileFlag = cmdline.Flag{
 	EnvKeys:      []string{"FROM_FILE"},
 }
 
+// --ulimit
+var ociUpdateUlimitFlag = cmdline.Flag{
+	ID:           "ociUpdateUlimitFlag",
+	Value:        &ociArgs.Ulimits,
+	DefaultValue: []string{},
+	Name:         "ulimit",
+	Usage:        "set a resource limit of the running container processes, in the form name=soft[:hard] (e.g. nofile=4096:8192)",
+	Tag:          "<limit>",
+	EnvKeys:      []string{"ULIMIT"},
+}
+
 func init() {
 	addCmdInit(func(cmdManager *cmdline.CommandManager) {
 		cmdManager.RegisterCmd(OciCmd)
@@ -141,6 +239,7 @@ func init() {
 		cmdManager.RegisterSubCmd(OciCmd, OciStateCmd)
 		cmdManager.RegisterSubCmd(OciCmd, OciAttachCmd)
 		cmdManager.RegisterSubCmd(OciCmd, OciExecCmd)
+		cmdManager.RegisterSubCmd(OciCmd, OciProbeCmd)
 		cmdManager.RegisterSubCmd(OciCmd, OciUpdateCmd)
 		cmdManager.RegisterSubCmd(OciCmd, OciPauseCmd)
 		cmdManager.RegisterSubCmd(OciCmd, OciResumeCmd)
@@ -155,11 +254,20 @@ func init() {
 		cmdManager.RegisterFlagForCmd(&ociLogPathFlag, createRunCmd...)
 		cmdManager.RegisterFlagForCmd(&ociLogFormatFlag, createRunCmd...)
 		cmdManager.RegisterFlagForCmd(&ociPidFileFlag, createRunCmd...)
+		cmdManager.RegisterFlagForCmd(&ociParallelHooksFlag, createRunCmd...)
+		cmdManager.RegisterFlagForCmd(&ociUlimitFlag, createRunCmd...)
+		cmdManager.RegisterFlagForCmd(&ociStdinFlag, createRunCmd...)
+		cmdManager.RegisterFlagForCmd(&ociStdinFileFlag, createRunCmd...)
+		cmdManager.RegisterFlagForCmd(&ociAttachSocketModeFlag, createRunCmd...)
+		cmdManager.RegisterFlagForCmd(&ociAttachTokenFlag, createRunCmd...)
+		cmdManager.RegisterFlagForCmd(&ociConsoleBufferFlag, createRunCmd...)
 		cmdManager.RegisterFlagForCmd(&ociCreateEmptyProcessFlag, OciCreateCmd)
 		cmdManager.RegisterFlagForCmd(&ociKillForceFlag, OciKillCmd)
 		cmdManager.RegisterFlagForCmd(&ociKillSignalFlag, OciKillCmd)
 		cmdManager.RegisterFlagForCmd(&ociKillTimeoutFlag, OciKillCmd)
+		cmdManager.RegisterFlagForCmd(&ociProbeTimeoutFlag, OciProbeCmd)
 		cmdManager.RegisterFlagForCmd(&ociUpdateFromFileFlag, OciUpdateCmd)
+		cmdManager.RegisterFlagForCmd(&ociUpdateUlimitFlag, OciUpdateCmd)
 		cmdManager.RegisterFlagForCmd(&ociSyncSocketFlag, OciStateCmd)
 	})
 }
@@ -308,6 +416,28 @@ var OciExecCmd = &cobra.Command{
 	Example: docs.OciExecExample,
 }
 
+// OciProbeCmd represents oci probe command.
+var OciProbeCmd = &cobra.Command{
+	Args:                  cobra.MinimumNArgs(2),
+	DisableFlagsInUseLine: true,
+	PreRun:                CheckRoot,
+	Run: func(cmd *cobra.Command, args []string) {
+		result, err := singularity.OciProbe(args[0], args[1:], int(ociArgs.ProbeTimeout))
+		if err != nil {
+			sylog.Fatalf("%s", err)
+		}
+		fmt.Print(result.Output)
+		if result.Error != "" {
+			sylog.Fatalf("Probe failed: %s", result.Error)
+		}
+		os.Exit(result.ExitCode)
+	},
+	Use:     docs.OciProbeUse,
+	Short:   docs.OciProbeShort,
+	Long:    docs.OciProbeLong,
+	Example: docs.OciProbeExample,
+}
+
 // OciUpdateCmd represents oci update command.
 var OciUpdateCmd = &cobra.Command{
 	Args:                  cobra.MinimumNArgs(1),
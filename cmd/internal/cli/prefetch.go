@@ -0,0 +1,262 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/pkg/buildcfg"
+	"github.com/hpcng/singularity/internal/pkg/cache"
+	"github.com/hpcng/singularity/internal/pkg/client/broadcast"
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	"github.com/hpcng/singularity/internal/pkg/util/uri"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+	"mvdan.cc/sh/v3/shell"
+)
+
+var (
+	// broadcastNodes is the list of nodes receiving the image.
+	broadcastNodes string
+	// broadcastFanout is the number of nodes each node forwards the image to.
+	broadcastFanout int
+	// broadcastRsh is the remote shell command used to reach the nodes.
+	broadcastRsh string
+	// prefetchReceive is set on the nodes receiving the image.
+	prefetchReceive bool
+	// prefetchDigest is the digest of the image received.
+	prefetchDigest string
+	// prefetchList is the file listing the images pulled into the cache.
+	prefetchList string
+	// prefetchJobs is the number of images pulled in parallel.
+	prefetchJobs int
+)
+
+// --nodes
+var broadcastNodesFlag = cmdline.Flag{
+	ID:           "broadcastNodesFlag",
+	Value:        &broadcastNodes,
+	DefaultValue: "",
+	Name:         "nodes",
+	Usage:        "comma separated list of nodes receiving the image at the same path, node ranges like node[01-16] are expanded",
+	EnvKeys:      []string{"PREFETCH_NODES"},
+}
+
+// --fanout
+var broadcastFanoutFlag = cmdline.Flag{
+	ID:           "broadcastFanoutFlag",
+	Value:        &broadcastFanout,
+	DefaultValue: broadcast.DefaultFanout,
+	Name:         "fanout",
+	Usage:        "number of nodes each node forwards the image to",
+	EnvKeys:      []string{"PREFETCH_FANOUT"},
+}
+
+// --rsh
+var broadcastRshFlag = cmdline.Flag{
+	ID:           "broadcastRshFlag",
+	Value:        &broadcastRsh,
+	DefaultValue: "ssh -o BatchMode=yes",
+	Name:         "rsh",
+	Usage:        "remote shell command used to reach the nodes",
+	EnvKeys:      []string{"PREFETCH_RSH"},
+}
+
+// --receive
+var prefetchReceiveFlag = cmdline.Flag{
+	ID:           "prefetchReceiveFlag",
+	Value:        &prefetchReceive,
+	DefaultValue: false,
+	Name:         "receive",
+	Usage:        "receive the image on the standard input",
+	Hidden:       true,
+}
+
+// --digest
+var prefetchDigestFlag = cmdline.Flag{
+	ID:           "prefetchDigestFlag",
+	Value:        &prefetchDigest,
+	DefaultValue: "",
+	Name:         "digest",
+	Usage:        "digest of the image received",
+	Hidden:       true,
+}
+
+// -l|--list
+var prefetchListFlag = cmdline.Flag{
+	ID:           "prefetchListFlag",
+	Value:        &prefetchList,
+	DefaultValue: "",
+	Name:         "list",
+	ShortHand:    "l",
+	Usage:        "file listing the image URIs to pull into the cache, one per line, - for the standard input",
+}
+
+// -j|--jobs
+var prefetchJobsFlag = cmdline.Flag{
+	ID:           "prefetchJobsFlag",
+	Value:        &prefetchJobs,
+	DefaultValue: 1,
+	Name:         "jobs",
+	ShortHand:    "j",
+	Usage:        "number of images pulled into the cache in parallel",
+	EnvKeys:      []string{"PREFETCH_JOBS"},
+}
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterCmd(PrefetchCmd)
+
+		cmdManager.RegisterFlagForCmd(&broadcastNodesFlag, PrefetchCmd)
+		cmdManager.RegisterFlagForCmd(&broadcastFanoutFlag, PrefetchCmd)
+		cmdManager.RegisterFlagForCmd(&broadcastRshFlag, PrefetchCmd)
+		cmdManager.RegisterFlagForCmd(&prefetchReceiveFlag, PrefetchCmd)
+		cmdManager.RegisterFlagForCmd(&prefetchDigestFlag, PrefetchCmd)
+		cmdManager.RegisterFlagForCmd(&prefetchListFlag, PrefetchCmd)
+		cmdManager.RegisterFlagForCmd(&prefetchJobsFlag, PrefetchCmd)
+
+		cmdManager.RegisterFlagForCmd(&commonForceFlag, PrefetchCmd)
+		cmdManager.RegisterFlagForCmd(&pullLibraryURIFlag, PrefetchCmd)
+		cmdManager.RegisterFlagForCmd(&commonNoHTTPSFlag, PrefetchCmd)
+		cmdManager.RegisterFlagForCmd(&commonTmpDirFlag, PrefetchCmd)
+		cmdManager.RegisterFlagForCmd(&pullDisableCacheFlag, PrefetchCmd)
+		cmdManager.RegisterFlagForCmd(&dockerUsernameFlag, PrefetchCmd)
+		cmdManager.RegisterFlagForCmd(&dockerPasswordFlag, PrefetchCmd)
+		cmdManager.RegisterFlagForCmd(&dockerLoginFlag, PrefetchCmd)
+		cmdManager.RegisterFlagForCmd(&pullArchFlag, PrefetchCmd)
+	})
+}
+
+// PrefetchCmd singularity prefetch
+var PrefetchCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ArbitraryArgs,
+	Run:                   prefetchRun,
+	Use:                   docs.PrefetchUse,
+	Short:                 docs.PrefetchShort,
+	Long:                  docs.PrefetchLong,
+	Example:               docs.PrefetchExample,
+}
+
+func prefetchRun(cmd *cobra.Command, args []string) {
+	ctx := cmd.Context()
+
+	// without nodes, images are pulled into the cache
+	if broadcastNodes == "" && !prefetchReceive {
+		if pullQuarantine() {
+			sylog.Fatalf("Pulled images are held in quarantine, images can't be pulled into the cache")
+		}
+		warmCache(cmd, args)
+		return
+	}
+	if len(args) == 0 {
+		sylog.Fatalf("An output file is required")
+	}
+
+	dest, err := filepath.Abs(args[0])
+	if err != nil {
+		sylog.Fatalf("While getting absolute path of %s: %s", args[0], err)
+	}
+
+	if prefetchReceive {
+		if len(args) != 1 || prefetchDigest == "" {
+			sylog.Fatalf("Receiving an image requires its digest and the output file only")
+		}
+		if err := broadcastConfig().Receive(ctx, os.Stdin, dest, prefetchDigest); err != nil {
+			sylog.Fatalf("While receiving image: %s", err)
+		}
+		return
+	}
+
+	if len(args) != 2 {
+		sylog.Fatalf("An output file and an image URI or path are required")
+	}
+
+	// local images are sent as is
+	source := args[1]
+	if transport, _ := uri.Split(source); transport == "" && fs.IsFile(source) {
+		broadcastImage(ctx, source, dest)
+		return
+	}
+
+	if _, err := os.Stat(dest); !os.IsNotExist(err) && !forceOverwrite {
+		sylog.Fatalf("Image file already exists: %q - will not overwrite", dest)
+	}
+
+	imgCache := getCacheHandle(cache.Config{Disable: disableCache})
+	if imgCache == nil {
+		sylog.Fatalf("Failed to create an image cache handle")
+	}
+	if pullQuarantine() {
+		quarantinePull(cmd, imgCache, dest, source)
+		return
+	}
+	pullImage(cmd, imgCache, dest, source)
+	broadcastImage(ctx, dest, dest)
+}
+
+// broadcastConfig returns the broadcast configuration set by the command
+// line flags, excluding the local node from the nodes.
+func broadcastConfig() *broadcast.Config {
+	nodes, err := broadcast.ExpandNodes(broadcastNodes)
+	if err != nil {
+		sylog.Fatalf("While parsing nodes: %s", err)
+	}
+	rsh, err := shell.Fields(broadcastRsh, nil)
+	if err != nil || len(rsh) == 0 {
+		sylog.Fatalf("Invalid remote shell command %q: %v", broadcastRsh, err)
+	}
+
+	local := make(map[string]bool)
+	if hostname, err := os.Hostname(); err == nil {
+		local[hostname] = true
+		local[strings.SplitN(hostname, ".", 2)[0]] = true
+	}
+	remote := nodes[:0]
+	for _, n := range nodes {
+		if !local[n] && n != "localhost" {
+			remote = append(remote, n)
+		}
+	}
+
+	return &broadcast.Config{
+		Nodes:   remote,
+		Fanout:  broadcastFanout,
+		Rsh:     rsh,
+		Command: filepath.Join(buildcfg.BINDIR, "singularity"),
+	}
+}
+
+// broadcastImage sends the image at path to the nodes set with --nodes,
+// where it's written at dest.
+func broadcastImage(ctx context.Context, path, dest string) {
+	dest, err := filepath.Abs(dest)
+	if err != nil {
+		sylog.Fatalf("While getting absolute path of %s: %s", dest, err)
+	}
+
+	// quarantined images are only broadcast once released
+	if pullQuarantine() {
+		if err := checkQuarantine(path); err != nil {
+			sylog.Fatalf("%s", err)
+		}
+	}
+
+	c := broadcastConfig()
+	if len(c.Nodes) == 0 {
+		sylog.Infof("No remote nodes to send the image to")
+		return
+	}
+	sylog.Infof("Sending image to %d node(s)", len(c.Nodes))
+	if err := c.Send(ctx, path, dest); err != nil {
+		sylog.Fatalf("While sending image: %s", err)
+	}
+}
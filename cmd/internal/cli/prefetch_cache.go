@@ -0,0 +1,140 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/hpcng/singularity/internal/pkg/cache"
+	"github.com/hpcng/singularity/internal/pkg/client/oci"
+	"github.com/hpcng/singularity/internal/pkg/util/uri"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+// readImageList returns the image URIs listed in r, one per line. Empty
+// lines and lines starting with # are ignored.
+func readImageList(r io.Reader) ([]string, error) {
+	var refs []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		refs = append(refs, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// prefetchRefs returns the image URIs passed as arguments and listed
+// in the file set with --list.
+func prefetchRefs(args []string) ([]string, error) {
+	refs := append([]string{}, args...)
+	if prefetchList == "" {
+		return refs, nil
+	}
+
+	var r io.Reader = os.Stdin
+	if prefetchList != "-" {
+		f, err := os.Open(prefetchList)
+		if err != nil {
+			return nil, fmt.Errorf("while opening image list: %s", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	list, err := readImageList(r)
+	if err != nil {
+		return nil, fmt.Errorf("while reading image list: %s", err)
+	}
+	return append(refs, list...), nil
+}
+
+// warmCache pulls the images passed as arguments or listed with --list
+// into the cache, using --jobs parallel pulls. Docker and OCI images are
+// converted one at a time as their conversion share the blob cache.
+func warmCache(cmd *cobra.Command, args []string) {
+	ctx := cmd.Context()
+
+	refs, err := prefetchRefs(args)
+	if err != nil {
+		sylog.Fatalf("%s", err)
+	}
+	if len(refs) == 0 {
+		sylog.Fatalf("No image URIs to pull into the cache")
+	}
+	for _, ref := range refs {
+		if t, _ := uri.Split(ref); t == "" || t == "instance" {
+			sylog.Fatalf("%s is not an image URI", ref)
+		}
+	}
+
+	imgCache := getCacheHandle(cache.Config{Disable: disableCache})
+	if imgCache == nil || imgCache.IsDisabled() {
+		sylog.Fatalf("The cache is disabled, images can't be pulled into the cache")
+	}
+
+	jobs := prefetchJobs
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		ociMu  sync.Mutex
+		failed []string
+	)
+	queue := make(chan string)
+
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ref := range queue {
+				t, _ := uri.Split(ref)
+				isOCI := oci.IsSupported(t) != ""
+				if isOCI {
+					ociMu.Lock()
+				}
+				sylog.Infof("Pulling %s into the cache", ref)
+				path, err := pullToCache(ctx, imgCache, cmd, ref)
+				if isOCI {
+					ociMu.Unlock()
+				}
+
+				if err != nil {
+					sylog.Errorf("While pulling %s: %s", ref, err)
+					mu.Lock()
+					failed = append(failed, ref)
+					mu.Unlock()
+					continue
+				}
+				sylog.Verbosef("Cached %s at %s", ref, path)
+			}
+		}()
+	}
+	for _, ref := range refs {
+		queue <- ref
+	}
+	close(queue)
+	wg.Wait()
+
+	if len(failed) > 0 {
+		sylog.Fatalf("%d of %d image(s) could not be pulled into the cache: %s", len(failed), len(refs), strings.Join(failed, ", "))
+	}
+	sylog.Infof("%d image(s) pulled into the cache", len(refs))
+}
@@ -0,0 +1,34 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadImageList(t *testing.T) {
+	list := `
+# base images
+library://alpine:latest
+  docker://ubuntu:20.04  
+
+oras://registry.example.com/app:1.0
+`
+	refs, err := readImageList(strings.NewReader(list))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []string{
+		"library://alpine:latest",
+		"docker://ubuntu:20.04",
+		"oras://registry.example.com/app:1.0",
+	}
+	if !reflect.DeepEqual(refs, expected) {
+		t.Errorf("got %v, expected %v", refs, expected)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/app/singularity"
+	"github.com/hpcng/singularity/internal/pkg/cache"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterCmd(pruneCmd)
+
+		cmdManager.RegisterFlagForCmd(&pruneDryRunFlag, pruneCmd)
+		cmdManager.RegisterFlagForCmd(&pruneDaysFlag, pruneCmd)
+		cmdManager.RegisterFlagForCmd(&commonTmpDirFlag, pruneCmd)
+	})
+}
+
+// -n|--dry-run
+var pruneDryRun bool
+var pruneDryRunFlag = cmdline.Flag{
+	ID:           "pruneDryRunFlag",
+	Value:        &pruneDryRun,
+	DefaultValue: false,
+	Name:         "dry-run",
+	ShortHand:    "n",
+	Usage:        "only report what would be removed",
+}
+
+// -D|--days
+var pruneDays int
+var pruneDaysFlag = cmdline.Flag{
+	ID:           "pruneDaysFlag",
+	Value:        &pruneDays,
+	DefaultValue: 30,
+	Name:         "days",
+	ShortHand:    "D",
+	Usage:        "remove cache entries older than specified number of days, a negative value keeps the cache",
+}
+
+// singularity prune
+var pruneCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(0),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := singularity.PruneOptions{
+			DryRun: pruneDryRun,
+			Days:   pruneDays,
+			TmpDir: tmpDir,
+		}
+		if err := singularity.Prune(getCacheHandle(cache.Config{}), opts); err != nil {
+			sylog.Fatalf("Prune failed: %v", err)
+		}
+	},
+
+	Use:     docs.PruneUse,
+	Short:   docs.PruneShort,
+	Long:    docs.PruneLong,
+	Example: docs.PruneExample,
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"os"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/app/singularity"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterCmd(psCmd)
+
+		cmdManager.RegisterFlagForCmd(&psUserFlag, psCmd)
+		cmdManager.RegisterFlagForCmd(&psJSONFlag, psCmd)
+	})
+}
+
+// -u|--user
+var psUser string
+var psUserFlag = cmdline.Flag{
+	ID:           "psUserFlag",
+	Value:        &psUser,
+	DefaultValue: "",
+	Name:         "user",
+	ShortHand:    "u",
+	Usage:        `if running as root, list containers from "<username>"`,
+	Tag:          "<username>",
+	EnvKeys:      []string{"USER"},
+}
+
+// -j|--json
+var psJSON bool
+var psJSONFlag = cmdline.Flag{
+	ID:           "psJSONFlag",
+	Value:        &psJSON,
+	DefaultValue: false,
+	Name:         "json",
+	ShortHand:    "j",
+	Usage:        "print structured json instead of list",
+	EnvKeys:      []string{"JSON"},
+}
+
+// singularity ps
+var psCmd = &cobra.Command{
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		if psUser != "" && os.Getuid() != 0 {
+			sylog.Fatalf("Only root user can list user's containers")
+		}
+
+		if err := singularity.PrintContainerList(os.Stdout, psUser, psJSON); err != nil {
+			sylog.Fatalf("Could not list containers: %v", err)
+		}
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:     docs.PsUse,
+	Short:   docs.PsShort,
+	Long:    docs.PsLong,
+	Example: docs.PsExample,
+}
@@ -12,13 +12,10 @@ import (
 	"runtime"
 
 	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/app/singularity"
 	"github.com/hpcng/singularity/internal/pkg/cache"
-	"github.com/hpcng/singularity/internal/pkg/client/library"
 	"github.com/hpcng/singularity/internal/pkg/client/net"
-	"github.com/hpcng/singularity/internal/pkg/client/oci"
-	"github.com/hpcng/singularity/internal/pkg/client/oras"
-	"github.com/hpcng/singularity/internal/pkg/client/shub"
-	"github.com/hpcng/singularity/internal/pkg/remote/endpoint"
+	"github.com/hpcng/singularity/internal/pkg/util/digest"
 	"github.com/hpcng/singularity/internal/pkg/util/uri"
 	"github.com/hpcng/singularity/pkg/cmdline"
 	"github.com/hpcng/singularity/pkg/sylog"
@@ -52,6 +49,13 @@ var (
 	// pullArch is the architecture for which containers will be pulled from the
 	// SCS library.
 	pullArch string
+	// pullDigest is the expected digest of images pulled from http(s) URIs.
+	pullDigest string
+	// pullHeaders are the headers added to the http(s) requests.
+	pullHeaders []string
+	// pullMaxRedirects is the maximum number of redirects followed
+	// for http(s) URIs.
+	pullMaxRedirects int
 )
 
 // --arch
@@ -129,6 +133,34 @@ var pullAllowUnauthenticatedFlag = cmdline.Flag{
 	Hidden:       true,
 }
 
+// --digest
+var pullDigestFlag = cmdline.Flag{
+	ID:           "pullDigestFlag",
+	Value:        &pullDigest,
+	DefaultValue: "",
+	Name:         "digest",
+	Usage:        "expected <algorithm>:<hex> digest (sha256, sha384 or sha512) of an image pulled from an http(s) URI",
+}
+
+// --header
+var pullHeaderFlag = cmdline.Flag{
+	ID:           "pullHeaderFlag",
+	Value:        &pullHeaders,
+	DefaultValue: cmdline.StringArray{},
+	Name:         "header",
+	Usage:        "add a 'Name: value' header to http(s) requests, like an authorization header (can be specified multiple times)",
+	EnvKeys:      []string{"PULL_HEADER"},
+}
+
+// --max-redirects
+var pullMaxRedirectsFlag = cmdline.Flag{
+	ID:           "pullMaxRedirectsFlag",
+	Value:        &pullMaxRedirects,
+	DefaultValue: net.DefaultMaxRedirects,
+	Name:         "max-redirects",
+	Usage:        "maximum number of redirects followed for http(s) URIs, 0 disables redirects",
+}
+
 func init() {
 	addCmdInit(func(cmdManager *cmdline.CommandManager) {
 		cmdManager.RegisterCmd(PullCmd)
@@ -149,6 +181,14 @@ func init() {
 		cmdManager.RegisterFlagForCmd(&pullAllowUnsignedFlag, PullCmd)
 		cmdManager.RegisterFlagForCmd(&pullAllowUnauthenticatedFlag, PullCmd)
 		cmdManager.RegisterFlagForCmd(&pullArchFlag, PullCmd)
+
+		cmdManager.RegisterFlagForCmd(&pullDigestFlag, PullCmd)
+		cmdManager.RegisterFlagForCmd(&pullHeaderFlag, PullCmd)
+		cmdManager.RegisterFlagForCmd(&pullMaxRedirectsFlag, PullCmd)
+
+		cmdManager.RegisterFlagForCmd(&broadcastNodesFlag, PullCmd)
+		cmdManager.RegisterFlagForCmd(&broadcastFanoutFlag, PullCmd)
+		cmdManager.RegisterFlagForCmd(&broadcastRshFlag, PullCmd)
 	})
 }
 
@@ -201,72 +241,60 @@ func pullRun(cmd *cobra.Command, args []string) {
 		}
 	}
 
-	switch transport {
-	case LibraryProtocol, "":
-		ref, err := library.NormalizeLibraryRef(pullFrom)
-		if err != nil {
-			sylog.Fatalf("Malformed library reference: %v", err)
-		}
+	if pullQuarantine() {
+		quarantinePull(cmd, imgCache, pullTo, pullFrom)
+		return
+	}
 
-		if pullLibraryURI != "" && ref.Host != "" {
-			sylog.Fatalf("Conflicting arguments; do not use --library with a library URI containing host name")
-		}
+	pullImage(cmd, imgCache, pullTo, pullFrom)
 
-		var libraryURI string
-		if pullLibraryURI != "" {
-			libraryURI = pullLibraryURI
-		} else if ref.Host != "" {
-			// override libraryURI if ref contains host name
-			libraryURI = "https://" + ref.Host
-		}
+	if broadcastNodes != "" {
+		broadcastImage(ctx, pullTo, pullTo)
+	}
+}
 
-		lc, err := getLibraryClientConfig(libraryURI)
-		if err != nil {
-			sylog.Fatalf("Unable to get library client configuration: %v", err)
-		}
-		co, err := getKeyserverClientOpts("", endpoint.KeyserverVerifyOp)
-		if err != nil {
-			sylog.Fatalf("Unable to get keyserver client configuration: %v", err)
-		}
+// quarantinePull pulls the image pullFrom to the quarantine directory, it is
+// moved to pullTo once released with the release command.
+func quarantinePull(cmd *cobra.Command, imgCache *cache.Handle, pullTo, pullFrom string) {
+	dir := quarantineDir()
+	tmp, err := singularity.QuarantineTempFile(dir)
+	if err != nil {
+		sylog.Fatalf("%s", err)
+	}
+	// the name is reserved in the private quarantine directory, the
+	// pull creates the file
+	os.Remove(tmp)
+	defer os.Remove(tmp)
 
-		_, err = library.PullToFile(ctx, imgCache, pullTo, ref, pullArch, tmpDir, lc, co)
-		if err != nil && err != library.ErrLibraryPullUnsigned {
-			sylog.Fatalf("While pulling library image: %v", err)
-		}
-		if err == library.ErrLibraryPullUnsigned {
-			sylog.Warningf("Skipping container verification")
-		}
-	case ShubProtocol:
-		_, err := shub.PullToFile(ctx, imgCache, pullTo, pullFrom, tmpDir, noHTTPS)
-		if err != nil {
-			sylog.Fatalf("While pulling shub image: %v\n", err)
-		}
-	case OrasProtocol:
-		ociAuth, err := makeDockerCredentials(cmd)
-		if err != nil {
-			sylog.Fatalf("Unable to make docker oci credentials: %s", err)
-		}
+	pullImage(cmd, imgCache, tmp, pullFrom)
 
-		_, err = oras.PullToFile(ctx, imgCache, pullTo, pullFrom, tmpDir, ociAuth)
-		if err != nil {
-			sylog.Fatalf("While pulling image from oci registry: %v", err)
-		}
-	case HTTPProtocol, HTTPSProtocol:
-		_, err := net.PullToFile(ctx, imgCache, pullTo, pullFrom, tmpDir)
-		if err != nil {
-			sylog.Fatalf("While pulling from image from http(s): %v\n", err)
-		}
-	case oci.IsSupported(transport):
-		ociAuth, err := makeDockerCredentials(cmd)
-		if err != nil {
-			sylog.Fatalf("While creating Docker credentials: %v", err)
-		}
+	e, err := singularity.QuarantineImage(dir, tmp, pullFrom, pullTo)
+	if err != nil {
+		sylog.Fatalf("While quarantining image: %s", err)
+	}
+	if broadcastNodes != "" {
+		sylog.Warningf("Image held in quarantine, not broadcasting it to %s", broadcastNodes)
+	}
+	sylog.Infof("Image %s held in quarantine, release it with 'singularity release %s'", e.ID, e.ID)
+}
 
-		_, err = oci.PullToFile(ctx, imgCache, pullTo, pullFrom, tmpDir, ociAuth, noHTTPS, buildArgs.noCleanUp)
-		if err != nil {
-			sylog.Fatalf("While making image from oci registry: %v", err)
-		}
-	default:
-		sylog.Fatalf("Unsupported transport type: %s", transport)
+// netOptions returns the options of http(s) pulls set by the command line.
+func netOptions() *net.Options {
+	headers, err := net.ParseHeaders(pullHeaders)
+	if err != nil {
+		sylog.Fatalf("While parsing headers: %s", err)
+	}
+	return &net.Options{
+		Digest:       pullDigest,
+		Algorithm:    digest.Configured(),
+		Headers:      headers,
+		MaxRedirects: pullMaxRedirects,
+	}
+}
+
+// pullImage pulls the image pullFrom to the file pullTo.
+func pullImage(cmd *cobra.Command, imgCache *cache.Handle, pullTo, pullFrom string) {
+	if err := transportPullToFile(cmd.Context(), imgCache, cmd, pullTo, pullFrom); err != nil {
+		sylog.Fatalf("While pulling %s: %v", pullFrom, err)
 	}
 }
@@ -8,16 +8,16 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 
 	"github.com/hpcng/singularity/docs"
 	"github.com/hpcng/singularity/internal/app/singularity"
-	"github.com/hpcng/singularity/internal/pkg/client/oras"
-	"github.com/hpcng/singularity/internal/pkg/remote/endpoint"
 	"github.com/hpcng/singularity/internal/pkg/util/uri"
 	"github.com/hpcng/singularity/pkg/cmdline"
 	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/transport"
 	"github.com/spf13/cobra"
 )
 
@@ -85,60 +85,21 @@ var PushCmd = &cobra.Command{
 
 		file, dest := args[0], args[1]
 
-		transport, ref := uri.Split(dest)
-		if transport == "" {
+		scheme, _ := uri.Split(dest)
+		if scheme == "" {
 			sylog.Fatalf("bad uri %s", dest)
 		}
 
-		switch transport {
-		case LibraryProtocol, "": // Handle pushing to a library
-			lc, err := getLibraryClientConfig(PushLibraryURI)
-			if err != nil {
-				sylog.Fatalf("Unable to get library client configuration: %v", err)
-			}
-
-			// Push to library requires a valid authToken
-			if lc.AuthToken == "" {
-				sylog.Fatalf("Cannot push image to library: %v", remoteWarning)
-			}
-
-			co, err := getKeyserverClientOpts("", endpoint.KeyserverVerifyOp)
-			if err != nil {
-				sylog.Fatalf("Unable to get keyserver client configuration: %v", err)
-			}
-
-			pushSpec := singularity.LibraryPushSpec{
-				SourceFile:    file,
-				DestRef:       dest,
-				Description:   pushDescription,
-				AllowUnsigned: unsignedPush,
-				FrontendURI:   URI(),
-			}
-
-			err = singularity.LibraryPush(ctx, pushSpec, lc, co)
-			if err == singularity.ErrLibraryUnsigned {
-				fmt.Printf("TIP: You can push unsigned images with 'singularity push -U %s'.\n", file)
-				fmt.Printf("TIP: Learn how to sign your own containers by using 'singularity help sign'\n\n")
-				sylog.Fatalf("Unable to upload container: unable to verify signature")
-				os.Exit(3)
-			} else if err != nil {
-				sylog.Fatalf("Unable to push image to library: %v", err)
-			}
-		case OrasProtocol:
-			if cmd.Flag(pushDescriptionFlag.Name).Changed {
-				sylog.Warningf("Description is not supported for push to oras. Ignoring it.")
-			}
-			ociAuth, err := makeDockerCredentials(cmd)
-			if err != nil {
-				sylog.Fatalf("Unable to make docker oci credentials: %s", err)
-			}
-
-			if err := oras.UploadImage(file, ref, ociAuth); err != nil {
-				sylog.Fatalf("Unable to push image to oci registry: %v", err)
-			}
-			sylog.Infof("Upload complete")
-		default:
-			sylog.Fatalf("Unsupported transport type: %s", transport)
+		err := transportPush(ctx, cmd, file, dest)
+		if errors.Is(err, singularity.ErrLibraryUnsigned) {
+			fmt.Printf("TIP: You can push unsigned images with 'singularity push -U %s'.\n", file)
+			fmt.Printf("TIP: Learn how to sign your own containers by using 'singularity help sign'\n\n")
+			sylog.Fatalf("Unable to upload container: unable to verify signature")
+			os.Exit(3)
+		} else if errors.Is(err, transport.ErrUnsupported) || errors.Is(err, errUnknownTransport) {
+			sylog.Fatalf("Unsupported transport type: %s", scheme)
+		} else if err != nil {
+			sylog.Fatalf("Unable to push image: %v", err)
 		}
 	},
 
@@ -0,0 +1,214 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/app/singularity"
+	"github.com/hpcng/singularity/internal/pkg/buildcfg"
+	"github.com/hpcng/singularity/internal/pkg/quarantine"
+	"github.com/hpcng/singularity/internal/pkg/remote/endpoint"
+	"github.com/hpcng/singularity/internal/pkg/util/digest"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/util/singularityconf"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterCmd(releaseCmd)
+
+		cmdManager.RegisterFlagForCmd(&releaseListFlag, releaseCmd)
+		cmdManager.RegisterFlagForCmd(&releaseDiscardFlag, releaseCmd)
+		cmdManager.RegisterFlagForCmd(&releaseApproveFlag, releaseCmd)
+		cmdManager.RegisterFlagForCmd(&releaseDestFlag, releaseCmd)
+		cmdManager.RegisterFlagForCmd(&releaseForceFlag, releaseCmd)
+		cmdManager.RegisterFlagForCmd(&verifyServerURIFlag, releaseCmd)
+	})
+}
+
+// -l|--list
+var releaseList bool
+var releaseListFlag = cmdline.Flag{
+	ID:           "releaseListFlag",
+	Value:        &releaseList,
+	DefaultValue: false,
+	Name:         "list",
+	ShortHand:    "l",
+	Usage:        "list the images held in quarantine",
+}
+
+// --discard
+var releaseDiscard bool
+var releaseDiscardFlag = cmdline.Flag{
+	ID:           "releaseDiscardFlag",
+	Value:        &releaseDiscard,
+	DefaultValue: false,
+	Name:         "discard",
+	Usage:        "remove the image from quarantine without releasing it",
+}
+
+// --approve
+var releaseApprove bool
+var releaseApproveFlag = cmdline.Flag{
+	ID:           "releaseApproveFlag",
+	Value:        &releaseApprove,
+	DefaultValue: false,
+	Name:         "approve",
+	Usage:        "check the image file against the quarantine policy and approve its release (root only)",
+}
+
+// --dest
+var releaseDest string
+var releaseDestFlag = cmdline.Flag{
+	ID:           "releaseDestFlag",
+	Value:        &releaseDest,
+	DefaultValue: "",
+	Name:         "dest",
+	Usage:        "release the image to this path instead of the pull destination",
+}
+
+// -F|--force
+var releaseForce bool
+var releaseForceFlag = cmdline.Flag{
+	ID:           "releaseForceFlag",
+	Value:        &releaseForce,
+	DefaultValue: false,
+	Name:         "force",
+	ShortHand:    "F",
+	Usage:        "overwrite an image file if it exists",
+}
+
+// singularity release
+var releaseCmd = &cobra.Command{
+	Args: func(cmd *cobra.Command, args []string) error {
+		if releaseList {
+			return cobra.ExactArgs(0)(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := quarantineDir()
+
+		switch {
+		case releaseList:
+			if err := listQuarantine(dir); err != nil {
+				sylog.Fatalf("While listing quarantined images: %s", err)
+			}
+		case releaseApprove:
+			d, err := singularity.Approve(cmd.Context(), args[0], quarantinePolicy())
+			if err != nil {
+				sylog.Fatalf("While approving image: %s", err)
+			}
+			sylog.Infof("Approved image %s with digest %s", args[0], d)
+		case releaseDiscard:
+			e, err := singularity.Discard(dir, args[0])
+			if err != nil {
+				sylog.Fatalf("While discarding image: %s", err)
+			}
+			sylog.Infof("Discarded quarantined image %s pulled from %s", e.ID, e.Source)
+		default:
+			e, err := singularity.Release(cmd.Context(), dir, args[0], releaseDest, releaseForce, quarantinePolicy())
+			if err != nil {
+				sylog.Fatalf("%s", err)
+			}
+			sylog.Infof("Released image %s to %s", e.ID, e.Dest)
+		}
+	},
+
+	Use:     docs.ReleaseUse,
+	Short:   docs.ReleaseShort,
+	Long:    docs.ReleaseLong,
+	Example: docs.ReleaseExample,
+}
+
+// pullQuarantine returns whether pulled images are held in quarantine.
+func pullQuarantine() bool {
+	c := singularityconf.GetCurrentConfig()
+	return c != nil && c.PullQuarantine
+}
+
+// quarantineDir returns the quarantine directory set in the configuration.
+func quarantineDir() string {
+	var dir string
+	if c := singularityconf.GetCurrentConfig(); c != nil {
+		dir = c.QuarantineDir
+	}
+	return singularity.QuarantineDir(dir)
+}
+
+// checkQuarantine returns an error if the image at path is held in quarantine.
+func checkQuarantine(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("while opening image %s: %s", path, err)
+	}
+	defer f.Close()
+
+	var dir string
+	if c := singularityconf.GetCurrentConfig(); c != nil {
+		dir = c.QuarantineDir
+	}
+	return quarantine.Check(f, path, dir, buildcfg.QUARANTINEDIR, digest.Configured())
+}
+
+// quarantinePolicy returns the policy set in the configuration that
+// quarantined images must pass to be released.
+func quarantinePolicy() singularity.QuarantinePolicy {
+	var p singularity.QuarantinePolicy
+
+	c := singularityconf.GetCurrentConfig()
+	if c == nil {
+		return p
+	}
+
+	if c.QuarantineScanCommand != "" {
+		if !filepath.IsAbs(c.QuarantineScanCommand) {
+			sylog.Fatalf("Quarantine scan command %s must be an absolute path", c.QuarantineScanCommand)
+		}
+		p.ScanCommand = c.QuarantineScanCommand
+	}
+
+	if c.QuarantineVerify {
+		co, err := getKeyserverClientOpts(keyServerURI, endpoint.KeyserverVerifyOp)
+		if err != nil {
+			sylog.Fatalf("Error while getting keyserver client config: %v", err)
+		}
+		p.Verify = true
+		p.VerifyOpts = []singularity.VerifyOpt{
+			singularity.OptVerifyUseKeyServer(co...),
+			singularity.OptVerifyHashWorkers(singularity.DefaultHashWorkers),
+			singularity.OptVerifyProgress(),
+		}
+	}
+	return p
+}
+
+// listQuarantine prints the images held in the quarantine directory dir.
+func listQuarantine(dir string) error {
+	entries, err := singularity.QuarantineList(dir)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Println("No image in quarantine")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tPULLED\tSOURCE\tDESTINATION")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", e.ID, e.Time.Format("2006-01-02 15:04:05"), e.Source, e.Dest)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/pkg/sandbox"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterCmd(SandboxCmd)
+		cmdManager.RegisterSubCmd(SandboxCmd, SandboxSnapshotCmd)
+		cmdManager.RegisterSubCmd(SandboxCmd, SandboxListCmd)
+		cmdManager.RegisterSubCmd(SandboxCmd, SandboxRollbackCmd)
+		cmdManager.RegisterSubCmd(SandboxCmd, SandboxDeleteCmd)
+
+		cmdManager.RegisterFlagForCmd(&sandboxSnapshotNameFlag, SandboxSnapshotCmd)
+		cmdManager.RegisterFlagForCmd(&sandboxJSONFlag, SandboxListCmd)
+	})
+}
+
+// -n|--name
+var sandboxSnapshotName string
+var sandboxSnapshotNameFlag = cmdline.Flag{
+	ID:           "sandboxSnapshotNameFlag",
+	Value:        &sandboxSnapshotName,
+	DefaultValue: "",
+	Name:         "name",
+	ShortHand:    "n",
+	Usage:        "name of the snapshot, the current date and time by default",
+	Tag:          "<name>",
+}
+
+// -j|--json
+var sandboxJSON bool
+var sandboxJSONFlag = cmdline.Flag{
+	ID:           "sandboxJSONFlag",
+	Value:        &sandboxJSON,
+	DefaultValue: false,
+	Name:         "json",
+	ShortHand:    "j",
+	Usage:        "print the snapshots in JSON format",
+}
+
+// SandboxCmd is the 'sandbox' command that allows to manage the snapshots
+// of sandbox directories.
+var SandboxCmd = &cobra.Command{
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("Invalid command")
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:     docs.SandboxUse,
+	Short:   docs.SandboxShort,
+	Long:    docs.SandboxLong,
+	Example: docs.SandboxExample,
+}
+
+// SandboxSnapshotCmd is the 'sandbox snapshot' command that allows to
+// take a snapshot of a sandbox.
+var SandboxSnapshotCmd = &cobra.Command{
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		s, err := sandbox.Create(args[0], sandboxSnapshotName)
+		if err != nil {
+			sylog.Fatalf("%s", err)
+		}
+		sylog.Infof("Snapshot %s of %s created", s.Name, args[0])
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:     docs.SandboxSnapshotUse,
+	Short:   docs.SandboxSnapshotShort,
+	Long:    docs.SandboxSnapshotLong,
+	Example: docs.SandboxSnapshotExample,
+}
+
+// SandboxListCmd is the 'sandbox list' command that allows to list the
+// snapshots of a sandbox.
+var SandboxListCmd = &cobra.Command{
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		snapshots, err := sandbox.List(args[0])
+		if err != nil {
+			sylog.Fatalf("%s", err)
+		}
+
+		if sandboxJSON {
+			if snapshots == nil {
+				snapshots = []*sandbox.Snapshot{}
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "\t")
+			if err := enc.Encode(snapshots); err != nil {
+				sylog.Fatalf("Could not encode snapshots: %s", err)
+			}
+			return
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tCREATED\tPATH")
+		for _, s := range snapshots {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Name, s.Created.Format("2006-01-02 15:04:05"), s.Path)
+		}
+		tw.Flush()
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:     docs.SandboxListUse,
+	Short:   docs.SandboxListShort,
+	Long:    docs.SandboxListLong,
+	Example: docs.SandboxListExample,
+}
+
+// SandboxRollbackCmd is the 'sandbox rollback' command that allows to
+// restore a sandbox from one of its snapshots.
+var SandboxRollbackCmd = &cobra.Command{
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := sandbox.Rollback(args[0], args[1]); err != nil {
+			sylog.Fatalf("%s", err)
+		}
+		sylog.Infof("%s rolled back to snapshot %s", args[0], args[1])
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:     docs.SandboxRollbackUse,
+	Short:   docs.SandboxRollbackShort,
+	Long:    docs.SandboxRollbackLong,
+	Example: docs.SandboxRollbackExample,
+}
+
+// SandboxDeleteCmd is the 'sandbox delete' command that allows to remove
+// snapshots of a sandbox.
+var SandboxDeleteCmd = &cobra.Command{
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		failed := false
+		for _, name := range args[1:] {
+			if err := sandbox.Remove(args[0], name); err != nil {
+				sylog.Errorf("%s", err)
+				failed = true
+				continue
+			}
+			sylog.Infof("Snapshot %s removed", name)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:     docs.SandboxDeleteUse,
+	Short:   docs.SandboxDeleteShort,
+	Long:    docs.SandboxDeleteLong,
+	Example: docs.SandboxDeleteExample,
+}
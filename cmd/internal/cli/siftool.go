@@ -1,4 +1,4 @@
-// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// Copyright (c) 2019-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -6,16 +6,79 @@
 package cli
 
 import (
+	"fmt"
+	"io"
+	"os"
+
 	"github.com/hpcng/sif/pkg/siftool"
+	"github.com/hpcng/singularity/docs"
 	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/image"
+	"github.com/spf13/cobra"
 )
 
 // SiftoolCmd is easily set since the sif repo allows the cobra.Command struct to be
 // easily accessed with Siftool(), we do not need to do anything but call that function.
 var SiftoolCmd = siftool.Siftool()
 
+var siftoolDumpStream bool
+
+// --stream
+var siftoolDumpStreamFlag = cmdline.Flag{
+	ID:           "siftoolDumpStreamFlag",
+	Value:        &siftoolDumpStream,
+	DefaultValue: false,
+	Name:         "stream",
+	Usage:        "stream a data object read forward only, arguments are <containerfile> ('-' for standard input) <rootfs|descriptorid|name>",
+}
+
 func init() {
 	addCmdInit(func(cmdManager *cmdline.CommandManager) {
 		cmdManager.RegisterCmd(SiftoolCmd)
+
+		for _, cmd := range SiftoolCmd.Commands() {
+			if cmd.Name() == "dump" {
+				addDumpStream(cmd)
+				cmdManager.RegisterFlagForCmd(&siftoolDumpStreamFlag, cmd)
+			}
+		}
 	})
 }
+
+// addDumpStream adds the streaming mode to the siftool dump command.
+func addDumpStream(cmd *cobra.Command) {
+	runE := cmd.RunE
+	cmd.DisableFlagsInUseLine = false
+	cmd.Long = docs.SifDumpLong
+	cmd.Example = docs.SifDumpExample
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if !siftoolDumpStream {
+			return runE(cmd, args)
+		}
+		return dumpStream(args[0], args[1])
+	}
+}
+
+// dumpStream writes the data object identified by object of the SIF image
+// at path, or read from the standard input if path is "-", to the standard
+// output.
+func dumpStream(path, object string) error {
+	r := io.Reader(os.Stdin)
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("while opening SIF image: %s", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	sr, d, err := image.NewSIFStreamReader(r, object)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(os.Stdout, sr, d.Filelen); err != nil {
+		return fmt.Errorf("while streaming data object %d: %s", d.ID, err)
+	}
+	return nil
+}
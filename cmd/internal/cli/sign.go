@@ -7,18 +7,27 @@ package cli
 
 import (
 	"fmt"
+	"io/ioutil"
+	"strings"
 
 	"github.com/hpcng/singularity/docs"
 	"github.com/hpcng/singularity/internal/app/singularity"
+	"github.com/hpcng/singularity/internal/pkg/signservice"
+	"github.com/hpcng/singularity/internal/pkg/util/digest"
 	"github.com/hpcng/singularity/pkg/cmdline"
 	"github.com/hpcng/singularity/pkg/sylog"
 	"github.com/hpcng/singularity/pkg/sypgp"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/openpgp"
 )
 
 var (
 	privKey int // -k encryption key (index from 'keys list') specification
 	signAll bool
+	// signServiceURL is the signing service creating the signatures.
+	signServiceURL string
+	// signTokenFile is the file holding the signing service token.
+	signTokenFile string
 )
 
 // -g|--group-id
@@ -82,6 +91,36 @@ var signAllFlag = cmdline.Flag{
 	Deprecated:   "now the default behavior",
 }
 
+// --hash-workers
+var signHashWorkersFlag = cmdline.Flag{
+	ID:           "signHashWorkersFlag",
+	Value:        &hashWorkers,
+	DefaultValue: singularity.DefaultHashWorkers,
+	Name:         "hash-workers",
+	Usage:        "number of concurrent reads used to hash large images, 1 disables reading ahead",
+	EnvKeys:      []string{"HASH_WORKERS"},
+}
+
+// --url
+var signServiceURLFlag = cmdline.Flag{
+	ID:           "signServiceURLFlag",
+	Value:        &signServiceURL,
+	DefaultValue: "",
+	Name:         "url",
+	Usage:        "sign with the key of the signing service at this URL, the key never leaves the service",
+	EnvKeys:      []string{"SIGNING_SERVICE_URL"},
+}
+
+// --token-file
+var signTokenFileFlag = cmdline.Flag{
+	ID:           "signTokenFileFlag",
+	Value:        &signTokenFile,
+	DefaultValue: "",
+	Name:         "token-file",
+	Usage:        "file holding the bearer token authenticating to the signing service",
+	EnvKeys:      []string{"SIGNING_SERVICE_TOKEN_FILE"},
+}
+
 func init() {
 	addCmdInit(func(cmdManager *cmdline.CommandManager) {
 		cmdManager.RegisterCmd(SignCmd)
@@ -92,17 +131,20 @@ func init() {
 		cmdManager.RegisterFlagForCmd(&signSifDescIDFlag, SignCmd)
 		cmdManager.RegisterFlagForCmd(&signKeyIdxFlag, SignCmd)
 		cmdManager.RegisterFlagForCmd(&signAllFlag, SignCmd)
+		cmdManager.RegisterFlagForCmd(&signHashWorkersFlag, SignCmd)
+		cmdManager.RegisterFlagForCmd(&signServiceURLFlag, SignCmd)
+		cmdManager.RegisterFlagForCmd(&signTokenFileFlag, SignCmd)
 	})
 }
 
 // SignCmd singularity sign
 var SignCmd = &cobra.Command{
 	DisableFlagsInUseLine: true,
-	Args:                  cobra.ExactArgs(1),
+	Args:                  cobra.MinimumNArgs(1),
 
 	Run: func(cmd *cobra.Command, args []string) {
-		// args[0] contains image path
-		doSignCmd(cmd, args[0])
+		// args contains image paths
+		doSignCmd(cmd, args)
 	},
 
 	Use:     docs.SignUse,
@@ -111,10 +153,32 @@ var SignCmd = &cobra.Command{
 	Example: docs.SignExample,
 }
 
-func doSignCmd(cmd *cobra.Command, cpath string) {
-	var opts []singularity.SignOpt
+// signEntity returns the entity signing the images, the key of the signing
+// service set with --url or the local key selected and decrypted once for
+// all images.
+func signEntity(cmd *cobra.Command) *openpgp.Entity {
+	if signServiceURL != "" {
+		if cmd.Flag(signKeyIdxFlag.Name).Changed {
+			sylog.Fatalf("--keyidx can't be used with a signing service")
+		}
+		if err := signservice.CheckURL(signServiceURL); err != nil {
+			sylog.Fatalf("%s", err)
+		}
+		token := ""
+		if signTokenFile != "" {
+			b, err := ioutil.ReadFile(signTokenFile)
+			if err != nil {
+				sylog.Fatalf("While reading signing service token: %s", err)
+			}
+			token = strings.TrimSpace(string(b))
+		}
+		e, err := signservice.NewClient(signServiceURL, token).Entity(cmd.Context())
+		if err != nil {
+			sylog.Fatalf("Failed to get signing service key: %s", err)
+		}
+		return e
+	}
 
-	// Set entity selector option, and ensure the entity is decrypted.
 	var f sypgp.EntitySelector
 	if cmd.Flag(signKeyIdxFlag.Name).Changed {
 		f = selectEntityAtIndex(privKey)
@@ -122,7 +186,18 @@ func doSignCmd(cmd *cobra.Command, cpath string) {
 		f = selectEntityInteractive()
 	}
 	f = decryptSelectedEntityInteractive(f)
-	opts = append(opts, singularity.OptSignEntitySelector(f))
+	e, err := sypgp.GetPrivateEntity(f)
+	if err != nil {
+		sylog.Fatalf("Failed to get signing key: %s", err)
+	}
+	return e
+}
+
+func doSignCmd(cmd *cobra.Command, paths []string) {
+	var opts []singularity.SignOpt
+
+	// Set entity option, the entity is selected once for all images.
+	opts = append(opts, singularity.OptSignEntity(signEntity(cmd)))
 
 	// Set group option, if applicable.
 	if cmd.Flag(signSifGroupIDFlag.Name).Changed || cmd.Flag(signOldSifGroupIDFlag.Name).Changed {
@@ -134,10 +209,27 @@ func doSignCmd(cmd *cobra.Command, cpath string) {
 		opts = append(opts, singularity.OptSignObjects(sifDescID))
 	}
 
-	// Sign the image.
-	fmt.Printf("Signing image: %s\n", cpath)
-	if err := singularity.Sign(cpath, opts...); err != nil {
-		sylog.Fatalf("Failed to sign container: %s", err)
+	opts = append(opts, singularity.OptSignHashWorkers(hashWorkers), singularity.OptSignProgress())
+
+	if a := digest.Configured(); a != digest.SHA256 {
+		sylog.Warningf("SIF signatures are computed with %s, the %s digest algorithm is not supported for signing", digest.SHA256, a)
+	}
+
+	// Sign the images, a failure doesn't stop signing the next images.
+	failed := 0
+	for _, cpath := range paths {
+		fmt.Printf("Signing image: %s\n", cpath)
+		if err := singularity.Sign(cpath, opts...); err != nil {
+			if len(paths) == 1 {
+				sylog.Fatalf("Failed to sign container: %s", err)
+			}
+			sylog.Errorf("Failed to sign container %s: %s", cpath, err)
+			failed++
+			continue
+		}
+		fmt.Printf("Signature created and applied to %s\n", cpath)
+	}
+	if failed > 0 {
+		sylog.Fatalf("Failed to sign %d of %d images", failed, len(paths))
 	}
-	fmt.Printf("Signature created and applied to %s\n", cpath)
 }
@@ -25,6 +25,7 @@ import (
 	"github.com/hpcng/singularity/internal/pkg/plugin"
 	"github.com/hpcng/singularity/internal/pkg/remote"
 	"github.com/hpcng/singularity/internal/pkg/remote/endpoint"
+	"github.com/hpcng/singularity/internal/pkg/syerror"
 	"github.com/hpcng/singularity/internal/pkg/util/fs"
 	"github.com/hpcng/singularity/pkg/cmdline"
 	clicallback "github.com/hpcng/singularity/pkg/plugin/callback/cli"
@@ -71,6 +72,8 @@ var (
 	verbose bool
 	quiet   bool
 
+	jsonErrors bool
+
 	configurationFile string
 )
 
@@ -205,6 +208,16 @@ var commonTmpDirFlag = cmdline.Flag{
 	EnvKeys:      []string{"TMPDIR"},
 }
 
+// --json-errors
+var singJSONErrorsFlag = cmdline.Flag{
+	ID:           "singJSONErrorsFlag",
+	Value:        &jsonErrors,
+	DefaultValue: false,
+	Name:         "json-errors",
+	Usage:        "report fatal errors as JSON objects with an error code, and exit with the exit code of the error",
+	EnvKeys:      []string{"JSON_ERRORS"},
+}
+
 // -c|--config
 var singConfigFileFlag = cmdline.Flag{
 	ID:           "singConfigFileFlag",
@@ -290,6 +303,9 @@ func handleConfDir(confDir string) {
 }
 
 func persistentPreRun(*cobra.Command, []string) {
+	if jsonErrors || syerror.Enabled() {
+		syerror.Enable()
+	}
 	setSylogMessageLevel()
 	sylog.Debugf("Singularity version: %s", buildcfg.PACKAGE_VERSION)
 
@@ -342,6 +358,7 @@ func Init(loadPlugins bool) {
 	cmdManager.RegisterFlagForCmd(&singQuietFlag, singularityCmd)
 	cmdManager.RegisterFlagForCmd(&singVerboseFlag, singularityCmd)
 	cmdManager.RegisterFlagForCmd(&singConfigFileFlag, singularityCmd)
+	cmdManager.RegisterFlagForCmd(&singJSONErrorsFlag, singularityCmd)
 
 	cmdManager.RegisterCmd(VersionCmd)
 
@@ -350,6 +367,9 @@ func Init(loadPlugins bool) {
 		cmdInit(cmdManager)
 	}
 
+	// plugin transports are registered on the first image lookup
+	loadTransportPlugins = loadPlugins
+
 	// load plugins and register commands/flags if any
 	if loadPlugins {
 		callbackType := (clicallback.Command)(nil)
@@ -428,6 +448,10 @@ func ExecuteSingularity() {
 	}()
 
 	if err := singularityCmd.ExecuteContext(ctx); err != nil {
+		if jsonErrors || syerror.Enabled() {
+			syerror.Exit(syerror.Classify(err.Error(), syerror.New(syerror.Usage, err)))
+		}
+
 		// Find the subcommand to display more useful help, and the correct
 		// subcommand name in messages - i.e. 'run' not 'singularity'
 		// This is required because we previously used ExecuteC that returns the
@@ -455,10 +479,11 @@ func ExecuteSingularity() {
 	}
 }
 
-// GenBashCompletion writes the bash completion file to w.
+// GenBashCompletion writes the bash completion file to w, the generated
+// script queries singularity for dynamic completions.
 func GenBashCompletion(w io.Writer) error {
 	Init(false)
-	return singularityCmd.GenBashCompletion(w)
+	return singularityCmd.GenBashCompletionV2(w, true)
 }
 
 // TraverseParentsUses walks the parent commands and outputs a properly formatted use string
@@ -0,0 +1,51 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"os"
+
+	"github.com/hpcng/singularity/internal/pkg/util/fs/tmpdir"
+	"github.com/hpcng/singularity/pkg/build/types"
+	"github.com/hpcng/singularity/pkg/util/singularityconf"
+)
+
+// squashfsRatio is the estimated compression ratio of squashfs images,
+// used to estimate the space required to extract them.
+const squashfsRatio = 3
+
+// newTmpDirManager returns a temporary directory manager using dir if
+// not empty or selecting a directory among the candidates configured in
+// singularity.conf otherwise.
+func newTmpDirManager(dir string) *tmpdir.Manager {
+	config := tmpdir.Config{Dir: dir}
+	if c := singularityconf.GetCurrentConfig(); c != nil {
+		config.Candidates = c.TmpDirCandidates
+		config.Quota = int64(c.MaxTmpDirSize) << 20
+	}
+	return tmpdir.New(config)
+}
+
+// estimateBuildSize returns the estimated space required to build the
+// definitions, 0 if it can't be estimated. Only builds from local images
+// can be estimated, using the size of the images.
+func estimateBuildSize(defs []types.Definition) int64 {
+	var size int64
+
+	for _, d := range defs {
+		if d.Header["bootstrap"] != "localimage" {
+			return 0
+		}
+		fi, err := os.Stat(d.Header["from"])
+		if err != nil {
+			return 0
+		}
+		// the root filesystem is extracted and
+		// packed again in the temporary directory
+		size += fi.Size() * (squashfsRatio + 1)
+	}
+	return size
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"os"
+	"time"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/app/singularity"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterCmd(topCmd)
+
+		cmdManager.RegisterFlagForCmd(&topUserFlag, topCmd)
+		cmdManager.RegisterFlagForCmd(&topIntervalFlag, topCmd)
+		cmdManager.RegisterFlagForCmd(&topBatchFlag, topCmd)
+		cmdManager.RegisterFlagForCmd(&topIterationsFlag, topCmd)
+	})
+}
+
+// -u|--user
+var topUser string
+var topUserFlag = cmdline.Flag{
+	ID:           "topUserFlag",
+	Value:        &topUser,
+	DefaultValue: "",
+	Name:         "user",
+	ShortHand:    "u",
+	Usage:        `if running as root, show containers from "<username>"`,
+	Tag:          "<username>",
+}
+
+// -d|--interval
+var topInterval string
+var topIntervalFlag = cmdline.Flag{
+	ID:           "topIntervalFlag",
+	Value:        &topInterval,
+	DefaultValue: "1s",
+	Name:         "interval",
+	ShortHand:    "d",
+	Usage:        "refresh interval of the view",
+	Tag:          "<duration>",
+}
+
+// -b|--batch
+var topBatch bool
+var topBatchFlag = cmdline.Flag{
+	ID:           "topBatchFlag",
+	Value:        &topBatch,
+	DefaultValue: false,
+	Name:         "batch",
+	ShortHand:    "b",
+	Usage:        "print the view at each refresh interval without interaction, the default when the standard input is not a terminal",
+}
+
+// -n|--iterations
+var topIterations int
+var topIterationsFlag = cmdline.Flag{
+	ID:           "topIterationsFlag",
+	Value:        &topIterations,
+	DefaultValue: 0,
+	Name:         "iterations",
+	ShortHand:    "n",
+	Usage:        "number of views printed in batch mode before exiting, 0 for no limit",
+}
+
+// singularity top
+var topCmd = &cobra.Command{
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		if topUser != "" && os.Getuid() != 0 {
+			sylog.Fatalf("Only root user can show user's containers")
+		}
+		interval, err := time.ParseDuration(topInterval)
+		if err != nil {
+			sylog.Fatalf("Invalid interval %s: %s", topInterval, err)
+		}
+
+		opts := singularity.TopOptions{
+			Username:   topUser,
+			Interval:   interval,
+			Batch:      topBatch,
+			Iterations: topIterations,
+		}
+		if err := singularity.Top(cmd.Context(), opts); err != nil {
+			sylog.Fatalf("%s", err)
+		}
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:     docs.TopUse,
+	Short:   docs.TopShort,
+	Long:    docs.TopLong,
+	Example: docs.TopExample,
+}
@@ -0,0 +1,527 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/containers/image/v5/transports"
+	ocitypes "github.com/containers/image/v5/types"
+	"github.com/hpcng/singularity/internal/app/singularity"
+	"github.com/hpcng/singularity/internal/pkg/build"
+	"github.com/hpcng/singularity/internal/pkg/cache"
+	"github.com/hpcng/singularity/internal/pkg/client/library"
+	"github.com/hpcng/singularity/internal/pkg/client/net"
+	"github.com/hpcng/singularity/internal/pkg/client/oci"
+	"github.com/hpcng/singularity/internal/pkg/client/oras"
+	"github.com/hpcng/singularity/internal/pkg/client/shub"
+	"github.com/hpcng/singularity/internal/pkg/plugin"
+	"github.com/hpcng/singularity/internal/pkg/remote/endpoint"
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	"github.com/hpcng/singularity/internal/pkg/util/uri"
+	clicallback "github.com/hpcng/singularity/pkg/plugin/callback/cli"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/transport"
+	"github.com/spf13/cobra"
+	libclient "github.com/sylabs/scs-library-client/client"
+)
+
+// errUnknownTransport is returned for the image references without
+// registered transport.
+var errUnknownTransport = errors.New("unsupported transport type")
+
+// cachePuller is implemented by the builtin transports, which pull through
+// the cache of their client package.
+type cachePuller interface {
+	// pullToCache pulls the image ref to the cache and returns its path.
+	pullToCache(ctx context.Context, imgCache *cache.Handle, ref string) (string, error)
+	// pullToFile pulls the image ref to the file dest through the cache.
+	pullToFile(ctx context.Context, imgCache *cache.Handle, dest, ref string) error
+}
+
+// commandKey is the context key of the command running a transport.
+type commandKey struct{}
+
+// withCommand returns ctx holding the command cmd, the builtin transports
+// read their options from its flags.
+func withCommand(ctx context.Context, cmd *cobra.Command) context.Context {
+	return context.WithValue(ctx, commandKey{}, cmd)
+}
+
+// dockerCredentials returns the docker credentials set by the flags of the
+// command of ctx.
+func dockerCredentials(ctx context.Context) (*ocitypes.DockerAuthConfig, error) {
+	cmd, ok := ctx.Value(commandKey{}).(*cobra.Command)
+	if !ok {
+		return nil, nil
+	}
+	return makeDockerCredentials(cmd)
+}
+
+var (
+	registerTransportsOnce sync.Once
+	// loadTransportPlugins is set when the plugins are loaded by Init.
+	loadTransportPlugins bool
+)
+
+// registerTransports registers the builtin transports and the transports
+// added by the plugins.
+func registerTransports() {
+	builtins := map[string]transport.Transport{
+		uri.Library: libraryTransport{},
+		uri.Shub:    shubTransport{},
+		uri.Oras:    orasTransport{},
+		uri.HTTP:    netTransport{},
+		uri.HTTPS:   netTransport{},
+	}
+	for _, name := range transports.ListNames() {
+		builtins[name] = ociTransport{}
+	}
+	for scheme, t := range builtins {
+		if err := transport.Register(scheme, t); err != nil {
+			sylog.Fatalf("While registering transport: %s", err)
+		}
+	}
+
+	if !loadTransportPlugins {
+		return
+	}
+	callbackType := (clicallback.Transport)(nil)
+	callbacks, err := plugin.LoadCallbacks(callbackType)
+	if err != nil {
+		sylog.Fatalf("Failed to load plugins callbacks '%T': %s", callbackType, err)
+	}
+	for _, c := range callbacks {
+		for scheme, t := range c.(clicallback.Transport)() {
+			if err := transport.Register(scheme, t); err != nil {
+				sylog.Warningf("Ignoring plugin transport: %s", err)
+			}
+		}
+	}
+}
+
+// lookupTransport returns the transport of the image reference ref,
+// references without scheme are library references.
+func lookupTransport(ref string) (string, transport.Transport, error) {
+	registerTransportsOnce.Do(registerTransports)
+
+	scheme, _ := uri.Split(ref)
+	if scheme == "" {
+		scheme = uri.Library
+	}
+	t := transport.Get(scheme)
+	if t == nil {
+		return scheme, nil, fmt.Errorf("%w: %s", errUnknownTransport, scheme)
+	}
+	return scheme, t, nil
+}
+
+// transportPullToCache pulls the image ref to the cache with its transport
+// and returns the path of the image.
+func transportPullToCache(ctx context.Context, imgCache *cache.Handle, cmd *cobra.Command, ref string) (string, error) {
+	scheme, t, err := lookupTransport(ref)
+	if err != nil {
+		return "", err
+	}
+	ctx = withCommand(ctx, cmd)
+	if p, ok := t.(cachePuller); ok {
+		return p.pullToCache(ctx, imgCache, ref)
+	}
+	return fetchToCache(ctx, imgCache, scheme, t, ref)
+}
+
+// transportPullToFile pulls the image ref to the file dest with its
+// transport, through the cache.
+func transportPullToFile(ctx context.Context, imgCache *cache.Handle, cmd *cobra.Command, dest, ref string) error {
+	scheme, t, err := lookupTransport(ref)
+	if err != nil {
+		return err
+	}
+	ctx = withCommand(ctx, cmd)
+	if p, ok := t.(cachePuller); ok {
+		return p.pullToFile(ctx, imgCache, dest, ref)
+	}
+
+	if imgCache.IsDisabled() {
+		return fetch(ctx, t, ref, dest)
+	}
+	src, err := fetchToCache(ctx, imgCache, scheme, t, ref)
+	if err != nil {
+		return err
+	}
+	// mode is before umask if dest doesn't exist
+	if err := fs.CopyFileAtomic(src, dest, 0777); err != nil {
+		return fmt.Errorf("error copying image out of cache: %v", err)
+	}
+	return nil
+}
+
+// transportPush pushes the SIF image path to ref with its transport.
+func transportPush(ctx context.Context, cmd *cobra.Command, path, ref string) error {
+	_, t, err := lookupTransport(ref)
+	if err != nil {
+		return err
+	}
+	return t.Push(withCommand(ctx, cmd), path, ref)
+}
+
+// fetch resolves ref and fetches it with the transport t to path.
+func fetch(ctx context.Context, t transport.Transport, ref, path string) error {
+	resolved, err := t.Resolve(ctx, ref)
+	if err != nil {
+		return fmt.Errorf("while resolving %s: %s", ref, err)
+	}
+	sylog.Infof("Fetching %s", resolved)
+	if err := t.Fetch(ctx, resolved, path); err != nil {
+		return fmt.Errorf("while fetching %s: %s", resolved, err)
+	}
+	return nil
+}
+
+// fetchToCache fetches the image ref with the transport t of scheme to the
+// transport cache, keyed by the image digest. Images without digest are
+// fetched to a temporary file.
+func fetchToCache(ctx context.Context, imgCache *cache.Handle, scheme string, t transport.Transport, ref string) (string, error) {
+	resolved, err := t.Resolve(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("while resolving %s: %s", ref, err)
+	}
+	digest, err := t.Digest(ctx, resolved)
+	if err != nil {
+		return "", fmt.Errorf("while getting digest of %s: %s", resolved, err)
+	}
+
+	if imgCache.IsDisabled() || digest == "" {
+		file, err := ioutil.TempFile(tmpDir, "sbuild-tmp-cache-")
+		if err != nil {
+			return "", fmt.Errorf("unable to create tmp file: %v", err)
+		}
+		file.Close()
+		if err := fetch(ctx, t, resolved, file.Name()); err != nil {
+			os.Remove(file.Name())
+			return "", err
+		}
+		return file.Name(), nil
+	}
+
+	key := scheme + "_" + strings.NewReplacer("/", "_", ":", "_").Replace(digest)
+	cacheEntry, err := imgCache.GetEntry(cache.TransportCacheType, key)
+	if err != nil {
+		return "", fmt.Errorf("unable to check if %v exists in cache: %v", key, err)
+	}
+	defer cacheEntry.CleanTmp()
+	if cacheEntry.Exists {
+		sylog.Infof("Using cached image")
+		return cacheEntry.Path, nil
+	}
+	if err := fetch(ctx, t, resolved, cacheEntry.TmpPath); err != nil {
+		return "", err
+	}
+	if err := cacheEntry.Finalize(); err != nil {
+		return "", err
+	}
+	return cacheEntry.Path, nil
+}
+
+// libraryTransport is the transport of the library:// references.
+type libraryTransport struct{}
+
+// libraryRef returns the normalized library reference of ref and the
+// client configuration of its library.
+func (libraryTransport) libraryRef(ref, libraryURI string) (*libclient.Ref, *libclient.Config, error) {
+	r, err := library.NormalizeLibraryRef(ref)
+	if err != nil {
+		return nil, nil, fmt.Errorf("malformed library reference: %v", err)
+	}
+	if libraryURI != "" && r.Host != "" {
+		return nil, nil, fmt.Errorf("conflicting arguments; do not use --library with a library URI containing host name")
+	}
+	if libraryURI == "" && r.Host != "" {
+		// override libraryURI if ref contains host name
+		libraryURI = "https://" + r.Host
+	}
+	c, err := getLibraryClientConfig(libraryURI)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get library client configuration: %v", err)
+	}
+	return r, c, nil
+}
+
+func (t libraryTransport) Resolve(ctx context.Context, ref string) (string, error) {
+	r, err := library.NormalizeLibraryRef(ref)
+	if err != nil {
+		return "", err
+	}
+	return r.String(), nil
+}
+
+func (t libraryTransport) Digest(ctx context.Context, ref string) (string, error) {
+	r, c, err := t.libraryRef(ref, pullLibraryURI)
+	if err != nil {
+		return "", err
+	}
+	lc, err := libclient.NewClient(c)
+	if err != nil {
+		return "", fmt.Errorf("unable to initialize client library: %v", err)
+	}
+	img, err := lc.GetImage(ctx, pullArch, r.Path+":"+r.Tags[0])
+	if err != nil {
+		return "", err
+	}
+	return img.Hash, nil
+}
+
+func (t libraryTransport) Fetch(ctx context.Context, ref, path string) error {
+	r, c, err := t.libraryRef(ref, pullLibraryURI)
+	if err != nil {
+		return err
+	}
+	lc, err := libclient.NewClient(c)
+	if err != nil {
+		return fmt.Errorf("unable to initialize client library: %v", err)
+	}
+	return library.DownloadImageNoProgress(ctx, lc, path, pullArch, r)
+}
+
+func (t libraryTransport) Push(ctx context.Context, path, ref string) error {
+	lc, err := getLibraryClientConfig(PushLibraryURI)
+	if err != nil {
+		return fmt.Errorf("unable to get library client configuration: %v", err)
+	}
+	// Push to library requires a valid authToken
+	if lc.AuthToken == "" {
+		return fmt.Errorf("cannot push image to library: %v", remoteWarning)
+	}
+	co, err := getKeyserverClientOpts("", endpoint.KeyserverVerifyOp)
+	if err != nil {
+		return fmt.Errorf("unable to get keyserver client configuration: %v", err)
+	}
+
+	pushSpec := singularity.LibraryPushSpec{
+		SourceFile:    path,
+		DestRef:       ref,
+		Description:   pushDescription,
+		AllowUnsigned: unsignedPush,
+		FrontendURI:   URI(),
+	}
+	return singularity.LibraryPush(ctx, pushSpec, lc, co)
+}
+
+func (t libraryTransport) pullToCache(ctx context.Context, imgCache *cache.Handle, ref string) (string, error) {
+	// Default "" = use current remote endpoint
+	r, c, err := t.libraryRef(ref, "")
+	if err != nil {
+		return "", err
+	}
+	return library.Pull(ctx, imgCache, r, runtime.GOARCH, tmpDir, c)
+}
+
+func (t libraryTransport) pullToFile(ctx context.Context, imgCache *cache.Handle, dest, ref string) error {
+	r, c, err := t.libraryRef(ref, pullLibraryURI)
+	if err != nil {
+		return err
+	}
+	co, err := getKeyserverClientOpts("", endpoint.KeyserverVerifyOp)
+	if err != nil {
+		return fmt.Errorf("unable to get keyserver client configuration: %v", err)
+	}
+
+	_, err = library.PullToFile(ctx, imgCache, dest, r, pullArch, tmpDir, c, co)
+	if err == library.ErrLibraryPullUnsigned {
+		sylog.Warningf("Skipping container verification")
+		return nil
+	}
+	return err
+}
+
+// shubTransport is the transport of the shub:// references.
+type shubTransport struct{}
+
+func (shubTransport) Resolve(ctx context.Context, ref string) (string, error) {
+	if _, err := shub.ParseReference(ref); err != nil {
+		return "", fmt.Errorf("failed to parse shub uri: %s", err)
+	}
+	return ref, nil
+}
+
+func (shubTransport) Digest(ctx context.Context, ref string) (string, error) {
+	shubURI, err := shub.ParseReference(ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse shub uri: %s", err)
+	}
+	manifest, err := shub.GetManifest(shubURI, noHTTPS)
+	if err != nil {
+		return "", fmt.Errorf("failed to get manifest for: %s: %s", ref, err)
+	}
+	return manifest.Commit, nil
+}
+
+func (shubTransport) Fetch(ctx context.Context, ref, path string) error {
+	shubURI, err := shub.ParseReference(ref)
+	if err != nil {
+		return fmt.Errorf("failed to parse shub uri: %s", err)
+	}
+	manifest, err := shub.GetManifest(shubURI, noHTTPS)
+	if err != nil {
+		return fmt.Errorf("failed to get manifest for: %s: %s", ref, err)
+	}
+	return shub.DownloadImage(ctx, manifest, path, ref, true, noHTTPS)
+}
+
+func (shubTransport) Push(ctx context.Context, path, ref string) error {
+	return transport.ErrUnsupported
+}
+
+func (shubTransport) pullToCache(ctx context.Context, imgCache *cache.Handle, ref string) (string, error) {
+	return shub.Pull(ctx, imgCache, ref, tmpDir, noHTTPS)
+}
+
+func (shubTransport) pullToFile(ctx context.Context, imgCache *cache.Handle, dest, ref string) error {
+	_, err := shub.PullToFile(ctx, imgCache, dest, ref, tmpDir, noHTTPS)
+	return err
+}
+
+// orasTransport is the transport of the oras:// references.
+type orasTransport struct{}
+
+func (orasTransport) Resolve(ctx context.Context, ref string) (string, error) {
+	return ref, nil
+}
+
+func (orasTransport) Digest(ctx context.Context, ref string) (string, error) {
+	ociAuth, err := dockerCredentials(ctx)
+	if err != nil {
+		return "", fmt.Errorf("while creating docker credentials: %v", err)
+	}
+	return oras.ImageSHA(ctx, ref, ociAuth)
+}
+
+func (orasTransport) Fetch(ctx context.Context, ref, path string) error {
+	ociAuth, err := dockerCredentials(ctx)
+	if err != nil {
+		return fmt.Errorf("while creating docker credentials: %v", err)
+	}
+	return oras.DownloadImage(path, ref, ociAuth)
+}
+
+func (orasTransport) Push(ctx context.Context, path, ref string) error {
+	if cmd, ok := ctx.Value(commandKey{}).(*cobra.Command); ok {
+		if f := cmd.Flag(pushDescriptionFlag.Name); f != nil && f.Changed {
+			sylog.Warningf("Description is not supported for push to oras. Ignoring it.")
+		}
+	}
+	ociAuth, err := dockerCredentials(ctx)
+	if err != nil {
+		return fmt.Errorf("while creating docker credentials: %v", err)
+	}
+	_, r := uri.Split(ref)
+	if err := oras.UploadImage(path, r, ociAuth); err != nil {
+		return fmt.Errorf("unable to push image to oci registry: %v", err)
+	}
+	sylog.Infof("Upload complete")
+	return nil
+}
+
+func (t orasTransport) pullToCache(ctx context.Context, imgCache *cache.Handle, ref string) (string, error) {
+	ociAuth, err := dockerCredentials(ctx)
+	if err != nil {
+		return "", fmt.Errorf("while creating docker credentials: %v", err)
+	}
+	return oras.Pull(ctx, imgCache, ref, tmpDir, ociAuth)
+}
+
+func (t orasTransport) pullToFile(ctx context.Context, imgCache *cache.Handle, dest, ref string) error {
+	ociAuth, err := dockerCredentials(ctx)
+	if err != nil {
+		return fmt.Errorf("while creating docker credentials: %v", err)
+	}
+	_, err = oras.PullToFile(ctx, imgCache, dest, ref, tmpDir, ociAuth)
+	return err
+}
+
+// netTransport is the transport of the http:// and https:// references.
+type netTransport struct{}
+
+func (netTransport) Resolve(ctx context.Context, ref string) (string, error) {
+	return ref, nil
+}
+
+// Digest returns the digest expected with --digest, the images are cached
+// by URL otherwise.
+func (netTransport) Digest(ctx context.Context, ref string) (string, error) {
+	return pullDigest, nil
+}
+
+func (netTransport) Fetch(ctx context.Context, ref, path string) error {
+	_, err := net.PullToFile(ctx, getCacheHandle(cache.Config{Disable: true}), path, ref, tmpDir, netOptions())
+	return err
+}
+
+func (netTransport) Push(ctx context.Context, path, ref string) error {
+	return transport.ErrUnsupported
+}
+
+func (netTransport) pullToCache(ctx context.Context, imgCache *cache.Handle, ref string) (string, error) {
+	return net.Pull(ctx, imgCache, ref, tmpDir, nil)
+}
+
+func (netTransport) pullToFile(ctx context.Context, imgCache *cache.Handle, dest, ref string) error {
+	_, err := net.PullToFile(ctx, imgCache, dest, ref, tmpDir, netOptions())
+	return err
+}
+
+// ociTransport is the transport of the OCI references (eg: docker://),
+// converted to SIF.
+type ociTransport struct{}
+
+func (ociTransport) Resolve(ctx context.Context, ref string) (string, error) {
+	return ref, nil
+}
+
+func (ociTransport) Digest(ctx context.Context, ref string) (string, error) {
+	ociAuth, err := dockerCredentials(ctx)
+	if err != nil {
+		return "", fmt.Errorf("while creating docker credentials: %v", err)
+	}
+	return oci.ImageDigest(ctx, ref, tmpDir, ociAuth, noHTTPS)
+}
+
+func (ociTransport) Fetch(ctx context.Context, ref, path string) error {
+	ociAuth, err := dockerCredentials(ctx)
+	if err != nil {
+		return fmt.Errorf("while creating docker credentials: %v", err)
+	}
+	imgCache := getCacheHandle(cache.Config{Disable: disableCache})
+	return build.ConvertOciToSIF(ctx, imgCache, ref, path, tmpDir, noHTTPS, buildArgs.noCleanUp, ociAuth)
+}
+
+func (ociTransport) Push(ctx context.Context, path, ref string) error {
+	return transport.ErrUnsupported
+}
+
+func (ociTransport) pullToCache(ctx context.Context, imgCache *cache.Handle, ref string) (string, error) {
+	ociAuth, err := dockerCredentials(ctx)
+	if err != nil {
+		return "", fmt.Errorf("while creating docker credentials: %v", err)
+	}
+	return oci.Pull(ctx, imgCache, ref, tmpDir, ociAuth, noHTTPS, false)
+}
+
+func (ociTransport) pullToFile(ctx context.Context, imgCache *cache.Handle, dest, ref string) error {
+	ociAuth, err := dockerCredentials(ctx)
+	if err != nil {
+		return fmt.Errorf("while creating docker credentials: %v", err)
+	}
+	_, err = oci.PullToFile(ctx, imgCache, dest, ref, tmpDir, ociAuth, noHTTPS, buildArgs.noCleanUp)
+	return err
+}
@@ -1,5 +1,5 @@
 // Copyright (c) 2020, Control Command Inc. All rights reserved.
-// Copyright (c) 2017-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2017-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -7,26 +7,48 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/hpcng/singularity/docs"
 	"github.com/hpcng/singularity/internal/app/singularity"
 	"github.com/hpcng/singularity/internal/pkg/remote/endpoint"
+	"github.com/hpcng/singularity/internal/pkg/signservice"
 	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/syfs"
 	"github.com/hpcng/singularity/pkg/sylog"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/openpgp"
 )
 
 var (
 	sifGroupID   uint32 // -g groupid specification
 	sifDescID    uint32 // -i id specification
+	hashWorkers  int    // --hash-workers concurrent reads
 	localVerify  bool   // -l flag
 	jsonVerify   bool   // -j flag
 	verifyAll    bool
 	verifyLegacy bool
+	// verifyManifest is the signed manifest images are verified against.
+	verifyManifest string
+	// verifyManifestSigners are the fingerprints of the manifest signers.
+	verifyManifestSigners []string
+	// verifySignerURL is the signing service providing a signing key.
+	verifySignerURL string
 )
 
+// --hash-workers
+var verifyHashWorkersFlag = cmdline.Flag{
+	ID:           "verifyHashWorkersFlag",
+	Value:        &hashWorkers,
+	DefaultValue: singularity.DefaultHashWorkers,
+	Name:         "hash-workers",
+	Usage:        "number of concurrent reads used to hash large images, 1 disables reading ahead",
+	EnvKeys:      []string{"HASH_WORKERS"},
+}
+
 // -u|--url
 var verifyServerURIFlag = cmdline.Flag{
 	ID:           "verifyServerURIFlag",
@@ -118,6 +140,35 @@ var verifyLegacyFlag = cmdline.Flag{
 	Usage:        "enable verification of (insecure) legacy signatures",
 }
 
+// --manifest
+var verifyManifestFlag = cmdline.Flag{
+	ID:           "verifyManifestFlag",
+	Value:        &verifyManifest,
+	DefaultValue: "",
+	Name:         "manifest",
+	Usage:        "verify images and directories of images against a signed manifest",
+}
+
+// --manifest-signer
+var verifyManifestSignerFlag = cmdline.Flag{
+	ID:           "verifyManifestSignerFlag",
+	Value:        &verifyManifestSigners,
+	DefaultValue: []string{},
+	Name:         "manifest-signer",
+	Usage:        "fingerprint of an entity trusted to sign the manifest, required with --manifest",
+	EnvKeys:      []string{"MANIFEST_SIGNER"},
+}
+
+// --signer-url
+var verifySignerURLFlag = cmdline.Flag{
+	ID:           "verifySignerURLFlag",
+	Value:        &verifySignerURL,
+	DefaultValue: "",
+	Name:         "signer-url",
+	Usage:        "trust the key of the signing service at this https URL, pinned on first use",
+	EnvKeys:      []string{"VERIFY_SIGNER_URL"},
+}
+
 func init() {
 	addCmdInit(func(cmdManager *cmdline.CommandManager) {
 		cmdManager.RegisterCmd(VerifyCmd)
@@ -131,17 +182,30 @@ func init() {
 		cmdManager.RegisterFlagForCmd(&verifyJSONFlag, VerifyCmd)
 		cmdManager.RegisterFlagForCmd(&verifyAllFlag, VerifyCmd)
 		cmdManager.RegisterFlagForCmd(&verifyLegacyFlag, VerifyCmd)
+		cmdManager.RegisterFlagForCmd(&verifyManifestFlag, VerifyCmd)
+		cmdManager.RegisterFlagForCmd(&verifyManifestSignerFlag, VerifyCmd)
+		cmdManager.RegisterFlagForCmd(&verifyHashWorkersFlag, VerifyCmd)
+		cmdManager.RegisterFlagForCmd(&verifySignerURLFlag, VerifyCmd)
 	})
 }
 
 // VerifyCmd singularity verify
 var VerifyCmd = &cobra.Command{
 	DisableFlagsInUseLine: true,
-	Args:                  cobra.ExactArgs(1),
+	Args: func(cmd *cobra.Command, args []string) error {
+		if jsonVerify && verifyManifest == "" {
+			return cobra.ExactArgs(1)(cmd, args)
+		}
+		return cobra.MinimumNArgs(1)(cmd, args)
+	},
 
 	Run: func(cmd *cobra.Command, args []string) {
-		// args[0] contains image path
-		doVerifyCmd(cmd, args[0])
+		if verifyManifest != "" {
+			doVerifyManifestCmd(cmd, args)
+			return
+		}
+		// args contains image paths
+		doVerifyCmd(cmd, args)
 	},
 
 	Use:     docs.VerifyUse,
@@ -150,7 +214,8 @@ var VerifyCmd = &cobra.Command{
 	Example: docs.VerifyExample,
 }
 
-func doVerifyCmd(cmd *cobra.Command, cpath string) {
+// verifyOpts returns the verification options set by the command line.
+func verifyOpts(cmd *cobra.Command) []singularity.VerifyOpt {
 	var opts []singularity.VerifyOpt
 
 	// Set keyserver option, if applicable.
@@ -163,6 +228,18 @@ func doVerifyCmd(cmd *cobra.Command, cpath string) {
 		opts = append(opts, singularity.OptVerifyUseKeyServer(co...))
 	}
 
+	// Set signing service key option, if applicable.
+	if verifySignerURL != "" {
+		if err := signservice.CheckURL(verifySignerURL); err != nil {
+			sylog.Fatalf("%s", err)
+		}
+		e, err := signservice.NewClient(verifySignerURL, "").PinnedKey(cmd.Context(), syfs.Signers())
+		if err != nil {
+			sylog.Fatalf("Failed to get signing service key: %s", err)
+		}
+		opts = append(opts, singularity.OptVerifyWithKeys(openpgp.EntityList{e}))
+	}
+
 	// Set group option, if applicable.
 	if cmd.Flag(verifySifGroupIDFlag.Name).Changed || cmd.Flag(verifyOldSifGroupIDFlag.Name).Changed {
 		opts = append(opts, singularity.OptVerifyGroup(sifGroupID))
@@ -183,8 +260,17 @@ func doVerifyCmd(cmd *cobra.Command, cpath string) {
 		opts = append(opts, singularity.OptVerifyLegacy())
 	}
 
+	opts = append(opts, singularity.OptVerifyHashWorkers(hashWorkers), singularity.OptVerifyProgress())
+
+	return opts
+}
+
+func doVerifyCmd(cmd *cobra.Command, paths []string) {
+	opts := verifyOpts(cmd)
+
 	// Set callback option.
 	if jsonVerify {
+		cpath := paths[0]
 		var kl keyList
 
 		opts = append(opts, singularity.OptVerifyCallback(getJSONCallback(&kl)))
@@ -202,12 +288,64 @@ func doVerifyCmd(cmd *cobra.Command, cpath string) {
 	} else {
 		opts = append(opts, singularity.OptVerifyCallback(outputVerify))
 
-		fmt.Printf("Verifying image: %s\n", cpath)
+		// Verify the images, a failure doesn't stop verifying the next images.
+		failed := 0
+		for _, cpath := range paths {
+			fmt.Printf("Verifying image: %s\n", cpath)
+
+			if err := singularity.Verify(cmd.Context(), cpath, opts...); err != nil {
+				if len(paths) == 1 {
+					sylog.Fatalf("Failed to verify container: %s", err)
+				}
+				sylog.Errorf("Failed to verify container %s: %s", cpath, err)
+				failed++
+				continue
+			}
+
+			fmt.Printf("Container verified: %s\n", cpath)
+		}
+		if failed > 0 {
+			sylog.Fatalf("Failed to verify %d of %d images", failed, len(paths))
+		}
+	}
+}
 
-		if err := singularity.Verify(cmd.Context(), cpath, opts...); err != nil {
-			sylog.Fatalf("Failed to verify container: %s", err)
+// doVerifyManifestCmd verifies the images found at paths against the
+// manifest set with --manifest and reports the result of each image.
+func doVerifyManifestCmd(cmd *cobra.Command, paths []string) {
+	if len(verifyManifestSigners) == 0 {
+		sylog.Fatalf("The fingerprint of the manifest signer is required with --manifest-signer")
+	}
+	opts := append(verifyOpts(cmd), singularity.OptVerifyManifestSigners(verifyManifestSigners...))
+
+	r, err := singularity.VerifyManifest(cmd.Context(), verifyManifest, paths, opts...)
+	if err != nil {
+		sylog.Fatalf("Failed to verify manifest: %s", err)
+	}
+
+	if jsonVerify {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		if err := e.Encode(r); err != nil {
+			sylog.Fatalf("Failed to output JSON: %v", err)
+		}
+	} else {
+		fmt.Printf("Manifest %s signed by %s\n", r.Manifest, r.Signer)
+		for _, ir := range r.Images {
+			path := ir.Path
+			if path == "" {
+				path = ir.Entry
+			}
+			if ir.Error != "" {
+				fmt.Printf("%-10s %s: %s\n", strings.ToUpper(ir.Status), path, ir.Error)
+			} else {
+				fmt.Printf("%-10s %s\n", strings.ToUpper(ir.Status), path)
+			}
 		}
+		fmt.Printf("%d image(s) verified, %d failure(s)\n", r.Verified, r.Failed)
+	}
 
-		fmt.Printf("Container verified: %s\n", cpath)
+	if r.Failed > 0 {
+		sylog.Fatalf("Failed to verify %d image(s) against manifest", r.Failed)
 	}
 }
@@ -0,0 +1,201 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/pkg/util/fs/pfs"
+	"github.com/hpcng/singularity/internal/pkg/volume"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterCmd(VolumeCmd)
+		cmdManager.RegisterSubCmd(VolumeCmd, VolumeCreateCmd)
+		cmdManager.RegisterSubCmd(VolumeCmd, VolumeListCmd)
+		cmdManager.RegisterSubCmd(VolumeCmd, VolumeRemoveCmd)
+
+		cmdManager.RegisterFlagForCmd(&volumeTypeFlag, VolumeCreateCmd)
+		cmdManager.RegisterFlagForCmd(&volumeSizeFlag, VolumeCreateCmd)
+		cmdManager.RegisterFlagForCmd(&volumeStripeCountFlag, VolumeCreateCmd)
+		cmdManager.RegisterFlagForCmd(&volumeStripeSizeFlag, VolumeCreateCmd)
+		cmdManager.RegisterFlagForCmd(&volumeJSONFlag, VolumeListCmd)
+	})
+}
+
+// -t|--type
+var volumeType string
+var volumeTypeFlag = cmdline.Flag{
+	ID:           "volumeTypeFlag",
+	Value:        &volumeType,
+	DefaultValue: volume.DirType,
+	Name:         "type",
+	ShortHand:    "t",
+	Usage:        "volume type: dir for a directory or ext3 for an EXT3 image",
+	Tag:          "<type>",
+}
+
+// -s|--size
+var volumeSize int
+var volumeSizeFlag = cmdline.Flag{
+	ID:           "volumeSizeFlag",
+	Value:        &volumeSize,
+	DefaultValue: 64,
+	Name:         "size",
+	ShortHand:    "s",
+	Usage:        "size of the EXT3 volume image in MiB",
+}
+
+// --stripe-count
+var volumeStripeCount string
+var volumeStripeCountFlag = cmdline.Flag{
+	ID:           "volumeStripeCountFlag",
+	Value:        &volumeStripeCount,
+	DefaultValue: "",
+	Name:         "stripe-count",
+	Usage:        "number of Lustre storage targets the files of a dir volume are striped over, -1 for all",
+	Tag:          "<count>",
+}
+
+// --stripe-size
+var volumeStripeSize string
+var volumeStripeSizeFlag = cmdline.Flag{
+	ID:           "volumeStripeSizeFlag",
+	Value:        &volumeStripeSize,
+	DefaultValue: "",
+	Name:         "stripe-size",
+	Usage:        "Lustre stripe size of the files of a dir volume with an optional k, m or g suffix",
+	Tag:          "<size>",
+}
+
+// -j|--json
+var volumeJSON bool
+var volumeJSONFlag = cmdline.Flag{
+	ID:           "volumeJSONFlag",
+	Value:        &volumeJSON,
+	DefaultValue: false,
+	Name:         "json",
+	ShortHand:    "j",
+	Usage:        "print the volumes in JSON format",
+}
+
+// VolumeCmd is the 'volume' command that allows to manage named volumes.
+var VolumeCmd = &cobra.Command{
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("Invalid command")
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:     docs.VolumeUse,
+	Short:   docs.VolumeShort,
+	Long:    docs.VolumeLong,
+	Example: docs.VolumeExample,
+}
+
+// VolumeCreateCmd is the 'volume create' command that allows to create named volumes.
+var VolumeCreateCmd = &cobra.Command{
+	Args:   cobra.ExactArgs(1),
+	PreRun: setPath,
+	Run: func(cmd *cobra.Command, args []string) {
+		var stripe *pfs.Stripe
+		if volumeStripeCount != "" || volumeStripeSize != "" {
+			stripe = &pfs.Stripe{Size: volumeStripeSize}
+			if volumeStripeCount != "" {
+				count, err := pfs.ParseStripeCount(volumeStripeCount)
+				if err != nil {
+					sylog.Fatalf("%s", err)
+				}
+				stripe.Count = count
+			}
+		}
+
+		v, err := volume.Create(volume.Dir(), args[0], volumeType, volumeSize, stripe)
+		if err != nil {
+			sylog.Fatalf("%s", err)
+		}
+		sylog.Infof("Volume %s created in %s", v.Name, v.Path)
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:     docs.VolumeCreateUse,
+	Short:   docs.VolumeCreateShort,
+	Long:    docs.VolumeCreateLong,
+	Example: docs.VolumeCreateExample,
+}
+
+// VolumeListCmd is the 'volume ls' command that allows to list named volumes.
+var VolumeListCmd = &cobra.Command{
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		volumes, err := volume.List(volume.Dir())
+		if err != nil {
+			sylog.Fatalf("%s", err)
+		}
+
+		if volumeJSON {
+			if volumes == nil {
+				volumes = []*volume.Volume{}
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "\t")
+			if err := enc.Encode(volumes); err != nil {
+				sylog.Fatalf("Could not encode volumes: %s", err)
+			}
+			return
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tTYPE\tSIZE\tCREATED\tPATH")
+		for _, v := range volumes {
+			size := "-"
+			if v.Type == volume.Ext3Type {
+				size = fmt.Sprintf("%dMiB", v.Size>>20)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", v.Name, v.Type, size, v.Created.Format("2006-01-02 15:04:05"), v.Path)
+		}
+		tw.Flush()
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:     docs.VolumeListUse,
+	Short:   docs.VolumeListShort,
+	Long:    docs.VolumeListLong,
+	Example: docs.VolumeListExample,
+}
+
+// VolumeRemoveCmd is the 'volume rm' command that allows to remove named volumes.
+var VolumeRemoveCmd = &cobra.Command{
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		failed := false
+		for _, name := range args {
+			if err := volume.Remove(volume.Dir(), name); err != nil {
+				sylog.Errorf("%s", err)
+				failed = true
+				continue
+			}
+			sylog.Infof("Volume %s removed", name)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:     docs.VolumeRemoveUse,
+	Short:   docs.VolumeRemoveShort,
+	Long:    docs.VolumeRemoveLong,
+	Example: docs.VolumeRemoveExample,
+}
@@ -18,6 +18,11 @@
 #define verbosef(b...)   singularity_message(VERBOSE, b)
 #define warningf(b...)   singularity_message(WARNING, b)
 #define errorf(b...)     singularity_message(ERROR, b)
+#define auditf(b...)     do { \
+                             if ( sconfig != NULL && sconfig->starter.securityAudit ) { \
+                                 audit_message(b); \
+                             } \
+                         } while (0)
 
 #define MAX_MAP_SIZE        4096
 #define MAX_PATH_SIZE       PATH_MAX
@@ -154,6 +159,10 @@ struct starter {
     bool masterPropagateMount;
     /* hybrid workflow where master process and container doesn't share user namespace */
     bool hybridWorkflow;
+    /* log every privileged action taken by starter */
+    bool securityAudit;
+    /* exit once stage 1 reported the container set up without creating it */
+    bool dryRun;
 };
 
 /* engine configuration */
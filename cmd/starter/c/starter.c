@@ -58,6 +58,23 @@
 
 #define capflag(x)  (1ULL << x)
 
+/*
+ * capabilities kept in the effective set while creating namespaces
+ * with escalated privileges in setuid workflow, filesystem related
+ * capabilities are already cleared by the filesystem UID change
+ */
+#define NAMESPACE_CAPABILITIES  (capflag(CAP_SYS_ADMIN) | capflag(CAP_NET_ADMIN) | \
+                                 capflag(CAP_SYS_PTRACE) | capflag(CAP_SETUID) | \
+                                 capflag(CAP_SETGID) | capflag(CAP_SETPCAP))
+
+/* capabilities the RPC server is allowed to raise, see set_rpc_privileges */
+#define RPC_CAPABILITIES        (capflag(CAP_SYS_ADMIN) | capflag(CAP_MKNOD) | \
+                                 capflag(CAP_SYS_CHROOT) | capflag(CAP_SETGID) | \
+                                 capflag(CAP_SETUID) | capflag(CAP_FOWNER) | \
+                                 capflag(CAP_DAC_OVERRIDE) | capflag(CAP_DAC_READ_SEARCH) | \
+                                 capflag(CAP_CHOWN) | capflag(CAP_IPC_LOCK) | \
+                                 capflag(CAP_SYS_PTRACE))
+
 /* current starter configuration */
 struct starterConfig *sconfig;
 
@@ -68,6 +85,27 @@ int master_socket[2] = {-1, -1};
 /* set Go execution call after init function returns */
 enum goexec goexecute;
 
+/*
+ * engine configuration format versions supported by this starter, stored
+ * in a dedicated ELF section read by the CLI to pass a compatible configuration
+ */
+__attribute__((used, section(ENGINE_CONFIG_VERSION_SECTION)))
+const unsigned int engine_config_versions[2] = {ENGINE_CONFIG_MIN_VERSION, ENGINE_CONFIG_VERSION};
+
+/* audit_message unconditionally prints a security audit message, use auditf macro instead */
+__attribute__ ((__format__(printf, 1, 2))) void audit_message(char *format, ...) {
+    char message[512];
+    va_list args;
+
+    va_start(args, format);
+    if ( vsnprintf(message, sizeof(message), format, args) >= (int)sizeof(message) ) {
+        memcpy(message+496, "(TRUNCATED...)", 15);
+    }
+    va_end(args);
+
+    fprintf(stderr, "AUDIT:   [U=%d,E=%d,P=%d] %s", getuid(), geteuid(), getpid(), message);
+}
+
 typedef struct fdlist {
     int *fds;
     unsigned int num;
@@ -113,6 +151,7 @@ static void priv_escalate(bool keep_fsuid) {
     uid_t uid = getuid();
 
     verbosef("Get root privileges\n");
+    auditf("seteuid(0): escalate privileges\n");
     if ( seteuid(0) < 0 ) {
         fatalf("Failed to set effective UID to 0\n");
     }
@@ -144,6 +183,7 @@ static void priv_drop(bool permanent) {
 
     if ( !permanent ) {
         verbosef("Drop root privileges\n");
+        auditf("seteuid(%d): drop privileges\n", uid);
         if ( setegid(gid) < 0 ) {
             fatalf("Failed to set effective GID to %d\n", gid);
         }
@@ -152,6 +192,7 @@ static void priv_drop(bool permanent) {
         }
     } else {
         verbosef("Drop root privileges permanently\n");
+        auditf("setresuid(%d, %d, %d): drop privileges permanently\n", uid, uid, uid);
         if ( setresgid(gid, gid, gid) < 0 ) {
             fatalf("Failed to set all GID to %d\n", gid);
         }
@@ -230,6 +271,29 @@ static struct capabilities *get_process_capabilities() {
     return current;
 }
 
+/* limit_effective_capabilities removes capabilities not in caps from the effective set */
+static void limit_effective_capabilities(unsigned long long caps) {
+    struct __user_cap_header_struct header;
+    struct __user_cap_data_struct data[2];
+
+    header.version = LINUX_CAPABILITY_VERSION;
+    header.pid = 0;
+
+    if ( capget(&header, data) < 0 ) {
+        fatalf("Failed to get processus capabilities\n");
+    }
+
+    data[1].effective &= (__u32)(caps >> 32);
+    data[0].effective &= (__u32)(caps & 0xFFFFFFFF);
+
+    auditf("capset(): limit effective capabilities to 0x%016llx\n",
+        ((unsigned long long)data[1].effective << 32) | data[0].effective);
+
+    if ( capset(&header, data) < 0 ) {
+        fatalf("Failed to set process capabilities\n");
+    }
+}
+
 static int get_last_cap(void) {
     int last_cap;
     for ( last_cap = CAPSET_MIN; last_cap <= CAPSET_MAX; last_cap++ ) {
@@ -258,6 +322,14 @@ static void apply_privileges(struct privileges *privileges, struct capabilities
         privileges->capabilities.ambient &= ~capflag(caps_index);
     }
 
+    auditf(
+        "capset(): effective=0x%016llx permitted=0x%016llx bounding=0x%016llx inheritable=0x%016llx\n",
+        privileges->capabilities.effective,
+        privileges->capabilities.permitted,
+        privileges->capabilities.bounding,
+        privileges->capabilities.inheritable
+    );
+
     debugf("Effective capabilities:   0x%016llx\n", privileges->capabilities.effective);
     debugf("Permitted capabilities:   0x%016llx\n", privileges->capabilities.permitted);
     debugf("Bounding capabilities:    0x%016llx\n", privileges->capabilities.bounding);
@@ -322,6 +394,7 @@ static void apply_privileges(struct privileges *privileges, struct capabilities
     }
 
     debugf("Set user ID to %d\n", targetUID);
+    auditf("setresuid(%d, %d, %d)\n", targetUID, targetUID, targetUID);
     if ( setresuid(targetUID, targetUID, targetUID) < 0 ) {
         fatalf("Failed to set all user ID to %d: %s\n", targetUID, strerror(errno));
     }
@@ -380,13 +453,14 @@ static void set_rpc_privileges(void) {
      * - CAP_IPC_LOCK
      * - CAP_SYS_PTRACE
      */
-    priv->capabilities.permitted = current->permitted;
+    priv->capabilities.permitted = current->permitted & RPC_CAPABILITIES;
     /* required by cryptsetup */
     priv->capabilities.bounding = capflag(CAP_SYS_ADMIN);
     priv->capabilities.bounding |= capflag(CAP_IPC_LOCK);
     priv->capabilities.bounding |= capflag(CAP_MKNOD);
 
     debugf("Set RPC privileges\n");
+    auditf("set RPC server privileges\n");
     apply_privileges(priv, current);
     set_parent_death_signal(SIGKILL);
 
@@ -412,6 +486,7 @@ static void set_master_privileges(void) {
     priv->capabilities.inheritable = current->permitted;
 
     debugf("Set master privileges\n");
+    auditf("set master privileges\n");
     apply_privileges(priv, current);
 
     free(priv);
@@ -523,6 +598,7 @@ static int create_namespace(int nstype) {
         errno = EINVAL;
         return(-1);
     }
+    auditf("unshare(0x%x): create namespace\n", nstype);
     return unshare(nstype);
 }
 
@@ -558,6 +634,7 @@ static int enter_namespace(char *nspath, int nstype) {
     }
 
     debugf("Opening namespace file %s\n", nspath);
+    auditf("setns(%s, 0x%x): enter namespace\n", nspath, nstype);
     ns_fd = open(nspath, O_RDONLY);
     if ( ns_fd < 0 ) {
         return(-1);
@@ -648,6 +725,8 @@ static void setup_userns_mappings(struct privileges *privileges) {
         setgroup = allow;
     }
 
+    auditf("write user namespace mappings\n");
+
     debugf("Write %s to setgroups file\n", setgroup);
     map_fp = fopen("setgroups", "w+");
     if ( map_fp != NULL ) {
@@ -1306,6 +1385,12 @@ __attribute__((constructor)) static void init(void) {
     debugf("Wait completion of stage1\n");
     wait_child("stage 1", process, false);
 
+    /* stage 1 reported the container set up in a dry run */
+    if ( sconfig->starter.dryRun ) {
+        verbosef("Dry run completed\n");
+        exit(0);
+    }
+
     /* change current working directory if requested by stage 1 */
     if ( sconfig->starter.workingDirectoryFd >= 0 ) {
         debugf("Applying stage 1 working directory\n");
@@ -1393,6 +1478,8 @@ __attribute__((constructor)) static void init(void) {
          */
         if ( sconfig->starter.isSuid ) {
             priv_escalate(true);
+            /* only keep capabilities required to create namespaces */
+            limit_effective_capabilities(NAMESPACE_CAPABILITIES);
         } else if ( uid != 0 ) {
             fatalf("No setuid installation found, for unprivileged installation use: ./mconfig --without-suid\n");
         }
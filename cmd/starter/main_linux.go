@@ -20,8 +20,11 @@ import (
 	"github.com/hpcng/singularity/internal/app/starter"
 	"github.com/hpcng/singularity/internal/pkg/runtime/engine"
 	starterConfig "github.com/hpcng/singularity/internal/pkg/runtime/engine/config/starter"
+	"github.com/hpcng/singularity/internal/pkg/security/audit"
+	"github.com/hpcng/singularity/internal/pkg/syerror"
 	_ "github.com/hpcng/singularity/internal/pkg/util/goversion"
 	"github.com/hpcng/singularity/internal/pkg/util/mainthread"
+	"github.com/hpcng/singularity/pkg/runtime/engine/config"
 	"github.com/hpcng/singularity/pkg/sylog"
 
 	// register engines
@@ -37,11 +40,25 @@ func getEngine(jsonConfig []byte) *engine.Engine {
 }
 
 func startup() {
+	// report fatal errors as JSON objects like the command line
+	if syerror.Enabled() {
+		syerror.Enable()
+	}
+
+	// engine configuration versions advertised to the CLI must
+	// match the versions supported by engines
+	if uint32(C.engine_config_versions[0]) != config.MinVersion || uint32(C.engine_config_versions[1]) != config.CurrentVersion {
+		sylog.Fatalf("Starter was built with mismatched engine configuration versions\n")
+	}
+
 	// global variable defined in cmd/starter/c/starter.c,
 	// C.sconfig points to a shared memory area
 	csconf := unsafe.Pointer(C.sconfig)
 	// initialize starter configuration
 	sconfig := starterConfig.NewConfig(starterConfig.SConfig(csconf))
+	if sconfig.GetSecurityAudit() {
+		audit.Enable()
+	}
 	// get JSON configuration originally passed from CLI
 	jsonConfig := sconfig.GetJSONConfig()
 
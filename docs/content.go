@@ -21,7 +21,16 @@ Enterprise Performance Computing (EPC)`
   Singularity containers provide an application virtualization layer enabling
   mobility of compute via both application and environment portability. With
   Singularity one is capable of building a root file system that runs on any 
-  other Linux system where Singularity is installed.`
+  other Linux system where Singularity is installed.
+
+  With --json-errors (or SINGULARITY_JSON_ERRORS=1) fatal errors are reported
+  on the standard error as a JSON object with a stable error code, the
+  subsystem, the message and a remediation hint, and the command exits with
+  the exit code of the error: USAGE (64), IMAGE_FORMAT and SIGNATURE (65),
+  NOT_FOUND and INSTANCE_NOT_FOUND (66), CGROUPS and NETWORK (69), CONTAINER
+  (70), INSTANCE_EXISTS (73), NO_SPACE (74), PERMISSION_DENIED, QUARANTINE,
+  UNAUTHORIZED and FAKEROOT (77), CONFIG (78), CANCELED (130) and UNKNOWN
+  (255).`
 	SingularityExample string = `
   $ singularity help <command> [<subcommand>]
   $ singularity help build
@@ -45,6 +54,59 @@ Enterprise Performance Computing (EPC)`
   container, and then build it as a default Singularity image for production 
   use. The default format is immutable.
 
+  Extracted Docker layers often contain unsafe permissions for sandboxes on
+  shared filesystems. The permissions of a sandbox can be normalized once built
+  with --sandbox-umask, removing the umask permissions from all content,
+  --strip-setuid, removing the setuid and setgid bits of files, and
+  --fix-world-writable, removing the world writable bit except for sticky
+  directories like /tmp. The modified permissions are reported in JSON with
+  --perms-report.
+
+  The whiteouts of Docker/OCI layers, hiding files of the layers below, are
+  applied by default. With --whiteout-mode, they are also kept in the image for
+  use as an overlay layer: 'overlayfs' creates real overlayfs whiteouts and
+  requires root, 'wh' creates .wh. files as used by fuse-overlayfs and works
+  when building as an unprivileged user.
+
+  When the %post or %test section of a definition file fails, --debug-shell
+  starts an interactive shell in the build sandbox, with the environment and
+  bind mounts the section ran with, to inspect the failure before the build
+  is discarded. The build fails once the shell exits.
+
+  With --build-log, the output of the build sections is recorded with a
+  timestamp and the section name on every line, and stored compressed in the
+  image. It's shown with 'singularity inspect --build-log'.
+
+  With --resume, each stage is checkpointed after its bootstrap, its %setup
+  and %files sections, its %post section and its %test section. When a build
+  fails, running the same build again with --resume restores the last
+  checkpoint instead of repeating the completed sections. Checkpoints are
+  kept in the singularity-checkpoints-<uid> directory of the temporary
+  directory, which must be owned by the user and not accessible by others.
+  When overlay mounts are supported, a checkpoint only holds the changes
+  made by a section, otherwise it is a copy of the root filesystem, using
+  reflinks when the filesystem supports them. Checkpoints are discarded when
+  the definition file changes and removed once the build succeeds.
+
+  The resources of a build are limited with --cpus and --memory, applied with
+  cgroups to the processes of the %post and %test sections, and with --disk,
+  failing the build once the build sandbox exceeds the given size. A file
+  written by a section can't grow beyond the space left when the section
+  starts, and the sandbox size is checked after each section and while a
+  section runs, up to every 200 milliseconds as it gets close to the limit.
+
+  The credentials of the registries docker and OCI bootstrap images are pulled
+  from are read from the 'singularity remote login' configuration, then from
+  the docker config.json of the invoking user, including its credential
+  helpers, so private base images don't require credentials in the
+  environment. The credentials are only sent to the registry hosting the
+  image, not to the registry mirrors. When building with sudo, the
+  config.json and ~/.config/containers/registries.conf are taken from the
+  home directory of the user running sudo. The registries of this
+  registries.conf are added to the system configuration, registries
+  already configured by the system are left unchanged. Other files are set
+  with --docker-config and --registries-conf.
+
   BUILD SPEC:
 
   The build spec target is a definition (def) file, local image, or URI that can 
@@ -127,6 +189,10 @@ Enterprise Performance Computing (EPC)`
       %startscript
           echo "Define actions for container to perform when started as an instance."
 
+      %shutdownscript
+          echo "Define actions for container to perform when its instance is stopped,"
+          echo "before the instance processes are signaled."
+
       %labels
           HELLO MOTO
           KEY VALUE
@@ -204,6 +270,26 @@ Enterprise Performance Computing (EPC)`
   $ singularity help cache list --type=library,oci
   $ singularity cache list --help`
 
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// Cache Stats
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	CacheStatsUse   string = `stats [stats options...]`
+	CacheStatsShort string = `Show the usage and quotas of your local Singularity cache`
+	CacheStatsLong  string = `
+  This will show the number of entries, the size, the quota and the least
+  recently used entry date of each type of your local cache (stored at
+  $HOME/.singularity/cache if SINGULARITY_CACHEDIR is not set).
+
+  Quotas are set with the 'cache quota' directive of singularity.conf or with
+  the SINGULARITY_CACHE_QUOTA environment variable, holding a comma separated
+  list of <type>:<size> quotas like 'blob:20G,library:10G'. The least recently
+  used entries of a cache type exceeding its quota are evicted as images are
+  pulled.`
+	CacheStatsExample string = `
+  $ singularity cache stats
+  $ singularity cache stats --json
+  $ SINGULARITY_CACHE_QUOTA=blob:20G singularity pull docker://alpine`
+
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// key
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
@@ -335,6 +421,29 @@ Enterprise Performance Computing (EPC)`
 	KeyRemoveExample string = `
   $ singularity key remove D87FE3AF5C1F063FCBCC9B02F812842B5EEE5934`
 
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// key server
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	KeyServerUse   string = `server [server options...]`
+	KeyServerShort string = `Run a key server distributing public keys over HKP`
+	KeyServerLong  string = `
+  The 'key server' command runs a lightweight HKP key server storing the
+  public keys as armored files in a directory, so that isolated sites can
+  distribute and verify signing keys without Internet access. The key
+  commands use it with --url, or as the keyserver of a remote added with
+  'remote add-keyserver'.
+
+  Submitted keys are merged with the stored keys, their identities, subkeys
+  and revocations are kept. With --read-only, key submissions are rejected
+  and keys are added by copying their armored file to the store directory.
+  The server doesn't serve TLS, put it behind a TLS reverse proxy to serve
+  it beyond a trusted network.`
+	KeyServerExample string = `
+  $ singularity key server --listen 0.0.0.0:11371 --store /srv/keys
+
+  $ singularity key push --url http://keys.example.com:11371 8883491F4268F173C6E5DC49EDECE4F3F38D871E
+  $ singularity key pull --url http://keys.example.com:11371 8883491F4268F173C6E5DC49EDECE4F3F38D871E`
+
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// delete
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
@@ -349,7 +458,7 @@ Enterprise Performance Computing (EPC)`
 	// capability
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	CapabilityUse   string = `capability`
-	CapabilityShort string = `Manage Linux capabilities for users and groups`
+	CapabilityShort string = `Manage Linux capabilities for users, groups and profiles`
 	CapabilityLong  string = `
   Capabilities allow you to have fine grained control over the permissions that
   your containers need to run.
@@ -357,7 +466,12 @@ Enterprise Performance Computing (EPC)`
   NOTE: capability add/drop commands require root to run. Granting capabilities 
   to users allows them to escalate privilege inside the container and will
   likely give them a route to privilege escalation on the host system as well.
-  Do not add capabilities to users who should not have root on the host system.`
+  Do not add capabilities to users who should not have root on the host system.
+
+  Capability profiles are named capability sets that users can request with
+  --security capabilities:<profile>, the capabilities of the profile are
+  added to the requested capabilities and are still subject to the user and
+  group authorizations.`
 	CapabilityExample string = `
   All group commands have their own help output:
 
@@ -368,10 +482,10 @@ Enterprise Performance Computing (EPC)`
 	// capability add
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	CapabilityAddUse   string = `add [add options...] <capabilities>`
-	CapabilityAddShort string = `Add capabilities to a user or group (requires root)`
+	CapabilityAddShort string = `Add capabilities to a user, group or profile (requires root)`
 	CapabilityAddLong  string = `
-  Add Linux capabilities to a user or group. NOTE: This command requires root to 
-  run.
+  Add Linux capabilities to a user, group or profile. NOTE: This command
+  requires root to run.
 
   The capabilities argument must be separated by commas and is not case 
   sensitive.
@@ -384,16 +498,20 @@ Enterprise Performance Computing (EPC)`
 
   To add all capabilities to a user:
 
-  $ sudo singularity capability add --user nobody all`
+  $ sudo singularity capability add --user nobody all
+
+  To define a "ping" profile requested with --security capabilities:ping:
+
+  $ sudo singularity capability add --profile ping CAP_NET_RAW`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// capability drop
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	CapabilityDropUse   string = `drop [drop options...] <capabilities>`
-	CapabilityDropShort string = `Remove capabilities from a user or group (requires root)`
+	CapabilityDropShort string = `Remove capabilities from a user, group or profile (requires root)`
 	CapabilityDropLong  string = `
-  Remove Linux capabilities from a user/group. NOTE: This command requires root 
-  to run.
+  Remove Linux capabilities from a user/group/profile. NOTE: This command
+  requires root to run.
 
   The capabilities argument must be separated by commas and is not case 
   sensitive.
@@ -411,16 +529,16 @@ Enterprise Performance Computing (EPC)`
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// capability list
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
-	CapabilityListUse   string = `list [user/group]`
-	CapabilityListShort string = `Show capabilities for a given user or group`
+	CapabilityListUse   string = `list [user/group/profile]`
+	CapabilityListShort string = `Show capabilities for a given user, group or profile`
 	CapabilityListLong  string = `
-  Show the capabilities for a user or group.`
+  Show the capabilities for a user, group or profile.`
 	CapabilityListExample string = `
-  To list capabilities set for user or group nobody:
+  To list capabilities set for user, group or profile nobody:
 
   $ singularity capability list nobody
 
-  To list capabilities for all users/groups:
+  To list capabilities for all users/groups/profiles:
 
   $ singularity capability list`
 
@@ -474,14 +592,24 @@ Enterprise Performance Computing (EPC)`
 	ExecUse   string = `exec [exec options...] <container> <command>`
 	ExecShort string = `Run a command within a container`
 	ExecLong  string = `
-  singularity exec supports the following formats:` + formats
+  singularity exec supports the following formats:` + formats + `
+
+  With --dry-run, the container is not started: the namespaces created, the
+  mounts performed in order, the environment set and the security options
+  applied are printed instead, or in JSON with --dry-run-json, to find out why
+  a file is not visible in the container. The configuration is checked like
+  for a real run, no mount is performed and no file is created. Paths in
+  the session directory of the container start with <session>, and the
+  current directory mount is reported even if the directory is found
+  within the image. The run and shell commands accept --dry-run too.`
 	ExecExamples string = `
   $ singularity exec /tmp/debian.sif cat /etc/debian_version
   $ singularity exec /tmp/debian.sif python ./hello_world.py
   $ cat hello_world.py | singularity exec /tmp/debian.sif python
   $ sudo singularity exec --writable /tmp/debian.sif apt-get update
   $ singularity exec instance://my_instance ps -ef
-  $ singularity exec library://centos cat /etc/os-release`
+  $ singularity exec library://centos cat /etc/os-release
+  $ singularity exec --dry-run --bind /data /tmp/debian.sif ls /data`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// instance
@@ -497,6 +625,22 @@ Enterprise Performance Computing (EPC)`
   $ singularity help instance start
   $ singularity instance start --help`
 
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// instance exec
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	InstanceExecUse   string = `exec [exec options...] <instance name> <command>`
+	InstanceExecShort string = `Run a command within a running instance`
+	InstanceExecLong  string = `
+  The command singularity instance exec allows you to execute a command within
+  a running instance, it is equivalent to singularity exec instance://<name>.
+  The exit code of the command is returned by singularity instance exec.`
+	InstanceExecExample string = `
+  $ singularity instance start my-sql.sif mysql
+  $ singularity instance exec mysql mysqladmin status
+
+  Set an environment variable for the command
+  $ singularity instance exec --env MYSQL_PWD=secret mysql mysql -u root`
+
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// instance list
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
@@ -534,6 +678,17 @@ Enterprise Performance Computing (EPC)`
   will be executed with the instance start command as well. You can optionally
   pass arguments to startscript
 
+  With --replicas N, N instances are started from the same image and options,
+  at most --parallel at a time. The instance name is a template: a %d verb is
+  replaced by the replica number starting at 1, otherwise "-<number>" is
+  appended to the name.
+
+  With --sshd, the OpenSSH or Dropbear server of the container is started in
+  the instance network namespace once the instance is running, with a host key
+  kept in ~/.singularity/sshd/<instance name>. OpenSSH accepts the keys found
+  in ~/.ssh/authorized_keys and ~/.ssh/*.pub on the host, Dropbear those of
+  ~/.ssh/authorized_keys in the container home directory.
+
   singularity instance start accepts the following container formats` + formats
 	InstanceStartExample string = `
   $ singularity instance start /tmp/my-sql.sif mysql
@@ -549,7 +704,17 @@ Enterprise Performance Computing (EPC)`
   Singularity my-sql.sif>
 
   $ singularity instance stop /tmp/my-sql.sif mysql
-  Stopping /tmp/my-sql.sif mysql`
+  Stopping /tmp/my-sql.sif mysql
+
+  Start a pool of 8 workers named worker-1 to worker-8
+  $ singularity instance start --replicas 8 worker.sif worker
+
+  Start 3 workers named worker01 to worker03, one at a time
+  $ singularity instance start --replicas 3 --parallel 1 worker.sif worker%02d
+
+  Start the SSH server of the container for a remote IDE
+  $ singularity instance start --sshd devel.sif devel
+  INFO:    SSH server of devel instance started, connect with: ssh -p 40213 user@node042`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// instance stop
@@ -558,7 +723,15 @@ Enterprise Performance Computing (EPC)`
 	InstanceStopShort string = `Stop a named instance of a given container image`
 	InstanceStopLong  string = `
   The command singularity instance stop allows you to stop and clean up a named,
-  running instance of a given container image.`
+  running instance of a given container image.
+
+  If the container defines a %shutdownscript, it is executed inside the
+  instance first so that services can flush their state, the instance is then
+  signaled once the script exits or after the grace period. Processes still
+  running after the timeout are killed. The stop reason is recorded in the
+  instance file and reported in the instance log. Instances are stopped
+  concurrently, --parallel limits the number of shutdown scripts executed at
+  the same time.`
 	InstanceStopExample string = `
   $ singularity instance start my-sql.sif mysql1
   $ singularity instance start my-sql.sif mysql2
@@ -568,14 +741,125 @@ Enterprise Performance Computing (EPC)`
 
   $ singularity instance start my-sql.sif mysql1
 
-  Force instance to shutdown
-  $ singularity instance stop -f mysql1 (may corrupt data)
+  Force instance to shutdown (shutdown script is not executed)
+  $ singularity instance stop -F mysql1 (may corrupt data)
+
+  Give 60 seconds to the shutdown script before sending the stop signal
+  $ singularity instance stop --grace-period 60 mysql1
+
+  Stop a pool of workers, running 4 shutdown scripts at a time
+  $ singularity instance stop --parallel 4 worker-*
 
   Send SIGTERM to the instance
   $ singularity instance stop -s SIGTERM mysql1
   $ singularity instance stop -s TERM mysql1
   $ singularity instance stop -s 15 mysql1`
 
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// instance update
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	InstanceUpdateUse   string = `update [update options...] <instance>`
+	InstanceUpdateShort string = `Update the resource limits of a running instance`
+	InstanceUpdateLong  string = `
+  The command singularity instance update sets the resource limits of the
+  processes of a running instance with prlimit(2), including the processes
+  started with instance exec when the instance has its own PID namespace. The
+  soft limits can be lowered or raised up to the hard limits, lowering a hard
+  limit can't be undone and raising it requires root privileges. New processes
+  inherit the limits of their parent.`
+	InstanceUpdateExample string = `
+  $ singularity instance update --ulimit nofile=4096 mysql1
+  $ sudo singularity instance update --user alice --ulimit nofile=65536:65536 --ulimit nproc=512 web`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// prune
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	PruneUse   string = `prune [prune options...]`
+	PruneShort string = `Remove stale state files, temporary directories and old cache entries`
+	PruneLong  string = `
+  The prune command removes the data left behind by containers which didn't
+  clean up after themselves:
+
+    - instance files of instances not running anymore
+    - state of OCI containers whose runtime process exited without stopping
+      them, and sockets of stopped OCI containers (root only)
+    - loop devices, encrypted and dm-verity devices, cgroups, CNI networks
+      and temporary files recorded for containers whose master process was
+      killed before releasing them (root only), these resources are also
+      released when the same user starts a new container
+    - temporary sandboxes, bundles and overlays owned by the user and older
+      than one hour found in the temporary directory, they are kept while the
+      user has containers running and mount points found inside them are
+      left untouched
+    - cache entries older than the number of days set with --days
+
+  Unprivileged users prune their own data only.`
+	PruneExample string = `
+  Report what would be removed
+  $ singularity prune --dry-run
+
+  Keep cache entries used in the last week
+  $ singularity prune --days 7`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// ps
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	PsUse   string = `ps [ps options...]`
+	PsShort string = `List running containers`
+	PsLong  string = `
+  The ps command lists the running containers, instances and OCI containers
+  with their image, uptime, PID of the container process, and the CPU time
+  and memory used by the container processes.
+
+  Unprivileged users get their own containers, root gets the containers of
+  all users.`
+	PsExample string = `
+  $ singularity ps
+  TYPE         NAME     USER    PID      UPTIME    CPU      MEMORY     IMAGE
+  instance     mysql    user    12345    2h5m3s    1.52s    98.2 MiB   /home/user/my-sql.sif
+  container    -        user    23456    1m10s     0.2s     3.6 MiB    /home/user/alpine.sif
+
+  List containers of a user as root
+  $ sudo singularity ps -u user
+
+  Print structured json
+  $ singularity ps --json`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// top
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	TopUse   string = `top [top options...]`
+	TopShort string = `Show a live view of the running containers`
+	TopLong  string = `
+  The top command shows the running containers, instances and OCI containers
+  like the ps command, with their CPU usage (percentage of one CPU), memory
+  and storage I/O rates refreshed at each interval. The processes of the
+  selected container are listed below along with the command to start a
+  process in it.
+
+  Keys:
+    Up/Down   select a container
+    k         terminate the selected container (SIGTERM)
+    K         kill the selected container (SIGKILL)
+    p         pause or resume the selected container
+    q         quit
+
+  Containers are paused by stopping their processes with SIGSTOP, OCI
+  containers are frozen by their runtime. Storage I/O rates are only
+  available for the processes of the current user, or of all users for root.
+
+  Unprivileged users get their own containers, root gets the containers of
+  all users. With --batch, or when the standard input is not a terminal, the
+  view is printed at each interval without interaction.`
+	TopExample string = `
+  $ singularity top
+
+  Show the containers of a user as root, refreshed every 5 seconds
+  $ sudo singularity top -u user -d 5s
+
+  Print the view 3 times
+  $ singularity top --batch -n 3`
+
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// pull
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
@@ -591,14 +875,48 @@ Enterprise Performance Computing (EPC)`
   docker: Pull a Docker/OCI image from Docker Hub, or another OCI registry.
       docker://user/image:tag
     
-  shub: Pull an image from Singularity Hub
+  shub: Pull an image from a Singularity Registry Server, or from the
+  deprecated Singularity Hub
+      shub://registry/collection/image:tag
       shub://user/image:tag
 
   oras: Pull a SIF image from an OCI registry that supports ORAS.
       oras://registry/namespace/image:tag
 
   http, https: Pull an image using the http(s?) protocol
-      https://library.sylabs.io/v1/imagefile/library/default/alpine:latest`
+      https://library.sylabs.io/v1/imagefile/library/default/alpine:latest
+
+  Images pulled from http(s) URIs are cached with their ETag or Last-Modified
+  header and only downloaded again once changed on the server. With --digest,
+  the image is verified against the sha256, sha384 or sha512 digest and cached
+  by digest. Headers like authorization headers are added with --header, they
+  are not sent to other hosts on redirects.
+
+  When 'pull quarantine' is enabled in singularity.conf, pulled images are
+  held in quarantine and only moved to the output file once released with the
+  'release' command.
+
+  Docker and oras images are pulled from the mirrors of their registry set by
+  the 'registry mirror' directives of singularity.conf, in order, and from the
+  registry itself when no mirror holds the image. Credentials given on the
+  command line are only sent to the registry itself.
+
+  Docker images are looked up before their pull with registry tokens cached
+  for the run. They're pulled anonymously when possible, with the registry
+  credentials of 'singularity remote login' or the docker config.json once
+  anonymous access is denied or rate limited. When the pull rate limit of the
+  registry is reached, like the Docker Hub one, the pull is retried up to 3
+  times after the Retry-After delay of the registry, or with a backoff. The
+  pull uses the token of the lookup, renewed when it expires, and is retried
+  up to 3 times with a backoff when the rate limit is reached midway, the
+  layers already pulled are kept.
+
+  Private collections of a Singularity Registry Server are pulled with the
+  username and token of the registry set in the sregistry client secrets
+  file, $HOME/.sregistry or the file set by SREGISTRY_CLIENT_SECRETS.
+
+  Plugins may add other URIs by registering a transport for their scheme,
+  their images are cached by digest in the 'transport' cache.`
 	PullExample string = `
   From Sylabs cloud library
   $ singularity pull alpine.sif library://alpine:latest
@@ -610,7 +928,112 @@ Enterprise Performance Computing (EPC)`
   $ singularity pull singularity-images.sif shub://vsoch/singularity-images
 
   From supporting OCI registry (e.g. Azure Container Registry)
-  $ singularity pull image.sif oras://<username>.azurecr.io/namespace/image:tag`
+  $ singularity pull image.sif oras://<username>.azurecr.io/namespace/image:tag
+
+  From a web server, verifying the image digest
+  $ singularity pull --digest sha256:<hex> --header "Authorization: Bearer <token>" \
+      image.sif https://example.com/image.sif`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// prefetch
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	PrefetchUse   string = `prefetch [prefetch options...] [<output file> <URI|image path> | <URI>...]`
+	PrefetchShort string = `Pull images into the cache or distribute an image to cluster nodes`
+	PrefetchLong  string = `
+  The 'prefetch' command resolves, downloads and converts images into the cache
+  without running them, for job prologues or nightly cache warming. Image URIs
+  are passed as arguments or listed in the file set with --list, one per line,
+  '-' reads the list from the standard input. Images are pulled in parallel with
+  --jobs, except Docker and OCI images which are converted one at a time as they
+  share the blob cache. The command fails if any image can't be pulled.
+
+  With --nodes, the 'prefetch' command writes an image at the output file path
+  on the nodes instead, the output file is usually on a node local storage.
+  URIs supported by 'pull' are pulled once to the output file of the local
+  node, local images are sent as is.
+
+  The image is distributed with a pipelined tree broadcast: the local node
+  sends it to --fanout nodes which write it while forwarding it to other
+  nodes, so the registry or the shared file system is hit only once and each
+  node receives the image once. Nodes are reached with the remote shell
+  command set with --rsh (ssh by default) and must have Singularity installed
+  at the same location. The image digest is verified by each node before the
+  output file is written. The 'pull' command accepts the same --nodes, --fanout
+  and --rsh options.`
+	PrefetchExample string = `
+  $ singularity prefetch library://alpine:latest docker://ubuntu:20.04
+  $ singularity prefetch --jobs 4 --list images.txt
+  $ cat images.txt | singularity prefetch --list -
+  $ singularity prefetch --nodes node[01-64] /local/scratch/alpine.sif library://alpine:latest
+  $ singularity prefetch --nodes $SLURM_JOB_NODELIST --fanout 8 /tmp/app.sif ./app.sif
+  $ singularity pull --nodes node01,node02 /tmp/lolcow.sif docker://godlovedc/lolcow`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// release
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	ReleaseUse   string = `release [release options...] <image>`
+	ReleaseShort string = `Release an image held in quarantine`
+	ReleaseLong  string = `
+  When 'pull quarantine' is enabled in singularity.conf, the images pulled
+  are held in a quarantine directory until released with the release command,
+  and action commands can't run images from URIs. An image is identified by
+  its quarantine ID, a prefix of its digest, or by its pull destination.
+
+  Before moving the image to its destination, the release command checks
+  that it wasn't modified in quarantine, verifies its signatures when
+  'quarantine verify' is enabled, and runs the 'quarantine scan command' set
+  in singularity.conf with the image path as argument. The image stays in
+  quarantine if any check fails.
+
+  Released images are recorded by their digest in a directory owned by root,
+  the runtime only runs image files with a released digest. Only root can
+  release images: root releases its own images directly, and approves the
+  images of other users with --approve, their owner can then release them.`
+	ReleaseExample string = `
+  List the images held in quarantine
+  $ singularity release --list
+
+  Release an image to its pull destination
+  $ singularity release 1c8d4b0e9f2a
+
+  Release an image to another path
+  $ singularity release --dest /data/alpine.sif alpine_latest.sif
+
+  Approve the image of another user, as root
+  $ sudo singularity release --approve /home/user/.singularity/quarantine/1c8d4b0e9f2a.sif
+
+  Remove an image from quarantine
+  $ singularity release --discard 1c8d4b0e9f2a`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// history
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	HistoryUse   string = `history [history options...] [<id>]`
+	HistoryShort string = `Browse the runs recorded with --history`
+	HistoryLong  string = `
+  The run, exec, shell and instance start commands record a provenance record
+  of the container run when the --history flag is set, or the
+  SINGULARITY_HISTORY environment variable is set to true. Records are stored
+  in the history directory of the user singularity directory
+  ($HOME/.singularity/history) and hold the image path and digest, the
+  fingerprints of the signers verified with the local and global public
+  keyrings, the command, working directory, binds and command line flags (not
+  their values), the start and end times, and the exit status of the run.
+
+  The history command lists the recorded runs, oldest first, or shows the
+  record identified by an ID prefix.`
+	HistoryExample string = `
+  Record a run
+  $ singularity exec --history analysis.sif ./run.sh
+
+  List the recorded runs
+  $ singularity history
+
+  Show a record in JSON format
+  $ singularity history --json 20210614T101502
+
+  Remove the records of runs finished more than 30 days ago
+  $ singularity history --clear --older-than 720h`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// push
@@ -677,7 +1100,11 @@ Enterprise Performance Computing (EPC)`
   Hello world: one two three
 
   # Note that this does the same thing
-  $ ./tmp/debian.sif one two three`
+  $ ./tmp/debian.sif one two three
+
+  # Run the Jupyter server of an image, the URL to open is printed
+  $ singularity run --publish-web 8888 jupyter.sif
+  Web service available at http://node042:8888/?token=...`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// shell
@@ -715,21 +1142,42 @@ Enterprise Performance Computing (EPC)`
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// sign
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
-	SignUse   string = `sign [sign options...] <image path>`
+	SignUse   string = `sign [sign options...] <image path>...`
 	SignShort string = `Attach digital signature(s) to an image`
 	SignLong  string = `
   The sign command allows a user to add one or more digital signatures to a SIF
   image. By default, one digital signature is added for each object group in
   the file.
   
-  To generate a keypair, see 'singularity help key newpair'`
+  To generate a keypair, see 'singularity help key newpair'
+
+  Several images can be signed at once, the signing key is selected and
+  decrypted once for all images.
+
+  With --url, the signatures are created by a remote signing service holding
+  the signing key, so that the key never leaves the service, like an HSM
+  backed server. The image digests are sent to the service, which returns the
+  signatures embedded into the images. The service must be an https URL, it
+  is authenticated by the bearer token of --token-file, if any. The service API is:
+
+    GET  <url>/v1/key   returns the armored public key of the signing key
+    POST <url>/v1/sign  signs {"fingerprint": "<hex>", "hash": "SHA256",
+                        "digest": "<base64>"} and returns
+                        {"signature": "<base64>"}
+
+  The data objects of large images are read in chunks by concurrent workers
+  ahead of the hashing, which speeds up reads from parallel filesystems with
+  bounded memory use, see --hash-workers. A progress bar is displayed while
+  hashing images larger than 256MiB.`
 	SignExample string = `
-  $ singularity sign container.sif`
+  $ singularity sign container.sif
+
+  $ singularity sign --url https://signer.example --token-file ~/.signer-token *.sif`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// verify
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
-	VerifyUse   string = `verify [verify options...] <image path>`
+	VerifyUse   string = `verify [verify options...] <image path>... | --manifest <manifest> <image path|directory>...`
 	VerifyShort string = `Verify cryptographic signatures attached to an image`
 	VerifyLong  string = `
   The verify command allows a user to verify cryptographic signatures on SIF 
@@ -737,9 +1185,50 @@ Enterprise Performance Computing (EPC)`
   multiple data objects signed. By default the command searches for the primary 
   partition signature. If found, a list of all verification blocks applied on 
   the primary partition is gathered so that data integrity (hashing) and 
-  signature verification is done for all those blocks.`
+  signature verification is done for all those blocks.
+
+  Several images can be verified at once, except with --json. With
+  --signer-url, the public key of a signing service is trusted in addition to
+  the keyrings, to verify the images signed with 'singularity sign --url'.
+  The service must be an https URL. The fingerprint of its key is pinned in
+  $HOME/.singularity/signers.json the first time the service is used, and a
+  different key is rejected until its entry is removed from the file.
+
+  With --manifest, images and directories of images are verified against a
+  manifest listing the images with their digest and the fingerprints of the
+  entities required to sign them, for integrity sweeps of image repositories.
+  The manifest is a JSON document clearsigned by an entity of the local or
+  global public keyring, whose fingerprint must be passed with
+  --manifest-signer:
+
+    {
+      "signers": ["<fingerprint required for all images>"],
+      "images": [
+        {"path": "<path>", "digest": "sha256:<hex>", "signers": ["<fingerprint>"]}
+      ]
+    }
+
+  Image digests are computed with sha256, sha384 or sha512 as selected by the
+  algorithm prefix of the manifest digest.
+
+  Directories are walked for .sif files matched by their path relative to the
+  directory, manifest images not found are reported as missing. Images given
+  individually are matched by file name. Images not listed in the manifest,
+  with a different digest or not signed by the required entities fail the
+  verification. A report is printed for all images, in JSON with --json.
+
+  The data objects of large images are read in chunks by concurrent workers
+  ahead of the hashing, which speeds up reads from parallel filesystems with
+  bounded memory use, see --hash-workers. A progress bar is displayed while
+  hashing images larger than 256MiB.`
 	VerifyExample string = `
-  $ singularity verify container.sif`
+  $ singularity verify container.sif
+
+  $ singularity verify --signer-url https://signer.example *.sif
+
+  $ gpg --clearsign --output images.manifest images.json
+  $ singularity verify --json --manifest images.manifest \
+      --manifest-signer 12045C8C0B1004D058DE4BEDA20C27EE7FF7BA84 /srv/images`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// Run-help
@@ -782,9 +1271,23 @@ Enterprise Performance Computing (EPC)`
   Inspect will show you labels, environment variables, apps and scripts associated 
   with the image determined by the flags you pass. By default, they will be shown in 
   plain text. If you would like to list them in json format, you should use the --json flag.
+
+  The build log of images built with 'build --build-log' is shown with the
+  --build-log flag.
+
+  From inside a running container, the --runtime flag shows how the container
+  was launched (image, image digest if enabled, binds, overlays, namespaces and
+  command line flags) without any image argument, this information is also
+  available to processes in the container as JSON in the read-only file
+  /.singularity.d/runtime.json. It is only exposed when 'runtime info' is
+  enabled in singularity.conf.
   `
 	InspectExample string = `
   $ singularity inspect ubuntu.sif
+
+  From inside a container:
+
+  $ singularity inspect --runtime --json
   
   If you want to list the applications (apps) installed in a container (located at
   /scif/apps) you should run inspect command with --list-apps <container-image> flag.
@@ -862,6 +1365,23 @@ Enterprise Performance Computing (EPC)`
 
       https://www.sylabs.io/docs/`
 
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// sif dump
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	SifDumpLong string = `
+  The 'sif dump' command writes a data object of a SIF image to the standard
+  output. By default the data object is identified by its descriptor ID.
+
+  With --stream, the arguments are the SIF image, or '-' to read it from the
+  standard input, followed by the data object identified by 'rootfs' for the
+  primary system partition, by descriptor ID or by name. The image is read
+  forward only, without seeking or loading it in memory or temporary space,
+  so partitions can be extracted from images streamed over a pipe.`
+	SifDumpExample string = `
+  $ singularity sif dump 1 image.sif > data
+  $ singularity sif dump --stream image.sif rootfs > rootfs.squashfs
+  $ curl -s https://example.com/image.sif | singularity sif dump --stream - rootfs > rootfs.squashfs`
+
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// OCI
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
@@ -922,7 +1442,16 @@ Enterprise Performance Computing (EPC)`
 	OciAttachShort string = `Attach console to a running container process (root user only)`
 	OciAttachLong  string = `
   Attach will attach console to a running container process running within 
-  container identified by container ID.`
+  container identified by container ID.
+
+  When supported by the container runtime, as advertised by the
+  "attachProtocol" field of the container state, the attach socket speaks a
+  framed protocol: the client sends "SINGULARITY-ATTACH" followed by a hello
+  frame holding its protocol version, the runtime answers with a hello frame
+  holding the negotiated version. Each frame is made of a type byte, a 4
+  bytes big endian payload length and the payload, frames separate standard
+  input, standard output, standard error and control messages like terminal
+  resize. Clients not sending the magic get the raw byte stream.`
 	OciAttachExample string = `
   $ singularity oci attach mycontainer`
 
@@ -934,6 +1463,26 @@ Enterprise Performance Computing (EPC)`
 	OciExecExample string = `
   $ singularity oci exec mycontainer id`
 
+	OciProbeUse   string = `probe [probe options...] <container_ID> <command> <args>`
+	OciProbeShort string = `Run a health probe command within container (root user only)`
+	OciProbeLong  string = `
+  Probe runs a short command within the running container identified by
+  container ID through its attach socket, and exits with the exit status of
+  the command, its output is printed. The command joins the container like
+  an exec process, with the namespaces, cgroups, capabilities, seccomp filter,
+  environment, working directory and user of the container process. The
+  command is killed after the timeout, 10 seconds by default, and only the
+  first 4 KiB of its output are kept.
+
+  Probes are sent on the attach socket after the framed protocol magic in a
+  probe frame holding a JSON object with the command "args" and an optional
+  "timeout" in seconds, the runtime answers with a probe frame holding a JSON
+  object with the "exitCode", "output" and "error" of the command, which
+  allows readiness and liveness checks from any attach socket client.`
+	OciProbeExample string = `
+  $ singularity oci probe mycontainer test -f /run/ready
+  $ singularity oci probe -t 2 mycontainer -- curl -sf http://localhost:8080/health`
+
 	OciRunUse   string = `run -b <bundle_path> [run options...] <container_ID>`
 	OciRunShort string = `Create/start/attach/delete a container from a bundle directory (root user only)`
 	OciRunLong  string = `
@@ -949,16 +1498,21 @@ Enterprise Performance Computing (EPC)`
   $ singularity oci delete mycontainer`
 
 	OciUpdateUse   string = `update [update options...] <container_ID>`
-	OciUpdateShort string = `Update container cgroups resources (root user only)`
+	OciUpdateShort string = `Update container cgroups resources and resource limits (root user only)`
 	OciUpdateLong  string = `
   Update will update cgroups resources for the specified container ID. Container 
-  must be in a RUNNING or CREATED state.`
+  must be in a RUNNING or CREATED state. The resource limits of the container
+  processes are set with --ulimit.`
 	OciUpdateExample string = `
   $ singularity oci update --from-file /tmp/cgroups-update.json mycontainer
 
   or to update from stdin :
 
-  $ cat /tmp/cgroups-update.json | singularity oci update --from-file - mycontainer`
+  $ cat /tmp/cgroups-update.json | singularity oci update --from-file - mycontainer
+
+  or to raise the open files limit of the container processes :
+
+  $ singularity oci update --ulimit nofile=65536:65536 mycontainer`
 
 	OciPauseUse   string = `pause <container_ID>`
 	OciPauseShort string = `Suspends all processes inside the container (root user only)`
@@ -1061,4 +1615,301 @@ Enterprise Performance Computing (EPC)`
 
   To create a single EXT3 writable overlay image:
   $ singularity overlay create --size 1024 /tmp/my_overlay.img`
+
+	VolumeUse   string = `volume`
+	VolumeShort string = `Manage named volumes`
+	VolumeLong  string = `
+  The volume command allows management of named volumes, directories or EXT3
+  images stored in the volumes directory of the user singularity directory
+  (~/.singularity/volumes) and holding persistent data. Volumes are mounted in
+  containers by name with:
+
+    --mount type=volume,src=<name>,dst=<container path>[,ro]
+
+  EXT3 volumes are mounted like the bind paths with the image-src option and
+  have the same requirements.`
+	VolumeExample string = `
+  All volume commands have their own help output:
+
+  $ singularity help volume create
+  $ singularity volume create --help`
+
+	VolumeCreateUse   string = `create [create options...] <name>`
+	VolumeCreateShort string = `Create a named volume`
+	VolumeCreateLong  string = `
+  The volume create command creates a named volume, a directory by default or
+  an EXT3 image of the given size with --type ext3.
+
+  When the volumes directory is located on a Lustre filesystem, --stripe-count
+  and --stripe-size set the striping hints of the files created in a directory
+  volume. The stripe-count and stripe-size fields of --mount set the hints of
+  the mounted directory the same way, they are ignored with a warning on
+  other filesystems. GPFS doesn't support striping hints, its block size is
+  set at filesystem creation.`
+	VolumeCreateExample string = `
+  $ singularity volume create mydata
+  $ singularity volume create --type ext3 --size 1024 scratch
+  $ singularity volume create --stripe-count -1 --stripe-size 4m checkpoints
+  $ singularity run --mount type=volume,src=mydata,dst=/data image.sif`
+
+	VolumeListUse   string = `ls [ls options...]`
+	VolumeListShort string = `List the named volumes`
+	VolumeListLong  string = `
+  The volume ls command lists the named volumes of the user.`
+	VolumeListExample string = `
+  $ singularity volume ls
+  $ singularity volume ls --json`
+
+	VolumeRemoveUse   string = `rm <name> [name...]`
+	VolumeRemoveShort string = `Remove named volumes`
+	VolumeRemoveLong  string = `
+  The volume rm command removes named volumes with their data.`
+	VolumeRemoveExample string = `
+  $ singularity volume rm mydata scratch`
+
+	SandboxUse   string = `sandbox`
+	SandboxShort string = `Manage the snapshots of sandbox directories`
+	SandboxLong  string = `
+  The sandbox command allows management of the snapshots of a sandbox
+  directory, to roll back the changes made in the sandbox with --writable.
+  Snapshots are copies of the sandbox stored in the .<sandbox name>.snapshots
+  directory next to it, made with reflinks when the filesystem supports them
+  (like btrfs or XFS) so they are fast and only use the space of the files
+  modified afterwards. Other filesystems get full copies.`
+	SandboxExample string = `
+  All sandbox commands have their own help output:
+
+  $ singularity help sandbox snapshot
+  $ singularity sandbox snapshot --help`
+
+	SandboxSnapshotUse   string = `snapshot [snapshot options...] <sandbox path>`
+	SandboxSnapshotShort string = `Take a snapshot of a sandbox`
+	SandboxSnapshotLong  string = `
+  The sandbox snapshot command takes a named snapshot of a sandbox directory,
+  named after the current date and time unless --name is set. Containers
+  should not write into the sandbox while the snapshot is taken.`
+	SandboxSnapshotExample string = `
+  $ singularity sandbox snapshot --name clean ubuntu/
+  $ singularity shell --writable ubuntu/
+  $ singularity sandbox rollback ubuntu/ clean`
+
+	SandboxListUse   string = `list [list options...] <sandbox path>`
+	SandboxListShort string = `List the snapshots of a sandbox`
+	SandboxListLong  string = `
+  The sandbox list command lists the snapshots of a sandbox directory by
+  creation time.`
+	SandboxListExample string = `
+  $ singularity sandbox list ubuntu/
+  $ singularity sandbox list --json ubuntu/`
+
+	SandboxRollbackUse   string = `rollback <sandbox path> <snapshot name>`
+	SandboxRollbackShort string = `Restore a sandbox from a snapshot`
+	SandboxRollbackLong  string = `
+  The sandbox rollback command replaces the content of a sandbox directory by
+  the one of a snapshot, the changes made since the snapshot are lost. The
+  snapshot is kept to roll back again. No container must be running from the
+  sandbox.`
+	SandboxRollbackExample string = `
+  $ singularity sandbox rollback ubuntu/ clean`
+
+	SandboxDeleteUse   string = `delete <sandbox path> <snapshot name> [name...]`
+	SandboxDeleteShort string = `Remove snapshots of a sandbox`
+	SandboxDeleteLong  string = `
+  The sandbox delete command removes snapshots of a sandbox directory.`
+	SandboxDeleteExample string = `
+  $ singularity sandbox delete ubuntu/ clean 20211014-174000`
+
+	DevcontainerUse   string = `devcontainer`
+	DevcontainerShort string = `Run development containers described by devcontainer.json files`
+	DevcontainerLong  string = `
+  The devcontainer command runs the development containers described by the
+  .devcontainer/devcontainer.json or .devcontainer.json file of a project as
+  Singularity instances.`
+	DevcontainerExample string = `
+  All devcontainer commands have their own help output:
+
+  $ singularity help devcontainer up
+  $ singularity devcontainer up --help`
+
+	DevcontainerUpUse   string = `up [up options...] [project directory]`
+	DevcontainerUpShort string = `Start the development container of a project`
+	DevcontainerUpLong  string = `
+  The devcontainer up command starts the development container of the project
+  directory, the current directory by default, as an instance named after the
+  name field or the project directory. The following fields are supported:
+
+    image                  a Docker image, pulled with docker://
+    mounts                 bind and volume mounts, missing named volumes are
+                           created
+    workspaceMount         the project directory mount
+    workspaceFolder        the project directory path in the container,
+                           /workspaces/<project directory name> by default
+    containerEnv           variables set in the instance, remoteEnv too
+    remoteEnv
+    forwardPorts           reported only, instances share the host network
+    postCreateCommand      executed in the instance once started
+
+  Dockerfile builds are not supported. Instead, the image can be built from a
+  Singularity definition file set with customizations.singularity.definition,
+  relative to the devcontainer.json file, and built with --fakeroot if
+  customizations.singularity.fakeroot is true. The image is stored next to the
+  definition file and rebuilt when the definition file changes. Nothing is
+  done if the instance is already running, stop it with 'singularity instance
+  stop <name>'.`
+	DevcontainerUpExample string = `
+  $ cat .devcontainer/devcontainer.json
+  {
+    "name": "analysis",
+    "image": "python:3.9",
+    "mounts": ["source=pip-cache,target=/cache/pip,type=volume"],
+    "containerEnv": {"PIP_CACHE_DIR": "/cache/pip"},
+    "postCreateCommand": "pip install --user -r requirements.txt"
+  }
+  $ singularity devcontainer up
+  $ singularity shell --pwd /workspaces/analysis instance://analysis`
+
+	KernelSpecUse   string = `kernelspec [kernelspec options...] <container> <name>`
+	KernelSpecShort string = `Generate a Jupyter kernel running in a container`
+	KernelSpecLong  string = `
+  The kernelspec command installs the Jupyter kernel specification <name>
+  launching the kernel inside the container with singularity exec, so notebook
+  servers running on the host offer the container environment as a kernel.
+  The kernel is installed in the user Jupyter data directory
+  (~/.local/share/jupyter/kernels by default) or in the --prefix directory.
+
+  The kernel command line is executed in the container, by default it starts
+  an IPython kernel which requires the ipykernel package in the container. The
+  Jupyter runtime directory holding the connection files is bound in the
+  container in addition to the --bind paths.`
+	KernelSpecExample string = `
+  $ singularity kernelspec pytorch.sif pytorch
+  $ singularity kernelspec --nv --bind /scratch --display-name "PyTorch (GPU)" pytorch.sif pytorch-gpu
+  $ singularity kernelspec --language R --kernel-cmd "R --slave -e IRkernel::main() --args {connection_file}" r.sif r-kernel`
+
+	BatchUse   string = `batch [batch options...] <container> [command] [args...]`
+	BatchShort string = `Generate a Slurm, PBS or LSF job script running a container`
+	BatchLong  string = `
+  The batch command generates a job script of the Slurm, PBS or LSF scheduler
+  requesting the nodes, tasks, CPUs, GPUs, memory and time limit of the job, and
+  executing the command in the container with singularity exec, or its
+  runscript with singularity run if no command is given. The script is written
+  to the standard output, or to the --output file, and submitted with sbatch,
+  qsub or bsub.
+
+  With --mpi the tasks are launched with srun for Slurm and with the host
+  mpirun for PBS and LSF, each task running in its own container (the hybrid
+  MPI model), which requires an MPI library in the container compatible with
+  the host one. Requesting GPUs enables Nvidia GPU support in the container
+  unless --rocm is set.`
+	BatchExample string = `
+  $ singularity batch --time 01:00:00 analysis.sif python analyze.py > job.sh
+  $ sbatch job.sh
+
+  $ singularity batch --scheduler pbs --mpi --nodes 2 --ntasks 64 --bind /scratch -o job.sh mpi.sif /opt/app/solver
+  $ qsub job.sh
+
+  $ singularity batch --scheduler lsf --gpus 4 --queue gpu --env OMP_NUM_THREADS=8 pytorch.sif python train.py | bsub`
+
+	DescribeUse   string = `describe [describe options...] <image>`
+	DescribeShort string = `Describe the container requirements of an image for workflow engines`
+	DescribeLong  string = `
+  The describe command prints the URI, digest and recommended runtime options
+  of an image, or with --cwl, --nextflow or --snakemake the container
+  requirements of a CWL tool, a Nextflow pipeline or a Snakemake rule running
+  it. Image URIs are pinned to the digest of their content for docker:// and
+  library:// images, the runtime options (like bind paths) are the ones
+  recommended by the image in its io.sylabs.runtime.flags label.
+
+  With --resolve the path of the image is printed, images given by URI are
+  pulled into the cache if needed, so workflow engines can run the cached
+  image without pulling it again.`
+	DescribeExample string = `
+  $ singularity describe --nextflow docker://quay.io/biocontainers/samtools:1.13--h8c37831_0 >> nextflow.config
+  $ singularity describe --cwl library://alpine:3.14
+  $ singularity exec $(singularity describe --resolve docker://python:3.9) python --version`
+
+	DoctorUse   string = `doctor [doctor options...]`
+	DoctorShort string = `Check the host and the installation for running containers`
+	DoctorLong  string = `
+  The doctor command checks the kernel features used by singularity (user
+  namespaces, overlay and squashfs filesystems, seccomp and the cgroups v2
+  unified hierarchy), the consistency of the installation (singularity.conf,
+  the subuid and subgid mappings of the user, the permissions of starter-suid
+  and the loop devices) and the connectivity of the remote endpoint services.
+
+  Each check is reported as PASS, WARN when a feature is unavailable, FAIL when
+  containers can't run, or SKIP when not applicable, with the fix of the
+  warnings and failures. The command exits with an error if a check failed.`
+	DoctorExample string = `
+  $ singularity doctor
+  $ singularity doctor --no-remote`
+
+	DuUse   string = `du [du options...] <image path|instance://name>`
+	DuShort string = `Report the filesystem usage of an image or a running instance`
+	DuLong  string = `
+  The du command reports the size of the partitions and data objects of an
+  image, and the top directories of its squashfs partitions and sandbox
+  directories by apparent size, uncompressed for squashfs. The content of ext3
+  partitions is not listed.
+
+  For a running instance, the usage of the writable filesystems mounted in the
+  container is reported against their size limit. The usage of an overlay root
+  filesystem is the one of its writable layer, either the ext3 overlay image
+  or the tmpfs of --writable-tmpfs, limited by the sessiondir max size directive
+  of singularity.conf. The top directories of the tmpfs mounts are also
+  reported.`
+	DuExample string = `
+  $ singularity du image.sif
+  $ singularity du --top 20 image.sif
+  $ singularity du instance://mysql`
+
+	ContainerExportUse   string = `export [export options...] <instance://name|container PID>`
+	ContainerExportShort string = `Export the root filesystem of a running container to a tar archive`
+	ContainerExportLong  string = `
+  The export command writes a tar archive of the root filesystem of a running
+  instance, or of a container given by the PID reported by 'singularity ps',
+  as seen from the container with the changes made in its writable overlay or
+  sandbox. The other filesystems mounted in the container, like the bind
+  mounts, the home directory or /proc, are not exported. Containers started
+  with the setuid workflow can only be exported by root.
+
+  With --writable-layer only the files created or modified since the container
+  started are exported, with their parent directories. The deleted files are
+  not recorded, nor the changes made in a persistent overlay by previous
+  containers.
+
+  The archive can be imported in a new image with a definition file using the
+  scratch bootstrap agent and a %setup section extracting it.`
+	ContainerExportExample string = `
+  $ singularity export -o env.tar instance://devel
+  $ singularity export --writable-layer -o changes.tar.gz instance://devel
+  $ singularity export -o - 12345 | tar -t`
+
+	CommitUse   string = `commit [commit options...] <instance://name|container PID|sandbox> <sif path>`
+	CommitShort string = `Commit the changes made in a container to a new SIF image`
+	CommitLong  string = `
+  The commit command creates a new SIF image from the root filesystem of a
+  running instance, or of a container given by the PID reported by
+  'singularity ps', with the changes made in its writable overlay or sandbox.
+  The other filesystems mounted in the container, like the bind mounts or the
+  home directory, are not committed, their mount points are kept empty.
+  Containers started with the setuid workflow can only be committed by root.
+
+  By default the whole root filesystem is re-packed. With --delta, only the
+  changes against the SIF image of the instance are stored in an overlay
+  partition added to a copy of the image, which requires overlay support at
+  runtime.
+
+  A sandbox directory is committed with its root filesystem as primary
+  partition and, with --overlay, the upper directory of its overlay directory
+  as overlay partition, its whiteouts kept as is.
+
+  The labels and runscript of the image metadata are read from the committed
+  root filesystem, labels recording the source and date of the commit are
+  added. The signatures of the source image don't apply to the new image and
+  are removed, with --sign the new image is signed with a local private key.`
+	CommitExample string = `
+  $ singularity commit instance://devel devel.sif
+  $ singularity commit --delta --sign --keyidx 0 instance://devel devel.sif
+  $ singularity commit --overlay overlay/ sandbox/ image.sif`
 )
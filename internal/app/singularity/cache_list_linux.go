@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -37,7 +37,13 @@ func listTypeCache(printList bool, name, cachePath string) (int, int64, error) {
 		totalSize int64
 	)
 
+	count := 0
 	for _, entry := range cacheEntries {
+		// skip cache metadata
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		count++
 
 		if printList {
 			fmt.Printf("%-24.22s %-22s %-16s %s\n",
@@ -49,7 +55,7 @@ func listTypeCache(printList bool, name, cachePath string) (int, int64, error) {
 		totalSize += entry.Size()
 	}
 
-	return len(cacheEntries), totalSize, nil
+	return count, totalSize, nil
 }
 
 // ListSingularityCache will list the local singularity cache for the
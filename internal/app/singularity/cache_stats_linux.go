@@ -0,0 +1,53 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/hpcng/singularity/internal/pkg/cache"
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+)
+
+// CacheStats writes the usage statistics of the cache types to w, in
+// JSON format if jsonOutput is true.
+func CacheStats(w io.Writer, imgCache *cache.Handle, jsonOutput bool) error {
+	if imgCache == nil {
+		return errInvalidCacheHandle
+	}
+
+	stats, err := imgCache.Stats()
+	if err != nil {
+		return fmt.Errorf("while reading cache statistics: %s", err)
+	}
+	if stats == nil {
+		stats = []cache.Stats{}
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(stats)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tENTRIES\tSIZE\tQUOTA\tLEAST RECENTLY USED")
+	for _, s := range stats {
+		quota := "-"
+		if s.Quota > 0 {
+			quota = fmt.Sprintf("%s (%.0f%%)", fs.FindSize(s.Quota), float64(s.Size)*100/float64(s.Quota))
+		}
+		lru := "-"
+		if s.Oldest != nil {
+			lru = s.Oldest.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", s.Type, s.Entries, fs.FindSize(s.Size), quota, lru)
+	}
+	return tw.Flush()
+}
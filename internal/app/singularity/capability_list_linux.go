@@ -16,15 +16,16 @@ import (
 
 // CapListConfig instructs CapabilityList on what to list
 type CapListConfig struct {
-	User  string
-	Group string
-	All   bool
+	User    string
+	Group   string
+	Profile string
+	All     bool
 }
 
 // CapabilityList lists the capabilities based on the CapListConfig
 func CapabilityList(capFile string, c CapListConfig) error {
-	if c.User == "" && c.Group == "" && !c.All {
-		return fmt.Errorf("while listing capabilities: must specify a user or a group")
+	if c.User == "" && c.Group == "" && c.Profile == "" && !c.All {
+		return fmt.Errorf("while listing capabilities: must specify a user, a group or a profile")
 	}
 
 	oldmask := syscall.Umask(0)
@@ -61,8 +62,15 @@ func CapabilityList(capFile string, c CapListConfig) error {
 			}
 		}
 
+		for profile, cap := range capConfig.ListAllProfiles() {
+			if len(cap) > 0 {
+				fmt.Printf("%s [profile]: %s\n", profile, strings.Join(cap, ","))
+				outputCaps++
+			}
+		}
+
 		if outputCaps == 0 {
-			return fmt.Errorf("no capability set for users or groups")
+			return fmt.Errorf("no capability set for users, groups or profiles")
 		}
 
 		return nil
@@ -84,8 +92,16 @@ func CapabilityList(capFile string, c CapListConfig) error {
 		}
 	}
 
+	if c.Profile != "" {
+		caps := capConfig.ListProfileCaps(c.Profile)
+		if len(caps) > 0 {
+			fmt.Printf("%s [profile]: %s\n", c.Profile, strings.Join(caps, ","))
+			outputCaps++
+		}
+	}
+
 	if outputCaps == 0 {
-		return fmt.Errorf("no capability set for user/group %s", c.User)
+		return fmt.Errorf("no capability set for user/group/profile %s", c.User)
 	}
 
 	return nil
@@ -17,14 +17,16 @@ import (
 
 // CapManageConfig specifies what capability set to edit in the capability file
 type CapManageConfig struct {
-	Caps  string
-	User  string
-	Group string
+	Caps    string
+	User    string
+	Group   string
+	Profile string
 }
 
 type manageType struct {
-	UserFn  func(*capabilities.Config, string, []string) error
-	GroupFn func(*capabilities.Config, string, []string) error
+	UserFn    func(*capabilities.Config, string, []string) error
+	GroupFn   func(*capabilities.Config, string, []string) error
+	ProfileFn func(*capabilities.Config, string, []string) error
 }
 
 // CapabilityAdd adds the specified capability set to the capability file
@@ -36,6 +38,9 @@ func CapabilityAdd(capFile string, c CapManageConfig) error {
 		GroupFn: func(c *capabilities.Config, a string, b []string) error {
 			return c.AddGroupCaps(a, b)
 		},
+		ProfileFn: func(c *capabilities.Config, a string, b []string) error {
+			return c.AddProfileCaps(a, b)
+		},
 	}
 
 	return manageCaps(capFile, c, addType)
@@ -50,6 +55,9 @@ func CapabilityDrop(capFile string, c CapManageConfig) error {
 		GroupFn: func(c *capabilities.Config, a string, b []string) error {
 			return c.DropGroupCaps(a, b)
 		},
+		ProfileFn: func(c *capabilities.Config, a string, b []string) error {
+			return c.DropProfileCaps(a, b)
+		},
 	}
 
 	return manageCaps(capFile, c, dropType)
@@ -79,8 +87,8 @@ func manageCaps(capFile string, c CapManageConfig, t manageType) error {
 		sylog.Warningf("Ignoring unknown capabilities: %s", ign)
 	}
 
-	if c.User == "" && c.Group == "" {
-		return fmt.Errorf("no user or group specified")
+	if c.User == "" && c.Group == "" && c.Profile == "" {
+		return fmt.Errorf("no user, group or profile specified")
 	}
 
 	if c.User != "" {
@@ -103,6 +111,12 @@ func manageCaps(capFile string, c CapManageConfig, t manageType) error {
 		}
 	}
 
+	if c.Profile != "" {
+		if err := t.ProfileFn(capConfig, c.Profile, caps); err != nil {
+			return fmt.Errorf("while setting capabilities for profile %s: %s", c.Profile, err)
+		}
+	}
+
 	if err := file.Truncate(0); err != nil {
 		return fmt.Errorf("while truncating capability config file: %s", err)
 	}
@@ -0,0 +1,278 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hpcng/sif/pkg/sif"
+	"github.com/hpcng/singularity/internal/pkg/build/assemblers"
+	"github.com/hpcng/singularity/internal/pkg/buildcfg"
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	"github.com/hpcng/singularity/internal/pkg/util/fs/squashfs"
+	"github.com/hpcng/singularity/pkg/build/types"
+	"github.com/hpcng/singularity/pkg/image"
+	"github.com/hpcng/singularity/pkg/inspect"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/util/fs/proc"
+	"golang.org/x/crypto/openpgp"
+)
+
+// Labels recording the origin of a committed image.
+const (
+	commitSourceLabel = "org.label-schema.usage.singularity.commit.source"
+	commitDateLabel   = "org.label-schema.usage.singularity.commit.date"
+)
+
+// CommitOptions are the options of CommitContainer.
+type CommitOptions struct {
+	// Overlay is the overlay directory holding the changes made to a
+	// sandbox source, they are stored in an overlay partition.
+	Overlay string
+	// Delta stores the changes made in a running container in an overlay
+	// partition added to a copy of its SIF image, instead of re-packing
+	// its whole root filesystem.
+	Delta bool
+	// SignEntity, if set, signs the new image with this entity.
+	SignEntity *openpgp.Entity
+	// TmpDir is the directory holding the temporary files.
+	TmpDir string
+}
+
+// commitSource describes the root filesystem committed to a new image.
+type commitSource struct {
+	// rootfs is the root filesystem directory.
+	rootfs string
+	// image is the image the root filesystem comes from, if known.
+	image string
+	// exclude are the mount points of a running container.
+	exclude []string
+}
+
+// runningSource returns the commit source of the running container target,
+// an instance name or a container PID.
+func runningSource(target string) (*commitSource, error) {
+	pid, err := containerPid(target)
+	if err != nil {
+		return nil, err
+	}
+	root, err := containerRoot(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := proc.GetMountInfoEntry(fmt.Sprintf("/proc/%d/mountinfo", pid))
+	if err != nil {
+		return nil, fmt.Errorf("while reading container mounts: %s", err)
+	}
+	src := &commitSource{rootfs: root}
+	for _, m := range entries {
+		if m.Point != "/" {
+			src.exclude = append(src.exclude, m.Point)
+		}
+	}
+
+	// the image is only known for instances
+	ii, err := listInstances("", "*", false)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve instance list: %v", err)
+	}
+	for _, i := range ii {
+		if i.Pid == pid {
+			src.image = i.Image
+			break
+		}
+	}
+	return src, nil
+}
+
+// commitMetadata returns the inspect metadata of the committed image, the
+// metadata of the source SIF image updated with the labels and runscript
+// of the root filesystem.
+func commitMetadata(src *commitSource) ([]byte, error) {
+	metadata := inspect.NewMetadata()
+
+	if img, err := image.Init(src.image, false); err == nil {
+		defer img.File.Close()
+
+		r, err := image.NewSectionReader(img, image.SIFDescInspectMetadataJSON, -1)
+		if err == nil {
+			if err := json.NewDecoder(r).Decode(metadata); err != nil {
+				return nil, fmt.Errorf("while decoding %s metadata: %s", src.image, err)
+			}
+		} else if img.Type == image.SIF && err != image.ErrNoSection {
+			return nil, fmt.Errorf("while reading %s metadata: %s", src.image, err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(src.rootfs, ".singularity.d", "labels.json"))
+	if err == nil {
+		labels := make(map[string]string)
+		if err := json.Unmarshal(data, &labels); err != nil {
+			return nil, fmt.Errorf("while decoding labels: %s", err)
+		}
+		metadata.Attributes.Labels = labels
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("while reading labels: %s", err)
+	}
+	if metadata.Attributes.Labels == nil {
+		metadata.Attributes.Labels = make(map[string]string)
+	}
+
+	if data, err := ioutil.ReadFile(filepath.Join(src.rootfs, ".singularity.d", "runscript")); err == nil {
+		metadata.Attributes.Runscript = string(data)
+	}
+
+	source := src.image
+	if source == "" {
+		source = src.rootfs
+	}
+	metadata.Attributes.Labels[commitSourceLabel] = source
+	metadata.Attributes.Labels[commitDateLabel] = time.Now().Format(time.RFC3339)
+	metadata.Attributes.Labels["org.label-schema.usage.singularity.version"] = buildcfg.PACKAGE_VERSION
+
+	return json.Marshal(metadata)
+}
+
+// replaceMetadata replaces the inspect metadata of the SIF image at path,
+// the signatures of the source image are removed as they don't cover the
+// committed image.
+func replaceMetadata(path string, metadata []byte) error {
+	fimg, err := sif.LoadContainer(path, false)
+	if err != nil {
+		return fmt.Errorf("while loading SIF image %s: %s", path, err)
+	}
+	defer fimg.UnloadContainer()
+
+	var ids []uint32
+	signatures := 0
+	for _, d := range fimg.DescrArr {
+		if !d.Used {
+			continue
+		}
+		switch {
+		case d.Datatype == sif.DataSignature:
+			ids = append(ids, d.ID)
+			signatures++
+		case d.Datatype == sif.DataGenericJSON && d.GetName() == image.SIFDescInspectMetadataJSON:
+			ids = append(ids, d.ID)
+		}
+	}
+	for _, id := range ids {
+		if err := fimg.DeleteObject(id, 0); err != nil {
+			return fmt.Errorf("while deleting descriptor %d: %s", id, err)
+		}
+	}
+	if signatures > 0 {
+		sylog.Verbosef("Removed %d signature(s) of the source image", signatures)
+	}
+
+	input := sif.DescriptorInput{
+		Datatype: sif.DataGenericJSON,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Data:     metadata,
+		Size:     int64(len(metadata)),
+		Fname:    image.SIFDescInspectMetadataJSON,
+	}
+	if err := fimg.AddObject(input); err != nil {
+		return fmt.Errorf("while adding metadata: %s", err)
+	}
+	return nil
+}
+
+// CommitContainer creates the SIF image dest from the changes made in
+// the running container target, an instance name or a container PID, or
+// from a sandbox directory and its overlay directory set in opts. The
+// image metadata are updated and the image is signed if requested.
+func CommitContainer(target, dest string, opts CommitOptions) error {
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("%s already exists", dest)
+	}
+
+	var src *commitSource
+	var err error
+	if fs.IsDir(target) {
+		if opts.Delta {
+			return fmt.Errorf("--delta only applies to running containers, the changes of a sandbox overlay are always stored in an overlay partition")
+		}
+		src = &commitSource{rootfs: target, image: target}
+	} else {
+		if opts.Overlay != "" {
+			return fmt.Errorf("--overlay only applies to sandbox directories")
+		}
+		if src, err = runningSource(target); err != nil {
+			return err
+		}
+	}
+
+	a := &assemblers.SIFAssembler{Exclude: src.exclude}
+	if a.MksquashfsPath, err = squashfs.GetPath(); err != nil {
+		return fmt.Errorf("while searching for mksquashfs: %v", err)
+	}
+	if a.MksquashfsProcs, err = squashfs.GetProcs(); err != nil {
+		return fmt.Errorf("while searching for mksquashfs processor limits: %v", err)
+	}
+	if a.MksquashfsMem, err = squashfs.GetMem(); err != nil {
+		return fmt.Errorf("while searching for mksquashfs mem limits: %v", err)
+	}
+
+	if opts.Overlay != "" {
+		// the changes are in the upper directory of the overlay layout
+		a.Overlay = opts.Overlay
+		if upper := filepath.Join(opts.Overlay, "upper"); fs.IsDir(upper) {
+			a.Overlay = upper
+		}
+		sylog.Infof("Changes of %s are stored in an overlay partition", opts.Overlay)
+	}
+	if opts.Delta {
+		if src.image == "" {
+			return fmt.Errorf("--delta requires an instance started from a SIF image")
+		}
+		a.DeltaFrom = src.image
+	}
+
+	metadata, err := commitMetadata(src)
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := ioutil.TempDir(opts.TmpDir, "commit-")
+	if err != nil {
+		return fmt.Errorf("while creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	b := &types.Bundle{
+		RootfsPath:  src.rootfs,
+		TmpDir:      tmpDir,
+		JSONObjects: make(map[string][]byte),
+	}
+	if def, err := ioutil.ReadFile(filepath.Join(src.rootfs, ".singularity.d", "Singularity")); err == nil {
+		b.Recipe.Raw = def
+	}
+
+	if err := a.Assemble(b, dest); err != nil {
+		os.Remove(dest)
+		return err
+	}
+	if err := replaceMetadata(dest, metadata); err != nil {
+		os.Remove(dest)
+		return err
+	}
+
+	if opts.SignEntity != nil {
+		if err := Sign(dest, OptSignEntity(opts.SignEntity)); err != nil {
+			return fmt.Errorf("while signing %s: %s", dest, err)
+		}
+	}
+	return nil
+}
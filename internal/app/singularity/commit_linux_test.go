@@ -0,0 +1,58 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcng/singularity/pkg/inspect"
+)
+
+func TestCommitMetadata(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "commit-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(rootfs)
+
+	dir := filepath.Join(rootfs, ".singularity.d")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("failed to create directory: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "labels.json"), []byte(`{"maintainer": "me"}`), 0644); err != nil {
+		t.Fatalf("failed to write labels: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "runscript"), []byte("#!/bin/sh\necho new\n"), 0755); err != nil {
+		t.Fatalf("failed to write runscript: %s", err)
+	}
+
+	data, err := commitMetadata(&commitSource{rootfs: rootfs, image: rootfs})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	metadata := new(inspect.Metadata)
+	if err := json.Unmarshal(data, metadata); err != nil {
+		t.Fatalf("failed to decode metadata: %s", err)
+	}
+
+	labels := metadata.Attributes.Labels
+	if labels["maintainer"] != "me" {
+		t.Errorf("root filesystem label not found in %v", labels)
+	}
+	if labels[commitSourceLabel] != rootfs {
+		t.Errorf("unexpected commit source label %q", labels[commitSourceLabel])
+	}
+	if labels[commitDateLabel] == "" {
+		t.Errorf("commit date label not found in %v", labels)
+	}
+	if metadata.Attributes.Runscript != "#!/bin/sh\necho new\n" {
+		t.Errorf("unexpected runscript %q", metadata.Attributes.Runscript)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/hpcng/singularity/internal/pkg/devcontainer"
+	"github.com/hpcng/singularity/internal/pkg/volume"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+// DevcontainerUp starts the instance of the development container
+// described by plan. An image built from a definition file is stored
+// next to it and rebuilt when the definition file is more recent or if
+// rebuild is true, missing named volumes are created, and the post
+// create commands are executed in the instance. Nothing is done if
+// the instance is already running.
+func DevcontainerUp(plan *devcontainer.Plan, rebuild bool) error {
+	if ii, err := listInstances("", plan.Name, false); err == nil && len(ii) > 0 {
+		sylog.Infof("Instance %s of the development container is already running", plan.Name)
+		return nil
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("while determining current executable path: %s", err)
+	}
+	run := func(args ...string) error {
+		sylog.Debugf("Executing singularity %v", args)
+		cmd := exec.Command(self, args...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+
+	image := plan.Image
+	if plan.Definition != "" {
+		image = filepath.Join(filepath.Dir(plan.Definition), plan.Name+".sif")
+		if rebuild || isOutdated(image, plan.Definition) {
+			args := []string{"build", "--force"}
+			if plan.Fakeroot {
+				args = append(args, "--fakeroot")
+			}
+			if err := run(append(args, image, plan.Definition)...); err != nil {
+				return fmt.Errorf("while building %s: %s", image, err)
+			}
+		}
+	}
+
+	args := []string{"instance", "start"}
+	for _, m := range plan.Mounts {
+		if m.Type == "volume" {
+			if _, err := volume.Get(volume.Dir(), m.Source); err != nil {
+				if _, err := volume.Create(volume.Dir(), m.Source, volume.DirType, 0, nil); err != nil {
+					return err
+				}
+				sylog.Infof("Volume %s created", m.Source)
+			}
+		}
+		args = append(args, "--mount", m.Spec())
+	}
+	for _, env := range plan.Env {
+		args = append(args, "--env", env)
+	}
+	if err := run(append(args, image, plan.Name)...); err != nil {
+		return fmt.Errorf("while starting %s instance: %s", plan.Name, err)
+	}
+
+	for _, cmd := range plan.PostCreate {
+		sylog.Infof("Running post create command: %v", cmd)
+		args := append([]string{"exec", "--pwd", plan.Pwd, "instance://" + plan.Name}, cmd...)
+		if err := run(args...); err != nil {
+			return fmt.Errorf("post create command %v failed: %s", cmd, err)
+		}
+	}
+
+	for _, port := range plan.Ports {
+		sylog.Infof("Forwarded port %s is reachable on the host network", port)
+	}
+	sylog.Infof("Development container running as instance %s, enter it with 'singularity shell --pwd %s instance://%s'", plan.Name, plan.Pwd, plan.Name)
+	return nil
+}
+
+// isOutdated returns true if image doesn't exist or if it's older
+// than the definition file.
+func isOutdated(image, definition string) bool {
+	fi, err := os.Stat(image)
+	if err != nil {
+		return true
+	}
+	di, err := os.Stat(definition)
+	return err != nil || di.ModTime().After(fi.ModTime())
+}
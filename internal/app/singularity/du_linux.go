@@ -0,0 +1,318 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	"github.com/hpcng/singularity/pkg/image"
+	"github.com/hpcng/singularity/pkg/image/unpacker"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/util/fs/proc"
+	"golang.org/x/sys/unix"
+)
+
+// usageFSTypes are the filesystem types of the instance mounts
+// reported, the writable filesystems a container may fill up.
+var usageFSTypes = map[string]bool{
+	"overlay": true,
+	"tmpfs":   true,
+	"ext3":    true,
+	"ext4":    true,
+	"xfs":     true,
+}
+
+// dirUsage is the apparent size of the files of a directory.
+type dirUsage struct {
+	Path string
+	Size int64
+}
+
+// topDirs returns at most n entries of sizes by decreasing size.
+func topDirs(sizes map[string]int64, n int) []dirUsage {
+	dirs := make([]dirUsage, 0, len(sizes))
+	for p, s := range sizes {
+		dirs = append(dirs, dirUsage{Path: p, Size: s})
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		if dirs[i].Size != dirs[j].Size {
+			return dirs[i].Size > dirs[j].Size
+		}
+		return dirs[i].Path < dirs[j].Path
+	})
+	if len(dirs) > n {
+		dirs = dirs[:n]
+	}
+	return dirs
+}
+
+// topLevel returns the top level entry of the absolute path p.
+func topLevel(p string) string {
+	p = strings.TrimPrefix(filepath.Clean(p), "/")
+	if i := strings.Index(p, "/"); i >= 0 {
+		p = p[:i]
+	}
+	return "/" + p
+}
+
+// dirSizes returns the apparent size of the files of the top level
+// entries of the directory root, without crossing filesystems.
+func dirSizes(root string) (map[string]int64, error) {
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	dev := fi.Sys().(*syscall.Stat_t).Dev
+
+	sizes := make(map[string]int64)
+	err = filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// unreadable directories are not accounted
+			sylog.Debugf("Skipping %s: %s", path, err)
+			return nil
+		}
+		if path == root {
+			return nil
+		}
+		if fi.Sys().(*syscall.Stat_t).Dev != dev {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		sizes[topLevel(rel)] += fi.Size()
+		return nil
+	})
+	return sizes, err
+}
+
+// squashfsSizes returns the apparent size of the files of the top level
+// entries of the squashfs partition part of the image img.
+func squashfsSizes(img *image.Image, part image.Section) (map[string]int64, error) {
+	path := img.Path
+	if img.Type != image.SQUASHFS || part.Offset != 0 {
+		f, err := ioutil.TempFile("", "du-partition-")
+		if err != nil {
+			return nil, fmt.Errorf("while creating temporary file: %s", err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		r := io.NewSectionReader(img.File, int64(part.Offset), int64(part.Size))
+		if _, err := io.Copy(f, r); err != nil {
+			return nil, fmt.Errorf("while copying partition: %s", err)
+		}
+		path = f.Name()
+	}
+
+	entries, err := unpacker.NewSquashfs().List(path)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]int64)
+	for _, e := range entries {
+		if e.Path == "/" {
+			continue
+		}
+		sizes[topLevel(e.Path)] += e.Size
+	}
+	return sizes, nil
+}
+
+func partitionType(t uint32) string {
+	switch t {
+	case image.SQUASHFS:
+		return "squashfs"
+	case image.EXT3:
+		return "ext3"
+	case image.ENCRYPTSQUASHFS:
+		return "encryptfs"
+	case image.SANDBOX:
+		return "sandbox"
+	}
+	return "raw"
+}
+
+func partitionName(name string) string {
+	if name == image.RootFs {
+		return "rootfs"
+	}
+	return name
+}
+
+func printTopDirs(w io.Writer, title string, sizes map[string]int64, top int) error {
+	var total int64
+	for _, s := range sizes {
+		total += s
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 4, ' ', 0)
+	fmt.Fprintf(tw, "\n%s (%s of files)\n", title, fs.FindSize(total))
+	fmt.Fprintln(tw, "DIRECTORY\tSIZE")
+	for _, d := range topDirs(sizes, top) {
+		fmt.Fprintf(tw, "%s\t%s\n", d.Path, fs.FindSize(d.Size))
+	}
+	return tw.Flush()
+}
+
+// PrintImageUsage writes to w the size of the partitions and data objects
+// of the image path, and the top directories of its filesystems by
+// apparent size, at most top directories are reported.
+func PrintImageUsage(w io.Writer, path string, top int) error {
+	img, err := image.Init(path, false)
+	if err != nil {
+		return fmt.Errorf("could not open image %s: %s", path, err)
+	}
+	defer img.File.Close()
+
+	if img.Type == image.SANDBOX {
+		sizes, err := dirSizes(img.Path)
+		if err != nil {
+			return fmt.Errorf("while computing %s usage: %s", img.Path, err)
+		}
+		fmt.Fprintf(w, "Image: %s (sandbox)\n", img.Path)
+		if top > 0 {
+			return printTopDirs(w, "Top directories", sizes, top)
+		}
+		return nil
+	}
+
+	fi, err := img.File.Stat()
+	if err != nil {
+		return fmt.Errorf("while getting %s size: %s", img.Path, err)
+	}
+	fmt.Fprintf(w, "Image: %s (%s)\n\n", img.Path, fs.FindSize(fi.Size()))
+
+	tw := tabwriter.NewWriter(w, 0, 8, 4, ' ', 0)
+	fmt.Fprintln(tw, "PARTITION\tTYPE\tSIZE")
+	for _, p := range img.Partitions {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", partitionName(p.Name), partitionType(p.Type), fs.FindSize(int64(p.Size)))
+	}
+	if len(img.Sections) > 0 {
+		var size uint64
+		for _, s := range img.Sections {
+			size += s.Size
+		}
+		fmt.Fprintf(tw, "%d data objects\t-\t%s\n", len(img.Sections), fs.FindSize(int64(size)))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if top <= 0 {
+		return nil
+	}
+	for _, p := range img.Partitions {
+		title := fmt.Sprintf("Top directories of %s partition", partitionName(p.Name))
+		switch p.Type {
+		case image.SQUASHFS:
+			sizes, err := squashfsSizes(img, p)
+			if err != nil {
+				return fmt.Errorf("while listing %s partition: %s", partitionName(p.Name), err)
+			}
+			if err := printTopDirs(w, title, sizes, top); err != nil {
+				return err
+			}
+		default:
+			sylog.Infof("Content of %s %s partition is not listed", partitionName(p.Name), partitionType(p.Type))
+		}
+	}
+	return nil
+}
+
+// PrintInstanceUsage writes to w the usage of the writable filesystems
+// mounted in the instance name, like the root overlay filesystem whose
+// usage is the one of its upper layer, against their size limit, and
+// the top directories of the tmpfs mounts by apparent size.
+func PrintInstanceUsage(w io.Writer, name string, top int) error {
+	ii, err := listInstances("", name, false)
+	if err != nil {
+		return fmt.Errorf("could not retrieve instance list: %v", err)
+	}
+	if len(ii) != 1 {
+		return fmt.Errorf("no instance %s found", name)
+	}
+	i := ii[0]
+
+	entries, err := proc.GetMountInfoEntry(fmt.Sprintf("/proc/%d/mountinfo", i.Pid))
+	if err != nil {
+		return fmt.Errorf("while reading %s instance mounts: %s", i.Name, err)
+	}
+	root := fmt.Sprintf("/proc/%d/root", i.Pid)
+
+	fmt.Fprintf(w, "Instance: %s (%s)\n\n", i.Name, i.Image)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 4, ' ', 0)
+	fmt.Fprintln(tw, "MOUNT\tTYPE\tSIZE\tUSED\tAVAIL\tUSE%")
+	tmpfs := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		if !usageFSTypes[e.FSType] && e.Point != "/" {
+			continue
+		}
+		if strings.HasPrefix(e.Point, "/proc/") || strings.HasPrefix(e.Point, "/sys/") || seen[e.Point] {
+			continue
+		}
+		seen[e.Point] = true
+
+		st := new(unix.Statfs_t)
+		if err := unix.Statfs(filepath.Join(root, e.Point), st); err != nil {
+			sylog.Warningf("Could not get %s usage: %s", e.Point, err)
+			continue
+		}
+		size := int64(st.Blocks) * st.Bsize
+		used := int64(st.Blocks-st.Bfree) * st.Bsize
+		percent := "-"
+		if size > 0 {
+			percent = fmt.Sprintf("%.0f%%", float64(used)/float64(size)*100)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.Point,
+			e.FSType,
+			fs.FindSize(size),
+			fs.FindSize(used),
+			fs.FindSize(int64(st.Bavail)*st.Bsize),
+			percent,
+		)
+		if e.FSType == "tmpfs" {
+			tmpfs = append(tmpfs, e.Point)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if top <= 0 {
+		return nil
+	}
+	for _, point := range tmpfs {
+		sizes, err := dirSizes(filepath.Join(root, point))
+		if err != nil {
+			sylog.Warningf("Could not list %s: %s", point, err)
+			continue
+		}
+		paths := make(map[string]int64, len(sizes))
+		for d, s := range sizes {
+			paths[filepath.Join(point, d)] = s
+		}
+		if err := printTopDirs(w, "Top directories of "+point, paths, top); err != nil {
+			return err
+		}
+	}
+	return nil
+}
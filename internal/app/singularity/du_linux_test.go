@@ -0,0 +1,90 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTopLevel(t *testing.T) {
+	tests := map[string]string{
+		"/usr/bin/sh": "/usr",
+		"/usr":        "/usr",
+		"etc/passwd":  "/etc",
+		"/":           "/",
+	}
+	for p, want := range tests {
+		if got := topLevel(p); got != want {
+			t.Errorf("got %q instead of %q for %q", got, want, p)
+		}
+	}
+}
+
+func TestTopDirs(t *testing.T) {
+	sizes := map[string]int64{"/a": 10, "/b": 30, "/c": 20, "/d": 20}
+
+	want := []dirUsage{{"/b", 30}, {"/c", 20}, {"/d", 20}}
+	if got := topDirs(sizes, 3); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v instead of %v", got, want)
+	}
+	if got := topDirs(sizes, 10); len(got) != 4 {
+		t.Errorf("got %d directories instead of 4", len(got))
+	}
+}
+
+func TestDirSizes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "du-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]int{
+		"usr/bin/a":   100,
+		"usr/lib/b":   200,
+		"opt/data/c":  50,
+		"environment": 10,
+	}
+	for f, size := range files {
+		path := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %s", err)
+		}
+		if err := ioutil.WriteFile(path, bytes.Repeat([]byte("x"), size), 0644); err != nil {
+			t.Fatalf("failed to write file: %s", err)
+		}
+	}
+
+	sizes, err := dirSizes(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// directories sizes depend on the filesystem
+	if got := sizes["/usr"]; got < 300 {
+		t.Errorf("got %d bytes for /usr instead of at least 300", got)
+	}
+	if got := sizes["/opt"]; got < 50 || got >= sizes["/usr"] {
+		t.Errorf("got %d bytes for /opt", got)
+	}
+	if got := sizes["/environment"]; got != 10 {
+		t.Errorf("got %d bytes for /environment instead of 10", got)
+	}
+
+	var b bytes.Buffer
+	if err := PrintImageUsage(&b, dir, 1); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	out := b.String()
+	if !strings.Contains(out, "/usr") || strings.Contains(out, "/opt") {
+		t.Errorf("unexpected top directories:\n%s", out)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/hpcng/singularity/internal/pkg/buildcfg"
+	"github.com/hpcng/singularity/internal/pkg/syecl"
+	"github.com/hpcng/singularity/pkg/sypgp"
+	"golang.org/x/crypto/openpgp"
+)
+
+// checkECL applies the execution control list to the opened image
+// for the images executed outside of the singularity engine, it
+// returns an error if the image is not allowed to run.
+func checkECL(f *os.File) error {
+	ecl, err := syecl.LoadConfig(buildcfg.ECL_FILE)
+	if err != nil {
+		// the engine ignores a missing or unreadable ECL file too
+		return nil
+	}
+	if err := ecl.ValidateConfig(); err != nil {
+		return fmt.Errorf("while validating ECL configuration: %s", err)
+	}
+
+	var kr openpgp.KeyRing = openpgp.EntityList{}
+	if ecl.Activated {
+		keyring := sypgp.NewHandle(buildcfg.SINGULARITY_CONFDIR, sypgp.GlobalHandleOpt())
+		kr, err = keyring.LoadPubKeyring()
+		if err != nil {
+			return fmt.Errorf("while obtaining keyring for ECL: %s", err)
+		}
+	}
+
+	if ok, err := ecl.ShouldRunFp(f, kr); err != nil {
+		return fmt.Errorf("while checking container image with ECL: %s", err)
+	} else if !ok {
+		return errors.New("image prohibited by ECL")
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/util/fs/proc"
+)
+
+// containerPid returns the process ID of the container target, either an
+// instance name with an optional instance:// prefix or the PID of a
+// container as reported by singularity ps.
+func containerPid(target string) (int, error) {
+	if pid, err := strconv.Atoi(target); err == nil {
+		return pid, nil
+	}
+	name := strings.TrimPrefix(target, "instance://")
+	ii, err := listInstances("", name, false)
+	if err != nil {
+		return 0, fmt.Errorf("could not retrieve instance list: %v", err)
+	}
+	if len(ii) != 1 {
+		return 0, fmt.Errorf("no instance %s found", name)
+	}
+	return ii[0].Pid, nil
+}
+
+// containerRoot returns the path of the root filesystem of the container
+// process pid. Processes of containers started with the setuid workflow
+// are not dumpable, their root filesystem can only be accessed by root
+// and they are refused instead of being seen as empty.
+func containerRoot(pid int) (string, error) {
+	// the trailing slash resolves the root link
+	root := fmt.Sprintf("/proc/%d/root/", pid)
+	if _, err := os.Stat(root); os.IsPermission(err) {
+		return "", fmt.Errorf("root filesystem of container %d is not accessible, containers started with the setuid workflow can only be accessed by root", pid)
+	} else if err != nil {
+		return "", fmt.Errorf("while accessing root filesystem of container %d: %s", pid, err)
+	}
+	return root, nil
+}
+
+// treeExporter writes the entries of a directory tree to a tar archive.
+type treeExporter struct {
+	tw   *tar.Writer
+	root string
+	// skip are the paths of the tree not exported, like mount points.
+	skip map[string]bool
+	// since, if not zero, exports only the entries changed after.
+	since time.Time
+	// links are the archive names of the hard linked files by device
+	// and inode numbers.
+	links map[[2]uint64]string
+	// written are the directories already written.
+	written map[string]bool
+	// skipped counts the entries which could not be read.
+	skipped int
+}
+
+// export exports the directory tree.
+func (e *treeExporter) export() error {
+	e.links = make(map[[2]uint64]string)
+	e.written = map[string]bool{"/": true}
+
+	return filepath.Walk(e.root, func(path string, fi os.FileInfo, err error) error {
+		rel, relErr := filepath.Rel(e.root, path)
+		if relErr != nil {
+			return relErr
+		}
+		name := filepath.Join("/", rel)
+
+		if err != nil && name == "/" {
+			return fmt.Errorf("while reading root filesystem: %s", err)
+		} else if err != nil {
+			sylog.Warningf("Skipping %s: %s", name, err)
+			e.skipped++
+			return nil
+		}
+		if e.skip[name] {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if name == "/" {
+			return nil
+		}
+
+		// entries created, modified or copied up to the writable layer
+		st := fi.Sys().(*syscall.Stat_t)
+		if !e.since.IsZero() && time.Unix(st.Ctim.Unix()).Before(e.since) {
+			return nil
+		}
+		return e.write(path, name, fi)
+	})
+}
+
+// write writes the entry name of the tree at path to the archive, its
+// parent directories are written first.
+func (e *treeExporter) write(path, name string, fi os.FileInfo) error {
+	if parent := filepath.Dir(name); !e.written[parent] {
+		pfi, err := os.Lstat(filepath.Join(e.root, parent))
+		if err != nil {
+			return fmt.Errorf("while getting %s information: %s", parent, err)
+		}
+		if err := e.write(filepath.Join(e.root, parent), parent, pfi); err != nil {
+			return err
+		}
+	}
+
+	link := ""
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return fmt.Errorf("while reading link %s: %s", name, err)
+		}
+		link = target
+	}
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		// sockets can't be archived
+		sylog.Debugf("Skipping %s: %s", name, err)
+		return nil
+	}
+	hdr.Name = strings.TrimPrefix(name, "/")
+	if fi.IsDir() {
+		hdr.Name += "/"
+	}
+	// host user and group names don't apply to the container files
+	hdr.Uname, hdr.Gname = "", ""
+
+	var f *os.File
+	if fi.Mode().IsRegular() {
+		st := fi.Sys().(*syscall.Stat_t)
+		key := [2]uint64{uint64(st.Dev), st.Ino}
+		if first, ok := e.links[key]; ok && st.Nlink > 1 {
+			hdr.Typeflag = tar.TypeLink
+			hdr.Linkname = first
+			hdr.Size = 0
+		} else {
+			f, err = os.Open(path)
+			if err != nil {
+				sylog.Warningf("Skipping %s: %s", name, err)
+				e.skipped++
+				return nil
+			}
+			defer f.Close()
+			e.links[key] = hdr.Name
+		}
+	}
+
+	if err := e.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("while writing %s header: %s", name, err)
+	}
+	if f != nil {
+		if _, err := io.CopyN(e.tw, f, hdr.Size); err != nil {
+			return fmt.Errorf("while writing %s: %s", name, err)
+		}
+	}
+	if fi.IsDir() {
+		e.written[name] = true
+	}
+	return nil
+}
+
+// ExportContainer writes to w a tar archive of the root filesystem of the
+// running container target, an instance name or a container PID, as seen
+// from the container. The other filesystems mounted in the container, like
+// the bind mounts, are not exported. If writableLayer is true, only the
+// entries created or modified since the container started are exported,
+// the deleted entries are not recorded.
+func ExportContainer(w io.Writer, target string, writableLayer bool) error {
+	pid, err := containerPid(target)
+	if err != nil {
+		return err
+	}
+	root, err := containerRoot(pid)
+	if err != nil {
+		return err
+	}
+
+	entries, err := proc.GetMountInfoEntry(fmt.Sprintf("/proc/%d/mountinfo", pid))
+	if err != nil {
+		return fmt.Errorf("while reading container mounts: %s", err)
+	}
+	e := &treeExporter{
+		tw:   tar.NewWriter(w),
+		root: root,
+		skip: make(map[string]bool),
+	}
+	for _, m := range entries {
+		if m.Point != "/" {
+			e.skip[m.Point] = true
+		}
+	}
+	if writableLayer {
+		st, err := proc.GetStat(pid)
+		if err != nil {
+			return fmt.Errorf("while getting container start time: %s", err)
+		}
+		e.since = st.StartTime
+	}
+
+	if err := e.export(); err != nil {
+		return err
+	}
+	if err := e.tw.Close(); err != nil {
+		return fmt.Errorf("while closing archive: %s", err)
+	}
+	if e.skipped > 0 {
+		sylog.Warningf("%d entries could not be read and were not exported", e.skipped)
+	}
+	return nil
+}
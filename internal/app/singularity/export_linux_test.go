@@ -0,0 +1,146 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func readArchive(t *testing.T, r io.Reader) map[string]*tar.Header {
+	headers := make(map[string]*tar.Header)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("failed to read archive: %s", err)
+		}
+		headers[hdr.Name] = hdr
+	}
+	return headers
+}
+
+func names(headers map[string]*tar.Header) []string {
+	n := make([]string, 0, len(headers))
+	for name := range headers {
+		n = append(n, name)
+	}
+	sort.Strings(n)
+	return n
+}
+
+func TestTreeExporter(t *testing.T) {
+	root, err := ioutil.TempDir("", "export-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, d := range []string{"etc", "home/user", "opt/app"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+			t.Fatalf("failed to create directory: %s", err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "etc/config"), []byte("config"), 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+	if err := os.Link(filepath.Join(root, "etc/config"), filepath.Join(root, "etc/link")); err != nil {
+		t.Fatalf("failed to create hard link: %s", err)
+	}
+	if err := os.Symlink("config", filepath.Join(root, "etc/symlink")); err != nil {
+		t.Fatalf("failed to create symlink: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "home/user/data"), nil, 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+
+	var b bytes.Buffer
+	e := &treeExporter{
+		tw:   tar.NewWriter(&b),
+		root: root,
+		skip: map[string]bool{"/home": true},
+	}
+	if err := e.export(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	e.tw.Close()
+
+	headers := readArchive(t, &b)
+	want := []string{"etc/", "etc/config", "etc/link", "etc/symlink", "opt/", "opt/app/"}
+	if got := names(headers); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got entries %v instead of %v", got, want)
+	}
+	if h := headers["etc/link"]; h.Typeflag != tar.TypeLink || h.Linkname != "etc/config" {
+		t.Errorf("etc/link is not a hard link to etc/config")
+	}
+	if h := headers["etc/symlink"]; h.Typeflag != tar.TypeSymlink || h.Linkname != "config" {
+		t.Errorf("etc/symlink is not a symlink to config")
+	}
+
+	// only the entries changed since are exported, with their parents
+	time.Sleep(50 * time.Millisecond)
+	since := time.Now()
+	time.Sleep(50 * time.Millisecond)
+	if err := ioutil.WriteFile(filepath.Join(root, "opt/app/new"), []byte("new"), 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+
+	b.Reset()
+	e = &treeExporter{
+		tw:    tar.NewWriter(&b),
+		root:  root,
+		since: since,
+	}
+	if err := e.export(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	e.tw.Close()
+
+	want = []string{"opt/", "opt/app/", "opt/app/new"}
+	if got := names(readArchive(t, &b)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got entries %v instead of %v", got, want)
+	}
+}
+
+func TestTreeExporterRootError(t *testing.T) {
+	root, err := ioutil.TempDir("", "export-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	// an unreadable root must not produce an empty archive
+	e := &treeExporter{
+		tw:   tar.NewWriter(ioutil.Discard),
+		root: filepath.Join(root, "missing") + "/",
+	}
+	if err := e.export(); err == nil {
+		t.Errorf("unexpected success with a missing root")
+	}
+}
+
+func TestContainerRoot(t *testing.T) {
+	root, err := containerRoot(os.Getpid())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if fi, err := os.Stat(root); err != nil || !fi.IsDir() {
+		t.Errorf("%s is not the process root directory", root)
+	}
+	if _, err := containerRoot(0); err == nil {
+		t.Errorf("unexpected success with a bad process ID")
+	}
+}
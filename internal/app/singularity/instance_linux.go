@@ -6,19 +6,30 @@
 package singularity
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"syscall"
 	"text/tabwriter"
 	"time"
 
 	"github.com/hpcng/singularity/internal/pkg/instance"
+	"github.com/hpcng/singularity/internal/pkg/util/user"
 	"github.com/hpcng/singularity/pkg/sylog"
 	"github.com/hpcng/singularity/pkg/util/fs/proc"
+	"golang.org/x/sys/unix"
 )
 
+// shutdownScript is the path of the instance shutdown
+// script within the container.
+const shutdownScript = "/.singularity.d/shutdownscript"
+
 type instanceInfo struct {
 	Instance   string `json:"instance"`
 	Pid        int    `json:"pid"`
@@ -26,13 +37,15 @@ type instanceInfo struct {
 	IP         string `json:"ip"`
 	LogErrPath string `json:"logErrPath"`
 	LogOutPath string `json:"logOutPath"`
+	System     bool   `json:"system,omitempty"`
+	SSHPort    int    `json:"sshPort,omitempty"`
 }
 
 // PrintInstanceList fetches instance list, applying name and
 // user filters, and prints it in a regular or a JSON format (if
 // formatJSON is true) to the passed writer. Additionally, fetches
-// log paths (if showLogs is true).
-func PrintInstanceList(w io.Writer, name, user string, formatJSON bool, showLogs bool) error {
+// log paths (if showLogs is true) and system instances (if all is true).
+func PrintInstanceList(w io.Writer, name, user string, all bool, formatJSON bool, showLogs bool) error {
 	if formatJSON && showLogs {
 		sylog.Fatalf("more than one flags have been set")
 	}
@@ -44,6 +57,13 @@ func PrintInstanceList(w io.Writer, name, user string, formatJSON bool, showLogs
 	if err != nil {
 		return fmt.Errorf("could not retrieve instance list: %v", err)
 	}
+	if all {
+		si, err := instance.ListSystem(name, instance.SingSubDir)
+		if err != nil {
+			return fmt.Errorf("could not retrieve system instance list: %v", err)
+		}
+		ii = append(ii, si...)
+	}
 
 	if showLogs {
 		_, err := fmt.Fprintln(tabWriter, "INSTANCE NAME\tPID\tLOGS")
@@ -60,7 +80,24 @@ func PrintInstanceList(w io.Writer, name, user string, formatJSON bool, showLogs
 		return nil
 	}
 
-	if !formatJSON {
+	if !formatJSON && all {
+		_, err := fmt.Fprintln(tabWriter, "INSTANCE NAME\tPID\tIP\tIMAGE\tSCOPE")
+		if err != nil {
+			return fmt.Errorf("could not write list header: %v", err)
+		}
+
+		for _, i := range ii {
+			scope := "user"
+			if i.System {
+				scope = "system"
+			}
+			_, err = fmt.Fprintf(tabWriter, "%s\t%d\t%s\t%s\t%s\n", i.Name, i.Pid, i.IP, i.Image, scope)
+			if err != nil {
+				return fmt.Errorf("could not write instance info: %v", err)
+			}
+		}
+		return nil
+	} else if !formatJSON {
 		_, err := fmt.Fprintln(tabWriter, "INSTANCE NAME\tPID\tIP\tIMAGE")
 		if err != nil {
 			return fmt.Errorf("could not write list header: %v", err)
@@ -83,6 +120,8 @@ func PrintInstanceList(w io.Writer, name, user string, formatJSON bool, showLogs
 		instances[i].IP = ii[i].IP
 		instances[i].LogErrPath = ii[i].LogErrPath
 		instances[i].LogOutPath = ii[i].LogOutPath
+		instances[i].System = ii[i].System
+		instances[i].SSHPort = ii[i].SSHPort
 	}
 
 	enc := json.NewEncoder(w)
@@ -100,8 +139,8 @@ func PrintInstanceList(w io.Writer, name, user string, formatJSON bool, showLogs
 // WriteInstancePidFile fetches instance's PID and writes it to the pidFile,
 // truncating it if it already exists. Note that the name should not be a glob,
 // i.e. name should identify a single instance only, otherwise an error is returned.
-func WriteInstancePidFile(name, pidFile string) error {
-	inst, err := instance.List("", name, instance.SingSubDir)
+func WriteInstancePidFile(name string, system bool, pidFile string) error {
+	inst, err := listInstances("", name, system)
 	if err != nil {
 		return fmt.Errorf("could not retrieve instance list: %v", err)
 
@@ -123,12 +162,24 @@ func WriteInstancePidFile(name, pidFile string) error {
 	return nil
 }
 
+// listInstances returns the user instances or the system
+// instances if system is true.
+func listInstances(user, name string, system bool) ([]*instance.File, error) {
+	if system {
+		return instance.ListSystem(name, instance.SingSubDir)
+	}
+	return instance.List(user, name, instance.SingSubDir)
+}
+
 // StopInstance fetches instance list, applying name and
-// user filters, and stops them by sending a signal sig. If an instance
-// is still running after a grace period defined by timeout is expired,
-// it will be forcibly killed.
-func StopInstance(name, user string, sig syscall.Signal, timeout time.Duration) error {
-	ii, err := instance.List(user, name, instance.SingSubDir)
+// user filters or system instances, and stops them by sending a signal sig.
+// The shutdown script of the instances, if any, is executed first and is given
+// gracePeriod to complete, the shutdown script is skipped if gracePeriod is zero.
+// If an instance is still running after a grace period defined by timeout is
+// expired, it will be forcibly killed. At most parallel shutdown scripts are
+// executed concurrently, without limit if parallel is zero.
+func StopInstance(name, user string, system bool, sig syscall.Signal, gracePeriod, timeout time.Duration, parallel int) error {
+	ii, err := listInstances(user, name, system)
 	if err != nil {
 		return fmt.Errorf("could not retrieve instance list: %v", err)
 	}
@@ -136,6 +187,48 @@ func StopInstance(name, user string, sig syscall.Signal, timeout time.Duration)
 		return fmt.Errorf("no instance found")
 	}
 
+	// the instance files of other users are left untouched
+	record := user == ""
+	stopper := stopperName()
+
+	if parallel <= 0 {
+		parallel = len(ii)
+	}
+
+	if gracePeriod > 0 {
+		var wg sync.WaitGroup
+		slots := make(chan struct{}, parallel)
+		for _, i := range ii {
+			if user != "" {
+				sylog.Warningf("Not running shutdown script of %s instance: joining instances of other users is not supported", i.Name)
+				continue
+			}
+			wg.Add(1)
+			slots <- struct{}{}
+			go func(i *instance.File) {
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+				reason := fmt.Sprintf("%s sent by %s", unix.SignalName(sig), stopper)
+				if ran, err := runShutdownScript(i, gracePeriod); err != nil {
+					sylog.Warningf("%s instance: %s", i.Name, err)
+					reason = fmt.Sprintf("%s, %s", err, reason)
+				} else if ran {
+					reason = "shutdown script completed, " + reason
+				}
+				if record {
+					setStopReason(i, reason)
+				}
+			}(i)
+		}
+		wg.Wait()
+	} else if record {
+		for _, i := range ii {
+			setStopReason(i, fmt.Sprintf("%s sent by %s", unix.SignalName(sig), stopper))
+		}
+	}
+
 	stoppedPID := make(chan int, 1)
 	stopped := make([]int, 0)
 
@@ -159,6 +252,9 @@ func StopInstance(name, user string, sig syscall.Signal, timeout time.Duration)
 					}
 				}
 
+				if record {
+					setStopReason(i, fmt.Sprintf("%s, killed after %s timeout", i.StopReason, timeout))
+				}
 				sylog.Infof("Killing %s instance of %s (PID=%d) (Timeout)\n", i.Name, i.Image, i.Pid)
 				syscall.Kill(i.Pid, syscall.SIGKILL)
 			}
@@ -167,8 +263,63 @@ func StopInstance(name, user string, sig syscall.Signal, timeout time.Duration)
 	}
 }
 
+// runShutdownScript executes the shutdown script of the instance i, if
+// the container provides one, and waits at most gracePeriod for it to
+// complete. It returns false if the container has no shutdown script.
+func runShutdownScript(i *instance.File, gracePeriod time.Duration) (bool, error) {
+	// the container filesystem is reachable through the
+	// instance process root directory
+	path := filepath.Join("/proc", strconv.Itoa(i.Pid), "root", shutdownScript)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		sylog.Debugf("No shutdown script found in %s instance", i.Name)
+		return false, nil
+	} else if err != nil {
+		sylog.Debugf("Could not check shutdown script presence: %s", err)
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return false, fmt.Errorf("while determining current executable path: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+
+	sylog.Infof("Running shutdown script of %s instance (PID=%d)\n", i.Name, i.Pid)
+	cmd := exec.CommandContext(ctx, self, "exec", "instance://"+i.Name, shutdownScript)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return true, fmt.Errorf("shutdown script didn't complete within %s", gracePeriod)
+	} else if err != nil {
+		return true, fmt.Errorf("shutdown script failed: %s", err)
+	}
+	return true, nil
+}
+
+// stopperName returns the name of the user stopping instances.
+func stopperName() string {
+	if u, err := user.CurrentOriginal(); err == nil {
+		return u.Name
+	}
+	return fmt.Sprintf("uid %d", os.Getuid())
+}
+
+// setStopReason records reason in the instance file of i.
+func setStopReason(i *instance.File, reason string) {
+	i.StopReason = reason
+	if err := i.Update(); err != nil {
+		sylog.Debugf("Could not record %s instance stop reason: %s", i.Name, err)
+	}
+}
+
 func killInstance(i *instance.File, sig syscall.Signal, stoppedPID chan<- int) {
 	sylog.Infof("Stopping %s instance of %s (PID=%d)\n", i.Name, i.Image, i.Pid)
+	if i.SSHPort != 0 {
+		stopInstanceSSHD(i)
+	}
 	syscall.Kill(i.Pid, sig)
 
 	for {
@@ -0,0 +1,42 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"os"
+	"os/user"
+	"testing"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/instance"
+)
+
+func TestRunShutdownScript(t *testing.T) {
+	// the test process root directory is the host one
+	// which doesn't provide a shutdown script
+	if _, err := os.Stat(shutdownScript); err == nil {
+		t.Skipf("%s found on the host", shutdownScript)
+	}
+
+	i := &instance.File{Name: "test", Pid: os.Getpid()}
+	ran, err := runShutdownScript(i, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ran {
+		t.Errorf("shutdown script reported as executed")
+	}
+}
+
+func TestStopperName(t *testing.T) {
+	u, err := user.Current()
+	if err != nil {
+		t.Skipf("could not determine current user: %s", err)
+	}
+	if name := stopperName(); name != u.Username {
+		t.Errorf("got stopper name %q instead of %q", name, u.Username)
+	}
+}
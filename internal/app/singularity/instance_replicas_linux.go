@@ -0,0 +1,98 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+// InstanceReplicaEnv is the environment variable holding the replica
+// index of an instance start command executed by StartInstanceReplicas.
+const InstanceReplicaEnv = "SINGULARITY_INSTANCE_REPLICA"
+
+// InstanceReplicaName returns the name of the replica index (starting at
+// 1) of the instances named after template. The template may hold a
+// %d verb replaced by the index, the index is appended with a dash
+// otherwise.
+func InstanceReplicaName(template string, index int) string {
+	if strings.Contains(template, "%") {
+		return fmt.Sprintf(template, index)
+	}
+	return fmt.Sprintf("%s-%d", template, index)
+}
+
+// InstanceReplicaIndex returns the replica index set by
+// StartInstanceReplicas for the current process, or 0 if the
+// process doesn't start a replica.
+func InstanceReplicaIndex() int {
+	index, err := strconv.Atoi(os.Getenv(InstanceReplicaEnv))
+	if err != nil || index < 1 {
+		return 0
+	}
+	return index
+}
+
+// StartInstanceReplicas starts replicas instances named after the name
+// template by executing the current executable with args, the instance
+// start command line, for each replica. At most parallel replicas are
+// started concurrently, the output of each start command is printed once
+// completed.
+func StartInstanceReplicas(args []string, name string, replicas, parallel int) error {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("while determining current executable path: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	failed := 0
+	slots := make(chan struct{}, parallel)
+
+	for i := 1; i <= replicas; i++ {
+		wg.Add(1)
+		slots <- struct{}{}
+
+		go func(i int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			replica := InstanceReplicaName(name, i)
+			sylog.Debugf("Starting %s instance", replica)
+
+			cmd := exec.Command(self, args...)
+			cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%d", InstanceReplicaEnv, i))
+			output, err := cmd.CombinedOutput()
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			os.Stderr.Write(output)
+			if err != nil {
+				sylog.Errorf("Failed to start %s instance: %s", replica, err)
+				failed++
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d replicas failed to start", failed, replicas)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"os"
+	"testing"
+)
+
+func TestInstanceReplicaName(t *testing.T) {
+	tests := []struct {
+		template string
+		index    int
+		expect   string
+	}{
+		{"worker", 1, "worker-1"},
+		{"worker%d", 2, "worker2"},
+		{"worker-%02d-db", 3, "worker-03-db"},
+	}
+	for _, tt := range tests {
+		if name := InstanceReplicaName(tt.template, tt.index); name != tt.expect {
+			t.Errorf("got %s instead of %s for %s replica %d", name, tt.expect, tt.template, tt.index)
+		}
+	}
+}
+
+func TestInstanceReplicaIndex(t *testing.T) {
+	defer os.Unsetenv(InstanceReplicaEnv)
+
+	for value, expect := range map[string]int{"": 0, "bad": 0, "-1": 0, "3": 3} {
+		os.Setenv(InstanceReplicaEnv, value)
+		if index := InstanceReplicaIndex(); index != expect {
+			t.Errorf("got replica index %d instead of %d for %q", index, expect, value)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/hpcng/singularity/internal/pkg/instance"
+	"github.com/hpcng/singularity/internal/pkg/util/user"
+	"github.com/hpcng/singularity/pkg/syfs"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+const (
+	sshdDir            = "sshd"
+	sshdPidFile        = "sshd.pid"
+	sshdAuthorizedKeys = "authorized_keys"
+)
+
+// sshdScript starts the SSH server found in the container, OpenSSH or
+// Dropbear, listening on port $2 with its host key and pid file in the
+// directory $1. Both servers detach once listening.
+const sshdScript = `dir="$1"
+port="$2"
+PATH="$PATH:/usr/local/sbin:/usr/sbin:/sbin"
+if command -v sshd >/dev/null 2>&1; then
+	key="$dir/ssh_host_ed25519_key"
+	if [ ! -f "$key" ]; then
+		ssh-keygen -q -t ed25519 -N "" -f "$key" || exit 1
+	fi
+	exec "$(command -v sshd)" -p "$port" -h "$key" \
+		-o PidFile="$dir/` + sshdPidFile + `" \
+		-o AuthorizedKeysFile="$dir/` + sshdAuthorizedKeys + `" \
+		-o PasswordAuthentication=no -o UsePAM=no -o StrictModes=no
+elif command -v dropbear >/dev/null 2>&1; then
+	key="$dir/dropbear_ecdsa_host_key"
+	if [ ! -f "$key" ]; then
+		dropbearkey -t ecdsa -f "$key" >/dev/null || exit 1
+	fi
+	exec dropbear -p "$port" -r "$key" -P "$dir/` + sshdPidFile + `" -s
+fi
+echo "no sshd or dropbear SSH server found in container" >&2
+exit 1
+`
+
+// InstanceSSHDir returns the directory of the host key, the authorized
+// keys and the pid file of the SSH server of the instance name of the
+// user username, the current user if username is empty.
+func InstanceSSHDir(name, username string) (string, error) {
+	dir := syfs.ConfigDir()
+	if username != "" {
+		var err error
+		dir, err = syfs.ConfigDirForUsername(username)
+		if err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(dir, sshdDir, name), nil
+}
+
+// PrepareInstanceSSHD creates the SSH server directory of the instance
+// name holding the public keys of the current user, read from
+// ~/.ssh/authorized_keys and ~/.ssh/*.pub, and returns its path. The
+// directory must be bound at the same path in the container. The host
+// key created in it on first start is kept for the next instances with
+// the same name.
+func PrepareInstanceSSHD(name string, system bool) (string, error) {
+	// don't touch the directory of a running instance
+	if ii, err := listInstances("", name, system); err == nil && len(ii) > 0 {
+		return "", fmt.Errorf("instance %s already exists", name)
+	}
+
+	pw, err := user.CurrentOriginal()
+	if err != nil {
+		return "", fmt.Errorf("while getting current user: %s", err)
+	}
+
+	sshDir := filepath.Join(pw.Dir, ".ssh")
+	files, _ := filepath.Glob(filepath.Join(sshDir, "*.pub"))
+	files = append([]string{filepath.Join(sshDir, "authorized_keys")}, files...)
+
+	var keys bytes.Buffer
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return "", fmt.Errorf("while reading public keys: %s", err)
+		}
+		keys.Write(b)
+		if len(b) > 0 && b[len(b)-1] != '\n' {
+			keys.WriteByte('\n')
+		}
+	}
+	if keys.Len() == 0 {
+		return "", fmt.Errorf("no public key found in %s, the SSH server only accepts public key authentication", sshDir)
+	}
+
+	dir, err := InstanceSSHDir(name, "")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("while creating SSH server directory: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, sshdAuthorizedKeys), keys.Bytes(), 0600); err != nil {
+		return "", fmt.Errorf("while writing authorized keys: %s", err)
+	}
+	// a pid file left by a previous instance is stale
+	os.Remove(filepath.Join(dir, sshdPidFile))
+
+	return dir, nil
+}
+
+// StartInstanceSSHD starts the SSH server found in the instance name on
+// port, or on a free port if port is 0, and records the port in the
+// instance file. It returns the SSH command to connect to the
+// instance.
+func StartInstanceSSHD(name string, system bool, port int) (string, error) {
+	ii, err := listInstances("", name, system)
+	if err != nil {
+		return "", fmt.Errorf("could not retrieve instance list: %v", err)
+	}
+	if len(ii) != 1 {
+		return "", fmt.Errorf("unexpected instance count: %d", len(ii))
+	}
+	i := ii[0]
+
+	// with the host network, the port must be free on the host
+	if i.IP == "" {
+		if port, err = freePort(port); err != nil {
+			return "", fmt.Errorf("while allocating SSH server port: %s", err)
+		}
+	} else if port == 0 {
+		port = 22
+	}
+
+	dir, err := InstanceSSHDir(name, "")
+	if err != nil {
+		return "", err
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("while determining current executable path: %s", err)
+	}
+
+	sylog.Verbosef("Starting SSH server of %s instance on port %d", name, port)
+	cmd := exec.Command(self, "exec", "instance://"+name, "/bin/sh", "-c", sshdScript, "sshd", dir, strconv.Itoa(port))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("while starting SSH server: %s: %s", err, bytes.TrimSpace(output))
+	}
+
+	i.SSHPort = port
+	if err := i.Update(); err != nil {
+		return "", fmt.Errorf("while recording SSH server port: %s", err)
+	}
+
+	host := i.IP
+	if host == "" {
+		if host, err = os.Hostname(); err != nil {
+			host = "localhost"
+		}
+	}
+	return fmt.Sprintf("ssh -p %d %s@%s", port, i.User, host), nil
+}
+
+// stopInstanceSSHD terminates the SSH server of the instance i, it
+// wouldn't exit with the instance process without a PID namespace.
+func stopInstanceSSHD(i *instance.File) {
+	dir, err := InstanceSSHDir(i.Name, i.User)
+	if err != nil {
+		return
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, sshdPidFile))
+	if err != nil {
+		return
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || pid <= 0 {
+		return
+	}
+
+	// the pid file holds the PID seen in the container, it matches
+	// the host PID only if the process is in the instance mount
+	// namespace
+	mntNs := func(pid int) string {
+		ns, _ := os.Readlink(fmt.Sprintf("/proc/%d/ns/mnt", pid))
+		return ns
+	}
+	if ns := mntNs(pid); ns == "" || ns != mntNs(i.Pid) {
+		return
+	}
+
+	sylog.Debugf("Stopping SSH server of %s instance (PID=%d)", i.Name, pid)
+	syscall.Kill(pid, syscall.SIGTERM)
+}
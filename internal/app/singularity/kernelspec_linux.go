@@ -0,0 +1,174 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/hpcng/singularity/internal/pkg/util/user"
+)
+
+// connectionFile is the placeholder replaced by Jupyter with the
+// path of the kernel connection file.
+const connectionFile = "{connection_file}"
+
+var kernelNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// KernelSpecOptions describes a Jupyter kernel launched in a container.
+type KernelSpecOptions struct {
+	// Name is the kernel name, the name of its directory.
+	Name string
+	// DisplayName is the name shown by notebook servers.
+	DisplayName string
+	// Language is the kernel language.
+	Language string
+	// Image is the container image the kernel runs in.
+	Image string
+	// KernelCmd is the kernel command line in the container, it
+	// must hold the {connection_file} placeholder.
+	KernelCmd []string
+	// Binds are the bind paths of the container.
+	Binds []string
+	// Nvidia and Rocm enable the GPU support of the container.
+	Nvidia bool
+	Rocm   bool
+	// Prefix installs the kernel in <prefix>/share/jupyter/kernels
+	// instead of the user Jupyter data directory.
+	Prefix string
+	// Force overwrites an existing kernel.
+	Force bool
+}
+
+// kernelSpec is the kernel.json file content.
+type kernelSpec struct {
+	Argv        []string               `json:"argv"`
+	DisplayName string                 `json:"display_name"`
+	Language    string                 `json:"language"`
+	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// InstallKernelSpec writes the kernel.json file of a Jupyter kernel
+// launched in a container with singularity exec and returns the kernel
+// directory. The Jupyter runtime directory holding the connection
+// files is bound in the container so kernels can read them when the
+// home directory isn't mounted.
+func InstallKernelSpec(opts KernelSpecOptions) (string, error) {
+	if !kernelNameRegexp.MatchString(opts.Name) {
+		return "", fmt.Errorf("invalid kernel name %q, it must contain only letters, digits, '.', '_' or '-'", opts.Name)
+	}
+	hasConnectionFile := false
+	for _, arg := range opts.KernelCmd {
+		if strings.Contains(arg, connectionFile) {
+			hasConnectionFile = true
+		}
+	}
+	if !hasConnectionFile {
+		return "", fmt.Errorf("kernel command must contain %s", connectionFile)
+	}
+
+	image := opts.Image
+	if !strings.Contains(image, "://") {
+		abs, err := filepath.Abs(image)
+		if err != nil {
+			return "", fmt.Errorf("while determining image absolute path: %s", err)
+		}
+		if _, err := os.Stat(abs); err != nil {
+			return "", fmt.Errorf("while checking image: %s", err)
+		}
+		image = abs
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("while determining current executable path: %s", err)
+	}
+
+	dataDir, err := jupyterDataDir(opts.Prefix)
+	if err != nil {
+		return "", err
+	}
+	// the runtime directory is in the user data directory
+	// even for kernels installed in a prefix
+	runtimeDir := os.Getenv("JUPYTER_RUNTIME_DIR")
+	if runtimeDir == "" {
+		userDir, err := jupyterDataDir("")
+		if err != nil {
+			return "", err
+		}
+		runtimeDir = filepath.Join(userDir, "runtime")
+	}
+
+	argv := []string{self, "exec"}
+	for _, b := range opts.Binds {
+		argv = append(argv, "--bind", b)
+	}
+	argv = append(argv, "--bind", runtimeDir)
+	if opts.Nvidia {
+		argv = append(argv, "--nv")
+	}
+	if opts.Rocm {
+		argv = append(argv, "--rocm")
+	}
+	argv = append(argv, image)
+	argv = append(argv, opts.KernelCmd...)
+
+	spec := kernelSpec{
+		Argv:        argv,
+		DisplayName: opts.DisplayName,
+		Language:    opts.Language,
+		Metadata: map[string]interface{}{
+			"singularity": map[string]string{"image": image},
+		},
+	}
+	if spec.DisplayName == "" {
+		spec.DisplayName = fmt.Sprintf("%s (%s)", opts.Name, filepath.Base(image))
+	}
+
+	dir := filepath.Join(dataDir, "kernels", opts.Name)
+	if _, err := os.Stat(dir); err == nil && !opts.Force {
+		return "", fmt.Errorf("kernel %s already exists in %s, use --force to overwrite it", opts.Name, dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("while creating kernel directory: %s", err)
+	}
+	if err := os.MkdirAll(runtimeDir, 0700); err != nil {
+		return "", fmt.Errorf("while creating Jupyter runtime directory: %s", err)
+	}
+
+	b, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("while encoding kernel specification: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "kernel.json"), append(b, '\n'), 0644); err != nil {
+		return "", fmt.Errorf("while writing kernel specification: %s", err)
+	}
+	return dir, nil
+}
+
+// jupyterDataDir returns the Jupyter data directory of the prefix, or
+// of the current user if prefix is empty.
+func jupyterDataDir(prefix string) (string, error) {
+	if prefix != "" {
+		return filepath.Join(prefix, "share", "jupyter"), nil
+	}
+	if dir := os.Getenv("JUPYTER_DATA_DIR"); dir != "" {
+		return dir, nil
+	}
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "jupyter"), nil
+	}
+	pw, err := user.CurrentOriginal()
+	if err != nil {
+		return "", fmt.Errorf("while getting current user: %s", err)
+	}
+	return filepath.Join(pw.Dir, ".local", "share", "jupyter"), nil
+}
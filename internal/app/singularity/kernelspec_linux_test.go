@@ -0,0 +1,77 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestInstallKernelSpec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kernelspec-")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	runtimeDir := filepath.Join(dir, "runtime")
+	os.Setenv("JUPYTER_RUNTIME_DIR", runtimeDir)
+	defer os.Unsetenv("JUPYTER_RUNTIME_DIR")
+
+	image := filepath.Join(dir, "python.sif")
+	if err := ioutil.WriteFile(image, nil, 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	opts := KernelSpecOptions{
+		Name:      "python-sif",
+		Language:  "python",
+		Image:     image,
+		KernelCmd: []string{"python", "-m", "ipykernel_launcher"},
+		Binds:     []string{"/scratch"},
+		Nvidia:    true,
+		Prefix:    dir,
+	}
+	if _, err := InstallKernelSpec(opts); err == nil {
+		t.Errorf("unexpected success without connection file")
+	}
+	opts.KernelCmd = append(opts.KernelCmd, "-f", "{connection_file}")
+
+	kernelDir, err := InstallKernelSpec(opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected := filepath.Join(dir, "share", "jupyter", "kernels", "python-sif"); kernelDir != expected {
+		t.Errorf("got kernel directory %s instead of %s", kernelDir, expected)
+	}
+	if _, err := InstallKernelSpec(opts); err == nil {
+		t.Errorf("unexpected success with an existing kernel")
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(kernelDir, "kernel.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var spec kernelSpec
+	if err := json.Unmarshal(b, &spec); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	self, _ := os.Executable()
+	argv := []string{
+		self, "exec", "--bind", "/scratch", "--bind", runtimeDir, "--nv", image,
+		"python", "-m", "ipykernel_launcher", "-f", "{connection_file}",
+	}
+	if !reflect.DeepEqual(spec.Argv, argv) {
+		t.Errorf("got argv %v instead of %v", spec.Argv, argv)
+	}
+	if spec.DisplayName != "python-sif (python.sif)" || spec.Language != "python" {
+		t.Errorf("unexpected display name %q or language %q", spec.DisplayName, spec.Language)
+	}
+}
@@ -27,9 +27,27 @@ import (
 	"golang.org/x/crypto/ssh/terminal"
 )
 
+// consoleSize returns the size of the terminal, incremented by one
+// if oversized is true.
+func consoleSize(oversized bool) (*specs.Box, error) {
+	rows, cols, err := pty.Getsize(os.Stdin)
+	if err != nil {
+		return nil, fmt.Errorf("terminal resize error: %s", err)
+	}
+
+	size := &specs.Box{
+		Height: uint(rows),
+		Width:  uint(cols),
+	}
+	if oversized {
+		size.Height++
+		size.Width++
+	}
+	return size, nil
+}
+
 func resize(controlSocket string, oversized bool) {
 	ctrl := &ociruntime.Control{}
-	ctrl.ConsoleSize = &specs.Box{}
 
 	c, err := unix.Dial(controlSocket)
 	if err != nil {
@@ -38,20 +56,12 @@ func resize(controlSocket string, oversized bool) {
 	}
 	defer c.Close()
 
-	rows, cols, err := pty.Getsize(os.Stdin)
+	ctrl.ConsoleSize, err = consoleSize(oversized)
 	if err != nil {
-		sylog.Errorf("terminal resize error: %s", err)
+		sylog.Errorf("%s", err)
 		return
 	}
 
-	ctrl.ConsoleSize.Height = uint(rows)
-	ctrl.ConsoleSize.Width = uint(cols)
-
-	if oversized {
-		ctrl.ConsoleSize.Height++
-		ctrl.ConsoleSize.Width++
-	}
-
 	enc := json.NewEncoder(c)
 	if enc == nil {
 		sylog.Errorf("cannot instantiate JSON encoder")
@@ -64,36 +74,132 @@ func resize(controlSocket string, oversized bool) {
 	}
 }
 
-func attach(engineConfig *oci.EngineConfig, run bool) error {
+// resizeFramed sends the terminal size in a control frame
+// with the framed attach protocol.
+func resizeFramed(framer *ociruntime.Framer, oversized bool) {
+	size, err := consoleSize(oversized)
+	if err != nil {
+		sylog.Errorf("%s", err)
+		return
+	}
+
+	data, err := json.Marshal(&ociruntime.Control{ConsoleSize: size})
+	if err != nil {
+		sylog.Errorf("%s", err)
+		return
+	}
+	if err := framer.WriteFrame(ociruntime.ControlFrame, data); err != nil {
+		sylog.Errorf("%s", err)
+	}
+}
+
+// helloAttach negotiates the framed attach protocol over conn
+// and returns the Framer to use for the connection.
+func helloAttach(conn net.Conn) (*ociruntime.Framer, error) {
+	if _, err := conn.Write([]byte(ociruntime.AttachMagic)); err != nil {
+		return nil, fmt.Errorf("failed to send attach protocol magic: %s", err)
+	}
+
+	framer := ociruntime.NewFramer(conn)
+	if err := framer.WriteFrame(ociruntime.HelloFrame, []byte{ociruntime.AttachProtocolVersion}); err != nil {
+		return nil, fmt.Errorf("failed to send attach protocol hello: %s", err)
+	}
+
+	t, payload, err := ociruntime.ReadFrame(conn)
+	if err != nil {
+		return nil, fmt.Errorf("while reading attach protocol hello: %s", err)
+	} else if t == ociruntime.ErrorFrame {
+		return nil, fmt.Errorf("attach rejected: %s", payload)
+	} else if t != ociruntime.HelloFrame || len(payload) != 1 {
+		return nil, fmt.Errorf("unexpected attach protocol hello")
+	}
+	return framer, nil
+}
+
+// copyFrames copies the container output received in frames over conn
+// to stdout and stderr until the connection is closed or the container
+// process exit status is received, which is returned if any.
+func copyFrames(conn net.Conn, stdout, stderr io.Writer) (*ociruntime.ExitStatus, error) {
+	for {
+		t, payload, err := ociruntime.ReadFrame(conn)
+		if err == io.EOF {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch t {
+		case ociruntime.StdoutFrame:
+			stdout.Write(payload)
+		case ociruntime.StderrFrame:
+			stderr.Write(payload)
+		case ociruntime.ErrorFrame:
+			return nil, fmt.Errorf("%s", payload)
+		case ociruntime.ExitFrame:
+			status := &ociruntime.ExitStatus{}
+			if err := json.Unmarshal(payload, status); err != nil {
+				return nil, fmt.Errorf("while decoding exit status: %s", err)
+			}
+			return status, nil
+		}
+	}
+}
+
+// attach attaches the console to the running container, it returns the
+// exit status of the container process when sent by the runtime.
+func attach(engineConfig *oci.EngineConfig, run bool) (*ociruntime.ExitStatus, error) {
 	var ostate *terminal.State
 	var conn net.Conn
 	var wg sync.WaitGroup
+	var status *ociruntime.ExitStatus
 
 	state := &engineConfig.State
 
 	if state.AttachSocket == "" {
-		return fmt.Errorf("attach socket not available, container state: %s", state.Status)
+		return nil, fmt.Errorf("attach socket not available, container state: %s", state.Status)
 	}
 	if state.ControlSocket == "" {
-		return fmt.Errorf("control socket not available, container state: %s", state.Status)
+		return nil, fmt.Errorf("control socket not available, container state: %s", state.Status)
 	}
 
 	hasTerminal := engineConfig.OciConfig.Process.Terminal
 	if hasTerminal && !terminal.IsTerminal(0) {
-		return fmt.Errorf("attach requires a terminal when terminal config is set to true")
+		return nil, fmt.Errorf("attach requires a terminal when terminal config is set to true")
 	}
 
 	var err error
 	conn, err = unix.Dial(state.AttachSocket)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer conn.Close()
 
+	if token := engineConfig.GetAttachToken(); token != "" {
+		if _, err := conn.Write([]byte(token + "\n")); err != nil {
+			return nil, fmt.Errorf("failed to send attach token: %s", err)
+		}
+	}
+
+	// the framed protocol is used when supported by the runtime
+	var framer *ociruntime.Framer
+	if state.AttachProtocol > 0 {
+		framer, err = helloAttach(conn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	resizeConsole := func(oversized bool) {
+		if framer != nil {
+			resizeFramed(framer, oversized)
+		} else {
+			resize(state.ControlSocket, oversized)
+		}
+	}
+
 	if hasTerminal {
 		ostate, _ = terminal.MakeRaw(0)
-		resize(state.ControlSocket, true)
-		resize(state.ControlSocket, false)
+		resizeConsole(true)
+		resizeConsole(false)
 	}
 
 	wg.Add(1)
@@ -109,7 +215,7 @@ func attach(engineConfig *oci.EngineConfig, run bool) error {
 			switch s {
 			case syscall.SIGWINCH:
 				if hasTerminal {
-					resize(state.ControlSocket, false)
+					resizeConsole(false)
 				}
 			default:
 				syscall.Kill(pid, s.(syscall.Signal))
@@ -120,23 +226,36 @@ func attach(engineConfig *oci.EngineConfig, run bool) error {
 	if hasTerminal || !run {
 		// Pipe session to bash and visa-versa
 		go func() {
-			io.Copy(os.Stdout, conn)
+			if framer != nil {
+				status, err = copyFrames(conn, os.Stdout, os.Stderr)
+			} else {
+				io.Copy(os.Stdout, conn)
+			}
 			wg.Done()
 		}()
 		go func() {
-			io.Copy(conn, os.Stdin)
+			if framer != nil {
+				io.Copy(framer.Writer(ociruntime.StdinFrame), os.Stdin)
+			} else {
+				io.Copy(conn, os.Stdin)
+			}
 		}()
 		wg.Wait()
 
 		if hasTerminal {
 			fmt.Printf("\r")
-			return terminal.Restore(0, ostate)
+			if rerr := terminal.Restore(0, ostate); rerr != nil {
+				return status, rerr
+			}
 		}
-		return nil
+		return status, err
 	}
 
+	if framer != nil {
+		return copyFrames(conn, ioutil.Discard, ioutil.Discard)
+	}
 	io.Copy(ioutil.Discard, conn)
-	return nil
+	return nil, nil
 }
 
 // OciAttach attaches console to a running container
@@ -149,7 +268,11 @@ func OciAttach(ctx context.Context, containerID string) error {
 		return fmt.Errorf("could not attach to %s: not in running state", containerID)
 	}
 
-	defer exitContainer(ctx, containerID, false)
+	var status *ociruntime.ExitStatus
+	defer func() {
+		exitContainer(ctx, containerID, false, status)
+	}()
 
-	return attach(engineConfig, false)
+	status, err = attach(engineConfig, false)
+	return err
 }
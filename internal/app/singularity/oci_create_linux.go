@@ -6,16 +6,21 @@
 package singularity
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 
+	"github.com/hpcng/singularity/internal/pkg/instance"
 	"github.com/hpcng/singularity/internal/pkg/runtime/engine/config/oci/generate"
 	"github.com/hpcng/singularity/internal/pkg/runtime/engine/oci"
 	"github.com/hpcng/singularity/internal/pkg/util/starter"
 	"github.com/hpcng/singularity/pkg/runtime/engine/config"
+	"github.com/hpcng/singularity/pkg/util/rlimit"
 )
 
 // OciCreate creates a container from an OCI bundle
@@ -25,13 +30,32 @@ func OciCreate(containerID string, args *OciArgs) error {
 		return fmt.Errorf("%s already exists", containerID)
 	}
 
+	// the engine must find the instance files set by the user
+	stateDir := os.Getenv(instance.StateDirEnv)
 	os.Clearenv()
+	if stateDir != "" {
+		os.Setenv(instance.StateDirEnv, stateDir)
+	}
 
 	absBundle, err := filepath.Abs(args.BundlePath)
 	if err != nil {
 		return fmt.Errorf("failed to determine bundle absolute path: %s", err)
 	}
 
+	stdinMode := args.StdinMode
+	stdinPath := args.StdinPath
+	if stdinPath != "" {
+		if stdinMode != "" && stdinMode != oci.StdinPipe && stdinMode != oci.StdinFile {
+			return fmt.Errorf("stdin mode %s can't be used with a stdin file", stdinMode)
+		}
+		stdinMode = oci.StdinFile
+		// resolved before entering the bundle directory
+		stdinPath, err = filepath.Abs(stdinPath)
+		if err != nil {
+			return fmt.Errorf("failed to determine stdin file absolute path: %s", err)
+		}
+	}
+
 	if err := os.Chdir(absBundle); err != nil {
 		return fmt.Errorf("failed to change directory to %s: %s", absBundle, err)
 	}
@@ -42,6 +66,25 @@ func OciCreate(containerID string, args *OciArgs) error {
 	engineConfig.SetLogPath(args.LogPath)
 	engineConfig.SetLogFormat(args.LogFormat)
 	engineConfig.SetPidFile(args.PidFile)
+	engineConfig.SetParallelHooks(args.ParallelHooks)
+	engineConfig.SetStdinMode(stdinMode)
+	engineConfig.SetStdinPath(stdinPath)
+	engineConfig.SetConsoleBuffer(int(args.ConsoleBuffer))
+
+	if args.AttachMode != "" {
+		mode, err := strconv.ParseUint(args.AttachMode, 8, 32)
+		if err != nil || mode&^0777 != 0 {
+			return fmt.Errorf("invalid attach socket mode %s", args.AttachMode)
+		}
+		engineConfig.SetAttachSocketMode(os.FileMode(mode))
+	}
+	if args.AttachToken {
+		token := make([]byte, 32)
+		if _, err := rand.Read(token); err != nil {
+			return fmt.Errorf("failed to generate attach token: %s", err)
+		}
+		engineConfig.SetAttachToken(hex.EncodeToString(token))
+	}
 
 	// load config.json from bundle path
 	configJSON := filepath.Join(absBundle, "config.json")
@@ -61,6 +104,17 @@ func OciCreate(containerID string, args *OciArgs) error {
 		return fmt.Errorf("failed to parse OCI specification file %s: %s", configJSON, err)
 	}
 
+	for _, limit := range args.Ulimits {
+		res, cur, max, err := rlimit.Parse(limit)
+		if err != nil {
+			return err
+		}
+		if generator.Config.Process == nil {
+			return fmt.Errorf("--ulimit requires a container process in the OCI specification")
+		}
+		generator.AddProcessRlimits(res, max, cur)
+	}
+
 	engineConfig.EmptyProcess = args.EmptyProcess
 	engineConfig.SyncSocket = args.SyncSocketPath
 
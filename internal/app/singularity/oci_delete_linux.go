@@ -10,7 +10,6 @@ import (
 	"fmt"
 
 	"github.com/hpcng/singularity/internal/pkg/instance"
-	"github.com/hpcng/singularity/internal/pkg/util/exec"
 	"github.com/hpcng/singularity/pkg/ociruntime"
 	"github.com/hpcng/singularity/pkg/sylog"
 )
@@ -37,11 +36,16 @@ func OciDelete(ctx context.Context, containerID string) error {
 	}
 
 	hooks := engineConfig.OciConfig.Hooks
-	if hooks != nil {
-		for _, h := range hooks.Poststop {
-			if err := exec.Hook(ctx, &h, &engineConfig.State.State); err != nil {
-				sylog.Warningf("%s", err)
-			}
+	if hooks != nil && len(hooks.Poststop) > 0 {
+		logger, err := engineConfig.NewLogger(containerID)
+		if err != nil {
+			sylog.Warningf("Could not open container log, poststop hooks output is discarded: %s", err)
+		}
+		for _, err := range engineConfig.RunHooks(ctx, hooks.Poststop, logger, false) {
+			sylog.Warningf("%s", err)
+		}
+		if logger != nil {
+			logger.Close()
 		}
 	}
 
@@ -28,7 +28,15 @@ type OciArgs struct {
 	FromFile       string
 	KillSignal     string
 	KillTimeout    uint32
+	ProbeTimeout   uint32
+	Ulimits        []string
 	EmptyProcess   bool
+	ParallelHooks  bool
+	StdinMode      string
+	StdinPath      string
+	AttachMode     string
+	AttachToken    bool
+	ConsoleBuffer  uint32
 	ForceKill      bool
 }
 
@@ -65,9 +73,13 @@ func getState(containerID string) (*ociruntime.State, error) {
 	return &engineConfig.State, nil
 }
 
-func exitContainer(ctx context.Context, containerID string, delete bool) {
+// exitContainer exits with the exit code of the container process if it
+// stopped, the code of the exit status sent over the attach socket if any,
+// or else the one recorded in the container state. The container is deleted
+// first if delete is true.
+func exitContainer(ctx context.Context, containerID string, delete bool, status *ociruntime.ExitStatus) {
 	state, err := getState(containerID)
-	if err != nil {
+	if err != nil && status == nil {
 		if !delete {
 			sylog.Errorf("%s", err)
 			os.Exit(1)
@@ -75,11 +87,13 @@ func exitContainer(ctx context.Context, containerID string, delete bool) {
 		return
 	}
 
-	if state.ExitCode != nil {
+	if status != nil {
+		defer os.Exit(status.ExitCode)
+	} else if state.ExitCode != nil {
 		defer os.Exit(*state.ExitCode)
 	}
 
-	if delete {
+	if delete && err == nil {
 		if err := OciDelete(ctx, containerID); err != nil {
 			sylog.Errorf("%s", err)
 		}
@@ -0,0 +1,78 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hpcng/singularity/pkg/ociruntime"
+	"github.com/hpcng/singularity/pkg/util/unix"
+)
+
+// probeAttachProtocol is the first attach protocol version handling
+// probe frames.
+const probeAttachProtocol = 3
+
+// OciProbe runs the short command args in a running container through
+// its attach socket, the command is executed as an exec of the container
+// process, and returns the command result. The command is killed after
+// timeout seconds, or after the runtime default timeout if timeout is 0.
+func OciProbe(containerID string, args []string, timeout int) (*ociruntime.ProbeResult, error) {
+	engineConfig, err := getEngineConfig(containerID)
+	if err != nil {
+		return nil, err
+	}
+	state := engineConfig.GetState()
+
+	if state.Status != ociruntime.Running {
+		return nil, fmt.Errorf("cannot probe '%s', container is not running", containerID)
+	}
+	if state.AttachSocket == "" {
+		return nil, fmt.Errorf("can't find attach socket")
+	}
+	if state.AttachProtocol < probeAttachProtocol {
+		return nil, fmt.Errorf("container runtime doesn't support probes")
+	}
+
+	c, err := unix.Dial(state.AttachSocket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to attach socket")
+	}
+	defer c.Close()
+
+	if token := engineConfig.GetAttachToken(); token != "" {
+		if _, err := c.Write([]byte(token + "\n")); err != nil {
+			return nil, fmt.Errorf("failed to send attach token: %s", err)
+		}
+	}
+
+	data, err := json.Marshal(&ociruntime.Probe{Args: args, Timeout: timeout})
+	if err != nil {
+		return nil, fmt.Errorf("while encoding probe: %s", err)
+	}
+	if _, err := c.Write([]byte(ociruntime.AttachMagic)); err != nil {
+		return nil, fmt.Errorf("failed to send attach protocol magic: %s", err)
+	}
+	if err := ociruntime.NewFramer(c).WriteFrame(ociruntime.ProbeFrame, data); err != nil {
+		return nil, fmt.Errorf("while sending probe: %s", err)
+	}
+
+	t, payload, err := ociruntime.ReadFrame(c)
+	if err != nil {
+		return nil, fmt.Errorf("while reading probe result: %s", err)
+	} else if t == ociruntime.ErrorFrame {
+		return nil, fmt.Errorf("probe rejected: %s", payload)
+	} else if t != ociruntime.ProbeFrame {
+		return nil, fmt.Errorf("unexpected attach protocol frame")
+	}
+
+	result := new(ociruntime.ProbeResult)
+	if err := json.Unmarshal(payload, result); err != nil {
+		return nil, fmt.Errorf("while decoding probe result: %s", err)
+	}
+	return result, nil
+}
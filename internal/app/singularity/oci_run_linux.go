@@ -51,7 +51,12 @@ func OciRun(ctx context.Context, containerID string, args *OciArgs) error {
 		return err
 	}
 
-	defer exitContainer(ctx, containerID, true)
+	// the exit status sent over the attach socket doesn't depend
+	// on the state file, it's preferred to the recorded exit code
+	var exitStatus *ociruntime.ExitStatus
+	defer func() {
+		exitContainer(ctx, containerID, true, exitStatus)
+	}()
 	defer os.Remove(args.SyncSocketPath)
 
 	go func() {
@@ -95,13 +100,20 @@ func OciRun(ctx context.Context, containerID string, args *OciArgs) error {
 		return err
 	}
 
-	if err := attach(engineConfig, true); err != nil {
+	exitStatus, err = attach(engineConfig, true)
+	if err != nil {
 		// kill container before deletion
 		sylog.Errorf("%s", err)
 		OciKill(containerID, "SIGKILL", 1)
 		return err
 	}
 
+	// the runtime sends the exit status once the stopped state is
+	// recorded, or if it couldn't be recorded
+	if exitStatus != nil {
+		return nil
+	}
+
 	// wait stopped status
 	s = <-status
 	if s != ociruntime.Stopped {
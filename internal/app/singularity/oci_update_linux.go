@@ -14,10 +14,12 @@ import (
 
 	"github.com/hpcng/singularity/internal/pkg/cgroups"
 	"github.com/hpcng/singularity/pkg/ociruntime"
+	"github.com/hpcng/singularity/pkg/sylog"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 )
 
-// OciUpdate updates container cgroups resources
+// OciUpdate updates container cgroups resources and the resource
+// limits of the container processes
 func OciUpdate(containerID string, args *OciArgs) error {
 	var reader io.Reader
 
@@ -30,8 +32,19 @@ func OciUpdate(containerID string, args *OciArgs) error {
 		return fmt.Errorf("container %s is neither running nor created", containerID)
 	}
 
+	if args.FromFile == "" && len(args.Ulimits) == 0 {
+		return fmt.Errorf("you must specify --from-file or --ulimit")
+	}
+
+	if len(args.Ulimits) > 0 {
+		n, err := UpdateRlimits(state.State.Pid, args.Ulimits)
+		if err != nil {
+			return fmt.Errorf("while updating resource limits: %s", err)
+		}
+		sylog.Verbosef("Updated resource limits of %d processes", n)
+	}
 	if args.FromFile == "" {
-		return fmt.Errorf("you must specify --from-file")
+		return nil
 	}
 
 	resources := &specs.LinuxResources{}
@@ -0,0 +1,303 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/cache"
+	"github.com/hpcng/singularity/internal/pkg/instance"
+	"github.com/hpcng/singularity/internal/pkg/journal"
+	"github.com/hpcng/singularity/internal/pkg/util/fs/tmpdir"
+	"github.com/hpcng/singularity/internal/pkg/util/user"
+	"github.com/hpcng/singularity/pkg/ociruntime"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+// tempDirMinAge is the minimum age of a temporary directory before
+// considering it as leaked, to not remove the temporary directories
+// of starting containers or of builds in progress.
+const tempDirMinAge = time.Hour
+
+// tempDirPrefixes are the name prefixes of the temporary sandboxes,
+// bundles and overlays created by singularity.
+var tempDirPrefixes = []string{
+	"rootfs-",
+	"tmp-rootfs-",
+	"sandbox-bundle-",
+	"bundle-temp-",
+	"build-temp-",
+	"temp-oci-",
+	"overlay-",
+	tmpdir.Prefix,
+}
+
+// PruneOptions holds the prune command options.
+type PruneOptions struct {
+	// DryRun only reports what would be removed.
+	DryRun bool
+	// Days is the number of days after which cache entries are
+	// removed, cache is not pruned if negative.
+	Days int
+	// TmpDir is the directory where temporary sandboxes
+	// and overlays are searched.
+	TmpDir string
+}
+
+type pruner struct {
+	opts   PruneOptions
+	errors int
+}
+
+// remove removes path described by desc or only reports it
+// with the dry run option.
+func (p *pruner) remove(desc, path string) {
+	if p.opts.DryRun {
+		sylog.Infof("Would remove %s: %s", desc, path)
+		return
+	}
+	sylog.Infof("Removing %s: %s", desc, path)
+	if err := os.RemoveAll(path); err != nil {
+		sylog.Errorf("Could not remove %s: %s", path, err)
+		p.errors++
+	}
+}
+
+// removeTempDir removes the temporary directory path or only reports
+// it with the dry run option. Contrary to remove, it doesn't cross
+// filesystem boundaries to not remove the content of bind mounts or
+// overlays left mounted in temporary directories.
+func (p *pruner) removeTempDir(path string) {
+	if p.opts.DryRun {
+		sylog.Infof("Would remove leaked temporary directory: %s", path)
+		return
+	}
+	sylog.Infof("Removing leaked temporary directory: %s", path)
+
+	fi, err := os.Lstat(path)
+	if err == nil {
+		err = removeOneFS(path, fi.Sys().(*syscall.Stat_t).Dev)
+	}
+	if err != nil {
+		sylog.Errorf("Could not remove %s: %s", path, err)
+		p.errors++
+	}
+}
+
+// removeOneFS removes path and its content like os.RemoveAll but
+// returns an error instead of descending into or removing an entry
+// not on the filesystem dev.
+func removeOneFS(path string, dev uint64) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.Sys().(*syscall.Stat_t).Dev != dev {
+		return fmt.Errorf("%s is on another filesystem", path)
+	}
+
+	if fi.IsDir() {
+		d, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		names, err := d.Readdirnames(-1)
+		d.Close()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := removeOneFS(filepath.Join(path, name), dev); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// pruneInstances removes the instance files of exited instances.
+func (p *pruner) pruneInstances(system bool) error {
+	files, err := instance.ListExited(system)
+	if err != nil {
+		return fmt.Errorf("could not retrieve instance list: %v", err)
+	}
+	for _, f := range files {
+		p.remove(fmt.Sprintf("stale instance %s files", f.Name), filepath.Dir(f.Path))
+	}
+	return nil
+}
+
+// ociMasterAlive returns if the master process of the OCI
+// container is still running.
+func ociMasterAlive(f *instance.File) bool {
+	if f.PPid <= 0 || syscall.Kill(f.PPid, 0) == syscall.ESRCH {
+		return false
+	}
+	return strings.HasPrefix(procName(f.PPid), ociProcName)
+}
+
+// pruneOci removes the state of OCI containers whose master process
+// exited without stopping the container and the sockets left by
+// stopped containers.
+func (p *pruner) pruneOci() error {
+	files, err := instance.List("", "*", instance.OciSubDir)
+	if err != nil {
+		return fmt.Errorf("could not retrieve OCI container list: %v", err)
+	}
+	for _, f := range files {
+		if ociMasterAlive(f) {
+			continue
+		}
+
+		state, err := getState(f.Name)
+		if err != nil || state.Status != ociruntime.Stopped {
+			p.remove(fmt.Sprintf("orphaned OCI container %s state", f.Name), filepath.Dir(f.Path))
+			continue
+		}
+
+		// stopped containers are kept until deleted but
+		// their sockets are not served anymore
+		for _, socket := range []string{state.AttachSocket, state.ControlSocket} {
+			if socket == "" {
+				continue
+			}
+			if _, err := os.Lstat(socket); err == nil {
+				p.remove(fmt.Sprintf("dead OCI container %s socket", f.Name), socket)
+			}
+		}
+	}
+	return nil
+}
+
+// pruneResources releases the loop devices, device mapper devices,
+// cgroups, networks and files recorded in the journals of containers
+// whose master process was killed before cleaning up.
+func (p *pruner) pruneResources() error {
+	if p.opts.DryRun {
+		entries, err := journal.Leaked(journal.Dir, -1)
+		if err != nil {
+			return fmt.Errorf("could not retrieve leaked resources: %v", err)
+		}
+		for _, e := range entries {
+			sylog.Infof("Would release leaked %s %s", e.Type, e.Path)
+		}
+		return nil
+	}
+
+	err := journal.Recover(journal.Dir, -1, journal.Release, func(e journal.Entry, err error) {
+		sylog.Errorf("Could not release leaked %s %s: %s", e.Type, e.Path, err)
+		p.errors++
+	})
+	if err != nil {
+		return fmt.Errorf("could not release leaked resources: %v", err)
+	}
+	return nil
+}
+
+// pruneTempDirs removes the temporary directories of the current user
+// left by containers or builds which didn't clean up. Temporary
+// directories are kept while the user has containers running.
+func (p *pruner) pruneTempDirs() error {
+	if p.opts.TmpDir == "" {
+		return nil
+	}
+
+	uid := os.Getuid()
+	username := strconv.Itoa(uid)
+	if u, err := user.GetPwUID(uint32(uid)); err == nil {
+		username = u.Name
+	}
+	containers, err := listContainers("")
+	if err != nil {
+		return err
+	}
+	for _, c := range containers {
+		if c.User == username {
+			sylog.Debugf("Skipping temporary directories: %s has running containers", username)
+			return nil
+		}
+	}
+
+	entries, err := ioutil.ReadDir(p.opts.TmpDir)
+	if err != nil {
+		return fmt.Errorf("while reading %s: %s", p.opts.TmpDir, err)
+	}
+
+	for _, fi := range entries {
+		if !fi.IsDir() || !hasTempDirPrefix(fi.Name()) {
+			continue
+		}
+		if time.Since(fi.ModTime()) < tempDirMinAge {
+			continue
+		}
+		// the temporary directory may be shared between users,
+		// directories of other users are never removed
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok || int(st.Uid) != uid {
+			continue
+		}
+		p.removeTempDir(filepath.Join(p.opts.TmpDir, fi.Name()))
+	}
+	return nil
+}
+
+func hasTempDirPrefix(name string) bool {
+	for _, prefix := range tempDirPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Prune removes the stale instance files, the orphaned OCI container
+// states and sockets, the resources leaked by killed containers, the
+// leaked temporary sandboxes and overlays and the cache entries older
+// than the number of days set in options.
+func Prune(imgCache *cache.Handle, opts PruneOptions) error {
+	p := &pruner{opts: opts}
+
+	if err := p.pruneInstances(false); err != nil {
+		return err
+	}
+	if os.Getuid() == 0 {
+		if err := p.pruneInstances(true); err != nil {
+			return err
+		}
+		if err := p.pruneOci(); err != nil {
+			return err
+		}
+		if err := p.pruneResources(); err != nil {
+			return err
+		}
+	}
+	if err := p.pruneTempDirs(); err != nil {
+		return err
+	}
+	if opts.Days >= 0 && imgCache != nil && !imgCache.IsDisabled() {
+		if err := CleanSingularityCache(imgCache, opts.DryRun, nil, opts.Days); err != nil {
+			return err
+		}
+	}
+
+	if p.errors > 0 {
+		return fmt.Errorf("failed to remove %d entries", p.errors)
+	}
+	return nil
+}
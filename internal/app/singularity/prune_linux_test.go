@@ -0,0 +1,131 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHasTempDirPrefix(t *testing.T) {
+	tests := map[string]bool{
+		"rootfs-123":       true,
+		"build-temp-456":   true,
+		"overlay-789":      true,
+		"my-rootfs-123":    false,
+		"singularity.conf": false,
+	}
+	for name, want := range tests {
+		if got := hasTempDirPrefix(name); got != want {
+			t.Errorf("got %v instead of %v for %q", got, want, name)
+		}
+	}
+}
+
+func TestRemoveOneFS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prune-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rootfs-1")
+	if err := os.MkdirAll(filepath.Join(path, "etc", "empty"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(path, "etc", "hosts"), nil, 0644); err != nil {
+		t.Fatalf("failed to create file: %s", err)
+	}
+	if err := os.Symlink("/etc", filepath.Join(path, "link")); err != nil {
+		t.Fatalf("failed to create symlink: %s", err)
+	}
+
+	fi, err := os.Lstat(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	dev := fi.Sys().(*syscall.Stat_t).Dev
+
+	// entries of another filesystem are neither removed nor traversed
+	if err := removeOneFS(path, dev+1); err == nil {
+		t.Errorf("unexpected success while removing directory of another filesystem")
+	}
+	if _, err := os.Stat(filepath.Join(path, "etc", "hosts")); err != nil {
+		t.Errorf("directory content removed: %s", err)
+	}
+
+	if err := removeOneFS(path, dev); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("directory %s not removed", path)
+	}
+	if _, err := os.Stat("/etc"); err != nil {
+		t.Errorf("symlink target removed: %s", err)
+	}
+}
+
+func TestPruneTempDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prune-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := time.Now().Add(-2 * tempDirMinAge)
+	mkdir := func(name string, mtime time.Time) string {
+		path := filepath.Join(dir, name)
+		if err := os.Mkdir(path, 0700); err != nil {
+			t.Fatalf("failed to create directory: %s", err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatalf("failed to change directory times: %s", err)
+		}
+		return path
+	}
+
+	leaked := mkdir("rootfs-leaked", old)
+	recent := mkdir("rootfs-recent", time.Now())
+	other := mkdir("data", old)
+	kept := map[string]bool{recent: true, other: true}
+
+	// directories of other users are never removed
+	if os.Getuid() == 0 {
+		path := mkdir("overlay-other", old)
+		if err := os.Chown(path, 1, 1); err != nil {
+			t.Fatalf("failed to change directory owner: %s", err)
+		}
+		kept[path] = true
+	}
+
+	p := &pruner{opts: PruneOptions{DryRun: true, TmpDir: dir}}
+	if err := p.pruneTempDirs(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := os.Stat(leaked); err != nil {
+		t.Errorf("directory %s removed with dry run", leaked)
+	}
+
+	p = &pruner{opts: PruneOptions{TmpDir: dir}}
+	if err := p.pruneTempDirs(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if p.errors != 0 {
+		t.Errorf("unexpected errors while pruning")
+	}
+	if _, err := os.Stat(leaked); !os.IsNotExist(err) {
+		t.Errorf("directory %s not removed", leaked)
+	}
+	for path := range kept {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("directory %s removed", path)
+		}
+	}
+}
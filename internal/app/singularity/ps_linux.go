@@ -0,0 +1,298 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/instance"
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	"github.com/hpcng/singularity/internal/pkg/util/user"
+	"github.com/hpcng/singularity/pkg/util/fs/proc"
+)
+
+const (
+	// runtimeProcName is the process name of the master process
+	// of containers not running as instance.
+	runtimeProcName = "Singularity runtime parent"
+	// ociProcName is the process name prefix of the master process
+	// of OCI containers.
+	ociProcName = "Singularity OCI "
+)
+
+// containerInfo describes a running container.
+type containerInfo struct {
+	// Type is either container, instance or oci.
+	Type string `json:"type"`
+	// Name is the instance name or the OCI container ID.
+	Name    string        `json:"name,omitempty"`
+	User    string        `json:"user"`
+	Pid     int           `json:"pid"`
+	Image   string        `json:"image"`
+	Started time.Time     `json:"started"`
+	CPUTime time.Duration `json:"cputime"`
+	// RSS is the total resident set size in bytes of the
+	// container processes.
+	RSS int64 `json:"rss"`
+}
+
+// processTree holds the process information of all the processes
+// visible from /proc indexed by process ID.
+type processTree struct {
+	stats    map[int]*proc.Stat
+	children map[int][]int
+}
+
+func newProcessTree() *processTree {
+	t := &processTree{
+		stats:    make(map[int]*proc.Stat),
+		children: make(map[int][]int),
+	}
+
+	matches, _ := filepath.Glob("/proc/[0-9]*")
+	for _, path := range matches {
+		pid, err := strconv.Atoi(filepath.Base(path))
+		if err != nil {
+			continue
+		}
+		// process may have exited in the meantime
+		st, err := proc.GetStat(pid)
+		if err != nil {
+			continue
+		}
+		t.stats[pid] = st
+		t.children[st.PPid] = append(t.children[st.PPid], pid)
+	}
+	for _, c := range t.children {
+		sort.Ints(c)
+	}
+	return t
+}
+
+// usage returns the CPU time and the resident set size of the
+// process pid and all its descendants.
+func (t *processTree) usage(pid int) (time.Duration, int64) {
+	st, ok := t.stats[pid]
+	if !ok {
+		return 0, 0
+	}
+	cpu, rss := st.CPUTime, st.RSS
+	for _, child := range t.children[pid] {
+		c, r := t.usage(child)
+		cpu += c
+		rss += r
+	}
+	return cpu, rss
+}
+
+// procName returns the name of the process pid, the process name
+// of singularity master processes being set as first argument.
+func procName(pid int) string {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return ""
+	}
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+	return string(data)
+}
+
+// procEnv returns the value of the environment variable key for the
+// process pid, an empty string is returned if the process environment
+// is not readable.
+func procEnv(pid int, key string) string {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/environ", pid))
+	if err != nil {
+		return ""
+	}
+	for _, env := range bytes.Split(data, []byte{0}) {
+		if kv := strings.SplitN(string(env), "=", 2); len(kv) == 2 && kv[0] == key {
+			return kv[1]
+		}
+	}
+	return ""
+}
+
+// findInstance returns the instance file of the instance name started
+// by username, user instances take precedence over system instances.
+func findInstance(username, name, subDir string) *instance.File {
+	if ii, err := instance.List(username, name, subDir); err == nil && len(ii) == 1 {
+		return ii[0]
+	}
+	if subDir == instance.SingSubDir {
+		if ii, err := instance.ListSystem(name, subDir); err == nil && len(ii) == 1 {
+			return ii[0]
+		}
+	}
+	return nil
+}
+
+// listContainers returns the containers running on the host. Unprivileged
+// users get their own containers only, root gets the containers of all
+// users or of the user username if not empty.
+func listContainers(username string) ([]containerInfo, error) {
+	uid := os.Getuid()
+	if username != "" && uid != 0 {
+		return nil, fmt.Errorf("only root user can list containers of other users")
+	}
+
+	return newProcessTree().containers(uid, username), nil
+}
+
+// containers returns the containers of the process tree t started by
+// the user uid, or of all users if uid is 0, optionally filtered by
+// username.
+func (t *processTree) containers(uid int, username string) []containerInfo {
+	containers := make([]containerInfo, 0)
+
+	for pid, st := range t.stats {
+		if uid != 0 && st.UID != uid {
+			continue
+		}
+
+		var c containerInfo
+
+		name := procName(pid)
+		switch {
+		case name == runtimeProcName:
+			c.Type = "container"
+		case strings.HasPrefix(name, instance.ProgPrefix+": "):
+			c.Type = "instance"
+			// instance process name is "<prefix>: <username> [<name>]"
+			fields := strings.Fields(strings.TrimPrefix(name, instance.ProgPrefix+": "))
+			if len(fields) != 2 {
+				continue
+			}
+			c.Name = strings.Trim(fields[1], "[]")
+		case strings.HasPrefix(name, ociProcName):
+			c.Type = "oci"
+			c.Name = strings.TrimPrefix(name, ociProcName)
+		default:
+			continue
+		}
+
+		// the container process is the first child
+		// of the master process
+		children := t.children[pid]
+		if len(children) == 0 {
+			continue
+		}
+		c.Pid = children[0]
+
+		u, err := user.GetPwUID(uint32(st.UID))
+		if err != nil {
+			c.User = strconv.Itoa(st.UID)
+		} else {
+			c.User = u.Name
+		}
+		if username != "" && c.User != username {
+			continue
+		}
+
+		switch c.Type {
+		case "instance":
+			if f := findInstance(c.User, c.Name, instance.SingSubDir); f != nil {
+				c.Image = f.Image
+			}
+		case "oci":
+			if f := findInstance(c.User, c.Name, instance.OciSubDir); f != nil {
+				c.Image = f.Image
+			}
+		}
+		if c.Image == "" {
+			c.Image = procEnv(c.Pid, "SINGULARITY_CONTAINER")
+		}
+
+		c.Started = st.StartTime
+		c.CPUTime, c.RSS = t.usage(c.Pid)
+		containers = append(containers, c)
+	}
+
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].Started.Before(containers[j].Started)
+	})
+
+	return containers
+}
+
+// descendants returns the process pid and all its descendants.
+func (t *processTree) descendants(pid int) []int {
+	pids := []int{pid}
+	for _, child := range t.children[pid] {
+		pids = append(pids, t.descendants(child)...)
+	}
+	return pids
+}
+
+// PrintContainerList prints the running containers, instances and OCI
+// containers of the current user, or of all users for root, in a regular
+// or a JSON format (if formatJSON is true) to the passed writer. The
+// username filter is only allowed for root.
+func PrintContainerList(w io.Writer, username string, formatJSON bool) error {
+	containers, err := listContainers(username)
+	if err != nil {
+		return err
+	}
+
+	if formatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		err = enc.Encode(
+			map[string][]containerInfo{
+				"containers": containers,
+			})
+		if err != nil {
+			return fmt.Errorf("could not encode container list: %v", err)
+		}
+		return nil
+	}
+
+	tabWriter := tabwriter.NewWriter(w, 0, 8, 4, ' ', 0)
+	defer tabWriter.Flush()
+
+	_, err = fmt.Fprintln(tabWriter, "TYPE\tNAME\tUSER\tPID\tUPTIME\tCPU\tMEMORY\tIMAGE")
+	if err != nil {
+		return fmt.Errorf("could not write list header: %v", err)
+	}
+
+	now := time.Now()
+	for _, c := range containers {
+		name := c.Name
+		if name == "" {
+			name = "-"
+		}
+		image := c.Image
+		if image == "" {
+			image = "-"
+		}
+		_, err = fmt.Fprintf(tabWriter, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n",
+			c.Type,
+			name,
+			c.User,
+			c.Pid,
+			now.Sub(c.Started).Truncate(time.Second),
+			c.CPUTime.Truncate(time.Millisecond*10),
+			fs.FindSize(c.RSS),
+			image,
+		)
+		if err != nil {
+			return fmt.Errorf("could not write container info: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// WebService describes a web service of a container published on the
+// host with an access token.
+type WebService struct {
+	// HostPort is the port the service is reachable on from the host.
+	HostPort int
+	// ContainerPort is the port the service listens on in the container.
+	ContainerPort int
+	// Token is the access token of the service.
+	Token string
+}
+
+// PublishWeb allocates a host port and an access token for the web
+// service listening on containerPort. When the container has its own
+// network namespace, netns is true and the host port is any free port
+// forwarded to containerPort. Otherwise the service shares the host
+// network and listens on the host port directly, containerPort being
+// used if it's free.
+func PublishWeb(containerPort int, netns bool) (*WebService, error) {
+	if containerPort <= 0 || containerPort > 65535 {
+		return nil, fmt.Errorf("port must be between 1 and 65535")
+	}
+
+	w := &WebService{ContainerPort: containerPort}
+
+	port := 0
+	if !netns {
+		port = containerPort
+	}
+	hostPort, err := freePort(port)
+	if err != nil && port != 0 {
+		hostPort, err = freePort(0)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("while allocating host port: %s", err)
+	}
+	w.HostPort = hostPort
+	if !netns {
+		w.ContainerPort = hostPort
+	}
+
+	token := make([]byte, 24)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("while generating access token: %s", err)
+	}
+	w.Token = hex.EncodeToString(token)
+
+	return w, nil
+}
+
+// NetworkArg returns the network argument forwarding the host port
+// to the container port.
+func (w *WebService) NetworkArg() string {
+	return fmt.Sprintf("portmap=%d:%d/tcp", w.HostPort, w.ContainerPort)
+}
+
+// Env returns the environment variables telling the service the port
+// to listen on and the access token to require, Jupyter variables
+// are set as well.
+func (w *WebService) Env() map[string]string {
+	port := strconv.Itoa(w.ContainerPort)
+	return map[string]string{
+		"SINGULARITY_WEB_PORT":  port,
+		"SINGULARITY_WEB_TOKEN": w.Token,
+		"JUPYTER_PORT":          port,
+		"JUPYTER_TOKEN":         w.Token,
+	}
+}
+
+// URL returns the URL of the service with its access token, the host
+// is the host name of the machine.
+func (w *WebService) URL() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	return fmt.Sprintf("http://%s/?token=%s", net.JoinHostPort(host, strconv.Itoa(w.HostPort)), w.Token)
+}
+
+// freePort returns port if it's free, or any free port if port is 0.
+// The port is released right away, it may be taken by another process
+// before the service listens on it.
+func freePort(port int) (int, error) {
+	l, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(port)))
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestPublishWeb(t *testing.T) {
+	if _, err := PublishWeb(0, false); err == nil {
+		t.Errorf("unexpected success with port 0")
+	}
+
+	// a busy port is replaced by a free one with the host network
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer l.Close()
+	busy := l.Addr().(*net.TCPAddr).Port
+
+	w, err := PublishWeb(busy, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if w.HostPort == busy || w.ContainerPort != w.HostPort {
+		t.Errorf("got host port %d and container port %d with busy port %d", w.HostPort, w.ContainerPort, busy)
+	}
+	if w.Token == "" || w.Env()["JUPYTER_TOKEN"] != w.Token {
+		t.Errorf("access token not set in environment")
+	}
+	if !strings.HasSuffix(w.URL(), "/?token="+w.Token) {
+		t.Errorf("unexpected URL %s", w.URL())
+	}
+
+	// the container port is kept with a network namespace
+	w, err = PublishWeb(8888, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if w.ContainerPort != 8888 || w.NetworkArg() != fmt.Sprintf("portmap=%d:8888/tcp", w.HostPort) {
+		t.Errorf("unexpected network argument %s", w.NetworkArg())
+	}
+}
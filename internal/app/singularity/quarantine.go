@@ -0,0 +1,381 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the LICENSE.md file
+// distributed with the sources of this project regarding your rights to use or distribute this
+// software.
+
+package singularity
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/buildcfg"
+	"github.com/hpcng/singularity/internal/pkg/quarantine"
+	"github.com/hpcng/singularity/internal/pkg/util/digest"
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	"github.com/hpcng/singularity/pkg/sylog"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+// quarantineIDLen is the length of the random quarantine IDs.
+const quarantineIDLen = 12
+
+// quarantineStore is the root-owned directory recording the digests of
+// the released images, it can be overridden by tests.
+var quarantineStore = buildcfg.QUARANTINEDIR
+
+// QuarantineEntry is an image held in quarantine until released.
+type QuarantineEntry struct {
+	// ID identifies the image in quarantine.
+	ID string `json:"id"`
+	// Source is the URI the image was pulled from.
+	Source string `json:"source"`
+	// Dest is the path the image is moved to once released.
+	Dest string `json:"dest"`
+	// Digest is the digest of the image when quarantined.
+	Digest string `json:"digest"`
+	// Time is the time the image was quarantined.
+	Time time.Time `json:"time"`
+}
+
+// QuarantinePolicy is the policy quarantined images must pass to be released.
+type QuarantinePolicy struct {
+	// Verify requires the image signatures to be verified with VerifyOpts.
+	Verify     bool
+	VerifyOpts []VerifyOpt
+	// ScanCommand is executed with the image path as argument, the image
+	// is only released if it exits successfully.
+	ScanCommand string
+}
+
+// QuarantineDir returns the quarantine directory dir if set, the quarantine directory of the
+// user singularity directory otherwise.
+func QuarantineDir(dir string) string {
+	return quarantine.Dir(dir)
+}
+
+// path returns the path of the image of e in the quarantine directory dir.
+func (e *QuarantineEntry) path(dir string) string {
+	return filepath.Join(dir, e.ID+".sif")
+}
+
+// metaPath returns the path of the metadata of e in the quarantine directory dir.
+func (e *QuarantineEntry) metaPath(dir string) string {
+	return filepath.Join(dir, e.ID+".json")
+}
+
+// QuarantineTempFile returns the path of a new temporary file in the quarantine directory dir
+// where an image can be pulled before being quarantined with QuarantineImage.
+func QuarantineTempFile(dir string) (string, error) {
+	if err := fs.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("while creating quarantine directory: %s", err)
+	}
+	f, err := ioutil.TempFile(dir, ".pull-")
+	if err != nil {
+		return "", fmt.Errorf("while creating quarantine file: %s", err)
+	}
+	f.Close()
+	return f.Name(), nil
+}
+
+// QuarantineImage moves the image at path to the quarantine directory dir, recording the
+// source it was pulled from and its destination once released.
+func QuarantineImage(dir, path, source, dest string) (*QuarantineEntry, error) {
+	if err := fs.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("while creating quarantine directory: %s", err)
+	}
+	d, err := digest.FromFile(digest.Configured(), path)
+	if err != nil {
+		return nil, fmt.Errorf("while computing image digest: %s", err)
+	}
+	abs, err := filepath.Abs(dest)
+	if err != nil {
+		return nil, fmt.Errorf("while resolving %s: %s", dest, err)
+	}
+
+	e := &QuarantineEntry{
+		Source: source,
+		Dest:   abs,
+		Digest: d.String(),
+		Time:   time.Now(),
+	}
+	meta, err := e.create(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer meta.Close()
+
+	b, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		os.Remove(meta.Name())
+		return nil, fmt.Errorf("while encoding quarantine metadata: %s", err)
+	}
+	if err := os.Rename(path, e.path(dir)); err != nil {
+		os.Remove(meta.Name())
+		return nil, fmt.Errorf("while moving image to quarantine: %s", err)
+	}
+	if _, err := meta.Write(b); err != nil {
+		return nil, fmt.Errorf("while writing quarantine metadata: %s", err)
+	}
+	if err := meta.Close(); err != nil {
+		return nil, fmt.Errorf("while writing quarantine metadata: %s", err)
+	}
+	return e, nil
+}
+
+// create sets a random ID to e and creates its metadata file in the quarantine directory dir,
+// the metadata file is created exclusively so IDs never collide.
+func (e *QuarantineEntry) create(dir string) (*os.File, error) {
+	b := make([]byte, quarantineIDLen/2)
+	for i := 0; i < 16; i++ {
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("while generating quarantine ID: %s", err)
+		}
+		e.ID = hex.EncodeToString(b)
+		f, err := os.OpenFile(e.metaPath(dir), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("while creating quarantine metadata: %s", err)
+		}
+		return f, nil
+	}
+	return nil, fmt.Errorf("could not generate a unique quarantine ID")
+}
+
+// QuarantineList returns the images held in the quarantine directory dir, oldest first.
+func QuarantineList(dir string) ([]QuarantineEntry, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]QuarantineEntry, 0, len(files))
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("while reading quarantine metadata: %s", err)
+		}
+		var e QuarantineEntry
+		if err := json.Unmarshal(b, &e); err != nil || e.ID == "" {
+			sylog.Warningf("Ignoring invalid quarantine metadata %s", file)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	return entries, nil
+}
+
+// findQuarantined returns the quarantined image identified by name: an ID prefix, the path
+// of its destination or the file name of its destination.
+func findQuarantined(dir, name string) (*QuarantineEntry, error) {
+	entries, err := QuarantineList(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	abs, _ := filepath.Abs(name)
+	var found []QuarantineEntry
+	for _, e := range entries {
+		if strings.HasPrefix(e.ID, name) || e.Dest == abs || filepath.Base(e.Dest) == name {
+			found = append(found, e)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no quarantined image %s found", name)
+	case 1:
+		return &found[0], nil
+	}
+	return nil, fmt.Errorf("%d quarantined images match %s, use the image ID", len(found), name)
+}
+
+// check checks the image at path against the policy p.
+func (p QuarantinePolicy) check(ctx context.Context, path string) error {
+	if p.Verify {
+		if err := Verify(ctx, path, p.VerifyOpts...); err != nil {
+			return fmt.Errorf("signature verification failed: %s", err)
+		}
+	}
+
+	if p.ScanCommand != "" {
+		cmd := exec.CommandContext(ctx, p.ScanCommand, path)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("scan command %s failed: %s", p.ScanCommand, err)
+		}
+	}
+	return nil
+}
+
+// copyPrivate copies the image file at path to the private directory dir, so
+// it can't be modified while checked. Symlinks are not followed as root may
+// copy images owned by users. It returns the path and the owner of the copy.
+func copyPrivate(path, dir string) (string, *syscall.Stat_t, error) {
+	src, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return "", nil, fmt.Errorf("while opening image: %s", err)
+	}
+	defer src.Close()
+
+	fi, err := src.Stat()
+	if err != nil {
+		return "", nil, fmt.Errorf("while checking image: %s", err)
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !fi.Mode().IsRegular() || !ok {
+		return "", nil, fmt.Errorf("image %s is not a regular file", path)
+	}
+
+	copied := filepath.Join(dir, "image.sif")
+	dst, err := os.OpenFile(copied, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0700)
+	if err != nil {
+		return "", nil, fmt.Errorf("while copying image: %s", err)
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return "", nil, fmt.Errorf("while copying image: %s", err)
+	}
+	return copied, st, dst.Close()
+}
+
+// Approve checks the image file at path against the policy p and records its
+// digest as released, so images with the same content can be released from
+// quarantine and run. Only root can approve images.
+func Approve(ctx context.Context, path string, p QuarantinePolicy) (godigest.Digest, error) {
+	if os.Geteuid() != 0 {
+		return "", fmt.Errorf("only root can approve images")
+	}
+
+	tmpDir, err := ioutil.TempDir("", "quarantine-")
+	if err != nil {
+		return "", fmt.Errorf("while creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	image, _, err := copyPrivate(path, tmpDir)
+	if err != nil {
+		return "", err
+	}
+	if err := p.check(ctx, image); err != nil {
+		return "", fmt.Errorf("image %s not approved: %s", path, err)
+	}
+	d, err := digest.FromFile(digest.Configured(), image)
+	if err != nil {
+		return "", fmt.Errorf("while computing image digest: %s", err)
+	}
+	return d, quarantine.Release(quarantineStore, d)
+}
+
+// Release moves the image identified by name in the quarantine directory dir to its destination,
+// or to dest if set. An existing destination is only overwritten with force. The image must not
+// have been modified in quarantine and its digest must have been approved by root: when run by
+// root, the image is checked against the policy p and approved, images of other users are
+// approved with Approve and released by their owner. The image is kept in quarantine if it can't
+// be released.
+func Release(ctx context.Context, dir, name, dest string, force bool, p QuarantinePolicy) (*QuarantineEntry, error) {
+	e, err := findQuarantined(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	if dest != "" {
+		if e.Dest, err = filepath.Abs(dest); err != nil {
+			return nil, fmt.Errorf("while resolving %s: %s", dest, err)
+		}
+	}
+	if _, err := os.Stat(e.Dest); err == nil && !force {
+		return nil, fmt.Errorf("image file already exists: %q - will not overwrite", e.Dest)
+	}
+	expected, err := godigest.Parse(e.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid quarantine digest: %s", err)
+	}
+
+	tmpDir, err := ioutil.TempDir("", "quarantine-")
+	if err != nil {
+		return nil, fmt.Errorf("while creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := e.path(dir)
+	image, owner, err := copyPrivate(path, tmpDir)
+	if err != nil {
+		return nil, fmt.Errorf("image %s not released: %s", e.ID, err)
+	}
+	// root doesn't write images of other users to the destination they chose
+	if os.Geteuid() == 0 && owner.Uid != 0 {
+		return nil, fmt.Errorf("image %s not released: images owned by other users are approved with 'singularity release --approve %s' and released by their owner", e.ID, path)
+	}
+	if d, err := digest.FromFile(expected.Algorithm(), image); err != nil {
+		return nil, fmt.Errorf("while computing image digest: %s", err)
+	} else if d != expected {
+		return nil, fmt.Errorf("image %s not released: image modified in quarantine: digest %s instead of %s", e.ID, d, expected)
+	}
+	d, err := digest.FromFile(digest.Configured(), image)
+	if err != nil {
+		return nil, fmt.Errorf("while computing image digest: %s", err)
+	}
+
+	released, err := quarantine.Released(quarantineStore, d)
+	if err != nil {
+		return nil, err
+	}
+	if !released {
+		if os.Geteuid() != 0 {
+			return nil, fmt.Errorf("image %s not released: digest %s must be approved by root with 'singularity release --approve %s'", e.ID, d, path)
+		}
+		if err := p.check(ctx, image); err != nil {
+			return nil, fmt.Errorf("image %s not released: %s", e.ID, err)
+		}
+		if err := quarantine.Release(quarantineStore, d); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.Chmod(image, 0755); err != nil {
+		return nil, fmt.Errorf("while setting image permissions: %s", err)
+	}
+	if err := os.Rename(image, e.Dest); err != nil {
+		// the destination may be on another filesystem
+		if err := fs.CopyFileAtomic(image, e.Dest, 0755); err != nil {
+			return nil, fmt.Errorf("while moving image to %s: %s", e.Dest, err)
+		}
+	}
+	if err := os.Remove(path); err != nil {
+		sylog.Warningf("Could not remove quarantined image %s: %s", e.ID, err)
+	}
+	if err := os.Remove(e.metaPath(dir)); err != nil {
+		sylog.Warningf("Could not remove quarantine metadata of %s: %s", e.ID, err)
+	}
+	return e, nil
+}
+
+// Discard removes the image identified by name from the quarantine directory dir.
+func Discard(dir, name string) (*QuarantineEntry, error) {
+	e, err := findQuarantined(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(e.path(dir)); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("while removing quarantined image: %s", err)
+	}
+	if err := os.Remove(e.metaPath(dir)); err != nil {
+		return nil, fmt.Errorf("while removing quarantine metadata: %s", err)
+	}
+	return e, nil
+}
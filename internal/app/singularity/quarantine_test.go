@@ -0,0 +1,164 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the LICENSE.md file
+// distributed with the sources of this project regarding your rights to use or distribute this
+// software.
+
+package singularity
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcng/singularity/internal/pkg/quarantine"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+// quarantineTestImage pulls a fake image with content to the quarantine directory dir.
+func quarantineTestImage(t *testing.T, dir, content, dest string) *QuarantineEntry {
+	tmp, err := QuarantineTempFile(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := ioutil.WriteFile(tmp, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write image: %s", err)
+	}
+	e, err := QuarantineImage(dir, tmp, "https://example.com/"+content, dest)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return e
+}
+
+func TestQuarantine(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "quarantine-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dir := filepath.Join(tmpDir, "quarantine")
+	dest := filepath.Join(tmpDir, "image.sif")
+	store := filepath.Join(tmpDir, "store")
+
+	defer func(s string) { quarantineStore = s }(quarantineStore)
+	quarantineStore = store
+
+	one := quarantineTestImage(t, dir, "one", dest)
+	two := quarantineTestImage(t, dir, "two", filepath.Join(tmpDir, "other.sif"))
+	// the same image pulled twice gets a distinct ID
+	three := quarantineTestImage(t, dir, "two", filepath.Join(tmpDir, "third.sif"))
+
+	entries, err := QuarantineList(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(entries) != 3 || entries[0].ID != one.ID || entries[1].ID != two.ID || entries[2].ID != three.ID {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+	if two.ID == three.ID {
+		t.Fatalf("same quarantine ID %s for two pulls", two.ID)
+	}
+
+	// quarantined images can't be opened by the runtime
+	f, err := os.Open(one.path(dir))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	err = quarantine.Check(f, one.path(dir), dir, store, godigest.SHA256)
+	f.Close()
+	if err == nil {
+		t.Errorf("quarantined image not refused")
+	}
+	if _, err := os.Stat(dest); err == nil {
+		t.Fatalf("image released before release")
+	}
+
+	ctx := context.Background()
+
+	if os.Geteuid() != 0 {
+		// users can't release images not approved by root
+		if _, err := Release(ctx, dir, one.ID, "", false, QuarantinePolicy{}); err == nil {
+			t.Errorf("unexpected success with an image not approved")
+		}
+		if _, err := Approve(ctx, one.path(dir), QuarantinePolicy{}); err == nil {
+			t.Errorf("unexpected success approving an image as user")
+		}
+	} else {
+		testRootRelease(t, dir, dest, one, two)
+	}
+
+	for _, e := range []*QuarantineEntry{two, three} {
+		if _, err := Discard(dir, e.ID); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	entries, err = QuarantineList(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("unexpected entries left: %v", entries)
+	}
+}
+
+// testRootRelease checks the release of images by root.
+func testRootRelease(t *testing.T, dir, dest string, one, two *QuarantineEntry) {
+	ctx := context.Background()
+
+	// the scan command rejects the image
+	if _, err := Release(ctx, dir, one.ID, "", false, QuarantinePolicy{ScanCommand: "/bin/false"}); err == nil {
+		t.Errorf("unexpected success with failing scan command")
+	}
+	if _, err := Release(ctx, dir, "missing.sif", "", false, QuarantinePolicy{}); err == nil {
+		t.Errorf("unexpected success with unknown image")
+	}
+
+	// tampering with the image
+	path := one.path(dir)
+	if err := ioutil.WriteFile(path, []byte("modified"), 0644); err != nil {
+		t.Fatalf("failed to write image: %s", err)
+	}
+	if _, err := Release(ctx, dir, one.ID, "", false, QuarantinePolicy{}); err == nil {
+		t.Errorf("unexpected success with modified image")
+	}
+	if err := ioutil.WriteFile(path, []byte("one"), 0644); err != nil {
+		t.Fatalf("failed to write image: %s", err)
+	}
+
+	// release by destination file name
+	e, err := Release(ctx, dir, "image.sif", "", false, QuarantinePolicy{ScanCommand: "/bin/true"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if b, err := ioutil.ReadFile(dest); err != nil || string(b) != "one" {
+		t.Errorf("unexpected released image %s: %q, %v", e.Dest, b, err)
+	}
+	if f, err := os.Open(dest); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	} else {
+		if err := quarantine.Check(f, dest, dir, quarantineStore, godigest.SHA256); err != nil {
+			t.Errorf("released image refused: %s", err)
+		}
+		f.Close()
+	}
+
+	// existing destination
+	if _, err := Release(ctx, dir, two.ID[:6], dest, false, QuarantinePolicy{}); err == nil {
+		t.Errorf("unexpected success with existing destination")
+	}
+
+	// approved images are released without checking the policy again
+	if _, err := Approve(ctx, two.path(dir), QuarantinePolicy{ScanCommand: "/bin/false"}); err == nil {
+		t.Errorf("unexpected success approving with failing scan command")
+	}
+	d, err := Approve(ctx, two.path(dir), QuarantinePolicy{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if released, err := quarantine.Released(quarantineStore, d); err != nil || !released {
+		t.Errorf("approved image not released: %v", err)
+	}
+}
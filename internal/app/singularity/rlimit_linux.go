@@ -0,0 +1,198 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/hpcng/singularity/internal/pkg/instance"
+	"github.com/hpcng/singularity/internal/pkg/util/user"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/util/fs/proc"
+	"github.com/hpcng/singularity/pkg/util/rlimit"
+)
+
+// containerProcesses returns the processes of the container process pid,
+// all the processes of its PID namespace if the container has its own,
+// including the processes joining it, or the container process and its
+// descendants otherwise.
+func containerProcesses(pid int) ([]int, error) {
+	ns, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/pid", pid))
+	if err != nil {
+		return nil, fmt.Errorf("while reading PID namespace of process %d: %s", pid, err)
+	}
+	self, err := os.Readlink("/proc/self/ns/pid")
+	if err != nil {
+		return nil, fmt.Errorf("while reading PID namespace: %s", err)
+	}
+
+	tree := newProcessTree()
+	if ns == self {
+		return tree.descendants(pid), nil
+	}
+
+	pids := make([]int, 0)
+	for p := range tree.stats {
+		// processes of other users are not readable
+		if n, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/pid", p)); err == nil && n == ns {
+			pids = append(pids, p)
+		}
+	}
+	sort.Ints(pids)
+	return pids, nil
+}
+
+// UpdateRlimits sets the resource limits of the processes of the container
+// process pid, limits are in the form name=soft[:hard] like nofile=4096:8192.
+// It returns the number of processes updated.
+func UpdateRlimits(pid int, limits []string) (int, error) {
+	type limit struct {
+		res      string
+		cur, max uint64
+	}
+	parsed := make([]limit, 0, len(limits))
+	for _, l := range limits {
+		res, cur, max, err := rlimit.Parse(l)
+		if err != nil {
+			return 0, err
+		}
+		parsed = append(parsed, limit{res, cur, max})
+	}
+
+	pids, err := containerProcesses(pid)
+	if err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, p := range pids {
+		exited := false
+		for _, l := range parsed {
+			err := rlimit.SetProcess(p, l.res, l.cur, l.max)
+			if errors.Is(err, syscall.ESRCH) {
+				// process exited in the meantime
+				exited = true
+				break
+			} else if errors.Is(err, syscall.EPERM) {
+				return updated, fmt.Errorf("%s: raising a hard limit or the limits of processes of other users requires root privileges", err)
+			} else if err != nil {
+				return updated, err
+			}
+			sylog.Debugf("Set %s of process %d to %d:%d", l.res, p, l.cur, l.max)
+		}
+		if !exited {
+			updated++
+		}
+	}
+	return updated, nil
+}
+
+// checkInstanceProcess returns an error if the process recorded in the
+// instance file isn't the sinit process of the instance. The instance
+// file is stored in the user home directory and its content can't be
+// trusted, so like when joining an instance the process and its parent
+// must be owned by the instance user, or by root for system instances,
+// and the process must run in a user namespace only if the instance
+// was started with one.
+func checkInstanceProcess(file *instance.File) error {
+	if file.Pid <= 1 || file.PPid <= 1 {
+		return fmt.Errorf("bad instance process ID found")
+	}
+
+	ownerUID, ownerGID := uint32(0), uint32(0)
+	if !file.System {
+		pw, err := user.GetPwNam(file.User)
+		if err != nil {
+			return fmt.Errorf("could not retrieve user %s: %s", file.User, err)
+		}
+		ownerUID, ownerGID = pw.UID, pw.GID
+	}
+
+	owned := func(path string) error {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("error while getting information for %s: %s", path, err)
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		if st.Uid != ownerUID || st.Gid != ownerGID {
+			return fmt.Errorf("instance process owned by %d:%d instead of %d:%d", st.Uid, st.Gid, ownerUID, ownerGID)
+		}
+		return nil
+	}
+
+	path := filepath.Join("/proc", strconv.Itoa(file.Pid))
+	if err := owned(filepath.Join(path, "task")); err != nil {
+		return err
+	}
+
+	_, hid, err := proc.ReadIDMap(filepath.Join(path, "uid_map"))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read user namespace mapping: %s", err)
+	} else if err == nil && hid > 0 && !file.UserNs {
+		return fmt.Errorf("instance process is running in a user namespace not recorded in instance file")
+	}
+
+	f, err := os.Open(filepath.Join(path, "status"))
+	if err != nil {
+		return fmt.Errorf("could not open status: %s", err)
+	}
+	ppid := -1
+	for s := bufio.NewScanner(f); s.Scan(); {
+		if n, _ := fmt.Sscanf(s.Text(), "PPid:\t%d", &ppid); n == 1 {
+			break
+		}
+	}
+	f.Close()
+
+	if ppid != file.PPid {
+		return fmt.Errorf("orphaned (or faked) instance process")
+	}
+	if err := owned(filepath.Join("/proc", strconv.Itoa(file.PPid), "task")); err != nil {
+		return fmt.Errorf("parent %s", err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(path, "comm"))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %s", filepath.Join(path, "comm"), err)
+	}
+	if strings.Trim(string(b), "\n") != "sinit" {
+		return fmt.Errorf("sinit not found in %s, wrong instance process", filepath.Join(path, "comm"))
+	}
+	return nil
+}
+
+// InstanceUpdate sets the resource limits of the processes of the instance
+// name of user, or of the system instance name if system is true.
+func InstanceUpdate(name, user string, system bool, limits []string) error {
+	ii, err := listInstances(user, name, system)
+	if err != nil {
+		return fmt.Errorf("could not retrieve instance list: %v", err)
+	}
+	if len(ii) == 0 {
+		return fmt.Errorf("no instance found")
+	}
+
+	for _, i := range ii {
+		if err := checkInstanceProcess(i); err != nil {
+			return fmt.Errorf("while updating %s instance: %s", i.Name, err)
+		}
+		n, err := UpdateRlimits(i.Pid, limits)
+		if err != nil {
+			return fmt.Errorf("while updating %s instance: %s", i.Name, err)
+		}
+		sylog.Infof("Updated resource limits of %d processes of %s instance", n, i.Name)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/instance"
+	"github.com/hpcng/singularity/internal/pkg/util/user"
+	"github.com/hpcng/singularity/pkg/util/rlimit"
+)
+
+func TestUpdateRlimits(t *testing.T) {
+	cmd := exec.Command("/bin/sh", "-c", "sleep 10 & wait")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start process: %s", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	pid := cmd.Process.Pid
+
+	// wait for the shell to fork its child
+	var pids []int
+	for i := 0; i < 50; i++ {
+		pids = newProcessTree().descendants(pid)
+		if len(pids) == 2 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(pids) != 2 {
+		t.Fatalf("got processes %v instead of the shell and its child", pids)
+	}
+
+	n, err := UpdateRlimits(pid, []string{"nofile=64"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n != 2 {
+		t.Errorf("got %d processes updated instead of 2", n)
+	}
+	for _, p := range pids {
+		if cur, _, err := rlimit.GetProcess(p, "RLIMIT_NOFILE"); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		} else if cur != 64 {
+			t.Errorf("got soft limit %d instead of 64 for process %d", cur, p)
+		}
+	}
+
+	if _, err := UpdateRlimits(pid, []string{"nofile"}); err == nil {
+		t.Errorf("unexpected success with an invalid limit")
+	}
+}
+
+func TestCheckInstanceProcess(t *testing.T) {
+	u, err := user.Current()
+	if err != nil {
+		t.Fatalf("could not retrieve current user: %s", err)
+	}
+
+	tests := []struct {
+		name string
+		file *instance.File
+	}{
+		{
+			name: "init process",
+			file: &instance.File{User: u.Name, Pid: 1, PPid: os.Getpid()},
+		},
+		{
+			name: "bad parent process",
+			file: &instance.File{User: u.Name, Pid: os.Getpid(), PPid: 1},
+		},
+		{
+			name: "not sinit process",
+			file: &instance.File{User: u.Name, Pid: os.Getpid(), PPid: os.Getppid()},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkInstanceProcess(tt.file); err == nil {
+				t.Errorf("unexpected success")
+			}
+		})
+	}
+}
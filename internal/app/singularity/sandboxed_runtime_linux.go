@@ -0,0 +1,205 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/hpcng/singularity/internal/pkg/runtime/engine/config/oci"
+	sifbundle "github.com/hpcng/singularity/pkg/ocibundle/sif"
+	singularityConfig "github.com/hpcng/singularity/pkg/runtime/engine/singularity/config"
+	"github.com/hpcng/singularity/pkg/sylog"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// sandboxedRuntimes maps the sandboxed runtime names accepted by
+// --runtime to their OCI runtime binary.
+var sandboxedRuntimes = map[string]string{
+	"gvisor":       "runsc",
+	"runsc":        "runsc",
+	"kata":         "kata-runtime",
+	"kata-runtime": "kata-runtime",
+}
+
+// SandboxedRunOptions holds the options to run a SIF image with
+// a sandboxed OCI runtime.
+type SandboxedRunOptions struct {
+	// Runtime is either a sandboxed runtime name (runsc, gvisor, kata,
+	// kata-runtime) or the absolute path of an OCI runtime binary.
+	Runtime string
+	// Image is the path of the SIF image.
+	Image string
+	// Args are the process arguments.
+	Args []string
+	// Env contains additional KEY=VALUE environment variables.
+	Env []string
+	// Binds are the host paths bind mounted in the container.
+	Binds []singularityConfig.BindPath
+	// Cwd is the process working directory, defaults to /.
+	Cwd string
+	// Hostname is the container hostname if not empty.
+	Hostname string
+	// Writable makes the container root filesystem writable
+	// with an ephemeral overlay.
+	Writable bool
+}
+
+// SandboxedRuntimePath returns the path of the OCI runtime binary
+// corresponding to the sandboxed runtime name.
+func SandboxedRuntimePath(name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return name, nil
+	}
+	bin, ok := sandboxedRuntimes[name]
+	if !ok {
+		return "", fmt.Errorf("unknown runtime %s, supported runtimes are runsc, gvisor, kata, kata-runtime or an absolute path", name)
+	}
+	path, err := exec.LookPath(bin)
+	if err != nil {
+		return "", fmt.Errorf("runtime %s not found: %s", bin, err)
+	}
+	return path, nil
+}
+
+// SandboxedRun creates a temporary OCI bundle from the SIF image and
+// runs it with a sandboxed OCI runtime like gVisor (runsc) or Kata
+// Containers, it returns the exit code of the container process.
+func SandboxedRun(opts SandboxedRunOptions) (int, error) {
+	if os.Geteuid() != 0 {
+		return -1, fmt.Errorf("runtime %s requires root privileges", opts.Runtime)
+	}
+
+	runtimePath, err := SandboxedRuntimePath(opts.Runtime)
+	if err != nil {
+		return -1, err
+	}
+
+	f, err := os.Open(opts.Image)
+	if err != nil {
+		return -1, fmt.Errorf("while opening image %s: %s", opts.Image, err)
+	}
+	err = checkECL(f)
+	f.Close()
+	if err != nil {
+		return -1, err
+	}
+
+	g, err := oci.DefaultConfig()
+	if err != nil {
+		return -1, fmt.Errorf("failed to generate OCI config: %s", err)
+	}
+	g.SetProcessArgs(opts.Args)
+	g.SetProcessTerminal(terminal.IsTerminal(0) && terminal.IsTerminal(1))
+	if opts.Cwd != "" {
+		g.SetProcessCwd(opts.Cwd)
+	}
+	if opts.Hostname != "" {
+		g.Config.Hostname = opts.Hostname
+	}
+	g.Config.Root.Readonly = !opts.Writable
+	g.AddProcessEnv("SINGULARITY_CONTAINER", opts.Image)
+	g.AddProcessEnv("SINGULARITY_NAME", filepath.Base(opts.Image))
+	if term := os.Getenv("TERM"); term != "" {
+		g.AddProcessEnv("TERM", term)
+	}
+	for _, env := range opts.Env {
+		kv := strings.SplitN(env, "=", 2)
+		if len(kv) != 2 {
+			return -1, fmt.Errorf("bad environment variable %q, must be KEY=VALUE", env)
+		}
+		g.AddProcessEnv(kv[0], kv[1])
+	}
+
+	for _, b := range opts.Binds {
+		if b.ImageSrc() != "" || b.ID() != "" {
+			return -1, fmt.Errorf("image bind %s is not supported with runtime %s", b.Source, opts.Runtime)
+		}
+		mode := "rw"
+		if b.Readonly() {
+			mode = "ro"
+		}
+		g.AddMount(specs.Mount{
+			Source:      b.Source,
+			Destination: b.Destination,
+			Type:        "none",
+			Options:     []string{"rbind", mode},
+		})
+	}
+
+	bundlePath, err := ioutil.TempDir("", "sandbox-bundle-")
+	if err != nil {
+		return -1, fmt.Errorf("while creating bundle directory: %s", err)
+	}
+
+	bundle, err := sifbundle.FromSif(opts.Image, bundlePath, opts.Writable)
+	if err != nil {
+		os.Remove(bundlePath)
+		return -1, err
+	}
+	if err := bundle.Create(g.Config); err != nil {
+		os.Remove(bundlePath)
+		return -1, fmt.Errorf("while creating OCI bundle: %s", err)
+	}
+	defer func() {
+		if err := bundle.Delete(); err != nil {
+			sylog.Warningf("While deleting OCI bundle %s: %s", bundlePath, err)
+		}
+	}()
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return -1, fmt.Errorf("while generating container ID: %s", err)
+	}
+	containerID := "singularity-" + id.String()
+
+	sylog.Debugf("Running %s with %s in bundle %s", containerID, runtimePath, bundlePath)
+
+	cmd := exec.Command(runtimePath, "run", "--bundle", bundlePath, containerID)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// terminal signals are also received by the runtime which forwards
+	// them to the container process, catch them here to not exit before
+	// the runtime and leave the bundle mounted
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM)
+	defer func() {
+		signal.Stop(sigs)
+		close(sigs)
+	}()
+
+	if err := cmd.Start(); err != nil {
+		return -1, fmt.Errorf("while running %s: %s", runtimePath, err)
+	}
+	go func() {
+		for sig := range sigs {
+			if sig == syscall.SIGTERM {
+				cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	if err := cmd.Wait(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				return 128 + int(status.Signal()), nil
+			}
+			return exitErr.ExitCode(), nil
+		}
+		return -1, fmt.Errorf("while running %s: %s", runtimePath, err)
+	}
+	return 0, nil
+}
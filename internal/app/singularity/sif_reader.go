@@ -0,0 +1,239 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the LICENSE.md file
+// distributed with the sources of this project regarding your rights to use or distribute this
+// software.
+
+package singularity
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/hpcng/sif/pkg/sif"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/vbauerster/mpb/v6"
+	"github.com/vbauerster/mpb/v6/decor"
+)
+
+const (
+	// DefaultHashWorkers is the default number of concurrent reads used
+	// when hashing the data objects of a SIF image.
+	DefaultHashWorkers = 4
+
+	// hashChunkSize is the size of the chunks read ahead of the hashing.
+	hashChunkSize = 8 << 20
+
+	// progressMinSize is the minimum size of the data objects of an image
+	// before a progress bar is displayed.
+	progressMinSize = 256 << 20
+)
+
+// hashConfig configures how the data objects of a SIF image are read while
+// computing their digests, zero workers means DefaultHashWorkers.
+type hashConfig struct {
+	workers  int
+	progress bool
+}
+
+// chunk is a chunk of a data object read ahead of the hashing.
+type chunk struct {
+	off  int64
+	size int64
+	buf  []byte
+	n    int
+	err  error
+	done chan struct{}
+}
+
+// prefetchFile wraps the file of a SIF image to read the data objects with
+// concurrent chunked reads ahead of the sequential hashing done by the SIF
+// integrity package. The digests are unchanged, only the reads are spread
+// over workers, which on parallel filesystems cuts the time spent waiting
+// for data. At most workers chunks are held in memory.
+type prefetchFile struct {
+	*os.File
+	workers int
+	// objects are the ranges of the data objects large enough to be read
+	// ahead.
+	objects [][2]int64
+	bar     *mpb.Bar
+	p       *mpb.Progress
+
+	mu     sync.Mutex
+	window []*chunk
+}
+
+// openSIF opens the SIF image at path and loads it with the data objects
+// read according to cfg.
+func openSIF(path string, rdonly bool, cfg hashConfig) (sif.FileImage, error) {
+	mode := os.O_RDWR
+	if rdonly {
+		mode = os.O_RDONLY
+	}
+	f, err := os.OpenFile(path, mode, 0)
+	if err != nil {
+		return sif.FileImage{}, err
+	}
+
+	if cfg.workers == 0 {
+		cfg.workers = DefaultHashWorkers
+	}
+	pf := &prefetchFile{File: f, workers: cfg.workers}
+	fimg, err := sif.LoadContainerFp(pf, rdonly)
+	if err != nil {
+		f.Close()
+		return sif.FileImage{}, err
+	}
+
+	var total int64
+	for _, d := range fimg.DescrArr {
+		if !d.Used || d.Datatype == sif.DataSignature || d.Filelen <= hashChunkSize {
+			continue
+		}
+		total += d.Filelen
+		pf.objects = append(pf.objects, [2]int64{d.Fileoff, d.Fileoff + d.Filelen})
+	}
+	if cfg.progress && total >= progressMinSize && sylog.GetLevel() > -1 {
+		pf.p = mpb.New(mpb.WithOutput(os.Stderr))
+		pf.bar = pf.p.AddBar(total,
+			mpb.PrependDecorators(
+				decor.Name("Hashing "),
+				decor.Counters(decor.UnitKiB, "%.1f / %.1f"),
+			),
+			mpb.AppendDecorators(
+				decor.AverageSpeed(decor.UnitKiB, " % .1f "),
+				decor.AverageETA(decor.ET_STYLE_GO),
+			),
+		)
+	}
+	return fimg, nil
+}
+
+// object returns the end of the data object range containing off, or -1
+// if off isn't part of a data object read ahead.
+func (f *prefetchFile) object(off int64) int64 {
+	for _, o := range f.objects {
+		if off >= o[0] && off < o[1] {
+			return o[1]
+		}
+	}
+	return -1
+}
+
+// load reads the chunk at off, up to end, in the background.
+func (f *prefetchFile) load(off, end int64) *chunk {
+	size := end - off
+	if size > hashChunkSize {
+		size = hashChunkSize
+	}
+	c := &chunk{off: off, size: size, buf: make([]byte, size), done: make(chan struct{})}
+	go func() {
+		c.n, c.err = f.File.ReadAt(c.buf, c.off)
+		if c.err == io.EOF && int64(c.n) == size {
+			c.err = nil
+		}
+		close(c.done)
+	}()
+	return c
+}
+
+// chunk returns the chunk containing off of the data object ending at end
+// and schedules the reads of the chunks following it.
+func (f *prefetchFile) chunk(off, end int64) *chunk {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// drop the chunks already hashed, the window is reset on a
+	// non-sequential read
+	for len(f.window) > 0 {
+		c := f.window[0]
+		if off >= c.off && off < c.off+c.size {
+			break
+		}
+		f.window = f.window[1:]
+	}
+
+	next := off
+	if len(f.window) > 0 {
+		last := f.window[len(f.window)-1]
+		next = last.off + last.size
+	}
+	for len(f.window) < f.workers && next < end {
+		c := f.load(next, end)
+		f.window = append(f.window, c)
+		next += c.size
+	}
+	return f.window[0]
+}
+
+// ReadAt reads the data objects through the read ahead window, other data
+// is read directly.
+func (f *prefetchFile) ReadAt(p []byte, off int64) (int, error) {
+	end := f.object(off)
+	if end < 0 {
+		return f.File.ReadAt(p, off)
+	} else if f.workers <= 1 {
+		n, err := f.File.ReadAt(p, off)
+		f.incr(n)
+		return n, err
+	}
+
+	n := 0
+	for n < len(p) && off < end {
+		c := f.chunk(off, end)
+		<-c.done
+
+		i := int(off - c.off)
+		if i < c.n {
+			m := copy(p[n:], c.buf[i:c.n])
+			n += m
+			off += int64(m)
+		}
+		if c.err != nil && off >= c.off+int64(c.n) {
+			f.incr(n)
+			return n, c.err
+		}
+	}
+
+	f.incr(n)
+	if n < len(p) {
+		m, err := f.ReadAt(p[n:], off)
+		return n + m, err
+	}
+	return n, nil
+}
+
+// incr advances the progress bar by n bytes.
+func (f *prefetchFile) incr(n int) {
+	if f.bar != nil {
+		f.bar.IncrBy(n)
+	}
+}
+
+// reset drops the read ahead window, the data being modified.
+func (f *prefetchFile) reset() {
+	f.mu.Lock()
+	f.window = nil
+	f.mu.Unlock()
+}
+
+func (f *prefetchFile) Write(p []byte) (int, error) {
+	f.reset()
+	return f.File.Write(p)
+}
+
+func (f *prefetchFile) Truncate(size int64) error {
+	f.reset()
+	return f.File.Truncate(size)
+}
+
+// Close completes the progress bar and closes the file.
+func (f *prefetchFile) Close() error {
+	if f.bar != nil {
+		f.bar.SetTotal(0, true)
+		f.p.Wait()
+	}
+	f.reset()
+	return f.File.Close()
+}
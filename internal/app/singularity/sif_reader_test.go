@@ -0,0 +1,66 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the LICENSE.md file
+// distributed with the sources of this project regarding your rights to use or distribute this
+// software.
+
+package singularity
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestPrefetchFile(t *testing.T) {
+	data := make([]byte, 3*hashChunkSize+12345)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	f, err := ioutil.TempFile("", "prefetch-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// the object doesn't cover the first and last bytes of the file
+	start, end := int64(100), int64(len(data)-100)
+	want := sha256.Sum256(data[start:end])
+
+	for _, workers := range []int{1, 2, 4} {
+		pf := &prefetchFile{File: f, workers: workers, objects: [][2]int64{{start, end}}}
+
+		h := sha256.New()
+		if _, err := io.Copy(h, io.NewSectionReader(pf, start, end-start)); err != nil {
+			t.Fatalf("unexpected error with %d workers: %s", workers, err)
+		}
+		if got := h.Sum(nil); !bytes.Equal(got, want[:]) {
+			t.Errorf("got digest %x with %d workers, want %x", got, workers, want)
+		}
+
+		// reads overlapping the object boundaries and going backward
+		for _, off := range []int64{0, end - 10, hashChunkSize - 5, 42} {
+			b := make([]byte, 64)
+			n, err := pf.ReadAt(b, off)
+			if err != nil {
+				t.Fatalf("unexpected error reading at %d with %d workers: %s", off, workers, err)
+			}
+			if !bytes.Equal(b[:n], data[off:off+64]) {
+				t.Errorf("unexpected data read at %d with %d workers", off, workers)
+			}
+		}
+
+		// reads past the end of the file
+		b := make([]byte, 256)
+		if n, err := pf.ReadAt(b, end-50); err != io.EOF || n != 150 {
+			t.Errorf("got %d bytes and error %v with %d workers, want 150 bytes and EOF", n, err, workers)
+		}
+	}
+}
@@ -6,13 +6,16 @@
 package singularity
 
 import (
+	"fmt"
+
 	"github.com/hpcng/sif/pkg/integrity"
-	"github.com/hpcng/sif/pkg/sif"
 	"github.com/hpcng/singularity/pkg/sypgp"
+	"golang.org/x/crypto/openpgp"
 )
 
 type signer struct {
 	opts []integrity.SignerOpt
+	hash hashConfig
 }
 
 // SignOpt are used to configure s.
@@ -33,6 +36,15 @@ func OptSignEntitySelector(f sypgp.EntitySelector) SignOpt {
 	}
 }
 
+// OptSignEntity specifies e be used to generate signature(s), its private key may sign with a
+// remote signing service. This allows the entity to be selected once to sign several images.
+func OptSignEntity(e *openpgp.Entity) SignOpt {
+	return func(s *signer) error {
+		s.opts = append(s.opts, integrity.OptSignWithEntity(e))
+		return nil
+	}
+}
+
 // OptSignGroup specifies that a signature be applied to cover all objects in the group with the
 // specified groupID. This may be called multiple times to add multiple group signatures.
 func OptSignGroup(groupID uint32) SignOpt {
@@ -52,8 +64,28 @@ func OptSignObjects(ids ...uint32) SignOpt {
 	}
 }
 
+// OptSignHashWorkers specifies the number of concurrent reads used to hash the data objects, 1
+// disables reading ahead of the hashing.
+func OptSignHashWorkers(n int) SignOpt {
+	return func(s *signer) error {
+		if n < 1 {
+			return fmt.Errorf("invalid number of hash workers %d", n)
+		}
+		s.hash.workers = n
+		return nil
+	}
+}
+
+// OptSignProgress specifies that a progress bar be displayed while hashing large images.
+func OptSignProgress() SignOpt {
+	return func(s *signer) error {
+		s.hash.progress = true
+		return nil
+	}
+}
+
 // Sign adds one or more digital signatures to the SIF image found at path, according to opts. Key
-// material must be provided via OptSignEntitySelector.
+// material must be provided via OptSignEntitySelector or OptSignEntity.
 //
 // By default, one digital signature is added per object group in f. To override this behavior,
 // consider using OptSignGroup and/or OptSignObject.
@@ -67,7 +99,7 @@ func Sign(path string, opts ...SignOpt) error {
 	}
 
 	// Load container.
-	f, err := sif.LoadContainer(path, false)
+	f, err := openSIF(path, false, s.hash)
 	if err != nil {
 		return err
 	}
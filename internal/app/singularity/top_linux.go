@@ -0,0 +1,431 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	"github.com/hpcng/singularity/pkg/ociruntime"
+	"github.com/hpcng/singularity/pkg/util/fs/proc"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// TopOptions configures the live view of the running containers.
+type TopOptions struct {
+	// Username restricts the view to the containers of this user,
+	// root only.
+	Username string
+	// Interval is the refresh interval of the view.
+	Interval time.Duration
+	// Batch prints the view Iterations times, or until interrupted if
+	// Iterations is 0, without interaction.
+	Batch      bool
+	Iterations int
+}
+
+// topProcess describes a process of a container.
+type topProcess struct {
+	Pid     int
+	State   string
+	CPUTime time.Duration
+	RSS     int64
+	Command string
+}
+
+// containerUsage describes a running container and its resource usage
+// over the last refresh interval.
+type containerUsage struct {
+	containerInfo
+	// CPU is the CPU usage as a percentage of one CPU.
+	CPU float64
+	// ReadRate and WriteRate are the storage I/O rates in bytes
+	// per second.
+	ReadRate  float64
+	WriteRate float64
+	Paused    bool
+	Processes []topProcess
+
+	io proc.IO
+}
+
+// topSampler samples the resource usage of the running containers,
+// the usage rates are computed from the previous sample.
+type topSampler struct {
+	uid      int
+	username string
+	last     map[int]*containerUsage
+	lastTime time.Time
+}
+
+// sample returns the running containers and their resource usage
+// since the previous sample.
+func (s *topSampler) sample() []*containerUsage {
+	now := time.Now()
+	tree := newProcessTree()
+	elapsed := now.Sub(s.lastTime).Seconds()
+
+	current := make(map[int]*containerUsage)
+	usages := make([]*containerUsage, 0)
+	for _, c := range tree.containers(s.uid, s.username) {
+		u := &containerUsage{containerInfo: c, Paused: true}
+		for _, pid := range tree.descendants(c.Pid) {
+			st := tree.stats[pid]
+			if st.State != "T" && st.State != "t" {
+				u.Paused = false
+			}
+			if pio, err := proc.GetIO(pid); err == nil {
+				u.io.ReadBytes += pio.ReadBytes
+				u.io.WriteBytes += pio.WriteBytes
+			}
+			u.Processes = append(u.Processes, topProcess{
+				Pid:     pid,
+				State:   st.State,
+				CPUTime: st.CPUTime,
+				RSS:     st.RSS,
+				Command: procCmdline(pid),
+			})
+		}
+		if c.Type == "oci" {
+			if state, err := getState(c.Name); err == nil {
+				u.Paused = state.Status == ociruntime.Paused
+			}
+		}
+
+		// rates can't be negative when processes exit
+		if last, ok := s.last[c.Pid]; ok && elapsed > 0 {
+			u.CPU = positive(c.CPUTime-last.CPUTime) / elapsed * 100
+			u.ReadRate = float64(positiveInt(u.io.ReadBytes-last.io.ReadBytes)) / elapsed
+			u.WriteRate = float64(positiveInt(u.io.WriteBytes-last.io.WriteBytes)) / elapsed
+		}
+		current[c.Pid] = u
+		usages = append(usages, u)
+	}
+
+	s.last = current
+	s.lastTime = now
+	return usages
+}
+
+func positive(d time.Duration) float64 {
+	if d < 0 {
+		return 0
+	}
+	return d.Seconds()
+}
+
+func positiveInt(n int64) int64 {
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// procCmdline returns the command line of the process pid.
+func procCmdline(pid int) string {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(bytes.ReplaceAll(data, []byte{0}, []byte{' '})))
+}
+
+// attachHint returns how to start a process in the container u.
+func (u *containerUsage) attachHint() string {
+	switch u.Type {
+	case "instance":
+		return fmt.Sprintf("singularity shell instance://%s", u.Name)
+	case "oci":
+		return fmt.Sprintf("singularity oci exec %s <command>", u.Name)
+	}
+	return fmt.Sprintf("nsenter --target %d --all", u.Pid)
+}
+
+// label returns a short description of the container u.
+func (u *containerUsage) label() string {
+	if u.Name != "" {
+		return fmt.Sprintf("%s %s", u.Type, u.Name)
+	}
+	return fmt.Sprintf("%s %d", u.Type, u.Pid)
+}
+
+// signal sends the signal sig to the container process of u.
+func (u *containerUsage) signal(sig syscall.Signal) error {
+	if err := syscall.Kill(u.Pid, sig); err != nil {
+		return fmt.Errorf("could not send %s to %s: %s", sig, u.label(), err)
+	}
+	return nil
+}
+
+// togglePause pauses the processes of the container u or resumes them
+// if they are paused. OCI containers are frozen by their runtime, other
+// containers processes are stopped with SIGSTOP.
+func (u *containerUsage) togglePause() error {
+	if u.Type == "oci" {
+		return OciPauseResume(u.Name, !u.Paused)
+	}
+	sig := syscall.SIGSTOP
+	if u.Paused {
+		sig = syscall.SIGCONT
+	}
+	for _, p := range u.Processes {
+		if err := syscall.Kill(p.Pid, sig); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("could not send %s to process %d: %s", sig, p.Pid, err)
+		}
+	}
+	return nil
+}
+
+// topLines returns the lines of the view of the containers usages, the
+// processes of the container selected (if not negative) are listed after
+// the containers. The index of the line of the selected container is
+// returned along with the lines.
+func topLines(usages []*containerUsage, selected int, interval time.Duration) ([]string, int) {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "singularity top - %s, %d containers, refreshed every %s\n\n",
+		time.Now().Format("15:04:05"), len(usages), interval)
+
+	tw := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tNAME\tUSER\tPID\tCPU%\tMEMORY\tREAD/s\tWRITE/s\tSTATE\tIMAGE")
+	for _, u := range usages {
+		name := u.Name
+		if name == "" {
+			name = "-"
+		}
+		image := u.Image
+		if image == "" {
+			image = "-"
+		}
+		state := "running"
+		if u.Paused {
+			state = "paused"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%.1f\t%s\t%s\t%s\t%s\t%s\n",
+			u.Type,
+			name,
+			u.User,
+			u.Pid,
+			u.CPU,
+			fs.FindSize(u.RSS),
+			fs.FindSize(int64(u.ReadRate)),
+			fs.FindSize(int64(u.WriteRate)),
+			state,
+			image,
+		)
+	}
+	tw.Flush()
+
+	if selected >= 0 && selected < len(usages) {
+		u := usages[selected]
+		fmt.Fprintf(&buf, "\nProcesses of %s, attach with: %s\n", u.label(), u.attachHint())
+		tw := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "  PID\tSTATE\tCPU\tMEMORY\tCOMMAND")
+		for _, p := range u.Processes {
+			fmt.Fprintf(tw, "  %d\t%s\t%s\t%s\t%s\n", p.Pid, p.State, p.CPUTime.Truncate(time.Millisecond*10), fs.FindSize(p.RSS), p.Command)
+		}
+		tw.Flush()
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	// the container lines follow the title, a blank line and the header
+	return lines, selected + 3
+}
+
+// Top shows a live view of the running containers of the current user,
+// or of all users for root, with their CPU, memory and storage I/O usage.
+// The view is interactive if the standard input is a terminal and batch
+// mode is not requested.
+func Top(ctx context.Context, opts TopOptions) error {
+	uid := os.Getuid()
+	if opts.Username != "" && uid != 0 {
+		return fmt.Errorf("only root user can list containers of other users")
+	}
+	if opts.Interval <= 0 {
+		return fmt.Errorf("invalid refresh interval %s", opts.Interval)
+	}
+
+	s := &topSampler{uid: uid, username: opts.Username}
+	s.sample()
+
+	if opts.Batch || !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return topBatch(ctx, os.Stdout, s, opts)
+	}
+	return topInteractive(ctx, s, opts)
+}
+
+// topBatch prints the view to w at each refresh interval.
+func topBatch(ctx context.Context, w io.Writer, s *topSampler, opts TopOptions) error {
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	for i := 0; opts.Iterations == 0 || i < opts.Iterations; i++ {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		lines, _ := topLines(s.sample(), -1, opts.Interval)
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(lines, "\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const topHelp = "Up/Down: select  k: terminate  K: kill  p: pause/resume  q: quit"
+
+// topInteractive shows the view in the terminal until the user quits.
+func topInteractive(ctx context.Context, s *topSampler, opts TopOptions) error {
+	fd := int(os.Stdin.Fd())
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("while setting terminal in raw mode: %s", err)
+	}
+	// use the alternate screen and hide the cursor
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Print("\x1b[?25h\x1b[?1049l")
+		terminal.Restore(fd, state)
+	}()
+
+	keys := make(chan []byte)
+	go func() {
+		for {
+			b := make([]byte, 8)
+			n, err := os.Stdin.Read(b)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- b[:n]
+		}
+	}()
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	usages := s.sample()
+	selected := 0
+	message := ""
+
+	for {
+		if selected >= len(usages) {
+			selected = len(usages) - 1
+		}
+		if selected < 0 && len(usages) > 0 {
+			selected = 0
+		}
+		drawTop(usages, selected, opts.Interval, message)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-winch:
+			continue
+		case <-ticker.C:
+			usages = s.sample()
+			continue
+		case key, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			message = ""
+
+			var err error
+			switch string(key) {
+			case "q", "\x03":
+				return nil
+			case "\x1b[A":
+				if selected > 0 {
+					selected--
+				}
+			case "\x1b[B":
+				selected++
+			case "k", "K", "p":
+				if selected < 0 {
+					break
+				}
+				u := usages[selected]
+				switch string(key) {
+				case "k":
+					err = u.signal(syscall.SIGTERM)
+					message = fmt.Sprintf("Sent SIGTERM to %s", u.label())
+				case "K":
+					err = u.signal(syscall.SIGKILL)
+					message = fmt.Sprintf("Sent SIGKILL to %s", u.label())
+				case "p":
+					err = u.togglePause()
+					message = fmt.Sprintf("Paused %s", u.label())
+					if u.Paused {
+						message = fmt.Sprintf("Resumed %s", u.label())
+					}
+				}
+				usages = s.sample()
+			}
+			if err != nil {
+				message = err.Error()
+			}
+		}
+	}
+}
+
+// drawTop draws the view in the terminal, the selected container line
+// is highlighted and lines are truncated to fit the terminal.
+func drawTop(usages []*containerUsage, selected int, interval time.Duration, message string) {
+	width, height, err := terminal.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		width, height = 80, 24
+	}
+
+	lines, selectedLine := topLines(usages, selected, interval)
+	if selected < 0 {
+		selectedLine = -1
+	}
+	// keep room for the help line
+	if len(lines) > height-2 && height > 2 {
+		lines = lines[:height-2]
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("\x1b[H")
+	for i, line := range lines {
+		if r := []rune(line); len(r) > width {
+			line = string(r[:width])
+		}
+		if i == selectedLine {
+			line = "\x1b[7m" + line + "\x1b[0m"
+		}
+		buf.WriteString(line + "\x1b[K\r\n")
+	}
+	buf.WriteString("\x1b[J\r\n")
+	help := topHelp
+	if message != "" {
+		help += "  | " + message
+	}
+	if r := []rune(help); len(r) > width {
+		help = string(r[:width])
+	}
+	buf.WriteString(help)
+	os.Stdout.Write(buf.Bytes())
+}
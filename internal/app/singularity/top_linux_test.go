@@ -0,0 +1,49 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTopLines(t *testing.T) {
+	usages := []*containerUsage{
+		{
+			containerInfo: containerInfo{Type: "container", User: "user", Pid: 100, Image: "/a.sif"},
+			CPU:           12.5,
+		},
+		{
+			containerInfo: containerInfo{Type: "instance", Name: "db", User: "user", Pid: 200, Image: "/db.sif"},
+			Paused:        true,
+			Processes: []topProcess{
+				{Pid: 200, State: "T", Command: "appinit"},
+				{Pid: 201, State: "T", Command: "postgres -D /data"},
+			},
+		},
+	}
+
+	lines, selected := topLines(usages, 1, time.Second)
+	if !strings.HasPrefix(lines[selected-1], "container ") || !strings.Contains(lines[selected-1], "12.5") {
+		t.Errorf("unexpected container line %q", lines[selected-1])
+	}
+	if l := lines[selected]; !strings.HasPrefix(l, "instance ") || !strings.Contains(l, "paused") {
+		t.Errorf("unexpected selected line %q", l)
+	}
+	view := strings.Join(lines, "\n")
+	if !strings.Contains(view, "attach with: singularity shell instance://db") {
+		t.Errorf("no attach hint for the selected instance in:\n%s", view)
+	}
+	if !strings.Contains(view, "postgres -D /data") {
+		t.Errorf("no processes of the selected instance in:\n%s", view)
+	}
+
+	lines, _ = topLines(usages, -1, time.Second)
+	if len(lines) != 5 {
+		t.Errorf("unexpected view without selection:\n%s", strings.Join(lines, "\n"))
+	}
+}
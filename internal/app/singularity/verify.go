@@ -10,6 +10,7 @@ import (
 	"context"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/hpcng/sif/pkg/integrity"
@@ -27,11 +28,16 @@ type VerifyCallback func(*sif.FileImage, integrity.VerifyResult) bool
 
 type verifier struct {
 	opts      []client.Option
+	keys      openpgp.EntityList
 	groupIDs  []uint32
 	objectIDs []uint32
 	all       bool
 	legacy    bool
 	cb        VerifyCallback
+	hash      hashConfig
+	// manifestSigners are the fingerprints of the entities trusted
+	// to sign manifests.
+	manifestSigners []string
 }
 
 // VerifyOpt are used to configure v.
@@ -46,6 +52,15 @@ func OptVerifyUseKeyServer(opts ...client.Option) VerifyOpt {
 	}
 }
 
+// OptVerifyWithKeys specifies that the keys el be used as a source of key material, in addition
+// to the keyrings, like the public key of a signing service.
+func OptVerifyWithKeys(el openpgp.EntityList) VerifyOpt {
+	return func(v *verifier) error {
+		v.keys = append(v.keys, el...)
+		return nil
+	}
+}
+
 // OptVerifyGroup adds a verification task for the group with the specified groupID. This may be
 // called multliple times to request verification of more than one group.
 func OptVerifyGroup(groupID uint32) VerifyOpt {
@@ -82,6 +97,22 @@ func OptVerifyLegacy() VerifyOpt {
 	}
 }
 
+// OptVerifyManifestSigners specifies the fingerprints of the entities trusted to sign the
+// manifests verified with VerifyManifest. This may be called multiple times to trust more than
+// one entity.
+func OptVerifyManifestSigners(fingerprints ...string) VerifyOpt {
+	return func(v *verifier) error {
+		for _, fp := range fingerprints {
+			fp = strings.ToUpper(strings.ReplaceAll(fp, " ", ""))
+			if _, err := hex.DecodeString(fp); err != nil || fp == "" {
+				return fmt.Errorf("invalid manifest signer fingerprint %q", fp)
+			}
+			v.manifestSigners = append(v.manifestSigners, fp)
+		}
+		return nil
+	}
+}
+
 // OptVerifyCallback registers f as the verification callback.
 func OptVerifyCallback(cb VerifyCallback) VerifyOpt {
 	return func(v *verifier) error {
@@ -90,6 +121,26 @@ func OptVerifyCallback(cb VerifyCallback) VerifyOpt {
 	}
 }
 
+// OptVerifyHashWorkers specifies the number of concurrent reads used to hash the data objects, 1
+// disables reading ahead of the hashing.
+func OptVerifyHashWorkers(n int) VerifyOpt {
+	return func(v *verifier) error {
+		if n < 1 {
+			return fmt.Errorf("invalid number of hash workers %d", n)
+		}
+		v.hash.workers = n
+		return nil
+	}
+}
+
+// OptVerifyProgress specifies that a progress bar be displayed while hashing large images.
+func OptVerifyProgress() VerifyOpt {
+	return func(v *verifier) error {
+		v.hash.progress = true
+		return nil
+	}
+}
+
 // newVerifier constructs a new verifier based on opts.
 func newVerifier(opts []VerifyOpt) (verifier, error) {
 	v := verifier{}
@@ -101,13 +152,11 @@ func newVerifier(opts []VerifyOpt) (verifier, error) {
 	return v, nil
 }
 
-// getOpts returns integrity.VerifierOpt necessary to validate f.
-func (v verifier) getOpts(ctx context.Context, f *sif.FileImage) ([]integrity.VerifierOpt, error) {
-	var iopts []integrity.VerifierOpt
-
-	// Add keyring.
+// keyRing returns the keyring providing key material, the keyserver is
+// only used if useKeyServer is set.
+func (v verifier) keyRing(ctx context.Context, useKeyServer bool) (openpgp.KeyRing, error) {
 	var kr openpgp.KeyRing
-	if v.opts != nil {
+	if v.opts != nil && useKeyServer {
 		hkr, err := sypgp.NewHybridKeyRing(ctx, v.opts...)
 		if err != nil {
 			return nil, err
@@ -127,8 +176,21 @@ func (v verifier) getOpts(ctx context.Context, f *sif.FileImage) ([]integrity.Ve
 	if err != nil {
 		return nil, err
 	}
-	kr = sypgp.NewMultiKeyRing(gkr, kr)
+	if len(v.keys) > 0 {
+		return sypgp.NewMultiKeyRing(gkr, kr, v.keys), nil
+	}
+	return sypgp.NewMultiKeyRing(gkr, kr), nil
+}
+
+// getOpts returns integrity.VerifierOpt necessary to validate f.
+func (v verifier) getOpts(ctx context.Context, f *sif.FileImage) ([]integrity.VerifierOpt, error) {
+	var iopts []integrity.VerifierOpt
 
+	// Add keyring.
+	kr, err := v.keyRing(ctx, true)
+	if err != nil {
+		return nil, err
+	}
 	iopts = append(iopts, integrity.OptVerifyWithKeyRing(kr))
 
 	// Add group IDs, if applicable.
@@ -184,7 +246,7 @@ func Verify(ctx context.Context, path string, opts ...VerifyOpt) error {
 	}
 
 	// Load container.
-	f, err := sif.LoadContainer(path, true)
+	f, err := openSIF(path, true, v.hash)
 	if err != nil {
 		return err
 	}
@@ -218,7 +280,7 @@ func VerifyFingerprints(ctx context.Context, path string, fingerprints []string,
 	}
 
 	// Load container.
-	f, err := sif.LoadContainer(path, true)
+	f, err := openSIF(path, true, v.hash)
 	if err != nil {
 		return err
 	}
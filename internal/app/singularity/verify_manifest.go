@@ -0,0 +1,303 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the LICENSE.md file
+// distributed with the sources of this project regarding your rights to use or distribute this
+// software.
+
+package singularity
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hpcng/singularity/internal/pkg/util/digest"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+// Status of the images of a manifest report.
+const (
+	// ManifestVerified is the status of the images matching their manifest entry.
+	ManifestVerified = "verified"
+	// ManifestFailed is the status of the images not matching their manifest entry.
+	ManifestFailed = "failed"
+	// ManifestUnlisted is the status of the images not listed in the manifest.
+	ManifestUnlisted = "unlisted"
+	// ManifestMissing is the status of the manifest images not found.
+	ManifestMissing = "missing"
+)
+
+// Manifest lists the images of a site repository with their digest and the
+// fingerprints of the entities required to sign them. Manifests are JSON
+// documents clearsigned with OpenPGP, like with 'gpg --clearsign'.
+type Manifest struct {
+	// Signers are the fingerprints of the entities required to sign
+	// the images without signers.
+	Signers []string `json:"signers,omitempty"`
+	// Images are the images of the manifest.
+	Images []ManifestImage `json:"images"`
+}
+
+// ManifestImage is an image of a manifest.
+type ManifestImage struct {
+	// Path is the path of the image relative to the verified directory,
+	// or the image file name for images verified individually.
+	Path string `json:"path"`
+	// Digest is the <algorithm>:<hex> digest of the image file, the
+	// algorithm being sha256, sha384 or sha512.
+	Digest string `json:"digest"`
+	// Signers are the fingerprints of the entities required to sign
+	// the image.
+	Signers []string `json:"signers,omitempty"`
+}
+
+// ManifestReport is the result of the verification of images against
+// a manifest.
+type ManifestReport struct {
+	// Manifest is the path of the manifest.
+	Manifest string `json:"manifest"`
+	// Signer is the fingerprint of the entity which signed the manifest.
+	Signer string `json:"signer"`
+	// Images are the images verified and the manifest images missing.
+	Images []ManifestImageReport `json:"images"`
+	// Verified is the number of images verified.
+	Verified int `json:"verified"`
+	// Failed is the number of images failing verification, unlisted
+	// or missing.
+	Failed int `json:"failed"`
+}
+
+// ManifestImageReport is the result of the verification of an image.
+type ManifestImageReport struct {
+	// Path is the path of the image.
+	Path string `json:"path"`
+	// Entry is the path of the manifest entry of the image.
+	Entry string `json:"entry,omitempty"`
+	// Status is one of ManifestVerified, ManifestFailed, ManifestUnlisted
+	// or ManifestMissing.
+	Status string `json:"status"`
+	// Error describes why the verification failed.
+	Error string `json:"error,omitempty"`
+}
+
+// readManifest reads the clearsigned manifest at path and verifies its
+// signature with the local and global public keyrings, the keyserver isn't
+// used as anybody can publish keys there. The manifest must be signed by
+// one of the trusted manifest signers, any key of the keyrings could sign
+// a manifest listing its own images otherwise.
+func (v verifier) readManifest(ctx context.Context, path string) (*Manifest, string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("while reading manifest: %s", err)
+	}
+	b, _ := clearsign.Decode(data)
+	if b == nil {
+		return nil, "", fmt.Errorf("manifest %s is not a clearsigned document", path)
+	}
+
+	kr, err := v.keyRing(ctx, false)
+	if err != nil {
+		return nil, "", fmt.Errorf("while loading keyring: %s", err)
+	}
+	e, err := openpgp.CheckDetachedSignature(kr, bytes.NewReader(b.Bytes), b.ArmoredSignature.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("while verifying manifest signature: %s", err)
+	}
+	signer := fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+	if !v.isManifestSigner(signer) {
+		return nil, "", fmt.Errorf("manifest signed by %s which is not a trusted manifest signer", signer)
+	}
+
+	m := new(Manifest)
+	if err := json.Unmarshal(b.Plaintext, m); err != nil {
+		return nil, "", fmt.Errorf("while parsing manifest: %s", err)
+	}
+	for _, img := range m.Images {
+		if img.Path == "" || img.Digest == "" {
+			return nil, "", fmt.Errorf("manifest image %q requires a path and a digest", img.Path)
+		}
+		if _, err := digest.Parse(img.Digest); err != nil {
+			return nil, "", fmt.Errorf("manifest image %q: %s", img.Path, err)
+		}
+	}
+	return m, signer, nil
+}
+
+// isManifestSigner returns true if fingerprint is the fingerprint
+// of a trusted manifest signer.
+func (v verifier) isManifestSigner(fingerprint string) bool {
+	for _, fp := range v.manifestSigners {
+		if fp == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// manifestTarget is an image to verify with the path of its manifest entry.
+type manifestTarget struct {
+	path  string
+	entry string
+}
+
+// manifestTargets returns the images found at paths, directories are walked
+// for .sif files.
+func manifestTargets(paths []string) (targets []manifestTarget, walked bool, err error) {
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, false, err
+		}
+		if !fi.IsDir() {
+			targets = append(targets, manifestTarget{path: p, entry: filepath.Base(p)})
+			continue
+		}
+
+		walked = true
+		err = filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() || filepath.Ext(path) != ".sif" {
+				return nil
+			}
+			rel, err := filepath.Rel(p, path)
+			if err != nil {
+				return err
+			}
+			targets = append(targets, manifestTarget{path: path, entry: filepath.ToSlash(rel)})
+			return nil
+		})
+		if err != nil {
+			return nil, false, fmt.Errorf("while walking %s: %s", p, err)
+		}
+	}
+	return targets, walked, nil
+}
+
+// verifyManifestImage verifies the digest and signatures of the image at
+// path against its manifest entry.
+func verifyManifestImage(ctx context.Context, path string, img ManifestImage, signers []string, opts []VerifyOpt) error {
+	expected, err := digest.Parse(img.Digest)
+	if err != nil {
+		return err
+	}
+	d, err := digest.FromFile(expected.Algorithm(), path)
+	if err != nil {
+		return fmt.Errorf("while computing digest: %s", err)
+	}
+	if d != expected {
+		return fmt.Errorf("digest %s doesn't match manifest digest %s", d, img.Digest)
+	}
+
+	if len(img.Signers) > 0 {
+		signers = img.Signers
+	}
+	fingerprints := make([]string, len(signers))
+	for i, s := range signers {
+		fingerprints[i] = strings.ReplaceAll(s, " ", "")
+	}
+
+	if len(fingerprints) == 0 {
+		err = Verify(ctx, path, opts...)
+	} else {
+		err = VerifyFingerprints(ctx, path, fingerprints, opts...)
+	}
+	if err != nil {
+		return fmt.Errorf("while verifying signatures: %s", err)
+	}
+	return nil
+}
+
+// VerifyManifest verifies the images found at paths against the signed manifest at manifestPath,
+// according to opts. Directories are walked for .sif files matched by their path relative to the
+// directory, images passed individually are matched by file name. When directories are verified,
+// the manifest images not found are reported as missing.
+//
+// The manifest must be signed by an entity of the local or global public keyring, trusted with
+// OptVerifyManifestSigners. The images must match the digest of their manifest entry and be
+// signed by all the required entities.
+//
+// An error is returned if the manifest can't be verified, failures of the images are part of the
+// report.
+func VerifyManifest(ctx context.Context, manifestPath string, paths []string, opts ...VerifyOpt) (*ManifestReport, error) {
+	v, err := newVerifier(opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(v.manifestSigners) == 0 {
+		return nil, fmt.Errorf("no trusted manifest signer")
+	}
+
+	m, signer, err := v.readManifest(ctx, manifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	targets, walked, err := manifestTargets(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]ManifestImage)
+	for _, img := range m.Images {
+		entries[img.Path] = img
+	}
+
+	r := &ManifestReport{
+		Manifest: manifestPath,
+		Signer:   signer,
+	}
+	found := make(map[string]bool)
+
+	for _, t := range targets {
+		ir := ManifestImageReport{Path: t.path}
+
+		img, ok := entries[t.entry]
+		if !ok {
+			ir.Status = ManifestUnlisted
+			ir.Error = "image not listed in manifest"
+			r.Failed++
+			r.Images = append(r.Images, ir)
+			continue
+		}
+		found[t.entry] = true
+		ir.Entry = t.entry
+
+		if err := verifyManifestImage(ctx, t.path, img, m.Signers, opts); err != nil {
+			ir.Status = ManifestFailed
+			ir.Error = err.Error()
+			r.Failed++
+		} else {
+			ir.Status = ManifestVerified
+			r.Verified++
+		}
+		r.Images = append(r.Images, ir)
+	}
+
+	if walked {
+		var missing []string
+		for entry := range entries {
+			if !found[entry] {
+				missing = append(missing, entry)
+			}
+		}
+		sort.Strings(missing)
+		for _, entry := range missing {
+			r.Images = append(r.Images, ManifestImageReport{
+				Entry:  entry,
+				Status: ManifestMissing,
+				Error:  "manifest image not found",
+			})
+			r.Failed++
+		}
+	}
+
+	return r, nil
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the LICENSE.md file
+// distributed with the sources of this project regarding your rights to use or distribute this
+// software.
+
+package singularity
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hpcng/singularity/internal/pkg/util/digest"
+	"github.com/sylabs/scs-key-client/client"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+// writeManifest writes m clearsigned with the test entity at path.
+func writeManifest(t *testing.T, path string, m Manifest) {
+	t.Helper()
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w, err := clearsign.Encode(&buf, getTestEntity(t).PrivateKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// copyImage copies the test image name to dir/dest.
+func copyImage(t *testing.T, name, dir, dest string) {
+	t.Helper()
+
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "images", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, dest), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyManifest(t *testing.T) {
+	e := getTestEntity(t)
+	s := httptest.NewServer(mockHKP{e: e})
+	defer s.Close()
+
+	keyServerOpt := OptVerifyUseKeyServer(client.OptBaseURL(s.URL))
+	signerOpt := OptVerifyManifestSigners(testFingerPrint)
+
+	dir, err := ioutil.TempDir("", "verify-manifest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the manifest is verified with the local keyring only
+	keysDir := filepath.Join(dir, "keys")
+	if err := os.Mkdir(keysDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("SINGULARITY_SYPGPDIR", os.Getenv("SINGULARITY_SYPGPDIR"))
+	os.Setenv("SINGULARITY_SYPGPDIR", keysDir)
+
+	var pub bytes.Buffer
+	if err := e.Serialize(&pub); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(keysDir, "pgp-public"), pub.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := filepath.Join(dir, "repo")
+	if err := os.MkdirAll(filepath.Join(repo, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	copyImage(t, "one-group-signed.sif", repo, "signed.sif")
+	copyImage(t, "one-group.sif", repo, "sub/unsigned.sif")
+	copyImage(t, "one-group.sif", repo, "unlisted.sif")
+
+	// digests of any supported algorithm are verified
+	signedDigest, err := digest.FromFile(digest.SHA512, filepath.Join(repo, "signed.sif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsignedDigest, err := digest.FromFile(digest.SHA256, filepath.Join(repo, "sub", "unsigned.sif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := filepath.Join(dir, "manifest.asc")
+	writeManifest(t, manifest, Manifest{
+		Signers: []string{testFingerPrint},
+		Images: []ManifestImage{
+			{Path: "signed.sif", Digest: signedDigest.String()},
+			{Path: "sub/unsigned.sif", Digest: unsignedDigest.String()},
+			{Path: "missing.sif", Digest: signedDigest.String()},
+		},
+	})
+
+	r, err := VerifyManifest(context.Background(), manifest, []string{repo}, keyServerOpt, signerOpt)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if r.Signer != testFingerPrint {
+		t.Errorf("got manifest signer %s, want %s", r.Signer, testFingerPrint)
+	}
+	if r.Verified != 1 || r.Failed != 3 {
+		t.Errorf("got %d verified and %d failed, want 1 and 3", r.Verified, r.Failed)
+	}
+	status := make(map[string]string)
+	for _, ir := range r.Images {
+		if ir.Entry != "" {
+			status[ir.Entry] = ir.Status
+		} else {
+			status[filepath.Base(ir.Path)] = ir.Status
+		}
+	}
+	want := map[string]string{
+		"signed.sif":       ManifestVerified,
+		"sub/unsigned.sif": ManifestFailed,
+		"unlisted.sif":     ManifestUnlisted,
+		"missing.sif":      ManifestMissing,
+	}
+	if !reflect.DeepEqual(status, want) {
+		t.Errorf("got status %v, want %v", status, want)
+	}
+
+	// images passed individually are matched by name, missing images
+	// aren't reported
+	r, err = VerifyManifest(context.Background(), manifest, []string{filepath.Join(repo, "signed.sif")}, keyServerOpt, signerOpt)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if r.Verified != 1 || r.Failed != 0 {
+		t.Errorf("got %d verified and %d failed, want 1 and 0", r.Verified, r.Failed)
+	}
+
+	// a wrong required signer fails
+	writeManifest(t, manifest, Manifest{
+		Images: []ManifestImage{
+			{Path: "signed.sif", Digest: signedDigest.String(), Signers: []string{invalidFingerPrint}},
+		},
+	})
+	r, err = VerifyManifest(context.Background(), manifest, []string{filepath.Join(repo, "signed.sif")}, keyServerOpt, signerOpt)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if r.Failed != 1 {
+		t.Errorf("got %d failed, want 1", r.Failed)
+	}
+
+	// manifests not signed by a trusted signer are rejected
+	paths := []string{filepath.Join(repo, "signed.sif")}
+	if _, err := VerifyManifest(context.Background(), manifest, paths, keyServerOpt); err == nil {
+		t.Errorf("unexpected success without trusted manifest signer")
+	}
+	untrustedOpt := OptVerifyManifestSigners(invalidFingerPrint)
+	if _, err := VerifyManifest(context.Background(), manifest, paths, keyServerOpt, untrustedOpt); err == nil {
+		t.Errorf("unexpected success with an untrusted manifest signer")
+	}
+
+	// a tampered manifest is rejected
+	b, err := ioutil.ReadFile(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = bytes.Replace(b, []byte("signed.sif"), []byte("signed.img"), 1)
+	if err := ioutil.WriteFile(manifest, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyManifest(context.Background(), manifest, []string{repo}, keyServerOpt, signerOpt); err == nil {
+		t.Errorf("unexpected success with a tampered manifest")
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hpcng/singularity/internal/pkg/runtime/wasm"
+	"github.com/hpcng/singularity/pkg/image"
+	singularityConfig "github.com/hpcng/singularity/pkg/runtime/engine/singularity/config"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+// WasmRuntime is the runtime name selecting the embedded WASI runtime.
+const WasmRuntime = "wasm"
+
+// WasmRunOptions holds the options to run a WebAssembly module
+// packaged in a SIF image.
+type WasmRunOptions struct {
+	// Image is the path of the SIF image.
+	Image string
+	// Action is the action command (exec, run, shell or test).
+	Action string
+	// Args are the action arguments, for exec the first argument
+	// is the program name passed to the module.
+	Args []string
+	// Env contains additional KEY=VALUE environment variables.
+	Env []string
+	// Binds are the host directories accessible by the module.
+	Binds []singularityConfig.BindPath
+	// CleanEnv doesn't pass the host environment to the module.
+	CleanEnv bool
+	// Contain doesn't give access to the current working directory.
+	Contain bool
+}
+
+// HasWasmModule returns if the image is a SIF image without root
+// filesystem containing a WebAssembly module.
+func HasWasmModule(path string) bool {
+	img, err := image.Init(path, false)
+	if err != nil {
+		return false
+	}
+	defer img.File.Close()
+
+	if _, err := img.GetRootFsPartition(); err == nil {
+		return false
+	}
+	_, err = wasm.FindModule(img)
+	return err == nil
+}
+
+// WasmRun runs the WebAssembly module packaged in the SIF image with
+// the embedded WASI runtime, it returns the module exit code.
+func WasmRun(ctx context.Context, opts WasmRunOptions) (int, error) {
+	img, err := image.Init(opts.Image, false)
+	if err != nil {
+		return -1, fmt.Errorf("while opening image %s: %s", opts.Image, err)
+	}
+	defer img.File.Close()
+
+	if err := checkECL(img.File); err != nil {
+		return -1, err
+	}
+
+	section, err := wasm.FindModule(img)
+	if err != nil {
+		return -1, fmt.Errorf("while searching module in %s: %s", opts.Image, err)
+	}
+	module, err := wasm.ReadModule(img, section)
+	if err != nil {
+		return -1, err
+	}
+
+	var args []string
+
+	switch opts.Action {
+	case "run":
+		args = append([]string{strings.TrimSuffix(section.Name, wasm.ModuleSuffix)}, opts.Args...)
+	case "exec":
+		args = opts.Args
+	default:
+		return -1, fmt.Errorf("%s command is not supported for WebAssembly modules", opts.Action)
+	}
+
+	cfg := wasm.Config{
+		Args:   args,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+	if !opts.CleanEnv {
+		cfg.Env = os.Environ()
+	}
+	cfg.Env = append(cfg.Env,
+		"SINGULARITY_CONTAINER="+opts.Image,
+		"SINGULARITY_NAME="+filepath.Base(opts.Image),
+	)
+	cfg.Env = append(cfg.Env, opts.Env...)
+
+	if !opts.Contain {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return -1, fmt.Errorf("while getting current working directory: %s", err)
+		}
+		cfg.Binds = append(cfg.Binds, wasm.Bind{Source: cwd, Destination: cwd})
+	}
+	for _, b := range opts.Binds {
+		if b.ImageSrc() != "" || b.ID() != "" {
+			return -1, fmt.Errorf("image bind %s is not supported for WebAssembly modules", b.Source)
+		}
+		cfg.Binds = append(cfg.Binds, wasm.Bind{
+			Source:      b.Source,
+			Destination: b.Destination,
+			Readonly:    b.Readonly(),
+		})
+	}
+
+	sylog.Debugf("Running WebAssembly module %s from %s", section.Name, opts.Image)
+
+	return wasm.Run(ctx, module, cfg)
+}
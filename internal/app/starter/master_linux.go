@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -14,7 +14,6 @@ import (
 	"os"
 	"os/signal"
 	"strings"
-	"syscall"
 
 	"github.com/hpcng/singularity/internal/pkg/runtime/engine"
 	"github.com/hpcng/singularity/internal/pkg/util/mainthread"
@@ -97,8 +96,12 @@ func startContainer(ctx context.Context, masterSocket int, containerPid int, e *
 	// wait container process execution, EOF means container process
 	// was executed and master socket was closed by stage 2. If data
 	// byte sent is equal to 'f', it means an error occurred in
-	// StartProcess, just return by waiting error and process status
+	// StartProcess, just return by waiting error and process status.
+	// Heartbeats sent by the engine before the start are skipped
 	_, err = conn.Read(data)
+	for err == nil && data[0] == 'h' {
+		_, err = conn.Read(data)
+	}
 	if (err != nil && err != io.EOF) || data[0] == 'f' {
 		sylog.Debugf("stage 2 process reported an error, waiting status")
 		return
@@ -117,12 +120,12 @@ func startContainer(ctx context.Context, masterSocket int, containerPid int, e *
 // the master is capable to escalate its privileges to setup
 // container environment properly.
 func Master(rpcSocket, masterSocket int, containerPid int, e *engine.Engine) {
-	var status syscall.WaitStatus
+	var info *engine.ExitInfo
 	fatalChan := make(chan error, 1)
 
 	// we could receive signal from child with CreateContainer call so we
-	// set the signal handler earlier to queue signals until MonitorContainer
-	// is called to handle them
+	// set the signal handler earlier to queue signals until the container
+	// process is waited to handle them
 	// Use a channel size of two here, since we may receive SIGURG, which is
 	// used for non-cooperative goroutine preemption starting with Go 1.14.
 	signals := make(chan os.Signal, 2)
@@ -134,15 +137,25 @@ func Master(rpcSocket, masterSocket int, containerPid int, e *engine.Engine) {
 
 	go startContainer(ctx, masterSocket, containerPid, e, fatalChan)
 
+	infoChan := make(chan *engine.ExitInfo, 1)
+
 	go func() {
-		var err error
-		status, err = e.MonitorContainer(containerPid, signals)
+		info, err := e.Wait(containerPid, signals)
+		infoChan <- info
 		fatalChan <- err
 	}()
 
 	fatal := <-fatalChan
 
-	if err := e.CleanupContainer(ctx, fatal, status); err != nil {
+	// a fatal error may be reported before the container
+	// process exits, the status is then unknown
+	select {
+	case info = <-infoChan:
+	default:
+		info = &engine.ExitInfo{Pid: containerPid}
+	}
+
+	if err := e.CleanupContainer(ctx, fatal, info.Status); err != nil {
 		sylog.Errorf("container cleanup failed: %s", err)
 	}
 
@@ -153,20 +166,25 @@ func Master(rpcSocket, masterSocket int, containerPid int, e *engine.Engine) {
 	// reset signal handlers
 	signal.Reset()
 
+	if info.OOMKilled {
+		sylog.Warningf("Container processes were killed by the out of memory killer")
+	}
+	user, system := info.CPUTime()
+	sylog.Debugf("Child resource usage: max RSS %d bytes, user time %s, system time %s", info.MaxRSS(), user, system)
+
 	exitCode := 0
 
-	if status.Signaled() {
-		s := status.Signal()
+	if s := info.Signal(); s != 0 {
 		sylog.Debugf("Child exited due to signal %d", s)
-		exitCode = 128 + int(s)
+		exitCode = info.ExitCode()
 
 		// mimic signal
 		mainthread.Execute(func() {
 			signalutil.Raise(s)
 		})
-	} else if status.Exited() {
-		sylog.Debugf("Child exited with exit status %d", status.ExitStatus())
-		exitCode = status.ExitStatus()
+	} else if info.Status.Exited() {
+		sylog.Debugf("Child exited with exit status %d", info.Status.ExitStatus())
+		exitCode = info.ExitCode()
 	}
 
 	// if previous signal didn't interrupt process
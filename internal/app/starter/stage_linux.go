@@ -6,6 +6,7 @@
 package starter
 
 import (
+	"errors"
 	"os"
 	"syscall"
 
@@ -54,6 +55,11 @@ func StageTwo(masterSocket int, e *engine.Engine) {
 		if _, err := syscall.Write(masterSocket, []byte("f")); err != nil {
 			sylog.Errorf("fail to send data to master: %s", err)
 		}
+		var exitErr *engine.ExitError
+		if errors.As(err, &exitErr) {
+			sylog.Errorf("%s", err)
+			os.Exit(exitErr.Status)
+		}
 		sylog.Fatalf("%s\n", err)
 	}
 }
@@ -0,0 +1,278 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package batch generates the job scripts of the Slurm, PBS and LSF
+// schedulers running a command in a container.
+package batch
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Slurm is the Slurm scheduler.
+	Slurm = "slurm"
+	// PBS is the PBS Pro and OpenPBS scheduler.
+	PBS = "pbs"
+	// LSF is the IBM Spectrum LSF scheduler.
+	LSF = "lsf"
+)
+
+var (
+	timeRegexp   = regexp.MustCompile(`^([0-9]+):([0-9]{2}):([0-9]{2})$`)
+	memoryRegexp = regexp.MustCompile(`^[0-9]+[kKmMgGtT]?[bB]?$`)
+	safeRegexp   = regexp.MustCompile(`^[a-zA-Z0-9@%+=:,./_-]+$`)
+)
+
+// Job describes a batch job running a command in a container.
+type Job struct {
+	// Scheduler is the scheduler of the job script.
+	Scheduler string
+	// Name is the job name.
+	Name string
+	// Nodes is the number of nodes, Tasks the total number of tasks
+	// spread evenly over the nodes, CPUsPerTask the number of CPUs of
+	// each task and GPUs the number of GPUs of each node.
+	Nodes       int
+	Tasks       int
+	CPUsPerTask int
+	GPUs        int
+	// Memory is the memory of each node with an optional unit
+	// suffix, e.g. 16G.
+	Memory string
+	// Time is the wall clock time limit as HH:MM:SS.
+	Time string
+	// Queue is the partition or queue of the job.
+	Queue string
+	// Account is the account or project charged for the job.
+	Account string
+	// MPI launches the tasks with srun for Slurm, or mpirun for the
+	// other schedulers, instead of running a single process.
+	MPI bool
+
+	// Singularity is the singularity executable path.
+	Singularity string
+	// Image is the container image.
+	Image string
+	// Command is the command executed in the container, the container
+	// runscript is executed if empty.
+	Command []string
+	// Binds and Env are the --bind and --env values of the container.
+	Binds []string
+	Env   []string
+	// Nvidia and Rocm enable GPU support, Nvidia is implied when GPUs
+	// are requested.
+	Nvidia bool
+	Rocm   bool
+}
+
+// Script returns the job script.
+func (j *Job) Script() (string, error) {
+	if err := j.check(); err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+	b.WriteString("#!/bin/bash\n")
+
+	switch j.Scheduler {
+	case Slurm:
+		j.slurmDirectives(&b)
+	case PBS:
+		j.pbsDirectives(&b)
+	case LSF:
+		j.lsfDirectives(&b)
+	}
+
+	b.WriteString("\nset -e\n")
+	if j.Scheduler == PBS {
+		b.WriteString("cd \"$PBS_O_WORKDIR\"\n")
+	}
+	b.WriteString("\n")
+	b.WriteString(quoteArgs(j.launcher()))
+	b.WriteString("\n")
+	return b.String(), nil
+}
+
+func (j *Job) check() error {
+	switch j.Scheduler {
+	case Slurm, PBS, LSF:
+	default:
+		return fmt.Errorf("unknown scheduler %q, supported schedulers are %s, %s and %s", j.Scheduler, Slurm, PBS, LSF)
+	}
+	if j.Name == "" {
+		return fmt.Errorf("no job name")
+	}
+	if j.Nodes < 1 || j.Tasks < 1 || j.CPUsPerTask < 1 || j.GPUs < 0 {
+		return fmt.Errorf("the number of nodes, tasks and CPUs per task must be greater than 0")
+	}
+	if j.Tasks%j.Nodes != 0 {
+		return fmt.Errorf("the number of tasks (%d) must be a multiple of the number of nodes (%d)", j.Tasks, j.Nodes)
+	}
+	if j.Tasks > 1 && !j.MPI {
+		return fmt.Errorf("running %d tasks requires an MPI launch", j.Tasks)
+	}
+	if j.Time != "" && !timeRegexp.MatchString(j.Time) {
+		return fmt.Errorf("invalid time limit %q, it must be HH:MM:SS", j.Time)
+	}
+	if j.Memory != "" && !memoryRegexp.MatchString(j.Memory) {
+		return fmt.Errorf("invalid memory %q, it must be a number with an optional K, M, G or T suffix", j.Memory)
+	}
+	if j.Image == "" {
+		return fmt.Errorf("no container image")
+	}
+	return nil
+}
+
+func (j *Job) slurmDirectives(b *bytes.Buffer) {
+	d := func(format string, a ...interface{}) {
+		fmt.Fprintf(b, "#SBATCH "+format+"\n", a...)
+	}
+	d("--job-name=%s", j.Name)
+	d("--nodes=%d", j.Nodes)
+	d("--ntasks=%d", j.Tasks)
+	d("--cpus-per-task=%d", j.CPUsPerTask)
+	if j.GPUs > 0 {
+		d("--gres=gpu:%d", j.GPUs)
+	}
+	if j.Memory != "" {
+		d("--mem=%s", strings.TrimRight(j.Memory, "bB"))
+	}
+	if j.Time != "" {
+		d("--time=%s", j.Time)
+	}
+	if j.Queue != "" {
+		d("--partition=%s", j.Queue)
+	}
+	if j.Account != "" {
+		d("--account=%s", j.Account)
+	}
+	d("--output=%s-%%j.out", j.Name)
+}
+
+func (j *Job) pbsDirectives(b *bytes.Buffer) {
+	d := func(format string, a ...interface{}) {
+		fmt.Fprintf(b, "#PBS "+format+"\n", a...)
+	}
+	perNode := j.Tasks / j.Nodes
+	d("-N %s", j.Name)
+	resources := fmt.Sprintf("select=%d:ncpus=%d:mpiprocs=%d", j.Nodes, perNode*j.CPUsPerTask, perNode)
+	if j.GPUs > 0 {
+		resources += fmt.Sprintf(":ngpus=%d", j.GPUs)
+	}
+	if j.Memory != "" {
+		resources += ":mem=" + pbsMemory(j.Memory)
+	}
+	d("-l %s", resources)
+	if j.Time != "" {
+		d("-l walltime=%s", j.Time)
+	}
+	if j.Queue != "" {
+		d("-q %s", j.Queue)
+	}
+	if j.Account != "" {
+		d("-A %s", j.Account)
+	}
+	d("-j oe")
+}
+
+func (j *Job) lsfDirectives(b *bytes.Buffer) {
+	d := func(format string, a ...interface{}) {
+		fmt.Fprintf(b, "#BSUB "+format+"\n", a...)
+	}
+	d("-J %s", j.Name)
+	d("-n %d", j.Tasks*j.CPUsPerTask)
+	d("-R \"span[ptile=%d] affinity[core(%d)]\"", j.Tasks/j.Nodes*j.CPUsPerTask, j.CPUsPerTask)
+	if j.GPUs > 0 {
+		d("-gpu \"num=%d\"", j.GPUs)
+	}
+	if j.Memory != "" {
+		d("-M %s", strings.ToUpper(strings.TrimRight(j.Memory, "bB")))
+	}
+	if j.Time != "" {
+		// LSF limits are [hours:]minutes, seconds are rounded up
+		m := timeRegexp.FindStringSubmatch(j.Time)
+		hours, _ := strconv.Atoi(m[1])
+		minutes, _ := strconv.Atoi(m[2])
+		if m[3] != "00" {
+			minutes++
+		}
+		hours += minutes / 60
+		d("-W %d:%02d", hours, minutes%60)
+	}
+	if j.Queue != "" {
+		d("-q %s", j.Queue)
+	}
+	if j.Account != "" {
+		d("-P %s", j.Account)
+	}
+	d("-o %s.%%J.out", j.Name)
+}
+
+// launcher returns the command line of the job.
+func (j *Job) launcher() []string {
+	var args []string
+	if j.MPI {
+		switch j.Scheduler {
+		case Slurm:
+			args = []string{"srun"}
+		default:
+			args = []string{"mpirun", "-np", fmt.Sprint(j.Tasks)}
+		}
+	}
+
+	singularity := j.Singularity
+	if singularity == "" {
+		singularity = "singularity"
+	}
+	args = append(args, singularity)
+	if len(j.Command) == 0 {
+		args = append(args, "run")
+	} else {
+		args = append(args, "exec")
+	}
+	if j.Nvidia || (j.GPUs > 0 && !j.Rocm) {
+		args = append(args, "--nv")
+	}
+	if j.Rocm {
+		args = append(args, "--rocm")
+	}
+	for _, bind := range j.Binds {
+		args = append(args, "--bind", bind)
+	}
+	for _, env := range j.Env {
+		args = append(args, "--env", env)
+	}
+	args = append(args, j.Image)
+	return append(args, j.Command...)
+}
+
+// pbsMemory converts the memory to the PBS syntax, a number with a
+// kb, mb, gb or tb unit.
+func pbsMemory(memory string) string {
+	m := strings.ToLower(strings.TrimRight(memory, "bB"))
+	if m[len(m)-1] >= '0' && m[len(m)-1] <= '9' {
+		// no unit means MiB like with Slurm and LSF
+		return m + "mb"
+	}
+	return m + "b"
+}
+
+// quoteArgs returns the shell command line of args.
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if safeRegexp.MatchString(arg) {
+			quoted[i] = arg
+		} else {
+			quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
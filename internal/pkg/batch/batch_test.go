@@ -0,0 +1,151 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package batch
+
+import (
+	"testing"
+)
+
+func TestScript(t *testing.T) {
+	job := Job{
+		Name:        "train",
+		Nodes:       2,
+		Tasks:       8,
+		CPUsPerTask: 4,
+		GPUs:        4,
+		Memory:      "64G",
+		Time:        "01:30:20",
+		Queue:       "gpu",
+		Account:     "proj",
+		MPI:         true,
+		Singularity: "/usr/bin/singularity",
+		Image:       "train.sif",
+		Command:     []string{"python", "train.py", "--name", "it's"},
+		Binds:       []string{"/scratch"},
+		Env:         []string{"OMP_NUM_THREADS=4"},
+	}
+	launch := "--nv --bind /scratch --env OMP_NUM_THREADS=4 train.sif python train.py --name 'it'\\''s'\n"
+
+	tests := []struct {
+		scheduler string
+		expected  string
+	}{
+		{
+			scheduler: Slurm,
+			expected: `#!/bin/bash
+#SBATCH --job-name=train
+#SBATCH --nodes=2
+#SBATCH --ntasks=8
+#SBATCH --cpus-per-task=4
+#SBATCH --gres=gpu:4
+#SBATCH --mem=64G
+#SBATCH --time=01:30:20
+#SBATCH --partition=gpu
+#SBATCH --account=proj
+#SBATCH --output=train-%j.out
+
+set -e
+
+srun /usr/bin/singularity exec ` + launch,
+		},
+		{
+			scheduler: PBS,
+			expected: `#!/bin/bash
+#PBS -N train
+#PBS -l select=2:ncpus=16:mpiprocs=4:ngpus=4:mem=64gb
+#PBS -l walltime=01:30:20
+#PBS -q gpu
+#PBS -A proj
+#PBS -j oe
+
+set -e
+cd "$PBS_O_WORKDIR"
+
+mpirun -np 8 /usr/bin/singularity exec ` + launch,
+		},
+		{
+			scheduler: LSF,
+			expected: `#!/bin/bash
+#BSUB -J train
+#BSUB -n 32
+#BSUB -R "span[ptile=16] affinity[core(4)]"
+#BSUB -gpu "num=4"
+#BSUB -M 64G
+#BSUB -W 1:31
+#BSUB -q gpu
+#BSUB -P proj
+#BSUB -o train.%J.out
+
+set -e
+
+mpirun -np 8 /usr/bin/singularity exec ` + launch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.scheduler, func(t *testing.T) {
+			j := job
+			j.Scheduler = tt.scheduler
+			script, err := j.Script()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if script != tt.expected {
+				t.Errorf("got script:\n%s\ninstead of:\n%s", script, tt.expected)
+			}
+		})
+	}
+}
+
+func TestScriptRun(t *testing.T) {
+	j := Job{Scheduler: Slurm, Name: "job", Nodes: 1, Tasks: 1, CPUsPerTask: 1, Rocm: true, Image: "docker://alpine"}
+	script, err := j.Script()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := `#!/bin/bash
+#SBATCH --job-name=job
+#SBATCH --nodes=1
+#SBATCH --ntasks=1
+#SBATCH --cpus-per-task=1
+#SBATCH --output=job-%j.out
+
+set -e
+
+singularity run --rocm docker://alpine
+`
+	if script != expected {
+		t.Errorf("got script:\n%s\ninstead of:\n%s", script, expected)
+	}
+}
+
+func TestScriptErrors(t *testing.T) {
+	valid := Job{Scheduler: Slurm, Name: "job", Nodes: 1, Tasks: 1, CPUsPerTask: 1, Image: "image.sif"}
+
+	tests := []struct {
+		name   string
+		modify func(j *Job)
+	}{
+		{"UnknownScheduler", func(j *Job) { j.Scheduler = "sge" }},
+		{"NoName", func(j *Job) { j.Name = "" }},
+		{"NoNodes", func(j *Job) { j.Nodes = 0 }},
+		{"UnevenTasks", func(j *Job) { j.Nodes, j.Tasks, j.MPI = 2, 3, true }},
+		{"TasksWithoutMPI", func(j *Job) { j.Tasks = 2 }},
+		{"BadTime", func(j *Job) { j.Time = "90" }},
+		{"BadMemory", func(j *Job) { j.Memory = "lots" }},
+		{"NoImage", func(j *Job) { j.Image = "" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := valid
+			tt.modify(&j)
+			if _, err := j.Script(); err == nil {
+				t.Errorf("unexpected success")
+			}
+		})
+	}
+}
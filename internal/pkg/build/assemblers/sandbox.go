@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -10,7 +10,10 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 
+	"github.com/hpcng/singularity/internal/pkg/build/buildlog"
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
 	"github.com/hpcng/singularity/pkg/build/types"
 	"github.com/hpcng/singularity/pkg/sylog"
 )
@@ -45,5 +48,15 @@ func (a *SandboxAssembler) Assemble(b *types.Bundle, path string) (err error) {
 		}
 	}
 
+	if b.BuildLog != "" {
+		if err := fs.CopyFile(b.BuildLog, filepath.Join(path, buildlog.SandboxPath), 0644); err != nil {
+			return fmt.Errorf("while copying build log: %s", err)
+		}
+	}
+
+	if b.Opts.SandboxPerms.Enabled() {
+		return NormalizeSandboxPerms(path, b.Opts.SandboxPerms)
+	}
+
 	return nil
 }
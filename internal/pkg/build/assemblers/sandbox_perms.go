@@ -0,0 +1,149 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package assemblers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	"github.com/hpcng/singularity/pkg/build/types"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+// PermChange is a permission modified while normalizing a sandbox.
+type PermChange struct {
+	// Path is the path of the file in the sandbox.
+	Path string `json:"path"`
+	// OldMode and NewMode are the permissions before and after
+	// normalization.
+	OldMode string `json:"oldMode"`
+	NewMode string `json:"newMode"`
+	// Reasons lists the normalizations which modified the permissions.
+	Reasons []string `json:"reasons"`
+}
+
+// PermReport is the report of the permissions modified while normalizing
+// a sandbox.
+type PermReport struct {
+	// Changes are the modified permissions.
+	Changes []PermChange `json:"changes"`
+	// Umask, Setuid and WorldWritable are the number of files modified
+	// by each normalization.
+	Umask         int `json:"umask"`
+	Setuid        int `json:"setuid"`
+	WorldWritable int `json:"worldWritable"`
+}
+
+// normalizeMode returns the mode of a file once normalized according to p
+// with the reasons of the modifications.
+func normalizeMode(mode os.FileMode, p types.SandboxPerms) (os.FileMode, []string) {
+	var reasons []string
+
+	if p.Umask != 0 && mode.Perm()&p.Umask != 0 {
+		mode &^= p.Umask
+		reasons = append(reasons, "umask")
+	}
+	if p.StripSetuid && mode.IsRegular() && mode&(os.ModeSetuid|os.ModeSetgid) != 0 {
+		mode &^= os.ModeSetuid | os.ModeSetgid
+		reasons = append(reasons, "setuid")
+	}
+	// sticky directories like /tmp are world writable by design
+	if p.FixWorldWritable && mode&0002 != 0 && !(mode.IsDir() && mode&os.ModeSticky != 0) {
+		mode &^= 0002
+		reasons = append(reasons, "world-writable")
+	}
+	return mode, reasons
+}
+
+// normalizePerms normalizes the permissions of the files and directories of
+// the sandbox at rootfs, symbolic links and special files are left as is.
+func normalizePerms(rootfs string, p types.SandboxPerms) (*PermReport, error) {
+	r := &PermReport{Changes: []PermChange{}}
+	errors := 0
+
+	err := fs.PermWalk(rootfs, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			sylog.Errorf("Unable to access sandbox path %s: %s", path, err)
+			errors++
+			return nil
+		}
+		if !f.Mode().IsDir() && !f.Mode().IsRegular() {
+			return nil
+		}
+
+		mode, reasons := normalizeMode(f.Mode(), p)
+		if len(reasons) == 0 {
+			return nil
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			sylog.Errorf("Error setting permission for %s: %s", path, err)
+			errors++
+			return nil
+		}
+
+		rel, err := filepath.Rel(rootfs, path)
+		if err != nil {
+			return err
+		}
+		r.Changes = append(r.Changes, PermChange{
+			Path:    filepath.Join("/", rel),
+			OldMode: f.Mode().String(),
+			NewMode: mode.String(),
+			Reasons: reasons,
+		})
+		for _, reason := range reasons {
+			switch reason {
+			case "umask":
+				r.Umask++
+			case "setuid":
+				r.Setuid++
+			case "world-writable":
+				r.WorldWritable++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return r, err
+	}
+	if errors > 0 {
+		return r, fmt.Errorf("%d errors were encountered when setting permissions", errors)
+	}
+	return r, nil
+}
+
+// NormalizeSandboxPerms normalizes the permissions of the sandbox at path
+// according to p, logs a summary of the modified permissions and writes
+// the full report to p.Report if set.
+func NormalizeSandboxPerms(path string, p types.SandboxPerms) error {
+	sylog.Infof("Normalizing sandbox permissions...")
+
+	r, err := normalizePerms(path, p)
+	if err != nil {
+		return fmt.Errorf("while normalizing sandbox permissions: %s", err)
+	}
+	sylog.Infof("Modified permissions of %d file(s): %d umask, %d setuid/setgid, %d world writable",
+		len(r.Changes), r.Umask, r.Setuid, r.WorldWritable)
+	for _, c := range r.Changes {
+		sylog.Debugf("Permissions of %s modified from %s to %s", c.Path, c.OldMode, c.NewMode)
+	}
+
+	if p.Report == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("while encoding permissions report: %s", err)
+	}
+	if err := ioutil.WriteFile(p.Report, append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("while writing permissions report: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package assemblers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcng/singularity/pkg/build/types"
+)
+
+func TestNormalizeMode(t *testing.T) {
+	all := types.SandboxPerms{Umask: 0022, StripSetuid: true, FixWorldWritable: true}
+
+	tests := []struct {
+		name     string
+		mode     os.FileMode
+		perms    types.SandboxPerms
+		expected os.FileMode
+		reasons  int
+	}{
+		{"Unchanged", 0755, all, 0755, 0},
+		{"Umask", 0775, types.SandboxPerms{Umask: 0022}, 0755, 1},
+		{"Setuid", os.ModeSetuid | 0755, types.SandboxPerms{StripSetuid: true}, 0755, 1},
+		{"SetgidDir", os.ModeDir | os.ModeSetgid | 0755, types.SandboxPerms{StripSetuid: true}, os.ModeDir | os.ModeSetgid | 0755, 0},
+		{"WorldWritable", 0666, types.SandboxPerms{FixWorldWritable: true}, 0664, 1},
+		{"StickyDir", os.ModeDir | os.ModeSticky | 0777, types.SandboxPerms{FixWorldWritable: true}, os.ModeDir | os.ModeSticky | 0777, 0},
+		{"All", os.ModeSetuid | 0777, all, 0755, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode, reasons := normalizeMode(tt.mode, tt.perms)
+			if mode != tt.expected {
+				t.Errorf("got mode %v, expected %v", mode, tt.expected)
+			}
+			if len(reasons) != tt.reasons {
+				t.Errorf("got reasons %v, expected %d reason(s)", reasons, tt.reasons)
+			}
+		})
+	}
+}
+
+func TestNormalizeSandboxPerms(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "perms-rootfs-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(rootfs)
+
+	files := map[string]os.FileMode{
+		"passwd":   os.ModeSetuid | 0755,
+		"writable": 0666,
+		"regular":  0644,
+	}
+	for name, mode := range files {
+		path := filepath.Join(rootfs, name)
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("failed to create %s: %s", path, err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatalf("failed to set %s permissions: %s", path, err)
+		}
+	}
+	tmp := filepath.Join(rootfs, "tmp")
+	if err := os.Mkdir(tmp, 0755); err != nil {
+		t.Fatalf("failed to create %s: %s", tmp, err)
+	}
+	if err := os.Chmod(tmp, os.ModeSticky|0777); err != nil {
+		t.Fatalf("failed to set %s permissions: %s", tmp, err)
+	}
+	if err := os.Symlink("regular", filepath.Join(rootfs, "link")); err != nil {
+		t.Fatalf("failed to create symlink: %s", err)
+	}
+
+	report := filepath.Join(rootfs, "..", filepath.Base(rootfs)+".json")
+	defer os.Remove(report)
+
+	p := types.SandboxPerms{StripSetuid: true, FixWorldWritable: true, Report: report}
+	if err := NormalizeSandboxPerms(rootfs, p); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := map[string]os.FileMode{
+		"passwd":   0755,
+		"writable": 0664,
+		"regular":  0644,
+		"tmp":      os.ModeDir | os.ModeSticky | 0777,
+	}
+	for name, mode := range expected {
+		fi, err := os.Stat(filepath.Join(rootfs, name))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if fi.Mode() != mode {
+			t.Errorf("got %s mode %v, expected %v", name, fi.Mode(), mode)
+		}
+	}
+
+	b, err := ioutil.ReadFile(report)
+	if err != nil {
+		t.Fatalf("failed to read report: %s", err)
+	}
+	var r PermReport
+	if err := json.Unmarshal(b, &r); err != nil {
+		t.Fatalf("failed to decode report: %s", err)
+	}
+	if len(r.Changes) != 2 || r.Setuid != 1 || r.WorldWritable != 1 {
+		t.Errorf("unexpected report: %+v", r)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
 	"runtime"
 	"sort"
@@ -17,11 +18,13 @@ import (
 	"syscall"
 
 	"github.com/hpcng/sif/pkg/sif"
+	"github.com/hpcng/singularity/internal/pkg/build/buildlog"
 	"github.com/hpcng/singularity/internal/pkg/util/machine"
 	"github.com/hpcng/singularity/pkg/build/types"
 	"github.com/hpcng/singularity/pkg/image/packer"
 	"github.com/hpcng/singularity/pkg/sylog"
 	"github.com/hpcng/singularity/pkg/util/crypt"
+	"github.com/hpcng/singularity/pkg/util/verity"
 	uuid "github.com/satori/go.uuid"
 )
 
@@ -31,6 +34,24 @@ type SIFAssembler struct {
 	MksquashfsProcs uint
 	MksquashfsMem   string
 	MksquashfsPath  string
+	// Packer is the tool used to create the squashfs partition,
+	// either mksquashfs (default), tar2sqfs or builtin.
+	Packer       string
+	Tar2sqfsPath string
+	// DeltaFrom is the path of a source SIF image whose root filesystem
+	// partition is reused, only changes are packed into an overlay
+	// partition.
+	DeltaFrom string
+	// Verity adds a dm-verity hash tree of the root filesystem
+	// partition, the partition is padded to the hash block size.
+	Verity bool
+	// Exclude are root filesystem paths whose content isn't packed,
+	// like the mount points of a running container, directories are
+	// packed empty. It requires mksquashfs.
+	Exclude []string
+	// Overlay is the path of an overlay upper directory packed into an
+	// overlay partition added to the image. It requires mksquashfs.
+	Overlay string
 }
 
 type encryptionOptions struct {
@@ -38,7 +59,7 @@ type encryptionOptions struct {
 	plaintext []byte
 }
 
-func createSIF(path string, b *types.Bundle, squashfile string, encOpts *encryptionOptions, arch string) (err error) {
+func createSIF(path string, b *types.Bundle, squashfile string, encOpts *encryptionOptions, arch string, withVerity bool) (err error) {
 	definition := b.Recipe.Raw
 
 	id, err := uuid.NewV4()
@@ -90,6 +111,21 @@ func createSIF(path string, b *types.Bundle, squashfile string, encOpts *encrypt
 		}
 	}
 
+	if b.BuildLog != "" {
+		data, err := ioutil.ReadFile(b.BuildLog)
+		if err != nil {
+			return fmt.Errorf("while reading build log: %s", err)
+		}
+		cinfo.InputDescr = append(cinfo.InputDescr, sif.DescriptorInput{
+			Datatype: sif.DataGeneric,
+			Groupid:  sif.DescrDefaultGroup,
+			Link:     sif.DescrUnusedLink,
+			Data:     data,
+			Size:     int64(len(data)),
+			Fname:    buildlog.DescriptorName,
+		})
+	}
+
 	// data we need to create a system partition descriptor
 	parinput := sif.DescriptorInput{
 		Datatype: sif.DataPartition,
@@ -127,6 +163,31 @@ func createSIF(path string, b *types.Bundle, squashfile string, encOpts *encrypt
 	// add this descriptor input element to the list
 	cinfo.InputDescr = append(cinfo.InputDescr, parinput)
 
+	if withVerity {
+		syspartID := uint32(len(cinfo.InputDescr))
+
+		vf, err := os.Open(squashfile)
+		if err != nil {
+			return fmt.Errorf("while opening partition file: %s", err)
+		}
+		defer vf.Close()
+
+		data, params, err := verity.Create(vf, parinput.Size)
+		if err != nil {
+			return fmt.Errorf("while creating dm-verity hash tree: %s", err)
+		}
+		sylog.Verbosef("dm-verity root hash: %s", params.RootHash)
+
+		cinfo.InputDescr = append(cinfo.InputDescr, sif.DescriptorInput{
+			Datatype: sif.DataGeneric,
+			Groupid:  sif.DescrDefaultGroup,
+			Link:     syspartID,
+			Data:     data,
+			Size:     int64(len(data)),
+			Fname:    verity.DescriptorName,
+		})
+	}
+
 	if encOpts != nil {
 		data, err := crypt.EncryptKey(encOpts.keyInfo, encOpts.plaintext)
 		if err != nil {
@@ -176,22 +237,9 @@ func createSIF(path string, b *types.Bundle, squashfile string, encOpts *encrypt
 	return nil
 }
 
-// Assemble creates a SIF image from a Bundle.
-func (a *SIFAssembler) Assemble(b *types.Bundle, path string) error {
-	sylog.Infof("Creating SIF file...")
-
-	s := packer.NewSquashfs()
-	s.MksquashfsPath = a.MksquashfsPath
-
-	f, err := ioutil.TempFile(b.TmpDir, "squashfs-")
-	if err != nil {
-		return fmt.Errorf("while creating temporary file for squashfs: %v", err)
-	}
-
-	fsPath := f.Name()
-	f.Close()
-	defer os.Remove(fsPath)
-
+// mksquashfsFlags returns the mksquashfs flags used to create
+// the squashfs partitions.
+func (a *SIFAssembler) mksquashfsFlags() []string {
 	flags := []string{"-noappend"}
 	// build squashfs with all-root flag when building as a user
 	if syscall.Getuid() != 0 {
@@ -207,6 +255,95 @@ func (a *SIFAssembler) Assemble(b *types.Bundle, path string) error {
 	if a.MksquashfsProcs != 0 {
 		flags = append(flags, "-processors", fmt.Sprint(a.MksquashfsProcs))
 	}
+	return flags
+}
+
+// checkXattrs warns about the extended attributes of the src directory
+// which can't be stored in the squashfs image by the selected packer.
+func (a *SIFAssembler) checkXattrs(src string) {
+	stats, err := packer.ScanXattrs(src)
+	if err != nil {
+		sylog.Warningf("Unable to check extended attributes of %s: %s", src, err)
+		return
+	}
+
+	if stats.ACLs > 0 {
+		sylog.Warningf("%d file(s) have POSIX ACLs which can't be stored in squashfs images and are dropped", stats.ACLs)
+	}
+	if stats.Files == 0 {
+		return
+	}
+	if a.Packer == "builtin" {
+		sylog.Warningf("The builtin packer doesn't store extended attributes, they are dropped from %d file(s) including %d with file capabilities", stats.Files, stats.Capabilities)
+		sylog.Warningf("Use the mksquashfs or tar2sqfs packer to preserve them")
+		return
+	}
+	sylog.Verbosef("Preserving extended attributes of %d file(s) including %d with file capabilities", stats.Files, stats.Capabilities)
+}
+
+// createSquashfs creates the squashfs image dest from the src directory
+// with the selected packer.
+func (a *SIFAssembler) createSquashfs(src, dest string) error {
+	allRoot := syscall.Getuid() != 0
+
+	a.checkXattrs(src)
+
+	flags := a.mksquashfsFlags()
+	if len(a.Exclude) > 0 {
+		if a.Packer == "builtin" || a.Packer == "tar2sqfs" {
+			return fmt.Errorf("excluding paths requires mksquashfs as squashfs packer")
+		}
+		excludeFile, err := writeExcludeFile(filepath.Dir(dest), excludePatterns(src, a.Exclude))
+		if err != nil {
+			return err
+		}
+		defer os.Remove(excludeFile)
+		flags = append(flags, "-wildcards", "-ef", excludeFile)
+	}
+
+	switch a.Packer {
+	case "builtin":
+		w := packer.NewSquashfsWriter()
+		w.AllRoot = allRoot
+		return w.Create(src, dest)
+	case "tar2sqfs":
+		t := packer.NewTar2sqfs()
+		t.Tar2sqfsPath = a.Tar2sqfsPath
+		t.AllRoot = allRoot
+
+		// tar2sqfs defaults to xz compression, always request gzip
+		flags := []string{"-f", "-q", "-k", "-c", "gzip"}
+		if a.MksquashfsProcs != 0 {
+			flags = append(flags, "-j", fmt.Sprint(a.MksquashfsProcs))
+		}
+		if a.MksquashfsMem != "" {
+			sylog.Debugf("Memory limit %s ignored by tar2sqfs", a.MksquashfsMem)
+		}
+		return t.Create(src, dest, flags)
+	}
+
+	s := packer.NewSquashfs()
+	s.MksquashfsPath = a.MksquashfsPath
+	return s.Create([]string{src}, dest, flags)
+}
+
+// Assemble creates a SIF image from a Bundle.
+func (a *SIFAssembler) Assemble(b *types.Bundle, path string) error {
+	if a.DeltaFrom != "" {
+		return a.assembleDelta(b, path)
+	}
+
+	sylog.Infof("Creating SIF file...")
+
+	f, err := ioutil.TempFile(b.TmpDir, "squashfs-")
+	if err != nil {
+		return fmt.Errorf("while creating temporary file for squashfs: %v", err)
+	}
+
+	fsPath := f.Name()
+	f.Close()
+	defer os.Remove(fsPath)
+
 	arch := machine.ArchFromContainer(b.RootfsPath)
 	if arch == "" {
 		sylog.Infof("Architecture not recognized, use native")
@@ -214,7 +351,7 @@ func (a *SIFAssembler) Assemble(b *types.Bundle, path string) error {
 	}
 	sylog.Verbosef("Set SIF container architecture to %s", arch)
 
-	if err := s.Create([]string{b.RootfsPath}, fsPath, flags); err != nil {
+	if err := a.createSquashfs(b.RootfsPath, fsPath); err != nil {
 		return fmt.Errorf("while creating squashfs: %v", err)
 	}
 
@@ -248,11 +385,61 @@ func (a *SIFAssembler) Assemble(b *types.Bundle, path string) error {
 
 	}
 
-	err = createSIF(path, b, fsPath, encOpts, arch)
+	if a.Verity {
+		// squashfs ignores trailing data, pad the partition to a
+		// multiple of the hash block size required by dm-verity
+		if err := padFile(fsPath, verity.BlockSize); err != nil {
+			return fmt.Errorf("while padding squashfs: %v", err)
+		}
+	}
+
+	err = createSIF(path, b, fsPath, encOpts, arch, a.Verity)
 	if err != nil {
 		return fmt.Errorf("while creating SIF: %v", err)
 	}
 
+	if a.Overlay != "" {
+		if err := a.addOverlay(b.TmpDir, path); err != nil {
+			os.Remove(path)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addOverlay packs the overlay upper directory and adds it as an overlay
+// partition of the SIF image at path, its whiteouts are kept as is.
+func (a *SIFAssembler) addOverlay(tmpdir, path string) error {
+	if a.Packer == "builtin" || a.Packer == "tar2sqfs" {
+		return fmt.Errorf("overlay partition requires mksquashfs as squashfs packer")
+	}
+
+	f, err := ioutil.TempFile(tmpdir, "squashfs-overlay-")
+	if err != nil {
+		return fmt.Errorf("while creating temporary file for squashfs: %v", err)
+	}
+	fsPath := f.Name()
+	f.Close()
+	defer os.Remove(fsPath)
+
+	s := packer.NewSquashfs()
+	s.MksquashfsPath = a.MksquashfsPath
+	if err := s.Create([]string{a.Overlay}, fsPath, a.mksquashfsFlags()); err != nil {
+		return fmt.Errorf("while creating overlay squashfs: %v", err)
+	}
+	return addDeltaPartition(path, fsPath)
+}
+
+// padFile extends the file at path with zeros to a multiple of size.
+func padFile(path string, size int64) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if rem := fi.Size() % size; rem != 0 {
+		return os.Truncate(path, fi.Size()+size-rem)
+	}
 	return nil
 }
 
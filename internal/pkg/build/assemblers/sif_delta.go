@@ -0,0 +1,546 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package assemblers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hpcng/sif/pkg/sif"
+	"github.com/hpcng/singularity/pkg/build/types"
+	"github.com/hpcng/singularity/pkg/image"
+	"github.com/hpcng/singularity/pkg/image/packer"
+	"github.com/hpcng/singularity/pkg/image/unpacker"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+// deltaPartName is the name given to the overlay partition holding
+// the changes between the source SIF and the repacked root filesystem.
+const deltaPartName = "delta"
+
+// rootfsDelta holds the changes found between a root filesystem
+// directory and the squashfs root filesystem of a source image.
+type rootfsDelta struct {
+	// unchanged contains root filesystem paths, relative to the root
+	// filesystem, of any non-directory entries identical in the source.
+	unchanged []string
+	// deleted contains absolute paths present in the source only.
+	deleted []string
+	// changed counts added or modified entries.
+	changed int
+}
+
+// lsMode returns the ls-like mode string of the file mode as
+// reported by unsquashfs long listing.
+func lsMode(m os.FileMode) string {
+	b := []byte("----------")
+
+	switch {
+	case m.IsDir():
+		b[0] = 'd'
+	case m&os.ModeSymlink != 0:
+		b[0] = 'l'
+	case m&os.ModeCharDevice != 0:
+		b[0] = 'c'
+	case m&os.ModeDevice != 0:
+		b[0] = 'b'
+	case m&os.ModeNamedPipe != 0:
+		b[0] = 'p'
+	case m&os.ModeSocket != 0:
+		b[0] = 's'
+	}
+
+	const rwx = "rwxrwxrwx"
+	for i := 0; i < 9; i++ {
+		if m&(1<<uint(8-i)) != 0 {
+			b[i+1] = rwx[i]
+		}
+	}
+
+	special := []struct {
+		bit    os.FileMode
+		pos    int
+		set    byte
+		noExec byte
+	}{
+		{os.ModeSetuid, 3, 's', 'S'},
+		{os.ModeSetgid, 6, 's', 'S'},
+		{os.ModeSticky, 9, 't', 'T'},
+	}
+	for _, s := range special {
+		if m&s.bit == 0 {
+			continue
+		}
+		if b[s.pos] == 'x' {
+			b[s.pos] = s.set
+		} else {
+			b[s.pos] = s.noExec
+		}
+	}
+
+	return string(b)
+}
+
+// computeDelta compares the root filesystem directory with the
+// source entries. Owners are ignored when allRoot is set as the
+// filesystem is packed with all files owned by root in that case.
+// The content of the excluded paths is considered unchanged.
+func computeDelta(rootfs string, entries []unpacker.Entry, allRoot bool, exclude []string) (*rootfsDelta, error) {
+	source := make(map[string]unpacker.Entry, len(entries))
+	for _, e := range entries {
+		source[e.Path] = e
+	}
+	excluded := make(map[string]bool, len(exclude))
+	for _, p := range exclude {
+		excluded[filepath.Join("/", p)] = true
+	}
+
+	delta := new(rootfsDelta)
+	seen := make(map[string]bool, len(entries))
+
+	err := filepath.Walk(rootfs, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(rootfs, path)
+		if err != nil {
+			return err
+		}
+		abs := filepath.Join("/", rel)
+		seen[abs] = true
+
+		if abs == "/" {
+			return nil
+		}
+		// excluded entries are left out by the exclude patterns
+		if excluded[abs] && !fi.IsDir() {
+			return nil
+		}
+
+		e, ok := source[abs]
+		if !ok || !sameEntry(path, fi, e, allRoot) {
+			delta.changed++
+		} else if !fi.IsDir() {
+			delta.unchanged = append(delta.unchanged, rel)
+		}
+		if excluded[abs] {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("while comparing root filesystem with source image: %s", err)
+	}
+
+	for _, e := range entries {
+		if seen[e.Path] || isExcluded(e.Path, excluded) {
+			continue
+		}
+		// a whiteout of the parent directory hides it already
+		if parent := filepath.Dir(e.Path); parent != "/" && !seen[parent] {
+			continue
+		}
+		delta.deleted = append(delta.deleted, e.Path)
+	}
+
+	return delta, nil
+}
+
+// sameEntry returns whether the root filesystem file metadata are
+// identical to the source entry, the content of regular files is
+// compared later by verifyContent.
+func sameEntry(path string, fi os.FileInfo, e unpacker.Entry, allRoot bool) bool {
+	if lsMode(fi.Mode()) != e.Mode {
+		return false
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && !allRoot {
+		if int(st.Uid) != e.UID || int(st.Gid) != e.GID {
+			return false
+		}
+	}
+	if fi.IsDir() {
+		return true
+	}
+	if !fi.ModTime().Truncate(time.Minute).Equal(e.ModTime) {
+		return false
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		return err == nil && target == e.Target
+	}
+	if fi.Mode().IsRegular() {
+		return fi.Size() == e.Size
+	}
+	return true
+}
+
+// verifyBatch is the number of source files extracted at once by
+// verifyContent.
+const verifyBatch = 256
+
+// sameContent returns whether both regular files have the same content.
+func sameContent(a, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	ba := make([]byte, 32*1024)
+	bb := make([]byte, 32*1024)
+	for {
+		na, erra := io.ReadFull(fa, ba)
+		nb, errb := io.ReadFull(fb, bb)
+		if na != nb || !bytes.Equal(ba[:na], bb[:nb]) {
+			return false, nil
+		}
+		if erra == io.EOF || erra == io.ErrUnexpectedEOF {
+			return errb == io.EOF || errb == io.ErrUnexpectedEOF, nil
+		} else if erra != nil {
+			return false, erra
+		} else if errb != nil && errb != io.EOF && errb != io.ErrUnexpectedEOF {
+			return false, errb
+		}
+	}
+}
+
+// verifyContent compares the content of the unchanged regular files
+// with the files of the source squashfs partition, extracted by batches
+// in a temporary directory. Files with a different content are counted
+// as changed.
+func verifyContent(rootfs, srcPart, tmpdir string, delta *rootfsDelta) error {
+	var regular []string
+	unchanged := make([]string, 0, len(delta.unchanged))
+	for _, rel := range delta.unchanged {
+		fi, err := os.Lstat(filepath.Join(rootfs, rel))
+		if err != nil {
+			return fmt.Errorf("while comparing %s content: %s", rel, err)
+		}
+		if fi.Mode().IsRegular() && fi.Size() > 0 {
+			regular = append(regular, rel)
+		} else {
+			unchanged = append(unchanged, rel)
+		}
+	}
+
+	src, err := os.Open(srcPart)
+	if err != nil {
+		return fmt.Errorf("while opening source root filesystem: %s", err)
+	}
+	defer src.Close()
+
+	s := unpacker.NewSquashfs()
+
+	for len(regular) > 0 {
+		n := verifyBatch
+		if n > len(regular) {
+			n = len(regular)
+		}
+		batch := regular[:n]
+		regular = regular[n:]
+
+		dir, err := ioutil.TempDir(tmpdir, "delta-verify-")
+		if err != nil {
+			return fmt.Errorf("while creating temporary directory: %s", err)
+		}
+		// unsquashfs extract arguments are wildcard patterns
+		files := make([]string, len(batch))
+		for i, rel := range batch {
+			files[i] = escapePattern(rel)
+		}
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			os.RemoveAll(dir)
+			return fmt.Errorf("while reading source root filesystem: %s", err)
+		}
+		if err := s.ExtractFiles(files, src, dir); err != nil {
+			os.RemoveAll(dir)
+			return fmt.Errorf("while extracting source files: %s", err)
+		}
+
+		for _, rel := range batch {
+			same, err := sameContent(filepath.Join(rootfs, rel), filepath.Join(dir, rel))
+			if err != nil && !os.IsNotExist(err) {
+				os.RemoveAll(dir)
+				return fmt.Errorf("while comparing %s content: %s", rel, err)
+			}
+			if same {
+				unchanged = append(unchanged, rel)
+			} else {
+				delta.changed++
+			}
+		}
+		os.RemoveAll(dir)
+	}
+
+	delta.unchanged = unchanged
+	return nil
+}
+
+// quotePseudo quotes a path for use in a mksquashfs pseudo file.
+func quotePseudo(path string) string {
+	if !strings.ContainsAny(path, " \t\"\\") {
+		return path
+	}
+	return strconv.Quote(path)
+}
+
+// writeDeltaLists writes the mksquashfs exclude file listing unchanged
+// entries and the pseudo file defining overlay whiteouts for deleted
+// entries. When patterns are given, they are added to the exclude file
+// and the unchanged entries are escaped for use with -wildcards.
+func writeDeltaLists(tmpdir string, delta *rootfsDelta, patterns []string) (excludeFile, pseudoFile string, err error) {
+	lines := delta.unchanged
+	if len(patterns) > 0 {
+		lines = make([]string, 0, len(delta.unchanged)+len(patterns))
+		for _, p := range delta.unchanged {
+			lines = append(lines, escapePattern(p))
+		}
+		lines = append(lines, patterns...)
+	}
+	excludeFile, err = writeExcludeFile(tmpdir, lines)
+	if err != nil {
+		return "", "", err
+	}
+
+	pf, err := ioutil.TempFile(tmpdir, "delta-pseudo-")
+	if err != nil {
+		os.Remove(excludeFile)
+		return "", "", fmt.Errorf("while creating pseudo file: %s", err)
+	}
+	defer pf.Close()
+
+	w := bufio.NewWriter(pf)
+	for _, p := range delta.deleted {
+		// overlay whiteouts are 0/0 character devices
+		fmt.Fprintf(w, "%s c 0000 0 0 0 0\n", quotePseudo(strings.TrimPrefix(p, "/")))
+	}
+	if err := w.Flush(); err != nil {
+		os.Remove(excludeFile)
+		return "", "", fmt.Errorf("while writing pseudo file: %s", err)
+	}
+
+	return excludeFile, pf.Name(), nil
+}
+
+// extractRootfsPartition copies the squashfs root filesystem partition
+// of the source image into a temporary file.
+func extractRootfsPartition(img *image.Image, tmpdir string) (string, error) {
+	if len(img.Partitions) == 0 || img.Partitions[0].Type != image.SQUASHFS {
+		return "", fmt.Errorf("%s doesn't have a squashfs root filesystem partition", img.Path)
+	}
+	part := img.Partitions[0]
+
+	f, err := ioutil.TempFile(tmpdir, "delta-source-")
+	if err != nil {
+		return "", fmt.Errorf("while creating temporary file: %s", err)
+	}
+	defer f.Close()
+
+	r := io.NewSectionReader(img.File, int64(part.Offset), int64(part.Size))
+	if _, err := io.Copy(f, r); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("while copying root filesystem partition: %s", err)
+	}
+	return f.Name(), nil
+}
+
+// assembleDelta creates a SIF image at path reusing the root filesystem
+// partition of the source image, and adding a squashfs overlay partition
+// containing only entries which differ between the bundle root filesystem
+// and the source root filesystem.
+func (a *SIFAssembler) assembleDelta(b *types.Bundle, path string) error {
+	sylog.Infof("Creating SIF file from %s with delta overlay partition...", a.DeltaFrom)
+
+	if b.Opts.EncryptionKeyInfo != nil {
+		return fmt.Errorf("delta repack is not supported with encrypted images")
+	}
+
+	img, err := image.Init(a.DeltaFrom, false)
+	if err != nil {
+		return fmt.Errorf("while opening source image %s: %s", a.DeltaFrom, err)
+	}
+	defer img.File.Close()
+
+	if img.Type != image.SIF {
+		return fmt.Errorf("source image %s is not a SIF image", a.DeltaFrom)
+	}
+
+	srcPart, err := extractRootfsPartition(img, b.TmpDir)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(srcPart)
+
+	entries, err := unpacker.NewSquashfs().List(srcPart)
+	if err != nil {
+		return fmt.Errorf("while listing source root filesystem: %s", err)
+	}
+
+	allRoot := syscall.Getuid() != 0
+
+	delta, err := computeDelta(b.RootfsPath, entries, allRoot, a.Exclude)
+	if err != nil {
+		return err
+	}
+	// modification time and size may be preserved by a modification,
+	// only keep files with an identical content
+	if err := verifyContent(b.RootfsPath, srcPart, b.TmpDir, delta); err != nil {
+		return err
+	}
+	sylog.Verbosef("Delta repack: %d added or changed, %d deleted, %d unchanged entries",
+		delta.changed, len(delta.deleted), len(delta.unchanged))
+
+	if err := copySIF(a.DeltaFrom, path); err != nil {
+		return err
+	}
+
+	if delta.changed == 0 && len(delta.deleted) == 0 {
+		sylog.Infof("No changes found against %s, source image copied as is", a.DeltaFrom)
+		return nil
+	}
+
+	patterns := excludePatterns(b.RootfsPath, a.Exclude)
+	excludeFile, pseudoFile, err := writeDeltaLists(b.TmpDir, delta, patterns)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(excludeFile)
+	defer os.Remove(pseudoFile)
+
+	f, err := ioutil.TempFile(b.TmpDir, "squashfs-delta-")
+	if err != nil {
+		return fmt.Errorf("while creating temporary file for squashfs: %v", err)
+	}
+	fsPath := f.Name()
+	f.Close()
+	defer os.Remove(fsPath)
+
+	flags := a.mksquashfsFlags()
+	if len(patterns) > 0 {
+		flags = append(flags, "-wildcards")
+	}
+	flags = append(flags, "-ef", excludeFile, "-pf", pseudoFile)
+
+	s := packer.NewSquashfs()
+	s.MksquashfsPath = a.MksquashfsPath
+	if err := s.Create([]string{b.RootfsPath}, fsPath, flags); err != nil {
+		return fmt.Errorf("while creating delta squashfs: %v", err)
+	}
+
+	if err := addDeltaPartition(path, fsPath); err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	sylog.Infof("Changes are stored in an overlay partition, the image requires overlay support at runtime")
+	return nil
+}
+
+// copySIF copies the source SIF image to the destination path.
+func copySIF(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("while opening %s: %s", src, err)
+	}
+	defer in.Close()
+
+	// remove anything that may exist at the build destination at last moment
+	os.RemoveAll(dst)
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return fmt.Errorf("while creating %s: %s", dst, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("while copying %s to %s: %s", src, dst, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("while closing %s: %s", dst, err)
+	}
+
+	// chown the sif file to the calling user
+	if uid, gid, ok := changeOwner(); ok {
+		if err := os.Chown(dst, uid, gid); err != nil {
+			return fmt.Errorf("while changing image ownership: %s", err)
+		}
+	}
+	return nil
+}
+
+// addDeltaPartition adds the squashfs delta as an overlay partition
+// in the same group than the primary system partition.
+func addDeltaPartition(path, fsPath string) error {
+	fimg, err := sif.LoadContainer(path, false)
+	if err != nil {
+		return fmt.Errorf("while loading SIF image %s: %s", path, err)
+	}
+	defer fimg.UnloadContainer()
+
+	primary, _, err := fimg.GetPartPrimSys()
+	if err != nil {
+		return fmt.Errorf("while looking for primary partition in %s: %s", path, err)
+	}
+
+	for _, d := range fimg.DescrArr {
+		if d.Used && d.Datatype == sif.DataSignature {
+			sylog.Warningf("Source image signatures don't cover the delta partition, the image needs to be signed again")
+			break
+		}
+	}
+
+	fp, err := os.Open(fsPath)
+	if err != nil {
+		return fmt.Errorf("while opening delta partition: %s", err)
+	}
+	defer fp.Close()
+
+	fi, err := fp.Stat()
+	if err != nil {
+		return fmt.Errorf("while calling stat on delta partition: %s", err)
+	}
+
+	input := sif.DescriptorInput{
+		Datatype: sif.DataPartition,
+		Groupid:  primary.Groupid,
+		Link:     sif.DescrUnusedLink,
+		Fname:    deltaPartName,
+		Fp:       fp,
+		Size:     fi.Size(),
+	}
+
+	arch, err := primary.GetArch()
+	if err != nil {
+		return fmt.Errorf("while getting primary partition architecture: %s", err)
+	}
+	if err := input.SetPartExtra(sif.FsSquash, sif.PartOverlay, trimArch(arch)); err != nil {
+		return err
+	}
+
+	if err := fimg.AddObject(input); err != nil {
+		return fmt.Errorf("while adding delta partition: %s", err)
+	}
+	return nil
+}
+
+func trimArch(arch [sif.HdrArchLen]byte) string {
+	return strings.TrimRight(string(arch[:]), "\x00")
+}
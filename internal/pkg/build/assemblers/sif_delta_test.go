@@ -0,0 +1,198 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package assemblers
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hpcng/singularity/pkg/image/unpacker"
+)
+
+func TestLsMode(t *testing.T) {
+	tests := []struct {
+		mode     os.FileMode
+		expected string
+	}{
+		{os.ModeDir | 0755, "drwxr-xr-x"},
+		{0644, "-rw-r--r--"},
+		{os.ModeSymlink | 0777, "lrwxrwxrwx"},
+		{os.ModeSetuid | 0755, "-rwsr-xr-x"},
+		{os.ModeSetgid | 0644, "-rw-r-Sr--"},
+		{os.ModeDir | os.ModeSticky | 0777, "drwxrwxrwt"},
+		{os.ModeDevice | os.ModeCharDevice | 0666, "crw-rw-rw-"},
+	}
+
+	for _, tt := range tests {
+		if got := lsMode(tt.mode); got != tt.expected {
+			t.Errorf("unexpected mode string for %v: got %s instead of %s", tt.mode, got, tt.expected)
+		}
+	}
+}
+
+func TestComputeDelta(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "delta-rootfs-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(rootfs)
+
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Minute)
+
+	files := map[string]string{
+		"unchanged": "same",
+		"modified":  "new content",
+		"added":     "added",
+	}
+	for name, content := range files {
+		path := filepath.Join(rootfs, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create %s: %s", path, err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatalf("failed to change %s times: %s", path, err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(rootfs, "dir"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %s", err)
+	}
+
+	entries := []unpacker.Entry{
+		{Path: "/", Mode: "drwxr-xr-x"},
+		{Path: "/unchanged", Mode: "-rw-r--r--", Size: 4, ModTime: mtime},
+		{Path: "/modified", Mode: "-rw-r--r--", Size: 3, ModTime: mtime},
+		{Path: "/dir", Mode: "drwxr-xr-x"},
+		{Path: "/deleted", Mode: "-rw-r--r--", Size: 1, ModTime: mtime},
+		{Path: "/removed", Mode: "drwxr-xr-x"},
+		{Path: "/removed/file", Mode: "-rw-r--r--", Size: 1, ModTime: mtime},
+	}
+
+	delta, err := computeDelta(rootfs, entries, true, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(delta.unchanged) != 1 || delta.unchanged[0] != "unchanged" {
+		t.Errorf("unexpected unchanged entries: %v", delta.unchanged)
+	}
+	if delta.changed != 2 {
+		t.Errorf("unexpected number of changed entries: got %d instead of 2", delta.changed)
+	}
+	if len(delta.deleted) != 2 || delta.deleted[0] != "/deleted" || delta.deleted[1] != "/removed" {
+		t.Errorf("unexpected deleted entries: %v", delta.deleted)
+	}
+}
+
+func TestSameContent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "delta-content-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	large := make([]byte, 100*1024)
+	modified := make([]byte, len(large))
+	modified[len(modified)-1] = 1
+
+	files := map[string][]byte{
+		"a":        []byte("content"),
+		"b":        []byte("content"),
+		"c":        []byte("CONTENT"),
+		"short":    []byte("cont"),
+		"large":    large,
+		"large2":   large,
+		"modified": modified,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatalf("failed to create %s: %s", name, err)
+		}
+	}
+
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"a", "b", true},
+		{"a", "c", false},
+		{"a", "short", false},
+		{"short", "a", false},
+		{"large", "large2", true},
+		{"large", "modified", false},
+	}
+	for _, tt := range tests {
+		same, err := sameContent(filepath.Join(dir, tt.a), filepath.Join(dir, tt.b))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if same != tt.same {
+			t.Errorf("got %v instead of %v when comparing %s and %s", same, tt.same, tt.a, tt.b)
+		}
+	}
+
+	if _, err := sameContent(filepath.Join(dir, "a"), filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("unexpected error for a missing file: %v", err)
+	}
+}
+
+func TestVerifyContent(t *testing.T) {
+	mksquashfsPath, err := exec.LookPath("mksquashfs")
+	if err != nil {
+		t.Skip("mksquashfs not found")
+	}
+	if _, err := exec.LookPath("unsquashfs"); err != nil {
+		t.Skip("unsquashfs not found")
+	}
+
+	tmpdir, err := ioutil.TempDir("", "delta-verify-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	source := filepath.Join(tmpdir, "source")
+	rootfs := filepath.Join(tmpdir, "rootfs")
+	for _, dir := range []string{source, rootfs} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatalf("failed to create directory: %s", err)
+		}
+	}
+
+	// same size, only the content differs
+	write := func(dir, name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create %s: %s", name, err)
+		}
+	}
+	write(source, "same", "same")
+	write(rootfs, "same", "same")
+	write(source, "edited", "aaaa")
+	write(rootfs, "edited", "bbbb")
+	if err := os.Symlink("same", filepath.Join(rootfs, "link")); err != nil {
+		t.Fatalf("failed to create symlink: %s", err)
+	}
+
+	srcPart := filepath.Join(tmpdir, "source.sqfs")
+	if out, err := exec.Command(mksquashfsPath, source, srcPart, "-noappend").CombinedOutput(); err != nil {
+		t.Fatalf("failed to create squashfs image: %s: %s", err, out)
+	}
+
+	delta := &rootfsDelta{unchanged: []string{"same", "edited", "link"}}
+	if err := verifyContent(rootfs, srcPart, tmpdir, delta); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(delta.unchanged, []string{"link", "same"}) {
+		t.Errorf("unexpected unchanged entries: %v", delta.unchanged)
+	}
+	if delta.changed != 1 {
+		t.Errorf("unexpected number of changed entries: got %d instead of 1", delta.changed)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package assemblers
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// escapePattern escapes the wildcard characters of a path for use in a
+// mksquashfs exclude file with -wildcards.
+func escapePattern(path string) string {
+	if !strings.ContainsAny(path, "*?[]\\") {
+		return path
+	}
+	var b strings.Builder
+	for _, r := range path {
+		if strings.ContainsRune("*?[]\\", r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// excludePatterns returns the mksquashfs wildcard patterns excluding the
+// paths of the root filesystem, the directories are kept but not their
+// content.
+func excludePatterns(rootfs string, exclude []string) []string {
+	var patterns []string
+	for _, p := range exclude {
+		rel := strings.TrimPrefix(filepath.Join("/", p), "/")
+		if rel == "" {
+			continue
+		}
+		fi, err := os.Lstat(filepath.Join(rootfs, rel))
+		if err == nil && fi.IsDir() {
+			// * doesn't match the leading dot of hidden entries
+			patterns = append(patterns, escapePattern(rel)+"/*", escapePattern(rel)+"/.*")
+			continue
+		}
+		patterns = append(patterns, escapePattern(rel))
+	}
+	return patterns
+}
+
+// isExcluded returns whether the absolute path or one of its parents is
+// excluded.
+func isExcluded(path string, excluded map[string]bool) bool {
+	for ; path != "/" && path != "."; path = filepath.Dir(path) {
+		if excluded[path] {
+			return true
+		}
+	}
+	return false
+}
+
+// writeExcludeFile writes the lines of a mksquashfs exclude file in a
+// temporary file and returns its path.
+func writeExcludeFile(tmpdir string, lines []string) (string, error) {
+	f, err := ioutil.TempFile(tmpdir, "exclude-")
+	if err != nil {
+		return "", fmt.Errorf("while creating exclude file: %s", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, l := range lines {
+		fmt.Fprintln(w, l)
+	}
+	if err := w.Flush(); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("while writing exclude file: %s", err)
+	}
+	return f.Name(), nil
+}
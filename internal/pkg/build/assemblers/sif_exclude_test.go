@@ -0,0 +1,70 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package assemblers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hpcng/singularity/pkg/image/unpacker"
+)
+
+func TestExcludePatterns(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "exclude-rootfs-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(rootfs)
+
+	if err := os.MkdirAll(filepath.Join(rootfs, "proc"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %s", err)
+	}
+	if err := os.MkdirAll(filepath.Join(rootfs, "etc"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(rootfs, "etc/hosts"), nil, 0644); err != nil {
+		t.Fatalf("failed to create file: %s", err)
+	}
+
+	got := excludePatterns(rootfs, []string{"/", "/proc", "/etc/hosts", "/data[1]"})
+	want := []string{"proc/*", "proc/.*", "etc/hosts", `data\[1\]`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got patterns %v instead of %v", got, want)
+	}
+}
+
+func TestComputeDeltaExclude(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "delta-rootfs-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(rootfs)
+
+	if err := os.MkdirAll(filepath.Join(rootfs, "proc/1"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(rootfs, "hosts"), []byte("host"), 0644); err != nil {
+		t.Fatalf("failed to create file: %s", err)
+	}
+
+	entries := []unpacker.Entry{
+		{Path: "/", Mode: "drwxr-xr-x"},
+		{Path: "/proc", Mode: "drwxr-xr-x"},
+		{Path: "/proc/hidden", Mode: "-rw-r--r--"},
+		{Path: "/hosts", Mode: "-rw-r--r--"},
+	}
+
+	delta, err := computeDelta(rootfs, entries, true, []string{"/proc", "/hosts"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if delta.changed != 0 || len(delta.unchanged) != 0 || len(delta.deleted) != 0 {
+		t.Errorf("unexpected delta of excluded paths: %+v", delta)
+	}
+}
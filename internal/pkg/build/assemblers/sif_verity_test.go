@@ -0,0 +1,92 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package assemblers
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcng/sif/pkg/sif"
+	"github.com/hpcng/singularity/pkg/build/types"
+	"github.com/hpcng/singularity/pkg/util/verity"
+)
+
+func TestSIFAssemblerVerity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verity-assembler-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	b, err := types.NewBundle(dir, dir)
+	if err != nil {
+		t.Fatalf("failed to create bundle: %s", err)
+	}
+	defer b.Remove()
+
+	b.Recipe.Raw = []byte("bootstrap: scratch\n")
+
+	if err := ioutil.WriteFile(filepath.Join(b.RootfsPath, "file"), []byte("content"), 0644); err != nil {
+		t.Fatalf("failed to create file: %s", err)
+	}
+
+	dest := filepath.Join(dir, "image.sif")
+	a := &SIFAssembler{Packer: "builtin", Verity: true}
+	if err := a.Assemble(b, dest); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	fimg, err := sif.LoadContainer(dest, true)
+	if err != nil {
+		t.Fatalf("failed to load SIF image: %s", err)
+	}
+	defer fimg.UnloadContainer()
+
+	primary, _, err := fimg.GetPartPrimSys()
+	if err != nil {
+		t.Fatalf("failed to get primary partition: %s", err)
+	}
+	if primary.Filelen%verity.BlockSize != 0 {
+		t.Errorf("primary partition size %d is not a multiple of %d", primary.Filelen, verity.BlockSize)
+	}
+
+	var hash *sif.Descriptor
+	for i, d := range fimg.DescrArr {
+		if d.Used && d.Datatype == sif.DataGeneric && d.GetName() == verity.DescriptorName {
+			hash = &fimg.DescrArr[i]
+		}
+	}
+	if hash == nil {
+		t.Fatalf("no dm-verity descriptor found")
+	}
+	if hash.Link != primary.ID {
+		t.Errorf("dm-verity descriptor is linked to %d instead of %d", hash.Link, primary.ID)
+	}
+
+	params, err := verity.ReadParams(fimg.Fp, hash.Fileoff)
+	if err != nil {
+		t.Fatalf("failed to read dm-verity parameters: %s", err)
+	}
+	if params.DataSize() != uint64(primary.Filelen) {
+		t.Errorf("hash tree covers %d bytes instead of %d", params.DataSize(), primary.Filelen)
+	}
+
+	salt, err := hex.DecodeString(params.Salt)
+	if err != nil {
+		t.Fatalf("failed to decode salt: %s", err)
+	}
+	expected, _, err := verity.CreateHashTree(bytes.NewReader(primary.GetData(&fimg)), primary.Filelen, salt)
+	if err != nil {
+		t.Fatalf("failed to compute hash tree: %s", err)
+	}
+	if expected.RootHash != params.RootHash {
+		t.Errorf("unexpected root hash %s instead of %s", params.RootHash, expected.RootHash)
+	}
+}
@@ -22,6 +22,7 @@ import (
 
 	"github.com/hpcng/singularity/internal/pkg/build/apps"
 	"github.com/hpcng/singularity/internal/pkg/build/assemblers"
+	"github.com/hpcng/singularity/internal/pkg/build/buildlog"
 	"github.com/hpcng/singularity/internal/pkg/build/sources"
 	"github.com/hpcng/singularity/internal/pkg/util/fs/squashfs"
 	"github.com/hpcng/singularity/internal/pkg/util/uri"
@@ -43,6 +44,8 @@ type Build struct {
 	stages []stage
 	// Conf contains cross stage build configuration.
 	Conf Config
+	// checkpoints of the stages, nil unless resuming.
+	checkpoints *checkpoints
 }
 
 // Config defines how build is executed, including things like where final image is written.
@@ -54,6 +57,29 @@ type Config struct {
 	// NoCleanUp allows a user to prevent a bundle from being cleaned
 	// up after a failed build, useful for debugging.
 	NoCleanUp bool
+	// DeltaFrom is the path of a SIF image the root filesystem is compared
+	// against, only changes are packed into a SIF overlay partition.
+	DeltaFrom string
+	// Verity adds a dm-verity hash tree of the root filesystem partition
+	// to the SIF image.
+	Verity bool
+	// SquashfsPacker overrides the squashfs packer set in singularity.conf,
+	// it's either mksquashfs, tar2sqfs, builtin or the path to mksquashfs
+	// or tar2sqfs binaries.
+	SquashfsPacker string
+	// MksquashfsProcs overrides the number of processors used to create
+	// the squashfs image when not zero.
+	MksquashfsProcs uint
+	// MksquashfsMem overrides the memory limit used to create the squashfs
+	// image when not empty.
+	MksquashfsMem string
+	// BuildLog records the timestamped output of the build sections in
+	// the image.
+	BuildLog bool
+	// Resume checkpoints the stages after each section and resumes a
+	// previous failed build of the same definition from its last completed
+	// section.
+	Resume bool
 	// Opts for bundles.
 	Opts types.Options
 }
@@ -170,6 +196,9 @@ func newBuild(defs []types.Definition, conf Config) (*Build, error) {
 		}
 
 		s.b.Opts = conf.Opts
+		if err := checkTargetArch(d, s.b.TargetArch()); err != nil {
+			return nil, err
+		}
 		// dont need to get cp if we're skipping bootstrap
 		if !conf.Opts.Update || conf.Opts.Force {
 			if c, err := conveyorPacker(d); err == nil {
@@ -182,39 +211,125 @@ func newBuild(defs []types.Definition, conf Config) (*Build, error) {
 		b.stages = append(b.stages, s)
 	}
 
+	if conf.Resume && conf.Opts.Update {
+		return nil, fmt.Errorf("resuming a build is not supported when updating an existing container")
+	}
+	if conf.DeltaFrom != "" && conf.Format != "sif" {
+		return nil, fmt.Errorf("delta repack requires a SIF output format")
+	}
+	if conf.Verity {
+		if conf.Format != "sif" {
+			return nil, fmt.Errorf("dm-verity hash tree requires a SIF output format")
+		}
+		if conf.DeltaFrom != "" {
+			return nil, fmt.Errorf("dm-verity hash tree is not supported with delta repack")
+		}
+		if conf.Opts.EncryptionKeyInfo != nil {
+			return nil, fmt.Errorf("dm-verity hash tree is not supported with encrypted images")
+		}
+	}
+
 	// only need an assembler for last stage
 	switch conf.Format {
 	case "sandbox":
 		b.stages[lastStageIndex].a = &assemblers.SandboxAssembler{Copy: sandboxCopy}
 	case "sif":
-		mksquashfsPath, err := squashfs.GetPath()
+		a, err := newSIFAssembler(b.stages[lastStageIndex].b.TmpDir, conf)
 		if err != nil {
-			return nil, fmt.Errorf("while searching for mksquashfs: %v", err)
+			return nil, err
 		}
+		b.stages[lastStageIndex].a = a
+	default:
+		return nil, fmt.Errorf("unrecognized output format %s", conf.Format)
+	}
 
-		flag, err := ensureGzipComp(b.stages[lastStageIndex].b.TmpDir, mksquashfsPath)
-		if err != nil {
-			return nil, fmt.Errorf("while ensuring correct compression algorithm: %v", err)
+	return b, nil
+}
+
+// newSIFAssembler returns a SIF assembler configured from singularity.conf,
+// the squashfs packer and resource limits are overridden by the build
+// configuration when set.
+func newSIFAssembler(tmpDir string, conf Config) (*assemblers.SIFAssembler, error) {
+	mksquashfsProcs, err := squashfs.GetProcs()
+	if err != nil {
+		return nil, fmt.Errorf("while searching for mksquashfs processor limits: %v", err)
+	}
+	if conf.MksquashfsProcs != 0 {
+		mksquashfsProcs = conf.MksquashfsProcs
+	}
+	mksquashfsMem, err := squashfs.GetMem()
+	if err != nil {
+		return nil, fmt.Errorf("while searching for mksquashfs mem limits: %v", err)
+	}
+	if conf.MksquashfsMem != "" {
+		mksquashfsMem = conf.MksquashfsMem
+	}
+
+	packerName, err := squashfs.GetPacker()
+	if err != nil {
+		return nil, fmt.Errorf("while searching for squashfs packer: %v", err)
+	}
+	packerPath := ""
+	if conf.SquashfsPacker != "" {
+		packerName = conf.SquashfsPacker
+		if filepath.IsAbs(packerName) {
+			packerPath = packerName
+			packerName = filepath.Base(packerName)
 		}
-		mksquashfsProcs, err := squashfs.GetProcs()
-		if err != nil {
-			return nil, fmt.Errorf("while searching for mksquashfs processor limits: %v", err)
+	}
+
+	a := &assemblers.SIFAssembler{
+		MksquashfsProcs: mksquashfsProcs,
+		MksquashfsMem:   mksquashfsMem,
+		DeltaFrom:       conf.DeltaFrom,
+		Verity:          conf.Verity,
+	}
+
+	switch {
+	case strings.HasPrefix(packerName, "tar2sqfs"):
+		if conf.DeltaFrom != "" {
+			return nil, fmt.Errorf("delta repack requires mksquashfs as squashfs packer")
 		}
-		mksquashfsMem, err := squashfs.GetMem()
-		if err != nil {
-			return nil, fmt.Errorf("while searching for mksquashfs mem limits: %v", err)
+		if packerPath == "" {
+			packerPath, err = squashfs.GetTar2sqfsPath()
+			if err != nil {
+				return nil, fmt.Errorf("while searching for tar2sqfs: %v", err)
+			}
+		}
+		a.Packer = "tar2sqfs"
+		a.Tar2sqfsPath = packerPath
+	case packerName == "builtin":
+		if conf.DeltaFrom != "" {
+			return nil, fmt.Errorf("delta repack requires mksquashfs as squashfs packer")
 		}
-		b.stages[lastStageIndex].a = &assemblers.SIFAssembler{
-			GzipFlag:        flag,
-			MksquashfsProcs: mksquashfsProcs,
-			MksquashfsMem:   mksquashfsMem,
-			MksquashfsPath:  mksquashfsPath,
+		a.Packer = "builtin"
+		packerPath = "builtin squashfs writer"
+	case strings.HasPrefix(packerName, "mksquashfs"):
+		if packerPath == "" {
+			packerPath, err = squashfs.GetPath()
+			// fallback to the builtin squashfs writer unless mksquashfs
+			// is required or was explicitly requested
+			if err != nil && conf.DeltaFrom == "" && conf.SquashfsPacker == "" {
+				sylog.Warningf("mksquashfs not found, using builtin squashfs writer")
+				a.Packer = "builtin"
+				return a, nil
+			} else if err != nil {
+				return nil, fmt.Errorf("while searching for mksquashfs: %v", err)
+			}
 		}
+		a.GzipFlag, err = ensureGzipComp(tmpDir, packerPath)
+		if err != nil {
+			return nil, fmt.Errorf("while ensuring correct compression algorithm: %v", err)
+		}
+		a.Packer = "mksquashfs"
+		a.MksquashfsPath = packerPath
 	default:
-		return nil, fmt.Errorf("unrecognized output format %s", conf.Format)
+		return nil, fmt.Errorf("unsupported squashfs packer %s", conf.SquashfsPacker)
 	}
 
-	return b, nil
+	sylog.Debugf("Using %s to create squashfs image", packerPath)
+
+	return a, nil
 }
 
 // ensureGzipComp builds dummy squashfs images and checks the type of compression used
@@ -314,6 +429,7 @@ func (b Build) cleanUp() {
 		return
 	}
 
+	b.checkpoints.unmount()
 	for _, s := range b.stages {
 		sylog.Debugf("Cleaning up %q and %q", s.b.RootfsPath, s.b.TmpDir)
 		err := s.b.Remove()
@@ -357,38 +473,51 @@ func (b *Build) Full(ctx context.Context) error {
 	}
 	configData := buffer.Bytes()
 
+	last := &b.stages[len(b.stages)-1]
+	if b.Conf.BuildLog {
+		l, err := buildlog.New(filepath.Join(last.b.TmpDir, "build-log.gz"))
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+		for i := range b.stages {
+			b.stages[i].log = l
+		}
+		last.logf("Build of %s started", b.Conf.Dest)
+	}
+
+	if b.Conf.Resume {
+		defs := make([]types.Definition, 0, len(b.stages))
+		for _, stage := range b.stages {
+			defs = append(defs, stage.b.Recipe)
+		}
+		// the checkpoints are kept next to the build temporary directory
+		// which is removed once the build completes or fails
+		tmpDir := filepath.Dir(b.Conf.Opts.TmpDir)
+		if b.checkpoints, err = newCheckpoints(tmpDir, b.Conf.Dest, defs); err != nil {
+			return err
+		}
+	}
+
 	// build each stage one after the other
 	for i, stage := range b.stages {
-		if err := stage.runSectionScript("pre", stage.b.Recipe.BuildData.Pre); err != nil {
+		done, err := b.checkpoints.restore(i, stage.b)
+		if err != nil {
 			return err
 		}
+		if done != checkpointNone {
+			stage.logf("Resuming after the %s section", checkpointNames[done])
+		}
 
-		// only update last stage if specified
-		update := stage.b.Opts.Update && !stage.b.Opts.Force && i == len(b.stages)-1
-		if update {
-			// updating, extract dest container to bundle
-			sylog.Infof("Building into existing container: %s", b.Conf.Dest)
-			p, err := sources.GetLocalPacker(ctx, b.Conf.Dest, stage.b)
-			if err != nil {
+		if done < checkpointBootstrap {
+			if err := stage.bootstrap(ctx, b, i); err != nil {
 				return err
 			}
-
-			_, err = p.Pack(ctx)
-			if err != nil {
+			if err := stage.checkDisk(); err != nil {
 				return err
 			}
-		} else {
-			// regular build or force, start build from scratch
-			if b.Conf.Opts.ImgCache == nil {
-				return fmt.Errorf("undefined image cache")
-			}
-			if err := stage.c.Get(ctx, stage.b); err != nil {
-				return fmt.Errorf("conveyor failed to get: %v", err)
-			}
-
-			_, err := stage.c.Pack(ctx)
-			if err != nil {
-				return fmt.Errorf("packer failed to pack: %v", err)
+			if err := b.checkpoints.save(i, checkpointBootstrap, stage.b); err != nil {
+				return err
 			}
 		}
 
@@ -398,31 +527,44 @@ func (b *Build) Full(ctx context.Context) error {
 			a.HandleSection(k, v)
 		}
 
-		a.HandleBundle(stage.b)
 		appPost, err := a.HandlePost(stage.b)
 		if err != nil {
 			return fmt.Errorf("unable to get app post information: %v", err)
 		}
 		stage.b.Recipe.BuildData.Post.Script += appPost
 
-		// copy potential files from previous stage
-		if stage.b.RunSection("files") {
-			if err := stage.copyFilesFrom(b); err != nil {
-				return fmt.Errorf("unable to copy files from stage to container fs: %v", err)
+		if done < checkpointSetup {
+			a.HandleBundle(stage.b)
+
+			// copy potential files from previous stage
+			if stage.b.RunSection("files") {
+				if err := stage.copyFilesFrom(b); err != nil {
+					return fmt.Errorf("unable to copy files from stage to container fs: %v", err)
+				}
 			}
-		}
 
-		if err := stage.runSectionScript("setup", stage.b.Recipe.BuildData.Setup); err != nil {
-			return err
-		}
+			if err := stage.runSectionScript("setup", stage.b.Recipe.BuildData.Setup); err != nil {
+				return err
+			}
 
-		// copy files from host
-		if stage.b.RunSection("files") {
-			if err := stage.copyFiles(); err != nil {
-				return fmt.Errorf("unable to copy files from host to container fs: %v", err)
+			// copy files from host
+			if stage.b.RunSection("files") {
+				if err := stage.copyFiles(); err != nil {
+					return fmt.Errorf("unable to copy files from host to container fs: %v", err)
+				}
+			}
+			if err := stage.checkDisk(); err != nil {
+				return err
+			}
+			if err := b.checkpoints.save(i, checkpointSetup, stage.b); err != nil {
+				return err
 			}
 		}
 
+		if done >= checkpointDone {
+			continue
+		}
+
 		// create stage file for /etc/resolv.conf and /etc/hosts
 		sessionResolv, err := createStageFile("/etc/resolv.conf", stage.b, "Name resolution could fail")
 		if err != nil {
@@ -444,10 +586,20 @@ func (b *Build) Full(ctx context.Context) error {
 		}
 		defer os.Remove(configFile)
 
-		if stage.b.Recipe.BuildData.Post.Script != "" {
+		if cgroupsFile := stage.cgroupsFile(); cgroupsFile != "" {
+			if err := writeCgroupsConfig(stage.b.Opts.Limits, cgroupsFile); err != nil {
+				return err
+			}
+			defer os.Remove(cgroupsFile)
+		}
+
+		if done < checkpointPost && stage.b.Recipe.BuildData.Post.Script != "" {
 			if err := stage.runPostScript(configFile, sessionResolv, sessionHosts); err != nil {
 				return fmt.Errorf("while running engine: %v", err)
 			}
+			if err := b.checkpoints.save(i, checkpointPost, stage.b); err != nil {
+				return err
+			}
 		}
 
 		sylog.Debugf("Inserting Metadata")
@@ -458,19 +610,74 @@ func (b *Build) Full(ctx context.Context) error {
 		if err := stage.runTestScript(configFile, sessionResolv, sessionHosts); err != nil {
 			return fmt.Errorf("failed to execute %%test script: %v", err)
 		}
+		if err := b.checkpoints.save(i, checkpointDone, stage.b); err != nil {
+			return err
+		}
 	}
 
 	syscall.Umask(oldumask)
 
+	if last.log != nil {
+		last.logf("Assembling %s image", b.Conf.Format)
+		if err := last.log.Close(); err != nil {
+			return err
+		}
+		last.b.BuildLog = last.log.Path()
+	}
+
+	if b.Conf.Format == "sandbox" {
+		if err := b.checkpoints.flatten(len(b.stages) - 1); err != nil {
+			return err
+		}
+	}
+
 	sylog.Debugf("Calling assembler")
-	if err := b.stages[len(b.stages)-1].Assemble(b.Conf.Dest); err != nil {
+	if err := last.Assemble(b.Conf.Dest); err != nil {
 		return err
 	}
+	b.checkpoints.remove()
 
 	sylog.Verbosef("Build complete: %s", b.Conf.Dest)
 	return nil
 }
 
+// bootstrap runs the %pre section of the stage i of the build b and
+// bootstraps its root filesystem.
+func (s *stage) bootstrap(ctx context.Context, b *Build, i int) error {
+	if err := s.runSectionScript("pre", s.b.Recipe.BuildData.Pre); err != nil {
+		return err
+	}
+
+	// only update last stage if specified
+	update := s.b.Opts.Update && !s.b.Opts.Force && i == len(b.stages)-1
+	if update {
+		// updating, extract dest container to bundle
+		sylog.Infof("Building into existing container: %s", b.Conf.Dest)
+		s.logf("Building into existing container %s", b.Conf.Dest)
+		p, err := sources.GetLocalPacker(ctx, b.Conf.Dest, s.b)
+		if err != nil {
+			return err
+		}
+
+		_, err = p.Pack(ctx)
+		return err
+	}
+
+	// regular build or force, start build from scratch
+	if b.Conf.Opts.ImgCache == nil {
+		return fmt.Errorf("undefined image cache")
+	}
+	s.logf("Bootstrapping from %s %s", s.b.Recipe.Header["bootstrap"], s.b.Recipe.Header["from"])
+	if err := s.c.Get(ctx, s.b); err != nil {
+		return fmt.Errorf("conveyor failed to get: %v", err)
+	}
+
+	if _, err := s.c.Pack(ctx); err != nil {
+		return fmt.Errorf("packer failed to pack: %v", err)
+	}
+	return nil
+}
+
 // makeDef gets a definition object from a spec.
 func makeDef(spec string) (types.Definition, error) {
 	if ok, err := uri.IsValid(spec); ok && err == nil {
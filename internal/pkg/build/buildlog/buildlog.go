@@ -0,0 +1,143 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package buildlog records the output of the sections of a build, timestamped
+// line by line, in a gzip compressed log stored in the image.
+package buildlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DescriptorName is the name of the SIF descriptor holding the
+	// build log.
+	DescriptorName = "build-log.gz"
+	// SandboxPath is the path of the build log in a sandbox image.
+	SandboxPath = ".singularity.d/build-log.gz"
+)
+
+// timeFormat is the format of the timestamp prefixing every line.
+const timeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// Log is a gzip compressed build log.
+type Log struct {
+	mu   sync.Mutex
+	f    *os.File
+	gz   *gzip.Writer
+	err  error
+	now  func() time.Time
+	path string
+}
+
+// New creates the build log at path.
+func New(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("while creating build log: %s", err)
+	}
+	return &Log{
+		f:    f,
+		gz:   gzip.NewWriter(f),
+		now:  time.Now,
+		path: path,
+	}, nil
+}
+
+// Path returns the path of the build log.
+func (l *Log) Path() string {
+	return l.path
+}
+
+// writeLine writes the line with the source tag, a write error is
+// kept and returned by Close so that the build isn't interrupted.
+func (l *Log) writeLine(tag string, line []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil || l.err != nil {
+		return
+	}
+	_, l.err = fmt.Fprintf(l.gz, "%s [%s] %s\n", l.now().UTC().Format(timeFormat), tag, line)
+}
+
+// Printf records a build event with the source tag.
+func (l *Log) Printf(tag, format string, a ...interface{}) {
+	l.writeLine(tag, bytes.TrimRight([]byte(fmt.Sprintf(format, a...)), "\n"))
+}
+
+// Section returns a writer recording the output of a section of a stage,
+// each line being tagged with stage/section, or with section only for
+// unnamed stages. The returned writer must be closed to record an
+// unterminated last line.
+func (l *Log) Section(stage, section string) io.WriteCloser {
+	tag := section
+	if stage != "" {
+		tag = stage + "/" + section
+	}
+	return &sectionWriter{l: l, tag: tag}
+}
+
+// Close completes the build log, it can be called more than once.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return nil
+	}
+	err := l.gz.Close()
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	l.f = nil
+	if l.err != nil {
+		return fmt.Errorf("while writing build log: %s", l.err)
+	} else if err != nil {
+		return fmt.Errorf("while writing build log: %s", err)
+	}
+	return nil
+}
+
+// sectionWriter splits the output of a section into lines.
+type sectionWriter struct {
+	mu  sync.Mutex
+	l   *Log
+	tag string
+	buf []byte
+}
+
+func (w *sectionWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.l.writeLine(w.tag, bytes.TrimRight(w.buf[:i], "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *sectionWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.l.writeLine(w.tag, w.buf)
+		w.buf = nil
+	}
+	return nil
+}
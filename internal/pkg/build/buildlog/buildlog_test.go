@@ -0,0 +1,75 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildlog
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "buildlog-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "build-log.gz")
+	l, err := New(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	l.now = func() time.Time { return time.Date(2021, 5, 4, 10, 0, 0, 0, time.UTC) }
+
+	l.Printf("build", "Bootstrapping from %s %s", "docker", "alpine")
+	w := l.Section("", "post")
+	io.WriteString(w, "first\r\nsec")
+	io.WriteString(w, "ond\nlast")
+	w.Close()
+	w = l.Section("devel", "test")
+	io.WriteString(w, "ok\n")
+	w.Close()
+
+	if err := l.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// writes after close are ignored
+	l.Printf("build", "ignored")
+	if err := l.Close(); err != nil {
+		t.Fatalf("unexpected error on second close: %s", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open build log: %s", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("failed to read compressed build log: %s", err)
+	}
+	b, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("failed to read build log: %s", err)
+	}
+
+	ts := "2021-05-04T10:00:00.000Z"
+	want := fmt.Sprintf(`%[1]s [build] Bootstrapping from docker alpine
+%[1]s [post] first
+%[1]s [post] second
+%[1]s [post] last
+%[1]s [devel/test] ok
+`, ts)
+	if string(b) != want {
+		t.Errorf("got build log:\n%s\nwant:\n%s", b, want)
+	}
+}
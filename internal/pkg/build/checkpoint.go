@@ -0,0 +1,471 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	"github.com/hpcng/singularity/pkg/build/types"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/util/namespaces"
+)
+
+// The sections a stage is checkpointed after, in build order.
+const (
+	checkpointNone = iota
+	// checkpointBootstrap follows the %pre section and the bootstrap.
+	checkpointBootstrap
+	// checkpointSetup follows the apps creation, the %setup and %files
+	// sections.
+	checkpointSetup
+	// checkpointPost follows the %post section.
+	checkpointPost
+	// checkpointDone follows the metadata insertion and the %test section.
+	checkpointDone
+)
+
+var checkpointNames = map[int]string{
+	checkpointBootstrap: "bootstrap",
+	checkpointSetup:     "%setup and %files",
+	checkpointPost:      "%post",
+	checkpointDone:      "%test",
+}
+
+// checkpointPrefix prefixes the directory holding the checkpoints of
+// a user in the temporary directory.
+const checkpointPrefix = "singularity-checkpoints-"
+
+// checkpointState is the state of a stage checkpoint.
+type checkpointState struct {
+	// Section is the last section completed.
+	Section int `json:"section"`
+	// Layers is the number of overlay layers of the snapshot, 0 when
+	// the snapshot is a copy of the root filesystem.
+	Layers int `json:"layers,omitempty"`
+	// JSONObjects are the JSON objects of the bundle set by the
+	// bootstrap.
+	JSONObjects map[string][]byte `json:"jsonObjects"`
+}
+
+// checkpoints holds the snapshots of the stage root filesystems of a build
+// taken after each section, to resume the build from the last completed
+// section. When overlay mounts are supported, the root filesystem of a stage
+// is an overlay of the snapshot layers and a snapshot only keeps the changes
+// made by a section. Otherwise the snapshots are copies of the root
+// filesystems, made with reflinks when supported by the filesystem.
+type checkpoints struct {
+	dir     string
+	overlay bool
+
+	// mutex protects mounts and layers from the build
+	// clean up on termination signals.
+	mutex sync.Mutex
+	// mounts are the root filesystems mounted as overlays
+	// of their snapshot layers, by stage.
+	mounts map[int]string
+	// layers are the number of snapshot layers of the
+	// mounted root filesystems, by stage.
+	layers map[int]int
+}
+
+// checkpointRoot returns the directory holding the checkpoints of the user
+// in tmpDir, created if necessary. A directory which could be tampered with
+// by other users is rejected.
+func checkpointRoot(tmpDir string) (string, error) {
+	// named by the host user to share the checkpoints with fakeroot builds
+	uid, err := namespaces.HostUID()
+	if err != nil {
+		return "", fmt.Errorf("while getting host user ID: %s", err)
+	}
+	dir := filepath.Join(tmpDir, checkpointPrefix+strconv.Itoa(uid))
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return "", fmt.Errorf("while creating checkpoint directory: %s", err)
+	}
+	if err := checkCheckpointRoot(dir, os.Getuid()); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// checkCheckpointRoot checks that dir is a directory owned by uid
+// and not accessible by other users.
+func checkCheckpointRoot(dir string, uid int) error {
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("while checking checkpoint directory: %s", err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("checkpoint directory %s is not a directory", dir)
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); !ok || int(st.Uid) != uid {
+		return fmt.Errorf("checkpoint directory %s is not owned by the current user", dir)
+	}
+	if fi.Mode().Perm()&077 != 0 {
+		return fmt.Errorf("checkpoint directory %s is accessible by other users", dir)
+	}
+	return nil
+}
+
+// newCheckpoints returns the checkpoints of the build of the definitions defs
+// to dest, stored in the checkpoint directory of the user in tmpDir. The
+// checkpoints of a previous build of dest are discarded if the definitions
+// changed.
+func newCheckpoints(tmpDir, dest string, defs []types.Definition) (*checkpoints, error) {
+	root, err := checkpointRoot(tmpDir)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(dest))
+	destKey := hex.EncodeToString(sum[:8])
+	h := sha256.New()
+	h.Write([]byte(dest))
+	for _, d := range defs {
+		h.Write(d.Raw)
+	}
+	key := destKey + "-" + hex.EncodeToString(h.Sum(nil)[:8])
+
+	prev, err := filepath.Glob(filepath.Join(root, destKey+"-*"))
+	if err != nil {
+		return nil, fmt.Errorf("while looking for previous checkpoints: %s", err)
+	}
+	for _, dir := range prev {
+		if filepath.Base(dir) == key {
+			continue
+		}
+		sylog.Warningf("Definition changed since the last build of %s, discarding checkpoints", dest)
+		if err := os.RemoveAll(dir); err != nil {
+			return nil, fmt.Errorf("while removing checkpoints: %s", err)
+		}
+	}
+
+	c := &checkpoints{
+		dir:    filepath.Join(root, key),
+		mounts: make(map[int]string),
+		layers: make(map[int]int),
+	}
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return nil, fmt.Errorf("while creating checkpoint directory: %s", err)
+	}
+	c.overlay = c.probeOverlay()
+	return c, nil
+}
+
+// probeOverlay returns whether overlays with upper and lower directories
+// in the checkpoint directory can be mounted.
+func (c *checkpoints) probeOverlay() bool {
+	// the overlay options can't hold these characters
+	if strings.ContainsAny(c.dir, ",:") {
+		return false
+	}
+	dir, err := ioutil.TempDir(c.dir, "probe-")
+	if err != nil {
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"lower", "upper", "work", "merged"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0700); err != nil {
+			return false
+		}
+	}
+	opts := fmt.Sprintf("lowerdir=%s/lower,upperdir=%s/upper,workdir=%s/work", dir, dir, dir)
+	merged := filepath.Join(dir, "merged")
+	if err := syscall.Mount("overlay", merged, "overlay", 0, opts); err != nil {
+		sylog.Debugf("Overlay not supported for build checkpoints: %s", err)
+		return false
+	}
+	syscall.Unmount(merged, syscall.MNT_DETACH)
+	return true
+}
+
+// stageDir returns the checkpoint directory of the stage i.
+func (c *checkpoints) stageDir(i int) string {
+	return filepath.Join(c.dir, "stage-"+strconv.Itoa(i))
+}
+
+// layerDir returns the directory of the snapshot layer n of the stage i.
+func (c *checkpoints) layerDir(i, n int) string {
+	return filepath.Join(c.stageDir(i), "layers", strconv.Itoa(n))
+}
+
+// restore restores the root filesystem and the JSON objects of the bundle b
+// of stage i from its checkpoint, and returns the last section completed.
+func (c *checkpoints) restore(i int, b *types.Bundle) (int, error) {
+	if c == nil {
+		return checkpointNone, nil
+	}
+
+	dir := c.stageDir(i)
+	data, err := ioutil.ReadFile(filepath.Join(dir, "state.json"))
+	if os.IsNotExist(err) {
+		return checkpointNone, nil
+	} else if err != nil {
+		return checkpointNone, fmt.Errorf("while reading checkpoint state: %s", err)
+	}
+	var state checkpointState
+	if err := json.Unmarshal(data, &state); err != nil {
+		sylog.Warningf("Ignoring invalid checkpoint of stage %d: %s", i+1, err)
+		return checkpointNone, nil
+	}
+	if _, ok := checkpointNames[state.Section]; !ok || state.Layers < 0 {
+		sylog.Warningf("Ignoring invalid checkpoint of stage %d", i+1)
+		return checkpointNone, nil
+	}
+	if state.Layers > 0 && !c.overlay {
+		sylog.Warningf("Ignoring checkpoint of stage %d: overlay not supported", i+1)
+		return checkpointNone, nil
+	}
+
+	sylog.Infof("Resuming stage %d after the %s section", i+1, checkpointNames[state.Section])
+	if err := os.RemoveAll(b.RootfsPath); err != nil {
+		return checkpointNone, fmt.Errorf("while removing root filesystem: %s", err)
+	}
+	if state.Layers > 0 {
+		if err := c.mountLayers(i, b.RootfsPath, state.Layers); err != nil {
+			return checkpointNone, fmt.Errorf("while restoring checkpoint: %s", err)
+		}
+	} else if err := fs.CopyTree(filepath.Join(dir, "rootfs"), b.RootfsPath); err != nil {
+		return checkpointNone, fmt.Errorf("while restoring checkpoint: %s", err)
+	}
+	for name, obj := range state.JSONObjects {
+		b.JSONObjects[name] = obj
+	}
+	return state.Section, nil
+}
+
+// save replaces the checkpoint of stage i by a snapshot of the root
+// filesystem of its bundle b after section.
+func (c *checkpoints) save(i, section int, b *types.Bundle) error {
+	if c == nil {
+		return nil
+	}
+
+	sylog.Verbosef("Checkpointing stage %d after the %s section", i+1, checkpointNames[section])
+	dir := c.stageDir(i)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("while creating checkpoint directory: %s", err)
+	}
+
+	state := checkpointState{Section: section, JSONObjects: b.JSONObjects}
+	if c.overlay {
+		n, err := c.saveLayer(i, b.RootfsPath)
+		if err != nil {
+			return fmt.Errorf("while checkpointing root filesystem: %s", err)
+		}
+		state.Layers = n
+	} else if err := c.saveCopy(i, b.RootfsPath); err != nil {
+		return fmt.Errorf("while checkpointing root filesystem: %s", err)
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("while encoding checkpoint state: %s", err)
+	}
+	// the state is replaced at once, a checkpoint interrupted before
+	// refers to the previous snapshot
+	tmp := filepath.Join(dir, "state.json.tmp")
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("while writing checkpoint state: %s", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "state.json")); err != nil {
+		return fmt.Errorf("while writing checkpoint state: %s", err)
+	}
+	return nil
+}
+
+// saveCopy replaces the snapshot of stage i by a copy of rootfs.
+func (c *checkpoints) saveCopy(i int, rootfs string) error {
+	dir := c.stageDir(i)
+
+	// the previous snapshot is kept until the new one is complete
+	tmp := filepath.Join(dir, "rootfs.tmp")
+	if err := os.RemoveAll(tmp); err != nil {
+		return fmt.Errorf("while removing incomplete checkpoint: %s", err)
+	}
+	if err := fs.CopyTree(rootfs, tmp); err != nil {
+		return err
+	}
+	snapshot := filepath.Join(dir, "rootfs")
+	if err := os.RemoveAll(snapshot); err != nil {
+		return fmt.Errorf("while removing previous checkpoint: %s", err)
+	}
+	return os.Rename(tmp, snapshot)
+}
+
+// saveLayer adds a snapshot layer to stage i and mounts rootfs as an overlay
+// of the snapshot layers, and returns the number of layers. The first layer
+// is the root filesystem moved to the checkpoint directory, the next ones
+// are the upper directories of the overlay holding the changes made by each
+// section.
+func (c *checkpoints) saveLayer(i int, rootfs string) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	n := c.layers[i]
+	layer := c.layerDir(i, n)
+	// left by a checkpoint interrupted before its state was written
+	if err := os.RemoveAll(layer); err != nil {
+		return 0, fmt.Errorf("while removing incomplete checkpoint: %s", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(layer), 0700); err != nil {
+		return 0, fmt.Errorf("while creating checkpoint directory: %s", err)
+	}
+
+	if _, ok := c.mounts[i]; ok {
+		if err := syscall.Unmount(rootfs, 0); err != nil {
+			return 0, fmt.Errorf("while unmounting %s: %s", rootfs, err)
+		}
+		delete(c.mounts, i)
+		if err := os.Rename(filepath.Join(c.stageDir(i), "upper"), layer); err != nil {
+			return 0, err
+		}
+	} else if err := os.RemoveAll(filepath.Join(c.stageDir(i), "rootfs")); err != nil {
+		// snapshot copy left by a build without overlay support
+		return 0, fmt.Errorf("while removing previous checkpoint: %s", err)
+	} else if err := os.Rename(rootfs, layer); errors.Is(err, syscall.EXDEV) {
+		if err := fs.CopyTree(rootfs, layer); err != nil {
+			return 0, err
+		}
+		if err := os.RemoveAll(rootfs); err != nil {
+			return 0, fmt.Errorf("while removing root filesystem: %s", err)
+		}
+	} else if err != nil {
+		return 0, err
+	}
+
+	if err := c.mount(i, rootfs, n+1); err != nil {
+		return 0, err
+	}
+	return n + 1, nil
+}
+
+// mountLayers mounts rootfs as an overlay of the n first snapshot
+// layers of stage i.
+func (c *checkpoints) mountLayers(i int, rootfs string, n int) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.mount(i, rootfs, n)
+}
+
+// mount mounts rootfs as an overlay of the n first snapshot layers of
+// stage i, with an empty upper directory. It must be called with the
+// mutex held.
+func (c *checkpoints) mount(i int, rootfs string, n int) error {
+	dir := c.stageDir(i)
+	upper := filepath.Join(dir, "upper")
+	work := filepath.Join(dir, "work")
+	for _, d := range []string{upper, work} {
+		if err := os.RemoveAll(d); err != nil {
+			return fmt.Errorf("while removing %s: %s", d, err)
+		}
+		if err := os.Mkdir(d, 0700); err != nil {
+			return fmt.Errorf("while creating %s: %s", d, err)
+		}
+	}
+
+	// the overlay root directory has the attributes of the upper directory
+	fi, err := os.Lstat(c.layerDir(i, n-1))
+	if err != nil {
+		return fmt.Errorf("while reading snapshot layer: %s", err)
+	}
+	if err := os.Chmod(upper, fi.Mode()&(os.ModePerm|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return fmt.Errorf("while setting %s permissions: %s", upper, err)
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		if err := os.Lchown(upper, int(st.Uid), int(st.Gid)); err != nil {
+			return fmt.Errorf("while setting %s ownership: %s", upper, err)
+		}
+	}
+
+	// the lower directories are listed from the top one
+	lowers := make([]string, 0, n)
+	for k := n - 1; k >= 0; k-- {
+		lowers = append(lowers, c.layerDir(i, k))
+	}
+	if err := os.MkdirAll(rootfs, 0755); err != nil {
+		return fmt.Errorf("while creating %s: %s", rootfs, err)
+	}
+	opts := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", strings.Join(lowers, ":"), upper, work)
+	if err := syscall.Mount("overlay", rootfs, "overlay", 0, opts); err != nil {
+		return fmt.Errorf("while mounting %s: %s", rootfs, err)
+	}
+	c.mounts[i] = rootfs
+	c.layers[i] = n
+	return nil
+}
+
+// flatten replaces the root filesystem of stage i mounted from its snapshot
+// layers by a copy of it, for the root filesystem to be moved to the build
+// destination.
+func (c *checkpoints) flatten(i int) error {
+	if c == nil {
+		return nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	rootfs, ok := c.mounts[i]
+	if !ok {
+		return nil
+	}
+	tmp := rootfs + ".copy"
+	if err := fs.CopyTree(rootfs, tmp); err != nil {
+		return fmt.Errorf("while copying root filesystem: %s", err)
+	}
+	if err := syscall.Unmount(rootfs, 0); err != nil {
+		return fmt.Errorf("while unmounting %s: %s", rootfs, err)
+	}
+	delete(c.mounts, i)
+	if err := os.Remove(rootfs); err != nil {
+		return fmt.Errorf("while removing %s: %s", rootfs, err)
+	}
+	return os.Rename(tmp, rootfs)
+}
+
+// unmount unmounts the root filesystems mounted from snapshot layers, before
+// the bundles are removed.
+func (c *checkpoints) unmount() {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for i, rootfs := range c.mounts {
+		if err := syscall.Unmount(rootfs, syscall.MNT_DETACH); err != nil {
+			sylog.Warningf("Could not unmount %s: %s", rootfs, err)
+			continue
+		}
+		delete(c.mounts, i)
+	}
+}
+
+// remove removes the checkpoints once the build completed.
+func (c *checkpoints) remove() {
+	if c == nil {
+		return
+	}
+	c.unmount()
+	if err := os.RemoveAll(c.dir); err != nil {
+		sylog.Warningf("Could not remove build checkpoints %s: %s", c.dir, err)
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcng/singularity/pkg/build/types"
+)
+
+func TestCheckCheckpointRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	private := filepath.Join(dir, "private")
+	shared := filepath.Join(dir, "shared")
+	link := filepath.Join(dir, "link")
+	file := filepath.Join(dir, "file")
+	if err := os.Mkdir(private, 0700); err != nil {
+		t.Fatalf("failed to create directory: %s", err)
+	}
+	if err := os.Mkdir(shared, 0700); err != nil {
+		t.Fatalf("failed to create directory: %s", err)
+	}
+	if err := os.Chmod(shared, 0777); err != nil {
+		t.Fatalf("failed to change directory permissions: %s", err)
+	}
+	if err := os.Symlink(private, link); err != nil {
+		t.Fatalf("failed to create symlink: %s", err)
+	}
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatalf("failed to create file: %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		dir     string
+		uid     int
+		wantErr bool
+	}{
+		{"private", private, os.Getuid(), false},
+		{"other owner", private, os.Getuid() + 1, true},
+		{"shared", shared, os.Getuid(), true},
+		{"symlink", link, os.Getuid(), true},
+		{"file", file, os.Getuid(), true},
+		{"missing", filepath.Join(dir, "missing"), os.Getuid(), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkCheckpointRoot(tt.dir, tt.uid)
+			if tt.wantErr && err == nil {
+				t.Errorf("unexpected success")
+			} else if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestNewCheckpoints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	defs := []types.Definition{{Raw: []byte("bootstrap: scratch\n")}}
+	c, err := newCheckpoints(dir, "/tmp/image.sif", defs)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := os.MkdirAll(c.stageDir(0), 0700); err != nil {
+		t.Fatalf("failed to create stage directory: %s", err)
+	}
+
+	// the checkpoints of the same build are kept
+	same, err := newCheckpoints(dir, "/tmp/image.sif", defs)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if same.dir != c.dir {
+		t.Errorf("got checkpoint directory %s instead of %s", same.dir, c.dir)
+	}
+	if _, err := os.Stat(c.stageDir(0)); err != nil {
+		t.Errorf("checkpoints of the same build discarded")
+	}
+
+	// the checkpoints of another destination are kept
+	other, err := newCheckpoints(dir, "/tmp/other.sif", defs)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if other.dir == c.dir {
+		t.Errorf("same checkpoint directory for another destination")
+	}
+	if _, err := os.Stat(c.stageDir(0)); err != nil {
+		t.Errorf("checkpoints of another destination discarded")
+	}
+
+	// the checkpoints are discarded when the definitions change
+	changed, err := newCheckpoints(dir, "/tmp/image.sif", []types.Definition{{Raw: []byte("bootstrap: docker\n")}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if changed.dir == c.dir {
+		t.Errorf("same checkpoint directory for another definition")
+	}
+	if _, err := os.Stat(c.dir); !os.IsNotExist(err) {
+		t.Errorf("checkpoints of a changed definition kept")
+	}
+	if _, err := os.Stat(other.dir); err != nil {
+		t.Errorf("checkpoints of another destination discarded")
+	}
+
+	// a checkpoint directory of another user is rejected
+	root := filepath.Dir(c.dir)
+	if err := os.Chmod(root, 0755); err != nil {
+		t.Fatalf("failed to change directory permissions: %s", err)
+	}
+	if _, err := newCheckpoints(dir, "/tmp/image.sif", defs); err == nil {
+		t.Errorf("unexpected success with a checkpoint directory accessible by others")
+	}
+}
+
+func TestCheckpointsSaveRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	defs := []types.Definition{{Raw: []byte("bootstrap: scratch\n")}}
+	probe, err := newCheckpoints(dir, filepath.Join(dir, "probe.sif"), defs)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	modes := []bool{false}
+	if probe.overlay {
+		modes = append(modes, true)
+	}
+
+	for _, overlay := range modes {
+		name := "copy"
+		if overlay {
+			name = "overlay"
+		}
+		t.Run(name, func(t *testing.T) {
+			dest := filepath.Join(dir, name+".sif")
+			newBundle := func(n string) *types.Bundle {
+				b := &types.Bundle{
+					RootfsPath:  filepath.Join(dir, name+"-"+n),
+					JSONObjects: make(map[string][]byte),
+				}
+				if err := os.Mkdir(b.RootfsPath, 0755); err != nil {
+					t.Fatalf("failed to create root filesystem: %s", err)
+				}
+				return b
+			}
+			open := func() *checkpoints {
+				c, err := newCheckpoints(dir, dest, defs)
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				c.overlay = overlay
+				return c
+			}
+
+			c := open()
+			b := newBundle("first")
+			write := func(name, content string) {
+				if err := ioutil.WriteFile(filepath.Join(b.RootfsPath, name), []byte(content), 0644); err != nil {
+					t.Fatalf("failed to write file: %s", err)
+				}
+			}
+			write("removed", "bootstrap")
+			write("changed", "bootstrap")
+			b.JSONObjects["labels.json"] = []byte("{}")
+			if err := c.save(0, checkpointBootstrap, b); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if err := os.Remove(filepath.Join(b.RootfsPath, "removed")); err != nil {
+				t.Fatalf("failed to remove file: %s", err)
+			}
+			write("changed", "post")
+			write("added", "post")
+			if err := c.save(0, checkpointPost, b); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			// changes following the last checkpoint are not restored
+			write("added", "test")
+			c.unmount()
+
+			c = open()
+			defer c.remove()
+			restored := newBundle("second")
+			done, err := c.restore(0, restored)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if done != checkpointPost {
+				t.Errorf("got section %d instead of %d", done, checkpointPost)
+			}
+			if _, ok := restored.JSONObjects["labels.json"]; !ok {
+				t.Errorf("JSON objects not restored")
+			}
+			for file, want := range map[string]string{"changed": "post", "added": "post"} {
+				got, err := ioutil.ReadFile(filepath.Join(restored.RootfsPath, file))
+				if err != nil {
+					t.Errorf("failed to read restored file %s: %s", file, err)
+				} else if string(got) != want {
+					t.Errorf("got %q instead of %q in restored file %s", got, want, file)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(restored.RootfsPath, "removed")); !os.IsNotExist(err) {
+				t.Errorf("removed file restored")
+			}
+
+			// the root filesystem copied from the snapshot layers is kept
+			if err := c.flatten(0); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(c.mounts) != 0 {
+				t.Errorf("root filesystem still mounted")
+			}
+			if _, err := os.Stat(filepath.Join(restored.RootfsPath, "added")); err != nil {
+				t.Errorf("root filesystem content lost: %s", err)
+			}
+
+			c.remove()
+			if _, err := os.Stat(c.dir); !os.IsNotExist(err) {
+				t.Errorf("checkpoints not removed")
+			}
+		})
+	}
+}
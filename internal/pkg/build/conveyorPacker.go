@@ -8,6 +8,7 @@ package build
 import (
 	"context"
 	"fmt"
+	"runtime"
 
 	"github.com/hpcng/singularity/internal/pkg/build/sources"
 	"github.com/hpcng/singularity/pkg/build/types"
@@ -61,3 +62,18 @@ func conveyorPacker(def types.Definition) (ConveyorPacker, error) {
 		return nil, fmt.Errorf("invalid build source %s", def.Header["bootstrap"])
 	}
 }
+
+// checkTargetArch returns an error if the definition bootstrap agent
+// can't fetch the base image for the architecture arch, only agents
+// honoring the requested architecture support cross-architecture
+// builds, others would install host architecture packages.
+func checkTargetArch(def types.Definition, arch string) error {
+	if arch == runtime.GOARCH {
+		return nil
+	}
+	switch def.Header["bootstrap"] {
+	case "library", "docker", "docker-archive", "docker-daemon", "oci", "oci-archive", "debootstrap", "scratch":
+		return nil
+	}
+	return fmt.Errorf("bootstrap agent %s doesn't support building %s images on a %s host", def.Header["bootstrap"], arch, runtime.GOARCH)
+}
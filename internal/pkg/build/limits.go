@@ -0,0 +1,234 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/cgroups"
+	"github.com/hpcng/singularity/pkg/build/types"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/util/rlimit"
+)
+
+const (
+	// cpuPeriod is the CPU period in microseconds the CPU quota of the
+	// build sections is computed for.
+	cpuPeriod = 100000
+
+	// diskCheckInterval is the maximum interval between the checks of the
+	// size of the build sandbox while a section runs.
+	diskCheckInterval = 10 * time.Second
+	// diskCheckMinInterval is the minimum interval between the checks of
+	// the size of the build sandbox, used once it is close to the limit.
+	diskCheckMinInterval = 200 * time.Millisecond
+)
+
+// fsizeMutex serializes the changes of the file size limit of the build
+// process while starting the sections.
+var fsizeMutex sync.Mutex
+
+// writeCgroupsConfig writes the cgroups configuration applying the limits
+// l to path.
+func writeCgroupsConfig(l types.Limits, path string) error {
+	var config cgroups.Config
+
+	if l.CPUs > 0 {
+		period := uint64(cpuPeriod)
+		quota := int64(l.CPUs * cpuPeriod)
+		config.CPU = &cgroups.LinuxCPU{Period: &period, Quota: &quota}
+	}
+	if l.Memory > 0 {
+		limit := l.Memory
+		config.Memory = &cgroups.LinuxMemory{Limit: &limit}
+	}
+
+	if err := cgroups.PutConfig(config, path); err != nil {
+		return fmt.Errorf("while writing build cgroups configuration: %s", err)
+	}
+	return nil
+}
+
+// cgroupsFile returns the path of the cgroups configuration applied to the
+// sections of the stage, an empty path if no limit requires cgroups.
+func (s *stage) cgroupsFile() string {
+	if !s.b.Opts.Limits.Cgroups() {
+		return ""
+	}
+	return filepath.Join(s.b.TmpDir, "cgroups.toml")
+}
+
+// diskUsage returns the disk space used by the files under path, files
+// with several hard links are counted once.
+func diskUsage(path string) (int64, error) {
+	type inode struct {
+		dev uint64
+		ino uint64
+	}
+	links := make(map[inode]struct{})
+
+	var total int64
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			// files may be removed by the running section
+			return nil
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			total += info.Size()
+			return nil
+		}
+		if st.Nlink > 1 && !info.IsDir() {
+			key := inode{uint64(st.Dev), st.Ino}
+			if _, ok := links[key]; ok {
+				return nil
+			}
+			links[key] = struct{}{}
+		}
+		total += st.Blocks * 512
+		return nil
+	})
+	return total, err
+}
+
+// checkDisk returns an error if the build sandbox of the stage is larger
+// than the disk limit.
+func (s *stage) checkDisk() error {
+	_, err := s.diskHeadroom()
+	return err
+}
+
+// diskHeadroom returns the space left in the build sandbox of the stage
+// before reaching the disk limit, or an error if the limit is exceeded.
+func (s *stage) diskHeadroom() (int64, error) {
+	limit := s.b.Opts.Limits.Disk
+	if limit <= 0 {
+		return 0, nil
+	}
+	size, err := diskUsage(s.b.RootfsPath)
+	if err != nil {
+		return 0, fmt.Errorf("while computing build sandbox size: %s", err)
+	}
+	sylog.Debugf("Build sandbox size: %d bytes", size)
+	if size > limit {
+		return 0, fmt.Errorf("build sandbox size %d bytes exceeds the disk limit of %d bytes", size, limit)
+	}
+	return limit - size, nil
+}
+
+// nextDiskCheck returns the delay before the next check of the build
+// sandbox size, short enough for the headroom left not to be filled at
+// the observed write rate in bytes per second.
+func nextDiskCheck(headroom int64, rate float64) time.Duration {
+	if rate <= 0 {
+		return diskCheckInterval
+	}
+	d := time.Duration(float64(headroom) / rate / 2 * float64(time.Second))
+	if d < diskCheckMinInterval {
+		return diskCheckMinInterval
+	} else if d > diskCheckInterval {
+		return diskCheckInterval
+	}
+	return d
+}
+
+// startLimited starts the command cmd with a file size limit of headroom,
+// no file written by the section can exceed the space left by itself. The
+// limit is only raised and set on the build process while starting cmd, to
+// be inherited.
+func startLimited(cmd *exec.Cmd, headroom int64) error {
+	fsizeMutex.Lock()
+	defer fsizeMutex.Unlock()
+
+	cur, max, err := rlimit.Get("RLIMIT_FSIZE")
+	if err != nil {
+		return err
+	}
+	// one byte over the headroom for the written file to exceed the limit
+	limit := uint64(headroom) + 1
+	if limit > max {
+		limit = max
+	}
+	if limit < cur {
+		if err := rlimit.Set("RLIMIT_FSIZE", limit, max); err != nil {
+			return err
+		}
+		defer rlimit.Set("RLIMIT_FSIZE", cur, max)
+	}
+	return cmd.Start()
+}
+
+// runLimited runs the command cmd of a section, the command is killed when
+// the build sandbox exceeds the disk limit. The size of the files written is
+// bounded by the space left when the section starts, and the sandbox size is
+// checked more often as it grows closer to the limit.
+func (s *stage) runLimited(cmd *exec.Cmd) error {
+	if s.b.Opts.Limits.Disk <= 0 {
+		return cmd.Run()
+	}
+
+	headroom, err := s.diskHeadroom()
+	if err != nil {
+		return err
+	}
+
+	// run the section in its own process group to kill all its processes
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := startLimited(cmd, headroom); err != nil {
+		return err
+	}
+
+	var (
+		mu       sync.Mutex
+		exceeded error
+	)
+	done := make(chan struct{})
+	go func() {
+		var rate float64
+		last, lastTime := headroom, time.Now()
+		timer := time.NewTimer(nextDiskCheck(headroom, rate))
+		defer timer.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-timer.C:
+				h, err := s.diskHeadroom()
+				if err != nil {
+					mu.Lock()
+					exceeded = err
+					mu.Unlock()
+					syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+					return
+				}
+				now := time.Now()
+				rate = float64(last-h) / now.Sub(lastTime).Seconds()
+				last, lastTime = h, now
+				timer.Reset(nextDiskCheck(h, rate))
+			}
+		}
+	}()
+
+	err = cmd.Wait()
+	close(done)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if exceeded != nil {
+		return exceeded
+	}
+	// a section stopped by the file size limit exceeds the disk limit
+	if err := s.checkDisk(); err != nil {
+		return err
+	}
+	return err
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hpcng/singularity/pkg/build/types"
+	"github.com/hpcng/singularity/pkg/util/rlimit"
+)
+
+func TestDiskUsage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "limits-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	empty, err := diskUsage(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, make([]byte, 1<<20), 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+	size, err := diskUsage(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if size-empty < 1<<20 {
+		t.Errorf("got %d bytes instead of at least %d", size-empty, 1<<20)
+	}
+
+	// hard links are counted once
+	if err := os.Link(file, filepath.Join(dir, "link")); err != nil {
+		t.Fatalf("failed to create hard link: %s", err)
+	}
+	linked, err := diskUsage(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if linked != size {
+		t.Errorf("got %d bytes with a hard link instead of %d", linked, size)
+	}
+}
+
+func TestNextDiskCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		headroom int64
+		rate     float64
+		want     time.Duration
+	}{
+		{"no writes", 1 << 20, 0, diskCheckInterval},
+		{"slow writes", 1 << 30, 1 << 10, diskCheckInterval},
+		{"fast writes", 1 << 20, 1 << 30, diskCheckMinInterval},
+		{"half headroom", 1 << 20, 1 << 19, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextDiskCheck(tt.headroom, tt.rate); got != tt.want {
+				t.Errorf("got %s instead of %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunLimited(t *testing.T) {
+	dir, err := ioutil.TempDir("", "limits-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	const limit = 4 << 20
+	s := &stage{b: &types.Bundle{RootfsPath: dir}}
+	s.b.Opts.Limits.Disk = limit
+
+	cur, max, err := rlimit.Get("RLIMIT_FSIZE")
+	if err != nil {
+		t.Fatalf("failed to get file size limit: %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		mb      int
+		wantErr string
+	}{
+		{"below limit", 1, ""},
+		// a single file is stopped once it exceeds the space left
+		// before the sandbox size is checked
+		{"above limit", 64, "exceeds the disk limit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(dir, "file")
+			defer os.Remove(file)
+
+			cmd := exec.Command("dd", "if=/dev/zero", "of="+file, "bs=1M", "count="+strconv.Itoa(tt.mb))
+			err := s.runLimited(cmd)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			} else if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("got error %v instead of %q", err, tt.wantErr)
+			}
+			if fi, err := os.Stat(file); err == nil && fi.Size() > limit {
+				t.Errorf("file of %d bytes written above the disk limit", fi.Size())
+			}
+
+			// the file size limit of the build process is restored
+			c, m, err := rlimit.Get("RLIMIT_FSIZE")
+			if err != nil {
+				t.Fatalf("failed to get file size limit: %s", err)
+			}
+			if c != cur || m != max {
+				t.Errorf("file size limit %d:%d not restored to %d:%d", c, m, cur, max)
+			}
+		})
+	}
+}
@@ -12,7 +12,6 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"runtime"
 	"strconv"
 	"strings"
 	"time"
@@ -51,6 +50,11 @@ func (s *stage) insertMetadata() error {
 		return fmt.Errorf("while inserting startscript: %v", err)
 	}
 
+	// insert shutdownscript
+	if err := insertShutdownScript(s.b); err != nil {
+		return fmt.Errorf("while inserting shutdownscript: %v", err)
+	}
+
 	// insert runscript
 	if err := insertRunScript(s.b); err != nil {
 		return fmt.Errorf("while inserting runscript: %v", err)
@@ -142,6 +146,18 @@ func insertStartScript(b *types.Bundle) error {
 	return nil
 }
 
+func insertShutdownScript(b *types.Bundle) error {
+	if b.RunSection("shutdownscript") && b.Recipe.ImageData.Shutdownscript.Script != "" {
+		sylog.Infof("Adding shutdownscript")
+		shebang, script := handleShebangScript(b.Recipe.ImageData.Shutdownscript)
+		err := ioutil.WriteFile(filepath.Join(b.RootfsPath, "/.singularity.d/shutdownscript"), []byte(shebang+"\n\n"+script+"\n"), 0755)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func insertTestScript(b *types.Bundle) error {
 	if b.RunSection("test") && b.Recipe.ImageData.Test.Script != "" {
 		sylog.Infof("Adding testscript")
@@ -341,10 +357,11 @@ func addBuildLabels(labels map[string]string, b *types.Bundle) error {
 	}
 
 	// Architecture of build
-	// Local builds currently always use the host architecture.
-	// In remote builds this label will be applied on the builder... where the
-	// architecture should match the remote build --arch flag.
-	labels["org.label-schema.build-arch"] = runtime.GOARCH
+	// Local builds use the architecture requested with --arch, which is the
+	// host architecture unless emulation is used. In remote builds this label
+	// will be applied on the builder... where the architecture should match
+	// the remote build --arch flag.
+	labels["org.label-schema.build-arch"] = b.TargetArch()
 
 	return nil
 }
@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -12,8 +12,10 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
 	"github.com/containers/image/v5/copy"
+	"github.com/containers/image/v5/image"
 	"github.com/containers/image/v5/oci/layout"
 	"github.com/containers/image/v5/signature"
 	"github.com/containers/image/v5/transports"
@@ -25,7 +27,8 @@ import (
 
 // ImageReference wraps containers/image ImageReference type
 type ImageReference struct {
-	source types.ImageReference
+	source   types.ImageReference
+	imgCache *cache.Handle
 	types.ImageReference
 }
 
@@ -54,6 +57,7 @@ func ConvertReference(ctx context.Context, imgCache *cache.Handle, src types.Ima
 
 	return &ImageReference{
 		source:         src,
+		imgCache:       imgCache,
 		ImageReference: c,
 	}, nil
 
@@ -71,6 +75,25 @@ func (t *ImageReference) newImageSource(ctx context.Context, sys *types.SystemCo
 		return nil, err
 	}
 
+	// Make room for the image blobs before fetching them, blobs reused
+	// from the cache are not marked as used and could be evicted meanwhile
+	if t.imgCache != nil {
+		if err := t.imgCache.EnforceQuota(cache.OciBlobCacheType, time.Now()); err != nil {
+			sylog.Warningf("Could not enforce %s cache quota: %s", cache.OciBlobCacheType, err)
+		}
+	}
+
+	// Reuse the blobs of the system cache
+	if t.imgCache != nil && t.imgCache.HasSystemCache() {
+		digests, err := blobDigests(ctx, t.source, sys)
+		if err == nil {
+			err = t.imgCache.LinkSystemBlobs(digests)
+		}
+		if err != nil {
+			sylog.Warningf("Could not use the system cache blobs: %s", err)
+		}
+	}
+
 	// First we are fetching into the cache
 	_, err = copy.Image(ctx, policyCtx, t.ImageReference, t.source, &copy.Options{
 		ReportWriter: w,
@@ -79,6 +102,11 @@ func (t *ImageReference) newImageSource(ctx context.Context, sys *types.SystemCo
 	if err != nil {
 		return nil, err
 	}
+	if t.imgCache != nil {
+		if err := t.imgCache.PublishBlobs(); err != nil {
+			sylog.Warningf("Could not make the blob cache readable: %s", err)
+		}
+	}
 	return t.ImageReference.NewImageSource(ctx, sys)
 }
 
@@ -119,6 +147,27 @@ func ImageSHA(ctx context.Context, uri string, sys *types.SystemContext) (string
 	return calculateRefHash(ctx, ref, sys)
 }
 
+// blobDigests returns the digests of the config and layer blobs of
+// the source image ref.
+func blobDigests(ctx context.Context, ref types.ImageReference, sys *types.SystemContext) ([]string, error) {
+	source, err := ref.NewImageSource(ctx, sys)
+	if err != nil {
+		return nil, err
+	}
+	img, err := image.FromSource(ctx, sys, source)
+	if err != nil {
+		source.Close()
+		return nil, err
+	}
+	defer img.Close()
+
+	digests := []string{img.ConfigInfo().Digest.String()}
+	for _, l := range img.LayerInfos() {
+		digests = append(digests, l.Digest.String())
+	}
+	return digests, nil
+}
+
 func calculateRefHash(ctx context.Context, ref types.ImageReference, sys *types.SystemContext) (hash string, err error) {
 	source, err := ref.NewImageSource(ctx, sys)
 	if err != nil {
@@ -29,6 +29,8 @@ import (
 	"github.com/containers/image/v5/signature"
 	"github.com/containers/image/v5/types"
 	"github.com/hpcng/singularity/internal/pkg/build/oci"
+	"github.com/hpcng/singularity/internal/pkg/client/mirror"
+	"github.com/hpcng/singularity/internal/pkg/client/registry"
 	"github.com/hpcng/singularity/internal/pkg/util/shell"
 	sytypes "github.com/hpcng/singularity/pkg/build/types"
 	"github.com/hpcng/singularity/pkg/image"
@@ -68,6 +70,7 @@ func (cp *OCIConveyorPacker) Get(ctx context.Context, b *sytypes.Bundle) (err er
 		OCIInsecureSkipTLSVerify: cp.b.Opts.NoHTTPS,
 		DockerAuthConfig:         cp.b.Opts.DockerAuthConfig,
 		OSChoice:                 "linux",
+		ArchitectureChoice:       b.TargetArch(),
 		AuthFilePath:             syfs.DockerConf(),
 		DockerRegistryUserAgent:  useragent.Value(),
 		BigFilesTemporaryDir:     b.TmpDir,
@@ -75,6 +78,13 @@ func (cp *OCIConveyorPacker) Get(ctx context.Context, b *sytypes.Bundle) (err er
 	if cp.b.Opts.NoHTTPS {
 		cp.sysCtx.DockerInsecureSkipTLSVerify = types.NewOptionalBool(true)
 	}
+	if cp.b.Opts.RegistriesConf != "" {
+		dir, err := userRegistriesDir(cp.sysCtx, systemRegistriesDirs(), cp.b.Opts.RegistriesConf, b.TmpDir)
+		if err != nil {
+			return err
+		}
+		cp.sysCtx.SystemRegistriesConfDirPath = dir
+	}
 
 	// add registry and namespace to reference if specified
 	ref := b.Recipe.Header["from"]
@@ -88,8 +98,9 @@ func (cp *OCIConveyorPacker) Get(ctx context.Context, b *sytypes.Bundle) (err er
 
 	switch b.Recipe.Header["bootstrap"] {
 	case "docker":
-		ref = "//" + ref
-		cp.srcRef, err = docker.ParseReference(ref)
+		err = mirror.Configured().Try(strings.TrimPrefix(ref, "//"), func(r string, isMirror bool) error {
+			return cp.dockerSource(ctx, r, isMirror)
+		})
 	case "docker-archive":
 		cp.srcRef, err = dockerarchive.ParseReference(ref)
 	case "docker-daemon":
@@ -145,9 +156,11 @@ func (cp *OCIConveyorPacker) Get(ctx context.Context, b *sytypes.Bundle) (err er
 	// contains *only* this image
 	cp.tmpfsRef, err = ocilayout.ParseReference(cp.b.TmpDir + ":" + "tmp")
 
-	err = cp.fetch(ctx)
+	err = registry.Retry(ctx, cp.sysCtx, func() error {
+		return cp.fetch(ctx)
+	})
 	if err != nil {
-		return err
+		return registry.Explain(err)
 	}
 
 	cp.imgConfig, err = cp.getConfig(ctx)
@@ -158,6 +171,64 @@ func (cp *OCIConveyorPacker) Get(ctx context.Context, b *sytypes.Bundle) (err er
 	return nil
 }
 
+// dockerSource sets the source of the conveyor to the docker image ref and
+// resolves the credentials of its registry. The image is looked up on mirror
+// registries, which aren't sent the credentials set on the command line.
+func (cp *OCIConveyorPacker) dockerSource(ctx context.Context, ref string, isMirror bool) error {
+	srcRef, err := docker.ParseReference("//" + ref)
+	if err != nil {
+		return err
+	}
+
+	cp.sysCtx.DockerAuthConfig = nil
+	cp.sysCtx.DockerBearerRegistryToken = ""
+	cp.sysCtx.AuthFilePath = syfs.DockerConf()
+	creds := cp.b.Opts.DockerAuthConfig
+	if isMirror || creds == nil {
+		// resolve the credentials once so that the docker configuration
+		// of the invoking user is honored when building as root
+		creds, err = registryCredentials(cp.sysCtx, srcRef.DockerReference(), cp.b.Opts.DockerConfig)
+		if err != nil {
+			return err
+		}
+	}
+
+	mirrored, err := registry.Mirrored(cp.sysCtx, srcRef.DockerReference())
+	if err != nil {
+		return err
+	}
+	if mirrored {
+		// the credentials of the system context would also be sent to the
+		// mirrors of registries.conf, they are scoped to the registry
+		// hosting the image in an authentication file instead
+		if creds != nil {
+			cp.sysCtx.AuthFilePath, err = scopedAuthFile(cp.b.TmpDir, cp.sysCtx, srcRef.DockerReference(), creds)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		if !isMirror {
+			cp.sysCtx.DockerAuthConfig = cp.b.Opts.DockerAuthConfig
+		}
+		// pull anonymously when possible and wait for the registry rate limit
+		if err := registry.Authorize(ctx, cp.sysCtx, srcRef.DockerReference(), creds); err != nil {
+			return err
+		}
+		if cp.sysCtx.DockerAuthConfig == nil {
+			cp.sysCtx.DockerAuthConfig = creds
+		}
+	}
+	if isMirror {
+		if _, err := docker.GetDigest(ctx, cp.sysCtx, srcRef); err != nil {
+			return err
+		}
+	}
+
+	cp.srcRef = srcRef
+	return nil
+}
+
 // Pack puts relevant objects in a Bundle.
 func (cp *OCIConveyorPacker) Pack(ctx context.Context) (*sytypes.Bundle, error) {
 	err := cp.unpackTmpfs(ctx)
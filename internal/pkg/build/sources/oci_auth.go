@@ -0,0 +1,87 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sources
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/pkg/docker/config"
+	"github.com/containers/image/v5/types"
+)
+
+// registryCredentials returns the credentials of the registry hosting ref,
+// looked up in the authentication file of sysCtx and then in the docker
+// configuration dockerConfig, including its credential helpers. A nil value
+// is returned when no credentials are found.
+func registryCredentials(sysCtx *types.SystemContext, ref reference.Named, dockerConfig string) (*types.DockerAuthConfig, error) {
+	paths := []string{sysCtx.AuthFilePath}
+	if dockerConfig != "" && dockerConfig != sysCtx.AuthFilePath {
+		paths = append(paths, dockerConfig)
+	}
+
+	for _, path := range paths {
+		ctx := *sysCtx
+		ctx.AuthFilePath = path
+		creds, err := config.GetCredentialsForRef(&ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("while reading credentials from %s: %s", path, err)
+		}
+		if (creds.Username != "" && creds.Password != "") || creds.IdentityToken != "" {
+			return &creds, nil
+		}
+	}
+	return nil, nil
+}
+
+// scopedAuthFile writes in dir an authentication file holding the content of
+// the authentication file of sysCtx and the credentials creds of the registry
+// hosting ref, and returns its path. Unlike the credentials set in the
+// DockerAuthConfig of a system context, which are sent to any registry the
+// image is pulled from including the mirrors of registries.conf, they are
+// only sent to the registry hosting ref.
+func scopedAuthFile(dir string, sysCtx *types.SystemContext, ref reference.Named, creds *types.DockerAuthConfig) (string, error) {
+	conf := make(map[string]interface{})
+	data, err := ioutil.ReadFile(sysCtx.AuthFilePath)
+	if err == nil {
+		if err := json.Unmarshal(data, &conf); err != nil {
+			return "", fmt.Errorf("while parsing %s: %s", sysCtx.AuthFilePath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("while reading %s: %s", sysCtx.AuthFilePath, err)
+	}
+
+	auths, ok := conf["auths"].(map[string]interface{})
+	if !ok {
+		auths = make(map[string]interface{})
+	}
+	entry := make(map[string]string)
+	if creds.IdentityToken != "" {
+		entry["identitytoken"] = creds.IdentityToken
+	} else {
+		entry["auth"] = base64.StdEncoding.EncodeToString([]byte(creds.Username + ":" + creds.Password))
+	}
+	auths[reference.Domain(ref)] = entry
+	conf["auths"] = auths
+
+	data, err = json.Marshal(conf)
+	if err != nil {
+		return "", fmt.Errorf("while encoding authentication file: %s", err)
+	}
+	f, err := ioutil.TempFile(dir, "auth-")
+	if err != nil {
+		return "", fmt.Errorf("while creating authentication file: %s", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return "", fmt.Errorf("while writing authentication file: %s", err)
+	}
+	return f.Name(), nil
+}
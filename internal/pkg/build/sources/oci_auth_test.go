@@ -0,0 +1,120 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sources
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/pkg/docker/config"
+	"github.com/containers/image/v5/types"
+)
+
+func writeAuthFile(t *testing.T, path, registry, user, password string) {
+	auth := base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+	content := fmt.Sprintf(`{"auths": {%q: {"auth": %q}}}`, registry, auth)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write %s: %s", path, err)
+	}
+}
+
+func TestRegistryCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci-auth-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	syConfig := filepath.Join(dir, "docker-config.json")
+	dockerConfig := filepath.Join(dir, "config.json")
+	writeAuthFile(t, syConfig, "registry.example.com", "sy", "sypass")
+	writeAuthFile(t, dockerConfig, "private.example.com", "docker", "dockerpass")
+
+	tests := []struct {
+		ref          string
+		dockerConfig string
+		wantUser     string
+	}{
+		{"registry.example.com/app:latest", dockerConfig, "sy"},
+		{"private.example.com/app:latest", dockerConfig, "docker"},
+		{"private.example.com/app:latest", "", ""},
+		{"other.example.com/app:latest", dockerConfig, ""},
+	}
+
+	for _, tt := range tests {
+		ref, err := reference.ParseNormalizedNamed(tt.ref)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %s", tt.ref, err)
+		}
+		sysCtx := &types.SystemContext{AuthFilePath: syConfig}
+		creds, err := registryCredentials(sysCtx, ref, tt.dockerConfig)
+		if err != nil {
+			t.Errorf("unexpected error for %s: %s", tt.ref, err)
+			continue
+		}
+		if tt.wantUser == "" {
+			if creds != nil {
+				t.Errorf("unexpected credentials for %s: %s", tt.ref, creds.Username)
+			}
+		} else if creds == nil || creds.Username != tt.wantUser {
+			t.Errorf("unexpected credentials for %s: %v", tt.ref, creds)
+		}
+	}
+}
+
+func TestScopedAuthFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci-auth-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	syConfig := filepath.Join(dir, "docker-config.json")
+	writeAuthFile(t, syConfig, "registry.example.com", "sy", "sypass")
+
+	ref, err := reference.ParseNormalizedNamed("private.example.com/app:latest")
+	if err != nil {
+		t.Fatalf("failed to parse reference: %s", err)
+	}
+	creds := &types.DockerAuthConfig{Username: "docker", Password: "dockerpass"}
+
+	for _, authFile := range []string{syConfig, filepath.Join(dir, "missing.json")} {
+		path, err := scopedAuthFile(dir, &types.SystemContext{AuthFilePath: authFile}, ref, creds)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		sysCtx := &types.SystemContext{AuthFilePath: path}
+
+		tests := []struct {
+			registry string
+			wantUser string
+		}{
+			{"private.example.com", "docker"},
+			// mirrors are not sent the credentials
+			{"mirror.example.com", ""},
+		}
+		if authFile == syConfig {
+			tests = append(tests, struct {
+				registry string
+				wantUser string
+			}{"registry.example.com", "sy"})
+		}
+		for _, tt := range tests {
+			got, err := config.GetCredentials(sysCtx, tt.registry)
+			if err != nil {
+				t.Fatalf("unexpected error for %s: %s", tt.registry, err)
+			}
+			if got.Username != tt.wantUser {
+				t.Errorf("got user %q instead of %q for %s", got.Username, tt.wantUser, tt.registry)
+			}
+		}
+	}
+}
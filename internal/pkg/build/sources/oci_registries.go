@@ -0,0 +1,133 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sources
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/containers/image/v5/types"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/pelletier/go-toml"
+)
+
+// userRegistriesDropIn is the name of the drop-in file holding the
+// registries of the user configuration.
+const userRegistriesDropIn = "zz-singularity-user.conf"
+
+// registriesEndpoint is a registry or mirror location of a drop-in file.
+type registriesEndpoint struct {
+	Location string `toml:"location,omitempty"`
+	Insecure bool   `toml:"insecure,omitempty"`
+}
+
+// registriesEntry is a registry of a drop-in file.
+type registriesEntry struct {
+	Prefix             string               `toml:"prefix"`
+	Location           string               `toml:"location,omitempty"`
+	Insecure           bool                 `toml:"insecure,omitempty"`
+	Blocked            bool                 `toml:"blocked,omitempty"`
+	MirrorByDigestOnly bool                 `toml:"mirror-by-digest-only,omitempty"`
+	Mirrors            []registriesEndpoint `toml:"mirror,omitempty"`
+}
+
+// systemRegistriesDirs returns the drop-in directories of the registries
+// configuration used by default, as selected by containers/image.
+func systemRegistriesDirs() []string {
+	userDir := filepath.Join(os.Getenv("HOME"), ".config", "containers")
+	if _, err := os.Stat(filepath.Join(userDir, "registries.conf")); err == nil {
+		return []string{filepath.Join(userDir, "registries.conf.d")}
+	}
+	return []string{"/etc/containers/registries.conf.d", filepath.Join(userDir, "registries.conf.d")}
+}
+
+// userRegistriesDir creates in dir a drop-in directory for the registries
+// configuration of sysCtx, holding the drop-in files of the directories
+// dropInDirs followed by the registries of the user configuration userConf
+// not configured by the system. The mirrors set by the user apply without
+// overriding the registries configured by the administrator. An empty path
+// is returned when the user configuration adds no registry.
+func userRegistriesDir(sysCtx *types.SystemContext, dropInDirs []string, userConf, dir string) (string, error) {
+	system, err := sysregistriesv2.GetRegistries(sysCtx)
+	if err != nil {
+		return "", fmt.Errorf("while reading system registries configuration: %s", err)
+	}
+	configured := make(map[string]bool, len(system))
+	for _, r := range system {
+		configured[r.Prefix] = true
+	}
+
+	user, err := sysregistriesv2.GetRegistries(&types.SystemContext{
+		SystemRegistriesConfPath: userConf,
+		// only the registries of the user configuration
+		SystemRegistriesConfDirPath: filepath.Join(dir, "none"),
+	})
+	if err != nil {
+		return "", fmt.Errorf("while reading registries configuration %s: %s", userConf, err)
+	}
+	var entries []registriesEntry
+	for _, r := range user {
+		if configured[r.Prefix] {
+			sylog.Warningf("Ignoring registry %s of %s configured by the system", r.Prefix, userConf)
+			continue
+		}
+		e := registriesEntry{
+			Prefix:             r.Prefix,
+			Location:           r.Location,
+			Insecure:           r.Insecure,
+			Blocked:            r.Blocked,
+			MirrorByDigestOnly: r.MirrorByDigestOnly,
+		}
+		for _, m := range r.Mirrors {
+			e.Mirrors = append(e.Mirrors, registriesEndpoint{Location: m.Location, Insecure: m.Insecure})
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		return "", nil
+	}
+
+	confDir, err := ioutil.TempDir(dir, "registries.conf.d-")
+	if err != nil {
+		return "", fmt.Errorf("while creating registries configuration directory: %s", err)
+	}
+	// the drop-in files are read in lexical order, the system ones
+	// are numbered to keep their order across directories
+	n := 0
+	for _, d := range dropInDirs {
+		files, err := filepath.Glob(filepath.Join(d, "*.conf"))
+		if err != nil {
+			return "", err
+		}
+		for _, f := range files {
+			data, err := ioutil.ReadFile(f)
+			if err != nil {
+				return "", fmt.Errorf("while reading %s: %s", f, err)
+			}
+			name := fmt.Sprintf("%04d-%s", n, filepath.Base(f))
+			if err := ioutil.WriteFile(filepath.Join(confDir, name), data, 0644); err != nil {
+				return "", fmt.Errorf("while copying %s: %s", f, err)
+			}
+			n++
+		}
+	}
+
+	data, err := toml.Marshal(struct {
+		Registries []registriesEntry `toml:"registry"`
+	}{entries})
+	if err != nil {
+		return "", fmt.Errorf("while encoding registries configuration: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(confDir, userRegistriesDropIn), data, 0644); err != nil {
+		return "", fmt.Errorf("while writing registries configuration: %s", err)
+	}
+	sylog.Debugf("Registries of %s added: %s", userConf, strings.TrimSpace(string(data)))
+	return confDir, nil
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sources
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/containers/image/v5/types"
+)
+
+func TestUserRegistriesDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci-registries-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(path, content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %s", path, err)
+		}
+	}
+	systemConf := filepath.Join(dir, "registries.conf")
+	systemDir := filepath.Join(dir, "registries.conf.d")
+	if err := os.Mkdir(systemDir, 0755); err != nil {
+		t.Fatalf("failed to create directory: %s", err)
+	}
+	write(systemConf, `
+[[registry]]
+location = "docker.io"
+[[registry.mirror]]
+location = "system-mirror.example.com"
+`)
+	write(filepath.Join(systemDir, "blocked.conf"), `
+[[registry]]
+location = "blocked.example.com"
+blocked = true
+`)
+	userConf := filepath.Join(dir, "user.conf")
+	write(userConf, `
+[[registry]]
+location = "docker.io"
+[[registry.mirror]]
+location = "user-mirror.example.com"
+
+[[registry]]
+location = "quay.io"
+[[registry.mirror]]
+location = "quay-mirror.example.com"
+`)
+
+	sysCtx := &types.SystemContext{
+		SystemRegistriesConfPath:    systemConf,
+		SystemRegistriesConfDirPath: systemDir,
+	}
+	confDir, err := userRegistriesDir(sysCtx, []string{systemDir}, userConf, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if confDir == "" {
+		t.Fatalf("no registries added")
+	}
+
+	merged := &types.SystemContext{
+		SystemRegistriesConfPath:    systemConf,
+		SystemRegistriesConfDirPath: confDir,
+	}
+	tests := []struct {
+		ref     string
+		mirror  string
+		blocked bool
+	}{
+		// registries configured by the system are left unchanged
+		{ref: "docker.io/library/alpine", mirror: "system-mirror.example.com"},
+		{ref: "blocked.example.com/app", blocked: true},
+		// other registries of the user are added
+		{ref: "quay.io/app", mirror: "quay-mirror.example.com"},
+	}
+	for _, tt := range tests {
+		reg, err := sysregistriesv2.FindRegistry(merged, tt.ref)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %s", tt.ref, err)
+		}
+		if reg == nil {
+			t.Errorf("no registry found for %s", tt.ref)
+			continue
+		}
+		if reg.Blocked != tt.blocked {
+			t.Errorf("got blocked %v instead of %v for %s", reg.Blocked, tt.blocked, tt.ref)
+		}
+		if tt.mirror != "" && (len(reg.Mirrors) != 1 || reg.Mirrors[0].Location != tt.mirror) {
+			t.Errorf("got mirrors %v instead of %s for %s", reg.Mirrors, tt.mirror, tt.ref)
+		}
+	}
+
+	// no directory is created when the user adds no registry,
+	// the registries configurations are cached by path
+	userConf = filepath.Join(dir, "user-system.conf")
+	write(userConf, `
+[[registry]]
+location = "docker.io"
+`)
+	confDir, err = userRegistriesDir(sysCtx, []string{systemDir}, userConf, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if confDir != "" {
+		t.Errorf("unexpected registries directory %s", confDir)
+	}
+}
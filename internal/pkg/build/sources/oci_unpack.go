@@ -1,4 +1,4 @@
-// Copyright (c) 2019-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2019-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -90,10 +90,20 @@ func unpackRootfs(ctx context.Context, b *sytypes.Bundle, tmpfsRef types.ImageRe
 
 	// Unpack root filesystem
 	unpackOptions := umocilayer.UnpackOptions{MapOptions: mapOptions}
+	if err := CheckWhiteoutMode(b.Opts.WhiteoutMode, mapOptions.Rootless); err != nil {
+		return err
+	}
+	finalize, err := setWhiteoutMode(ctx, &unpackOptions, b.Opts.WhiteoutMode, b.RootfsPath, engineExt, manifest)
+	if err != nil {
+		return fmt.Errorf("error reading layer whiteouts: %s", err)
+	}
 	err = umocilayer.UnpackRootfs(ctx, engineExt, b.RootfsPath, manifest, &unpackOptions)
 	if err != nil {
 		return fmt.Errorf("error unpacking rootfs: %s", err)
 	}
+	if err := finalize(); err != nil {
+		return fmt.Errorf("error unpacking rootfs: %s", err)
+	}
 
 	// If the `--fix-perms` flag was used, then modify the permissions so that
 	// content has owner rwX and we're done
@@ -0,0 +1,201 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sources
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/hpcng/singularity/pkg/sylog"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/opencontainers/umoci/oci/casext"
+	umocilayer "github.com/opencontainers/umoci/oci/layer"
+	"golang.org/x/sys/unix"
+)
+
+// Whiteout modes of the OCI layer extraction.
+const (
+	// WhiteoutApply applies the layer whiteouts by removing the files
+	// they hide, the resulting rootfs doesn't contain any whiteout.
+	WhiteoutApply = "apply"
+	// WhiteoutOverlayFS applies the layer whiteouts and keeps them as
+	// overlayfs whiteouts, 0/0 character devices and opaque directory
+	// attributes, which requires privileges.
+	WhiteoutOverlayFS = "overlayfs"
+	// WhiteoutFiles applies the layer whiteouts and keeps them as .wh.
+	// files, as understood by fuse-overlayfs, which works rootless.
+	WhiteoutFiles = "wh"
+)
+
+const (
+	whPrefix = ".wh."
+	whOpaque = whPrefix + whPrefix + ".opq"
+)
+
+// CheckWhiteoutMode returns an error if the whiteout mode is unknown or
+// not supported when extracting as an unprivileged user.
+func CheckWhiteoutMode(mode string, rootless bool) error {
+	switch mode {
+	case "", WhiteoutApply, WhiteoutFiles:
+		return nil
+	case WhiteoutOverlayFS:
+		if rootless {
+			return fmt.Errorf("%s whiteout mode requires root privileges, use the %s whiteout mode instead", WhiteoutOverlayFS, WhiteoutFiles)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown whiteout mode %q: must be one of %s, %s or %s", mode, WhiteoutApply, WhiteoutOverlayFS, WhiteoutFiles)
+}
+
+// layerWhiteouts returns the whiteout entries of the layers of manifest,
+// indexed by layer digest.
+func layerWhiteouts(ctx context.Context, engineExt casext.Engine, manifest imgspecv1.Manifest) (map[string][]string, error) {
+	whiteouts := make(map[string][]string)
+
+	for _, desc := range manifest.Layers {
+		paths, err := scanLayerWhiteouts(ctx, engineExt, desc)
+		if err != nil {
+			return nil, fmt.Errorf("while reading layer %s: %s", desc.Digest, err)
+		}
+		whiteouts[desc.Digest.String()] = paths
+	}
+	return whiteouts, nil
+}
+
+// scanLayerWhiteouts returns the cleaned paths of the whiteout entries of
+// the layer desc.
+func scanLayerWhiteouts(ctx context.Context, engineExt casext.Engine, desc imgspecv1.Descriptor) ([]string, error) {
+	blob, err := engineExt.FromDescriptor(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	data, ok := blob.Data.(io.ReadCloser)
+	if !ok {
+		return nil, fmt.Errorf("unexpected layer blob data")
+	}
+	var r io.Reader = data
+	if strings.HasSuffix(desc.MediaType, "gzip") {
+		gz, err := gzip.NewReader(data)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var paths []string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return paths, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if name := filepath.Clean("/" + hdr.Name); strings.HasPrefix(filepath.Base(name), whPrefix) {
+			paths = append(paths, name)
+		}
+	}
+}
+
+// whiteoutKeeper keeps the whiteouts applied by umoci in the rootfs as
+// overlayfs whiteouts or .wh. files.
+type whiteoutKeeper struct {
+	rootfs    string
+	mode      string
+	whiteouts map[string][]string
+	created   []string
+}
+
+// afterLayer creates the whiteouts of the layer desc once unpacked.
+func (w *whiteoutKeeper) afterLayer(manifest imgspecv1.Manifest, desc imgspecv1.Descriptor) error {
+	for _, path := range w.whiteouts[desc.Digest.String()] {
+		dir, err := securejoin.SecureJoin(w.rootfs, filepath.Dir(path))
+		if err != nil {
+			return fmt.Errorf("while resolving whiteout %s: %s", path, err)
+		}
+		// whiteouts in directories removed by another whiteout are
+		// hidden already
+		if fi, err := os.Lstat(dir); err != nil || !fi.IsDir() {
+			sylog.Debugf("Skipping whiteout %s without parent directory", path)
+			continue
+		}
+
+		name := filepath.Base(path)
+		if w.mode == WhiteoutFiles {
+			wh := filepath.Join(dir, name)
+			f, err := os.OpenFile(wh, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0)
+			if err != nil {
+				return fmt.Errorf("while creating whiteout %s: %s", path, err)
+			}
+			f.Close()
+			w.created = append(w.created, wh)
+			continue
+		}
+
+		if name == whOpaque {
+			if err := unix.Lsetxattr(dir, "trusted.overlay.opaque", []byte("y"), 0); err != nil {
+				return fmt.Errorf("while setting opaque directory %s: %s", dir, err)
+			}
+			continue
+		}
+		// the path was added back by the layer itself
+		target := filepath.Join(dir, strings.TrimPrefix(name, whPrefix))
+		if _, err := os.Lstat(target); err == nil {
+			continue
+		}
+		if err := unix.Mknod(target, unix.S_IFCHR|0666, int(unix.Mkdev(0, 0))); err != nil {
+			return fmt.Errorf("while creating whiteout %s: %s", path, err)
+		}
+	}
+	return nil
+}
+
+// cleanup removes the .wh. files of the paths added back by a later layer.
+func (w *whiteoutKeeper) cleanup() error {
+	for _, wh := range w.created {
+		name := filepath.Base(wh)
+		if name == whOpaque {
+			continue
+		}
+		target := filepath.Join(filepath.Dir(wh), strings.TrimPrefix(name, whPrefix))
+		if _, err := os.Lstat(target); err != nil {
+			continue
+		}
+		if err := os.Remove(wh); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("while removing whiteout %s: %s", wh, err)
+		}
+	}
+	return nil
+}
+
+// setWhiteoutMode configures the unpack options for the whiteout mode, the
+// returned function finalizes the rootfs once unpacked. The whiteouts are
+// always applied by umoci so the rootfs is flattened correctly, the kept
+// whiteouts are created once each layer is unpacked.
+func setWhiteoutMode(ctx context.Context, opts *umocilayer.UnpackOptions, mode, rootfs string, engineExt casext.Engine, manifest imgspecv1.Manifest) (func() error, error) {
+	opts.WhiteoutMode = umocilayer.OCIStandardWhiteout
+	if mode != WhiteoutOverlayFS && mode != WhiteoutFiles {
+		return func() error { return nil }, nil
+	}
+
+	whiteouts, err := layerWhiteouts(ctx, engineExt, manifest)
+	if err != nil {
+		return nil, err
+	}
+	w := &whiteoutKeeper{rootfs: rootfs, mode: mode, whiteouts: whiteouts}
+	opts.AfterLayerUnpack = w.afterLayer
+	return w.cleanup, nil
+}
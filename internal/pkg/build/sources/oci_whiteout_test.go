@@ -0,0 +1,90 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sources
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestCheckWhiteoutMode(t *testing.T) {
+	tests := []struct {
+		mode     string
+		rootless bool
+		wantErr  bool
+	}{
+		{"", true, false},
+		{WhiteoutApply, true, false},
+		{WhiteoutFiles, true, false},
+		{WhiteoutOverlayFS, false, false},
+		{WhiteoutOverlayFS, true, true},
+		{"aufs", false, true},
+	}
+
+	for _, tt := range tests {
+		err := CheckWhiteoutMode(tt.mode, tt.rootless)
+		if tt.wantErr && err == nil {
+			t.Errorf("unexpected success for mode %q, rootless %v", tt.mode, tt.rootless)
+		} else if !tt.wantErr && err != nil {
+			t.Errorf("unexpected error for mode %q, rootless %v: %s", tt.mode, tt.rootless, err)
+		}
+	}
+}
+
+func TestWhiteoutFiles(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "whiteout-rootfs-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(rootfs)
+
+	if err := os.MkdirAll(filepath.Join(rootfs, "etc", "opaque"), 0755); err != nil {
+		t.Fatalf("failed to create directories: %s", err)
+	}
+
+	w := &whiteoutKeeper{
+		rootfs: rootfs,
+		mode:   WhiteoutFiles,
+		whiteouts: map[string][]string{
+			"sha256:1": {
+				"/etc/.wh.removed",
+				"/etc/.wh.readded",
+				"/etc/opaque/.wh..wh..opq",
+				"/gone/.wh.file",
+			},
+		},
+	}
+	layer := imgspecv1.Descriptor{Digest: "sha256:1"}
+	if err := w.afterLayer(imgspecv1.Manifest{}, layer); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// a later layer adds a whited out path back
+	if err := ioutil.WriteFile(filepath.Join(rootfs, "etc", "readded"), nil, 0644); err != nil {
+		t.Fatalf("failed to create file: %s", err)
+	}
+	if err := w.cleanup(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for path, exists := range map[string]bool{
+		"etc/.wh.removed":         true,
+		"etc/.wh.readded":         false,
+		"etc/opaque/.wh..wh..opq": true,
+		"gone":                    false,
+	} {
+		_, err := os.Lstat(filepath.Join(rootfs, path))
+		if exists && err != nil {
+			t.Errorf("%s not found: %s", path, err)
+		} else if !exists && err == nil {
+			t.Errorf("unexpected %s found", path)
+		}
+	}
+}
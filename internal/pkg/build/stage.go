@@ -7,12 +7,14 @@ package build
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"syscall"
 
+	"github.com/hpcng/singularity/internal/pkg/build/buildlog"
 	"github.com/hpcng/singularity/internal/pkg/build/files"
 	"github.com/hpcng/singularity/internal/pkg/buildcfg"
 	"github.com/hpcng/singularity/pkg/build/types"
@@ -29,6 +31,8 @@ type stage struct {
 	a Assembler
 	// b is an intermediate structure that encapsulates all information for the container, e.g., metadata, filesystems.
 	b *types.Bundle
+	// log records the output of the sections, nil when disabled.
+	log *buildlog.Log
 }
 
 const (
@@ -70,7 +74,7 @@ func (s *stage) runSectionScript(name string, script types.Script) error {
 		cmd.Env = append(cmd.Env, sEnvironment, sRootfs)
 
 		sylog.Infof("Running %s scriptlet", name)
-		if err := cmd.Run(); err != nil {
+		if err := s.runSection(name, cmd); err != nil {
 			return fmt.Errorf("failed to run %%%s script: %v", name, err)
 		}
 	}
@@ -79,14 +83,17 @@ func (s *stage) runSectionScript(name string, script types.Script) error {
 
 func (s *stage) runPostScript(configFile, sessionResolv, sessionHosts string) error {
 	if s.b.Recipe.BuildData.Post.Script != "" {
-		cmdArgs := []string{"-s", "-c", configFile, "exec", "--pwd", "/", "--writable"}
-		cmdArgs = append(cmdArgs, "--cleanenv", "--env", sEnvironment, "--env", sLabels)
+		opts := []string{"--pwd", "/", "--writable"}
+		opts = append(opts, "--cleanenv", "--env", sEnvironment, "--env", sLabels)
 
 		if sessionResolv != "" {
-			cmdArgs = append(cmdArgs, "-B", sessionResolv+":/etc/resolv.conf")
+			opts = append(opts, "-B", sessionResolv+":/etc/resolv.conf")
 		}
 		if sessionHosts != "" {
-			cmdArgs = append(cmdArgs, "-B", sessionHosts+":/etc/hosts")
+			opts = append(opts, "-B", sessionHosts+":/etc/hosts")
+		}
+		if cgroupsFile := s.cgroupsFile(); cgroupsFile != "" {
+			opts = append(opts, "--apply-cgroups", cgroupsFile)
 		}
 
 		script := s.b.Recipe.BuildData.Post
@@ -101,48 +108,114 @@ func (s *stage) runPostScript(configFile, sessionResolv, sessionHosts string) er
 			return fmt.Errorf("while processing section %%post arguments: %s", err)
 		}
 
-		exe := filepath.Join(buildcfg.BINDIR, "singularity")
-
+		cmdArgs := []string{"-s", "-c", configFile, "exec"}
+		cmdArgs = append(cmdArgs, opts...)
 		cmdArgs = append(cmdArgs, s.b.RootfsPath)
 		cmdArgs = append(cmdArgs, args...)
-		cmd := exec.Command(exe, cmdArgs...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		cmd.Dir = "/"
-		cmd.Env = currentEnvNoSingularity()
 
 		sylog.Infof("Running post scriptlet")
-		return cmd.Run()
+		if err := s.runSection("post", s.sectionCmd(cmdArgs)); err != nil {
+			s.debugShell("post", configFile, opts, "the %post script is /.post.script")
+			return err
+		}
 	}
 	return nil
 }
 
 func (s *stage) runTestScript(configFile, sessionResolv, sessionHosts string) error {
 	if !s.b.Opts.NoTest && s.b.Recipe.BuildData.Test.Script != "" {
-		cmdArgs := []string{"-s", "-c", configFile, "test", "--pwd", "/"}
+		opts := []string{"--pwd", "/"}
 
 		if sessionResolv != "" {
-			cmdArgs = append(cmdArgs, "-B", sessionResolv+":/etc/resolv.conf")
+			opts = append(opts, "-B", sessionResolv+":/etc/resolv.conf")
 		}
 		if sessionHosts != "" {
-			cmdArgs = append(cmdArgs, "-B", sessionHosts+":/etc/hosts")
+			opts = append(opts, "-B", sessionHosts+":/etc/hosts")
+		}
+		if cgroupsFile := s.cgroupsFile(); cgroupsFile != "" {
+			opts = append(opts, "--apply-cgroups", cgroupsFile)
 		}
 
-		exe := filepath.Join(buildcfg.BINDIR, "singularity")
-
+		cmdArgs := []string{"-s", "-c", configFile, "test"}
+		cmdArgs = append(cmdArgs, opts...)
 		cmdArgs = append(cmdArgs, s.b.RootfsPath)
-		cmd := exec.Command(exe, cmdArgs...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		cmd.Dir = "/"
-		cmd.Env = currentEnvNoSingularity()
 
 		sylog.Infof("Running testscript")
-		return cmd.Run()
+		if err := s.runSection("test", s.sectionCmd(cmdArgs)); err != nil {
+			s.debugShell("test", configFile, opts, "the %test script is /.singularity.d/test")
+			return err
+		}
 	}
 	return nil
 }
 
+// sectionCmd returns the command running singularity with args to execute
+// a section in the build sandbox.
+func (s *stage) sectionCmd(args []string) *exec.Cmd {
+	exe := filepath.Join(buildcfg.BINDIR, "singularity")
+
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Dir = "/"
+	cmd.Env = currentEnvNoSingularity()
+	return cmd
+}
+
+// runSection runs the command of the section name, its output is recorded
+// in the build log when enabled.
+func (s *stage) runSection(name string, cmd *exec.Cmd) error {
+	if s.log == nil {
+		return s.runLimited(cmd)
+	}
+
+	w := s.log.Section(s.name, name)
+	defer w.Close()
+	cmd.Stdout = io.MultiWriter(cmd.Stdout, w)
+	cmd.Stderr = io.MultiWriter(cmd.Stderr, w)
+
+	s.logf("Running %%%s section", name)
+	if err := s.runLimited(cmd); err != nil {
+		s.logf("%%%s section failed: %s", name, err)
+		return err
+	}
+	s.logf("%%%s section completed", name)
+	return nil
+}
+
+// logf records a build event of the stage in the build log when enabled.
+func (s *stage) logf(format string, a ...interface{}) {
+	if s.log == nil {
+		return
+	}
+	tag := "build"
+	if s.name != "" {
+		tag = s.name
+	}
+	s.log.Printf(tag, format, a...)
+}
+
+// debugShell starts an interactive shell in the build sandbox, with the
+// options opts the failed section ran with, when the build was requested
+// with a debug shell. The build fails once the shell exits.
+func (s *stage) debugShell(section, configFile string, opts []string, hint string) {
+	if !s.b.Opts.DebugShell {
+		return
+	}
+
+	args := []string{"-s", "-c", configFile, "shell"}
+	args = append(args, opts...)
+	args = append(args, s.b.RootfsPath)
+
+	sylog.Warningf("%%%s section failed, starting a debug shell in the build sandbox, %s", section, hint)
+	sylog.Warningf("The build fails once the shell exits")
+	cmd := s.sectionCmd(args)
+	cmd.Stdin = os.Stdin
+	if err := cmd.Run(); err != nil {
+		sylog.Debugf("Debug shell exited: %s", err)
+	}
+}
+
 func (s *stage) copyFilesFrom(b *Build) error {
 	def := s.b.Recipe
 	for _, f := range def.BuildData.Files {
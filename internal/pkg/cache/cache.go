@@ -47,6 +47,9 @@ const (
 	OrasCacheType = "oras"
 	// NetCacheType specifies the cache holds images pulled from http(s) internet sources
 	NetCacheType = "net"
+	// TransportCacheType specifies the cache holds images pulled with the
+	// transports registered by plugins
+	TransportCacheType = "transport"
 )
 
 var (
@@ -57,6 +60,7 @@ var (
 		ShubCacheType,
 		OrasCacheType,
 		NetCacheType,
+		TransportCacheType,
 	}
 	// OciCacheTypes specifies the OCI cache types.
 	OciCacheTypes = []string{
@@ -71,6 +75,12 @@ type Config struct {
 	ParentDir string
 	// Disable specifies whether the user request the cache to be disabled by default.
 	Disable bool
+	// Quotas specifies the maximum size in bytes of cache types, the least
+	// recently used entries are evicted when new entries are added.
+	Quotas map[string]int64
+	// SystemDir specifies the root directory of a read-only cache populated
+	// by the administrator, consulted before the user cache.
+	SystemDir string
 }
 
 // Handle is an structure representing the image cache, it's location and subdirectories
@@ -85,6 +95,13 @@ type Handle struct {
 	rootDir string
 	// If the cache is disabled
 	disabled bool
+	// quotas are the maximum sizes of the cache types
+	quotas map[string]int64
+	// systemDir is the root directory of the system cache
+	systemDir string
+	// shared is true if the cache is the system cache, populated by
+	// the administrator and readable by all users
+	shared bool
 }
 
 func (h *Handle) GetFileCacheDir(cacheType string) (cacheDir string, err error) {
@@ -107,13 +124,25 @@ func (h *Handle) GetEntry(cacheType string, hash string) (e *Entry, err error) {
 		return nil, nil
 	}
 
-	e = &Entry{}
+	e = &Entry{
+		CacheType: cacheType,
+		handle:    h,
+		created:   time.Now(),
+	}
 
 	cacheDir, err := h.GetFileCacheDir(cacheType)
 	if err != nil {
 		return nil, fmt.Errorf("cannot get '%s' cache directory: %v", cacheType, err)
 	}
 
+	// The system cache is consulted first, its entries are read-only
+	if path := h.systemEntry(cacheType, hash); path != "" {
+		sylog.Debugf("Using system cache entry %s", path)
+		e.Path = path
+		e.Exists = true
+		return e, nil
+	}
+
 	e.Path = filepath.Join(cacheDir, hash)
 
 	// If there is a directory it's from an older version of Singularity
@@ -155,6 +184,7 @@ func (h *Handle) GetEntry(cacheType string, hash string) (e *Entry, err error) {
 
 	// It exists in the cache and it's a file. Caller can use the Path directly
 	e.Exists = true
+	touch(e.Path)
 	return e, nil
 }
 
@@ -254,6 +284,7 @@ func New(cfg Config) (h *Handle, err error) {
 		parentDir = getCacheParentDir()
 	}
 	h.parentDir = parentDir
+	h.quotas = cfg.Quotas
 
 	// If we can't access the parent of the cache directory then don't use the
 	// cache.
@@ -275,13 +306,30 @@ func New(cfg Config) (h *Handle, err error) {
 	// Initialize the root directory of the cache
 	rootDir := path.Join(parentDir, SubDirName)
 	h.rootDir = rootDir
-	if err = initCacheDir(rootDir); err != nil {
+
+	// The administrator populating the system cache uses it
+	// as the regular cache, made readable by all users
+	perm := os.FileMode(0700)
+	if cfg.SystemDir != "" {
+		if filepath.Clean(cfg.SystemDir) == filepath.Clean(rootDir) {
+			h.shared = true
+			perm = 0755
+		} else if dir, err := filepath.EvalSymlinks(cfg.SystemDir); err != nil {
+			sylog.Warningf("Ignoring system cache: %s", err)
+		} else if err := checkSystemDir(dir); err != nil {
+			sylog.Warningf("Ignoring system cache: %s", err)
+		} else {
+			h.systemDir = dir
+		}
+	}
+
+	if err = initCacheDir(rootDir, perm); err != nil {
 		return nil, fmt.Errorf("failed initializing caching directory: %s", err)
 	}
 	// Initialize the subdirectories of the cache
 	for _, ct := range FileCacheTypes {
 		dir := h.getCacheTypeDir(ct)
-		if err = initCacheDir(dir); err != nil {
+		if err = initCacheDir(dir, perm); err != nil {
 			return nil, fmt.Errorf("failed initializing caching directory: %s", err)
 		}
 	}
@@ -313,19 +361,19 @@ func getCacheParentDir() string {
 	return parentDir
 }
 
-func initCacheDir(dir string) error {
+func initCacheDir(dir string, perm os.FileMode) error {
 	if fi, err := os.Stat(dir); os.IsNotExist(err) {
 		sylog.Debugf("Creating cache directory: %s", dir)
-		if err := fs.MkdirAll(dir, 0700); err != nil {
+		if err := fs.MkdirAll(dir, perm); err != nil {
 			return fmt.Errorf("couldn't create cache directory %v: %v", dir, err)
 		}
 	} else if err != nil {
 		return fmt.Errorf("unable to stat %s: %s", dir, err)
-	} else if fi.Mode().Perm() != 0700 {
+	} else if fi.Mode().Perm() != perm {
 		// enforce permission on cache directory to prevent
 		// potential information leak
-		if err := os.Chmod(dir, 0700); err != nil {
-			return fmt.Errorf("couldn't enforce permission 0700 on %s: %s", dir, err)
+		if err := os.Chmod(dir, perm); err != nil {
+			return fmt.Errorf("couldn't enforce permission %04o on %s: %s", perm, dir, err)
 		}
 	}
 
@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -9,6 +9,7 @@ package cache
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/hpcng/singularity/internal/pkg/util/fs"
 	"github.com/hpcng/singularity/pkg/sylog"
@@ -27,6 +28,11 @@ type Entry struct {
 	// tmpPath is the temporary location that should be used for a new cache entry as it
 	// is created
 	TmpPath string
+	// handle is the cache the entry belongs to
+	handle *Handle
+	// created is the time the entry was requested, entries
+	// used after it are kept while enforcing the cache quota
+	created time.Time
 }
 
 // Finalize an entry by renaming it to its permanent path atomically
@@ -39,6 +45,14 @@ func (e *Entry) Finalize() error {
 	if err != nil {
 		return fmt.Errorf("could not finalize cached file: %v", err)
 	}
+	if e.handle != nil {
+		if err := e.handle.publish(e.Path); err != nil {
+			sylog.Warningf("Could not make cache entry %s readable: %s", e.Path, err)
+		}
+		if err := e.handle.EnforceQuota(e.CacheType, e.created); err != nil {
+			sylog.Warningf("Could not enforce %s cache quota: %s", e.CacheType, err)
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,240 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+// QuotaEnv specifies the environment variable setting a comma separated
+// list of cache quotas, it takes precedence over the cache quota
+// directives of singularity.conf.
+const QuotaEnv = "SINGULARITY_CACHE_QUOTA"
+
+// Stats describes the usage of a cache type.
+type Stats struct {
+	Type    string `json:"type"`
+	Entries int    `json:"entries"`
+	Size    int64  `json:"size"`
+	// Quota is the maximum size of the cache type in bytes, zero
+	// if the cache type has no quota.
+	Quota int64 `json:"quota,omitempty"`
+	// Oldest and Newest are the oldest and most recent use of
+	// the cache type entries.
+	Oldest *time.Time `json:"oldest,omitempty"`
+	Newest *time.Time `json:"newest,omitempty"`
+}
+
+// cacheFile is a cache entry file considered for eviction.
+type cacheFile struct {
+	path    string
+	size    int64
+	lastUse time.Time
+}
+
+// ParseQuotas parses cache quotas of the form <type>:<size>, where size is
+// a number of bytes optionally followed by a K, M, G or T binary unit.
+// The type oci is accepted for the oci-tmp cache type, the last quota of
+// a type takes precedence.
+func ParseQuotas(quotas []string) (map[string]int64, error) {
+	m := make(map[string]int64)
+
+	for _, q := range quotas {
+		q = strings.TrimSpace(q)
+		if q == "" {
+			continue
+		}
+		i := strings.Index(q, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid cache quota %q: must be of the form <type>:<size>", q)
+		}
+		cacheType := strings.TrimSpace(q[:i])
+		if cacheType == "oci" {
+			cacheType = OciTempCacheType
+		}
+		if !stringInSlice(cacheType, FileCacheTypes) && !stringInSlice(cacheType, OciCacheTypes) {
+			return nil, fmt.Errorf("invalid cache quota %q: unknown cache type %s", q, cacheType)
+		}
+		size, err := ParseSize(strings.TrimSpace(q[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache quota %q: %s", q, err)
+		}
+		m[cacheType] = size
+	}
+	return m, nil
+}
+
+// ParseSize returns the number of bytes represented by s, a number with an
+// optional K, M, G or T unit.
+func ParseSize(s string) (int64, error) {
+	units := map[string]int64{
+		"":  1,
+		"K": 1 << 10,
+		"M": 1 << 20,
+		"G": 1 << 30,
+		"T": 1 << 40,
+	}
+
+	u := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(s), "B"), "I")
+	n := strings.TrimRight(u, "KMGT")
+	factor, ok := units[u[len(n):]]
+	if !ok || n == "" {
+		return 0, fmt.Errorf("invalid size %s", s)
+	}
+	v, err := strconv.ParseFloat(n, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %s", s)
+	}
+	return int64(v * float64(factor)), nil
+}
+
+// Quota returns the quota of the cache type in bytes, zero if
+// the cache type has no quota.
+func (h *Handle) Quota(cacheType string) int64 {
+	return h.quotas[cacheType]
+}
+
+// entriesDir returns the directory holding the entry files of
+// the cache type.
+func (h *Handle) entriesDir(cacheType string) string {
+	dir := h.getCacheTypeDir(cacheType)
+	if cacheType == OciBlobCacheType {
+		// blobs are stored in the OCI layout of the blob cache
+		dir = filepath.Join(dir, "blobs", "sha256")
+	}
+	return dir
+}
+
+// listFiles returns the entry files of the cache type sorted from the
+// least to the most recently used. Temporary files of entries being
+// created are ignored.
+func (h *Handle) listFiles(cacheType string) ([]cacheFile, error) {
+	dir := h.entriesDir(cacheType)
+
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("while reading %s cache directory %s: %s", cacheType, dir, err)
+	}
+
+	files := make([]cacheFile, 0, len(infos))
+	for _, fi := range infos {
+		if !fi.Mode().IsRegular() || strings.HasPrefix(fi.Name(), "tmp_") {
+			continue
+		}
+		files = append(files, cacheFile{
+			path:    filepath.Join(dir, fi.Name()),
+			size:    fi.Size(),
+			lastUse: lastUse(fi),
+		})
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].lastUse.Before(files[j].lastUse)
+	})
+	return files, nil
+}
+
+// lastUse returns the last time a cache entry was used, the most recent
+// of its access and modification times.
+func lastUse(fi os.FileInfo) time.Time {
+	t := fi.ModTime()
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		if atime := time.Unix(st.Atim.Unix()); atime.After(t) {
+			t = atime
+		}
+	}
+	return t
+}
+
+// touch records a cache hit on the entry at path by updating its access
+// time, the modification time is preserved as it's the entry creation date.
+func touch(path string) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if err := os.Chtimes(path, time.Now(), fi.ModTime()); err != nil {
+		sylog.Debugf("Could not update access time of cache entry %s: %s", path, err)
+	}
+}
+
+// EnforceQuota removes the least recently used entries of the cache type
+// until its size fits in its quota. Entries used after keep, like the ones
+// of the image being pulled, are never removed.
+func (h *Handle) EnforceQuota(cacheType string, keep time.Time) error {
+	quota := h.Quota(cacheType)
+	if h.disabled || quota <= 0 {
+		return nil
+	}
+
+	files, err := h.listFiles(cacheType)
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, f := range files {
+		size += f.size
+	}
+
+	for _, f := range files {
+		if size <= quota {
+			break
+		}
+		if f.lastUse.After(keep) {
+			continue
+		}
+		sylog.Debugf("Evicting %s cache entry %s to fit in %s quota", cacheType, filepath.Base(f.path), fs.FindSize(quota))
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("while removing cache entry %s: %s", f.path, err)
+		}
+		size -= f.size
+	}
+	if size > quota {
+		sylog.Warningf("The %s cache uses %s, exceeding its %s quota", cacheType, fs.FindSize(size), fs.FindSize(quota))
+	}
+	return nil
+}
+
+// Stats returns the usage of all cache types.
+func (h *Handle) Stats() ([]Stats, error) {
+	if h.disabled {
+		return nil, nil
+	}
+
+	var stats []Stats
+	for _, cacheType := range append(OciCacheTypes, FileCacheTypes...) {
+		files, err := h.listFiles(cacheType)
+		if err != nil {
+			return nil, err
+		}
+		s := Stats{
+			Type:    cacheType,
+			Entries: len(files),
+			Quota:   h.Quota(cacheType),
+		}
+		for _, f := range files {
+			s.Size += f.size
+		}
+		if len(files) > 0 {
+			s.Oldest = &files[0].lastUse
+			s.Newest = &files[len(files)-1].lastUse
+		}
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseQuotas(t *testing.T) {
+	tests := []struct {
+		name     string
+		quotas   []string
+		expected map[string]int64
+		wantErr  bool
+	}{
+		{
+			name:     "Empty",
+			expected: map[string]int64{},
+		},
+		{
+			name:   "Units",
+			quotas: []string{"blob:20G", "library: 1.5MiB", "net:512", "oci:2k"},
+			expected: map[string]int64{
+				OciBlobCacheType: 20 << 30,
+				LibraryCacheType: 3 << 19,
+				NetCacheType:     512,
+				OciTempCacheType: 2 << 10,
+			},
+		},
+		{
+			name:     "Override",
+			quotas:   []string{"blob:20G", "blob:1G"},
+			expected: map[string]int64{OciBlobCacheType: 1 << 30},
+		},
+		{
+			name:    "UnknownType",
+			quotas:  []string{"build:1G"},
+			wantErr: true,
+		},
+		{
+			name:    "MissingSize",
+			quotas:  []string{"blob"},
+			wantErr: true,
+		},
+		{
+			name:    "InvalidSize",
+			quotas:  []string{"blob:G"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quotas, err := ParseQuotas(tt.quotas)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(quotas) != len(tt.expected) {
+				t.Fatalf("got %v, expected %v", quotas, tt.expected)
+			}
+			for k, v := range tt.expected {
+				if quotas[k] != v {
+					t.Errorf("got %s quota %d, expected %d", k, quotas[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestEnforceQuota(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache-quota-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	h, err := New(Config{
+		ParentDir: dir,
+		Quotas:    map[string]int64{LibraryCacheType: 25},
+	})
+	if err != nil {
+		t.Fatalf("failed to create cache handle: %s", err)
+	}
+	cacheDir, err := h.GetFileCacheDir(LibraryCacheType)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// entries of 10 bytes used from the oldest to the newest
+	now := time.Now()
+	names := []string{"old", "used", "new", "tmp_entry"}
+	for i, name := range names {
+		path := filepath.Join(cacheDir, name)
+		if err := ioutil.WriteFile(path, make([]byte, 10), 0600); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		when := now.Add(time.Duration(i-len(names)) * time.Hour)
+		if err := os.Chtimes(path, when, when); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	// a cache hit makes the entry the most recently used
+	e, err := h.GetEntry(LibraryCacheType, "used")
+	if err != nil || !e.Exists {
+		t.Fatalf("unexpected cache miss: %v", err)
+	}
+
+	stats, err := h.Stats()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, s := range stats {
+		if s.Type != LibraryCacheType {
+			continue
+		}
+		if s.Entries != 3 || s.Size != 30 || s.Quota != 25 {
+			t.Errorf("unexpected %s cache statistics: %+v", s.Type, s)
+		}
+	}
+
+	if err := h.EnforceQuota(LibraryCacheType, now); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, name := range names {
+		_, err := os.Stat(filepath.Join(cacheDir, name))
+		if name == "old" && !os.IsNotExist(err) {
+			t.Errorf("least recently used entry %s not evicted", name)
+		} else if name != "old" && err != nil {
+			t.Errorf("entry %s unexpectedly evicted: %v", name, err)
+		}
+	}
+
+	// entries used after keep are not evicted
+	h.quotas[LibraryCacheType] = 1
+	if err := h.EnforceQuota(LibraryCacheType, now.Add(-time.Minute)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "used")); err != nil {
+		t.Errorf("recently used entry evicted: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "new")); !os.IsNotExist(err) {
+		t.Errorf("entry new not evicted")
+	}
+}
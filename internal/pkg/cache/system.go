@@ -0,0 +1,214 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	"github.com/hpcng/singularity/pkg/sylog"
+	digest "github.com/opencontainers/go-digest"
+	"golang.org/x/sys/unix"
+)
+
+// systemOwner is the UID owning the system cache, changed by tests.
+var systemOwner uint32
+
+// checkSystemDir returns an error if the system cache directory
+// dir is not a directory only writable by its owner root.
+func checkSystemDir(dir string) error {
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return checkSystemOwner(dir, fi)
+}
+
+// checkSystemDirs returns an error if one of the directories of the
+// system cache leading to the path made of elem isn't only writable
+// by root.
+func (h *Handle) checkSystemDirs(elem ...string) error {
+	dir := h.systemDir
+	for _, e := range elem {
+		dir = filepath.Join(dir, e)
+		if err := checkSystemDir(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSystemOwner returns an error if the system cache file path
+// described by fi is not owned by root or writable by group or others.
+func checkSystemOwner(path string, fi os.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || st.Uid != systemOwner {
+		return fmt.Errorf("%s is not owned by root", path)
+	}
+	if fi.Mode().Perm()&022 != 0 {
+		return fmt.Errorf("%s is writable by group or others", path)
+	}
+	return nil
+}
+
+// HasSystemCache returns true if a system cache is consulted before
+// the user cache.
+func (h *Handle) HasSystemCache() bool {
+	return !h.disabled && h.systemDir != ""
+}
+
+// systemEntry returns the path of the system cache entry of the
+// cache type for hash, or an empty string if there is none.
+func (h *Handle) systemEntry(cacheType string, hash string) string {
+	if !h.HasSystemCache() {
+		return ""
+	}
+	if err := h.checkSystemDirs(cacheType); err != nil {
+		if !os.IsNotExist(err) {
+			sylog.Debugf("Ignoring system cache: %s", err)
+		}
+		return ""
+	}
+	path := filepath.Join(h.systemDir, cacheType, hash)
+	if isSystemFile(path) {
+		return path
+	}
+	return ""
+}
+
+// isSystemFile returns true if path is a regular file of the
+// system cache owned by root and readable by the current user.
+func isSystemFile(path string) bool {
+	fi, err := os.Lstat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+	if err := checkSystemOwner(path, fi); err != nil {
+		sylog.Debugf("Ignoring system cache entry: %s", err)
+		return false
+	}
+	return unix.Access(path, unix.R_OK) == nil
+}
+
+// verifyBlob returns an error if the content of the blob
+// at path doesn't match its digest d.
+func verifyBlob(path string, d digest.Digest) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	got, err := d.Algorithm().FromReader(f)
+	if err != nil {
+		return fmt.Errorf("while reading %s: %s", path, err)
+	}
+	if got != d {
+		return fmt.Errorf("%s digest is %s", path, got)
+	}
+	return nil
+}
+
+// LinkSystemBlobs links the blobs of the system cache having one
+// of the digests into the user blob cache, so that they are reused
+// instead of being fetched again. Blobs already in the user cache
+// are left untouched. System blobs not matching their digest are
+// ignored.
+func (h *Handle) LinkSystemBlobs(digests []string) error {
+	if !h.HasSystemCache() {
+		return nil
+	}
+
+	systemDir := filepath.Join(h.systemDir, OciBlobCacheType, "blobs")
+	userDir := filepath.Join(h.getCacheTypeDir(OciBlobCacheType), "blobs")
+
+	for _, s := range digests {
+		d, err := digest.Parse(s)
+		if err != nil {
+			continue
+		}
+		algo, hex := d.Algorithm().String(), d.Hex()
+		if !isPathElement(algo) || !isPathElement(hex) {
+			continue
+		}
+		if err := h.checkSystemDirs(OciBlobCacheType, "blobs", algo); err != nil {
+			if !os.IsNotExist(err) {
+				sylog.Debugf("Ignoring system cache blobs: %s", err)
+			}
+			continue
+		}
+		src := filepath.Join(systemDir, algo, hex)
+		if !isSystemFile(src) {
+			continue
+		}
+		dst := filepath.Join(userDir, algo, hex)
+		if _, err := os.Lstat(dst); err == nil {
+			continue
+		}
+		if err := verifyBlob(src, d); err != nil {
+			sylog.Warningf("Ignoring corrupted system cache blob %s: %s", d, err)
+			continue
+		}
+		if err := fs.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			return fmt.Errorf("while creating blob cache directory: %s", err)
+		}
+		sylog.Debugf("Using blob %s from the system cache", d)
+		if err := os.Symlink(src, dst); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("while linking system cache blob %s: %s", d, err)
+		}
+	}
+	return nil
+}
+
+// isPathElement returns true if s is a single path element.
+func isPathElement(s string) bool {
+	return s != "" && s != "." && s != ".." && !strings.ContainsRune(s, os.PathSeparator)
+}
+
+// IsShared returns true if the cache is the system cache itself, in
+// which case its content is made readable by all users.
+func (h *Handle) IsShared() bool {
+	return !h.disabled && h.shared
+}
+
+// publish makes the cache entry at path readable by all users
+// if the cache is the system cache.
+func (h *Handle) publish(path string) error {
+	if !h.IsShared() {
+		return nil
+	}
+	return os.Chmod(path, 0644)
+}
+
+// PublishBlobs makes the blob cache content readable by all users
+// if the cache is the system cache.
+func (h *Handle) PublishBlobs() error {
+	if !h.IsShared() {
+		return nil
+	}
+	return filepath.Walk(h.getCacheTypeDir(OciBlobCacheType), func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(0644)
+		if fi.IsDir() {
+			mode = 0755
+		} else if !fi.Mode().IsRegular() {
+			return nil
+		}
+		if fi.Mode().Perm() == mode {
+			return nil
+		}
+		return os.Chmod(path, mode)
+	})
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+func TestSystemCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache-system-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the system cache is owned by the user running the test
+	defer func(uid uint32) { systemOwner = uid }(systemOwner)
+	systemOwner = uint32(os.Getuid())
+
+	systemParent := filepath.Join(dir, "system")
+	systemDir := filepath.Join(systemParent, SubDirName)
+
+	// the administrator populates the system cache
+	sh, err := New(Config{ParentDir: systemParent, SystemDir: systemDir})
+	if err != nil {
+		t.Fatalf("failed to create system cache handle: %s", err)
+	}
+	if !sh.IsShared() || sh.HasSystemCache() {
+		t.Fatalf("system cache not detected")
+	}
+	e, err := sh.GetEntry(LibraryCacheType, "image")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := ioutil.WriteFile(e.TmpPath, []byte("sif"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := e.Finalize(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, path := range []string{systemDir, filepath.Dir(e.Path), e.Path} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if fi.Mode().Perm()&0044 != 0044 {
+			t.Errorf("%s is not readable by all users: %s", path, fi.Mode())
+		}
+	}
+
+	blobDigest := digest.FromString("layer")
+	blob := filepath.Join(systemDir, OciBlobCacheType, "blobs", "sha256", blobDigest.Hex())
+	if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := ioutil.WriteFile(blob, []byte("layer"), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	corruptedDigest := digest.FromString("other")
+	corrupted := filepath.Join(filepath.Dir(blob), corruptedDigest.Hex())
+	if err := ioutil.WriteFile(corrupted, []byte("layer"), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// users consult the system cache first
+	uh, err := New(Config{ParentDir: filepath.Join(dir, "user"), SystemDir: systemDir})
+	if err != nil {
+		t.Fatalf("failed to create user cache handle: %s", err)
+	}
+	if uh.IsShared() || !uh.HasSystemCache() {
+		t.Fatalf("system cache not configured")
+	}
+	e, err = uh.GetEntry(LibraryCacheType, "image")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !e.Exists || e.Path != filepath.Join(systemDir, LibraryCacheType, "image") {
+		t.Errorf("system cache entry not used: %+v", e)
+	}
+	e, err = uh.GetEntry(LibraryCacheType, "other")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer e.CleanTmp()
+	if e.Exists || filepath.Dir(e.Path) != filepath.Join(dir, "user", SubDirName, LibraryCacheType) {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+
+	missing := digest.FromString("missing")
+	digests := []string{blobDigest.String(), corruptedDigest.String(), missing.String(), "sha256:../1234"}
+	if err := uh.LinkSystemBlobs(digests); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	userBlobs := filepath.Join(uh.getCacheTypeDir(OciBlobCacheType), "blobs", "sha256")
+	if target, err := os.Readlink(filepath.Join(userBlobs, blobDigest.Hex())); err != nil || target != blob {
+		t.Errorf("system blob not linked: %v", err)
+	}
+	for _, d := range []digest.Digest{corruptedDigest, missing} {
+		if _, err := os.Lstat(filepath.Join(userBlobs, d.Hex())); !os.IsNotExist(err) {
+			t.Errorf("unexpected link for blob %s", d)
+		}
+	}
+
+	// entries of a system cache writable by users are ignored
+	if err := os.Chmod(filepath.Join(systemDir, LibraryCacheType), 0777); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	e, err = uh.GetEntry(LibraryCacheType, "image")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer e.CleanTmp()
+	if e.Exists {
+		t.Errorf("entry of a writable system cache used: %+v", e)
+	}
+
+	// system caches not owned by root are ignored
+	systemOwner++
+	uh, err = New(Config{ParentDir: filepath.Join(dir, "user"), SystemDir: systemDir})
+	if err != nil {
+		t.Fatalf("failed to create user cache handle: %s", err)
+	}
+	if uh.HasSystemCache() {
+		t.Errorf("system cache not owned by root used")
+	}
+}
@@ -1,4 +1,4 @@
-// Copyright (c) 2018, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -6,30 +6,118 @@
 package cgroups
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/containerd/cgroups"
+	cgroupsv2 "github.com/containerd/cgroups/v2"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 )
 
+// unifiedMountPoint is the cgroups v2 hierarchy mount point.
+const unifiedMountPoint = "/sys/fs/cgroup"
+
 // Manager manage container cgroup resources restriction
 type Manager struct {
-	Path   string
-	Pid    int
-	cgroup cgroups.Cgroup
+	Path    string
+	Pid     int
+	cgroup  cgroups.Cgroup
+	unified *cgroupsv2.Manager
+	group   string
+}
+
+// IsUnified returns whether the host uses the cgroups v2 unified
+// hierarchy only.
+func IsUnified() bool {
+	return cgroups.Mode() == cgroups.Unified
+}
+
+// DelegatedPath returns the topmost cgroup, ancestor of the current
+// process cgroup, delegated to the user uid. With cgroups v2 and systemd
+// this is usually the user@UID.service cgroup delegated to the user
+// where unprivileged users are allowed to create sub-cgroups.
+func DelegatedPath(uid uint32) (string, error) {
+	if !IsUnified() {
+		return "", fmt.Errorf("cgroups v2 unified hierarchy is required for unprivileged users")
+	}
+	current, err := cgroupsv2.NestedGroupPath("")
+	if err != nil {
+		return "", fmt.Errorf("while reading current process cgroup: %s", err)
+	}
+	return delegatedPath(unifiedMountPoint, current, uid)
+}
+
+// delegatedPath returns the topmost ancestor of the cgroup current,
+// itself included, delegated to the user uid in the hierarchy mounted
+// at root.
+func delegatedPath(root, current string, uid uint32) (string, error) {
+	// a cgroup outside of the cgroup namespace root is reported
+	// with a relative path going up the hierarchy
+	if !filepath.IsAbs(current) || filepath.Clean(current) != current || strings.Contains(current, "/../") || strings.HasSuffix(current, "/..") {
+		return "", fmt.Errorf("current process cgroup %q is outside of the cgroup namespace", current)
+	}
+
+	delegated := ""
+	for path := current; path != "/"; path = filepath.Dir(path) {
+		if !isDelegated(filepath.Join(root, path), uid) {
+			break
+		}
+		delegated = path
+	}
+	if delegated == "" {
+		return "", fmt.Errorf("no cgroup delegated to user in %s hierarchy", current)
+	}
+	return delegated, nil
 }
 
-func readSpecFromFile(path string) (spec specs.LinuxResources, err error) {
+// isDelegated returns whether the cgroup directory and the interface
+// files required to create sub-cgroups and move processes are owned
+// by the user uid.
+func isDelegated(dir string, uid uint32) bool {
+	for _, path := range []string{dir, filepath.Join(dir, "cgroup.procs"), filepath.Join(dir, "cgroup.subtree_control")} {
+		fi, err := os.Lstat(path)
+		if err != nil {
+			return false
+		}
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok || st.Uid != uid {
+			return false
+		}
+		if path == dir && !fi.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadSpecFromFile reads the cgroups resources restriction from a TOML
+// configuration file or an OCI linux resources JSON file.
+func ReadSpecFromFile(path string) (spec specs.LinuxResources, err error) {
+	// JSON file contains an OCI linux resources specification
+	var data []byte
+	if data, err = ioutil.ReadFile(path); err != nil {
+		return
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		err = json.Unmarshal(data, &spec)
+		return
+	}
+
 	conf, err := LoadConfig(path)
 	if err != nil {
 		return
 	}
 
 	// convert TOML structures to OCI JSON structures
-	data, err := json.Marshal(conf)
+	data, err = json.Marshal(conf)
 	if err != nil {
 		return
 	}
@@ -43,6 +131,9 @@ func readSpecFromFile(path string) (spec specs.LinuxResources, err error) {
 
 // GetCgroupRootPath returns cgroup root path
 func (m *Manager) GetCgroupRootPath() string {
+	if m.unified != nil {
+		return unifiedMountPoint
+	}
 	if m.cgroup == nil {
 		return ""
 	}
@@ -68,13 +159,22 @@ func (m *Manager) ApplyFromSpec(spec *specs.LinuxResources) (err error) {
 		return fmt.Errorf("cgroup path must be an absolute path")
 	}
 
-	path = cgroups.StaticPath(m.Path)
-
 	s := spec
 	if s == nil {
 		s = &specs.LinuxResources{}
 	}
 
+	if IsUnified() {
+		m.unified, err = cgroupsv2.NewManager(unifiedMountPoint, m.Path, cgroupsv2.ToResources(s))
+		if err != nil {
+			return err
+		}
+		m.group = m.Path
+		return m.unified.AddProc(uint64(m.Pid))
+	}
+
+	path = cgroups.StaticPath(m.Path)
+
 	// creates cgroup
 	m.cgroup, err = cgroups.New(cgroups.V1, path, s)
 	if err != nil {
@@ -89,9 +189,9 @@ func (m *Manager) ApplyFromSpec(spec *specs.LinuxResources) (err error) {
 }
 
 // ApplyFromFile applies cgroups resources restriction from TOML configuration
-// file
+// file or OCI linux resources JSON file
 func (m *Manager) ApplyFromFile(path string) error {
-	spec, err := readSpecFromFile(path)
+	spec, err := ReadSpecFromFile(path)
 	if err != nil {
 		return err
 	}
@@ -102,53 +202,197 @@ func (m *Manager) loadFromPid() (err error) {
 	if m.Pid == 0 {
 		return fmt.Errorf("no process ID specified")
 	}
+	if IsUnified() {
+		group, err := cgroupsv2.PidGroupPath(m.Pid)
+		if err != nil {
+			return err
+		}
+		m.unified, err = cgroupsv2.LoadManager(unifiedMountPoint, group)
+		m.group = group
+		return err
+	}
 	path := cgroups.PidPath(m.Pid)
 	m.cgroup, err = cgroups.Load(cgroups.V1, path)
 	return
 }
 
+func (m *Manager) loadFromPath() (err error) {
+	if m.Path == "" {
+		return fmt.Errorf("no cgroup path specified")
+	}
+	if IsUnified() {
+		m.unified, err = cgroupsv2.LoadManager(unifiedMountPoint, m.Path)
+		m.group = m.Path
+		return err
+	}
+	m.cgroup, err = cgroups.Load(cgroups.V1, cgroups.StaticPath(m.Path))
+	return
+}
+
 // UpdateFromSpec updates cgroups resources restriction from OCI specification
 func (m *Manager) UpdateFromSpec(spec *specs.LinuxResources) (err error) {
-	if m.cgroup == nil {
+	if m.cgroup == nil && m.unified == nil {
 		if err = m.loadFromPid(); err != nil {
 			return
 		}
 	}
+	if m.unified != nil {
+		// creating the manager for an existing cgroup only
+		// writes the resources values
+		m.unified, err = cgroupsv2.NewManager(unifiedMountPoint, m.group, cgroupsv2.ToResources(spec))
+		return
+	}
 	err = m.cgroup.Update(spec)
 	return
 }
 
 // UpdateFromFile updates cgroups resources restriction from TOML configuration
+// file or OCI linux resources JSON file
 func (m *Manager) UpdateFromFile(path string) error {
-	spec, err := readSpecFromFile(path)
+	spec, err := ReadSpecFromFile(path)
 	if err != nil {
 		return err
 	}
 	return m.UpdateFromSpec(&spec)
 }
 
-// Remove removes resources restriction for current managed process
+// Remove removes resources restriction for current managed process,
+// the cgroup is loaded from the manager path if it was not applied by
+// this manager
 func (m *Manager) Remove() error {
+	if m.cgroup == nil && m.unified == nil {
+		if err := m.loadFromPath(); err != nil {
+			return err
+		}
+	}
 	// deletes subgroup
+	if m.unified != nil {
+		return m.unified.Delete()
+	}
 	return m.cgroup.Delete()
 }
 
 // Pause suspends all processes inside the container
 func (m *Manager) Pause() error {
-	if m.cgroup == nil {
+	if m.cgroup == nil && m.unified == nil {
 		if err := m.loadFromPid(); err != nil {
 			return err
 		}
 	}
+	if m.unified != nil {
+		return m.unified.Freeze()
+	}
 	return m.cgroup.Freeze()
 }
 
 // Resume resumes all processes that have been previously paused
 func (m *Manager) Resume() error {
-	if m.cgroup == nil {
+	if m.cgroup == nil && m.unified == nil {
 		if err := m.loadFromPid(); err != nil {
 			return err
 		}
 	}
+	if m.unified != nil {
+		return m.unified.Thaw()
+	}
 	return m.cgroup.Thaw()
 }
+
+// OOMKills returns the number of processes of the managed cgroup killed
+// by the kernel OOM killer, the cgroup is loaded from the manager path if
+// it was not applied by this manager.
+func (m *Manager) OOMKills() (uint64, error) {
+	if m.cgroup == nil && m.unified == nil {
+		if err := m.loadFromPath(); err != nil {
+			return 0, err
+		}
+	}
+	if m.unified != nil {
+		st, err := m.unified.Stat()
+		if err != nil {
+			return 0, err
+		}
+		if st.MemoryEvents == nil {
+			return 0, nil
+		}
+		return st.MemoryEvents.OomKill, nil
+	}
+	st, err := m.cgroup.Stat(cgroups.IgnoreNotExist)
+	if err != nil {
+		return 0, err
+	}
+	if st.MemoryOomControl == nil {
+		return 0, nil
+	}
+	return st.MemoryOomControl.OomKill, nil
+}
+
+// Usage holds the resource usage accounted in a cgroup.
+type Usage struct {
+	// CPUTime is the CPU time consumed by the cgroup processes.
+	CPUTime time.Duration
+	// MemoryPeak is the maximum memory usage in bytes, it's not
+	// reported with cgroups v2 on kernels without memory.peak.
+	MemoryPeak uint64
+	// IORead and IOWrite are the number of bytes read from and
+	// written to block devices.
+	IORead  uint64
+	IOWrite uint64
+}
+
+// Usage returns the resource usage accounted in the managed cgroup, the
+// cgroup is loaded from the manager path if it was not applied by this
+// manager.
+func (m *Manager) Usage() (*Usage, error) {
+	if m.cgroup == nil && m.unified == nil {
+		if err := m.loadFromPath(); err != nil {
+			return nil, err
+		}
+	}
+
+	u := new(Usage)
+
+	if m.unified != nil {
+		st, err := m.unified.Stat()
+		if err != nil {
+			return nil, err
+		}
+		if st.CPU != nil {
+			u.CPUTime = time.Duration(st.CPU.UsageUsec) * time.Microsecond
+		}
+		if st.Io != nil {
+			for _, e := range st.Io.Usage {
+				u.IORead += e.Rbytes
+				u.IOWrite += e.Wbytes
+			}
+		}
+		// memory.peak is available since Linux 5.19
+		b, err := ioutil.ReadFile(filepath.Join(unifiedMountPoint, m.group, "memory.peak"))
+		if err == nil {
+			u.MemoryPeak, _ = strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+		}
+		return u, nil
+	}
+
+	st, err := m.cgroup.Stat(cgroups.IgnoreNotExist)
+	if err != nil {
+		return nil, err
+	}
+	if st.CPU != nil && st.CPU.Usage != nil {
+		u.CPUTime = time.Duration(st.CPU.Usage.Total)
+	}
+	if st.Memory != nil && st.Memory.Usage != nil {
+		u.MemoryPeak = st.Memory.Usage.Max
+	}
+	if st.Blkio != nil {
+		for _, e := range st.Blkio.IoServiceBytesRecursive {
+			switch e.Op {
+			case "Read":
+				u.IORead += e.Value
+			case "Write":
+				u.IOWrite += e.Value
+			}
+		}
+	}
+	return u, nil
+}
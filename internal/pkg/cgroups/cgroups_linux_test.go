@@ -194,3 +194,126 @@ func TestPauseResume(t *testing.T) {
 
 	cmd.Wait()
 }
+
+func TestReadSpecFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cgroups-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"toml", "[cpu]\nshares = 512\n[pids]\nlimit = 16"},
+		{"json", `{"cpu": {"shares": 512}, "pids": {"limit": 16}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "cgroups."+tt.name)
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			spec, err := ReadSpecFromFile(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if spec.CPU == nil || spec.CPU.Shares == nil || *spec.CPU.Shares != 512 {
+				t.Errorf("cpu shares should be equal to 512")
+			}
+			if spec.Pids == nil || spec.Pids.Limit != 16 {
+				t.Errorf("pids limit should be equal to 16")
+			}
+		})
+	}
+}
+
+func TestDelegatedPath(t *testing.T) {
+	root, err := ioutil.TempDir("", "cgroups-root-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// fake hierarchy where /user.slice is owned by another user
+	for _, dir := range []string{"user.slice/user@1000.service/app.slice", "user.slice/user@1000.service/other.slice"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, dir := range []string{"user.slice", "user.slice/user@1000.service", "user.slice/user@1000.service/app.slice"} {
+		for _, f := range []string{"cgroup.procs", "cgroup.subtree_control"} {
+			if err := ioutil.WriteFile(filepath.Join(root, dir, f), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	uid := uint32(os.Getuid())
+	other := uid + 1
+	if os.Getuid() == 0 {
+		if err := os.Chown(filepath.Join(root, "user.slice"), int(other), int(other)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		current  string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "Delegated",
+			current:  "/user.slice/user@1000.service/app.slice",
+			expected: "/user.slice/user@1000.service",
+		},
+		{
+			// cgroup.procs and cgroup.subtree_control are missing
+			name:    "NotDelegated",
+			current: "/user.slice/user@1000.service/other.slice",
+			wantErr: true,
+		},
+		{
+			name:    "Missing",
+			current: "/user.slice/missing",
+			wantErr: true,
+		},
+		{
+			name:    "Relative",
+			current: "user.slice/user@1000.service",
+			wantErr: true,
+		},
+		{
+			name:    "OutsideNamespace",
+			current: "/../user.slice/user@1000.service/app.slice",
+			wantErr: true,
+		},
+		{
+			name:    "Root",
+			current: "/",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.name == "Delegated" && os.Getuid() != 0 {
+				// without privileges /user.slice can't be owned by
+				// another user and is reported as delegated
+				tt.expected = "/user.slice"
+			}
+			path, err := delegatedPath(root, tt.current, uid)
+			if err != nil && !tt.wantErr {
+				t.Fatalf("unexpected error: %s", err)
+			} else if err == nil && tt.wantErr {
+				t.Fatalf("unexpected success with path %s", path)
+			}
+			if path != tt.expected {
+				t.Errorf("got %q instead of %q", path, tt.expected)
+			}
+		})
+	}
+}
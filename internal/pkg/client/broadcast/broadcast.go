@@ -0,0 +1,263 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package broadcast distributes image files across cluster nodes with a
+// pipelined tree broadcast: each node writes the image it receives while
+// forwarding it to its children, the image is read once from its source
+// and each node receives it only once, whatever the number of nodes.
+package broadcast
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+// DefaultFanout is the default number of children of each node.
+const DefaultFanout = 4
+
+// Config describes how an image is broadcast to nodes.
+type Config struct {
+	// Nodes are the nodes receiving the image.
+	Nodes []string
+	// Fanout is the number of children each node forwards the image to.
+	Fanout int
+	// Rsh is the remote shell command used to execute the receiving
+	// command on the children, like ssh.
+	Rsh []string
+	// Command is the singularity command executed on the children.
+	Command string
+}
+
+// Split splits nodes into at most fanout subtrees of nearly equal
+// size, the first node of a subtree receives the image and forwards
+// it to the other nodes of its subtree.
+func Split(nodes []string, fanout int) [][]string {
+	if fanout < 1 {
+		fanout = 1
+	}
+	if len(nodes) < fanout {
+		fanout = len(nodes)
+	}
+
+	trees := make([][]string, 0, fanout)
+	for i := 0; i < fanout; i++ {
+		start := i * len(nodes) / fanout
+		end := (i + 1) * len(nodes) / fanout
+		trees = append(trees, nodes[start:end])
+	}
+	return trees
+}
+
+// shellQuote quotes s for the remote shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shellJoin quotes and joins args in a command line.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// childCommand returns the command executed to send the image with digest
+// to the first node of tree, receiving it at dest and forwarding it to
+// the other nodes of tree with the same remote shell.
+func (c *Config) childCommand(tree []string, dest, digest string) []string {
+	remote := []string{
+		c.Command, "prefetch", "--receive",
+		"--digest", digest,
+		"--fanout", strconv.Itoa(c.Fanout),
+	}
+	if len(tree) > 1 {
+		remote = append(remote,
+			"--nodes", strings.Join(tree[1:], ","),
+			"--rsh", shellJoin(c.Rsh),
+		)
+	}
+	remote = append(remote, dest)
+
+	// the remote shell joins its arguments in a command line
+	args := append([]string{}, c.Rsh...)
+	args = append(args, tree[0], shellJoin(remote))
+	return args
+}
+
+// child is a subtree receiving the image.
+type child struct {
+	node string
+	cmd  *exec.Cmd
+	in   io.WriteCloser
+	err  error
+}
+
+// Write forwards data to the child, a child failing to receive the image
+// is reported once the broadcast is done so the other children can still
+// receive it.
+func (c *child) Write(p []byte) (int, error) {
+	if c.err == nil {
+		if _, err := c.in.Write(p); err != nil {
+			c.err = err
+		}
+	}
+	return len(p), nil
+}
+
+// startChildren starts the commands sending the image to the subtrees.
+func (c *Config) startChildren(ctx context.Context, dest, digest string) ([]*child, error) {
+	var children []*child
+
+	for _, tree := range Split(c.Nodes, c.Fanout) {
+		args := c.childCommand(tree, dest, digest)
+		sylog.Debugf("Sending image to %s: %s", tree[0], strings.Join(args, " "))
+
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		in, err := cmd.StdinPipe()
+		if err != nil {
+			return children, fmt.Errorf("while creating pipe for node %s: %s", tree[0], err)
+		}
+		if err := cmd.Start(); err != nil {
+			return children, fmt.Errorf("while sending image to node %s: %s", tree[0], err)
+		}
+		children = append(children, &child{node: tree[0], cmd: cmd, in: in})
+	}
+	return children, nil
+}
+
+// waitChildren waits for the children and returns an error listing
+// the nodes which failed to receive the image.
+func waitChildren(children []*child) error {
+	var failed []string
+
+	for _, c := range children {
+		c.in.Close()
+		if err := c.cmd.Wait(); err != nil && c.err == nil {
+			c.err = err
+		}
+		if c.err != nil {
+			sylog.Errorf("Node %s failed to receive the image: %s", c.node, c.err)
+			failed = append(failed, c.node)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("image not received by node(s) %s and possibly by their children", strings.Join(failed, ","))
+	}
+	return nil
+}
+
+// Send broadcasts the image file at path to the nodes, where it's
+// written at dest.
+func (c *Config) Send(ctx context.Context, path, dest string) error {
+	if len(c.Nodes) == 0 {
+		return nil
+	}
+
+	digest, err := fileDigest(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("while opening image %s: %s", path, err)
+	}
+	defer f.Close()
+
+	children, err := c.startChildren(ctx, dest, digest)
+	if err != nil {
+		waitChildren(children)
+		return err
+	}
+
+	writers := make([]io.Writer, len(children))
+	for i, ch := range children {
+		writers[i] = ch
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), f); err != nil {
+		waitChildren(children)
+		return fmt.Errorf("while reading image %s: %s", path, err)
+	}
+	return waitChildren(children)
+}
+
+// Receive writes the image read from r at dest while forwarding it to
+// the nodes. The image is renamed to dest once its digest is verified.
+func (c *Config) Receive(ctx context.Context, r io.Reader, dest, digest string) error {
+	tmp, err := fs.MakeTmpFile(filepath.Dir(dest), "."+filepath.Base(dest)+"-", 0644)
+	if err != nil {
+		return fmt.Errorf("while creating temporary file for %s: %s", dest, err)
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	var children []*child
+	if len(c.Nodes) > 0 {
+		children, err = c.startChildren(ctx, dest, digest)
+		if err != nil {
+			waitChildren(children)
+			return err
+		}
+	}
+
+	h := sha256.New()
+	writers := []io.Writer{tmp, h}
+	for _, ch := range children {
+		writers = append(writers, ch)
+	}
+
+	// children receiving a truncated image reject it
+	waited := false
+	defer func() {
+		if !waited {
+			waitChildren(children)
+		}
+	}()
+
+	if _, err := io.Copy(io.MultiWriter(writers...), r); err != nil {
+		return fmt.Errorf("while receiving image: %s", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("while writing %s: %s", tmp.Name(), err)
+	}
+	if d := "sha256:" + hex.EncodeToString(h.Sum(nil)); d != digest {
+		return fmt.Errorf("received image digest %s doesn't match expected digest %s", d, digest)
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return fmt.Errorf("while writing %s: %s", dest, err)
+	}
+	waited = true
+	return waitChildren(children)
+}
+
+// fileDigest returns the SHA256 digest of the file at path.
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("while opening image %s: %s", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("while reading image %s: %s", path, err)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
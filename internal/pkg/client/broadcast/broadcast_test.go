@@ -0,0 +1,163 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package broadcast
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandNodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		list     string
+		expected []string
+		wantErr  bool
+	}{
+		{
+			name:     "List",
+			list:     "n1, n2,,n1",
+			expected: []string{"n1", "n2"},
+		},
+		{
+			name:     "Range",
+			list:     "node[08-10,3],gpu1",
+			expected: []string{"node08", "node09", "node10", "node3", "gpu1"},
+		},
+		{
+			name:     "Ranges",
+			list:     "r[1-2]n[1-2]",
+			expected: []string{"r1n1", "r1n2", "r2n1", "r2n2"},
+		},
+		{
+			name:    "Reversed",
+			list:    "node[3-1]",
+			wantErr: true,
+		},
+		{
+			name:    "Unbalanced",
+			list:    "node1]",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes, err := ExpandNodes(tt.list)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(nodes, tt.expected) {
+				t.Errorf("got %v, expected %v", nodes, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	nodes := []string{"n1", "n2", "n3", "n4", "n5"}
+
+	trees := Split(nodes, 2)
+	expected := [][]string{{"n1", "n2"}, {"n3", "n4", "n5"}}
+	if !reflect.DeepEqual(trees, expected) {
+		t.Errorf("got %v, expected %v", trees, expected)
+	}
+	if trees := Split(nodes[:1], 4); len(trees) != 1 {
+		t.Errorf("got %d trees for a single node", len(trees))
+	}
+}
+
+func TestChildCommand(t *testing.T) {
+	c := &Config{
+		Fanout:  2,
+		Rsh:     []string{"ssh", "-x"},
+		Command: "singularity",
+	}
+	args := c.childCommand([]string{"n1", "n2", "n3"}, "/tmp/it's.sif", "sha256:1234")
+	expected := []string{
+		"ssh", "-x", "n1",
+		`'singularity' 'prefetch' '--receive' '--digest' 'sha256:1234' '--fanout' '2' '--nodes' 'n2,n3' '--rsh' ''\''ssh'\'' '\''-x'\''' '/tmp/it'\''s.sif'`,
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("got %q, expected %q", args, expected)
+	}
+
+	// leaves don't forward the image
+	args = c.childCommand([]string{"n1"}, "/tmp/image.sif", "sha256:1234")
+	expected = []string{
+		"ssh", "-x", "n1",
+		`'singularity' 'prefetch' '--receive' '--digest' 'sha256:1234' '--fanout' '2' '/tmp/image.sif'`,
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("got %q, expected %q", args, expected)
+	}
+}
+
+func TestSendReceive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "broadcast-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	image := filepath.Join(dir, "image.sif")
+	data := bytes.Repeat([]byte("image"), 100000)
+	if err := ioutil.WriteFile(image, data, 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	digest, err := fileDigest(image)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the remote shell writes the image received by each child
+	rsh := filepath.Join(dir, "rsh")
+	script := "#!/bin/sh\ncat > \"" + dir + "/$1\"\n"
+	if err := ioutil.WriteFile(rsh, []byte(script), 0755); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c := &Config{
+		Nodes:   []string{"n1", "n2", "n3"},
+		Fanout:  2,
+		Rsh:     []string{rsh},
+		Command: "singularity",
+	}
+	if err := c.Send(context.Background(), image, "/dest"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, node := range []string{"n1", "n2"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, node))
+		if err != nil || !bytes.Equal(b, data) {
+			t.Errorf("image not sent to %s: %v", node, err)
+		}
+	}
+
+	dest := filepath.Join(dir, "received.sif")
+	c = &Config{}
+	if err := c.Receive(context.Background(), bytes.NewReader(data), dest, "sha256:0000"); err == nil {
+		t.Errorf("unexpected success for a wrong digest")
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("image with a wrong digest written")
+	}
+	if err := c.Receive(context.Background(), bytes.NewReader(data), dest, digest); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if b, err := ioutil.ReadFile(dest); err != nil || !bytes.Equal(b, data) {
+		t.Errorf("image not received: %v", err)
+	}
+}
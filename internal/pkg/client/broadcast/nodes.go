@@ -0,0 +1,113 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package broadcast
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxRangeNodes is the maximum number of nodes a range can expand to.
+const maxRangeNodes = 100000
+
+// ExpandNodes expands a comma separated list of nodes, where nodes can be
+// described by ranges like the compressed host lists of resource managers,
+// e.g. node[01-03,07] expands to node01, node02, node03 and node07.
+// Duplicate nodes are removed.
+func ExpandNodes(list string) ([]string, error) {
+	var nodes []string
+	seen := make(map[string]bool)
+
+	for _, elem := range splitList(list) {
+		expanded, err := expandRange(elem)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range expanded {
+			if !seen[n] {
+				seen[n] = true
+				nodes = append(nodes, n)
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// splitList splits list on the commas which are not within brackets.
+func splitList(list string) []string {
+	var elems []string
+
+	depth, start := 0, 0
+	for i, c := range list {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				elems = append(elems, list[start:i])
+				start = i + 1
+			}
+		}
+	}
+	elems = append(elems, list[start:])
+
+	var nonEmpty []string
+	for _, e := range elems {
+		if e = strings.TrimSpace(e); e != "" {
+			nonEmpty = append(nonEmpty, e)
+		}
+	}
+	return nonEmpty
+}
+
+// expandRange expands the range of the node description elem.
+func expandRange(elem string) ([]string, error) {
+	open := strings.Index(elem, "[")
+	if open < 0 {
+		if strings.Contains(elem, "]") {
+			return nil, fmt.Errorf("invalid node range %s", elem)
+		}
+		return []string{elem}, nil
+	}
+	end := strings.Index(elem, "]")
+	if end < open {
+		return nil, fmt.Errorf("invalid node range %s", elem)
+	}
+	prefix, ranges, suffix := elem[:open], elem[open+1:end], elem[end+1:]
+
+	// the suffix may hold other ranges
+	suffixes, err := expandRange(suffix)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []string
+	for _, r := range strings.Split(ranges, ",") {
+		bounds := strings.SplitN(r, "-", 2)
+		if len(bounds) == 1 {
+			bounds = append(bounds, bounds[0])
+		}
+		first, err1 := strconv.Atoi(bounds[0])
+		last, err2 := strconv.Atoi(bounds[1])
+		if err1 != nil || err2 != nil || first < 0 || last < first || last-first >= maxRangeNodes {
+			return nil, fmt.Errorf("invalid node range %s in %s", r, elem)
+		}
+		// keep the zero padding of the range bounds
+		width := len(bounds[0])
+		for i := first; i <= last; i++ {
+			for _, s := range suffixes {
+				nodes = append(nodes, fmt.Sprintf("%s%0*d%s", prefix, width, i, s))
+			}
+		}
+		if len(nodes) > maxRangeNodes {
+			return nil, fmt.Errorf("node range %s expands to too many nodes", elem)
+		}
+	}
+	return nodes, nil
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package mirror rewrites the references of the images pulled from a
+// registry to the references of the same images on the mirrors of the
+// registry, which are tried in order before the registry itself.
+package mirror
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/util/singularityconf"
+)
+
+// dockerHubAliases are the host names of Docker Hub normalized to the
+// docker.io domain of the image references.
+var dockerHubAliases = map[string]bool{
+	"index.docker.io":      true,
+	"registry-1.docker.io": true,
+}
+
+// Mirrors holds the mirrors of registries, in the order they are tried.
+type Mirrors map[string][]string
+
+// normalizeRegistry returns the domain of the registry host as found in
+// the normalized image references.
+func normalizeRegistry(host string) string {
+	host = strings.ToLower(host)
+	if dockerHubAliases[host] {
+		return "docker.io"
+	}
+	return host
+}
+
+// Parse parses the mirror entries of the form <registry>=<mirror>, where
+// mirror is a registry host optionally followed by a repository path prefix
+// (eg: docker.io=harbor.example.com/dockerhub-proxy). The mirrors of a
+// registry are tried in the order of the entries.
+func Parse(entries []string) (Mirrors, error) {
+	m := make(Mirrors)
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid registry mirror %q: must be of the form <registry>=<mirror>", e)
+		}
+		registry := strings.TrimSpace(parts[0])
+		mirror := strings.TrimRight(strings.TrimSpace(parts[1]), "/")
+		if registry == "" || mirror == "" {
+			return nil, fmt.Errorf("invalid registry mirror %q: must be of the form <registry>=<mirror>", e)
+		}
+		if strings.Contains(registry, "/") || strings.Contains(mirror, "://") {
+			return nil, fmt.Errorf("invalid registry mirror %q: registry and mirror must be given without scheme", e)
+		}
+		// the mirror must be usable as a reference prefix
+		if _, err := reference.ParseNamed(mirror + "/image"); err != nil {
+			return nil, fmt.Errorf("invalid registry mirror %q: %s", e, err)
+		}
+		registry = normalizeRegistry(registry)
+		m[registry] = append(m[registry], mirror)
+	}
+	return m, nil
+}
+
+// Configured returns the mirrors set by the registry mirror directives of
+// the current configuration, invalid mirrors are ignored.
+func Configured() Mirrors {
+	c := singularityconf.GetCurrentConfig()
+	if c == nil {
+		return nil
+	}
+	m, err := Parse(c.RegistryMirrors)
+	if err != nil {
+		sylog.Warningf("Ignoring registry mirrors: %s", err)
+		return nil
+	}
+	return m
+}
+
+// References returns the references of the image ref on the mirrors of its
+// registry, in order. The reference ref is given without transport and
+// defaults to the docker.io registry (eg: alpine:3 or docker.io/library/alpine:3).
+func (m Mirrors) References(ref string) []string {
+	if len(m) == 0 {
+		return nil
+	}
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return nil
+	}
+
+	suffix := ""
+	if tagged, ok := named.(reference.Tagged); ok {
+		suffix = ":" + tagged.Tag()
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		suffix += "@" + digested.Digest().String()
+	}
+
+	var refs []string
+	for _, mirror := range m[reference.Domain(named)] {
+		refs = append(refs, mirror+"/"+reference.Path(named)+suffix)
+	}
+	return refs
+}
+
+// Try calls pull with the references of the image ref on the mirrors of its
+// registry, in order, and then with ref itself, until pull succeeds. The
+// mirror argument of pull reports if the reference is a mirror reference.
+// The error of the pull from the registry itself is returned if all fail.
+func (m Mirrors) Try(ref string, pull func(ref string, mirror bool) error) error {
+	for _, r := range m.References(ref) {
+		sylog.Debugf("Trying registry mirror reference %s", r)
+		err := pull(r, true)
+		if err == nil {
+			sylog.Verbosef("Using registry mirror reference %s for %s", r, ref)
+			return nil
+		}
+		sylog.Warningf("Could not pull %s from registry mirror: %s", r, err)
+	}
+	return pull(ref, false)
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package mirror
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		want    Mirrors
+		wantErr bool
+	}{
+		{
+			name:    "Empty",
+			entries: []string{""},
+			want:    Mirrors{},
+		},
+		{
+			name: "Ordered",
+			entries: []string{
+				"docker.io=harbor.example.com/dockerhub-proxy/",
+				"index.docker.io=mirror.gcr.io",
+				"quay.io = quay-mirror.example.com:5000",
+			},
+			want: Mirrors{
+				"docker.io": {"harbor.example.com/dockerhub-proxy", "mirror.gcr.io"},
+				"quay.io":   {"quay-mirror.example.com:5000"},
+			},
+		},
+		{name: "NoMirror", entries: []string{"docker.io"}, wantErr: true},
+		{name: "EmptyMirror", entries: []string{"docker.io="}, wantErr: true},
+		{name: "Scheme", entries: []string{"docker.io=https://mirror.gcr.io"}, wantErr: true},
+		{name: "RegistryPath", entries: []string{"docker.io/library=mirror.gcr.io"}, wantErr: true},
+		{name: "InvalidMirror", entries: []string{"docker.io=Mirror GCR"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := Parse(tt.entries)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(m, tt.want) {
+				t.Errorf("got mirrors %v, want %v", m, tt.want)
+			}
+		})
+	}
+}
+
+func TestReferences(t *testing.T) {
+	m := Mirrors{
+		"docker.io":            {"harbor.example.com/proxy", "mirror.gcr.io"},
+		"registry.example.com": {"localhost:5000"},
+	}
+	digest := "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+
+	tests := []struct {
+		ref  string
+		want []string
+	}{
+		{"alpine", []string{"harbor.example.com/proxy/library/alpine", "mirror.gcr.io/library/alpine"}},
+		{"docker.io/user/app:1.0", []string{"harbor.example.com/proxy/user/app:1.0", "mirror.gcr.io/user/app:1.0"}},
+		{"registry.example.com/app:v1@" + digest, []string{"localhost:5000/app:v1@" + digest}},
+		{"quay.io/app:latest", nil},
+		{"Invalid Reference", nil},
+	}
+
+	for _, tt := range tests {
+		if got := m.References(tt.ref); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("references of %s: got %v, want %v", tt.ref, got, tt.want)
+		}
+	}
+	if got := Mirrors(nil).References("alpine"); got != nil {
+		t.Errorf("unexpected references without mirrors: %v", got)
+	}
+}
+
+func TestTry(t *testing.T) {
+	m := Mirrors{"docker.io": {"first.example.com", "second.example.com"}}
+
+	var tried []string
+	pull := func(failing ...string) func(string, bool) error {
+		tried = nil
+		return func(ref string, isMirror bool) error {
+			tried = append(tried, ref)
+			if isMirror != (ref != "alpine:3") {
+				t.Errorf("unexpected mirror flag %v for %s", isMirror, ref)
+			}
+			for _, f := range failing {
+				if f == ref {
+					return errors.New("pull failed")
+				}
+			}
+			return nil
+		}
+	}
+
+	if err := m.Try("alpine:3", pull("first.example.com/library/alpine:3")); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if want := []string{"first.example.com/library/alpine:3", "second.example.com/library/alpine:3"}; !reflect.DeepEqual(tried, want) {
+		t.Errorf("tried %v, want %v", tried, want)
+	}
+
+	err := m.Try("alpine:3", pull("first.example.com/library/alpine:3", "second.example.com/library/alpine:3", "alpine:3"))
+	if err == nil {
+		t.Errorf("unexpected success")
+	}
+	if len(tried) != 3 || tried[2] != "alpine:3" {
+		t.Errorf("registry not tried last: %v", tried)
+	}
+}
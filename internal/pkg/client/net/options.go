@@ -0,0 +1,170 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package net
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hpcng/singularity/internal/pkg/util/digest"
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+// DefaultMaxRedirects is the default maximum number of redirects
+// followed while pulling an image.
+const DefaultMaxRedirects = 10
+
+// metaDir is the directory of the net cache holding the
+// metadata of the cached URLs.
+const metaDir = ".meta"
+
+// Options holds the options of http(s) image pulls.
+type Options struct {
+	// Digest is the expected digest of the image, <algorithm>:<hex>,
+	// images with a digest are cached by digest.
+	Digest string
+	// Algorithm is the digest algorithm of the cache keys of images
+	// pulled without digest, the default algorithm if empty.
+	Algorithm godigest.Algorithm
+	// Headers are added to the requests, like authorization
+	// headers. They are not sent to other hosts on redirects.
+	Headers http.Header
+	// MaxRedirects is the maximum number of redirects followed,
+	// zero disables redirects.
+	MaxRedirects int
+}
+
+// defaultOptions are the options used if none are passed.
+var defaultOptions = Options{MaxRedirects: DefaultMaxRedirects}
+
+// ParseHeaders parses HTTP headers of the form "Name: value".
+func ParseHeaders(headers []string) (http.Header, error) {
+	h := make(http.Header)
+	for _, header := range headers {
+		i := strings.Index(header, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid header %q: must be of the form 'Name: value'", header)
+		}
+		h.Add(strings.TrimSpace(header[:i]), strings.TrimSpace(header[i+1:]))
+	}
+	return h, nil
+}
+
+func (o *Options) orDefault() *Options {
+	if o == nil {
+		return &defaultOptions
+	}
+	return o
+}
+
+// validate checks the image digest format.
+func (o *Options) validate() error {
+	if d := o.orDefault().Digest; d != "" {
+		if _, err := digest.Parse(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// digest returns the expected digest of the image, once validated.
+func (o *Options) digest() godigest.Digest {
+	if o.orDefault().Digest == "" {
+		return ""
+	}
+	d, _ := digest.Parse(o.orDefault().Digest)
+	return d
+}
+
+func (o *Options) algorithm() godigest.Algorithm {
+	if a := o.orDefault().Algorithm; a != "" {
+		return a
+	}
+	return digest.Default
+}
+
+func (o *Options) headers() http.Header {
+	return o.orDefault().Headers
+}
+
+// checkRedirect limits the number of redirects, refuses redirects from
+// https to http and drops the user headers on redirects to other hosts.
+func (o *Options) checkRedirect(req *http.Request, via []*http.Request) error {
+	max := o.orDefault().MaxRedirects
+	if len(via) > max {
+		return fmt.Errorf("stopped after %d redirect(s)", max)
+	}
+	orig := via[0].URL
+	if orig.Scheme == "https" && req.URL.Scheme != "https" {
+		return fmt.Errorf("refusing redirect from %s to insecure %s", orig, req.URL)
+	}
+	if req.URL.Host != orig.Host {
+		for k := range o.headers() {
+			req.Header.Del(k)
+		}
+	}
+	return nil
+}
+
+// cacheMeta holds the cache validators of an URL.
+type cacheMeta struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	// Entry is the cache entry of the image.
+	Entry string `json:"entry"`
+}
+
+// metaPath returns the path of the cache metadata of url.
+func metaPath(cacheDir, url string) string {
+	h := sha256.Sum256([]byte(url))
+	return filepath.Join(cacheDir, metaDir, hex.EncodeToString(h[:])+".json")
+}
+
+// readCacheMeta returns the cache metadata of url or nil.
+func readCacheMeta(cacheDir, url string) *cacheMeta {
+	b, err := ioutil.ReadFile(metaPath(cacheDir, url))
+	if err != nil {
+		return nil
+	}
+	meta := new(cacheMeta)
+	if err := json.Unmarshal(b, meta); err != nil || meta.Entry == "" {
+		return nil
+	}
+	return meta
+}
+
+// writeCacheMeta records the cache metadata of url.
+func writeCacheMeta(cacheDir, url string, meta *cacheMeta) error {
+	path := metaPath(cacheDir, url)
+	if err := fs.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	f, err := fs.MakeTmpFile(filepath.Dir(path), "tmp_", 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -8,9 +8,8 @@ package net
 import (
 	"bytes"
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
@@ -20,9 +19,11 @@ import (
 
 	"github.com/hpcng/singularity/internal/pkg/cache"
 	"github.com/hpcng/singularity/internal/pkg/client"
+	dgst "github.com/hpcng/singularity/internal/pkg/util/digest"
 	"github.com/hpcng/singularity/internal/pkg/util/fs"
 	"github.com/hpcng/singularity/pkg/sylog"
 	useragent "github.com/hpcng/singularity/pkg/util/user-agent"
+	godigest "github.com/opencontainers/go-digest"
 )
 
 // Timeout for an image pull in seconds - could be a large download...
@@ -38,7 +39,12 @@ func IsNetPullRef(netRef string) bool {
 // DownloadImage will retrieve an image from an http(s) URI,
 // saving it into the specified file
 func DownloadImage(ctx context.Context, filePath string, netURL string) error {
+	return downloadImage(ctx, filePath, netURL, nil)
+}
 
+// downloadImage retrieves an image from an http(s) URI with the options
+// opts, saving it into the specified file.
+func downloadImage(ctx context.Context, filePath string, netURL string, opts *Options) error {
 	if !IsNetPullRef(netURL) {
 		return fmt.Errorf("not a valid url reference: %s", netURL)
 	}
@@ -48,40 +54,71 @@ func DownloadImage(ctx context.Context, filePath string, netURL string) error {
 		sylog.Infof("Download filename not provided. Downloading to: %s\n", filePath)
 	}
 
-	url := netURL
+	res, err := opts.get(ctx, netURL, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	return writeImage(ctx, res, filePath, opts.digest())
+}
+
+// get sends a GET request for url, conditional if the cache metadata
+// meta is set, and returns the response if its status is 200 OK or
+// 304 Not Modified.
+func (o *Options) get(ctx context.Context, url string, meta *cacheMeta) (*http.Response, error) {
 	sylog.Debugf("Pulling from URL: %s\n", url)
 
 	httpClient := &http.Client{
-		Timeout: pullTimeout * time.Second,
+		Timeout:       pullTimeout * time.Second,
+		CheckRedirect: o.checkRedirect,
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	for k, values := range o.headers() {
+		for _, v := range values {
+			req.Header.Add(k, v)
+		}
 	}
-
 	req.Header.Set("User-Agent", useragent.Value())
+	if meta != nil {
+		if meta.ETag != "" {
+			req.Header.Set("If-None-Match", meta.ETag)
+		} else if meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", meta.LastModified)
+		}
+	}
 
 	res, err := httpClient.Do(req)
 	if err != nil {
-		return err
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode == http.StatusNotFound {
-		return fmt.Errorf("the requested image was not found")
+		return nil, err
 	}
 
-	if res.StatusCode != http.StatusOK {
-		buf := new(bytes.Buffer)
-		buf.ReadFrom(res.Body)
-		s := buf.String()
-		return fmt.Errorf("Download did not succeed: %d %s\n\t",
-			res.StatusCode, s)
+	switch res.StatusCode {
+	case http.StatusOK:
+		sylog.Debugf("OK response received, beginning body download\n")
+		return res, nil
+	case http.StatusNotModified:
+		if meta != nil {
+			return res, nil
+		}
+	case http.StatusNotFound:
+		res.Body.Close()
+		return nil, fmt.Errorf("the requested image was not found")
 	}
 
-	sylog.Debugf("OK response received, beginning body download\n")
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(res.Body)
+	res.Body.Close()
+	return nil, fmt.Errorf("Download did not succeed: %d %s\n\t", res.StatusCode, buf.String())
+}
 
+// writeImage writes the body of the response res to filePath, and verifies
+// its digest if set. The file is removed if the download fails.
+func writeImage(ctx context.Context, res *http.Response, filePath string, digest godigest.Digest) error {
 	// Perms are 777 *prior* to umask
 	out, err := os.OpenFile(filePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0777)
 	if err != nil {
@@ -89,10 +126,20 @@ func DownloadImage(ctx context.Context, filePath string, netURL string) error {
 	}
 	defer out.Close()
 
+	var w io.Writer = out
+	var digester godigest.Digester
+	if digest != "" {
+		digester = digest.Algorithm().Digester()
+		w = io.MultiWriter(out, digester.Hash())
+	}
 	pb := client.ProgressBarCallback(ctx)
 
-	err = pb(res.ContentLength, res.Body, out)
-
+	err = pb(res.ContentLength, res.Body, w)
+	if err == nil && digester != nil {
+		if d := digester.Digest(); d != digest {
+			err = fmt.Errorf("image digest %s doesn't match expected digest %s", d, digest)
+		}
+	}
 	if err != nil {
 		// Delete incomplete image file in the event of failure
 		// we get here e.g. if the context is canceled by Ctrl-C
@@ -111,41 +158,22 @@ func DownloadImage(ctx context.Context, filePath string, netURL string) error {
 }
 
 // pull will pull a http(s) image into the cache if directTo="", or a specific file if directTo is set.
-func pull(ctx context.Context, imgCache *cache.Handle, directTo, pullFrom string) (imagePath string, err error) {
-	// We will cache using a sha256 over the URL and the date of the file that
-	// is to be fetched, as returned by an HTTP HEAD call and the Last-Modified
-	// header. If no date is available, use the current date-time, which will
-	// effectively result in no caching.
-	imageDate := time.Now().String()
-
-	req, err := http.NewRequest("HEAD", pullFrom, nil)
-	if err != nil {
-		sylog.Fatalf("Error constructing http request: %v\n", err)
+func pull(ctx context.Context, imgCache *cache.Handle, directTo, pullFrom string, opts *Options) (imagePath string, err error) {
+	if err := opts.validate(); err != nil {
+		return "", err
 	}
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		sylog.Fatalf("Error making http request: %v\n", err)
-	}
-
-	headerDate := res.Header.Get("Last-Modified")
-	sylog.Debugf("HTTP Last-Modified header is: %s", headerDate)
-	if headerDate != "" {
-		imageDate = headerDate
-	}
-
-	h := sha256.New()
-	h.Write([]byte(pullFrom + imageDate))
-	hash := hex.EncodeToString(h.Sum(nil))
-	sylog.Debugf("Image hash for cache is: %s", hash)
 
 	if directTo != "" {
 		sylog.Infof("Downloading network image")
-		if err := DownloadImage(ctx, directTo, pullFrom); err != nil {
+		if err := downloadImage(ctx, directTo, pullFrom, opts); err != nil {
 			return "", fmt.Errorf("unable to Download Image: %v", err)
 		}
-		imagePath = directTo
+		return directTo, nil
+	}
 
-	} else {
+	// Images with a known digest are cached by digest
+	if digest := opts.digest(); digest != "" {
+		hash := dgst.CacheKey(digest)
 		cacheEntry, err := imgCache.GetEntry(cache.NetCacheType, hash)
 		if err != nil {
 			return "", fmt.Errorf("unable to check if %v exists in cache: %v", hash, err)
@@ -154,28 +182,104 @@ func pull(ctx context.Context, imgCache *cache.Handle, directTo, pullFrom string
 
 		if !cacheEntry.Exists {
 			sylog.Infof("Downloading network image")
-			err := DownloadImage(ctx, cacheEntry.TmpPath, pullFrom)
-			if err != nil {
-				sylog.Fatalf("%v\n", err)
+			if err := downloadImage(ctx, cacheEntry.TmpPath, pullFrom, opts); err != nil {
+				return "", fmt.Errorf("unable to Download Image: %v", err)
 			}
-
-			err = cacheEntry.Finalize()
-			if err != nil {
+			if err := cacheEntry.Finalize(); err != nil {
 				return "", err
 			}
-
 		} else {
 			sylog.Verbosef("Using image from cache")
 		}
+		return cacheEntry.Path, nil
+	}
+
+	return pullCached(ctx, imgCache, pullFrom, opts)
+}
+
+// pullCached pulls a http(s) image into the cache with a conditional
+// request, the image cached for the URL is used if the server reports
+// it isn't modified. The cache entries are identified by a digest over
+// the URL and the ETag or the Last-Modified date of the image. If none
+// are returned, the current date-time is used, which will effectively
+// result in no caching.
+func pullCached(ctx context.Context, imgCache *cache.Handle, pullFrom string, opts *Options) (string, error) {
+	cacheDir, err := imgCache.GetFileCacheDir(cache.NetCacheType)
+	if err != nil {
+		return "", err
+	}
+
+	meta := readCacheMeta(cacheDir, pullFrom)
+	if meta != nil {
+		cacheEntry, err := imgCache.GetEntry(cache.NetCacheType, meta.Entry)
+		if err != nil {
+			return "", fmt.Errorf("unable to check if %v exists in cache: %v", meta.Entry, err)
+		}
+		cacheEntry.CleanTmp()
+		if !cacheEntry.Exists {
+			meta = nil
+		}
+	}
 
-		imagePath = cacheEntry.Path
+	res, err := opts.get(ctx, pullFrom, meta)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified {
+		sylog.Verbosef("Using image from cache, not modified on server")
+		cacheEntry, err := imgCache.GetEntry(cache.NetCacheType, meta.Entry)
+		if err != nil {
+			return "", fmt.Errorf("unable to check if %v exists in cache: %v", meta.Entry, err)
+		}
+		return cacheEntry.Path, nil
 	}
 
-	return imagePath, nil
+	meta = &cacheMeta{
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+	}
+	sylog.Debugf("HTTP ETag header is: %s, Last-Modified header is: %s", meta.ETag, meta.LastModified)
+
+	validator := meta.ETag
+	if validator == "" {
+		validator = meta.LastModified
+	}
+	if validator == "" {
+		validator = time.Now().String()
+	}
+	meta.Entry = dgst.CacheKey(opts.algorithm().FromString(pullFrom + validator))
+	sylog.Debugf("Image hash for cache is: %s", meta.Entry)
+
+	cacheEntry, err := imgCache.GetEntry(cache.NetCacheType, meta.Entry)
+	if err != nil {
+		return "", fmt.Errorf("unable to check if %v exists in cache: %v", meta.Entry, err)
+	}
+	defer cacheEntry.CleanTmp()
+
+	if !cacheEntry.Exists {
+		sylog.Infof("Downloading network image")
+		if err := writeImage(ctx, res, cacheEntry.TmpPath, ""); err != nil {
+			return "", fmt.Errorf("unable to Download Image: %v", err)
+		}
+		if err := cacheEntry.Finalize(); err != nil {
+			return "", err
+		}
+	} else {
+		sylog.Verbosef("Using image from cache")
+	}
+
+	if meta.ETag != "" || meta.LastModified != "" {
+		if err := writeCacheMeta(cacheDir, pullFrom, meta); err != nil {
+			sylog.Debugf("Could not write cache metadata for %s: %s", pullFrom, err)
+		}
+	}
+	return cacheEntry.Path, nil
 }
 
 // Pull will pull a http(s) image to the cache or direct to a temporary file if cache is disabled
-func Pull(ctx context.Context, imgCache *cache.Handle, pullFrom string, tmpDir string) (imagePath string, err error) {
+func Pull(ctx context.Context, imgCache *cache.Handle, pullFrom string, tmpDir string, opts *Options) (imagePath string, err error) {
 
 	directTo := ""
 
@@ -188,11 +292,11 @@ func Pull(ctx context.Context, imgCache *cache.Handle, pullFrom string, tmpDir s
 		sylog.Infof("Downloading library image to tmp cache: %s", directTo)
 	}
 
-	return pull(ctx, imgCache, directTo, pullFrom)
+	return pull(ctx, imgCache, directTo, pullFrom, opts)
 }
 
 // PullToFile will pull an http(s) image to the specified location, through the cache, or directly if cache is disabled
-func PullToFile(ctx context.Context, imgCache *cache.Handle, pullTo, pullFrom, tmpDir string) (imagePath string, err error) {
+func PullToFile(ctx context.Context, imgCache *cache.Handle, pullTo, pullFrom, tmpDir string, opts *Options) (imagePath string, err error) {
 
 	directTo := ""
 	if imgCache.IsDisabled() {
@@ -200,7 +304,7 @@ func PullToFile(ctx context.Context, imgCache *cache.Handle, pullTo, pullFrom, t
 		sylog.Debugf("Cache disabled, pulling directly to: %s", directTo)
 	}
 
-	src, err := pull(ctx, imgCache, directTo, pullFrom)
+	src, err := pull(ctx, imgCache, directTo, pullFrom, opts)
 	if err != nil {
 		return "", fmt.Errorf("error fetching image to cache: %v", err)
 	}
@@ -0,0 +1,156 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package net
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcng/singularity/internal/pkg/cache"
+	useragent "github.com/hpcng/singularity/pkg/util/user-agent"
+)
+
+const testImage = "not really a SIF image"
+
+func TestMain(m *testing.M) {
+	useragent.InitValue("singularity", "3.0.0-alpha.1-303-gaed8d30-dirty")
+
+	os.Exit(m.Run())
+}
+
+func TestPull(t *testing.T) {
+	var downloads, conditionals int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, "/image.sif", http.StatusFound)
+			return
+		case "/image.sif":
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			conditionals++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Write([]byte(testImage))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "net-pull-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	imgCache, err := cache.New(cache.Config{ParentDir: dir})
+	if err != nil {
+		t.Fatalf("failed to create cache handle: %s", err)
+	}
+
+	opts := &Options{
+		Headers:      http.Header{"Authorization": []string{"Bearer token"}},
+		MaxRedirects: DefaultMaxRedirects,
+	}
+	ctx := context.Background()
+
+	// the second pull is served from the cache
+	var paths []string
+	for i := 0; i < 2; i++ {
+		path, err := pull(ctx, imgCache, "", srv.URL+"/image.sif", opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		paths = append(paths, path)
+	}
+	if downloads != 1 || conditionals != 1 {
+		t.Errorf("got %d downloads and %d conditional requests, expected 1 and 1", downloads, conditionals)
+	}
+	if paths[0] != paths[1] {
+		t.Errorf("got different cache entries %s and %s", paths[0], paths[1])
+	}
+	if b, err := ioutil.ReadFile(paths[0]); err != nil || string(b) != testImage {
+		t.Errorf("unexpected cached image: %v", err)
+	}
+
+	sum := sha256.Sum256([]byte(testImage))
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	// redirects are followed unless disabled
+	dest := filepath.Join(dir, "image.sif")
+	opts.Digest = digest
+	if _, err := pull(ctx, imgCache, dest, srv.URL+"/redirect", opts); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	opts.MaxRedirects = 0
+	if _, err := pull(ctx, imgCache, dest, srv.URL+"/redirect", opts); err == nil {
+		t.Errorf("unexpected success with redirects disabled")
+	}
+	opts.MaxRedirects = DefaultMaxRedirects
+
+	// images are verified and cached by digest
+	path, err := pull(ctx, imgCache, "", srv.URL+"/image.sif", opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if filepath.Base(path) != digest[len("sha256:"):] {
+		t.Errorf("image not cached by digest: %s", path)
+	}
+
+	// other algorithms are cached with an algorithm prefix
+	sum512 := sha512.Sum512([]byte(testImage))
+	opts.Digest = "sha512:" + hex.EncodeToString(sum512[:])
+	path, err = pull(ctx, imgCache, "", srv.URL+"/image.sif", opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if filepath.Base(path) != "sha512-"+hex.EncodeToString(sum512[:]) {
+		t.Errorf("image not cached by sha512 digest: %s", path)
+	}
+
+	opts.Digest = "sha256:" + hex.EncodeToString(make([]byte, 32))
+	if _, err := pull(ctx, imgCache, dest+".bad", srv.URL+"/image.sif", opts); err == nil {
+		t.Errorf("unexpected success with a wrong digest")
+	}
+	if _, err := os.Stat(dest + ".bad"); !os.IsNotExist(err) {
+		t.Errorf("image with a wrong digest not removed")
+	}
+
+	opts.Digest = "md5:1234"
+	if _, err := pull(ctx, imgCache, dest, srv.URL+"/image.sif", opts); err == nil {
+		t.Errorf("unexpected success with an invalid digest")
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	h, err := ParseHeaders([]string{"Authorization: Bearer a:b", "X-Token:1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if h.Get("Authorization") != "Bearer a:b" || h.Get("X-Token") != "1" {
+		t.Errorf("unexpected headers %v", h)
+	}
+	if _, err := ParseHeaders([]string{"no header"}); err == nil {
+		t.Errorf("unexpected success for an invalid header")
+	}
+}
@@ -10,19 +10,24 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"strings"
 
+	"github.com/containers/image/v5/docker/reference"
 	ocitypes "github.com/containers/image/v5/types"
 	"github.com/hpcng/singularity/internal/pkg/build"
 	"github.com/hpcng/singularity/internal/pkg/build/oci"
 	"github.com/hpcng/singularity/internal/pkg/cache"
+	"github.com/hpcng/singularity/internal/pkg/client/mirror"
+	"github.com/hpcng/singularity/internal/pkg/client/registry"
 	"github.com/hpcng/singularity/internal/pkg/util/fs"
 	"github.com/hpcng/singularity/pkg/syfs"
 	"github.com/hpcng/singularity/pkg/sylog"
 	useragent "github.com/hpcng/singularity/pkg/util/user-agent"
 )
 
-// pull will build a SIF image into the cache if directTo="", or a specific file if directTo is set.
-func pull(ctx context.Context, imgCache *cache.Handle, directTo, pullFrom, tmpDir string, ociAuth *ocitypes.DockerAuthConfig, noHTTPS, noCleanUp bool) (imagePath string, err error) {
+// ImageDigest returns the digest of the OCI image pullFrom identifying the
+// image in the cache. Docker images are looked up on the registry mirrors.
+func ImageDigest(ctx context.Context, pullFrom, tmpDir string, ociAuth *ocitypes.DockerAuthConfig, noHTTPS bool) (digest string, err error) {
 	// DockerInsecureSkipTLSVerify is set only if --nohttps is specified to honor
 	// configuration from /etc/containers/registries.conf because DockerInsecureSkipTLSVerify
 	// can have three possible values true/false and undefined, so we left it as undefined instead
@@ -39,9 +44,38 @@ func pull(ctx context.Context, imgCache *cache.Handle, directTo, pullFrom, tmpDi
 		sysCtx.DockerInsecureSkipTLSVerify = ocitypes.NewOptionalBool(true)
 	}
 
-	hash, err := oci.ImageSHA(ctx, pullFrom, sysCtx)
+	if ref := strings.TrimPrefix(pullFrom, "docker://"); ref != pullFrom {
+		// the conversion below selects the same mirror again
+		err = mirror.Configured().Try(ref, func(r string, isMirror bool) error {
+			mirrorCtx := *sysCtx
+			if isMirror {
+				// credentials set on the command line are for the registry
+				mirrorCtx.DockerAuthConfig = nil
+			}
+			if named, err := reference.ParseNormalizedNamed(r); err == nil {
+				if err := registry.Authorize(ctx, &mirrorCtx, named, nil); err != nil {
+					return err
+				}
+			}
+			return registry.Retry(ctx, &mirrorCtx, func() (err error) {
+				digest, err = oci.ImageSHA(ctx, "docker://"+r, &mirrorCtx)
+				return err
+			})
+		})
+	} else {
+		digest, err = oci.ImageSHA(ctx, pullFrom, sysCtx)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get checksum for %s: %s", pullFrom, registry.Explain(err))
+	}
+	return digest, nil
+}
+
+// pull will build a SIF image into the cache if directTo="", or a specific file if directTo is set.
+func pull(ctx context.Context, imgCache *cache.Handle, directTo, pullFrom, tmpDir string, ociAuth *ocitypes.DockerAuthConfig, noHTTPS, noCleanUp bool) (imagePath string, err error) {
+	hash, err := ImageDigest(ctx, pullFrom, tmpDir, ociAuth, noHTTPS)
 	if err != nil {
-		return "", fmt.Errorf("failed to get checksum for %s: %s", pullFrom, err)
+		return "", err
 	}
 
 	if directTo != "" {
@@ -9,16 +9,31 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"strings"
 
 	ocitypes "github.com/containers/image/v5/types"
 	"github.com/hpcng/singularity/internal/pkg/cache"
+	"github.com/hpcng/singularity/internal/pkg/client/mirror"
 	"github.com/hpcng/singularity/internal/pkg/util/fs"
 	"github.com/hpcng/singularity/pkg/sylog"
 )
 
 // pull will pull an oras image into the cache if directTo="", or a specific file if directTo is set.
 func pull(ctx context.Context, imgCache *cache.Handle, directTo, pullFrom string, ociAuth *ocitypes.DockerAuthConfig) (imagePath string, err error) {
-	hash, err := ImageSHA(ctx, pullFrom, ociAuth)
+	var hash string
+	ref := strings.TrimPrefix(strings.TrimPrefix(pullFrom, "oras:"), "//")
+	err = mirror.Configured().Try(ref, func(r string, isMirror bool) error {
+		auth := ociAuth
+		if isMirror {
+			// credentials set on the command line are for the registry
+			auth = nil
+		}
+		hash, err = ImageSHA(ctx, "oras://"+r, auth)
+		if err == nil {
+			pullFrom, ociAuth = "oras://"+r, auth
+		}
+		return err
+	})
 	if err != nil {
 		return "", fmt.Errorf("failed to get checksum for %s: %s", pullFrom, err)
 	}
@@ -0,0 +1,366 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package registry prepares the pulls of docker images from registries using
+// bearer token authentication, like Docker Hub. The image is looked up before
+// the pull with tokens cached for the run, anonymously first and then with the
+// credentials of the registry once anonymous access is denied or rate limited,
+// and the pull waits for the registry rate limit to be lifted. The pull uses
+// the token of the lookup, renewed when it expires during the pull, and is
+// retried when the registry rate limit is reached during the pull.
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/docker/config"
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/containers/image/v5/types"
+	"github.com/hpcng/singularity/pkg/sylog"
+	useragent "github.com/hpcng/singularity/pkg/util/user-agent"
+)
+
+const (
+	// maxRetries is the number of lookups retried once the registry rate
+	// limit is reached.
+	maxRetries = 3
+	// initialBackoff is the delay before the first retry when the registry
+	// doesn't set a Retry-After header, doubled on each retry.
+	initialBackoff = 10 * time.Second
+	// maxWait is the longest delay waited for before a retry.
+	maxWait = 2 * time.Minute
+)
+
+// ErrRateLimited is returned when the pull rate limit of the registry is
+// still reached after the retries.
+var ErrRateLimited = errors.New("registry pull rate limit reached")
+
+// authorizer looks up images on registries.
+type authorizer struct {
+	client *http.Client
+	scheme string
+	tokens tokenCache
+	now    func() time.Time
+	sleep  func(context.Context, time.Duration) error
+}
+
+var defaultAuthorizer = &authorizer{
+	client: &http.Client{Timeout: 30 * time.Second},
+	scheme: "https",
+	now:    time.Now,
+	sleep:  sleepContext,
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func (a *authorizer) setUserAgent(req *http.Request) {
+	req.Header.Set("User-Agent", useragent.Value())
+}
+
+// registryHost returns the host serving the registry API of domain.
+func registryHost(domain string) string {
+	if domain == "docker.io" {
+		return "registry-1.docker.io"
+	}
+	return domain
+}
+
+// Mirrored returns whether the docker image ref is pulled through the mirrors
+// or from another location set in the registries configuration of sysCtx.
+func Mirrored(sysCtx *types.SystemContext, ref reference.Named) (bool, error) {
+	reg, err := sysregistriesv2.FindRegistry(sysCtx, ref.Name())
+	if err != nil {
+		return false, err
+	}
+	return reg != nil && (len(reg.Mirrors) > 0 || reg.Location != reference.Domain(ref)), nil
+}
+
+// Authorize looks up the docker image ref before its pull with sysCtx and
+// sets the credentials of sysCtx to pull it anonymously when possible, or
+// with the credentials creds of the registry otherwise, looked up in the
+// authentication files of sysCtx if nil. The bearer token of the lookup is
+// set in sysCtx for the pull, run with Retry. Credentials already set in
+// sysCtx are used as is. When the pull rate limit of the registry is
+// reached, the lookup is retried after the delay requested by the registry
+// and ErrRateLimited is returned once the retries are exhausted. The lookup
+// is skipped for registries which don't use bearer tokens, mirrored or
+// insecure registries, other lookup failures are left to the pull to report.
+func Authorize(ctx context.Context, sysCtx *types.SystemContext, ref reference.Named, creds *types.DockerAuthConfig) error {
+	return defaultAuthorizer.authorize(ctx, sysCtx, ref, creds)
+}
+
+func (a *authorizer) authorize(ctx context.Context, sysCtx *types.SystemContext, ref reference.Named, creds *types.DockerAuthConfig) error {
+	if sysCtx.DockerInsecureSkipTLSVerify == types.OptionalBoolTrue || sysCtx.DockerBearerRegistryToken != "" {
+		return nil
+	}
+	domain := reference.Domain(ref)
+	if mirrored, err := Mirrored(sysCtx, ref); err != nil {
+		sylog.Debugf("Skipping lookup of %s: %s", ref, err)
+		return nil
+	} else if mirrored {
+		sylog.Debugf("Skipping lookup of %s pulled through mirrors", ref)
+		return nil
+	}
+
+	host := registryHost(domain)
+	ch, err := a.challenge(ctx, host)
+	if err != nil {
+		sylog.Debugf("Skipping lookup of %s: %s", ref, err)
+		return nil
+	} else if ch == nil {
+		return nil
+	}
+
+	// credentials set on the command line are used as is
+	anonymous := true
+	if sysCtx.DockerAuthConfig != nil {
+		if sysCtx.DockerAuthConfig.Username == "" {
+			return nil
+		}
+		creds, anonymous = sysCtx.DockerAuthConfig, false
+	}
+
+	scope := "repository:" + reference.Path(ref) + ":pull"
+	manifestRef := "latest"
+	if digested, ok := ref.(reference.Digested); ok {
+		manifestRef = digested.Digest().String()
+	} else if tagged, ok := ref.(reference.Tagged); ok {
+		manifestRef = tagged.Tag()
+	}
+	manifestURL := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", a.scheme, host, reference.Path(ref), manifestRef)
+
+	backoff := initialBackoff
+	for retries := 0; ; {
+		var auth *types.DockerAuthConfig
+		if !anonymous {
+			auth = creds
+		}
+		tok, err := a.token(ctx, ch, scope, auth)
+		if err != nil {
+			sylog.Debugf("Skipping lookup of %s: %s", ref, err)
+			return nil
+		}
+		res, err := a.head(ctx, manifestURL, tok)
+		if err != nil {
+			sylog.Debugf("Skipping lookup of %s: %s", ref, err)
+			return nil
+		}
+
+		switch res.StatusCode {
+		case http.StatusOK:
+			sysCtx.DockerBearerRegistryToken = tok
+			if anonymous {
+				sysCtx.DockerAuthConfig = &types.DockerAuthConfig{}
+			} else {
+				sysCtx.DockerAuthConfig = creds
+			}
+			return nil
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+			limited := res.StatusCode == http.StatusTooManyRequests
+			if anonymous {
+				if creds == nil {
+					creds = registryCredentials(sysCtx, ref)
+				}
+				if creds != nil {
+					if limited {
+						sylog.Infof("Anonymous pull rate limit of %s reached%s, retrying with credentials of %s", domain, rateLimitInfo(res), creds.Username)
+					} else {
+						sylog.Verbosef("Anonymous access to %s denied, retrying with credentials of %s", ref, creds.Username)
+					}
+					anonymous = false
+					continue
+				}
+			}
+			if !limited {
+				// the pull reports the authentication failure
+				return nil
+			}
+			if retries == maxRetries {
+				return fmt.Errorf("%w for %s%s, pull later, authenticate with 'singularity remote login' or configure a registry mirror", ErrRateLimited, domain, rateLimitInfo(res))
+			}
+			wait, ok := retryAfter(res, a.now())
+			if !ok {
+				wait = backoff
+				backoff *= 2
+			}
+			if wait > maxWait {
+				return fmt.Errorf("%w for %s%s, retry after %s, authenticate with 'singularity remote login' or configure a registry mirror", ErrRateLimited, domain, rateLimitInfo(res), wait.Round(time.Second))
+			}
+			retries++
+			sylog.Warningf("Pull rate limit of %s reached%s, retrying in %s (%d/%d)", domain, rateLimitInfo(res), wait.Round(time.Second), retries, maxRetries)
+			if err := a.sleep(ctx, wait); err != nil {
+				return err
+			}
+		default:
+			// not found and other errors are reported by the pull
+			return nil
+		}
+	}
+}
+
+// Retry runs pull with sysCtx prepared by Authorize. The token set in sysCtx
+// is renewed when it expires during the pull, or dropped when the registry
+// rejects it, and the pull is retried with a backoff when the registry rate
+// limit is reached. The image layers already pulled are not pulled again.
+func Retry(ctx context.Context, sysCtx *types.SystemContext, pull func() error) error {
+	return defaultAuthorizer.retry(ctx, sysCtx, pull)
+}
+
+func (a *authorizer) retry(ctx context.Context, sysCtx *types.SystemContext, pull func() error) error {
+	backoff := initialBackoff
+	for retries, renewals := 0, 0; ; {
+		err := pull()
+		if err == nil {
+			return nil
+		}
+
+		if tok := sysCtx.DockerBearerRegistryToken; tok != "" && renewals < maxRetries {
+			var unauthorized docker.ErrUnauthorizedForCredentials
+			issued, ok := a.tokens.lookup(tok)
+			if ok && a.now().Add(tokenExpiryMargin).After(issued.expires) {
+				// the token expired during the pull
+				renewals++
+				r := issued.request
+				if sysCtx.DockerBearerRegistryToken, err = a.token(ctx, r.ch, r.scope, r.creds); err != nil {
+					sylog.Debugf("While renewing registry token: %s", err)
+				}
+				continue
+			} else if errors.As(err, &unauthorized) {
+				// the pull authenticates with the credentials of sysCtx
+				sylog.Debugf("Registry token rejected, retrying without it: %s", err)
+				renewals++
+				sysCtx.DockerBearerRegistryToken = ""
+				continue
+			}
+		}
+
+		if !errors.Is(err, docker.ErrTooManyRequests) || retries == maxRetries {
+			return err
+		}
+		retries++
+		sylog.Warningf("Pull rate limit reached during the pull, retrying in %s (%d/%d)", backoff, retries, maxRetries)
+		if err := a.sleep(ctx, backoff); err != nil {
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// challenge returns the bearer authentication challenge of the registry
+// host, nil if the registry doesn't use bearer tokens.
+func (a *authorizer) challenge(ctx context.Context, host string) (*challenge, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.scheme+"://"+host+"/v2/", nil)
+	if err != nil {
+		return nil, err
+	}
+	a.setUserAgent(req)
+	res, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("while pinging registry: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		return nil, nil
+	}
+	return parseChallenge(res.Header.Get("WWW-Authenticate")), nil
+}
+
+// head requests the manifest at url with the bearer token tok, manifest
+// HEAD requests don't count in the pull rate limit of Docker Hub.
+func (a *authorizer) head(ctx context.Context, url, tok string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	a.setUserAgent(req)
+	req.Header.Set("Authorization", "Bearer "+tok)
+	for _, t := range manifest.DefaultRequestedManifestMIMETypes {
+		req.Header.Add("Accept", t)
+	}
+	res, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("while looking up manifest: %s", err)
+	}
+	res.Body.Close()
+	return res, nil
+}
+
+// registryCredentials returns the credentials of the registry of ref found
+// in the authentication files of sysCtx, nil if there are none.
+func registryCredentials(sysCtx *types.SystemContext, ref reference.Named) *types.DockerAuthConfig {
+	creds, err := config.GetCredentialsForRef(sysCtx, ref)
+	if err != nil {
+		sylog.Debugf("While reading credentials of %s: %s", reference.Domain(ref), err)
+		return nil
+	}
+	// identity tokens are exchanged by the pull
+	if creds.Username == "" || creds.Password == "" {
+		return nil
+	}
+	return &creds
+}
+
+// retryAfter returns the delay set by the Retry-After header of res, in
+// seconds or as a date.
+func retryAfter(res *http.Response, now time.Time) (time.Duration, bool) {
+	after := strings.TrimSpace(res.Header.Get("Retry-After"))
+	if after == "" {
+		return 0, false
+	}
+	if n, err := strconv.Atoi(after); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second, true
+	}
+	if t, err := http.ParseTime(after); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// rateLimitInfo returns the pull rate limit reported by the RateLimit
+// headers of res, like Docker Hub does.
+func rateLimitInfo(res *http.Response) string {
+	limit := res.Header.Get("RateLimit-Limit")
+	if limit == "" {
+		return ""
+	}
+	// the limit is of the form <pulls>;w=<window in seconds>
+	parts := strings.SplitN(limit, ";w=", 2)
+	info := " (" + parts[0] + " pulls"
+	if len(parts) == 2 {
+		if w, err := strconv.Atoi(parts[1]); err == nil {
+			info += " per " + (time.Duration(w) * time.Second).String()
+		}
+	}
+	return info + ")"
+}
+
+// Explain completes the error of a pull rejected by the registry rate limit.
+func Explain(err error) error {
+	if err != nil && errors.Is(err, docker.ErrTooManyRequests) {
+		return fmt.Errorf("%s: authenticate with 'singularity remote login' or configure a registry mirror to raise the pull rate limit", err)
+	}
+	return err
+}
@@ -0,0 +1,265 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/types"
+	useragent "github.com/hpcng/singularity/pkg/util/user-agent"
+)
+
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		header string
+		want   *challenge
+	}{
+		{`Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`, &challenge{"https://auth.docker.io/token", "registry.docker.io"}},
+		{`bearer service=registry, realm="https://example.com/token?a=b,c"`, &challenge{"https://example.com/token?a=b,c", "registry"}},
+		{`Bearer service="registry"`, nil},
+		{`Basic realm="registry"`, nil},
+		{``, nil},
+	}
+	for _, tt := range tests {
+		if got := parseChallenge(tt.header); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("challenge of %q: got %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+// testRegistry is a registry serving the manifest of library/alpine:3
+// according to its mode.
+type testRegistry struct {
+	*httptest.Server
+	mode   string
+	tokens int
+	heads  int
+}
+
+func newTestRegistry(mode string) *testRegistry {
+	r := &testRegistry{mode: mode}
+	r.Server = httptest.NewTLSServer(http.HandlerFunc(r.serve))
+	return r
+}
+
+func (r *testRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "/v2/":
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, r.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+	case "/token":
+		r.tokens++
+		tok := "anonymous"
+		if user, password, ok := req.BasicAuth(); ok {
+			if user != "user" || password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			tok = "user"
+		}
+		fmt.Fprintf(w, `{"token": %q, "expires_in": 300}`, tok)
+	case "/v2/library/alpine/manifests/3":
+		r.heads++
+		user := req.Header.Get("Authorization") == "Bearer user"
+		switch {
+		case r.mode == "private" && !user:
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.mode == "limited" && !user:
+			w.Header().Set("RateLimit-Limit", "100;w=21600")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case r.mode == "busy" && r.heads <= 2:
+			w.Header().Set("Retry-After", "5")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case r.mode == "closed" || r.mode == "later":
+			if r.mode == "later" {
+				w.Header().Set("Retry-After", "3600")
+			}
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	useragent.InitValue("singularity", "3.0.0-alpha.1-303-gaed8d30-dirty")
+
+	f, err := ioutil.TempFile("", "registries-")
+	if err != nil {
+		t.Fatalf("failed to create registries configuration: %s", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	creds := &types.DockerAuthConfig{Username: "user", Password: "secret"}
+
+	tests := []struct {
+		name      string
+		mode      string
+		explicit  *types.DockerAuthConfig
+		creds     *types.DockerAuthConfig
+		wantAuth  *types.DockerAuthConfig
+		wantWaits []time.Duration
+		wantErr   bool
+	}{
+		{name: "Public", mode: "public", creds: creds, wantAuth: &types.DockerAuthConfig{}},
+		{name: "Private", mode: "private", creds: creds, wantAuth: creds},
+		{name: "PrivateNoCredentials", mode: "private"},
+		{name: "Explicit", mode: "public", explicit: creds, wantAuth: creds},
+		{name: "AnonymousLimited", mode: "limited", creds: creds, wantAuth: creds},
+		{name: "RetryAfter", mode: "busy", wantAuth: &types.DockerAuthConfig{}, wantWaits: []time.Duration{5 * time.Second, 5 * time.Second}},
+		{name: "Backoff", mode: "closed", wantWaits: []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second}, wantErr: true},
+		{name: "RetryTooLate", mode: "later", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRegistry(tt.mode)
+			defer r.Close()
+
+			var waits []time.Duration
+			a := &authorizer{
+				client: r.Client(),
+				scheme: "https",
+				now:    time.Now,
+				sleep: func(_ context.Context, d time.Duration) error {
+					waits = append(waits, d)
+					return nil
+				},
+			}
+
+			ref, err := reference.ParseNormalizedNamed(strings.TrimPrefix(r.URL, "https://") + "/library/alpine:3")
+			if err != nil {
+				t.Fatalf("failed to parse reference: %s", err)
+			}
+			sysCtx := &types.SystemContext{
+				SystemRegistriesConfPath: f.Name(),
+				DockerAuthConfig:         tt.explicit,
+			}
+
+			err = a.authorize(context.Background(), sysCtx, ref, tt.creds)
+			if tt.wantErr {
+				if !errors.Is(err, ErrRateLimited) {
+					t.Errorf("unexpected error: %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(sysCtx.DockerAuthConfig, tt.wantAuth) {
+				t.Errorf("got credentials %v, want %v", sysCtx.DockerAuthConfig, tt.wantAuth)
+			}
+			if !reflect.DeepEqual(waits, tt.wantWaits) {
+				t.Errorf("got waits %v, want %v", waits, tt.wantWaits)
+			}
+			// the pull uses the token of the lookup
+			if tt.wantAuth != nil && sysCtx.DockerBearerRegistryToken == "" {
+				t.Errorf("registry token not set")
+			} else if tt.wantAuth == nil && sysCtx.DockerBearerRegistryToken != "" {
+				t.Errorf("unexpected registry token %s", sysCtx.DockerBearerRegistryToken)
+			}
+
+			// tokens are cached for the next pulls
+			tokens := r.tokens
+			sysCtx.DockerAuthConfig = tt.explicit
+			sysCtx.DockerBearerRegistryToken = ""
+			a.sleep = func(context.Context, time.Duration) error { return nil }
+			a.authorize(context.Background(), sysCtx, ref, tt.creds)
+			if r.tokens != tokens {
+				t.Errorf("%d tokens requested again", r.tokens-tokens)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	r := newTestRegistry("public")
+	defer r.Close()
+
+	rejected := docker.ErrUnauthorizedForCredentials{Err: errors.New("unauthorized")}
+	tests := []struct {
+		name      string
+		errs      []error
+		expire    bool
+		wantToken string
+		wantPulls int
+		wantWaits []time.Duration
+		wantErr   error
+	}{
+		{name: "Success", wantToken: "anonymous", wantPulls: 1},
+		{name: "RateLimited", errs: []error{docker.ErrTooManyRequests, docker.ErrTooManyRequests}, wantToken: "anonymous", wantPulls: 3, wantWaits: []time.Duration{10 * time.Second, 20 * time.Second}},
+		{name: "RateLimitedRetries", errs: []error{docker.ErrTooManyRequests, docker.ErrTooManyRequests, docker.ErrTooManyRequests, docker.ErrTooManyRequests}, wantToken: "anonymous", wantPulls: 4, wantWaits: []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second}, wantErr: docker.ErrTooManyRequests},
+		{name: "Expired", errs: []error{rejected}, expire: true, wantToken: "anonymous", wantPulls: 2},
+		{name: "Rejected", errs: []error{rejected}, wantPulls: 2},
+		{name: "Failure", errs: []error{errors.New("failure")}, wantToken: "anonymous", wantPulls: 1, wantErr: errors.New("failure")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			var waits []time.Duration
+			a := &authorizer{
+				client: r.Client(),
+				scheme: "https",
+				now:    func() time.Time { return now },
+				sleep: func(_ context.Context, d time.Duration) error {
+					waits = append(waits, d)
+					return nil
+				},
+			}
+			ch := &challenge{realm: r.URL + "/token", service: "test"}
+			tok, err := a.token(context.Background(), ch, "repository:library/alpine:pull", nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tt.expire {
+				now = now.Add(time.Hour)
+			}
+			tokens := r.tokens
+
+			sysCtx := &types.SystemContext{DockerBearerRegistryToken: tok}
+			pulls := 0
+			err = a.retry(context.Background(), sysCtx, func() error {
+				pulls++
+				if len(tt.errs) >= pulls {
+					return tt.errs[pulls-1]
+				}
+				return nil
+			})
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			} else if tt.wantErr != nil && (err == nil || err.Error() != tt.wantErr.Error()) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if pulls != tt.wantPulls {
+				t.Errorf("got %d pulls, want %d", pulls, tt.wantPulls)
+			}
+			if !reflect.DeepEqual(waits, tt.wantWaits) {
+				t.Errorf("got waits %v, want %v", waits, tt.wantWaits)
+			}
+			if sysCtx.DockerBearerRegistryToken != tt.wantToken {
+				t.Errorf("got token %q, want %q", sysCtx.DockerBearerRegistryToken, tt.wantToken)
+			}
+			// the expired token is renewed
+			if tt.expire && r.tokens != tokens+1 {
+				t.Errorf("got %d token requests, want 1", r.tokens-tokens)
+			}
+		})
+	}
+}
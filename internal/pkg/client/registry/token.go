@@ -0,0 +1,211 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/types"
+)
+
+const (
+	// defaultTokenLifetime is the lifetime of the tokens issued without
+	// expiration, as defined by the docker token specification.
+	defaultTokenLifetime = 60 * time.Second
+	// tokenExpiryMargin is the remaining lifetime under which a cached
+	// token is renewed.
+	tokenExpiryMargin = 10 * time.Second
+)
+
+// challenge is the bearer authentication challenge of a registry.
+type challenge struct {
+	realm   string
+	service string
+}
+
+// parseChallenge parses the Bearer challenge of the WWW-Authenticate header
+// h, nil is returned for other authentication schemes.
+func parseChallenge(h string) *challenge {
+	parts := strings.SplitN(strings.TrimSpace(h), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") {
+		return nil
+	}
+
+	params := make(map[string]string)
+	s := parts[1]
+	for s != "" {
+		i := strings.Index(s, "=")
+		if i < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = strings.TrimSpace(s[i+1:])
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.Index(s[1:], `"`)
+			if end < 0 {
+				return nil
+			}
+			value, s = s[1:end+1], s[end+2:]
+		} else if end := strings.Index(s, ","); end >= 0 {
+			value, s = s[:end], s[end:]
+		} else {
+			value, s = s, ""
+		}
+		params[key] = value
+		s = strings.TrimPrefix(strings.TrimSpace(s), ",")
+	}
+
+	if params["realm"] == "" {
+		return nil
+	}
+	return &challenge{realm: params["realm"], service: params["service"]}
+}
+
+// token is a bearer token issued for a repository scope.
+type token struct {
+	value   string
+	expires time.Time
+}
+
+// tokenRequest is the request of a bearer token for a repository scope.
+type tokenRequest struct {
+	ch    *challenge
+	scope string
+	creds *types.DockerAuthConfig
+}
+
+// issuedToken is the request and expiration time of an issued token.
+type issuedToken struct {
+	request tokenRequest
+	expires time.Time
+}
+
+// tokenCache holds the tokens issued during the run, shared by all the pulls.
+type tokenCache struct {
+	mu     sync.Mutex
+	tokens map[string]token
+	issued map[string]issuedToken
+}
+
+// tokenKey returns the cache key of the token for scope issued to the
+// user of creds, anonymous if creds is nil.
+func tokenKey(ch *challenge, scope string, creds *types.DockerAuthConfig) string {
+	user := ""
+	if creds != nil {
+		user = creds.Username
+	}
+	return strings.Join([]string{ch.realm, ch.service, scope, user}, "|")
+}
+
+func (c *tokenCache) get(key string, now time.Time) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, ok := c.tokens[key]
+	if !ok || now.Add(tokenExpiryMargin).After(t.expires) {
+		return "", false
+	}
+	return t.value, true
+}
+
+func (c *tokenCache) put(key string, t token, r tokenRequest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tokens == nil {
+		c.tokens = make(map[string]token)
+		c.issued = make(map[string]issuedToken)
+	}
+	c.tokens[key] = t
+	c.issued[t.value] = issuedToken{request: r, expires: t.expires}
+}
+
+// lookup returns the request and expiration time of the token value issued
+// during the run, false if it wasn't.
+func (c *tokenCache) lookup(value string) (issuedToken, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, ok := c.issued[value]
+	return t, ok
+}
+
+// token returns a bearer token for scope from the cache, or requests it to
+// the authorization service of the challenge with the credentials creds,
+// anonymously if creds is nil.
+func (a *authorizer) token(ctx context.Context, ch *challenge, scope string, creds *types.DockerAuthConfig) (string, error) {
+	key := tokenKey(ch, scope, creds)
+	if t, ok := a.tokens.get(key, a.now()); ok {
+		return t, nil
+	}
+
+	u, err := url.Parse(ch.realm)
+	if err != nil {
+		return "", fmt.Errorf("invalid token realm %s: %s", ch.realm, err)
+	}
+	q := u.Query()
+	if ch.service != "" {
+		q.Set("service", ch.service)
+	}
+	q.Set("scope", scope)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	a.setUserAgent(req)
+	if creds != nil {
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+	res, err := a.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("while requesting token: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request to %s failed: %s", u.Host, res.Status)
+	}
+
+	var body struct {
+		Token       string    `json:"token"`
+		AccessToken string    `json:"access_token"`
+		ExpiresIn   int       `json:"expires_in"`
+		IssuedAt    time.Time `json:"issued_at"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("while decoding token: %s", err)
+	}
+	t := token{value: body.Token}
+	if t.value == "" {
+		t.value = body.AccessToken
+	}
+	if t.value == "" {
+		return "", fmt.Errorf("no token issued by %s", u.Host)
+	}
+
+	lifetime := time.Duration(body.ExpiresIn) * time.Second
+	if lifetime < defaultTokenLifetime {
+		lifetime = defaultTokenLifetime
+	}
+	issued := body.IssuedAt
+	if issued.IsZero() || issued.After(a.now()) {
+		issued = a.now()
+	}
+	t.expires = issued.Add(lifetime)
+
+	a.tokens.put(key, t, tokenRequest{ch: ch, scope: scope, creds: creds})
+	return t.value, nil
+}
@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -6,16 +6,12 @@
 package shub
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"net/url"
-	"time"
+	"strings"
+	"sync"
 
 	"github.com/hpcng/singularity/pkg/sylog"
-	useragent "github.com/hpcng/singularity/pkg/util/user-agent"
 )
 
 const (
@@ -25,6 +21,9 @@ const (
 	URINotSupported string = "Only the default Singularity Hub registry is supported for now"
 )
 
+// warnDeprecated warns once about the pulls from Singularity Hub.
+var warnDeprecated sync.Once
+
 // URI stores the various components of a singularityhub URI
 type URI struct {
 	registry  string
@@ -48,64 +47,27 @@ type APIResponse struct {
 }
 
 // GetManifest will return the image manifest for a container uri
-// from Singularity Hub.
+// from Singularity Hub, or a Singularity Hub compatible registry.
 func GetManifest(uri URI, noHTTPS bool) (APIResponse, error) {
-	// Create a new http Hub client
-	httpc := http.Client{
-		Timeout: 30 * time.Second,
-	}
-
-	if uri.registry != defaultRegistry+shubAPIRoute {
-		uri.registry = "https://" + uri.registry
-	}
-
-	// Create the request, add headers context
-	url, err := url.Parse(uri.registry + uri.user + "/" + uri.container + uri.tag + uri.digest)
+	c, err := NewClient(uri, noHTTPS)
 	if err != nil {
 		return APIResponse{}, err
 	}
-
-	if noHTTPS {
-		url.Scheme = "http"
+	if uri.registry == defaultRegistry+shubAPIRoute {
+		warnDeprecated.Do(func() {
+			sylog.Warningf("Singularity Hub is read-only and its support is deprecated, use a Singularity Registry Server with shub://<registry>/<collection>/<container>")
+		})
 	}
 
-	req, err := http.NewRequest(http.MethodGet, url.String(), nil)
-	if err != nil {
+	ctx := context.TODO()
+	manifest, err := c.Container(ctx, uri)
+	if err == ErrNotFound {
+		if tags, err := c.Tags(ctx, uri.user, uri.container); err == nil && len(tags) > 0 {
+			return APIResponse{}, fmt.Errorf("the requested manifest was not found in the registry, available tags: %s", strings.Join(tags, ", "))
+		}
+		return APIResponse{}, fmt.Errorf("the requested manifest was not found in the registry")
+	} else if err != nil {
 		return APIResponse{}, err
 	}
-	req.Header.Set("User-Agent", useragent.Value())
-
-	sylog.Debugf("shub request: %s", req.URL.String())
-
-	// Do the request, if status isn't success, return error
-	res, err := httpc.Do(req)
-	if res == nil {
-		return APIResponse{}, fmt.Errorf("no response received from singularity hub")
-	}
-	if res.StatusCode == http.StatusNotFound {
-		return APIResponse{}, fmt.Errorf("the requested manifest was not found in singularity hub")
-	}
-	sylog.Debugf("%s response received, beginning manifest download\n", res.Status)
-
-	if err != nil {
-		return APIResponse{}, err
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode != http.StatusOK {
-		err = errors.New(res.Status)
-		return APIResponse{}, err
-	}
-
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return APIResponse{}, err
-	}
-
-	var manifest APIResponse
-
-	err = json.Unmarshal(body, &manifest)
-	sylog.Debugf("manifest image name: %v\n", manifest.Name)
-
-	return manifest, err
+	return manifest, nil
 }
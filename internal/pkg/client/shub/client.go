@@ -0,0 +1,224 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package shub
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hpcng/singularity/pkg/sylog"
+	useragent "github.com/hpcng/singularity/pkg/util/user-agent"
+)
+
+// SecretsEnv is the environment variable setting the path of the sregistry
+// client secrets file, $HOME/.sregistry by default.
+const SecretsEnv = "SREGISTRY_CLIENT_SECRETS"
+
+// ErrNotFound is returned when the requested container or collection
+// doesn't exist in the registry.
+var ErrNotFound = errors.New("not found in registry")
+
+// Client is a client of the API of a Singularity Hub compatible registry,
+// like the self-hosted Singularity Registry Server (sregistry).
+type Client struct {
+	// BaseURL is the URL of the registry, without the API route.
+	BaseURL string
+	// Username and Token are the credentials of the registry user, they
+	// give access to the private collections.
+	Username string
+	Token    string
+	// HTTPClient is the client sending the requests.
+	HTTPClient *http.Client
+	// now returns the timestamp of the signed requests.
+	now func() time.Time
+}
+
+// Collection is a collection of containers of the registry.
+type Collection struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Private bool   `json:"private"`
+}
+
+// NewClient returns a client of the registry of uri, using the credentials
+// of the sregistry client secrets file when they are for this registry.
+func NewClient(uri URI, noHTTPS bool) (*Client, error) {
+	base := strings.TrimSuffix(uri.registry, shubAPIRoute)
+	if !strings.Contains(base, "://") {
+		base = "https://" + base
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry %s: %s", base, err)
+	}
+	if noHTTPS {
+		u.Scheme = "http"
+	}
+
+	c := &Client{
+		BaseURL:    u.String(),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		now:        time.Now,
+	}
+	if base != defaultRegistry {
+		c.Username, c.Token = registryCredentials(u.Host)
+	}
+	return c, nil
+}
+
+// secretsPath returns the path of the sregistry client secrets file.
+func secretsPath() string {
+	if p := os.Getenv(SecretsEnv); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".sregistry")
+}
+
+// registryCredentials returns the credentials of the registry host stored
+// in the sregistry client secrets file.
+func registryCredentials(host string) (username, token string) {
+	path := secretsPath()
+	if path == "" {
+		return "", ""
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			sylog.Warningf("While reading sregistry secrets %s: %s", path, err)
+		}
+		return "", ""
+	}
+
+	var secrets struct {
+		Registry struct {
+			Base     string `json:"base"`
+			Username string `json:"username"`
+			Token    string `json:"token"`
+		} `json:"registry"`
+	}
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		sylog.Warningf("Ignoring invalid sregistry secrets %s: %s", path, err)
+		return "", ""
+	}
+	r := secrets.Registry
+	if u, err := url.Parse(r.Base); err != nil || u.Host != host {
+		return "", ""
+	}
+	return r.Username, r.Token
+}
+
+// authorize signs the request req for action on collection with the token
+// of the client, like the sregistry client does.
+func (c *Client) authorize(req *http.Request, action, collection string) {
+	if c.Username == "" || c.Token == "" {
+		return
+	}
+	timestamp := strconv.FormatInt(c.now().Unix(), 10)
+	payload := strings.Join([]string{action, collection, timestamp, c.Username}, "|")
+	mac := hmac.New(sha256.New, []byte(c.Token))
+	mac.Write([]byte(payload))
+	signature := hex.EncodeToString(mac.Sum(nil))
+	req.Header.Set("Authorization", fmt.Sprintf("SREGISTRY-HMAC-SHA256 Credential=%s/%s,Signature=%s", action, timestamp, signature))
+}
+
+// get decodes the JSON response of the API route with the request signed for
+// action on collection.
+func (c *Client) get(ctx context.Context, route, action, collection string, v interface{}) error {
+	u := route
+	if !strings.Contains(route, "://") {
+		u = c.BaseURL + route
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", useragent.Value())
+	c.authorize(req, action, collection)
+
+	sylog.Debugf("shub request: %s", req.URL.String())
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("while requesting %s: %s", req.URL.Host, err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		if c.Token == "" {
+			return fmt.Errorf("access denied by %s: %s, set the registry credentials in %s", req.URL.Host, res.Status, secretsPath())
+		}
+		return fmt.Errorf("access denied by %s: %s", req.URL.Host, res.Status)
+	default:
+		return errors.New(res.Status)
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("while decoding response: %s", err)
+	}
+	return nil
+}
+
+// Container returns the manifest of the container of uri.
+func (c *Client) Container(ctx context.Context, uri URI) (APIResponse, error) {
+	var manifest APIResponse
+	route := shubAPIRoute + uri.user + "/" + uri.container + uri.tag + uri.digest
+	if err := c.get(ctx, route, "pull", uri.user, &manifest); err != nil {
+		return APIResponse{}, err
+	}
+	sylog.Debugf("manifest image name: %v\n", manifest.Name)
+	return manifest, nil
+}
+
+// Collections returns the collections of the registry visible to the user.
+func (c *Client) Collections(ctx context.Context) ([]Collection, error) {
+	var collections []Collection
+	route := "/api/collections/"
+	for route != "" {
+		var page struct {
+			Next    string       `json:"next"`
+			Results []Collection `json:"results"`
+		}
+		if err := c.get(ctx, route, "pull", "", &page); err != nil {
+			return nil, err
+		}
+		collections = append(collections, page.Results...)
+		route = page.Next
+	}
+	return collections, nil
+}
+
+// Tags returns the tags of the container name of collection.
+func (c *Client) Tags(ctx context.Context, collection, name string) ([]string, error) {
+	var containers []APIResponse
+	route := "/api/containers/search/collection/" + url.PathEscape(collection) + "/name/" + url.PathEscape(name) + "/"
+	if err := c.get(ctx, route, "pull", collection, &containers); err != nil {
+		return nil, err
+	}
+	tags := make([]string, 0, len(containers))
+	for _, ct := range containers {
+		tags = append(tags, ct.Tag)
+	}
+	return tags, nil
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package shub
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testRegistry is an sregistry server holding the private collection
+// private and the public collection public.
+type testRegistry struct {
+	*httptest.Server
+	token string
+}
+
+func newTestRegistry(token string) *testRegistry {
+	r := &testRegistry{token: token}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	return r
+}
+
+// authorized checks the signature of the request for collection.
+func (r *testRegistry) authorized(req *http.Request, collection string) bool {
+	var timestamp, signature string
+	auth := strings.TrimPrefix(req.Header.Get("Authorization"), "SREGISTRY-HMAC-SHA256 ")
+	if _, err := fmt.Sscanf(strings.Replace(auth, ",", " ", 1), "Credential=pull/%s Signature=%s", &timestamp, &signature); err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(r.token))
+	mac.Write([]byte("pull|" + collection + "|" + timestamp + "|user"))
+	return signature == hex.EncodeToString(mac.Sum(nil))
+}
+
+func (r *testRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "/api/container/public/alpine:3":
+		fmt.Fprintf(w, `{"image": "%s/download/alpine", "name": "alpine", "tag": "3", "version": "v1"}`, r.URL)
+	case "/api/container/private/alpine:3":
+		if !r.authorized(req, "private") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprintf(w, `{"image": "%s/download/private", "name": "alpine", "tag": "3"}`, r.URL)
+	case "/api/containers/search/collection/public/name/alpine/":
+		fmt.Fprint(w, `[{"name": "alpine", "tag": "3"}, {"name": "alpine", "tag": "edge"}]`)
+	case "/api/collections/":
+		fmt.Fprintf(w, `{"next": "%s/api/collections/2/", "results": [{"id": 1, "name": "public"}]}`, r.URL)
+	case "/api/collections/2/":
+		fmt.Fprint(w, `{"next": null, "results": [{"id": 2, "name": "private", "private": true}]}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestClient(t *testing.T) {
+	r := newTestRegistry("secret")
+	defer r.Close()
+	host := strings.TrimPrefix(r.URL, "http://")
+
+	dir, err := ioutil.TempDir("", "sregistry-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	secrets := filepath.Join(dir, "sregistry")
+	content := fmt.Sprintf(`{"registry": {"base": "https://%s", "username": "user", "token": "secret"}}`, host)
+	if err := ioutil.WriteFile(secrets, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write secrets: %s", err)
+	}
+	defer os.Unsetenv(SecretsEnv)
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		ref     string
+		secrets string
+		want    string
+		wantErr string
+	}{
+		{name: "Public", ref: "public/alpine:3", want: r.URL + "/download/alpine"},
+		{name: "PrivateAnonymous", ref: "private/alpine:3", wantErr: "access denied"},
+		{name: "Private", ref: "private/alpine:3", secrets: secrets, want: r.URL + "/download/private"},
+		{name: "UnknownTag", ref: "public/alpine:latest", wantErr: "available tags: 3, edge"},
+		{name: "Unknown", ref: "public/busybox:latest", wantErr: "not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv(SecretsEnv, filepath.Join(dir, "none"))
+			if tt.secrets != "" {
+				os.Setenv(SecretsEnv, tt.secrets)
+			}
+			uri, err := ParseReference("shub://" + host + "/" + tt.ref)
+			if err != nil {
+				t.Fatalf("failed to parse reference: %s", err)
+			}
+			manifest, err := GetManifest(uri, true)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if manifest.Image != tt.want {
+				t.Errorf("got image %s, want %s", manifest.Image, tt.want)
+			}
+		})
+	}
+
+	t.Run("Collections", func(t *testing.T) {
+		os.Setenv(SecretsEnv, secrets)
+		uri, err := ParseReference("shub://" + host + "/public/alpine")
+		if err != nil {
+			t.Fatalf("failed to parse reference: %s", err)
+		}
+		c, err := NewClient(uri, true)
+		if err != nil {
+			t.Fatalf("failed to create client: %s", err)
+		}
+		if c.Username != "user" || c.Token != "secret" {
+			t.Errorf("credentials not read from secrets: %q %q", c.Username, c.Token)
+		}
+		got, err := c.Collections(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		want := []Collection{{ID: 1, Name: "public"}, {ID: 2, Name: "private", Private: true}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got collections %v, want %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,388 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package devcontainer parses the devcontainer.json files describing
+// development containers and maps them onto the images, binds and
+// commands of a Singularity instance.
+package devcontainer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Config is the subset of a devcontainer.json file supported by
+// Singularity.
+type Config struct {
+	Name              string            `json:"name"`
+	Image             string            `json:"image"`
+	Build             *Build            `json:"build"`
+	Mounts            []Mount           `json:"mounts"`
+	WorkspaceMount    *Mount            `json:"workspaceMount"`
+	WorkspaceFolder   string            `json:"workspaceFolder"`
+	ContainerEnv      map[string]string `json:"containerEnv"`
+	RemoteEnv         map[string]string `json:"remoteEnv"`
+	ForwardPorts      []Port            `json:"forwardPorts"`
+	PostCreateCommand Command           `json:"postCreateCommand"`
+	Customizations    Customizations    `json:"customizations"`
+}
+
+// Build describes the image build of a development container.
+type Build struct {
+	Dockerfile string `json:"dockerfile"`
+	Context    string `json:"context"`
+}
+
+// Customizations holds the Singularity specific settings, read from
+// the customizations.singularity object.
+type Customizations struct {
+	Singularity struct {
+		// Definition is the path of a definition file, relative to
+		// the devcontainer.json file, the image is built from.
+		Definition string `json:"definition"`
+		// Fakeroot builds the definition file with --fakeroot.
+		Fakeroot bool `json:"fakeroot"`
+	} `json:"singularity"`
+}
+
+// Mount is a mount of a development container, given either as a
+// "source=...,target=...,type=..." string or as an object.
+type Mount struct {
+	Type     string `json:"type"`
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"readonly"`
+}
+
+// UnmarshalJSON decodes a mount string or object.
+func (m *Mount) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		type mount Mount
+		return json.Unmarshal(b, (*mount)(m))
+	}
+
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		value := ""
+		if len(kv) == 2 {
+			value = kv[1]
+		}
+		switch kv[0] {
+		case "type":
+			m.Type = value
+		case "source", "src":
+			m.Source = value
+		case "target", "destination", "dst":
+			m.Target = value
+		case "readonly", "ro":
+			m.ReadOnly = len(kv) == 1 || value == "true" || value == "1"
+		case "consistency", "":
+			// docker desktop specific
+		default:
+			return fmt.Errorf("unknown field %q in mount %q", kv[0], s)
+		}
+	}
+	return nil
+}
+
+// Spec returns the --mount specification of the mount.
+func (m Mount) Spec() string {
+	spec := fmt.Sprintf("type=%s,src=%s,dst=%s", m.Type, m.Source, m.Target)
+	if m.ReadOnly {
+		spec += ",ro"
+	}
+	return spec
+}
+
+// Port is a forwarded port, given as a number or a "host:port" string.
+type Port string
+
+// UnmarshalJSON decodes a port number or string.
+func (p *Port) UnmarshalJSON(b []byte) error {
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err == nil {
+		*p = Port(n.String())
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("port must be a number or a string")
+	}
+	*p = Port(s)
+	return nil
+}
+
+// Command is a lifecycle command, given as a string run by a shell,
+// as an array of arguments, or as an object of named commands.
+type Command [][]string
+
+// UnmarshalJSON decodes a command string, array or object.
+func (c *Command) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*c = Command{{"/bin/sh", "-c", s}}
+		return nil
+	}
+	var args []string
+	if err := json.Unmarshal(b, &args); err == nil {
+		*c = Command{args}
+		return nil
+	}
+	var named map[string]json.RawMessage
+	if err := json.Unmarshal(b, &named); err != nil {
+		return fmt.Errorf("command must be a string, an array or an object")
+	}
+	names := make([]string, 0, len(named))
+	for name := range named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var cmd Command
+		if err := cmd.UnmarshalJSON(named[name]); err != nil {
+			return fmt.Errorf("%s command: %s", name, err)
+		}
+		*c = append(*c, cmd...)
+	}
+	return nil
+}
+
+// Find returns the path of the devcontainer.json file of the project
+// directory workspace.
+func Find(workspace string) (string, error) {
+	for _, path := range []string{
+		filepath.Join(workspace, ".devcontainer", "devcontainer.json"),
+		filepath.Join(workspace, ".devcontainer.json"),
+	} {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no .devcontainer/devcontainer.json or .devcontainer.json file found in %s", workspace)
+}
+
+// Load reads and decodes the devcontainer.json file path, comments and
+// trailing commas are allowed.
+func Load(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("while reading %s: %s", path, err)
+	}
+	c := new(Config)
+	if err := json.Unmarshal(stripJSONC(b), c); err != nil {
+		return nil, fmt.Errorf("while decoding %s: %s", path, err)
+	}
+	return c, nil
+}
+
+// stripJSONC removes the comments and the trailing commas of a JSON
+// with comments document.
+func stripJSONC(b []byte) []byte {
+	var out bytes.Buffer
+	inString := false
+	// position of the last comma not followed yet by a value
+	comma := -1
+
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		if inString {
+			out.WriteByte(c)
+			if c == '\\' && i+1 < len(b) {
+				i++
+				out.WriteByte(b[i])
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+
+		switch {
+		case c == '/' && i+1 < len(b) && b[i+1] == '/':
+			for i < len(b) && b[i] != '\n' {
+				i++
+			}
+			out.WriteByte('\n')
+			continue
+		case c == '/' && i+1 < len(b) && b[i+1] == '*':
+			i += 2
+			for i+1 < len(b) && !(b[i] == '*' && b[i+1] == '/') {
+				i++
+			}
+			i++
+			continue
+		case c == '}' || c == ']':
+			if comma >= 0 {
+				out.Bytes()[comma] = ' '
+			}
+		case c == ',':
+			comma = out.Len()
+			out.WriteByte(c)
+			continue
+		case c == '"':
+			inString = true
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			comma = -1
+		}
+		out.WriteByte(c)
+	}
+	return out.Bytes()
+}
+
+// Plan describes the Singularity instance of a development container.
+type Plan struct {
+	// Name is the instance name.
+	Name string
+	// Image is the image URI, docker://<image> for the image field.
+	Image string
+	// Definition is the absolute path of the definition file the
+	// image is built from, when set Image is empty.
+	Definition string
+	Fakeroot   bool
+	// Mounts are the mounts of the instance, the workspace
+	// mount first.
+	Mounts []Mount
+	// Env are the --env variables of the instance.
+	Env []string
+	// Pwd is the working directory, the workspace folder.
+	Pwd string
+	// Ports are the forwarded ports, reachable on the host network.
+	Ports []string
+	// PostCreate are the commands executed once the instance started.
+	PostCreate [][]string
+}
+
+var nameRegexp = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// Plan maps the configuration, read from the devcontainer.json file
+// path, onto a Singularity instance for the project directory
+// workspace.
+func (c *Config) Plan(path, workspace string) (*Plan, error) {
+	workspace, err := filepath.Abs(workspace)
+	if err != nil {
+		return nil, fmt.Errorf("while determining workspace absolute path: %s", err)
+	}
+	configDir := filepath.Dir(path)
+
+	p := &Plan{Fakeroot: c.Customizations.Singularity.Fakeroot}
+
+	name := c.Name
+	if name == "" {
+		name = filepath.Base(workspace)
+	}
+	p.Name = strings.Trim(nameRegexp.ReplaceAllString(name, "-"), "-.")
+	if p.Name == "" {
+		p.Name = "devcontainer"
+	}
+
+	switch {
+	case c.Customizations.Singularity.Definition != "":
+		p.Definition = c.Customizations.Singularity.Definition
+		if !filepath.IsAbs(p.Definition) {
+			p.Definition = filepath.Join(configDir, p.Definition)
+		}
+	case c.Image != "":
+		p.Image = c.Image
+		if !strings.Contains(p.Image, "://") {
+			p.Image = "docker://" + p.Image
+		}
+	case c.Build != nil && c.Build.Dockerfile != "":
+		return nil, fmt.Errorf("Dockerfile builds are not supported, use an image or set customizations.singularity.definition to a definition file")
+	default:
+		return nil, fmt.Errorf("no image or definition file set")
+	}
+
+	folder := c.WorkspaceFolder
+	if folder == "" {
+		folder = "/workspaces/" + filepath.Base(workspace)
+	}
+
+	sum := sha256.Sum256([]byte(workspace))
+	vars := map[string]string{
+		"localWorkspaceFolder":             workspace,
+		"localWorkspaceFolderBasename":     filepath.Base(workspace),
+		"containerWorkspaceFolder":         folder,
+		"containerWorkspaceFolderBasename": filepath.Base(folder),
+		"devcontainerId":                   hex.EncodeToString(sum[:8]),
+	}
+	folder = expand(folder, vars)
+	p.Pwd = folder
+
+	workspaceMount := Mount{Type: "bind", Source: workspace, Target: folder}
+	if c.WorkspaceMount != nil {
+		workspaceMount = *c.WorkspaceMount
+	}
+	for _, m := range append([]Mount{workspaceMount}, c.Mounts...) {
+		m.Source = expand(m.Source, vars)
+		m.Target = expand(m.Target, vars)
+		if m.Type == "" {
+			m.Type = "bind"
+		}
+		if m.Type != "bind" && m.Type != "volume" {
+			return nil, fmt.Errorf("unsupported %s mount type, supported types are bind and volume", m.Type)
+		}
+		p.Mounts = append(p.Mounts, m)
+	}
+
+	// remote variables are set in the container too as there
+	// is no separate remote process environment
+	for _, env := range []map[string]string{c.ContainerEnv, c.RemoteEnv} {
+		keys := make([]string, 0, len(env))
+		for k := range env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p.Env = append(p.Env, k+"="+expand(env[k], vars))
+		}
+	}
+
+	for _, port := range c.ForwardPorts {
+		p.Ports = append(p.Ports, string(port))
+	}
+
+	for _, cmd := range c.PostCreateCommand {
+		args := make([]string, len(cmd))
+		for i, arg := range cmd {
+			args[i] = expand(arg, vars)
+		}
+		p.PostCreate = append(p.PostCreate, args)
+	}
+
+	return p, nil
+}
+
+var varRegexp = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// expand substitutes the ${name} and ${localEnv:NAME[:default]}
+// variables of s, other variables are left untouched.
+func expand(s string, vars map[string]string) string {
+	return varRegexp.ReplaceAllStringFunc(s, func(v string) string {
+		name := v[2 : len(v)-1]
+		if strings.HasPrefix(name, "localEnv:") {
+			kv := strings.SplitN(strings.TrimPrefix(name, "localEnv:"), ":", 2)
+			if value, ok := os.LookupEnv(kv[0]); ok {
+				return value
+			} else if len(kv) == 2 {
+				return kv[1]
+			}
+			return ""
+		}
+		if value, ok := vars[name]; ok {
+			return value
+		}
+		return v
+	})
+}
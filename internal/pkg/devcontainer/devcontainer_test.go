@@ -0,0 +1,110 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package devcontainer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testConfig = `{
+	// development environment
+	"name": "My Project",
+	"image": "mcr.microsoft.com/devcontainers/python:3", /* base image */
+	"mounts": [
+		"source=${localEnv:DEVCONTAINER_TEST_DATA:/data},target=/data,type=bind,consistency=cached",
+		{"source": "cache-${devcontainerId}", "target": "/cache", "type": "volume"},
+	],
+	"containerEnv": {"URL": "http://example.com/${containerWorkspaceFolderBasename}"},
+	"forwardPorts": [8888, "db:5432"],
+	"postCreateCommand": {
+		"pip": "pip install -r requirements.txt",
+		"check": ["python", "--version"],
+	},
+}
+`
+
+func TestPlan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "devcontainer-")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := Find(dir); err == nil {
+		t.Errorf("unexpected success without devcontainer.json")
+	}
+
+	path := filepath.Join(dir, ".devcontainer.json")
+	if err := ioutil.WriteFile(path, []byte(testConfig), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	found, err := Find(dir)
+	if err != nil || found != path {
+		t.Fatalf("got %s (%v) instead of %s", found, err, path)
+	}
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	p, err := c.Plan(path, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	sum := sha256.Sum256([]byte(dir))
+	base := filepath.Base(dir)
+	folder := "/workspaces/" + base
+	expected := &Plan{
+		Name:  "My-Project",
+		Image: "docker://mcr.microsoft.com/devcontainers/python:3",
+		Mounts: []Mount{
+			{Type: "bind", Source: dir, Target: folder},
+			{Type: "bind", Source: "/data", Target: "/data"},
+			{Type: "volume", Source: "cache-" + hex.EncodeToString(sum[:8]), Target: "/cache"},
+		},
+		Env:   []string{"URL=http://example.com/" + base},
+		Pwd:   folder,
+		Ports: []string{"8888", "db:5432"},
+		PostCreate: [][]string{
+			{"python", "--version"},
+			{"/bin/sh", "-c", "pip install -r requirements.txt"},
+		},
+	}
+	if !reflect.DeepEqual(p, expected) {
+		t.Errorf("got plan %+v instead of %+v", p, expected)
+	}
+
+	if spec := p.Mounts[1].Spec(); spec != "type=bind,src=/data,dst=/data" {
+		t.Errorf("unexpected mount specification %s", spec)
+	}
+
+	c = &Config{Build: &Build{Dockerfile: "Dockerfile"}}
+	if _, err := c.Plan(path, dir); err == nil {
+		t.Errorf("unexpected success with a Dockerfile build")
+	}
+	c.Customizations.Singularity.Definition = "devel.def"
+	if p, err := c.Plan(path, dir); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	} else if p.Definition != filepath.Join(dir, "devel.def") {
+		t.Errorf("got definition %s instead of %s", p.Definition, filepath.Join(dir, "devel.def"))
+	}
+}
+
+func TestStripJSONC(t *testing.T) {
+	in := `{"a": "// not a comment", /* comment */ "b": [1, 2,], // comment
+"c": {"d": "e",},}`
+	expected := `{"a": "// not a comment",  "b": [1, 2 ], ` + "\n" + `"c": {"d": "e" } }`
+	if out := string(stripJSONC([]byte(in))); out != expected {
+		t.Errorf("got %q instead of %q", out, expected)
+	}
+}
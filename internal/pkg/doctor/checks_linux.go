@@ -0,0 +1,282 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package doctor
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/hpcng/singularity/internal/pkg/fakeroot"
+	"github.com/hpcng/singularity/internal/pkg/remote/endpoint"
+	"github.com/hpcng/singularity/internal/pkg/util/fs/mount"
+	"github.com/hpcng/singularity/internal/pkg/util/user"
+	"github.com/hpcng/singularity/pkg/util/fs/proc"
+	"github.com/hpcng/singularity/pkg/util/singularityconf"
+)
+
+// minIDRange is the size of the subordinate ID ranges required by most
+// images with fakeroot.
+const minIDRange = 65536
+
+// System describes the host checked.
+type System struct {
+	// Root is the prefix of the /proc, /sys and /dev paths, empty for
+	// the host.
+	Root string
+	// UID is the user running the containers.
+	UID uint32
+	// Config is the configuration parsed from ConfigFile, nil if it
+	// couldn't be parsed.
+	Config     *singularityconf.File
+	ConfigFile string
+	// SubUID and SubGID are the subordinate ID files of fakeroot.
+	SubUID string
+	SubGID string
+	// StarterSuid is the path of the setuid starter and SuidInstall is
+	// true for a setuid installation.
+	StarterSuid string
+	SuidInstall bool
+	// Seccomp is true if singularity is built with seccomp support.
+	Seccomp bool
+	// RemoteURI is the URI of the remote endpoint and Services returns
+	// its services, the remote checks are skipped if Services is nil.
+	RemoteURI string
+	Services  func() (map[string][]endpoint.Service, error)
+}
+
+// Checks returns the checks of the system.
+func (s *System) Checks() []Check {
+	return []Check{
+		{"kernel", "user namespaces", s.checkUserNamespaces},
+		{"kernel", "overlay", func() Result {
+			return s.checkFilesystem("overlay", "--overlay and --writable-tmpfs are unavailable and the bind mount points are created with underlay")
+		}},
+		{"kernel", "squashfs", func() Result {
+			return s.checkFilesystem("squashfs", "SIF images are extracted to a temporary sandbox or mounted with squashfuse")
+		}},
+		{"kernel", "seccomp", s.checkSeccomp},
+		{"kernel", "cgroups v2", s.checkCgroups},
+		{"configuration", "singularity.conf", s.checkConfig},
+		{"configuration", "subuid", func() Result { return s.checkIDRange(s.SubUID, "UID") }},
+		{"configuration", "subgid", func() Result { return s.checkIDRange(s.SubGID, "GID") }},
+		{"configuration", "starter-suid", s.checkStarterSuid},
+		{"configuration", "loop devices", s.checkLoopDevices},
+		{"remote", "endpoint", s.checkRemote},
+	}
+}
+
+func (s *System) path(path string) string {
+	return filepath.Join(s.Root, path)
+}
+
+// readInt reads the integer of the file path.
+func (s *System) readInt(path string) (int, error) {
+	b, err := ioutil.ReadFile(s.path(path))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+func (s *System) checkUserNamespaces() Result {
+	// the setuid workflow runs containers without user namespace
+	limited := fail
+	if s.SuidInstall {
+		limited = warn
+	}
+
+	max, err := s.readInt("/proc/sys/user/max_user_namespaces")
+	if os.IsNotExist(err) {
+		return limited("the kernel doesn't support user namespaces, --userns and --fakeroot are unavailable",
+			"use a kernel built with CONFIG_USER_NS")
+	} else if err != nil {
+		return fail(fmt.Sprintf("can't read the user namespaces limit: %s", err), "")
+	}
+	if max == 0 {
+		return limited("user namespaces are disabled by user.max_user_namespaces, --userns and --fakeroot are unavailable",
+			"enable them with 'sysctl -w user.max_user_namespaces=15000' and in /etc/sysctl.conf")
+	}
+	// Debian and Ubuntu kernels may disable unprivileged user namespaces
+	if clone, err := s.readInt("/proc/sys/kernel/unprivileged_userns_clone"); err == nil && clone == 0 {
+		return limited("unprivileged user namespaces are disabled by kernel.unprivileged_userns_clone, --userns and --fakeroot are unavailable",
+			"enable them with 'sysctl -w kernel.unprivileged_userns_clone=1' and in /etc/sysctl.conf")
+	}
+	return passf("%d user namespaces allowed", max)
+}
+
+func (s *System) checkFilesystem(fs, impact string) Result {
+	f, err := os.Open(s.path("/proc/filesystems"))
+	if err != nil {
+		return fail(fmt.Sprintf("can't read the kernel filesystems: %s", err), "")
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.HasSuffix(scanner.Text(), "\t"+fs) {
+			return passf("%s filesystem supported", fs)
+		}
+	}
+	return warn(fmt.Sprintf("the kernel doesn't support the %s filesystem, %s", fs, impact),
+		fmt.Sprintf("load the %s module with 'modprobe %s'", fs, fs))
+}
+
+func (s *System) checkSeccomp() Result {
+	if !s.Seccomp {
+		return warn("singularity is built without seccomp support, --security seccomp: and the OCI seccomp profiles are unavailable",
+			"install the libseccomp development package and build singularity again")
+	}
+	f, err := os.Open(s.path("/proc/self/status"))
+	if err != nil {
+		return fail(fmt.Sprintf("can't read the process status: %s", err), "")
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "Seccomp:") {
+			return passf("seccomp filters supported")
+		}
+	}
+	return warn("the kernel doesn't support seccomp filters", "use a kernel built with CONFIG_SECCOMP_FILTER")
+}
+
+func (s *System) checkCgroups() Result {
+	b, err := ioutil.ReadFile(s.path("/sys/fs/cgroup/cgroup.controllers"))
+	if os.IsNotExist(err) {
+		return warn("the host uses the cgroups v1 hierarchy, resource limits require root",
+			"boot the host with systemd.unified_cgroup_hierarchy=1 to use the cgroups v2 unified hierarchy")
+	} else if err != nil {
+		return fail(fmt.Sprintf("can't read the cgroups controllers: %s", err), "")
+	}
+	return passf("unified hierarchy with controllers %s", strings.Join(strings.Fields(string(b)), ","))
+}
+
+func (s *System) checkConfig() Result {
+	if s.Config == nil {
+		return fail(fmt.Sprintf("%s can't be parsed", s.ConfigFile),
+			fmt.Sprintf("fix the directives of %s or generate it again with 'singularity config global'", s.ConfigFile))
+	}
+	return passf("%s parsed", s.ConfigFile)
+}
+
+func (s *System) checkIDRange(path, kind string) Result {
+	if s.UID == 0 {
+		return skipf("fakeroot mappings are not used by root")
+	}
+	pw, err := user.GetPwUID(s.UID)
+	if err != nil {
+		return fail(fmt.Sprintf("can't find the user with UID %d: %s", s.UID, err), "")
+	}
+	fix := fmt.Sprintf("ask the administrator to map subordinate IDs with 'singularity config fakeroot --add %s'", pw.Name)
+
+	r, err := fakeroot.GetIDRange(path, s.UID)
+	if err != nil {
+		return warn(fmt.Sprintf("no subordinate %s of %s in %s, --fakeroot is unavailable: %s", kind, pw.Name, path, err), fix)
+	}
+	if r.Size < minIDRange {
+		return warn(fmt.Sprintf("%d subordinate %ss mapped for %s, less than the %d required by most images", r.Size, kind, pw.Name, minIDRange), fix)
+	}
+	return passf("%d subordinate %ss from %d mapped for %s", r.Size, kind, r.HostID, pw.Name)
+}
+
+func (s *System) checkStarterSuid() Result {
+	if !s.SuidInstall {
+		return skipf("unprivileged installation")
+	}
+	if s.Config != nil && !s.Config.AllowSetuid {
+		return skipf("setuid workflow disabled by 'allow setuid' in %s", s.ConfigFile)
+	}
+	fi, err := os.Stat(s.StarterSuid)
+	if err != nil {
+		return fail(err.Error(), "install singularity again with 'make install' as root")
+	}
+
+	fix := fmt.Sprintf("run 'chown root:root %[1]s && chmod 4755 %[1]s' as root", s.StarterSuid)
+	st := fi.Sys().(*syscall.Stat_t)
+	if st.Uid != 0 {
+		return fail(fmt.Sprintf("%s is owned by UID %d instead of root", s.StarterSuid, st.Uid), fix)
+	}
+	if fi.Mode()&os.ModeSetuid == 0 {
+		return fail(fmt.Sprintf("%s doesn't have the setuid bit", s.StarterSuid), fix)
+	}
+	if fi.Mode()&0022 != 0 {
+		return fail(fmt.Sprintf("%s is writable by group or others", s.StarterSuid), fix)
+	}
+
+	entries, err := proc.GetMountInfoEntry(s.path("/proc/self/mountinfo"))
+	if err == nil {
+		e, err := proc.FindParentMountEntry(s.StarterSuid, entries)
+		if err == nil && mount.HasOption(e.Options, "nosuid") {
+			return fail(fmt.Sprintf("%s is on the nosuid mount %s", s.StarterSuid, e.Point),
+				fmt.Sprintf("remount %s without nosuid or install singularity on another filesystem", e.Point))
+		}
+	}
+	return passf("%s is setuid root", s.StarterSuid)
+}
+
+func (s *System) checkLoopDevices() Result {
+	if s.Config != nil && s.Config.MaxLoopDevices == 0 {
+		return fail(fmt.Sprintf("'max loop devices' is 0 in %s, SIF images can't be mounted", s.ConfigFile),
+			fmt.Sprintf("set 'max loop devices = 256' in %s", s.ConfigFile))
+	}
+	devices, _ := filepath.Glob(s.path("/dev/loop[0-9]*"))
+	if _, err := os.Stat(s.path("/dev/loop-control")); err != nil && len(devices) == 0 {
+		return warn("no loop device found, SIF images can't be mounted in the setuid workflow",
+			"load the loop module with 'modprobe loop'")
+	}
+	max := uint(0)
+	if s.Config != nil {
+		max = s.Config.MaxLoopDevices
+	}
+	return passf("%d loop devices, %d allowed by the configuration", len(devices), max)
+}
+
+func (s *System) checkRemote() Result {
+	if s.Services == nil {
+		return skipf("remote checks disabled")
+	}
+	services, err := s.Services()
+	if err != nil {
+		return fail(fmt.Sprintf("can't retrieve the services of %s: %s", s.RemoteURI, err),
+			"check the network connection, the HTTPS_PROXY settings and the remote with 'singularity remote list'")
+	}
+
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var ok, failed []string
+	for _, name := range names {
+		for _, service := range services[name] {
+			version, err := service.Status()
+			if err == endpoint.ErrStatusNotSupported {
+				continue
+			} else if err != nil {
+				failed = append(failed, fmt.Sprintf("%s (%s): %s", name, service.URI(), err))
+			} else {
+				ok = append(ok, fmt.Sprintf("%s %s", name, version))
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return fail(fmt.Sprintf("%s unreachable", strings.Join(failed, ", ")),
+			"check the network connection, the HTTPS_PROXY settings and the status with 'singularity remote status'")
+	}
+	if len(ok) == 0 {
+		return skipf("%s has no service with a status", s.RemoteURI)
+	}
+	return passf("%s reachable: %s", s.RemoteURI, strings.Join(ok, ", "))
+}
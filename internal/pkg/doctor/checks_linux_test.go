@@ -0,0 +1,265 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package doctor
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hpcng/singularity/internal/pkg/remote/endpoint"
+	"github.com/hpcng/singularity/pkg/util/singularityconf"
+)
+
+// tempDir returns a temporary directory.
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "doctor-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// writeFiles creates the files of contents in the root directory.
+func writeFiles(t *testing.T, root string, contents map[string]string) {
+	for path, content := range contents {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	checks := []Check{
+		{"kernel", "a", func() Result { return passf("ok") }},
+		{"kernel", "b", func() Result { return fail("broken", "repair it") }},
+		{"remote", "c", func() Result { return Result{Status: Skip, Message: "skipped", Fix: "ignored"} }},
+	}
+
+	var b bytes.Buffer
+	if n := Run(&b, checks); n != 1 {
+		t.Errorf("got %d failed checks instead of 1", n)
+	}
+	expected := `KERNEL
+  [PASS] a: ok
+  [FAIL] b: broken
+         fix: repair it
+
+REMOTE
+  [SKIP] c: skipped
+`
+	if b.String() != expected {
+		t.Errorf("got output:\n%s\ninstead of:\n%s", b.String(), expected)
+	}
+}
+
+func TestKernelChecks(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  map[string]string
+		suid   bool
+		check  func(s *System) Result
+		status Status
+	}{
+		{
+			name:   "UserNamespaces",
+			files:  map[string]string{"proc/sys/user/max_user_namespaces": "15000\n"},
+			check:  (*System).checkUserNamespaces,
+			status: Pass,
+		},
+		{
+			name:   "UserNamespacesDisabled",
+			files:  map[string]string{"proc/sys/user/max_user_namespaces": "0\n"},
+			check:  (*System).checkUserNamespaces,
+			status: Fail,
+		},
+		{
+			name:   "UserNamespacesDisabledSuid",
+			files:  map[string]string{"proc/sys/user/max_user_namespaces": "0\n"},
+			suid:   true,
+			check:  (*System).checkUserNamespaces,
+			status: Warn,
+		},
+		{
+			name: "UnprivilegedUserNamespacesDisabled",
+			files: map[string]string{
+				"proc/sys/user/max_user_namespaces":         "15000\n",
+				"proc/sys/kernel/unprivileged_userns_clone": "0\n",
+			},
+			check:  (*System).checkUserNamespaces,
+			status: Fail,
+		},
+		{
+			name:   "Overlay",
+			files:  map[string]string{"proc/filesystems": "nodev\tproc\nnodev\toverlay\n"},
+			check:  func(s *System) Result { return s.checkFilesystem("overlay", "") },
+			status: Pass,
+		},
+		{
+			name:   "NoOverlay",
+			files:  map[string]string{"proc/filesystems": "nodev\tproc\n\text4\n"},
+			check:  func(s *System) Result { return s.checkFilesystem("overlay", "") },
+			status: Warn,
+		},
+		{
+			name:   "Seccomp",
+			files:  map[string]string{"proc/self/status": "Name:\ttest\nSeccomp:\t0\n"},
+			check:  (*System).checkSeccomp,
+			status: Pass,
+		},
+		{
+			name:   "CgroupsV2",
+			files:  map[string]string{"sys/fs/cgroup/cgroup.controllers": "cpu memory pids\n"},
+			check:  (*System).checkCgroups,
+			status: Pass,
+		},
+		{
+			name:   "CgroupsV1",
+			files:  map[string]string{"sys/fs/cgroup/memory/tasks": ""},
+			check:  (*System).checkCgroups,
+			status: Warn,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &System{Root: tempDir(t), SuidInstall: tt.suid, Seccomp: true}
+			defer os.RemoveAll(s.Root)
+			writeFiles(t, s.Root, tt.files)
+			if r := tt.check(s); r.Status != tt.status {
+				t.Errorf("got status %s instead of %s: %s", r.Status, tt.status, r.Message)
+			}
+		})
+	}
+}
+
+func TestCheckIDRange(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"subuid": "nobody:100000:65536\n",
+		"subgid": "nobody:100000:1000\n",
+	})
+
+	s := &System{UID: 65534}
+	if r := s.checkIDRange(filepath.Join(dir, "subuid"), "UID"); r.Status != Pass {
+		t.Errorf("got status %s instead of PASS: %s", r.Status, r.Message)
+	}
+	if r := s.checkIDRange(filepath.Join(dir, "subgid"), "GID"); r.Status != Warn {
+		t.Errorf("got status %s instead of WARN for a small range: %s", r.Status, r.Message)
+	}
+	if r := s.checkIDRange(filepath.Join(dir, "missing"), "UID"); r.Status != Warn || !strings.Contains(r.Fix, "--add nobody") {
+		t.Errorf("got status %s with fix %q instead of WARN for a missing file", r.Status, r.Fix)
+	}
+	s.UID = 0
+	if r := s.checkIDRange(filepath.Join(dir, "missing"), "UID"); r.Status != Skip {
+		t.Errorf("got status %s instead of SKIP for root", r.Status)
+	}
+}
+
+func TestCheckConfiguration(t *testing.T) {
+	config, err := singularityconf.Parse("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &System{Root: tempDir(t), Config: config, ConfigFile: "singularity.conf"}
+	defer os.RemoveAll(s.Root)
+	if r := s.checkStarterSuid(); r.Status != Skip {
+		t.Errorf("got starter-suid status %s instead of SKIP for an unprivileged installation", r.Status)
+	}
+	s.SuidInstall = true
+	s.StarterSuid = filepath.Join(s.Root, "starter-suid")
+	if r := s.checkStarterSuid(); r.Status != Fail {
+		t.Errorf("got starter-suid status %s instead of FAIL for a missing starter", r.Status)
+	}
+
+	if r := s.checkLoopDevices(); r.Status != Warn {
+		t.Errorf("got loop devices status %s instead of WARN without loop devices", r.Status)
+	}
+	writeFiles(t, s.Root, map[string]string{"dev/loop-control": "", "dev/loop0": ""})
+	if r := s.checkLoopDevices(); r.Status != Pass {
+		t.Errorf("got loop devices status %s instead of PASS: %s", r.Status, r.Message)
+	}
+	config.MaxLoopDevices = 0
+	if r := s.checkLoopDevices(); r.Status != Fail {
+		t.Errorf("got loop devices status %s instead of FAIL with max loop devices 0", r.Status)
+	}
+
+	s.Config = nil
+	if r := s.checkConfig(); r.Status != Fail {
+		t.Errorf("got configuration status %s instead of FAIL without configuration", r.Status)
+	}
+}
+
+type testService struct {
+	version string
+	err     error
+}
+
+func (s testService) URI() string {
+	return "https://service.example.com"
+}
+
+func (s testService) Status() (string, error) {
+	return s.version, s.err
+}
+
+func TestCheckRemote(t *testing.T) {
+	tests := []struct {
+		name     string
+		services map[string][]endpoint.Service
+		err      error
+		status   Status
+	}{
+		{
+			name: "Reachable",
+			services: map[string][]endpoint.Service{
+				"library": {testService{version: "v1"}},
+				"consent": {testService{err: endpoint.ErrStatusNotSupported}},
+			},
+			status: Pass,
+		},
+		{
+			name: "Unreachable",
+			services: map[string][]endpoint.Service{
+				"library": {testService{err: errors.New("connection refused")}},
+			},
+			status: Fail,
+		},
+		{
+			name:   "NoServices",
+			err:    errors.New("no such host"),
+			status: Fail,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &System{
+				RemoteURI: "cloud.example.com",
+				Services: func() (map[string][]endpoint.Service, error) {
+					return tt.services, tt.err
+				},
+			}
+			if r := s.checkRemote(); r.Status != tt.status {
+				t.Errorf("got status %s instead of %s: %s", r.Status, tt.status, r.Message)
+			}
+		})
+	}
+
+	if r := (&System{}).checkRemote(); r.Status != Skip {
+		t.Errorf("got status %s instead of SKIP without services", r.Status)
+	}
+}
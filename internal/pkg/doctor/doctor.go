@@ -0,0 +1,106 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package doctor checks the host kernel features, the installation
+// configuration and the remote connectivity required by singularity and
+// reports the fixes of the failed checks.
+package doctor
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Status is the status of a check.
+type Status int
+
+// Check statuses.
+const (
+	// Pass is the status of a successful check.
+	Pass Status = iota
+	// Warn is the status of a check finding a limitation, some
+	// features are not available.
+	Warn
+	// Fail is the status of a check finding an error preventing
+	// containers to run.
+	Fail
+	// Skip is the status of a check not applicable to the host.
+	Skip
+)
+
+func (s Status) String() string {
+	switch s {
+	case Pass:
+		return "PASS"
+	case Warn:
+		return "WARN"
+	case Fail:
+		return "FAIL"
+	case Skip:
+		return "SKIP"
+	}
+	return "UNKNOWN"
+}
+
+// Result is the result of a check.
+type Result struct {
+	Status  Status
+	Message string
+	// Fix is the remediation of a warning or a failure.
+	Fix string
+}
+
+// Check is a named check of a category.
+type Check struct {
+	Category string
+	Name     string
+	Run      func() Result
+}
+
+// passf returns a Pass result with a formatted message.
+func passf(format string, a ...interface{}) Result {
+	return Result{Status: Pass, Message: fmt.Sprintf(format, a...)}
+}
+
+// skipf returns a Skip result with a formatted message.
+func skipf(format string, a ...interface{}) Result {
+	return Result{Status: Skip, Message: fmt.Sprintf(format, a...)}
+}
+
+// warn returns a Warn result with its message and fix.
+func warn(message, fix string) Result {
+	return Result{Status: Warn, Message: message, Fix: fix}
+}
+
+// fail returns a Fail result with its message and fix.
+func fail(message, fix string) Result {
+	return Result{Status: Fail, Message: message, Fix: fix}
+}
+
+// Run runs checks, writes their results to w grouped by category and
+// returns the number of failed checks.
+func Run(w io.Writer, checks []Check) int {
+	failed := 0
+	category := ""
+	for _, c := range checks {
+		if c.Category != category {
+			if category != "" {
+				fmt.Fprintln(w)
+			}
+			category = c.Category
+			fmt.Fprintln(w, strings.ToUpper(category))
+		}
+		r := c.Run()
+		if r.Status == Fail {
+			failed++
+		}
+		fmt.Fprintf(w, "  [%s] %s: %s\n", r.Status, c.Name, r.Message)
+		if r.Fix != "" && (r.Status == Warn || r.Status == Fail) {
+			fmt.Fprintf(w, "         fix: %s\n", r.Fix)
+		}
+	}
+	return failed
+}
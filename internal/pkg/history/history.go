@@ -0,0 +1,165 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package history stores the provenance records of the containers run
+// with --history in the user history directory.
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hpcng/singularity/pkg/syfs"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+// Record is the provenance record of a container run.
+type Record struct {
+	// ID identifies the record, it is derived from the start time and
+	// the container process PID.
+	ID string `json:"id"`
+	// Image is the path of the image on the host.
+	Image string `json:"image"`
+	// ImageDigest is the digest of the image file, directory images
+	// have no digest.
+	ImageDigest string `json:"imageDigest,omitempty"`
+	// Signers are the fingerprints of the entities which signed the
+	// image, verified with the local and global public keyrings.
+	Signers  []string `json:"signers"`
+	Instance string   `json:"instance,omitempty"`
+	Command  []string `json:"command"`
+	Cwd      string   `json:"cwd"`
+	Binds    []string `json:"binds"`
+	// Flags are the names of the command line flags, their values are
+	// not recorded as they could hold sensitive data.
+	Flags    []string  `json:"flags"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// ExitCode is the exit code of the container process, unset when it
+	// was killed by Signal.
+	ExitCode  *int   `json:"exitCode,omitempty"`
+	Signal    string `json:"signal,omitempty"`
+	OOMKilled bool   `json:"oomKilled,omitempty"`
+}
+
+// Duration returns the wall time of the run.
+func (r *Record) Duration() time.Duration {
+	return r.Finished.Sub(r.Started)
+}
+
+// Status returns a short description of how the run ended.
+func (r *Record) Status() string {
+	switch {
+	case r.OOMKilled:
+		return "oom killed"
+	case r.Signal != "":
+		return "signal " + r.Signal
+	case r.ExitCode != nil:
+		return fmt.Sprintf("exit %d", *r.ExitCode)
+	}
+	return "unknown"
+}
+
+// Dir returns the history directory of the user singularity directory.
+func Dir() string {
+	return filepath.Join(syfs.ConfigDir(), "history")
+}
+
+// NewID returns the ID of the record of the container process pid
+// started at started.
+func NewID(started time.Time, pid int) string {
+	return fmt.Sprintf("%s-%d", started.UTC().Format("20060102T150405"), pid)
+}
+
+// Write writes the record r to the history directory dir.
+func Write(dir string, r *Record) error {
+	b, err := json.MarshalIndent(r, "", "\t")
+	if err != nil {
+		return fmt.Errorf("while encoding history record: %s", err)
+	}
+	path := filepath.Join(dir, r.ID+".json")
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(b, '\n'), 0600); err != nil {
+		return fmt.Errorf("while writing history record: %s", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("while writing history record: %s", err)
+	}
+	return nil
+}
+
+// List returns the records of the history directory dir, oldest first.
+func List(dir string) ([]Record, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]Record, 0, len(files))
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("while reading history record: %s", err)
+		}
+		var r Record
+		if err := json.Unmarshal(b, &r); err != nil || r.ID == "" {
+			sylog.Warningf("Ignoring invalid history record %s", file)
+			continue
+		}
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Started.Before(records[j].Started) })
+	return records, nil
+}
+
+// Find returns the record of the history directory dir identified by
+// an ID prefix.
+func Find(dir, id string) (*Record, error) {
+	records, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []Record
+	for _, r := range records {
+		if strings.HasPrefix(r.ID, id) {
+			found = append(found, r)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no history record %s found", id)
+	case 1:
+		return &found[0], nil
+	}
+	return nil, fmt.Errorf("%d history records match %s", len(found), id)
+}
+
+// Remove removes the records of the history directory dir finished
+// before t.
+func Remove(dir string, t time.Time) (int, error) {
+	records, err := List(dir)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, r := range records {
+		if !r.Finished.Before(t) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, r.ID+".json")); err != nil {
+			return n, fmt.Errorf("while removing history record %s: %s", r.ID, err)
+		}
+		n++
+	}
+	return n, nil
+}
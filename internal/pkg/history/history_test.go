@@ -0,0 +1,67 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	code := 3
+	records := []*Record{
+		{ID: NewID(now, 20), Image: "/b.sif", Started: now, Finished: now.Add(time.Second), ExitCode: &code},
+		{ID: NewID(now.Add(-time.Hour), 10), Image: "/a.sif", Started: now.Add(-time.Hour), Finished: now.Add(-time.Minute), Signal: "killed"},
+	}
+	for _, r := range records {
+		if err := Write(dir, r); err != nil {
+			t.Fatalf("failed to write record: %s", err)
+		}
+	}
+	ioutil.WriteFile(dir+"/invalid.json", []byte("{"), 0600)
+
+	list, err := List(dir)
+	if err != nil {
+		t.Fatalf("failed to list records: %s", err)
+	}
+	if len(list) != 2 || list[0].Image != "/a.sif" || list[1].Image != "/b.sif" {
+		t.Fatalf("unexpected records %v", list)
+	}
+	if s := list[0].Status(); s != "signal killed" {
+		t.Errorf("unexpected status %q", s)
+	}
+	if s := list[1].Status(); s != "exit 3" {
+		t.Errorf("unexpected status %q", s)
+	}
+
+	if _, err := Find(dir, ""); err == nil {
+		t.Errorf("unexpected success finding an ambiguous record")
+	}
+	r, err := Find(dir, records[0].ID)
+	if err != nil {
+		t.Fatalf("failed to find record: %s", err)
+	}
+	if r.Image != "/b.sif" || r.Duration() != time.Second {
+		t.Errorf("unexpected record %v", r)
+	}
+
+	n, err := Remove(dir, now)
+	if err != nil || n != 1 {
+		t.Fatalf("unexpected removal of %d records: %v", n, err)
+	}
+	if list, _ := List(dir); len(list) != 1 || list[0].Image != "/b.sif" {
+		t.Errorf("unexpected records after removal %v", list)
+	}
+}
@@ -15,6 +15,8 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/hpcng/singularity/internal/pkg/buildcfg"
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
 	"github.com/hpcng/singularity/internal/pkg/util/user"
 	"github.com/hpcng/singularity/pkg/syfs"
 )
@@ -36,6 +38,10 @@ const (
 	prognameFormat  = "%s: %s [%s]"
 )
 
+// SystemDir is the directory where system instance files started by
+// root and shared with other users are stored.
+var SystemDir = filepath.Join(buildcfg.LOCALSTATEDIR, "singularity", "instances")
+
 // File represents an instance file storing instance information
 type File struct {
 	Path       string `json:"-"`
@@ -49,6 +55,22 @@ type File struct {
 	IP         string `json:"ip"`
 	LogErrPath string `json:"logErrPath"`
 	LogOutPath string `json:"logOutPath"`
+	// System is true for system instances visible to other users.
+	System bool `json:"system,omitempty"`
+	// AllowUsers and AllowGroups are the users and groups allowed
+	// to join a system instance.
+	AllowUsers  []string `json:"allowUsers,omitempty"`
+	AllowGroups []string `json:"allowGroups,omitempty"`
+	// StopReason describes why and by whom the instance was
+	// stopped, it's set by instance stop.
+	StopReason string `json:"stopReason,omitempty"`
+	// SSHPort is the port of the SSH server started in the
+	// instance with instance start --sshd.
+	SSHPort int `json:"sshPort,omitempty"`
+	// JoinConfig is the configuration subset required to join a
+	// system instance, it's stored in place of Config in the join
+	// file readable by the users allowed to join the instance.
+	JoinConfig []byte `json:"-"`
 }
 
 // ProcName returns processus name based on instance name
@@ -95,12 +117,36 @@ func getPath(username string, subDir string) (string, error) {
 		return "", err
 	}
 
-	configDir, err := syfs.ConfigDirForUsername(u.Name)
+	root, shared := userStateDir(u.Name, username == "")
+	if root == "" {
+		configDir, err := syfs.ConfigDirForUsername(u.Name)
+		if err != nil {
+			return "", err
+		}
+		root = filepath.Join(configDir, instancePath)
+	} else if shared {
+		if err := checkOwner(root, u.UID); err != nil {
+			return "", fmt.Errorf("while checking instance state directory: %s", err)
+		}
+	}
+
+	return filepath.Join(root, subDir, hostname, u.Name), nil
+}
+
+// getSystemPath returns the path where searching for system instance files
+func getSystemPath(subDir string) (string, error) {
+	hostname, err := os.Hostname()
 	if err != nil {
 		return "", err
 	}
-
-	return filepath.Join(configDir, instancePath, subDir, hostname, u.Name), nil
+	dir := SystemDir
+	if stateDir := configStateDir(); stateDir != "" {
+		dir, err = systemStatePath(stateDir)
+		if err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(dir, subDir, hostname), nil
 }
 
 // GetDir returns directory where instances file will be stored
@@ -130,6 +176,34 @@ func Get(name string, subDir string) (*File, error) {
 	return list[0], nil
 }
 
+// GetSystem returns the system instance file corresponding to instance name
+func GetSystem(name string, subDir string) (*File, error) {
+	if err := CheckName(name); err != nil {
+		return nil, err
+	}
+	list, err := ListSystem(name, subDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) != 1 {
+		return nil, fmt.Errorf("no system instance found with name %s", name)
+	}
+	return list[0], nil
+}
+
+// Find returns the user instance file corresponding to instance name,
+// or the system instance file if the user has no instance with this name.
+func Find(name string, subDir string) (*File, error) {
+	file, err := Get(name, subDir)
+	if err == nil {
+		return file, nil
+	}
+	if file, err := GetSystem(name, subDir); err == nil {
+		return file, nil
+	}
+	return nil, err
+}
+
 // Add creates an instance file for a named instance in a privileged
 // or unprivileged path
 func Add(name string, subDir string) (*File, error) {
@@ -150,52 +224,235 @@ func Add(name string, subDir string) (*File, error) {
 	return i, nil
 }
 
+// AddSystem creates an instance file for a named system instance
+func AddSystem(name string, subDir string) (*File, error) {
+	if err := CheckName(name); err != nil {
+		return nil, err
+	}
+	_, err := GetSystem(name, subDir)
+	if err == nil {
+		return nil, fmt.Errorf("system instance %s already exists", name)
+	}
+	i := &File{Name: name, System: true}
+	i.Path, err = getSystemPath(subDir)
+	if err != nil {
+		return nil, err
+	}
+	i.Path = filepath.Join(i.Path, name, name+".json")
+	return i, nil
+}
+
 // List returns instance files matching username and/or name pattern
 func List(username string, name string, subDir string) ([]*File, error) {
-	list := make([]*File, 0)
-
 	path, err := getPath(username, subDir)
 	if err != nil {
 		return nil, err
 	}
+	return list(path, name, subDir, false)
+}
+
+// ListSystem returns system instance files matching name pattern
+func ListSystem(name string, subDir string) ([]*File, error) {
+	path, err := getSystemPath(subDir)
+	if err != nil {
+		return nil, err
+	}
+	return list(path, name, subDir, true)
+}
+
+func list(path string, name string, subDir string, system bool) ([]*File, error) {
+	files, err := read(path, name, system)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*File, 0, len(files))
+	for _, f := range files {
+		// delete ghost singularity instance files
+		if subDir == SingSubDir && f.isExited() {
+			f.Delete()
+			continue
+		}
+		list = append(list, f)
+	}
+	return list, nil
+}
+
+// read returns the instance files matching name pattern in path.
+func read(path string, name string, system bool) ([]*File, error) {
+	list := make([]*File, 0)
+
 	pattern := filepath.Join(path, name, name+".json")
+	// system instance files are only readable by root, other
+	// users read the join files stored next to instance directories
+	join := system && os.Geteuid() != 0
+	if join {
+		pattern = filepath.Join(path, name+".json")
+	}
 	files, err := filepath.Glob(pattern)
 	if err != nil {
 		return nil, err
 	}
 	for _, file := range files {
-		r, err := os.Open(file)
+		f, err := readFile(file, system)
 		if os.IsNotExist(err) {
 			continue
 		} else if err != nil {
 			return nil, err
+		} else if f == nil {
+			continue
 		}
-		f := &File{}
-		if err := json.NewDecoder(r).Decode(f); err != nil {
-			r.Close()
-			return nil, err
-		}
-		r.Close()
 		f.Path = file
-		// delete ghost singularity instance files
-		if subDir == SingSubDir && f.isExited() {
-			f.Delete()
-			continue
+		if join {
+			base := strings.TrimSuffix(filepath.Base(file), ".json")
+			f.Path = filepath.Join(path, base, base+".json")
 		}
+		// the scope is determined by the file location and
+		// not by the file content
+		f.System = system
 		list = append(list, f)
 	}
 	return list, nil
 }
 
+// readFile decodes the instance file at path under the instance lock,
+// nil is returned for untrusted system instance files.
+func readFile(path string, system bool) (*File, error) {
+	unlock, err := lockDir(filepath.Dir(path), false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	r, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// system instance files are trusted only if owned by root
+	if system {
+		fi, err := r.Stat()
+		if err != nil {
+			return nil, err
+		}
+		if st, ok := fi.Sys().(*syscall.Stat_t); !ok || st.Uid != 0 {
+			return nil, nil
+		}
+	}
+	f := &File{}
+	if err := json.NewDecoder(r).Decode(f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// ListExited returns the singularity instance files of the current user,
+// or the system instance files if system is true, whose instance process
+// exited. Contrary to List, ghost instance files are not deleted.
+func ListExited(system bool) ([]*File, error) {
+	var path string
+	var err error
+
+	if system {
+		path, err = getSystemPath(SingSubDir)
+	} else {
+		path, err = getPath("", SingSubDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := read(path, "*", system)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*File, 0)
+	for _, f := range files {
+		if f.isExited() {
+			list = append(list, f)
+		}
+	}
+	return list, nil
+}
+
+// Authorized returns whether the current user is allowed to join
+// the instance, users are always allowed to join their own instances
+// and system instances are joinable by root and by the users and
+// groups allowed when the instance was started.
+func (i *File) Authorized() bool {
+	uid := os.Getuid()
+	if !i.System || uid == 0 {
+		return true
+	}
+
+	if u, err := user.GetPwUID(uint32(uid)); err == nil {
+		for _, name := range i.AllowUsers {
+			if name == u.Name {
+				return true
+			}
+		}
+	}
+
+	if len(i.AllowGroups) == 0 {
+		return false
+	}
+	gids, err := os.Getgroups()
+	if err != nil {
+		return false
+	}
+	gids = append(gids, os.Getgid())
+	for _, gid := range gids {
+		g, err := user.GetGrGID(uint32(gid))
+		if err != nil {
+			continue
+		}
+		for _, name := range i.AllowGroups {
+			if name == g.Name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // Delete deletes instance file
 func (i *File) Delete() error {
 	dir := filepath.Dir(i.Path)
 	if dir == "." {
 		dir = ""
 	}
+	if i.System && dir != "" {
+		if err := os.Remove(joinPath(i.Path)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
 	return os.RemoveAll(dir)
 }
 
+// joinPath returns the path of the join file of the system
+// instance file at path.
+func joinPath(path string) string {
+	return filepath.Dir(path) + ".json"
+}
+
+// readJoinConfig returns the configuration stored in the join file of
+// the system instance file path, or nil if there is no join file.
+func readJoinConfig(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(joinPath(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read instance join file: %s", err)
+	}
+	join := &File{}
+	if err := json.Unmarshal(b, join); err != nil {
+		return nil, fmt.Errorf("failed to decode instance join file: %s", err)
+	}
+	return join.Config, nil
+}
+
 // isExited returns if the instance process is exited or not.
 func (i *File) isExited() bool {
 	if i.PPid <= 0 {
@@ -236,23 +493,63 @@ func (i *File) Update() error {
 
 	path := filepath.Dir(i.Path)
 
-	oldumask := syscall.Umask(0)
-	defer syscall.Umask(oldumask)
-
+	// the directory holding system instance directories and
+	// join files is traversable by users joining them, modes
+	// are set explicitly to not depend on the process umask
+	if i.System {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.Chmod(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+	}
 	if err := os.MkdirAll(path, 0700); err != nil {
 		return err
 	}
-	file, err := os.OpenFile(i.Path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|syscall.O_NOFOLLOW, 0644)
+	if err := os.Chmod(path, 0700); err != nil {
+		return err
+	}
+	if dir := sharedUserDir(path); dir != "" && !i.System {
+		if err := checkOwner(dir, uint32(os.Getuid())); err != nil {
+			return fmt.Errorf("while checking instance state directory: %s", err)
+		}
+	}
+
+	unlock, err := lockDir(path, true)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	defer unlock()
 
-	if _, err := file.Write(b); err != nil {
+	if err := fs.WriteFileAtomic(i.Path, b, 0600); err != nil {
 		return fmt.Errorf("failed to write instance file %s: %s", i.Path, err)
 	}
+	if !i.System {
+		return nil
+	}
 
-	return file.Sync()
+	// the join file doesn't hold the instance configuration
+	// which may contain secrets like environment variables
+	// or the image encryption key
+	// the join configuration isn't stored in the instance file,
+	// files read from disk keep the one of the current join file
+	if i.JoinConfig == nil {
+		i.JoinConfig, err = readJoinConfig(i.Path)
+		if err != nil {
+			return err
+		}
+	}
+	join := *i
+	join.Config = i.JoinConfig
+	b, err = json.Marshal(&join)
+	if err != nil {
+		return err
+	}
+	if err := fs.WriteFileAtomic(joinPath(i.Path), b, 0644); err != nil {
+		return fmt.Errorf("failed to write instance join file: %s", err)
+	}
+	return nil
 }
 
 // GetLogFilePaths returns the paths of log files containing
@@ -268,16 +565,40 @@ func GetLogFilePaths(name string, subDir string) (string, string, error) {
 	return logErrPath, logOutPath, nil
 }
 
+// GetSystemLogFilePaths returns the paths of system instance log files
+// containing .err, .out streams, respectively
+func GetSystemLogFilePaths(name string, subDir string) (string, string, error) {
+	path, err := getSystemPath(subDir)
+	if err != nil {
+		return "", "", err
+	}
+	logErrPath := filepath.Join(path, name+".err")
+	logOutPath := filepath.Join(path, name+".out")
+
+	return logErrPath, logOutPath, nil
+}
+
 // SetLogFile replaces stdout/stderr streams and redirect content
 // to log file
 func SetLogFile(name string, uid int, subDir string) (*os.File, *os.File, error) {
-	path, err := getPath("", subDir)
+	stderrPath, stdoutPath, err := GetLogFilePaths(name, subDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return setLogFile(stderrPath, stdoutPath, uid)
+}
+
+// SetSystemLogFile replaces stdout/stderr streams and redirect content
+// to system instance log file, only readable by root
+func SetSystemLogFile(name string, subDir string) (*os.File, *os.File, error) {
+	stderrPath, stdoutPath, err := GetSystemLogFilePaths(name, subDir)
 	if err != nil {
 		return nil, nil, err
 	}
-	stderrPath := filepath.Join(path, name+".err")
-	stdoutPath := filepath.Join(path, name+".out")
+	return setLogFile(stderrPath, stdoutPath, 0)
+}
 
+func setLogFile(stderrPath, stdoutPath string, uid int) (*os.File, *os.File, error) {
 	oldumask := syscall.Umask(0)
 	defer syscall.Umask(oldumask)
 
@@ -288,12 +609,12 @@ func SetLogFile(name string, uid int, subDir string) (*os.File, *os.File, error)
 		return nil, nil, err
 	}
 
-	stderr, err := os.OpenFile(stderrPath, os.O_RDWR|os.O_CREATE|os.O_APPEND|syscall.O_NOFOLLOW, 0644)
+	stderr, err := os.OpenFile(stderrPath, os.O_RDWR|os.O_CREATE|os.O_APPEND|syscall.O_NOFOLLOW, 0600)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	stdout, err := os.OpenFile(stdoutPath, os.O_RDWR|os.O_CREATE|os.O_APPEND|syscall.O_NOFOLLOW, 0644)
+	stdout, err := os.OpenFile(stdoutPath, os.O_RDWR|os.O_CREATE|os.O_APPEND|syscall.O_NOFOLLOW, 0600)
 	if err != nil {
 		return nil, nil, err
 	}
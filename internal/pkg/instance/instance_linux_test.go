@@ -6,6 +6,8 @@
 package instance
 
 import (
+	"encoding/json"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -251,6 +253,121 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestSystem(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	dir, err := ioutil.TempDir("", "system-instances-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	systemDir := SystemDir
+	SystemDir = dir
+	defer func() { SystemDir = systemDir }()
+
+	file, err := AddSystem("service", testSubDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	file.User = "root"
+	file.PPid = fakeInstancePid
+	file.Pid = os.Getpid()
+	file.AllowUsers = []string{"nobody"}
+	file.Config = []byte(`"secret"`)
+	file.JoinConfig = []byte(`"join"`)
+	if err := file.Update(); err != nil {
+		t.Fatalf("error while creating system instance: %s", err)
+	}
+	defer file.Delete()
+
+	// the instance file is only readable by root and the join
+	// file readable by other users doesn't hold the configuration
+	for path, mode := range map[string]os.FileMode{
+		filepath.Dir(file.Path): os.ModeDir | 0700,
+		file.Path:               0600,
+		joinPath(file.Path):     0644,
+	} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if fi.Mode() != mode {
+			t.Errorf("unexpected mode %s for %s, expected %s", fi.Mode(), path, mode)
+		}
+	}
+	b, err := ioutil.ReadFile(joinPath(file.Path))
+	if err != nil {
+		t.Fatalf("failed to read join file: %s", err)
+	}
+	join := &File{}
+	if err := json.Unmarshal(b, join); err != nil {
+		t.Fatalf("failed to decode join file: %s", err)
+	}
+	if string(join.Config) != `"join"` {
+		t.Errorf("unexpected configuration %s in join file", join.Config)
+	}
+
+	// updating an instance file read from disk keeps the join
+	// configuration users join the instance with
+	read, err := GetSystem("service", testSubDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	read.StopReason = "stopped by root"
+	if err := read.Update(); err != nil {
+		t.Fatalf("error while updating system instance: %s", err)
+	}
+	b, err = ioutil.ReadFile(joinPath(file.Path))
+	if err != nil {
+		t.Fatalf("failed to read join file: %s", err)
+	}
+	join = &File{}
+	if err := json.Unmarshal(b, join); err != nil {
+		t.Fatalf("failed to decode join file: %s", err)
+	}
+	if string(join.Config) != `"join"` {
+		t.Errorf("join configuration %s lost after update", join.Config)
+	}
+	if join.StopReason != read.StopReason {
+		t.Errorf("join file not updated")
+	}
+
+	if _, err := AddSystem("service", testSubDir); err == nil {
+		t.Errorf("unexpected success while adding existing system instance")
+	}
+	if _, err := Get("service", testSubDir); err == nil {
+		t.Errorf("unexpected user instance found")
+	}
+
+	found, err := Find("service", testSubDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !found.System {
+		t.Errorf("system instance not reported as system instance")
+	}
+	if !found.Authorized() {
+		t.Errorf("root not authorized to join system instance")
+	}
+
+	// system instance files not owned by root are ignored
+	if err := os.Chown(file.Path, 1, 1); err != nil {
+		t.Fatalf("failed to change instance file owner: %s", err)
+	}
+	if _, err := GetSystem("service", testSubDir); err == nil {
+		t.Errorf("unexpected success with system instance file not owned by root")
+	}
+
+	// the join file is deleted with the instance file
+	if err := file.Delete(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := os.Stat(joinPath(file.Path)); !os.IsNotExist(err) {
+		t.Errorf("join file not deleted")
+	}
+}
+
 func TestMain(m *testing.M) {
 	// spawn a fake instance process
 	cmd := exec.Command("cat")
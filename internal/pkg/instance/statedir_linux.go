@@ -0,0 +1,204 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package instance
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/buildcfg"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/util/fs/lock"
+	"github.com/hpcng/singularity/pkg/util/singularityconf"
+)
+
+// StateDirEnv is the environment variable setting the directory where
+// the instance files of the current user are stored, it takes precedence
+// over the instance state dir directive of singularity.conf.
+const StateDirEnv = "SINGULARITY_INSTANCE_STATEDIR"
+
+const (
+	// systemStateDir is the directory of the system instance
+	// files within the configured state directory.
+	systemStateDir = ".system"
+	// lockFile is the instance file lock, placed in the
+	// instance directory.
+	lockFile = ".lock"
+	// lockTimeout is the time waited to acquire the instance
+	// file lock.
+	lockTimeout = 10 * time.Second
+)
+
+var (
+	parseConfigOnce sync.Once
+	parsedStateDir  string
+)
+
+// configStateDir returns the value of the instance state dir directive,
+// singularity.conf is parsed if the configuration isn't already loaded
+// by the process, like in the engines master process.
+func configStateDir() string {
+	if cfg := singularityconf.GetCurrentConfig(); cfg != nil {
+		return cfg.InstanceStateDir
+	}
+	parseConfigOnce.Do(func() {
+		cfg, err := singularityconf.Parse(buildcfg.SINGULARITY_CONF_FILE)
+		if err != nil {
+			sylog.Debugf("Could not parse %s: %s", buildcfg.SINGULARITY_CONF_FILE, err)
+			return
+		}
+		parsedStateDir = cfg.InstanceStateDir
+	})
+	return parsedStateDir
+}
+
+// userStateDir returns the directory where the instance files of
+// username are stored, current is true for the current user. An empty
+// string is returned if the default location must be used. shared
+// reports if the directory is within the state directory configured
+// by the administrator, shared by all users.
+func userStateDir(username string, current bool) (dir string, shared bool) {
+	if env := os.Getenv(StateDirEnv); env != "" && current {
+		if filepath.IsAbs(env) {
+			return filepath.Clean(env), false
+		}
+		sylog.Warningf("Ignoring %s=%s: not an absolute path", StateDirEnv, env)
+	}
+	if dir := configStateDir(); dir != "" {
+		return filepath.Join(dir, username), true
+	}
+	return "", false
+}
+
+// sharedUserDir returns the user directory of the shared state
+// directory containing path, or an empty string.
+func sharedUserDir(path string) string {
+	dir := configStateDir()
+	if dir == "" {
+		return ""
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	return filepath.Join(dir, strings.Split(rel, string(os.PathSeparator))[0])
+}
+
+// checkOwner ensures that the directory at path, if it exists, is
+// not a symlink and is owned by root or uid. Users directories in a
+// shared state directory could be created beforehand by other users.
+func checkOwner(path string, uid uint32) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("while checking %s: %s", path, err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || (st.Uid != 0 && st.Uid != uid) {
+		return fmt.Errorf("%s is not owned by uid %d", path, uid)
+	}
+	return nil
+}
+
+// systemStatePath returns the directory of the system instance files
+// within the configured state directory stateDir. As the state directory
+// is writable by all users, root creates it without following symlinks
+// and it must be a root owned directory not writable by other users, a
+// user could have created it beforehand otherwise.
+func systemStatePath(stateDir string) (string, error) {
+	dir := filepath.Join(stateDir, systemStateDir)
+
+	if os.Geteuid() == 0 {
+		err := os.Mkdir(dir, 0755)
+		if err == nil {
+			// set the mode explicitly to not depend on the umask,
+			// users joining system instances traverse it
+			err = os.Chmod(dir, 0755)
+		}
+		if err != nil && !os.IsExist(err) {
+			return "", fmt.Errorf("while creating system instance directory: %s", err)
+		}
+	}
+
+	fi, err := os.Lstat(dir)
+	if os.IsNotExist(err) {
+		return dir, nil
+	} else if err != nil {
+		return "", fmt.Errorf("while checking %s: %s", dir, err)
+	}
+	if !fi.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", dir)
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || st.Uid != 0 {
+		return "", fmt.Errorf("%s is not owned by root", dir)
+	}
+	if fi.Mode().Perm()&022 != 0 {
+		return "", fmt.Errorf("%s is writable by other users", dir)
+	}
+	return dir, nil
+}
+
+// lockDir places a write lock, or a read lock if exclusive is false, on
+// the lock file of the instance directory dir. POSIX record locks are used
+// as they are supported over NFS contrary to flock with older clients.
+// Readers don't create the lock file, there is nothing to wait for if it
+// doesn't exist.
+func lockDir(dir string, exclusive bool) (unlock func(), err error) {
+	var f *os.File
+
+	path := filepath.Join(dir, lockFile)
+	if exclusive {
+		f, err = os.OpenFile(path, os.O_CREATE|os.O_RDWR|syscall.O_NOFOLLOW, 0600)
+	} else {
+		f, err = os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+		if os.IsNotExist(err) || os.IsPermission(err) {
+			return func() {}, nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("while opening instance lock file: %s", err)
+	}
+
+	br := lock.NewByteRange(int(f.Fd()), 0, 1)
+	deadline := time.Now().Add(lockTimeout)
+
+	for {
+		if exclusive {
+			err = br.Lock()
+		} else {
+			err = br.RLock()
+		}
+		if err != lock.ErrByteRangeAcquired {
+			break
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("timed out while waiting for instance lock in %s", dir)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err == lock.ErrLockNotSupported {
+		sylog.Debugf("File locking not supported for %s, instance file not locked", dir)
+	} else if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("while locking instance file: %s", err)
+	}
+
+	return func() {
+		br.Unlock()
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package instance
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcng/singularity/internal/pkg/test"
+	"github.com/hpcng/singularity/pkg/util/singularityconf"
+)
+
+func TestStateDir(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	dir, err := ioutil.TempDir("", "instance-state-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	singularityconf.SetCurrentConfig(&singularityconf.File{InstanceStateDir: dir})
+	defer singularityconf.SetCurrentConfig(nil)
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	path, err := getPath("", testSubDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected := filepath.Join(dir, "root", testSubDir, hostname, "root"); path != expected {
+		t.Errorf("got user instance path %s, expected %s", path, expected)
+	}
+	if userDir := sharedUserDir(path); userDir != filepath.Join(dir, "root") {
+		t.Errorf("unexpected shared user directory %s", userDir)
+	}
+	if userDir := sharedUserDir("/var/run"); userDir != "" {
+		t.Errorf("unexpected shared user directory %s", userDir)
+	}
+
+	path, err = getSystemPath(testSubDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected := filepath.Join(dir, systemStateDir, testSubDir, hostname); path != expected {
+		t.Errorf("got system instance path %s, expected %s", path, expected)
+	}
+
+	// the environment variable takes precedence for the current user
+	envDir := filepath.Join(dir, "env")
+	os.Setenv(StateDirEnv, envDir)
+	defer os.Unsetenv(StateDirEnv)
+
+	path, err = getPath("", testSubDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected := filepath.Join(envDir, testSubDir, hostname, "root"); path != expected {
+		t.Errorf("got user instance path %s, expected %s", path, expected)
+	}
+}
+
+func TestSystemStatePath(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	newStateDir := func() string {
+		dir, err := ioutil.TempDir("", "instance-state-")
+		if err != nil {
+			t.Fatalf("failed to create temporary directory: %s", err)
+		}
+		if err := os.Chmod(dir, 01777); err != nil {
+			t.Fatalf("failed to change directory mode: %s", err)
+		}
+		return dir
+	}
+
+	dir := newStateDir()
+	defer os.RemoveAll(dir)
+
+	path, err := systemStatePath(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	fi, err := os.Lstat(path)
+	if err != nil {
+		t.Fatalf("system instance directory not created: %s", err)
+	}
+	if fi.Mode() != os.ModeDir|0755 {
+		t.Errorf("unexpected mode %s for %s", fi.Mode(), path)
+	}
+	if _, err := systemStatePath(dir); err != nil {
+		t.Errorf("unexpected error with existing directory: %s", err)
+	}
+
+	// directories created beforehand by users are rejected
+	tests := map[string]func(path string) error{
+		"symlink": func(path string) error {
+			return os.Symlink(os.TempDir(), path)
+		},
+		"file": func(path string) error {
+			return ioutil.WriteFile(path, nil, 0644)
+		},
+		"user owned": func(path string) error {
+			if err := os.Mkdir(path, 0755); err != nil {
+				return err
+			}
+			return os.Chown(path, 1, 1)
+		},
+		"world writable": func(path string) error {
+			if err := os.Mkdir(path, 0755); err != nil {
+				return err
+			}
+			return os.Chmod(path, 0777)
+		},
+	}
+	for name, create := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := newStateDir()
+			defer os.RemoveAll(dir)
+
+			if err := create(filepath.Join(dir, systemStateDir)); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if _, err := systemStatePath(dir); err == nil {
+				t.Errorf("unexpected success")
+			}
+		})
+	}
+}
+
+func TestCheckOwner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "instance-owner-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	uid := uint32(os.Getuid())
+
+	if err := checkOwner(filepath.Join(dir, "missing"), uid); err != nil {
+		t.Errorf("unexpected error for missing directory: %s", err)
+	}
+	if err := checkOwner(dir, uid); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if uid != 0 {
+		if err := checkOwner(dir, uid+1); err == nil {
+			t.Errorf("unexpected success for directory owned by another user")
+		}
+	}
+
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(dir, link); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := checkOwner(link, uid); err == nil {
+		t.Errorf("unexpected success for symlink")
+	}
+}
+
+func TestLockDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "instance-lock-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// readers don't wait if there is no lock file
+	unlock, err := lockDir(dir, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	unlock()
+
+	unlock, err = lockDir(dir, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	unlock()
+
+	// locks can be acquired again once released
+	for _, exclusive := range []bool{true, false} {
+		unlock, err = lockDir(dir, exclusive)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		unlock()
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package journal records the host resources created for a container in
+// order to release them if the container master process is killed before
+// it could clean them up.
+//
+// Each container journal is a file in a per user directory holding one
+// JSON entry per line, the file being locked by the container master
+// process during the whole container lifetime. Journals which are not
+// locked anymore belong to containers which didn't clean up, their
+// entries are replayed in reverse order by Recover.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/hpcng/singularity/internal/pkg/buildcfg"
+)
+
+// Dir is the directory where container journals are stored.
+var Dir = filepath.Join(buildcfg.LOCALSTATEDIR, "singularity", "journal")
+
+// Resource types recorded in journals.
+const (
+	// Loop is a loop device path.
+	Loop = "loop"
+	// Crypt is an encrypted device path.
+	Crypt = "crypt"
+	// Verity is a dm-verity device path.
+	Verity = "verity"
+	// Cgroup is a cgroup path.
+	Cgroup = "cgroup"
+	// Network is a CNI network setup.
+	Network = "network"
+	// File is a file, a socket or a directory path.
+	File = "file"
+)
+
+// Entry describes a resource created for a container.
+type Entry struct {
+	Type string `json:"type"`
+	Path string `json:"path,omitempty"`
+	// Data holds additional resource type specific data.
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Journal is a container journal closed by Close.
+type Journal struct {
+	file *os.File
+}
+
+func lock(path string, flag int, how int) (*os.File, error) {
+	f, err := os.OpenFile(path, flag, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Open creates the journal of the container whose master process is pid
+// for the user uid. The returned journal must be held by the master
+// process until the container resources are released.
+func Open(root string, uid int, pid int) (*Journal, error) {
+	dir := filepath.Join(root, strconv.Itoa(uid))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("while creating journal directory %s: %s", dir, err)
+	}
+
+	path := filepath.Join(dir, strconv.Itoa(pid))
+	os.Remove(path)
+	f, err := lock(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, syscall.LOCK_EX)
+	if err != nil {
+		return nil, fmt.Errorf("while creating journal %s: %s", path, err)
+	}
+	return &Journal{file: f}, nil
+}
+
+// Record appends the resource entry to the journal.
+func (j *Journal) Record(e Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("while encoding journal entry: %s", err)
+	}
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("while writing journal %s: %s", j.file.Name(), err)
+	}
+	return nil
+}
+
+// Close removes the journal once the container resources were released.
+func (j *Journal) Close() error {
+	path := j.file.Name()
+	if err := os.Remove(path); err != nil {
+		j.file.Close()
+		return fmt.Errorf("while removing journal %s: %s", path, err)
+	}
+	return j.file.Close()
+}
+
+// samePath returns whether path still refers to the opened file f.
+func samePath(f *os.File, path string) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	pi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fi, pi)
+}
+
+// readEntries returns the entries of the journal file f. A partially
+// written last entry is ignored.
+func readEntries(f *os.File) ([]Entry, error) {
+	var entries []Entry
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 4096), 1<<20)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			break
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// walk calls fn with the entries of the journals of the user uid, or of
+// all users if uid is negative, which are not held anymore by a master
+// process. The journals are kept locked during fn call.
+func walk(root string, uid int, fn func(owner int, path string, entries []Entry)) error {
+	dirs := []string{filepath.Join(root, strconv.Itoa(uid))}
+	if uid < 0 {
+		var err error
+		dirs, err = filepath.Glob(filepath.Join(root, "[0-9]*"))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, dir := range dirs {
+		owner, err := strconv.Atoi(filepath.Base(dir))
+		if err != nil {
+			continue
+		}
+		files, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("while reading %s: %s", dir, err)
+		}
+		for _, fi := range files {
+			if !fi.Mode().IsRegular() {
+				continue
+			}
+			path := filepath.Join(dir, fi.Name())
+			f, err := lock(path, os.O_RDONLY, syscall.LOCK_EX|syscall.LOCK_NB)
+			if err == syscall.EWOULDBLOCK {
+				// still held by a running master process
+				continue
+			} else if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return fmt.Errorf("while locking journal %s: %s", path, err)
+			} else if !samePath(f, path) {
+				// replaced by a new container journal
+				f.Close()
+				continue
+			}
+
+			entries, err := readEntries(f)
+			if err != nil {
+				f.Close()
+				return fmt.Errorf("while reading journal %s: %s", path, err)
+			}
+			fn(owner, path, entries)
+			f.Close()
+		}
+	}
+	return nil
+}
+
+// Leaked returns the entries of the journals of the user uid, or of
+// all users if uid is negative, left by containers which didn't clean
+// up their resources.
+func Leaked(root string, uid int) ([]Entry, error) {
+	var leaked []Entry
+
+	err := walk(root, uid, func(_ int, _ string, entries []Entry) {
+		leaked = append(leaked, entries...)
+	})
+	return leaked, err
+}
+
+// Recover replays in reverse order the entries of the journals of the
+// user uid, or of all users if uid is negative, left by containers which
+// didn't clean up their resources and removes them. The release function
+// is called for each entry with the journal user ID and its errors are
+// passed to the report function.
+func Recover(root string, uid int, release func(int, Entry) error, report func(Entry, error)) error {
+	return walk(root, uid, func(owner int, path string, entries []Entry) {
+		for i := len(entries) - 1; i >= 0; i-- {
+			if err := release(owner, entries[i]); err != nil {
+				report(entries[i], err)
+			}
+		}
+		os.Remove(path)
+	})
+}
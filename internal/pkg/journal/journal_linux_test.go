@@ -0,0 +1,91 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package journal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+	root, err := ioutil.TempDir("", "journal-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	uid := os.Getuid()
+
+	running, err := Open(root, uid, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := running.Record(Entry{Type: File, Path: "/running"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	killed, err := Open(root, uid, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, p := range []string{"/first", "/second"} {
+		if err := killed.Record(Entry{Type: File, Path: p}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	// simulate a killed master process releasing the lock
+	// without removing its journal
+	killed.file.Close()
+
+	entries, err := Leaked(root, -1)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("unexpected leaked entries: %v", entries)
+	}
+
+	var released []string
+	release := func(owner int, e Entry) error {
+		if owner != uid {
+			t.Errorf("unexpected journal owner %d", owner)
+		}
+		released = append(released, e.Path)
+		return nil
+	}
+	report := func(e Entry, err error) {
+		t.Errorf("unexpected error for %s: %s", e.Path, err)
+	}
+
+	if err := Recover(root, uid, release, report); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := []string{"/second", "/first"}; !reflect.DeepEqual(released, want) {
+		t.Errorf("released %v instead of %v", released, want)
+	}
+	if _, err := os.Stat(filepath.Join(root, strconv.Itoa(uid), "2")); !os.IsNotExist(err) {
+		t.Errorf("recovered journal not removed")
+	}
+
+	// the journal of the running container is left intact
+	released = nil
+	if err := Recover(root, -1, release, report); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(released) != 0 {
+		t.Errorf("unexpected released entries: %v", released)
+	}
+	if err := running.Close(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, strconv.Itoa(uid), "1")); !os.IsNotExist(err) {
+		t.Errorf("closed journal not removed")
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package journal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+
+	"github.com/hpcng/singularity/internal/pkg/cgroups"
+	"github.com/hpcng/singularity/internal/pkg/util/bin"
+	"github.com/hpcng/singularity/pkg/network"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/util/crypt"
+	"github.com/hpcng/singularity/pkg/util/loop"
+	"github.com/hpcng/singularity/pkg/util/verity"
+)
+
+// LoopData identifies the image attached to a recorded loop device,
+// to not release a loop device reused by another container.
+type LoopData struct {
+	Device uint64 `json:"device"`
+	Inode  uint64 `json:"inode"`
+	Offset uint64 `json:"offset"`
+}
+
+// NetworkData holds the CNI parameters required to delete the
+// container networks.
+type NetworkData struct {
+	Networks    []string `json:"networks"`
+	ContainerID string   `json:"containerID"`
+	NsPath      string   `json:"nsPath"`
+	ConfPath    string   `json:"confPath"`
+	PluginPath  string   `json:"pluginPath"`
+	Args        []string `json:"args,omitempty"`
+}
+
+// RecoverResources releases the resources leaked by the containers of
+// the user uid, or of all users if uid is negative, whose master process
+// was killed before cleaning up. It must be called with root privileges.
+func RecoverResources(uid int) error {
+	return Recover(Dir, uid, Release, func(e Entry, err error) {
+		sylog.Warningf("Could not release leaked %s %s: %s", e.Type, e.Path, err)
+	})
+}
+
+// Release releases a resource recorded in the journal of the user uid.
+func Release(uid int, e Entry) error {
+	sylog.Infof("Releasing leaked %s %s", e.Type, e.Path)
+
+	switch e.Type {
+	case Loop:
+		var l LoopData
+		if err := json.Unmarshal(e.Data, &l); err != nil {
+			return err
+		}
+		return releaseLoop(e.Path, l)
+	case Crypt:
+		dev := &crypt.Device{}
+		if _, err := os.Stat(e.Path); os.IsNotExist(err) {
+			return nil
+		}
+		return dev.CloseCryptDevice(filepath.Base(e.Path))
+	case Verity:
+		if _, err := os.Stat(e.Path); os.IsNotExist(err) {
+			return nil
+		}
+		dmsetup, err := bin.Dmsetup()
+		if err != nil {
+			return err
+		}
+		return verity.Close(dmsetup, filepath.Base(e.Path))
+	case Cgroup:
+		m := &cgroups.Manager{Path: e.Path}
+		if err := m.Remove(); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	case Network:
+		var n NetworkData
+		if err := json.Unmarshal(e.Data, &n); err != nil {
+			return err
+		}
+		cniPath := &network.CNIPath{Conf: n.ConfPath, Plugin: n.PluginPath}
+		setup, err := network.NewSetup(n.Networks, n.ContainerID, n.NsPath, cniPath)
+		if err != nil {
+			return err
+		}
+		if err := setup.SetArgs(n.Args); err != nil {
+			return err
+		}
+		setup.SetEnvPath("/bin:/sbin:/usr/bin:/usr/sbin")
+		return setup.DelNetworks(context.Background())
+	case File:
+		return removeAs(uid, e.Path)
+	}
+	return fmt.Errorf("unknown resource type")
+}
+
+// releaseLoop clears the loop device path if it is still attached to
+// the recorded image, the kernel defers the release while the device
+// is in use.
+func releaseLoop(path string, l LoopData) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := loop.GetStatusFromFd(f.Fd())
+	if err != nil {
+		return err
+	}
+	if info.Device != l.Device || info.Inode != l.Inode || info.Offset != l.Offset {
+		return nil
+	}
+
+	_, _, esys := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), loop.CmdClrFd, 0)
+	if esys != 0 && esys != syscall.ENXIO {
+		return esys
+	}
+	return nil
+}
+
+// removeAs removes path with the filesystem credentials of the user
+// uid, to not let a user remove files of other users by placing them
+// at a recorded path.
+func removeAs(uid int, path string) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	syscall.Setfsuid(uid)
+	defer syscall.Setfsuid(os.Geteuid())
+
+	return os.RemoveAll(path)
+}
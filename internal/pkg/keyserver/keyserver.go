@@ -0,0 +1,343 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package keyserver implements a lightweight HKP keyserver storing the
+// public keys in a directory, to distribute the signing keys of a site
+// without access to a public keyserver. The lookup and add operations of
+// the HKP protocol are supported, as used by the key commands:
+// https://tools.ietf.org/html/draft-shaw-openpgp-hkp-00
+package keyserver
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hpcng/singularity/pkg/sylog"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// maxKeySize is the maximum size of the key submissions.
+const maxKeySize = 1 << 20
+
+// Store is a directory holding one armored public key per file, named
+// after the key fingerprint.
+type Store struct {
+	dir string
+	mu  sync.RWMutex
+}
+
+// NewStore returns the key store in dir, created if needed.
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("while creating key store: %s", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+func fingerprint(e *openpgp.Entity) string {
+	return strings.ToUpper(hex.EncodeToString(e.PrimaryKey.Fingerprint[:]))
+}
+
+func (s *Store) path(fpr string) string {
+	return filepath.Join(s.dir, fpr+".asc")
+}
+
+// Keys returns the keys of the store, invalid files are skipped.
+func (s *Store) Keys() (openpgp.EntityList, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.asc"))
+	if err != nil {
+		return nil, err
+	}
+	var keys openpgp.EntityList
+	for _, f := range files {
+		el, err := readKeys(f)
+		if err != nil {
+			sylog.Warningf("Skipping key %s: %s", f, err)
+			continue
+		}
+		keys = append(keys, el...)
+	}
+	return keys, nil
+}
+
+func readKeys(path string) (openpgp.EntityList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return openpgp.ReadArmoredKeyRing(f)
+}
+
+// Add adds the public key e to the store. The identities, subkeys and
+// revocations of a stored key are kept when it is submitted again, so
+// that a submission can't remove them.
+func (s *Store) Add(e *openpgp.Entity) error {
+	if e.PrivateKey != nil {
+		return fmt.Errorf("private keys are not accepted")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fpr := fingerprint(e)
+	if el, err := readKeys(s.path(fpr)); err == nil && len(el) == 1 {
+		e = merge(el[0], e)
+	} else if err != nil && !os.IsNotExist(err) {
+		sylog.Warningf("Replacing invalid key %s: %s", fpr, err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeKeys(&buf, openpgp.EntityList{e}); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(s.dir, ".key-")
+	if err != nil {
+		return fmt.Errorf("while storing key: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("while storing key: %s", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("while storing key: %s", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("while storing key: %s", err)
+	}
+	return os.Rename(tmp.Name(), s.path(fpr))
+}
+
+// merge adds the identities, subkeys and revocations of e missing from the
+// stored key old.
+func merge(old, e *openpgp.Entity) *openpgp.Entity {
+	for name, id := range e.Identities {
+		if _, ok := old.Identities[name]; !ok {
+			old.Identities[name] = id
+		}
+	}
+	for _, sub := range e.Subkeys {
+		found := false
+		for _, oldSub := range old.Subkeys {
+			if oldSub.PublicKey.Fingerprint == sub.PublicKey.Fingerprint {
+				found = true
+				break
+			}
+		}
+		if !found {
+			old.Subkeys = append(old.Subkeys, sub)
+		}
+	}
+	if len(old.Revocations) == 0 {
+		old.Revocations = e.Revocations
+	}
+	return old
+}
+
+func writeKeys(w *bytes.Buffer, el openpgp.EntityList) error {
+	aw, err := armor.Encode(w, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return err
+	}
+	for _, e := range el {
+		if err := serialize(aw, e); err != nil {
+			return fmt.Errorf("while serializing key: %s", err)
+		}
+	}
+	return aw.Close()
+}
+
+// serialize writes the public key e to w like Entity.Serialize, with the
+// key revocations.
+func serialize(w io.Writer, e *openpgp.Entity) error {
+	if err := e.PrimaryKey.Serialize(w); err != nil {
+		return err
+	}
+	for _, sig := range e.Revocations {
+		if err := sig.Serialize(w); err != nil {
+			return err
+		}
+	}
+	for _, id := range e.Identities {
+		if err := id.UserId.Serialize(w); err != nil {
+			return err
+		}
+		if err := id.SelfSignature.Serialize(w); err != nil {
+			return err
+		}
+		for _, sig := range id.Signatures {
+			if err := sig.Serialize(w); err != nil {
+				return err
+			}
+		}
+	}
+	for _, sub := range e.Subkeys {
+		if err := sub.PublicKey.Serialize(w); err != nil {
+			return err
+		}
+		if err := sub.Sig.Serialize(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches returns whether the key e matches the search string, a key ID
+// or fingerprint prefixed by 0x, or text matched against the identities.
+func matches(e *openpgp.Entity, search string, exact bool) bool {
+	if id := strings.TrimPrefix(strings.ToLower(search), "0x"); id != strings.ToLower(search) {
+		id = strings.ToUpper(id)
+		return len(id) >= 8 && strings.HasSuffix(fingerprint(e), id)
+	}
+	search = strings.ToLower(search)
+	for name, id := range e.Identities {
+		if exact {
+			if strings.ToLower(name) == search || strings.ToLower(id.UserId.Email) == search {
+				return true
+			}
+		} else if strings.Contains(strings.ToLower(name), search) {
+			return true
+		}
+	}
+	return false
+}
+
+// Server is an HKP keyserver serving the keys of a store.
+type Server struct {
+	Store *Store
+	// ReadOnly rejects the key submissions.
+	ReadOnly bool
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/pks/lookup":
+		s.lookup(w, r)
+	case "/pks/add":
+		s.add(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	search := q.Get("search")
+	if search == "" {
+		http.Error(w, "missing search", http.StatusBadRequest)
+		return
+	}
+
+	keys, err := s.Store.Keys()
+	if err != nil {
+		sylog.Errorf("While reading keys: %s", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	var found openpgp.EntityList
+	for _, e := range keys {
+		if matches(e, search, q.Get("exact") == "on") {
+			found = append(found, e)
+		}
+	}
+	if len(found) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch op := q.Get("op"); op {
+	case "get":
+		var buf bytes.Buffer
+		if err := writeKeys(&buf, found); err != nil {
+			sylog.Errorf("While serializing keys: %s", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pgp-keys")
+		w.Write(buf.Bytes())
+	case "index", "vindex":
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(index(found))
+	default:
+		http.Error(w, "operation not implemented: "+op, http.StatusNotImplemented)
+	}
+}
+
+func (s *Server) add(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.ReadOnly {
+		http.Error(w, "key submissions are disabled", http.StatusForbidden)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxKeySize)
+	el, err := openpgp.ReadArmoredKeyRing(strings.NewReader(r.PostFormValue("keytext")))
+	if err != nil || len(el) == 0 {
+		http.Error(w, "invalid key", http.StatusBadRequest)
+		return
+	}
+	for _, e := range el {
+		if err := s.Store.Add(e); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sylog.Infof("Added key %s", fingerprint(e))
+	}
+}
+
+// index returns the machine readable index of the keys el.
+func index(el openpgp.EntityList) []byte {
+	escape := strings.NewReplacer("%", "%25", ":", "%3A", "\n", "%0A")
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "info:1:%d\n", len(el))
+	for _, e := range el {
+		bits, _ := e.PrimaryKey.BitLength()
+		flags := ""
+		if len(e.Revocations) > 0 {
+			flags = "r"
+		}
+		created := e.PrimaryKey.CreationTime.Unix()
+		expires := ""
+		for _, id := range e.Identities {
+			if lt := id.SelfSignature.KeyLifetimeSecs; lt != nil && *lt > 0 {
+				expires = strconv.FormatInt(created+int64(*lt), 10)
+				break
+			}
+		}
+		fmt.Fprintf(&buf, "pub:%s:%d:%d:%d:%s:%s\n", fingerprint(e), e.PrimaryKey.PubKeyAlgo, bits, created, expires, flags)
+		names := make([]string, 0, len(e.Identities))
+		for name := range e.Identities {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			id := e.Identities[name]
+			fmt.Fprintf(&buf, "uid:%s:%d::\n", escape.Replace(name), id.SelfSignature.CreationTime.Unix())
+		}
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package keyserver
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/scs-key-client/client"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+func newEntity(t *testing.T, name, email string) *openpgp.Entity {
+	e, err := openpgp.NewEntity(name, "", email, &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatalf("failed to create key: %s", err)
+	}
+	return e
+}
+
+// addIdentity adds an identity self-signed by e to e.
+func addIdentity(t *testing.T, e *openpgp.Entity, name, email string) {
+	uid := packet.NewUserId(name, "", email)
+	sig := &packet.Signature{
+		SigType:      packet.SigTypePositiveCert,
+		PubKeyAlgo:   e.PrimaryKey.PubKeyAlgo,
+		Hash:         crypto.SHA256,
+		CreationTime: e.PrimaryKey.CreationTime,
+		IssuerKeyId:  &e.PrimaryKey.KeyId,
+	}
+	if err := sig.SignUserId(uid.Id, e.PrimaryKey, e.PrivateKey, nil); err != nil {
+		t.Fatalf("failed to sign identity: %s", err)
+	}
+	e.Identities[uid.Id] = &openpgp.Identity{Name: uid.Id, UserId: uid, SelfSignature: sig}
+}
+
+func armored(t *testing.T, e *openpgp.Entity) string {
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatalf("failed to armor key: %s", err)
+	}
+	if err := e.Serialize(w); err != nil {
+		t.Fatalf("failed to serialize key: %s", err)
+	}
+	w.Close()
+	return buf.String()
+}
+
+func TestServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyserver-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to create store: %s", err)
+	}
+	s := &Server{Store: store}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	c, err := client.NewClient(client.OptBaseURL(srv.URL))
+	if err != nil {
+		t.Fatalf("failed to create client: %s", err)
+	}
+	ctx := context.Background()
+
+	alice := newEntity(t, "Alice", "alice@example.com")
+	bob := newEntity(t, "Bob", "bob@example.com")
+	for _, e := range []*openpgp.Entity{alice, bob} {
+		if err := c.PKSAdd(ctx, armored(t, e)); err != nil {
+			t.Fatalf("failed to add key: %s", err)
+		}
+	}
+
+	// keys are looked up by fingerprint
+	text, err := c.GetKey(ctx, alice.PrimaryKey.Fingerprint[:])
+	if err != nil {
+		t.Fatalf("failed to get key: %s", err)
+	}
+	el, err := openpgp.ReadArmoredKeyRing(strings.NewReader(text))
+	if err != nil || len(el) != 1 || el[0].PrimaryKey.KeyId != alice.PrimaryKey.KeyId {
+		t.Fatalf("unexpected keys %v: %v", el, err)
+	}
+
+	// and searched by identity
+	index, err := c.PKSLookup(ctx, nil, "example.com", client.OperationIndex, false, false, []string{client.OptionMachineReadable})
+	if err != nil {
+		t.Fatalf("failed to search keys: %s", err)
+	}
+	if !strings.HasPrefix(index, "info:1:2\n") || !strings.Contains(index, "uid:Alice <alice@example.com>:") {
+		t.Errorf("unexpected index:\n%s", index)
+	}
+
+	var httpError *client.HTTPError
+	if _, err := c.PKSLookup(ctx, nil, "carol", client.OperationIndex, false, false, nil); !errors.As(err, &httpError) || httpError.Code() != http.StatusNotFound {
+		t.Errorf("unexpected error for unknown key: %v", err)
+	}
+
+	// identities are kept when a key is submitted again
+	work := *alice
+	work.Identities = map[string]*openpgp.Identity{}
+	for name, id := range alice.Identities {
+		work.Identities[name] = id
+	}
+	addIdentity(t, &work, "Alice Work", "alice@work.example.com")
+	if err := c.PKSAdd(ctx, armored(t, &work)); err != nil {
+		t.Fatalf("failed to add key: %s", err)
+	}
+	if err := c.PKSAdd(ctx, armored(t, alice)); err != nil {
+		t.Fatalf("failed to add key: %s", err)
+	}
+	keys, err := store.Keys()
+	if err != nil {
+		t.Fatalf("failed to read keys: %s", err)
+	}
+	for _, e := range keys {
+		if e.PrimaryKey.KeyId == alice.PrimaryKey.KeyId && len(e.Identities) != 2 {
+			t.Errorf("key of alice has %d identities", len(e.Identities))
+		}
+	}
+
+	// private keys are rejected
+	var buf bytes.Buffer
+	w, _ := armor.Encode(&buf, openpgp.PrivateKeyType, nil)
+	if err := alice.SerializePrivate(w, nil); err != nil {
+		t.Fatalf("failed to serialize private key: %s", err)
+	}
+	w.Close()
+	if err := c.PKSAdd(ctx, buf.String()); err == nil {
+		t.Errorf("private key accepted")
+	}
+
+	s.ReadOnly = true
+	if err := c.PKSAdd(ctx, armored(t, newEntity(t, "Carol", ""))); !errors.As(err, &httpError) || httpError.Code() != http.StatusForbidden {
+		t.Errorf("unexpected error for read-only server: %v", err)
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package ocihooks loads the OCI hook definitions of the site hooks
+// directories, in the hooks.d format used by podman and CRI-O, and adds
+// the hooks matching a container to its OCI specification.
+//
+// In addition to the hook command, a definition can inject environment
+// variables and bind mounts in the container, and match the container
+// image labels:
+//
+//	{
+//	    "version": "1.0.0",
+//	    "hook": {"path": "/usr/libexec/license-hook"},
+//	    "when": {"labels": {"^com\\.example\\.license$": ".*"}},
+//	    "stages": ["prestart"],
+//	    "env": ["LICENSE_SERVER=license.example.com"],
+//	    "mounts": [{"source": "/opt/license", "destination": "/opt/license"}]
+//	}
+package ocihooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/hpcng/singularity/pkg/sylog"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Version is the supported hook definition version.
+const Version = "1.0.0"
+
+// Lifecycle stages a hook can be attached to.
+const (
+	Prestart        = "prestart"
+	CreateRuntime   = "createRuntime"
+	CreateContainer = "createContainer"
+	StartContainer  = "startContainer"
+	Poststart       = "poststart"
+	Poststop        = "poststop"
+)
+
+// When holds the conditions of a hook definition, the hook matches a
+// container if any of the conditions is true.
+type When struct {
+	// Always matches any container if true.
+	Always *bool `json:"always,omitempty"`
+	// Annotations maps annotation key regular expressions to value
+	// regular expressions.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Labels maps image label key regular expressions to value regular
+	// expressions.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Commands are regular expressions matched against the container
+	// process executable.
+	Commands []string `json:"commands,omitempty"`
+	// HasBindMounts matches containers with bind mounts if true.
+	HasBindMounts *bool `json:"hasBindMounts,omitempty"`
+}
+
+// Hook is a hook definition of a hooks directory.
+type Hook struct {
+	Version string     `json:"version"`
+	Hook    specs.Hook `json:"hook"`
+	When    When       `json:"when"`
+	Stages  []string   `json:"stages"`
+	// Env holds the variables added to the container process environment.
+	Env []string `json:"env,omitempty"`
+	// Mounts holds the bind mounts added to the container.
+	Mounts []specs.Mount `json:"mounts,omitempty"`
+
+	// Name is the definition file name.
+	Name string `json:"-"`
+}
+
+// Container describes the container matched against the hook conditions.
+type Container struct {
+	Annotations   map[string]string
+	Labels        map[string]string
+	Command       []string
+	HasBindMounts bool
+}
+
+// Load returns the hook definitions of the JSON files in dirs, sorted by
+// file name. A definition in a later directory replaces the definition
+// with the same file name of a previous directory like with podman. The
+// directories must be owned by root and not writable by others, missing
+// directories are ignored.
+func Load(dirs ...string) ([]*Hook, error) {
+	byName := make(map[string]*Hook)
+
+	for _, dir := range dirs {
+		if err := checkDir(dir); os.IsNotExist(err) {
+			sylog.Debugf("Ignoring missing hooks directory %s", dir)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("while reading hooks directory %s: %s", dir, err)
+		}
+		for _, fi := range files {
+			if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".json") {
+				continue
+			}
+			h, err := read(filepath.Join(dir, fi.Name()))
+			if err != nil {
+				return nil, err
+			}
+			byName[fi.Name()] = h
+		}
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hooks := make([]*Hook, 0, len(names))
+	for _, name := range names {
+		hooks = append(hooks, byName[name])
+	}
+	return hooks, nil
+}
+
+// checkDir ensures that the hooks directory dir can't be modified by users.
+func checkDir(dir string) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !fi.IsDir() || !ok {
+		return fmt.Errorf("hooks directory %s is not a directory", dir)
+	}
+	if st.Uid != 0 || fi.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("hooks directory %s must be owned by root and not writable by group or others", dir)
+	}
+	return nil
+}
+
+// read reads and validates the hook definition file path.
+func read(path string) (*Hook, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("while reading hook definition: %s", err)
+	}
+	h := &Hook{Name: filepath.Base(path)}
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, fmt.Errorf("while decoding hook definition %s: %s", path, err)
+	}
+	if err := h.validate(); err != nil {
+		return nil, fmt.Errorf("invalid hook definition %s: %s", path, err)
+	}
+	return h, nil
+}
+
+func (h *Hook) validate() error {
+	if h.Version != Version {
+		return fmt.Errorf("unsupported version %q, must be %s", h.Version, Version)
+	}
+	if !filepath.IsAbs(h.Hook.Path) {
+		return fmt.Errorf("hook path %q is not absolute", h.Hook.Path)
+	}
+	if len(h.Stages) == 0 {
+		return fmt.Errorf("no stage")
+	}
+	for _, stage := range h.Stages {
+		switch stage {
+		case Prestart, CreateRuntime, CreateContainer, StartContainer, Poststart, Poststop:
+		default:
+			return fmt.Errorf("unknown stage %q", stage)
+		}
+	}
+	for _, env := range h.Env {
+		if !strings.Contains(env, "=") {
+			return fmt.Errorf("environment variable %q: '=' is missing", env)
+		}
+	}
+	for _, m := range h.Mounts {
+		if !filepath.IsAbs(m.Source) || !filepath.IsAbs(m.Destination) {
+			return fmt.Errorf("mount %s:%s: paths must be absolute", m.Source, m.Destination)
+		}
+	}
+
+	var patterns []string
+	for k, v := range h.When.Annotations {
+		patterns = append(patterns, k, v)
+	}
+	for k, v := range h.When.Labels {
+		patterns = append(patterns, k, v)
+	}
+	patterns = append(patterns, h.When.Commands...)
+	for _, p := range patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("invalid regular expression %q: %s", p, err)
+		}
+	}
+	return nil
+}
+
+// matchMap returns if a key and value of m match a key and value regular
+// expressions pair of patterns.
+func matchMap(patterns, m map[string]string) bool {
+	for kp, vp := range patterns {
+		for k, v := range m {
+			// patterns are validated when loaded
+			if regexp.MustCompile(kp).MatchString(k) && regexp.MustCompile(vp).MatchString(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Match returns if the hook conditions match the container c.
+func (h *Hook) Match(c *Container) bool {
+	w := &h.When
+	if w.Always != nil && *w.Always {
+		return true
+	}
+	if w.HasBindMounts != nil && *w.HasBindMounts && c.HasBindMounts {
+		return true
+	}
+	if matchMap(w.Annotations, c.Annotations) || matchMap(w.Labels, c.Labels) {
+		return true
+	}
+	if len(c.Command) > 0 {
+		for _, p := range w.Commands {
+			if regexp.MustCompile(p).MatchString(c.Command[0]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Matching returns the hooks matching the container c.
+func Matching(hooks []*Hook, c *Container) []*Hook {
+	var matched []*Hook
+	for _, h := range hooks {
+		if h.Match(c) {
+			sylog.Debugf("OCI hook %s matches the container", h.Name)
+			matched = append(matched, h)
+		}
+	}
+	return matched
+}
+
+// Apply adds the commands, environment variables and mounts of hooks to
+// the OCI specification spec.
+func Apply(hooks []*Hook, spec *specs.Spec) {
+	for _, h := range hooks {
+		if spec.Hooks == nil {
+			spec.Hooks = new(specs.Hooks)
+		}
+		for _, stage := range h.Stages {
+			switch stage {
+			case Prestart:
+				spec.Hooks.Prestart = append(spec.Hooks.Prestart, h.Hook)
+			case CreateRuntime:
+				spec.Hooks.CreateRuntime = append(spec.Hooks.CreateRuntime, h.Hook)
+			case CreateContainer:
+				spec.Hooks.CreateContainer = append(spec.Hooks.CreateContainer, h.Hook)
+			case StartContainer:
+				spec.Hooks.StartContainer = append(spec.Hooks.StartContainer, h.Hook)
+			case Poststart:
+				spec.Hooks.Poststart = append(spec.Hooks.Poststart, h.Hook)
+			case Poststop:
+				spec.Hooks.Poststop = append(spec.Hooks.Poststop, h.Hook)
+			}
+		}
+		if spec.Process != nil {
+			spec.Process.Env = append(spec.Process.Env, h.Env...)
+		}
+		for _, m := range h.Mounts {
+			if m.Type == "" {
+				m.Type = "bind"
+			}
+			if len(m.Options) == 0 {
+				m.Options = []string{"nosuid", "nodev"}
+			}
+			if !hasBindOption(m.Options) {
+				m.Options = append([]string{"rbind"}, m.Options...)
+			}
+			spec.Mounts = append(spec.Mounts, m)
+		}
+	}
+}
+
+func hasBindOption(options []string) bool {
+	for _, o := range options {
+		if o == "bind" || o == "rbind" {
+			return true
+		}
+	}
+	return false
+}
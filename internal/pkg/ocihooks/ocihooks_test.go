@@ -0,0 +1,150 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package ocihooks
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const testHook = `{
+	"version": "1.0.0",
+	"hook": {"path": "/usr/bin/true"},
+	"when": {"labels": {"^com\\.example\\.gpu$": "^yes$"}, "commands": ["python"]},
+	"stages": ["prestart", "poststop"],
+	"env": ["GPU_HOOK=1"],
+	"mounts": [{"source": "/opt/gpu", "destination": "/opt/gpu", "options": ["ro"]}]
+}`
+
+func writeHook(t *testing.T, dir, name, content string) {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write hook definition: %s", err)
+	}
+}
+
+func TestRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ocihooks-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"Valid", testHook, false},
+		{"BadVersion", `{"version": "2.0.0", "hook": {"path": "/bin/true"}, "stages": ["prestart"]}`, true},
+		{"RelativePath", `{"version": "1.0.0", "hook": {"path": "true"}, "stages": ["prestart"]}`, true},
+		{"NoStage", `{"version": "1.0.0", "hook": {"path": "/bin/true"}}`, true},
+		{"BadStage", `{"version": "1.0.0", "hook": {"path": "/bin/true"}, "stages": ["prerun"]}`, true},
+		{"BadRegexp", `{"version": "1.0.0", "hook": {"path": "/bin/true"}, "when": {"commands": ["("]}, "stages": ["prestart"]}`, true},
+		{"BadEnv", `{"version": "1.0.0", "hook": {"path": "/bin/true"}, "stages": ["prestart"], "env": ["FOO"]}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeHook(t, dir, tt.name+".json", tt.content)
+			_, err := read(filepath.Join(dir, tt.name+".json"))
+			if tt.wantErr && err == nil {
+				t.Errorf("unexpected success")
+			} else if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("hooks directories must be owned by root")
+	}
+
+	dirs := make([]string, 2)
+	for i := range dirs {
+		dir, err := ioutil.TempDir("", "ocihooks-")
+		if err != nil {
+			t.Fatalf("failed to create temporary directory: %s", err)
+		}
+		defer os.RemoveAll(dir)
+		dirs[i] = dir
+	}
+
+	writeHook(t, dirs[0], "b.json", testHook)
+	writeHook(t, dirs[0], "a.json", `{"version": "1.0.0", "hook": {"path": "/bin/false"}, "stages": ["prestart"]}`)
+	writeHook(t, dirs[1], "a.json", `{"version": "1.0.0", "hook": {"path": "/bin/true"}, "stages": ["poststart"]}`)
+	writeHook(t, dirs[1], "README", "ignored")
+
+	hooks, err := Load(append(dirs, "/non/existent")...)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(hooks) != 2 {
+		t.Fatalf("got %d hooks, expected 2", len(hooks))
+	}
+	if hooks[0].Name != "a.json" || hooks[0].Stages[0] != Poststart {
+		t.Errorf("a.json of the first directory not replaced")
+	}
+	if hooks[1].Name != "b.json" {
+		t.Errorf("unexpected hook %s", hooks[1].Name)
+	}
+
+	if err := os.Chmod(dirs[1], 0777); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := Load(dirs...); err == nil {
+		t.Errorf("unexpected success with a world writable directory")
+	}
+}
+
+func TestMatchApply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ocihooks-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeHook(t, dir, "gpu.json", testHook)
+	h, err := read(filepath.Join(dir, "gpu.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		name      string
+		container Container
+		match     bool
+	}{
+		{"NoMatch", Container{Labels: map[string]string{"com.example.gpu": "no"}, Command: []string{"/bin/sh"}}, false},
+		{"Label", Container{Labels: map[string]string{"com.example.gpu": "yes"}}, true},
+		{"Command", Container{Command: []string{"/usr/bin/python3", "-c"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if m := h.Match(&tt.container); m != tt.match {
+				t.Errorf("got match %v, expected %v", m, tt.match)
+			}
+		})
+	}
+
+	spec := &specs.Spec{Process: &specs.Process{Env: []string{"PATH=/bin"}}}
+	Apply([]*Hook{h}, spec)
+
+	if len(spec.Hooks.Prestart) != 1 || len(spec.Hooks.Poststop) != 1 || len(spec.Hooks.Poststart) != 0 {
+		t.Errorf("unexpected hooks %+v", spec.Hooks)
+	}
+	if len(spec.Process.Env) != 2 || spec.Process.Env[1] != "GPU_HOOK=1" {
+		t.Errorf("unexpected environment %v", spec.Process.Env)
+	}
+	if len(spec.Mounts) != 1 || spec.Mounts[0].Type != "bind" || spec.Mounts[0].Options[1] != "ro" {
+		t.Errorf("unexpected mounts %+v", spec.Mounts)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package quarantine keeps track of the images released from quarantine,
+// so the runtime refuses to open images before they are released.
+//
+// Released images are recorded by their digest in a store directory owned
+// by root, each release being an empty file named after the digest. Image
+// files owned by users can be modified or copied at will, only their digest
+// computed when opened by the runtime identifies them as released.
+package quarantine
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/hpcng/singularity/pkg/syfs"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+// Dir returns the quarantine directory dir if set, the quarantine directory
+// of the user singularity directory otherwise.
+func Dir(dir string) string {
+	if dir != "" {
+		return dir
+	}
+	return filepath.Join(syfs.ConfigDir(), "quarantine")
+}
+
+// entryPath returns the path of the release of digest d in the store.
+func entryPath(store string, d godigest.Digest) string {
+	return filepath.Join(store, d.Algorithm().String()+"-"+d.Encoded())
+}
+
+// ownedByRoot returns an error if the file described by fi isn't owned
+// by root or is writable by group or others.
+func ownedByRoot(path string, fi os.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || st.Uid != 0 {
+		return fmt.Errorf("%s is not owned by root", path)
+	}
+	if fi.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("%s is writable by group or others", path)
+	}
+	return nil
+}
+
+// Release records the image with the digest d as released in the store
+// directory, only root can release images.
+func Release(store string, d godigest.Digest) error {
+	if err := d.Validate(); err != nil {
+		return fmt.Errorf("invalid image digest %s: %s", d, err)
+	}
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("only root can release images from quarantine")
+	}
+
+	oldmask := syscall.Umask(0022)
+	defer syscall.Umask(oldmask)
+
+	if err := os.MkdirAll(store, 0755); err != nil {
+		return fmt.Errorf("while creating quarantine store: %s", err)
+	}
+	fi, err := os.Lstat(store)
+	if err != nil {
+		return fmt.Errorf("while checking quarantine store: %s", err)
+	}
+	if err := ownedByRoot(store, fi); err != nil {
+		return fmt.Errorf("untrusted quarantine store: %s", err)
+	}
+
+	f, err := os.OpenFile(entryPath(store, d), os.O_WRONLY|os.O_CREATE|syscall.O_NOFOLLOW, 0644)
+	if err != nil {
+		return fmt.Errorf("while recording release of %s: %s", d, err)
+	}
+	return f.Close()
+}
+
+// Released returns whether the image with the digest d is recorded as
+// released in the store directory. The store and its entries must be
+// owned by root and not writable by others, they are ignored otherwise.
+func Released(store string, d godigest.Digest) (bool, error) {
+	fi, err := os.Lstat(store)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("while checking quarantine store: %s", err)
+	}
+	if err := ownedByRoot(store, fi); err != nil || !fi.IsDir() {
+		return false, fmt.Errorf("untrusted quarantine store: %v", err)
+	}
+
+	path := entryPath(store, d)
+	fi, err = os.Lstat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("while checking release of %s: %s", d, err)
+	}
+	if !fi.Mode().IsRegular() {
+		return false, nil
+	}
+	return ownedByRoot(path, fi) == nil, nil
+}
+
+// Check returns an error if the image opened as f from path is held in the
+// quarantine directory dir, or if its digest computed with the algorithm a
+// from f isn't recorded as released in the store directory.
+func Check(f *os.File, path, dir, store string, a godigest.Algorithm) error {
+	qdir, err := filepath.EvalSymlinks(Dir(dir))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("while resolving quarantine directory: %s", err)
+	} else if err == nil {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			resolved = path
+		}
+		if resolved == qdir || strings.HasPrefix(resolved, qdir+string(os.PathSeparator)) {
+			return fmt.Errorf("image %s is held in quarantine, release it before using it", path)
+		}
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("while checking image %s: %s", path, err)
+	}
+	// the digest is read from the opened file, not from path
+	d, err := a.FromReader(io.NewSectionReader(f, 0, fi.Size()))
+	if err != nil {
+		return fmt.Errorf("while computing digest of image %s: %s", path, err)
+	}
+	released, err := Released(store, d)
+	if err != nil {
+		return err
+	} else if !released {
+		return fmt.Errorf("image %s with digest %s was not released from quarantine", path, d)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package quarantine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	godigest "github.com/opencontainers/go-digest"
+)
+
+func TestCheck(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("only root can release images")
+	}
+
+	tmpDir, err := ioutil.TempDir("", "quarantine-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dir := filepath.Join(tmpDir, "quarantine")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatalf("failed to create directory: %s", err)
+	}
+	store := filepath.Join(tmpDir, "store")
+
+	write := func(path, content string) string {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write image: %s", err)
+		}
+		return path
+	}
+	check := func(path string) error {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("failed to open image: %s", err)
+		}
+		defer f.Close()
+		return Check(f, path, dir, store, godigest.SHA256)
+	}
+
+	// images in the quarantine directory are refused, even through symlinks
+	quarantined := write(filepath.Join(dir, "image.sif"), "image")
+	link := filepath.Join(tmpDir, "link.sif")
+	if err := os.Symlink(quarantined, link); err != nil {
+		t.Fatalf("failed to create symlink: %s", err)
+	}
+	for _, path := range []string{quarantined, link} {
+		if err := check(path); err == nil {
+			t.Errorf("unexpected success with %s", path)
+		}
+	}
+
+	// images are refused until their digest is released
+	image := write(filepath.Join(tmpDir, "image.sif"), "image")
+	if err := check(image); err == nil {
+		t.Errorf("unexpected success with an image not released")
+	}
+	if err := Release(store, godigest.SHA256.FromString("image")); err != nil {
+		t.Fatalf("failed to release image: %s", err)
+	}
+	if err := check(image); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	// a copy is released too, a modified image is not
+	other := write(filepath.Join(tmpDir, "other.sif"), "image")
+	if err := check(other); err != nil {
+		t.Errorf("unexpected error with a copy: %s", err)
+	}
+	write(other, "modified")
+	if err := check(other); err == nil {
+		t.Errorf("unexpected success with a modified image")
+	}
+
+	// releases recorded in a store writable by others are ignored
+	if err := os.Chmod(store, 0777); err != nil {
+		t.Fatalf("failed to change store permissions: %s", err)
+	}
+	if err := check(image); err == nil {
+		t.Errorf("unexpected success with an untrusted store")
+	}
+}
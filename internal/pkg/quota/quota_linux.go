@@ -0,0 +1,175 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package quota keeps track of the containers and instances running per
+// user in order to enforce the per user limits set in singularity.conf.
+//
+// Each running container is registered by a file in a per user directory,
+// the file being locked by the container master process during the whole
+// container lifetime. Files which are not locked anymore belong to
+// terminated containers and are removed when the usage is computed.
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+const lockFile = ".lock"
+
+// Entry describes a running container.
+type Entry struct {
+	// Instance is true if the container is an instance.
+	Instance bool `json:"instance"`
+	// Memory is the memory limit in bytes reserved by the
+	// container cgroup, zero if the container has no limit.
+	Memory int64 `json:"memory"`
+}
+
+// Usage holds the resources used by a user.
+type Usage struct {
+	// Containers is the number of running containers including instances.
+	Containers int
+	// Instances is the number of running instances.
+	Instances int
+	// Memory is the total memory in bytes reserved by containers cgroups.
+	Memory int64
+}
+
+// Limits holds the per user limits, a zero value means unlimited.
+type Limits struct {
+	Containers int
+	Instances  int
+	Memory     int64
+}
+
+// Reservation is a container registration released by Release.
+type Reservation struct {
+	file *os.File
+}
+
+func lock(path string, how int) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// usage computes the resources used by the containers registered in
+// the user directory and removes stale entries, the caller must hold
+// the user directory lock.
+func usage(dir string) (Usage, error) {
+	var u Usage
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return u, fmt.Errorf("while reading %s: %s", dir, err)
+	}
+	for _, fi := range files {
+		if fi.Name() == lockFile || !fi.Mode().IsRegular() {
+			continue
+		}
+		path := filepath.Join(dir, fi.Name())
+		f, err := lock(path, syscall.LOCK_SH|syscall.LOCK_NB)
+		if err == nil {
+			// not locked by a master process anymore
+			f.Close()
+			os.Remove(path)
+			continue
+		} else if err != syscall.EWOULDBLOCK {
+			return u, fmt.Errorf("while checking %s: %s", path, err)
+		}
+
+		var e Entry
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return u, fmt.Errorf("while reading %s: %s", path, err)
+		}
+		if err := json.Unmarshal(data, &e); err != nil {
+			return u, fmt.Errorf("while decoding %s: %s", path, err)
+		}
+
+		u.Containers++
+		if e.Instance {
+			u.Instances++
+		}
+		u.Memory += e.Memory
+	}
+	return u, nil
+}
+
+// Reserve checks that registering the container entry for the user uid
+// doesn't exceed the limits and registers it. The returned reservation
+// must be held by the process monitoring the container until the
+// container exits.
+func Reserve(root string, uid int, pid int, e Entry, limits Limits) (*Reservation, error) {
+	dir := filepath.Join(root, strconv.Itoa(uid))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("while creating quota directory %s: %s", dir, err)
+	}
+
+	// serialize reservations of the same user
+	l, err := lock(filepath.Join(dir, lockFile), syscall.LOCK_EX)
+	if err != nil {
+		return nil, fmt.Errorf("while locking quota directory %s: %s", dir, err)
+	}
+	defer l.Close()
+
+	u, err := usage(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if limits.Containers > 0 && u.Containers+1 > limits.Containers {
+		return nil, fmt.Errorf("limit of %d running containers per user reached", limits.Containers)
+	}
+	if e.Instance && limits.Instances > 0 && u.Instances+1 > limits.Instances {
+		return nil, fmt.Errorf("limit of %d running instances per user reached", limits.Instances)
+	}
+	if limits.Memory > 0 && u.Memory+e.Memory > limits.Memory {
+		return nil, fmt.Errorf(
+			"memory limit of %d bytes per user exceeded, %d bytes already reserved by running containers",
+			limits.Memory, u.Memory,
+		)
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("while encoding quota entry: %s", err)
+	}
+
+	path := filepath.Join(dir, strconv.Itoa(pid))
+	os.Remove(path)
+	f, err := lock(path, syscall.LOCK_EX)
+	if err != nil {
+		return nil, fmt.Errorf("while creating quota entry %s: %s", path, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("while writing quota entry %s: %s", path, err)
+	}
+	return &Reservation{file: f}, nil
+}
+
+// Release unregisters the container.
+func (r *Reservation) Release() error {
+	path := r.file.Name()
+	if err := os.Remove(path); err != nil {
+		r.file.Close()
+		return fmt.Errorf("while removing quota entry %s: %s", path, err)
+	}
+	return r.file.Close()
+}
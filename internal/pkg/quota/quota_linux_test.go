@@ -0,0 +1,69 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package quota
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReserve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quota-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	limits := Limits{Containers: 3, Instances: 1, Memory: 1024}
+
+	instance, err := Reserve(dir, 1000, 1, Entry{Instance: true, Memory: 512}, limits)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := Reserve(dir, 1000, 2, Entry{Instance: true}, limits); err == nil {
+		t.Errorf("unexpected success with instances limit reached")
+	}
+	if _, err := Reserve(dir, 1000, 2, Entry{Memory: 1024}, limits); err == nil {
+		t.Errorf("unexpected success with memory limit exceeded")
+	}
+
+	container, err := Reserve(dir, 1000, 2, Entry{Memory: 512}, limits)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// other users are not affected
+	other, err := Reserve(dir, 1001, 3, Entry{Instance: true, Memory: 1024}, limits)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer other.Release()
+
+	// simulate a terminated master process without release
+	container.file.Close()
+
+	last, err := Reserve(dir, 1000, 4, Entry{}, limits)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1000", "2")); !os.IsNotExist(err) {
+		t.Errorf("stale entry not removed")
+	}
+	if _, err := Reserve(dir, 1000, 5, Entry{}, Limits{Containers: 2}); err == nil {
+		t.Errorf("unexpected success with containers limit reached")
+	}
+
+	if err := instance.Release(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := last.Release(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if _, err := Reserve(dir, 1000, 6, Entry{Instance: true}, limits); err != nil {
+		t.Errorf("unexpected error after release: %s", err)
+	}
+}
@@ -170,6 +170,32 @@ func (c *Config) SetHybridWorkflow(hybrid bool) {
 	}
 }
 
+// SetSecurityAudit sets the flag to tell starter to log every
+// privileged action it takes.
+func (c *Config) SetSecurityAudit(audit bool) {
+	if audit {
+		c.config.starter.securityAudit = C.true
+	} else {
+		c.config.starter.securityAudit = C.false
+	}
+}
+
+// SetDryRun sets the flag to tell starter to exit once stage 1 is
+// completed, when the engine reported the container set up without
+// creating it.
+func (c *Config) SetDryRun(dryRun bool) {
+	if dryRun {
+		c.config.starter.dryRun = C.true
+	} else {
+		c.config.starter.dryRun = C.false
+	}
+}
+
+// GetSecurityAudit returns true if starter logs privileged actions.
+func (c *Config) GetSecurityAudit() bool {
+	return c.config.starter.securityAudit == C.true
+}
+
 // SetAllowSetgroups allows use of setgroups syscall from user namespace.
 func (c *Config) SetAllowSetgroups(allow bool) {
 	if allow {
@@ -90,6 +90,22 @@ type Operations interface {
 	CleanupContainer(context.Context, error, syscall.WaitStatus) error
 }
 
+// ExitError is returned by StartProcess when the container process
+// can't be executed, the starter exits with Status instead of the
+// generic failure status.
+type ExitError struct {
+	Status int
+	Err    error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
 // getName returns the engine name set in JSON []byte configuration.
 func getName(b []byte) string {
 	engineName := struct {
@@ -103,6 +119,21 @@ func getName(b []byte) string {
 
 // Get returns the engine described by the JSON []byte configuration.
 func Get(b []byte) (*Engine, error) {
+	// configuration may come from an older or a newer CLI
+	version, err := config.GetVersion(b)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.CheckVersion(version); err != nil {
+		return nil, err
+	}
+	if version != config.CurrentVersion {
+		b, err = config.Convert(b, config.CurrentVersion)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	engineName := getName(b)
 
 	// ensure engine with given name is registered
@@ -253,29 +253,21 @@ func (e *EngineOperations) StartProcess(masterConnFd int) error {
 // Particularly here no additional privileges are gained as monitor does
 // not need them for wait4 and kill syscalls.
 func (e *EngineOperations) MonitorContainer(pid int, signals chan os.Signal) (syscall.WaitStatus, error) {
-	var status syscall.WaitStatus
-
-	for {
-		s := <-signals
-		switch s {
-		case syscall.SIGCHLD:
-			if wpid, err := syscall.Wait4(pid, &status, syscall.WNOHANG, nil); err != nil {
-				return status, fmt.Errorf("error while waiting child: %s", err)
-			} else if wpid != pid {
-				continue
-			}
-			return status, nil
-		case syscall.SIGURG:
-			// Ignore SIGURG, which is used for non-cooperative goroutine
-			// preemption starting with Go 1.14. For more information, see
-			// https://github.com/golang/go/issues/24543.
-			break
-		default:
-			if err := syscall.Kill(pid, s.(syscall.Signal)); err != nil {
-				return status, fmt.Errorf("interrupted by signal %s", s.String())
-			}
+	info, err := e.WaitContainer(pid, signals)
+	return info.Status, err
+}
+
+// WaitContainer is called from master once the container has been
+// spawned, it blocks until the container exits and returns the container
+// process exit information. Signals received are forwarded to the
+// container process.
+func (e *EngineOperations) WaitContainer(pid int, signals chan os.Signal) (*engine.ExitInfo, error) {
+	return engine.WaitProcess(pid, signals, func(s syscall.Signal) error {
+		if err := syscall.Kill(pid, s); err != nil {
+			return fmt.Errorf("interrupted by signal %s", s.String())
 		}
-	}
+		return nil
+	})
 }
 
 // CleanupContainer does nothing for the fakeroot engine.
@@ -0,0 +1,230 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oci
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hpcng/singularity/pkg/ociruntime"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/util/unix"
+	"github.com/kr/pty"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	// attachTokenTimeout is the time given to an attach client
+	// to send the token.
+	attachTokenTimeout = 5 * time.Second
+	// attachHelloTimeout is the time given to an attach client to
+	// send the framed protocol magic, clients not sending it in time
+	// use the raw byte stream protocol.
+	attachHelloTimeout = 500 * time.Millisecond
+	// exitOutputTimeout is the time given to copy the remaining
+	// container output before sending the exit status to the attach
+	// clients, processes left in the container may hold the output.
+	exitOutputTimeout = time.Second
+)
+
+// attachClients tracks the framed attach clients to send them the exit
+// status of the container process.
+type attachClients struct {
+	mutex   sync.Mutex
+	framers map[*ociruntime.Framer]struct{}
+	// outputDone is closed once the container output is copied.
+	outputDone chan struct{}
+}
+
+func newAttachClients() *attachClients {
+	return &attachClients{
+		framers:    make(map[*ociruntime.Framer]struct{}),
+		outputDone: make(chan struct{}),
+	}
+}
+
+func (a *attachClients) add(framer *ociruntime.Framer) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.framers[framer] = struct{}{}
+}
+
+func (a *attachClients) del(framer *ociruntime.Framer) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.framers, framer)
+}
+
+// notifyExit sends the exit status in an exit frame to the attached
+// clients once the container output is copied.
+func (a *attachClients) notifyExit(status *ociruntime.ExitStatus) {
+	select {
+	case <-a.outputDone:
+	case <-time.After(exitOutputTimeout):
+		sylog.Debugf("Container output still open, sending exit status to attach clients")
+	}
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		sylog.Warningf("Could not encode exit status: %s", err)
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for framer := range a.framers {
+		if err := framer.WriteFrame(ociruntime.ExitFrame, data); err != nil {
+			sylog.Debugf("Could not send exit status to attach client: %s", err)
+		}
+	}
+}
+
+// authenticateAttach checks that the attach client connected over c
+// runs as root or as the container owner, and that it sends the attach
+// token first when one is set.
+func (e *EngineOperations) authenticateAttach(c net.Conn) error {
+	cred, err := unix.PeerCredentials(c)
+	if err != nil {
+		return err
+	}
+	if cred.Uid != 0 && cred.Uid != uint32(os.Getuid()) {
+		return fmt.Errorf("client process %d runs as uid %d", cred.Pid, cred.Uid)
+	}
+
+	token := e.EngineConfig.GetAttachToken()
+	if token == "" {
+		return nil
+	}
+
+	// the token is followed by a newline, exactly that is read
+	// to not consume the client input
+	buf := make([]byte, len(token)+1)
+	c.SetReadDeadline(time.Now().Add(attachTokenTimeout))
+	if _, err := io.ReadFull(c, buf); err != nil {
+		return fmt.Errorf("client process %d didn't send the attach token: %s", cred.Pid, err)
+	}
+	c.SetReadDeadline(time.Time{})
+
+	if subtle.ConstantTimeCompare(buf, []byte(token+"\n")) != 1 {
+		return fmt.Errorf("client process %d sent a wrong attach token", cred.Pid)
+	}
+	return nil
+}
+
+// negotiateAttach negotiates the attach protocol with the client
+// connected over c. It returns a Framer for the client connection if the
+// client speaks the framed protocol, or a nil Framer with the bytes read
+// from a raw client which must be handled as its input. The probe sent
+// by a probe client is returned with its Framer, the client is answered
+// with the probe result only.
+func negotiateAttach(c net.Conn) (*ociruntime.Framer, []byte, *ociruntime.Probe, error) {
+	buf := make([]byte, len(ociruntime.AttachMagic))
+
+	c.SetReadDeadline(time.Now().Add(attachHelloTimeout))
+	defer c.SetReadDeadline(time.Time{})
+
+	// a short read means a raw client which didn't send
+	// input yet or which closed its input
+	n, err := io.ReadFull(c, buf)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		err = nil
+	} else if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	} else if string(buf[:n]) != ociruntime.AttachMagic {
+		return nil, buf[:n], nil, nil
+	}
+
+	framer := ociruntime.NewFramer(c)
+
+	t, payload, err := ociruntime.ReadFrame(c)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("while reading attach protocol hello: %s", err)
+	}
+	if t == ociruntime.ProbeFrame {
+		probe := &ociruntime.Probe{}
+		if err := json.Unmarshal(payload, probe); err != nil {
+			framer.WriteFrame(ociruntime.ErrorFrame, []byte("bad probe"))
+			return nil, nil, nil, fmt.Errorf("client sent a bad probe: %s", err)
+		}
+		return framer, nil, probe, nil
+	}
+	if t != ociruntime.HelloFrame || len(payload) != 1 || payload[0] == 0 {
+		framer.WriteFrame(ociruntime.ErrorFrame, []byte("unsupported attach protocol version"))
+		return nil, nil, nil, fmt.Errorf("client sent a bad attach protocol hello")
+	}
+
+	version := payload[0]
+	if version > ociruntime.AttachProtocolVersion {
+		version = ociruntime.AttachProtocolVersion
+	}
+	if err := framer.WriteFrame(ociruntime.HelloFrame, []byte{version}); err != nil {
+		return nil, nil, nil, fmt.Errorf("while sending attach protocol hello: %s", err)
+	}
+	return framer, nil, nil, nil
+}
+
+// answerProbe runs the probe p sent by a probe client and sends it the
+// result in a probe frame.
+func (e *EngineOperations) answerProbe(framer *ociruntime.Framer, p *ociruntime.Probe) {
+	data, err := json.Marshal(e.runProbe(p))
+	if err != nil {
+		sylog.Warningf("Could not encode probe result: %s", err)
+		return
+	}
+	if err := framer.WriteFrame(ociruntime.ProbeFrame, data); err != nil {
+		sylog.Warningf("Could not send probe result: %s", err)
+	}
+}
+
+// readAttachFrames handles the frames sent by a framed attach client
+// over c until it disconnects, stdin data is written to in and console
+// size controls are applied to the terminal master if any. Unknown
+// frames are ignored to let clients use newer protocol features.
+func readAttachFrames(c net.Conn, in io.Writer, master *os.File) {
+	for {
+		t, payload, err := ociruntime.ReadFrame(c)
+		if err != nil {
+			if err != io.EOF {
+				sylog.Debugf("Attach client connection closed: %s", err)
+			}
+			return
+		}
+
+		switch t {
+		case ociruntime.StdinFrame:
+			in.Write(payload)
+		case ociruntime.ControlFrame:
+			ctrl := &ociruntime.Control{}
+			if err := json.Unmarshal(payload, ctrl); err != nil {
+				sylog.Warningf("Ignoring bad attach control frame: %s", err)
+				continue
+			}
+			if ctrl.ConsoleSize != nil && master != nil {
+				if err := setConsoleSize(master, ctrl.ConsoleSize); err != nil {
+					sylog.Warningf("Could not resize console: %s", err)
+				}
+			}
+		}
+	}
+}
+
+// setConsoleSize sets the size of the terminal with master side master.
+func setConsoleSize(master *os.File, size *specs.Box) error {
+	return pty.Setsize(master, &pty.Winsize{
+		Cols: uint16(size.Width),
+		Rows: uint16(size.Height),
+	})
+}
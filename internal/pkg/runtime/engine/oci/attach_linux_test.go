@@ -0,0 +1,156 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oci
+
+import (
+	"net"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/hpcng/singularity/pkg/ociruntime"
+)
+
+// socketPair returns both ends of a connected unix socket pair.
+func socketPair(t *testing.T) (net.Conn, net.Conn) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatalf("failed to create socket pair: %s", err)
+	}
+
+	conns := make([]net.Conn, 2)
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "socket")
+		conns[i], err = net.FileConn(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("failed to create connection: %s", err)
+		}
+	}
+	return conns[0], conns[1]
+}
+
+func TestAuthenticateAttach(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string
+		send    string
+		wantErr bool
+	}{
+		{
+			name: "no token",
+		},
+		{
+			name:  "token",
+			token: "secret",
+			send:  "secret\n",
+		},
+		{
+			name:    "wrong token",
+			token:   "secret",
+			send:    "public\n",
+			wantErr: true,
+		},
+		{
+			name:    "no newline",
+			token:   "secret",
+			send:    "secretX",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := socketPair(t)
+			defer server.Close()
+			defer client.Close()
+
+			e := &EngineOperations{EngineConfig: NewConfig()}
+			e.EngineConfig.SetAttachToken(tt.token)
+
+			if tt.send != "" {
+				if _, err := client.Write([]byte(tt.send + "input")); err != nil {
+					t.Fatalf("failed to send token: %s", err)
+				}
+			}
+
+			err := e.authenticateAttach(server)
+			if tt.wantErr && err == nil {
+				t.Fatalf("unexpected success")
+			} else if !tt.wantErr && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			// the client input following the token is not consumed
+			if tt.send != "" {
+				buf := make([]byte, len("input"))
+				if _, err := server.Read(buf); err != nil || string(buf) != "input" {
+					t.Errorf("client input consumed with the token")
+				}
+			}
+		})
+	}
+}
+
+func TestNegotiateAttachProbe(t *testing.T) {
+	tests := []struct {
+		name      string
+		payload   string
+		wantProbe *ociruntime.Probe
+		wantErr   bool
+	}{
+		{
+			name:      "probe",
+			payload:   `{"args":["test","-f","/run/ready"],"timeout":2}`,
+			wantProbe: &ociruntime.Probe{Args: []string{"test", "-f", "/run/ready"}, Timeout: 2},
+		},
+		{
+			name:    "bad probe",
+			payload: `{"args":`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := socketPair(t)
+			defer server.Close()
+			defer client.Close()
+
+			if _, err := client.Write([]byte(ociruntime.AttachMagic)); err != nil {
+				t.Fatalf("failed to send magic: %s", err)
+			}
+			if err := ociruntime.NewFramer(client).WriteFrame(ociruntime.ProbeFrame, []byte(tt.payload)); err != nil {
+				t.Fatalf("failed to send probe: %s", err)
+			}
+
+			framer, input, probe, err := negotiateAttach(server)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("unexpected success")
+				}
+				// the client is told why it's rejected
+				if ft, _, err := ociruntime.ReadFrame(client); err != nil || ft != ociruntime.ErrorFrame {
+					t.Errorf("no error frame sent to client")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if framer == nil || input != nil {
+				t.Errorf("probe client not handled as a framed client")
+			}
+			if !reflect.DeepEqual(probe, tt.wantProbe) {
+				t.Errorf("got probe %+v, want %+v", probe, tt.wantProbe)
+			}
+		})
+	}
+}
@@ -53,14 +53,19 @@ func (e *EngineOperations) CleanupContainer(ctx context.Context, fatal error, st
 
 	exitCode := 0
 	desc := ""
+	var signal syscall.Signal
 
 	if fatal != nil {
 		exitCode = 255
 		desc = fatal.Error()
+	} else if e.exitInfo != nil {
+		exitCode = e.exitInfo.ExitCode()
+		desc = e.exitInfo.String()
+		signal = e.exitInfo.Signal()
 	} else if status.Signaled() {
-		s := status.Signal()
-		exitCode = int(s) + 128
-		desc = fmt.Sprintf("interrupted by signal %s", s.String())
+		signal = status.Signal()
+		exitCode = int(signal) + 128
+		desc = fmt.Sprintf("interrupted by signal %s", signal.String())
 	} else {
 		exitCode = status.ExitStatus()
 		desc = fmt.Sprintf("exited with code %d", status.ExitStatus())
@@ -69,7 +74,18 @@ func (e *EngineOperations) CleanupContainer(ctx context.Context, fatal error, st
 	e.EngineConfig.State.ExitCode = &exitCode
 	e.EngineConfig.State.ExitDesc = desc
 
-	if err := e.updateState(ociruntime.Stopped); err != nil {
+	err := e.updateState(ociruntime.Stopped)
+
+	// the attached clients get the exit status even if the
+	// state couldn't be recorded
+	if e.attached != nil {
+		e.attached.notifyExit(&ociruntime.ExitStatus{
+			ExitCode: exitCode,
+			Signal:   int(signal),
+			Desc:     desc,
+		})
+	}
+	if err != nil {
 		return err
 	}
 
@@ -6,6 +6,7 @@
 package oci
 
 import (
+	"os"
 	"sync"
 
 	"github.com/hpcng/singularity/internal/pkg/cgroups"
@@ -16,6 +17,20 @@ import (
 // Name of the engine.
 const Name = "oci"
 
+const (
+	// StdinPipe connects the container process stdin to the
+	// standard input of oci create/run and of the attached clients.
+	StdinPipe = "pipe"
+	// StdinClose runs the container process with stdin closed,
+	// reading from /dev/null.
+	StdinClose = "close"
+	// StdinFile redirects the container process stdin from a file.
+	StdinFile = "file"
+	// StdinAttach connects the container process stdin to the
+	// first attached client only, stdin is closed once detached.
+	StdinAttach = "attach"
+)
+
 // EngineConfig is the config for the OCI engine.
 type EngineConfig struct {
 	BundlePath    string           `json:"bundlePath"`
@@ -31,6 +46,14 @@ type EngineConfig struct {
 	SyncSocket    string           `json:"syncSocket"`
 	EmptyProcess  bool             `json:"emptyProcess"`
 	Exec          bool             `json:"exec"`
+	ParallelHooks bool             `json:"parallelHooks,omitempty"`
+	StdinMode     string           `json:"stdinMode,omitempty"`
+	StdinPath     string           `json:"stdinPath,omitempty"`
+	AttachMode    uint32           `json:"attachMode,omitempty"`
+	AttachToken   string           `json:"attachToken,omitempty"`
+	ConsoleBuffer int              `json:"consoleBuffer,omitempty"`
+	EnvDeny       []string         `json:"envDeny,omitempty"`
+	EnvAllow      []string         `json:"envAllow,omitempty"`
 	Cgroups       *cgroups.Manager `json:"-"`
 
 	sync.Mutex `json:"-"`
@@ -95,3 +118,80 @@ func (e *EngineConfig) SetPidFile(path string) {
 func (e *EngineConfig) GetPidFile() string {
 	return e.PidFile
 }
+
+// SetParallelHooks sets if the hooks of a lifecycle stage are
+// executed concurrently.
+func (e *EngineConfig) SetParallelHooks(parallel bool) {
+	e.ParallelHooks = parallel
+}
+
+// GetParallelHooks returns if the hooks of a lifecycle stage are
+// executed concurrently.
+func (e *EngineConfig) GetParallelHooks() bool {
+	return e.ParallelHooks
+}
+
+// SetStdinMode sets the container process stdin mode.
+func (e *EngineConfig) SetStdinMode(mode string) {
+	e.StdinMode = mode
+}
+
+// GetStdinMode returns the container process stdin mode,
+// StdinPipe by default.
+func (e *EngineConfig) GetStdinMode() string {
+	if e.StdinMode == "" {
+		return StdinPipe
+	}
+	return e.StdinMode
+}
+
+// SetStdinPath sets the path of the file the container process
+// stdin is redirected from with StdinFile mode.
+func (e *EngineConfig) SetStdinPath(path string) {
+	e.StdinPath = path
+}
+
+// GetStdinPath returns the path of the file the container process
+// stdin is redirected from with StdinFile mode.
+func (e *EngineConfig) GetStdinPath() string {
+	return e.StdinPath
+}
+
+// SetAttachSocketMode sets the attach socket permissions.
+func (e *EngineConfig) SetAttachSocketMode(mode os.FileMode) {
+	e.AttachMode = uint32(mode.Perm())
+}
+
+// GetAttachSocketMode returns the attach socket permissions,
+// 0600 by default.
+func (e *EngineConfig) GetAttachSocketMode() os.FileMode {
+	if e.AttachMode == 0 {
+		return 0600
+	}
+	return os.FileMode(e.AttachMode)
+}
+
+// SetAttachToken sets the token clients must send first
+// over the attach socket.
+func (e *EngineConfig) SetAttachToken(token string) {
+	e.AttachToken = token
+}
+
+// GetAttachToken returns the token clients must send first
+// over the attach socket, an empty token means no handshake.
+func (e *EngineConfig) GetAttachToken() string {
+	return e.AttachToken
+}
+
+// SetConsoleBuffer sets the size in bytes of the terminal output
+// replayed to newly attached clients.
+func (e *EngineConfig) SetConsoleBuffer(size int) {
+	e.ConsoleBuffer = size
+}
+
+// GetConsoleBuffer returns the size in bytes of the terminal output
+// replayed to newly attached clients, 0 means only the last line is
+// replayed.
+func (e *EngineConfig) GetConsoleBuffer() int {
+	return e.ConsoleBuffer
+}
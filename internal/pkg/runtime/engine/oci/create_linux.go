@@ -26,8 +26,6 @@ import (
 	"github.com/hpcng/singularity/pkg/ociruntime"
 	"github.com/hpcng/singularity/pkg/sylog"
 	"github.com/hpcng/singularity/pkg/util/fs/proc"
-	"github.com/hpcng/singularity/pkg/util/namespaces"
-	"github.com/hpcng/singularity/pkg/util/sysctl"
 	"github.com/hpcng/singularity/pkg/util/unix"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 )
@@ -142,6 +140,7 @@ type container struct {
 	mntNS       bool
 	devIndex    int
 	cgroupIndex int
+	devices     []device
 }
 
 var statusChan = make(chan string, 1)
@@ -260,23 +259,7 @@ func (e *EngineOperations) CreateContainer(ctx context.Context, pid int, rpcConn
 		return err
 	}
 
-	if err := namespaces.Enter(pid, "ipc"); err != nil {
-		return err
-	}
-	if err := namespaces.Enter(pid, "net"); err != nil {
-		return err
-	}
-
-	for key, value := range e.EngineConfig.OciConfig.Linux.Sysctl {
-		if err := sysctl.Set(key, value); err != nil {
-			return err
-		}
-	}
-
-	if err := namespaces.Enter(os.Getpid(), "ipc"); err != nil {
-		return err
-	}
-	if err := namespaces.Enter(os.Getpid(), "net"); err != nil {
+	if err := c.setSysctl(pid); err != nil {
 		return err
 	}
 
@@ -356,7 +339,7 @@ func (e *EngineOperations) CreateContainer(ctx context.Context, pid int, rpcConn
 			return fmt.Errorf("failed to close write error stream: %s", err)
 		}
 	}
-	if e.EngineConfig.InputStreams[0] != -1 {
+	if e.EngineConfig.InputStreams[1] != -1 {
 		if err := syscall.Close(e.EngineConfig.InputStreams[1]); err != nil {
 			return fmt.Errorf("failed to close write input stream: %s", err)
 		}
@@ -610,19 +593,14 @@ func (c *container) addCgroups(pid int, system *mount.System) error {
 }
 
 func (c *container) addAllPaths(system *mount.System) error {
-	// add masked path
-	if err := c.addMaskedPathsMount(system); err != nil {
+	// add read-only path first, paths masked below a
+	// read-only path must not be hidden by its bind mount
+	if err := c.addReadonlyPathsMount(system); err != nil {
 		return err
 	}
 
-	// add read-only path
-	if !c.userNS {
-		if err := c.addReadonlyPathsMount(system); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	// add masked path
+	return c.addMaskedPathsMount(system)
 }
 
 func (c *container) addRootfsMount(system *mount.System) error {
@@ -705,40 +683,14 @@ func (c *container) addDefaultDevices(system *mount.System) error {
 		}
 	}
 
-	for _, device := range devices {
-		dev := int((device.major << 8) | (device.minor & 0xff) | ((device.minor & 0xfff00) << 12))
-		path := filepath.Join(rootfsPath, device.path)
-		if _, err := os.Lstat(path); os.IsNotExist(err) {
-			if c.userNS {
-				path = filepath.Join(c.rootfs, device.path)
-				if _, err := os.Stat(device.path); os.IsNotExist(err) {
-					sylog.Debugf("skipping mount, %s doesn't exists", device.path)
-					continue
-				}
-				dirpath := filepath.Dir(path)
-				if _, err := c.rpcOps.MkdirAll(dirpath, 0755); err != nil {
-					return fmt.Errorf("could not create parent directory %s: %s", dirpath, err)
-				}
-				if _, err := c.rpcOps.Touch(path); err != nil {
-					return fmt.Errorf("could not create file %s: %s", path, err)
-				}
-				if err := c.rpcOps.Mount(device.path, path, "", syscall.MS_BIND, ""); err != nil {
-					return fmt.Errorf("could not mount %s to %s: %s", device.path, path, err)
-				}
-			} else {
-				dirpath := filepath.Dir(path)
-				if err := os.MkdirAll(dirpath, 0755); err != nil {
-					return fmt.Errorf("could not create parent directory %s: %s", dirpath, err)
-				}
-				if err := syscall.Mknod(path, uint32(device.mode), dev); err != nil {
-					return fmt.Errorf("could not create device %s: %s", path, err)
-				}
-				if device.uid != 0 || device.gid != 0 {
-					if err := os.Chown(path, device.uid, device.gid); err != nil {
-						return fmt.Errorf("could not change %s owner: %s", path, err)
-					}
-				}
-			}
+	for _, d := range devices {
+		if err := c.createDevice(d, false); err != nil {
+			return err
+		}
+	}
+	for _, d := range c.devices {
+		if err := c.createDevice(d, true); err != nil {
+			return err
 		}
 	}
 
@@ -782,7 +734,7 @@ func (c *container) addDevices(system *mount.System) error {
 			dev.gid = int(*d.GID)
 		}
 
-		devices = append(devices, dev)
+		c.devices = append(c.devices, dev)
 	}
 
 	if c.devIndex >= 0 {
@@ -813,24 +765,11 @@ func (c *container) addDevices(system *mount.System) error {
 	return nil
 }
 
+// addMaskedPathsMount masks the files of the spec masked paths
+// with /dev/null and the directories with a read-only tmpfs.
 func (c *container) addMaskedPathsMount(system *mount.System) error {
 	paths := c.engine.EngineConfig.OciConfig.Linux.MaskedPaths
 
-	dir, err := instance.GetDir(c.engine.CommonConfig.ContainerID, instance.OciSubDir)
-	if err != nil {
-		return err
-	}
-	nullPath := filepath.Join(dir, "null")
-
-	if _, err := os.Stat(nullPath); os.IsNotExist(err) {
-		oldmask := syscall.Umask(0)
-		defer syscall.Umask(oldmask)
-
-		if err := os.Mkdir(nullPath, 0755); err != nil {
-			return err
-		}
-	}
-
 	for _, path := range paths {
 		relativePath := filepath.Join(c.rootfs, path)
 		rpcPath := filepath.Join(c.rpcRoot, relativePath)
@@ -840,7 +779,7 @@ func (c *container) addMaskedPathsMount(system *mount.System) error {
 			continue
 		}
 		if fi.IsDir() {
-			if err := system.Points.AddBind(mount.OtherTag, nullPath, relativePath, syscall.MS_BIND); err != nil {
+			if err := system.Points.AddFS(mount.OtherTag, relativePath, "tmpfs", syscall.MS_RDONLY, ""); err != nil {
 				return err
 			}
 		} else if err := system.Points.AddBind(mount.OtherTag, "/dev/null", relativePath, syscall.MS_BIND); err != nil {
@@ -850,6 +789,9 @@ func (c *container) addMaskedPathsMount(system *mount.System) error {
 	return nil
 }
 
+// addReadonlyPathsMount bind mounts the spec read-only paths on
+// themselves and remounts them read-only, preserving the flags locked
+// for mounts inherited by a user namespace.
 func (c *container) addReadonlyPathsMount(system *mount.System) error {
 	paths := c.engine.EngineConfig.OciConfig.Linux.ReadonlyPaths
 
@@ -861,10 +803,18 @@ func (c *container) addReadonlyPathsMount(system *mount.System) error {
 			sylog.Debugf("ignoring read-only path %s: %s", path, err)
 			continue
 		}
-		if err := system.Points.AddBind(mount.OtherTag, relativePath, relativePath, syscall.MS_BIND|syscall.MS_RDONLY); err != nil {
+		flags := uintptr(syscall.MS_BIND | syscall.MS_REC | syscall.MS_RDONLY)
+		if c.userNS {
+			locked, err := lockedMountFlags(rpcPath)
+			if err != nil {
+				return err
+			}
+			flags |= locked
+		}
+		if err := system.Points.AddBind(mount.OtherTag, relativePath, relativePath, flags); err != nil {
 			return err
 		}
-		if err := system.Points.AddRemount(mount.OtherTag, relativePath, syscall.MS_BIND|syscall.MS_RDONLY); err != nil {
+		if err := system.Points.AddRemount(mount.OtherTag, relativePath, flags); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,88 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oci
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/hpcng/singularity/pkg/sylog"
+	"golang.org/x/sys/unix"
+)
+
+// createDevice creates the device node d in the container, an existing
+// file not matching the device type and number is replaced. Device nodes
+// can't be created in a user namespace, the host device node is bound
+// instead, if it doesn't exist or doesn't match the device an error is
+// returned when required is set, otherwise the device is skipped.
+func (c *container) createDevice(d device, required bool) error {
+	rdev := unix.Mkdev(uint32(d.major), uint32(d.minor))
+	if d.mode&syscall.S_IFMT == syscall.S_IFIFO {
+		rdev = 0
+	}
+	matches := func(st *syscall.Stat_t) bool {
+		return st.Mode&syscall.S_IFMT == uint32(d.mode)&syscall.S_IFMT && st.Rdev == rdev
+	}
+
+	path := filepath.Join(c.rpcRoot, c.rootfs, d.path)
+
+	var st syscall.Stat_t
+	exists := syscall.Lstat(path, &st) == nil
+	if exists && st.Mode&syscall.S_IFMT == syscall.S_IFDIR {
+		return fmt.Errorf("could not create device %s: a directory exists at this location", d.path)
+	}
+
+	if c.userNS {
+		var hst syscall.Stat_t
+		if err := syscall.Stat(d.path, &hst); err != nil || !matches(&hst) {
+			if required {
+				return fmt.Errorf("could not create device %s in user namespace: no matching host device", d.path)
+			}
+			sylog.Debugf("skipping mount, %s doesn't exists", d.path)
+			return nil
+		}
+		path = filepath.Join(c.rootfs, d.path)
+		dirpath := filepath.Dir(path)
+		if _, err := c.rpcOps.MkdirAll(dirpath, 0755); err != nil {
+			return fmt.Errorf("could not create parent directory %s: %s", dirpath, err)
+		}
+		if !exists {
+			if _, err := c.rpcOps.Touch(path); err != nil {
+				return fmt.Errorf("could not create file %s: %s", path, err)
+			}
+		}
+		if err := c.rpcOps.Mount(d.path, path, "", syscall.MS_BIND, ""); err != nil {
+			return fmt.Errorf("could not mount %s to %s: %s", d.path, path, err)
+		}
+		return nil
+	}
+
+	if exists && !matches(&st) {
+		sylog.Debugf("Replacing %s by device node", d.path)
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("could not remove %s: %s", path, err)
+		}
+		exists = false
+	}
+	if !exists {
+		dirpath := filepath.Dir(path)
+		if err := os.MkdirAll(dirpath, 0755); err != nil {
+			return fmt.Errorf("could not create parent directory %s: %s", dirpath, err)
+		}
+		if err := syscall.Mknod(path, uint32(d.mode), int(rdev)); err != nil {
+			return fmt.Errorf("could not create device %s: %s", path, err)
+		}
+	} else if err := os.Chmod(path, d.mode.Perm()); err != nil {
+		return fmt.Errorf("could not change %s mode: %s", path, err)
+	}
+	if err := os.Lchown(path, d.uid, d.gid); err != nil {
+		return fmt.Errorf("could not change %s owner: %s", path, err)
+	}
+
+	return nil
+}
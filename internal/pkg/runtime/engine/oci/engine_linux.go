@@ -6,9 +6,11 @@
 package oci
 
 import (
+	"github.com/hpcng/singularity/internal/pkg/instance"
 	"github.com/hpcng/singularity/internal/pkg/runtime/engine"
 	ociServer "github.com/hpcng/singularity/internal/pkg/runtime/engine/oci/rpc/server"
 	"github.com/hpcng/singularity/internal/pkg/runtime/engine/singularity/rpc/server"
+	"github.com/hpcng/singularity/internal/pkg/util/ptypool"
 	"github.com/hpcng/singularity/pkg/runtime/engine/config"
 )
 
@@ -17,6 +19,22 @@ import (
 type EngineOperations struct {
 	CommonConfig *config.Common `json:"-"`
 	EngineConfig *EngineConfig  `json:"engineConfig"`
+
+	// logger writes the container log, set in master
+	// by PreStartProcess.
+	logger *instance.Logger
+	// streams tracks the container process stream file
+	// descriptors of the current stage.
+	streams *ptypool.Pool
+	// heartbeat exchanges heartbeats with the container process
+	// until started, set in master by PreStartProcess.
+	heartbeat *heartbeat
+	// exitInfo is the container process exit information,
+	// set in master by WaitContainer.
+	exitInfo *engine.ExitInfo
+	// attached tracks the framed attach clients notified of the
+	// container process exit, set in master by handleStream.
+	attached *attachClients
 }
 
 // InitConfig stores the parsed config.Common inside the engine.
@@ -0,0 +1,173 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oci
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/hpcng/singularity/internal/pkg/runtime/engine"
+	"github.com/hpcng/singularity/internal/pkg/util/env"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// exitNotExecutable is the shell exit status returned when
+	// the process binary can't be executed.
+	exitNotExecutable = 126
+	// exitNotFound is the shell exit status returned when the
+	// process binary is not found.
+	exitNotFound = 127
+)
+
+// processEnv returns the process environment completed with the
+// variables a container process expects when they are not set: PATH,
+// HOME and USER from the container passwd entry of uid, and TERM when
+// a terminal is allocated.
+func processEnv(penv []string, uid uint32, terminal bool) []string {
+	environ := make([]string, len(penv))
+	copy(environ, penv)
+
+	if _, ok := getenv(environ, "PATH"); !ok {
+		environ = append(environ, "PATH="+env.DefaultPath)
+	}
+
+	_, hasHome := getenv(environ, "HOME")
+	_, hasUser := getenv(environ, "USER")
+	if !hasHome || !hasUser {
+		name, home := lookupPasswd("/etc/passwd", uid)
+		if !hasHome {
+			if home == "" {
+				home = "/"
+			}
+			environ = append(environ, "HOME="+home)
+		}
+		if !hasUser && name != "" {
+			environ = append(environ, "USER="+name)
+		}
+	}
+
+	if _, ok := getenv(environ, "TERM"); !ok && terminal {
+		environ = append(environ, "TERM=xterm")
+	}
+
+	return environ
+}
+
+// getenv returns the value of the variable key from environ, the
+// last definition wins like with execve.
+func getenv(environ []string, key string) (string, bool) {
+	prefix := key + "="
+	for i := len(environ) - 1; i >= 0; i-- {
+		if strings.HasPrefix(environ[i], prefix) {
+			return environ[i][len(prefix):], true
+		}
+	}
+	return "", false
+}
+
+// lookupPasswd returns the user name and home directory of uid
+// found in the passwd file at path, empty strings are returned if
+// there is no entry. The C library is not used as we are in the
+// container context and it could load host NSS modules.
+func lookupPasswd(path string, uid uint32) (string, string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// name:password:uid:gid:gecos:home:shell
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) != 7 {
+			continue
+		}
+		id, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil || uint32(id) != uid {
+			continue
+		}
+		return fields[0], fields[5]
+	}
+	return "", ""
+}
+
+// lookPath resolves the process binary file like a shell does with the
+// PATH value path. A relative path containing a slash is resolved against
+// the process working directory cwd. An *engine.ExitError with status 127
+// is returned if the binary is not found and 126 if it's not executable.
+func lookPath(file, cwd, path string) (string, error) {
+	if strings.Contains(file, "/") {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(cwd, file)
+		}
+		if err := checkExecutable(file); err != nil {
+			return "", execError(file, err)
+		}
+		return file, nil
+	}
+
+	var permErr error
+
+	for _, dir := range filepath.SplitList(path) {
+		// an empty entry refers to the working directory
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(cwd, dir)
+		}
+		p := filepath.Join(dir, file)
+		err := checkExecutable(p)
+		if err == nil {
+			return p, nil
+		} else if err != syscall.ENOENT && permErr == nil {
+			permErr = execError(p, err)
+		}
+	}
+	if permErr != nil {
+		return "", permErr
+	}
+
+	return "", &engine.ExitError{
+		Status: exitNotFound,
+		Err:    fmt.Errorf("%s: executable file not found in $PATH", file),
+	}
+}
+
+// checkExecutable returns ENOENT if the file at path doesn't exist,
+// EISDIR for a directory or EACCES if it's not executable.
+func checkExecutable(path string) error {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return syscall.ENOENT
+	} else if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return syscall.EISDIR
+	}
+	if err := unix.Access(path, unix.X_OK); err != nil {
+		return err
+	}
+	return nil
+}
+
+// execError returns an *engine.ExitError for a process binary path
+// failing with err, either during its lookup or its execution.
+func execError(path string, err error) error {
+	status := exitNotExecutable
+	if err == syscall.ENOENT {
+		status = exitNotFound
+	}
+	return &engine.ExitError{
+		Status: status,
+		Err:    fmt.Errorf("exec %s failed: %s", path, err),
+	}
+}
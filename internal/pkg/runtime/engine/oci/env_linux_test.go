@@ -0,0 +1,242 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oci
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/hpcng/singularity/internal/pkg/runtime/engine"
+	"github.com/hpcng/singularity/internal/pkg/util/env"
+)
+
+func TestProcessEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      []string
+		terminal bool
+		want     []string
+	}{
+		{
+			name: "complete",
+			env:  []string{"PATH=/bin", "HOME=/home/user", "USER=user"},
+			want: []string{"PATH=/bin", "HOME=/home/user", "USER=user"},
+		},
+		{
+			name: "default path",
+			env:  []string{"HOME=/home/user", "USER=user"},
+			want: []string{"HOME=/home/user", "USER=user", "PATH=" + env.DefaultPath},
+		},
+		{
+			name:     "terminal",
+			env:      []string{"PATH=/bin", "HOME=/home/user", "USER=user"},
+			terminal: true,
+			want:     []string{"PATH=/bin", "HOME=/home/user", "USER=user", "TERM=xterm"},
+		},
+		{
+			name:     "terminal set",
+			env:      []string{"PATH=/bin", "HOME=/home/user", "USER=user", "TERM=vt100"},
+			terminal: true,
+			want:     []string{"PATH=/bin", "HOME=/home/user", "USER=user", "TERM=vt100"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := processEnv(tt.env, 0, tt.terminal)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v instead of %v", got, tt.want)
+			}
+		})
+	}
+
+	// the process environment is not modified
+	penv := []string{"USER=user"}
+	processEnv(penv, 0, false)
+	if len(penv) != 1 {
+		t.Errorf("process environment modified")
+	}
+}
+
+func TestGetenv(t *testing.T) {
+	environ := []string{"A=1", "AB=2", "A=3", "EMPTY="}
+
+	tests := []struct {
+		key   string
+		value string
+		found bool
+	}{
+		{"A", "3", true},
+		{"AB", "2", true},
+		{"EMPTY", "", true},
+		{"B", "", false},
+	}
+	for _, tt := range tests {
+		value, found := getenv(environ, tt.key)
+		if value != tt.value || found != tt.found {
+			t.Errorf("got (%q, %v) instead of (%q, %v) for %s", value, found, tt.value, tt.found, tt.key)
+		}
+	}
+}
+
+func TestLookupPasswd(t *testing.T) {
+	f, err := ioutil.TempFile("", "passwd-")
+	if err != nil {
+		t.Fatalf("failed to create temporary file: %s", err)
+	}
+	defer os.Remove(f.Name())
+
+	content := "root:x:0:0:root:/root:/bin/sh\n" +
+		"broken:x:1000\n" +
+		"user:x:1000:1000::/home/user:/bin/sh\n"
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatalf("failed to write passwd file: %s", err)
+	}
+	f.Close()
+
+	tests := []struct {
+		uid  uint32
+		name string
+		home string
+	}{
+		{0, "root", "/root"},
+		{1000, "user", "/home/user"},
+		{2000, "", ""},
+	}
+	for _, tt := range tests {
+		name, home := lookupPasswd(f.Name(), tt.uid)
+		if name != tt.name || home != tt.home {
+			t.Errorf("got (%q, %q) instead of (%q, %q) for uid %d", name, home, tt.name, tt.home, tt.uid)
+		}
+	}
+
+	if name, home := lookupPasswd(filepath.Join(f.Name(), "missing"), 0); name != "" || home != "" {
+		t.Errorf("unexpected entry found in missing passwd file")
+	}
+}
+
+func TestLookPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lookpath-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	bin := filepath.Join(dir, "bin")
+	noexec := filepath.Join(dir, "noexec")
+	for _, d := range []string{bin, noexec, filepath.Join(bin, "subdir")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatalf("failed to create directory: %s", err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(bin, "prog"), nil, 0755); err != nil {
+		t.Fatalf("failed to create file: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(noexec, "prog"), nil, 0644); err != nil {
+		t.Fatalf("failed to create file: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(noexec, "data"), nil, 0644); err != nil {
+		t.Fatalf("failed to create file: %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		file   string
+		path   string
+		want   string
+		status int
+	}{
+		{
+			name: "found in path",
+			file: "prog",
+			path: noexec + ":" + bin,
+			want: filepath.Join(bin, "prog"),
+		},
+		{
+			name: "relative path entry",
+			file: "prog",
+			path: "bin",
+			want: filepath.Join(bin, "prog"),
+		},
+		{
+			name: "absolute path",
+			file: filepath.Join(bin, "prog"),
+			want: filepath.Join(bin, "prog"),
+		},
+		{
+			name: "relative to working directory",
+			file: "bin/prog",
+			want: filepath.Join(bin, "prog"),
+		},
+		{
+			name:   "not found",
+			file:   "missing",
+			path:   bin,
+			status: exitNotFound,
+		},
+		{
+			name:   "not executable",
+			file:   "data",
+			path:   bin + ":" + noexec,
+			status: exitNotExecutable,
+		},
+		{
+			name:   "directory",
+			file:   "subdir",
+			path:   bin,
+			status: exitNotExecutable,
+		},
+		{
+			name:   "missing file",
+			file:   "/missing/prog",
+			status: exitNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lookPath(tt.file, dir, tt.path)
+			if tt.status == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if got != tt.want {
+					t.Errorf("got %s instead of %s", got, tt.want)
+				}
+				return
+			}
+			var exitErr *engine.ExitError
+			if !errors.As(err, &exitErr) {
+				t.Fatalf("got error %v instead of an exit error", err)
+			}
+			if exitErr.Status != tt.status {
+				t.Errorf("got status %d instead of %d", exitErr.Status, tt.status)
+			}
+		})
+	}
+}
+
+func TestExecError(t *testing.T) {
+	tests := map[error]int{
+		syscall.ENOENT:  exitNotFound,
+		syscall.EACCES:  exitNotExecutable,
+		syscall.ENOEXEC: exitNotExecutable,
+	}
+	for err, status := range tests {
+		var exitErr *engine.ExitError
+		if !errors.As(execError("/bin/prog", err), &exitErr) {
+			t.Fatalf("exec error is not an exit error")
+		}
+		if exitErr.Status != status {
+			t.Errorf("got status %d instead of %d for %s", exitErr.Status, status, err)
+		}
+	}
+}
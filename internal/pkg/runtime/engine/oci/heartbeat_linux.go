@@ -0,0 +1,149 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oci
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// heartbeatEvent is the byte exchanged over the master socket
+	// while the container process waits for the start event.
+	heartbeatEvent = 'h'
+	// heartbeatInterval is the time between two heartbeats.
+	heartbeatInterval = 5 * time.Second
+	// heartbeatTimeout is the time after which a peer not sending
+	// heartbeats is considered dead.
+	heartbeatTimeout = 30 * time.Second
+)
+
+// heartbeat sends heartbeats over the master socket between the
+// container process and master, and optionally monitors the heartbeats
+// sent by the peer, until stopped.
+type heartbeat struct {
+	conn    net.Conn
+	mutex   sync.Mutex
+	stopped bool
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newHeartbeat starts sending heartbeats over conn.
+func newHeartbeat(conn net.Conn) *heartbeat {
+	h := &heartbeat{
+		conn: conn,
+		stop: make(chan struct{}),
+	}
+
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-h.stop:
+				return
+			case <-ticker.C:
+			}
+			// a peer not reading its heartbeats is detected by
+			// its own monitor, just stop sending on error
+			h.conn.SetWriteDeadline(time.Now().Add(heartbeatTimeout))
+			if _, err := h.conn.Write([]byte{heartbeatEvent}); err != nil {
+				return
+			}
+		}
+	}()
+
+	return h
+}
+
+// monitor reports an error on fatalChan if peer doesn't send heartbeats
+// before stop. A closed connection is not reported as the peer exit is
+// handled by the container process wait.
+func (h *heartbeat) monitor(peer string, fatalChan chan error) {
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+
+		data := make([]byte, 1)
+		for {
+			if !h.setReadDeadline(time.Now().Add(heartbeatTimeout)) {
+				return
+			}
+			_, err := h.conn.Read(data)
+			select {
+			case <-h.stop:
+				return
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				fatalChan <- fmt.Errorf("no heartbeat received from %s for %s, it may be wedged", peer, heartbeatTimeout)
+				return
+			} else if err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// setReadDeadline sets the connection read deadline unless the
+// heartbeat was stopped, it returns false if it was.
+func (h *heartbeat) setReadDeadline(t time.Time) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.stopped {
+		return false
+	}
+	h.conn.SetReadDeadline(t)
+	return true
+}
+
+// Stop stops sending and monitoring heartbeats, once returned the
+// connection can be used to send and receive events again.
+func (h *heartbeat) Stop() {
+	h.mutex.Lock()
+	if !h.stopped {
+		h.stopped = true
+		close(h.stop)
+		// interrupt a blocking monitor read
+		h.conn.SetReadDeadline(time.Now())
+	}
+	h.mutex.Unlock()
+
+	h.wg.Wait()
+
+	h.conn.SetReadDeadline(time.Time{})
+	h.conn.SetWriteDeadline(time.Time{})
+}
+
+// waitStartEvent sends heartbeats over the master socket conn until the
+// start event is received from master, it returns an error if master
+// terminated or stopped sending heartbeats.
+func waitStartEvent(conn net.Conn) error {
+	h := newHeartbeat(conn)
+	defer h.Stop()
+
+	data := make([]byte, 1)
+	for {
+		conn.SetReadDeadline(time.Now().Add(heartbeatTimeout))
+		_, err := conn.Read(data)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return fmt.Errorf("no heartbeat received from master for %s, it may be wedged", heartbeatTimeout)
+		} else if err != nil {
+			return fmt.Errorf("failed to receive start signal: %s", err)
+		}
+		if data[0] != heartbeatEvent {
+			return nil
+		}
+	}
+}
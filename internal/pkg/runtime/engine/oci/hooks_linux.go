@@ -0,0 +1,99 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oci
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/hpcng/singularity/internal/pkg/instance"
+	"github.com/hpcng/singularity/internal/pkg/ocihooks"
+	"github.com/hpcng/singularity/internal/pkg/util/exec"
+	"github.com/hpcng/singularity/pkg/util/singularityconf"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// NewLogger returns a logger writing to the log of container
+// containerID, in its instance directory if no log path is set.
+func (e *EngineConfig) NewLogger(containerID string) (*instance.Logger, error) {
+	logPath := e.GetLogPath()
+	if logPath == "" {
+		dir, err := instance.GetDir(containerID, instance.OciSubDir)
+		if err != nil {
+			return nil, err
+		}
+		logPath = filepath.Join(dir, containerID+".log")
+	}
+
+	format := e.GetLogFormat()
+	formatter, ok := instance.LogFormats[format]
+	if !ok {
+		return nil, fmt.Errorf("log format %s is not supported", format)
+	}
+
+	return instance.NewLogger(logPath, formatter)
+}
+
+// RunHooks executes the hooks of a container lifecycle stage, their
+// output is written to the container log with logger if not nil.
+// With stopOnError, the first failing hook stops the execution.
+func (e *EngineConfig) RunHooks(ctx context.Context, hooks []specs.Hook, logger *instance.Logger, stopOnError bool) []error {
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	opts := exec.HookOptions{
+		Parallel:    e.GetParallelHooks(),
+		StopOnError: stopOnError,
+	}
+	if logger != nil {
+		stdout, err := logger.NewWriter("stdout", true)
+		if err != nil {
+			return []error{err}
+		}
+		defer stdout.Close()
+		stderr, err := logger.NewWriter("stderr", true)
+		if err != nil {
+			return []error{err}
+		}
+		defer stderr.Close()
+		opts.Stdout = stdout
+		opts.Stderr = stderr
+	}
+
+	return exec.Hooks(ctx, hooks, &e.State.State, opts)
+}
+
+// prepareOCIHooks adds the site OCI hooks of the hooks directories set in
+// singularity.conf matching the container to its OCI specification, with
+// their environment variables and mounts.
+func (e *EngineOperations) prepareOCIHooks(cfg *singularityconf.File) error {
+	if len(cfg.OCIHooksDir) == 0 {
+		return nil
+	}
+	hooks, err := ocihooks.Load(cfg.OCIHooksDir...)
+	if err != nil {
+		return fmt.Errorf("while loading OCI hooks: %s", err)
+	}
+
+	spec := &e.EngineConfig.OciConfig.Spec
+	hasBindMounts := false
+	for _, m := range spec.Mounts {
+		if m.Type == "bind" {
+			hasBindMounts = true
+			break
+		}
+	}
+
+	matched := ocihooks.Matching(hooks, &ocihooks.Container{
+		Annotations:   spec.Annotations,
+		Command:       spec.Process.Args,
+		HasBindMounts: hasBindMounts,
+	})
+	ocihooks.Apply(matched, spec)
+	return nil
+}
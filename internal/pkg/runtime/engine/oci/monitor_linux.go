@@ -1,4 +1,4 @@
-// Copyright (c) 2018, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -9,6 +9,9 @@ import (
 	"fmt"
 	"os"
 	"syscall"
+
+	"github.com/hpcng/singularity/internal/pkg/runtime/engine"
+	"github.com/hpcng/singularity/pkg/sylog"
 )
 
 // MonitorContainer is called from master once the container has
@@ -24,27 +27,29 @@ import (
 // still will be executed as root since `singularity oci` command set requires
 // privileged execution.
 func (e *EngineOperations) MonitorContainer(pid int, signals chan os.Signal) (syscall.WaitStatus, error) {
-	var status syscall.WaitStatus
+	info, err := e.WaitContainer(pid, signals)
+	return info.Status, err
+}
+
+// WaitContainer is called from master once the container has been spawned,
+// it blocks until the container exits and returns the container process exit
+// information which is also kept to be recorded in the container state during
+// cleanup. Signals received are forwarded to the container process.
+func (e *EngineOperations) WaitContainer(pid int, signals chan os.Signal) (*engine.ExitInfo, error) {
+	info, err := engine.WaitProcess(pid, signals, func(s syscall.Signal) error {
+		if err := syscall.Kill(pid, s); err != nil {
+			return fmt.Errorf("interrupted by signal %s", s.String())
+		}
+		return nil
+	})
 
-	for {
-		s := <-signals
-		switch s {
-		case syscall.SIGCHLD:
-			if wpid, err := syscall.Wait4(pid, &status, syscall.WNOHANG, nil); err != nil {
-				return status, fmt.Errorf("error while waiting child: %s", err)
-			} else if wpid != pid {
-				continue
-			}
-			return status, nil
-		case syscall.SIGURG:
-			// Ignore SIGURG, which is used for non-cooperative goroutine
-			// preemption starting with Go 1.14. For more information, see
-			// https://github.com/golang/go/issues/24543.
-			break
-		default:
-			if err := syscall.Kill(pid, s.(syscall.Signal)); err != nil {
-				return status, fmt.Errorf("interrupted by signal %s", s.String())
-			}
+	if err == nil && e.EngineConfig.Cgroups != nil {
+		if n, err := e.EngineConfig.Cgroups.OOMKills(); err != nil {
+			sylog.Debugf("Could not read cgroup OOM kill events: %s", err)
+		} else {
+			info.OOMKilled = n > 0
 		}
 	}
+	e.exitInfo = info
+	return info, err
 }
@@ -0,0 +1,45 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oci
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockedFlags maps the statfs flags to the mount flags locked for
+// mounts inherited by a user namespace, a remount in a user namespace
+// must preserve them.
+var lockedFlags = []struct {
+	st uint64
+	ms uintptr
+}{
+	{unix.ST_NOSUID, unix.MS_NOSUID},
+	{unix.ST_NODEV, unix.MS_NODEV},
+	{unix.ST_NOEXEC, unix.MS_NOEXEC},
+	{unix.ST_NOATIME, unix.MS_NOATIME},
+	{unix.ST_NODIRATIME, unix.MS_NODIRATIME},
+	{unix.ST_RELATIME, unix.MS_RELATIME},
+}
+
+// lockedMountFlags returns the locked mount flags of the mount
+// containing path.
+func lockedMountFlags(path string) (uintptr, error) {
+	var st unix.Statfs_t
+
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, fmt.Errorf("while getting mount flags of %s: %s", path, err)
+	}
+
+	flags := uintptr(0)
+	for _, f := range lockedFlags {
+		if uint64(st.Flags)&f.st != 0 {
+			flags |= f.ms
+		}
+	}
+	return flags, nil
+}
@@ -10,20 +10,18 @@ import (
 	"os"
 
 	"github.com/containerd/cgroups"
+	"github.com/hpcng/singularity/internal/pkg/buildcfg"
 	"github.com/hpcng/singularity/internal/pkg/runtime/engine/config/starter"
+	"github.com/hpcng/singularity/internal/pkg/util/env"
+	"github.com/hpcng/singularity/internal/pkg/util/ptypool"
 	"github.com/hpcng/singularity/pkg/ociruntime"
 	"github.com/hpcng/singularity/pkg/sylog"
 	"github.com/hpcng/singularity/pkg/util/capabilities"
+	"github.com/hpcng/singularity/pkg/util/singularityconf"
 	"github.com/kr/pty"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 )
 
-// make master/slave as global variable to avoid GC close file descriptor
-var (
-	master *os.File
-	slave  *os.File
-)
-
 // PrepareConfig is called during stage1 to validate and prepare
 // container configuration. It is responsible for reading capabilities,
 // checking what namespaces are required, opening streams for attach and
@@ -57,6 +55,25 @@ func (e *EngineOperations) PrepareConfig(starterConfig *starter.Config) error {
 	// reset state config that could be passed to engine
 	e.EngineConfig.State = ociruntime.State{}
 
+	cfg, err := singularityconf.Parse(buildcfg.SINGULARITY_CONF_FILE)
+	if err != nil {
+		return fmt.Errorf("unable to parse singularity.conf file: %s", err)
+	}
+
+	// the environment variables policy set in singularity.conf overrides
+	// any policy passed to the engine
+	if err := env.CheckPolicy(cfg.EnvDeny, cfg.EnvAllow); err != nil {
+		return err
+	}
+	e.EngineConfig.EnvDeny = cfg.EnvDeny
+	e.EngineConfig.EnvAllow = cfg.EnvAllow
+
+	if !e.EngineConfig.Exec {
+		if err := e.prepareOCIHooks(cfg); err != nil {
+			return err
+		}
+	}
+
 	user := &e.EngineConfig.OciConfig.Process.User
 	gids := make([]int, 0, len(user.AdditionalGids)+1)
 
@@ -140,67 +157,20 @@ func (e *EngineOperations) PrepareConfig(starterConfig *starter.Config) error {
 	}
 
 	if !e.EngineConfig.Exec {
-		if e.EngineConfig.OciConfig.Process.Terminal {
-			var err error
-
-			master, slave, err = pty.Open()
-			if err != nil {
-				return err
-			}
-			consoleSize := e.EngineConfig.OciConfig.Process.ConsoleSize
-			if consoleSize != nil {
-				var size pty.Winsize
-
-				size.Cols = uint16(consoleSize.Width)
-				size.Rows = uint16(consoleSize.Height)
-				if err := pty.Setsize(slave, &size); err != nil {
-					return err
-				}
-			}
-			e.EngineConfig.MasterPts = int(master.Fd())
-			if err := starterConfig.KeepFileDescriptor(e.EngineConfig.MasterPts); err != nil {
-				return err
-			}
-			e.EngineConfig.SlavePts = int(slave.Fd())
-			if err := starterConfig.KeepFileDescriptor(e.EngineConfig.SlavePts); err != nil {
-				return err
-			}
-		} else {
-			r, w, err := os.Pipe()
-			if err != nil {
-				return err
-			}
-			e.EngineConfig.OutputStreams = [2]int{int(r.Fd()), int(w.Fd())}
-			if err := starterConfig.KeepFileDescriptor(e.EngineConfig.OutputStreams[0]); err != nil {
-				return err
-			}
-			if err := starterConfig.KeepFileDescriptor(e.EngineConfig.OutputStreams[1]); err != nil {
-				return err
-			}
-
-			r, w, err = os.Pipe()
-			if err != nil {
-				return err
-			}
-			e.EngineConfig.ErrorStreams = [2]int{int(r.Fd()), int(w.Fd())}
-			if err := starterConfig.KeepFileDescriptor(e.EngineConfig.ErrorStreams[0]); err != nil {
-				return err
-			}
-			if err := starterConfig.KeepFileDescriptor(e.EngineConfig.ErrorStreams[1]); err != nil {
-				return err
+		switch mode := e.EngineConfig.GetStdinMode(); mode {
+		case StdinPipe, StdinAttach:
+		case StdinClose, StdinFile:
+			// a terminal is useless without interactive input
+			if e.EngineConfig.OciConfig.Process.Terminal {
+				sylog.Verbosef("Disabling terminal allocation with stdin mode %s", mode)
+				e.EngineConfig.OciConfig.Process.Terminal = false
 			}
+		default:
+			return fmt.Errorf("unknown stdin mode %s", mode)
+		}
 
-			r, w, err = os.Pipe()
-			if err != nil {
-				return err
-			}
-			e.EngineConfig.InputStreams = [2]int{int(w.Fd()), int(r.Fd())}
-			if err := starterConfig.KeepFileDescriptor(e.EngineConfig.InputStreams[0]); err != nil {
-				return err
-			}
-			if err := starterConfig.KeepFileDescriptor(e.EngineConfig.InputStreams[1]); err != nil {
-				return err
-			}
+		if err := e.allocateStreams(starterConfig); err != nil {
+			return err
 		}
 	} else {
 		starterConfig.SetNamespaceJoinOnly(true)
@@ -252,3 +222,90 @@ func (e *EngineOperations) checkCapabilities() error {
 	}
 	return nil
 }
+
+// allocateStreams allocates the PTY pair or the pipes of the container
+// process streams, the stdin stream depending on the stdin mode. They are
+// all closed if one of them can't be allocated or kept open for the next
+// stages.
+func (e *EngineOperations) allocateStreams(starterConfig *starter.Config) (err error) {
+	e.streams = ptypool.New()
+	defer func() {
+		if err != nil {
+			e.streams.CloseAll()
+		}
+	}()
+
+	// keep returns the file descriptors of files kept open for the next stages
+	keep := func(files ...*os.File) ([2]int, error) {
+		fds := [2]int{-1, -1}
+		for i, f := range files {
+			fds[i] = int(f.Fd())
+			if err := starterConfig.KeepFileDescriptor(fds[i]); err != nil {
+				return fds, err
+			}
+		}
+		return fds, nil
+	}
+
+	if e.EngineConfig.OciConfig.Process.Terminal {
+		var size *pty.Winsize
+
+		if consoleSize := e.EngineConfig.OciConfig.Process.ConsoleSize; consoleSize != nil {
+			size = &pty.Winsize{
+				Cols: uint16(consoleSize.Width),
+				Rows: uint16(consoleSize.Height),
+			}
+		}
+		master, slave, err := e.streams.Open(size)
+		if err != nil {
+			return err
+		}
+		fds, err := keep(master, slave)
+		if err != nil {
+			return err
+		}
+		e.EngineConfig.MasterPts = fds[0]
+		e.EngineConfig.SlavePts = fds[1]
+		return nil
+	}
+
+	r, w, err := e.streams.Pipe()
+	if err != nil {
+		return err
+	}
+	if e.EngineConfig.OutputStreams, err = keep(r, w); err != nil {
+		return err
+	}
+
+	r, w, err = e.streams.Pipe()
+	if err != nil {
+		return err
+	}
+	if e.EngineConfig.ErrorStreams, err = keep(r, w); err != nil {
+		return err
+	}
+
+	switch e.EngineConfig.GetStdinMode() {
+	case StdinFile:
+		f, err := os.Open(e.EngineConfig.GetStdinPath())
+		if err != nil {
+			return fmt.Errorf("while opening stdin file: %s", err)
+		}
+		e.streams.Add(f)
+		fds, err := keep(f)
+		if err != nil {
+			return err
+		}
+		e.EngineConfig.InputStreams = [2]int{-1, fds[0]}
+	case StdinPipe, StdinAttach:
+		r, w, err := e.streams.Pipe()
+		if err != nil {
+			return err
+		}
+		if e.EngineConfig.InputStreams, err = keep(w, r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
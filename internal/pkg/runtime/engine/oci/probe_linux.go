@@ -0,0 +1,124 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/util/starter"
+	"github.com/hpcng/singularity/pkg/ociruntime"
+	"github.com/hpcng/singularity/pkg/runtime/engine/config"
+)
+
+const (
+	// defaultProbeTimeout is the time after which a probe command is
+	// killed if the probe doesn't set a timeout.
+	defaultProbeTimeout = 10 * time.Second
+	// maxProbeOutput is the maximum size of the probe output returned.
+	maxProbeOutput = 4096
+)
+
+// limitedBuffer is a buffer discarding data written beyond its limit.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if n := b.limit - b.Len(); n < len(p) {
+		if n > 0 {
+			b.Buffer.Write(p[:n])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// probeConfig returns a copy of the container configuration executing
+// the probe command args with the exec workflow, the probe process joins
+// the container like any exec process would, with the container process
+// capabilities, seccomp filter, no new privileges flag, cgroups and
+// namespaces.
+func (e *EngineOperations) probeConfig(args []string) (*config.Common, error) {
+	e.EngineConfig.Lock()
+	status := e.EngineConfig.State.Status
+	data, err := json.Marshal(e.CommonConfig)
+	e.EngineConfig.Unlock()
+
+	if err != nil {
+		return nil, fmt.Errorf("while copying container configuration: %s", err)
+	}
+	if status != ociruntime.Running {
+		return nil, fmt.Errorf("container is %s", status)
+	}
+
+	engineConfig := NewConfig()
+	commonConfig := &config.Common{EngineConfig: engineConfig}
+	if err := json.Unmarshal(data, commonConfig); err != nil {
+		return nil, fmt.Errorf("while copying container configuration: %s", err)
+	}
+
+	engineConfig.Exec = true
+	engineConfig.EmptyProcess = false
+	engineConfig.OciConfig.SetProcessArgs(args)
+	// the probe output is captured
+	engineConfig.OciConfig.Process.Terminal = false
+
+	return commonConfig, nil
+}
+
+// runProbe runs the probe command p in the container as an exec of the
+// container process and returns its result.
+func (e *EngineOperations) runProbe(p *ociruntime.Probe) *ociruntime.ProbeResult {
+	result := &ociruntime.ProbeResult{ExitCode: -1}
+
+	if len(p.Args) == 0 {
+		result.Error = "no probe command"
+		return result
+	}
+
+	commonConfig, err := e.probeConfig(p.Args)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	timeout := defaultProbeTimeout
+	if p.Timeout > 0 {
+		timeout = time.Duration(p.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	output := &limitedBuffer{limit: maxProbeOutput}
+	procName := fmt.Sprintf("Singularity OCI %s", e.CommonConfig.ContainerID)
+	err = starter.Run(
+		procName,
+		commonConfig,
+		starter.WithStdout(output),
+		starter.WithStderr(output),
+		starter.WithContext(ctx),
+	)
+	result.Output = output.String()
+
+	var exitErr *exec.ExitError
+	if ctx.Err() == context.DeadlineExceeded {
+		result.Error = fmt.Sprintf("probe timed out after %s", timeout)
+	} else if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+	} else if err != nil {
+		result.Error = err.Error()
+	} else {
+		result.ExitCode = 0
+	}
+	return result
+}
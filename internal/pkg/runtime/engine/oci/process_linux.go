@@ -13,22 +13,22 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
-	osexec "os/exec"
 	"os/signal"
 	"path/filepath"
 	"strconv"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 
 	"github.com/hpcng/singularity/internal/pkg/instance"
 	"github.com/hpcng/singularity/internal/pkg/security"
-	"github.com/hpcng/singularity/internal/pkg/util/exec"
+	"github.com/hpcng/singularity/internal/pkg/util/env"
+	"github.com/hpcng/singularity/internal/pkg/util/ptypool"
 	"github.com/hpcng/singularity/pkg/ociruntime"
 	"github.com/hpcng/singularity/pkg/sylog"
 	"github.com/hpcng/singularity/pkg/util/copy"
 	"github.com/hpcng/singularity/pkg/util/rlimit"
 	"github.com/hpcng/singularity/pkg/util/unix"
-	"github.com/kr/pty"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 )
 
@@ -38,7 +38,23 @@ import (
 // No additional privileges can be gained during this call (unless container
 // is executed as root intentionally) as starter will set uid/euid/suid
 // to the targetUID (PrepareConfig will set it by calling starter.Config.SetTargetUID).
-func (e *EngineOperations) StartProcess(masterConnFd int) error {
+func (e *EngineOperations) StartProcess(masterConnFd int) (err error) {
+	e.inheritStreams(
+		e.EngineConfig.MasterPts,
+		e.EngineConfig.SlavePts,
+		e.EngineConfig.OutputStreams[0],
+		e.EngineConfig.OutputStreams[1],
+		e.EngineConfig.ErrorStreams[0],
+		e.EngineConfig.ErrorStreams[1],
+		e.EngineConfig.InputStreams[0],
+		e.EngineConfig.InputStreams[1],
+	)
+	defer func() {
+		if err != nil {
+			e.streams.CloseAll()
+		}
+	}()
+
 	cwd := e.EngineConfig.OciConfig.Process.Cwd
 
 	if cwd == "" {
@@ -68,18 +84,23 @@ func (e *EngineOperations) StartProcess(masterConnFd int) error {
 		return e.emptyProcess(masterConn)
 	}
 
-	args := e.EngineConfig.OciConfig.Process.Args
-	env := e.EngineConfig.OciConfig.Process.Env
+	process := e.EngineConfig.OciConfig.Process
+	args := process.Args
+	if len(args) == 0 {
+		return fmt.Errorf("no process arguments provided")
+	}
 
-	for _, e := range e.EngineConfig.OciConfig.Process.Env {
-		if strings.HasPrefix(e, "PATH=") {
-			os.Setenv("PATH", e[5:])
-		}
+	// the environment is completed before looking up the binary
+	// with the process PATH
+	environ, err := env.ApplyPolicy(processEnv(process.Env, process.User.UID, process.Terminal), e.EngineConfig.EnvDeny, e.EngineConfig.EnvAllow)
+	if err != nil {
+		return err
 	}
+	path, _ := getenv(environ, "PATH")
 
-	bpath, err := osexec.LookPath(args[0])
+	bpath, err := lookPath(args[0], cwd, path)
 	if err != nil {
-		return fmt.Errorf("%s", err)
+		return err
 	}
 	args[0] = bpath
 
@@ -94,10 +115,7 @@ func (e *EngineOperations) StartProcess(masterConnFd int) error {
 		if err := syscall.Dup3(slaveFd, int(os.Stderr.Fd()), 0); err != nil {
 			return err
 		}
-		if err := syscall.Close(e.EngineConfig.MasterPts); err != nil {
-			return err
-		}
-		if err := syscall.Close(slaveFd); err != nil {
+		if err := e.streams.Close(e.EngineConfig.MasterPts, slaveFd); err != nil {
 			return err
 		}
 		if _, err := syscall.Setsid(); err != nil {
@@ -110,30 +128,37 @@ func (e *EngineOperations) StartProcess(masterConnFd int) error {
 		if err := syscall.Dup3(e.EngineConfig.OutputStreams[1], int(os.Stdout.Fd()), 0); err != nil {
 			return err
 		}
-		if err := syscall.Close(e.EngineConfig.OutputStreams[1]); err != nil {
-			return err
-		}
-		if err := syscall.Close(e.EngineConfig.OutputStreams[0]); err != nil {
+		if err := e.streams.Close(e.EngineConfig.OutputStreams[:]...); err != nil {
 			return err
 		}
 
 		if err := syscall.Dup3(e.EngineConfig.ErrorStreams[1], int(os.Stderr.Fd()), 0); err != nil {
 			return err
 		}
-		if err := syscall.Close(e.EngineConfig.ErrorStreams[1]); err != nil {
-			return err
-		}
-		if err := syscall.Close(e.EngineConfig.ErrorStreams[0]); err != nil {
+		if err := e.streams.Close(e.EngineConfig.ErrorStreams[:]...); err != nil {
 			return err
 		}
 
-		if err := syscall.Dup3(e.EngineConfig.InputStreams[1], int(os.Stdin.Fd()), 0); err != nil {
-			return err
-		}
-		if err := syscall.Close(e.EngineConfig.InputStreams[1]); err != nil {
-			return err
+		if e.EngineConfig.InputStreams[1] == -1 {
+			// stdin closed, /dev/null is read instead of leaving
+			// fd 0 free as the first file opened by the process
+			// would take it
+			fd, err := syscall.Open("/dev/null", syscall.O_RDONLY, 0)
+			if err != nil {
+				return fmt.Errorf("failed to open /dev/null: %s", err)
+			}
+			if err := syscall.Dup3(fd, int(os.Stdin.Fd()), 0); err != nil {
+				return err
+			}
+			if err := syscall.Close(fd); err != nil {
+				return err
+			}
+		} else {
+			if err := syscall.Dup3(e.EngineConfig.InputStreams[1], int(os.Stdin.Fd()), 0); err != nil {
+				return err
+			}
 		}
-		if err := syscall.Close(e.EngineConfig.InputStreams[0]); err != nil {
+		if err := e.streams.Close(e.EngineConfig.InputStreams[:]...); err != nil {
 			return err
 		}
 	}
@@ -143,10 +168,9 @@ func (e *EngineOperations) StartProcess(masterConnFd int) error {
 		return fmt.Errorf("failed to pause process: %s", err)
 	}
 	if !e.EngineConfig.Exec {
-		// block on read start given
-		data := make([]byte, 1)
-		if _, err := masterConn.Read(data); err != nil {
-			return fmt.Errorf("failed to receive start signal: %s", err)
+		// block until start given
+		if err := waitStartEvent(masterConn); err != nil {
+			return err
 		}
 	}
 
@@ -154,8 +178,17 @@ func (e *EngineOperations) StartProcess(masterConnFd int) error {
 		return fmt.Errorf("failed to apply security configuration: %s", err)
 	}
 
-	err = syscall.Exec(args[0], args, env)
-	return fmt.Errorf("exec %s failed: %s", args[0], err)
+	err = syscall.Exec(args[0], args, environ)
+	return execError(args[0], err)
+}
+
+// inheritStreams tracks the stream file descriptors fds inherited from
+// stage 1, so they are closed on failure.
+func (e *EngineOperations) inheritStreams(fds ...int) {
+	e.streams = ptypool.New()
+	for _, fd := range fds {
+		e.streams.Inherit(fd, fmt.Sprintf("stream-%d", fd))
+	}
 }
 
 // PreStartProcess is called from master after before container startup.
@@ -164,21 +197,49 @@ func (e *EngineOperations) StartProcess(masterConnFd int) error {
 // in suid flow. However, when a user namespace is requested and it is not
 // a hybrid workflow (e.g. fakeroot), then there is no privileged saved uid
 // and thus no additional privileges can be gained.
-func (e *EngineOperations) PreStartProcess(ctx context.Context, pid int, masterConn net.Conn, fatalChan chan error) error {
+func (e *EngineOperations) PreStartProcess(ctx context.Context, pid int, masterConn net.Conn, fatalChan chan error) (err error) {
 	if e.EngineConfig.Exec {
 		return nil
 	}
 
+	// slave sides were closed by CreateContainer
+	e.inheritStreams(
+		e.EngineConfig.MasterPts,
+		e.EngineConfig.OutputStreams[0],
+		e.EngineConfig.ErrorStreams[0],
+		e.EngineConfig.InputStreams[0],
+	)
+
+	// closers are released on failure until they are
+	// handed over to the control handler
+	var closers []func()
+	defer func() {
+		if err != nil {
+			for _, c := range closers {
+				c()
+			}
+			e.streams.CloseAll()
+		}
+	}()
+
 	file, err := instance.Get(e.CommonConfig.ContainerID, instance.OciSubDir)
 	if err != nil {
 		return err
 	}
 	e.EngineConfig.State.AttachSocket = filepath.Join(filepath.Dir(file.Path), "attach.sock")
+	e.EngineConfig.State.AttachProtocol = ociruntime.AttachProtocolVersion
 
 	attach, err := unix.CreateSocket(e.EngineConfig.State.AttachSocket)
 	if err != nil {
 		return err
 	}
+	closers = append(closers, func() { attach.Close() })
+
+	if mode := e.EngineConfig.GetAttachSocketMode(); mode != 0600 {
+		if err := os.Chmod(e.EngineConfig.State.AttachSocket, mode); err != nil {
+			return fmt.Errorf("while setting attach socket permissions: %s", err)
+		}
+	}
 
 	e.EngineConfig.State.ControlSocket = filepath.Join(filepath.Dir(file.Path), "control.sock")
 
@@ -186,27 +247,14 @@ func (e *EngineOperations) PreStartProcess(ctx context.Context, pid int, masterC
 	if err != nil {
 		return err
 	}
+	closers = append(closers, func() { control.Close() })
 
-	logPath := e.EngineConfig.GetLogPath()
-	if logPath == "" {
-		containerID := e.CommonConfig.ContainerID
-		dir, err := instance.GetDir(containerID, instance.OciSubDir)
-		if err != nil {
-			return err
-		}
-		logPath = filepath.Join(dir, containerID+".log")
-	}
-
-	format := e.EngineConfig.GetLogFormat()
-	formatter, ok := instance.LogFormats[format]
-	if !ok {
-		return fmt.Errorf("log format %s is not supported", format)
-	}
-
-	logger, err := instance.NewLogger(logPath, formatter)
+	logger, err := e.EngineConfig.NewLogger(e.CommonConfig.ContainerID)
 	if err != nil {
 		return err
 	}
+	e.logger = logger
+	closers = append(closers, logger.Close)
 
 	pidFile := e.EngineConfig.GetPidFile()
 	if pidFile != "" {
@@ -221,14 +269,17 @@ func (e *EngineOperations) PreStartProcess(ctx context.Context, pid int, masterC
 
 	start := make(chan bool, 1)
 
+	// exchange heartbeats with the container process until start
+	e.heartbeat = newHeartbeat(masterConn)
+	e.heartbeat.monitor("container process", fatalChan)
+
+	closers = nil
 	go e.handleControl(masterConn, attach, control, logger, start, fatalChan)
 
 	hooks := e.EngineConfig.OciConfig.Hooks
 	if hooks != nil {
-		for _, h := range hooks.Prestart {
-			if err := exec.Hook(ctx, &h, &e.EngineConfig.State.State); err != nil {
-				return err
-			}
+		if errs := e.EngineConfig.RunHooks(ctx, hooks.Prestart, logger, true); len(errs) > 0 {
+			return errs[0]
 		}
 	}
 
@@ -263,10 +314,8 @@ func (e *EngineOperations) PostStartProcess(ctx context.Context, pid int) error
 	}
 	hooks := e.EngineConfig.OciConfig.Hooks
 	if hooks != nil {
-		for _, h := range hooks.Poststart {
-			if err := exec.Hook(ctx, &h, &e.EngineConfig.State.State); err != nil {
-				sylog.Warningf("%s", err)
-			}
+		for _, err := range e.EngineConfig.RunHooks(ctx, hooks.Poststart, e.logger, false) {
+			sylog.Warningf("%s", err)
 		}
 	}
 	return nil
@@ -294,10 +343,9 @@ func (e *EngineOperations) emptyProcess(masterConn net.Conn) error {
 		return fmt.Errorf("failed to pause process: %s", err)
 	}
 
-	// block on read start given
-	data := make([]byte, 1)
-	if _, err := masterConn.Read(data); err != nil {
-		return fmt.Errorf("failed to receive ack from master: %s", err)
+	// block until start given
+	if err := waitStartEvent(masterConn); err != nil {
+		return err
 	}
 
 	var status syscall.WaitStatus
@@ -332,9 +380,13 @@ func (e *EngineOperations) handleStream(l net.Listener, logger *instance.Logger,
 	var outputWriters *copy.MultiWriter
 	var errorWriters *copy.MultiWriter
 	var inputWriters *copy.MultiWriter
-	var tbuf *copy.TerminalBuffer
+	var master *os.File
+	var replay func() []byte
 
 	hasTerminal := e.EngineConfig.OciConfig.Process.Terminal
+	attachStdin := e.EngineConfig.GetStdinMode() == StdinAttach
+
+	e.attached = newAttachClients()
 
 	inputWriters = &copy.MultiWriter{}
 	outputWriters = &copy.MultiWriter{}
@@ -342,19 +394,30 @@ func (e *EngineOperations) handleStream(l net.Listener, logger *instance.Logger,
 	outputWriters.Add(outWriter)
 
 	if hasTerminal {
-		stdout = os.NewFile(uintptr(e.EngineConfig.MasterPts), "stream-master-pts")
-		tbuf = copy.NewTerminalBuffer()
-		outputWriters.Add(tbuf)
+		master = e.streams.File(e.EngineConfig.MasterPts)
+		stdout = master
+		// replay the recent terminal output to attached clients,
+		// or the last line only if no console buffer is set
+		if size := e.EngineConfig.GetConsoleBuffer(); size > 0 {
+			rbuf := copy.NewRingBuffer(size)
+			outputWriters.Add(rbuf)
+			replay = rbuf.Bytes
+		} else {
+			tbuf := copy.NewTerminalBuffer()
+			outputWriters.Add(tbuf)
+			replay = tbuf.Line
+		}
 		inputWriters.Add(stdout)
 	} else {
-		outputStream := os.NewFile(uintptr(e.EngineConfig.OutputStreams[0]), "stdout-stream")
-		errorStream := os.NewFile(uintptr(e.EngineConfig.ErrorStreams[0]), "error-stream")
-		inputStream := os.NewFile(uintptr(e.EngineConfig.InputStreams[0]), "input-stream")
-		stdout = outputStream
-		stderr = errorStream
-		stdin = inputStream
+		stdout = e.streams.File(e.EngineConfig.OutputStreams[0])
+		stderr = e.streams.File(e.EngineConfig.ErrorStreams[0])
 		outputWriters.Add(os.Stdout)
-		inputWriters.Add(stdin)
+		// there is no input stream when stdin is closed or
+		// redirected from a file
+		if e.EngineConfig.InputStreams[0] != -1 {
+			stdin = e.streams.File(e.EngineConfig.InputStreams[0])
+			inputWriters.Add(stdin)
+		}
 	}
 
 	if stderr != nil {
@@ -365,6 +428,9 @@ func (e *EngineOperations) handleStream(l net.Listener, logger *instance.Logger,
 	}
 
 	go func() {
+		// set once the first client is authenticated
+		var attachedOnce int32
+
 		for {
 			c, err := l.Accept()
 			if err != nil {
@@ -372,39 +438,96 @@ func (e *EngineOperations) handleStream(l net.Listener, logger *instance.Logger,
 				return
 			}
 
-			go func() {
-				outputWriters.Add(c)
+			// clients are authenticated in their own goroutine to
+			// not block the other clients while waiting for the token
+			go func(c net.Conn) {
+				defer c.Close()
+
+				if err := e.authenticateAttach(c); err != nil {
+					sylog.Warningf("Rejecting attach connection: %s", err)
+					return
+				}
+
+				framer, input, probe, err := negotiateAttach(c)
+				if err != nil {
+					sylog.Warningf("Rejecting attach connection: %s", err)
+					return
+				}
+				// probe clients only receive the probe result and
+				// don't count as the first attached client
+				if probe != nil {
+					e.answerProbe(framer, probe)
+					return
+				}
+				first := atomic.CompareAndSwapInt32(&attachedOnce, 0, 1)
+
+				// raw clients receive the container output as is,
+				// framed clients receive stdout and stderr frames
+				var outWriter, errWriter io.Writer = c, c
+				if framer != nil {
+					outWriter = framer.Writer(ociruntime.StdoutFrame)
+					errWriter = framer.Writer(ociruntime.StderrFrame)
+				}
+				outputWriters.Add(outWriter)
 				if stderr != nil {
-					errorWriters.Add(c)
+					errorWriters.Add(errWriter)
+				}
+				if framer != nil {
+					e.attached.add(framer)
+					defer e.attached.del(framer)
 				}
 
-				if tbuf != nil {
-					c.Write(tbuf.Line())
+				if replay != nil {
+					outWriter.Write(replay())
 				}
 
-				io.Copy(inputWriters, c)
+				// only the first client feeds stdin in attach mode
+				var in io.Writer = inputWriters
+				if attachStdin && !first {
+					in = ioutil.Discard
+				}
+				if framer != nil {
+					readAttachFrames(c, in, master)
+				} else {
+					if len(input) > 0 {
+						in.Write(input)
+					}
+					io.Copy(in, c)
+				}
+				if attachStdin && first && stdin != nil {
+					stdin.Close()
+				}
 
-				outputWriters.Del(c)
+				outputWriters.Del(outWriter)
 				if stderr != nil {
-					errorWriters.Del(c)
+					errorWriters.Del(errWriter)
 				}
-				c.Close()
-			}()
+			}(c)
 		}
 	}()
 
+	var outputs sync.WaitGroup
+
+	outputs.Add(1)
 	go func() {
 		io.Copy(outputWriters, stdout)
 		stdout.Close()
+		outputs.Done()
 	}()
 
 	if stderr != nil {
+		outputs.Add(1)
 		go func() {
 			io.Copy(errorWriters, stderr)
 			stderr.Close()
+			outputs.Done()
 		}()
 	}
-	if stdin != nil {
+	go func() {
+		outputs.Wait()
+		close(e.attached.outputDone)
+	}()
+	if stdin != nil && !attachStdin {
 		go func() {
 			io.Copy(inputWriters, os.Stdin)
 			stdin.Close()
@@ -417,7 +540,7 @@ func (e *EngineOperations) handleControl(masterConn net.Conn, attach, control ne
 	started := false
 
 	if e.EngineConfig.OciConfig.Process.Terminal {
-		master = os.NewFile(uintptr(e.EngineConfig.MasterPts), "control-master-pts")
+		master = e.streams.File(e.EngineConfig.MasterPts)
 	}
 
 	for {
@@ -441,6 +564,7 @@ func (e *EngineOperations) handleControl(masterConn net.Conn, attach, control ne
 			// since container process block on read, send it an
 			// ACK so when it will receive data, the container
 			// process will be executed
+			e.heartbeat.Stop()
 			if _, err := masterConn.Write([]byte("s")); err != nil {
 				fatalChan <- fmt.Errorf("failed to send ACK to start process: %s", err)
 				return
@@ -453,11 +577,7 @@ func (e *EngineOperations) handleControl(masterConn net.Conn, attach, control ne
 			e.waitStatusUpdate()
 		}
 		if ctrl.ConsoleSize != nil && master != nil {
-			size := &pty.Winsize{
-				Cols: uint16(ctrl.ConsoleSize.Width),
-				Rows: uint16(ctrl.ConsoleSize.Height),
-			}
-			if err := pty.Setsize(master, size); err != nil {
+			if err := setConsoleSize(master, ctrl.ConsoleSize); err != nil {
 				fatalChan <- err
 				return
 			}
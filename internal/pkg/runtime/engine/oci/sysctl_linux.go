@@ -0,0 +1,99 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oci
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/util/namespaces"
+	"github.com/hpcng/singularity/pkg/util/sysctl"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// sysctlNamespaces maps the namespaces isolating sysctl keys to
+// their OCI spec type.
+var sysctlNamespaces = map[string]specs.LinuxNamespaceType{
+	"ipc": specs.IPCNamespace,
+	"net": specs.NetworkNamespace,
+	"uts": specs.UTSNamespace,
+}
+
+// privateNamespace returns whether the container has its own namespace
+// of type nstype, either created for it or joined and different from
+// the runtime namespace.
+func (c *container) privateNamespace(nstype string) (bool, error) {
+	for _, ns := range c.engine.EngineConfig.OciConfig.Linux.Namespaces {
+		if ns.Type != sysctlNamespaces[nstype] {
+			continue
+		}
+		if ns.Path == "" {
+			return true, nil
+		}
+		joined, err := os.Stat(ns.Path)
+		if err != nil {
+			return false, fmt.Errorf("while checking namespace %s: %s", ns.Path, err)
+		}
+		host, err := os.Stat(fmt.Sprintf("/proc/self/ns/%s", nstype))
+		if err != nil {
+			return false, fmt.Errorf("while checking %s namespace: %s", nstype, err)
+		}
+		return !os.SameFile(joined, host), nil
+	}
+	return false, nil
+}
+
+// setSysctl applies the spec sysctl keys in the container namespaces
+// of process pid. Keys must be isolated by a namespace owned by the
+// container, so setting them doesn't affect the host.
+func (c *container) setSysctl(pid int) error {
+	keys := c.engine.EngineConfig.OciConfig.Linux.Sysctl
+	if len(keys) == 0 {
+		return nil
+	}
+
+	enter := make(map[string]bool)
+	for key := range keys {
+		nstype, err := sysctl.Namespace(key)
+		if err != nil {
+			return err
+		}
+		private, err := c.privateNamespace(nstype)
+		if err != nil {
+			return err
+		} else if !private {
+			return fmt.Errorf("sysctl %s requires a %s namespace owned by the container", key, nstype)
+		}
+		enter[nstype] = true
+	}
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		// the thread entering the container namespaces is
+		// never unlocked, it exits with the goroutine
+		runtime.LockOSThread()
+
+		for nstype := range enter {
+			if err := namespaces.Enter(pid, nstype); err != nil {
+				errCh <- fmt.Errorf("while entering container %s namespace: %s", nstype, err)
+				return
+			}
+		}
+		for key, value := range keys {
+			sylog.Debugf("Setting sysctl %s to %s", key, value)
+			if err := sysctl.Set(key, value); err != nil {
+				errCh <- fmt.Errorf("while setting sysctl %s: %s", key, err)
+				return
+			}
+		}
+		errCh <- nil
+	}()
+
+	return <-errCh
+}
@@ -0,0 +1,233 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/runtime/engine"
+	"github.com/hpcng/singularity/internal/pkg/util/digest"
+	"github.com/hpcng/singularity/internal/pkg/util/user"
+	"github.com/hpcng/singularity/pkg/image"
+	"github.com/hpcng/singularity/pkg/sylog"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+const (
+	accountingStart = "start"
+	accountingStop  = "stop"
+)
+
+// accountingTimeout is the time after which the accounting
+// hook is killed.
+var accountingTimeout = 10 * time.Second
+
+// accountingStarted is closed once the start record has been sent,
+// the stop record is sent after it.
+var accountingStarted chan struct{}
+
+// accountingRecord is the JSON record passed on the standard input of
+// the accounting hook at container start and stop. Duration is expressed
+// in seconds.
+type accountingRecord struct {
+	Event       string   `json:"event"`
+	Time        string   `json:"time"`
+	User        string   `json:"user"`
+	UID         int      `json:"uid"`
+	Image       string   `json:"image"`
+	ImageDigest string   `json:"imageDigest,omitempty"`
+	Instance    string   `json:"instance,omitempty"`
+	Flags       []string `json:"flags"`
+	Command     []string `json:"command"`
+	Pid         int      `json:"pid"`
+	Duration    float64  `json:"duration,omitempty"`
+	ExitCode    *int     `json:"exitCode,omitempty"`
+	Signal      string   `json:"signal,omitempty"`
+	OOMKilled   bool     `json:"oomKilled,omitempty"`
+}
+
+// accountingHook returns the accounting hook configured in
+// singularity.conf or an empty string if accounting is disabled.
+func (e *EngineOperations) accountingHook() string {
+	if e.EngineConfig.File == nil || e.EngineConfig.GetInstanceJoin() {
+		return ""
+	}
+	reloadLock.RLock()
+	defer reloadLock.RUnlock()
+	return e.EngineConfig.File.AccountingHook
+}
+
+// newAccountingRecord returns the accounting record of the container
+// process pid for event.
+func (e *EngineOperations) newAccountingRecord(event string, pid int) *accountingRecord {
+	r := &accountingRecord{
+		Event:   event,
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		UID:     os.Getuid(),
+		Image:   e.EngineConfig.GetImage(),
+		Flags:   e.EngineConfig.GetCLIFlags(),
+		Command: e.EngineConfig.OciConfig.Process.Args,
+		Pid:     pid,
+	}
+	if pw, err := user.GetPwUID(uint32(r.UID)); err == nil {
+		r.User = pw.Name
+	}
+	if e.EngineConfig.GetInstance() {
+		r.Instance = e.CommonConfig.ContainerID
+	}
+	return r
+}
+
+// sendAccountingStart sends the start record of the container process
+// pid to the accounting hook in the background, the image digest may
+// take a while to compute for large images.
+func (e *EngineOperations) sendAccountingStart(pid int) {
+	hook := e.accountingHook()
+	if hook == "" {
+		return
+	}
+	r := e.newAccountingRecord(accountingStart, pid)
+
+	accountingStarted = make(chan struct{})
+	go func() {
+		defer close(accountingStarted)
+
+		reloadLock.RLock()
+		withDigest := e.EngineConfig.File.AccountingImageDigest
+		reloadLock.RUnlock()
+
+		if withDigest {
+			if images := e.EngineConfig.GetImageList(); len(images) > 0 {
+				digest, err := imageDigest(&images[0], e.digestAlgorithm())
+				if err != nil {
+					sylog.Debugf("Could not compute image digest: %s", err)
+				}
+				r.ImageDigest = digest
+			}
+		}
+		runAccountingHook(hook, r)
+	}()
+}
+
+// sendAccountingStop sends the stop record of the container process
+// described by info to the accounting hook, once the start record
+// has been sent.
+func (e *EngineOperations) sendAccountingStop(info *engine.ExitInfo) {
+	hook := e.accountingHook()
+	if hook == "" {
+		return
+	}
+	if accountingStarted != nil {
+		<-accountingStarted
+	}
+
+	r := e.newAccountingRecord(accountingStop, info.Pid)
+	exitCode := info.ExitCode()
+	r.ExitCode = &exitCode
+	r.Duration = info.WallTime.Seconds()
+	r.OOMKilled = info.OOMKilled
+	if s := info.Signal(); s != 0 {
+		r.Signal = s.String()
+	}
+	runAccountingHook(hook, r)
+}
+
+// digestAlgorithm returns the digest algorithm of the image digests
+// selected in the configuration.
+func (e *EngineOperations) digestAlgorithm() godigest.Algorithm {
+	reloadLock.RLock()
+	name := e.EngineConfig.File.DigestAlgorithm
+	reloadLock.RUnlock()
+
+	a, err := digest.Algorithm(name)
+	if err != nil {
+		sylog.Warningf("Using %s digests: %s", digest.Default, err)
+		return digest.Default
+	}
+	return a
+}
+
+// imageDigest returns the digest computed with the algorithm a of the
+// image file, directory images have no digest.
+func imageDigest(img *image.Image, a godigest.Algorithm) (string, error) {
+	if img.Type == image.SANDBOX {
+		return "", nil
+	}
+	f := img.File
+	if f == nil {
+		var err error
+		if f, err = os.Open(img.Path); err != nil {
+			return "", fmt.Errorf("while opening image %s: %s", img.Path, err)
+		}
+		defer f.Close()
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("while getting image %s information: %s", img.Path, err)
+	}
+
+	// the image file descriptor may be shared, don't move its offset
+	d, err := a.FromReader(io.NewSectionReader(f, 0, fi.Size()))
+	if err != nil {
+		return "", fmt.Errorf("while reading image %s: %s", img.Path, err)
+	}
+	return d.String(), nil
+}
+
+// runAccountingHook executes the accounting hook with the record r passed
+// on its standard input. Hook failures are reported but never affect the
+// container.
+func runAccountingHook(hook string, r *accountingRecord) {
+	if !filepath.IsAbs(hook) {
+		sylog.Warningf("Ignoring accounting hook %s: not an absolute path", hook)
+		return
+	}
+	fi, err := os.Stat(hook)
+	if err != nil {
+		sylog.Warningf("Ignoring accounting hook: %s", err)
+		return
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); !ok || st.Uid != 0 || fi.Mode()&022 != 0 {
+		sylog.Warningf("Ignoring accounting hook %s: not owned by root or writable by group or others", hook)
+		return
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		sylog.Warningf("Could not encode accounting record: %s", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), accountingTimeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, hook)
+	cmd.Stdin = bytes.NewReader(append(data, '\n'))
+	cmd.Stderr = &stderr
+	cmd.Env = []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"}
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", accountingTimeout)
+		}
+		sylog.Warningf("Accounting hook %s failed for %s event: %s", hook, r.Event, err)
+		if stderr.Len() > 0 {
+			sylog.Debugf("Accounting hook error output: %s", stderr.String())
+		}
+		return
+	}
+	sylog.Debugf("Sent %s accounting record to %s", r.Event, hook)
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/runtime/engine"
+	"github.com/hpcng/singularity/internal/pkg/runtime/engine/config/oci"
+	"github.com/hpcng/singularity/internal/pkg/runtime/engine/config/oci/generate"
+	singularityConfig "github.com/hpcng/singularity/pkg/runtime/engine/singularity/config"
+	"github.com/hpcng/singularity/pkg/util/singularityconf"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// writeHook writes an accounting hook script with body in dir.
+func writeHook(t *testing.T, dir, name, body string, mode os.FileMode) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), mode); err != nil {
+		t.Fatalf("failed to write hook: %s", err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		t.Fatalf("failed to change hook mode: %s", err)
+	}
+	return path
+}
+
+// readRecords returns the accounting records written by the hooks in path.
+func readRecords(t *testing.T, path string) []accountingRecord {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open records: %s", err)
+	}
+	defer f.Close()
+
+	var records []accountingRecord
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var r accountingRecord
+		if err := dec.Decode(&r); err != nil {
+			t.Fatalf("failed to decode record: %s", err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestRunAccountingHook(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("accounting hooks must be owned by root")
+	}
+
+	dir, err := ioutil.TempDir("", "accounting-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatalf("failed to change directory mode: %s", err)
+	}
+
+	defer func(timeout time.Duration) { accountingTimeout = timeout }(accountingTimeout)
+	accountingTimeout = time.Second
+
+	out := filepath.Join(dir, "records")
+	r := &accountingRecord{Event: accountingStart, Image: "test.sif", Pid: 1}
+
+	tests := []struct {
+		name  string
+		hook  string
+		wants bool
+	}{
+		{
+			name:  "hook",
+			hook:  writeHook(t, dir, "hook", "cat >> "+out, 0755),
+			wants: true,
+		},
+		{
+			name: "relative hook",
+			hook: "hook",
+		},
+		{
+			name: "missing hook",
+			hook: filepath.Join(dir, "missing"),
+		},
+		{
+			name: "writable hook",
+			hook: writeHook(t, dir, "writable", "cat >> "+out, 0777),
+		},
+		{
+			name:  "failing hook",
+			hook:  writeHook(t, dir, "failing", "cat >> "+out+"; echo failure >&2; exit 1", 0755),
+			wants: true,
+		},
+		{
+			name: "hanging hook",
+			hook: writeHook(t, dir, "hanging", "exec sleep 60", 0755),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(out)
+
+			start := time.Now()
+			runAccountingHook(tt.hook, r)
+			if d := time.Since(start); d > 5*accountingTimeout {
+				t.Errorf("hook not killed after %s", d)
+			}
+
+			if !tt.wants {
+				if _, err := os.Stat(out); err == nil {
+					t.Errorf("hook executed")
+				}
+				return
+			}
+			records := readRecords(t, out)
+			if len(records) != 1 || !reflect.DeepEqual(&records[0], r) {
+				t.Errorf("got records %+v instead of %+v", records, r)
+			}
+		})
+	}
+}
+
+func TestSendAccounting(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("accounting hooks must be owned by root")
+	}
+
+	dir, err := ioutil.TempDir("", "accounting-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "records")
+	hook := writeHook(t, dir, "hook", "cat >> "+out, 0755)
+
+	e := &EngineOperations{EngineConfig: singularityConfig.NewConfig()}
+	e.EngineConfig.File = &singularityconf.File{AccountingHook: hook}
+	e.EngineConfig.OciConfig = &oci.Config{}
+	e.EngineConfig.OciConfig.Generator = *generate.New(&e.EngineConfig.OciConfig.Spec)
+	e.EngineConfig.OciConfig.Process = &specs.Process{Args: []string{"/bin/true"}}
+	e.EngineConfig.SetImage("test.sif")
+
+	e.sendAccountingStart(42)
+	e.sendAccountingStop(&engine.ExitInfo{
+		Pid:      42,
+		Status:   syscall.WaitStatus(uint32(syscall.SIGKILL)),
+		WallTime: 2 * time.Second,
+	})
+
+	records := readRecords(t, out)
+	if len(records) != 2 {
+		t.Fatalf("got %d records instead of 2", len(records))
+	}
+	for _, r := range records {
+		if r.Pid != 42 || r.Image != "test.sif" || !reflect.DeepEqual(r.Command, []string{"/bin/true"}) {
+			t.Errorf("unexpected %s record %+v", r.Event, r)
+		}
+	}
+
+	start, stop := records[0], records[1]
+	if start.Event != accountingStart || start.ExitCode != nil || start.Duration != 0 {
+		t.Errorf("unexpected start record %+v", start)
+	}
+	if stop.Event != accountingStop || stop.ExitCode == nil || *stop.ExitCode != 128+int(syscall.SIGKILL) {
+		t.Errorf("unexpected stop record %+v", stop)
+	}
+	if stop.Signal != syscall.SIGKILL.String() || stop.Duration != 2 {
+		t.Errorf("unexpected stop record %+v", stop)
+	}
+
+	// instance joins are not accounted
+	os.Remove(out)
+	e.EngineConfig.SetInstanceJoin(true)
+	e.sendAccountingStart(42)
+	e.sendAccountingStop(&engine.ExitInfo{Pid: 42})
+	if _, err := os.Stat(out); err == nil {
+		t.Errorf("instance join accounted")
+	}
+}
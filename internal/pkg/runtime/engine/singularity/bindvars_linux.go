@@ -0,0 +1,77 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/hpcng/singularity/internal/pkg/util/user"
+	singularityConfig "github.com/hpcng/singularity/pkg/runtime/engine/singularity/config"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+// bindVars returns the variables expanded in bind paths, the user
+// identity variables are determined from the password database and
+// the host environment variables are the ones passed by the caller.
+func (e *EngineOperations) bindVars() singularityConfig.BindVars {
+	vars := singularityConfig.BindVars{
+		Identity: make(map[string]string),
+		Env:      e.EngineConfig.GetBindEnv(),
+	}
+
+	pw, err := user.CurrentOriginal()
+	if err != nil {
+		sylog.Warningf("Could not determine user identity for bind paths: %s", err)
+		return vars
+	}
+	vars.Identity["USER"] = pw.Name
+	vars.Identity["UID"] = strconv.Itoa(int(pw.UID))
+	vars.Identity["GID"] = strconv.Itoa(int(pw.GID))
+	vars.Identity["HOME"] = pw.Dir
+
+	return vars
+}
+
+// configBindPaths returns the bind paths of singularity.conf with
+// their variables expanded, bind paths referencing a variable not set
+// or with a value which is not a single path component are skipped.
+func (e *EngineOperations) configBindPaths() []string {
+	vars := e.bindVars()
+	paths := make([]string, 0, len(e.EngineConfig.File.BindPath))
+
+	for _, bindpath := range e.EngineConfig.File.BindPath {
+		expanded, err := vars.Expand(bindpath, true)
+		if err != nil {
+			sylog.Verbosef("Skipping 'bind path' = %s: %s", bindpath, err)
+			continue
+		}
+		paths = append(paths, expanded)
+	}
+	return paths
+}
+
+// expandUserBindPaths expands the variables of the user bind paths.
+func (e *EngineOperations) expandUserBindPaths() error {
+	vars := e.bindVars()
+	binds := e.EngineConfig.GetBindPath()
+
+	for i, b := range binds {
+		for _, path := range []*string{&binds[i].Source, &binds[i].Destination} {
+			expanded, err := vars.Expand(*path, false)
+			if err != nil {
+				return fmt.Errorf("while expanding bind path %s: %s", b.Source, err)
+			}
+			if expanded != *path {
+				*path = filepath.Clean(expanded)
+			}
+		}
+	}
+	e.EngineConfig.SetBindPath(binds)
+
+	return nil
+}
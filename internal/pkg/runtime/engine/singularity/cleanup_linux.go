@@ -16,12 +16,15 @@ import (
 
 	"github.com/hpcng/singularity/internal/pkg/instance"
 	fakerootConfig "github.com/hpcng/singularity/internal/pkg/runtime/engine/fakeroot/config"
+	"github.com/hpcng/singularity/internal/pkg/util/bin"
 	"github.com/hpcng/singularity/internal/pkg/util/priv"
 	"github.com/hpcng/singularity/internal/pkg/util/starter"
 	"github.com/hpcng/singularity/pkg/runtime/engine/config"
 	"github.com/hpcng/singularity/pkg/sylog"
 	"github.com/hpcng/singularity/pkg/util/capabilities"
 	"github.com/hpcng/singularity/pkg/util/crypt"
+	"github.com/hpcng/singularity/pkg/util/verity"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
 )
 
 // CleanupContainer is called from master after the MonitorContainer returns.
@@ -41,6 +44,14 @@ func (e *EngineOperations) CleanupContainer(ctx context.Context, fatal error, st
 	// fakeroot workflow
 	e.stopFuseDrivers()
 
+	e.removeRunFiles()
+
+	if hooks := e.EngineConfig.OciConfig.Hooks; hooks != nil {
+		for _, err := range e.runOCIHooks(ctx, hooks.Poststop, specs.StateStopped, false) {
+			sylog.Warningf("Poststop OCI hook failed: %s", err)
+		}
+	}
+
 	if imageDriver != nil {
 		if err := umount(); err != nil {
 			sylog.Errorf("%s", err)
@@ -88,6 +99,14 @@ func (e *EngineOperations) CleanupContainer(ctx context.Context, fatal error, st
 		}
 	}
 
+	if quotaReservation != nil {
+		priv.Escalate()
+		if err := quotaReservation.Release(); err != nil {
+			sylog.Errorf("could not release quota reservation: %s", err)
+		}
+		priv.Drop()
+	}
+
 	if cgroupManager != nil {
 		if err := cgroupManager.Remove(); err != nil {
 			sylog.Errorf("could not remove cgroups: %v", err)
@@ -100,11 +119,28 @@ func (e *EngineOperations) CleanupContainer(ctx context.Context, fatal error, st
 		}
 	}
 
+	if verityDev != "" && imageDriver == nil {
+		if err := cleanupVerity(verityDev); err != nil {
+			sylog.Errorf("could not cleanup dm-verity device: %v", err)
+		}
+	}
+
+	// all resources were released, nothing
+	// to recover for this container
+	closeJournal()
+
 	if e.EngineConfig.GetInstance() {
-		file, err := instance.Get(e.CommonConfig.ContainerID, instance.SingSubDir)
+		getInstance := instance.Get
+		if e.EngineConfig.GetSystemInstance() {
+			getInstance = instance.GetSystem
+		}
+		file, err := getInstance(e.CommonConfig.ContainerID, instance.SingSubDir)
 		if err != nil {
 			return err
 		}
+		if file.StopReason != "" {
+			sylog.Infof("Instance %s stopped: %s", file.Name, file.StopReason)
+		}
 		return file.Delete()
 	}
 
@@ -169,6 +205,23 @@ func cleanupCrypt(path string) error {
 	return nil
 }
 
+func cleanupVerity(path string) error {
+	if err := umount(); err != nil {
+		return err
+	}
+
+	dmsetup, err := bin.Dmsetup()
+	if err != nil {
+		return err
+	}
+
+	devName := filepath.Base(path)
+	if err := verity.Close(dmsetup, devName); err != nil {
+		return fmt.Errorf("unable to delete dm-verity device %s: %s", devName, err)
+	}
+	return nil
+}
+
 func fakerootCleanup(path string) error {
 	command := []string{"/bin/rm", "-rf", path}
 
@@ -6,19 +6,28 @@
 package singularity
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/hpcng/sif/pkg/integrity"
+	"github.com/hpcng/sif/pkg/sif"
 	"github.com/hpcng/singularity/internal/pkg/buildcfg"
 	"github.com/hpcng/singularity/internal/pkg/cgroups"
+	"github.com/hpcng/singularity/internal/pkg/journal"
 	"github.com/hpcng/singularity/internal/pkg/plugin"
+	"github.com/hpcng/singularity/internal/pkg/quota"
 	"github.com/hpcng/singularity/internal/pkg/runtime/engine/singularity/rpc/client"
+	"github.com/hpcng/singularity/internal/pkg/security/ima"
 	"github.com/hpcng/singularity/internal/pkg/util/fs"
 	"github.com/hpcng/singularity/internal/pkg/util/fs/files"
 	"github.com/hpcng/singularity/internal/pkg/util/fs/layout"
@@ -30,16 +39,19 @@ import (
 	"github.com/hpcng/singularity/internal/pkg/util/priv"
 	"github.com/hpcng/singularity/internal/pkg/util/user"
 	"github.com/hpcng/singularity/pkg/image"
+	"github.com/hpcng/singularity/pkg/inspect"
 	"github.com/hpcng/singularity/pkg/network"
 	singularitycallback "github.com/hpcng/singularity/pkg/plugin/callback/runtime/engine/singularity"
 	singularity "github.com/hpcng/singularity/pkg/runtime/engine/singularity/config"
 	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/sypgp"
 	"github.com/hpcng/singularity/pkg/util/fs/proc"
 	"github.com/hpcng/singularity/pkg/util/gpu"
 	"github.com/hpcng/singularity/pkg/util/loop"
 	"github.com/hpcng/singularity/pkg/util/namespaces"
 	"github.com/hpcng/singularity/pkg/util/singularityconf"
 	"github.com/hpcng/singularity/pkg/util/slice"
+	"github.com/hpcng/singularity/pkg/util/verity"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"golang.org/x/crypto/ssh/terminal"
 	"golang.org/x/sys/unix"
@@ -50,14 +62,20 @@ import (
 // - cleanup
 // - post start process
 var cryptDev string
+var verityDev string
 var networkSetup *network.Setup
 var cgroupManager *cgroups.Manager
+var quotaReservation *quota.Reservation
+var resourceJournal *journal.Journal
 var imageDriver image.Driver
 var umountPoints []string
 
 // defaultCNIConfPath is the default directory to CNI network configuration files.
 var defaultCNIConfPath = filepath.Join(buildcfg.SYSCONFDIR, "singularity", "network")
 
+// sessionDir is the directory where the container session is mounted.
+var sessionDir = buildcfg.SESSIONDIR
+
 // defaultCNIPluginPath is the default directory to CNI plugins executables.
 var defaultCNIPluginPath = filepath.Join(buildcfg.LIBEXECDIR, "singularity", "cni")
 
@@ -82,6 +100,62 @@ type container struct {
 	skippedMount  []string
 	suidFlag      uintptr
 	devSourcePath string
+	rootfsVerity  *rootfsVerity
+	// dryRun is set when the mount points are only registered
+	// and reported, the hooks run without side effects
+	dryRun bool
+}
+
+// reserveQuota registers the container in the per user quota directory
+// when per user limits are set in singularity.conf, it returns an error
+// if the container would exceed one of the limits.
+func (c *container) reserveQuota() error {
+	file := c.engine.EngineConfig.File
+	limits := quota.Limits{
+		Containers: int(file.MaxContainersPerUser),
+		Instances:  int(file.MaxInstancesPerUser),
+		Memory:     int64(file.MaxMemoryPerUser) << 20,
+	}
+	if limits == (quota.Limits{}) || os.Getuid() == 0 {
+		return nil
+	}
+
+	entry := quota.Entry{Instance: c.engine.EngineConfig.GetInstance()}
+	if path := c.engine.EngineConfig.GetCgroupsPath(); path != "" {
+		spec, err := cgroups.ReadSpecFromFile(path)
+		if err != nil {
+			return fmt.Errorf("while reading cgroups file %s: %s", path, err)
+		}
+		if spec.Memory != nil && spec.Memory.Limit != nil && *spec.Memory.Limit > 0 {
+			entry.Memory = *spec.Memory.Limit
+		}
+	}
+
+	// quota directory is only writable by root, limits can't
+	// be enforced without the setuid workflow
+	if err := priv.Escalate(); err != nil {
+		priv.Drop()
+		sylog.Debugf("Per user limits not enforced without setuid workflow")
+		return nil
+	}
+	defer priv.Drop()
+
+	r, err := quota.Reserve(buildcfg.QUOTADIR, os.Getuid(), os.Getpid(), entry, limits)
+	if err != nil {
+		return fmt.Errorf("could not start container: %s", err)
+	}
+	quotaReservation = r
+
+	return nil
+}
+
+// rootfsVerity holds the dm-verity hash tree location and parameters
+// of the root filesystem partition.
+type rootfsVerity struct {
+	source string
+	offset uint64
+	hash   image.Section
+	params *verity.Params
 }
 
 func create(ctx context.Context, engine *EngineOperations, rpcOps *client.RPC, pid int) error {
@@ -91,19 +165,108 @@ func create(ctx context.Context, engine *EngineOperations, rpcOps *client.RPC, p
 		return fmt.Errorf("no root filesystem image provided")
 	}
 
-	configurationFile := buildcfg.SINGULARITY_CONF_FILE
-	if buildcfg.SINGULARITY_SUID_INSTALL == 0 || os.Geteuid() == 0 {
-		configFile := engine.EngineConfig.GetConfigurationFile()
-		if configFile != "" {
-			configurationFile = configFile
-		}
-	}
+	configurationFile := engine.configurationFile()
 
 	engine.EngineConfig.File, err = singularityconf.Parse(configurationFile)
 	if err != nil {
 		return fmt.Errorf("unable to parse singularity.conf file: %s", err)
 	}
 
+	c := newContainer(engine, rpcOps, pid)
+
+	cwd := engine.EngineConfig.GetCwd()
+	if err := os.Chdir(cwd); err != nil {
+		return fmt.Errorf("can't change directory to %s: %s", cwd, err)
+	}
+
+	if err := c.reserveQuota(); err != nil {
+		return err
+	}
+
+	if err := c.openJournal(); err != nil {
+		return fmt.Errorf("while opening resource journal: %s", err)
+	}
+	for _, path := range []string{
+		engine.EngineConfig.GetDeleteTempDir(),
+		engine.EngineConfig.GetPidFile(),
+		engine.EngineConfig.GetInfoFile(),
+	} {
+		if path != "" {
+			record(journal.File, path, nil)
+		}
+	}
+
+	p := &mount.Points{}
+	system := &mount.System{Points: p, Mount: c.mount}
+
+	usernsFd, err := c.addMounts(system)
+	if err != nil {
+		return err
+	}
+
+	networkSetup, err := c.prepareNetworkSetup(system, pid)
+	if err != nil {
+		return err
+	}
+
+	sylog.Debugf("Mount all")
+	if err := system.MountAll(); err != nil {
+		return err
+	}
+
+	// chroot from RPC server current working directory since
+	// it's already in final directory after chdirFinal call
+	sylog.Debugf("Chroot into %s\n", c.session.FinalPath())
+	_, err = c.rpcOps.Chroot(".", "pivot")
+	if err != nil {
+		sylog.Debugf("Fallback to move/chroot")
+		_, err = c.rpcOps.Chroot(".", "move")
+		if err != nil {
+			return fmt.Errorf("chroot failed: %s", err)
+		}
+	}
+
+	if networkSetup != nil {
+		if err := networkSetup(ctx); err != nil {
+			return err
+		}
+	}
+
+	if path := engine.EngineConfig.GetCgroupsPath(); path != "" {
+		cgroupPath := filepath.Join("/singularity", strconv.Itoa(pid))
+		if uid := os.Getuid(); uid != 0 {
+			// unprivileged users can only create cgroups in the
+			// cgroups v2 hierarchy delegated to them, the effective
+			// user ID is root with the setuid workflow
+			delegated, err := cgroups.DelegatedPath(uint32(uid))
+			if err != nil {
+				return fmt.Errorf("failed to apply cgroups resources restriction: %s", err)
+			}
+			cgroupPath = filepath.Join(delegated, "singularity-"+strconv.Itoa(pid))
+		}
+		cgroupManager = &cgroups.Manager{Pid: pid, Path: cgroupPath}
+		record(journal.Cgroup, cgroupPath, nil)
+		if err := cgroupManager.ApplyFromFile(path); err != nil {
+			return fmt.Errorf("failed to apply cgroups resources restriction: %s", err)
+		}
+	}
+
+	sylog.Debugf("Chdir into / to avoid errors\n")
+	err = syscall.Chdir("/")
+	if err != nil {
+		return fmt.Errorf("change directory failed: %s", err)
+	}
+
+	if err := engine.runFuseDrivers(false, usernsFd); err != nil {
+		return fmt.Errorf("while running FUSE drivers: %s", err)
+	}
+
+	return nil
+}
+
+// newContainer returns the container set up for engine with the RPC
+// server operations rpcOps, pid is the container process.
+func newContainer(engine *EngineOperations, rpcOps *client.RPC, pid int) *container {
 	c := &container{
 		engine:        engine,
 		rpcOps:        rpcOps,
@@ -113,11 +276,6 @@ func create(ctx context.Context, engine *EngineOperations, rpcOps *client.RPC, p
 		suidFlag:      syscall.MS_NOSUID,
 	}
 
-	cwd := engine.EngineConfig.GetCwd()
-	if err := os.Chdir(cwd); err != nil {
-		return fmt.Errorf("can't change directory to %s: %s", cwd, err)
-	}
-
 	if engine.EngineConfig.OciConfig.Linux != nil {
 		for _, namespace := range engine.EngineConfig.OciConfig.Linux.Namespaces {
 			switch namespace.Type {
@@ -149,33 +307,38 @@ func create(ctx context.Context, engine *EngineOperations, rpcOps *client.RPC, p
 		c.userNS, _ = namespaces.IsInsideUserNamespace(os.Getpid())
 	}
 
+	return c
+}
+
+// addMounts registers in system the mount points of the container and the
+// hook functions run while mounting them, it returns the file descriptor
+// of the container user namespace used by the FUSE mounts, if any. With a
+// dry run, the mount points are registered without side effects.
+func (c *container) addMounts(system *mount.System) (int, error) {
 	// load image driver plugins
 	callbackType := (singularitycallback.RegisterImageDriver)(nil)
 	callbacks, err := plugin.LoadCallbacks(callbackType)
 	if err != nil {
-		return fmt.Errorf("while loading plugins callbacks '%T': %s", callbackType, err)
+		return -1, fmt.Errorf("while loading plugins callbacks '%T': %s", callbackType, err)
 	}
 	for _, callback := range callbacks {
 		if err := callback.(singularitycallback.RegisterImageDriver)(c.userNS); err != nil {
-			return fmt.Errorf("while registering image driver: %s", err)
+			return -1, fmt.Errorf("while registering image driver: %s", err)
 		}
 	}
 
 	driverName := c.engine.EngineConfig.File.ImageDriver
 	imageDriver = image.GetDriver(driverName)
 	if driverName != "" && imageDriver == nil {
-		return fmt.Errorf("%q: no such image driver", driverName)
+		return -1, fmt.Errorf("%q: no such image driver", driverName)
 	}
 
-	p := &mount.Points{}
-	system := &mount.System{Points: p, Mount: c.mount}
-
 	if err := c.setupSessionLayout(system); err != nil {
-		return err
+		return -1, err
 	}
 
 	if err := c.setupImageDriver(system); err != nil {
-		return err
+		return -1, err
 	}
 
 	umountPoints = append(umountPoints, c.session.RootFsPath())
@@ -184,119 +347,81 @@ func create(ctx context.Context, engine *EngineOperations, rpcOps *client.RPC, p
 		umountPoints = append(umountPoints, c.session.FinalPath())
 	}
 
-	if err := system.RunAfterTag(mount.SessionTag, c.addMountInfo); err != nil {
-		return err
+	if !c.dryRun {
+		if err := system.RunAfterTag(mount.SessionTag, c.addMountInfo); err != nil {
+			return -1, err
+		}
 	}
 	if err := system.RunBeforeTag(mount.CwdTag, c.addCwdMount); err != nil {
-		return err
+		return -1, err
 	}
 	if err := system.RunAfterTag(mount.SharedTag, c.addIdentityMount); err != nil {
-		return err
+		return -1, err
 	}
 	// this call must occur just after all container layers are mounted
 	// to prevent user binds to screw up session final directory and
 	// consequently chroot
-	if err := system.RunAfterTag(mount.SharedTag, c.chdirFinal); err != nil {
-		return err
+	if !c.dryRun {
+		if err := system.RunAfterTag(mount.SharedTag, c.chdirFinal); err != nil {
+			return -1, err
+		}
 	}
 
 	if err := c.addRootfsMount(system); err != nil {
-		return err
+		return -1, err
 	}
 	if err := c.addImageBindMount(system); err != nil {
-		return err
+		return -1, err
 	}
 	if err := c.addKernelMount(system); err != nil {
-		return err
+		return -1, err
 	}
 	if err := c.addDevMount(system); err != nil {
-		return err
+		return -1, err
 	}
 	if err := c.addHostMount(system); err != nil {
-		return err
+		return -1, err
 	}
 	if err := c.addBindsMount(system); err != nil {
-		return err
+		return -1, err
+	}
+	if err := c.addHookMounts(system); err != nil {
+		return -1, err
 	}
 	if err := c.addHomeMount(system); err != nil {
-		return err
+		return -1, err
 	}
 	if err := c.addUserbindsMount(system); err != nil {
-		return err
+		return -1, err
 	}
 	if err := c.addTmpMount(system); err != nil {
-		return err
+		return -1, err
 	}
 	if err := c.addScratchMount(system); err != nil {
-		return err
+		return -1, err
 	}
 	if err := c.addLibsMount(system); err != nil {
-		return err
-	}
-	if err := c.addFilesMount(system); err != nil {
-		return err
-	}
-	if err := c.addResolvConfMount(system); err != nil {
-		return err
-	}
-	if err := c.addHostnameMount(system); err != nil {
-		return err
-	}
-	usernsFd, err := c.addFuseMount(system)
-	if err != nil {
-		return err
+		return -1, err
 	}
-
-	networkSetup, err := c.prepareNetworkSetup(system, pid)
-	if err != nil {
-		return err
+	if err := c.addAttestationMount(system); err != nil {
+		return -1, err
 	}
-
-	sylog.Debugf("Mount all")
-	if err := system.MountAll(); err != nil {
-		return err
+	if err := c.addRuntimeInfoMount(system); err != nil {
+		return -1, err
 	}
-
-	// chroot from RPC server current working directory since
-	// it's already in final directory after chdirFinal call
-	sylog.Debugf("Chroot into %s\n", c.session.FinalPath())
-	_, err = c.rpcOps.Chroot(".", "pivot")
-	if err != nil {
-		sylog.Debugf("Fallback to move/chroot")
-		_, err = c.rpcOps.Chroot(".", "move")
-		if err != nil {
-			return fmt.Errorf("chroot failed: %s", err)
-		}
+	if err := c.addFilesMount(system); err != nil {
+		return -1, err
 	}
-
-	if networkSetup != nil {
-		if err := networkSetup(ctx); err != nil {
-			return err
-		}
+	if err := c.addResolvConfMount(system); err != nil {
+		return -1, err
 	}
-
-	if os.Geteuid() == 0 && !c.userNS {
-		path := engine.EngineConfig.GetCgroupsPath()
-		if path != "" {
-			cgroupPath := filepath.Join("/singularity", strconv.Itoa(pid))
-			cgroupManager = &cgroups.Manager{Pid: pid, Path: cgroupPath}
-			if err := cgroupManager.ApplyFromFile(path); err != nil {
-				return fmt.Errorf("failed to apply cgroups resources restriction: %s", err)
-			}
-		}
+	if err := c.addTimezoneMount(system); err != nil {
+		return -1, err
 	}
-
-	sylog.Debugf("Chdir into / to avoid errors\n")
-	err = syscall.Chdir("/")
-	if err != nil {
-		return fmt.Errorf("change directory failed: %s", err)
-	}
-
-	if err := engine.runFuseDrivers(false, usernsFd); err != nil {
-		return fmt.Errorf("while running FUSE drivers: %s", err)
+	if err := c.addHostnameMount(system); err != nil {
+		return -1, err
 	}
-
-	return nil
+	return c.addFuseMount(system)
 }
 
 // setupSessionLayout will create the session layout according to the capabilities of Singularity
@@ -307,9 +432,9 @@ func (c *container) setupSessionLayout(system *mount.System) error {
 	var err error
 	var sessionPath string
 
-	sessionPath, err = filepath.EvalSymlinks(buildcfg.SESSIONDIR)
+	sessionPath, err = filepath.EvalSymlinks(sessionDir)
 	if err != nil {
-		return fmt.Errorf("failed to resolve session directory %s: %s", buildcfg.SESSIONDIR, err)
+		return fmt.Errorf("failed to resolve session directory %s: %s", sessionDir, err)
 	}
 
 	sessionLayer := c.engine.EngineConfig.GetSessionLayer()
@@ -330,6 +455,10 @@ func (c *container) setupSessionLayout(system *mount.System) error {
 	if err != nil {
 		return fmt.Errorf("while setting %s session layout: %s", sessionLayer, err)
 	}
+	if c.dryRun {
+		c.session.VFS = newDryRunVFS()
+		return nil
+	}
 
 	return system.RunAfterTag(mount.SharedTag, c.setPropagationMount)
 }
@@ -380,7 +509,7 @@ func (c *container) mount(point *mount.Point, system *mount.System) error {
 // setupImageDriver prepare the image driver configured in singularity.conf
 // to start it after the session setup.
 func (c *container) setupImageDriver(system *mount.System) error {
-	if imageDriver == nil {
+	if imageDriver == nil || c.dryRun {
 		return nil
 	}
 
@@ -618,8 +747,26 @@ func (c *container) mountGeneric(mnt *mount.Point, tag mount.AuthorizedTag) (err
 		}
 	}
 
+	created := false
+
 mount:
 	err = c.rpcOps.Mount(source, dest, mnt.Type, flags, optsString)
+	if os.IsNotExist(err) && !remount && !propagation {
+		switch mount.MissingPolicy(mnt.InternalOptions) {
+		case singularity.MissingSkip:
+			c.skippedMount = append(c.skippedMount, mnt.Destination)
+			sylog.Warningf("Skipping mount %s [%s]: %s doesn't exist in container", source, tag, mnt.Destination)
+			return nil
+		case singularity.MissingCreate:
+			if !created {
+				if err := c.createBindDestination(source, dest, mnt.Destination); err != nil {
+					return err
+				}
+				created = true
+				goto mount
+			}
+		}
+	}
 	if os.IsNotExist(err) {
 		switch tag {
 		case mount.KernelTag,
@@ -657,7 +804,8 @@ mount:
 				goto mount
 			}
 			// mount error for other filesystems is considered fatal
-			return fmt.Errorf("can't mount %s filesystem to %s: %s", mnt.Type, mnt.Destination, err)
+			msg := fmt.Sprintf("can't mount %s filesystem to %s: %s", mnt.Type, mnt.Destination, err)
+			return mount.Diagnose(msg, c.mountFailure(mnt, dest, err))
 		}
 		if remount {
 			if os.IsPermission(err) && c.userNS {
@@ -672,7 +820,8 @@ mount:
 				}
 				return nil
 			}
-			return fmt.Errorf("could not remount %s: %s", mnt.Destination, err)
+			msg := fmt.Sprintf("could not remount %s: %s", mnt.Destination, err)
+			return mount.Diagnose(msg, c.mountFailure(mnt, dest, err))
 		}
 
 		if mount.SkipOnError(mnt.InternalOptions) {
@@ -680,9 +829,54 @@ mount:
 			c.skippedMount = append(c.skippedMount, mnt.Destination)
 			return nil
 		}
-		return fmt.Errorf("could not mount %s: %s", mnt.Source, err)
+		msg := fmt.Sprintf("could not mount %s: %s", mnt.Source, err)
+		return mount.Diagnose(msg, c.mountFailure(mnt, dest, err))
+	}
+
+	return nil
+}
+
+// mountFailure returns the description of the failed mount of mnt on
+// dest used by the mount diagnostics.
+func (c *container) mountFailure(mnt *mount.Point, dest string, err error) mount.Failure {
+	return mount.Failure{
+		Source:      mnt.Source,
+		Destination: mnt.Destination,
+		Target:      dest,
+		FSType:      mnt.Type,
+		Options:     mnt.Options,
+		UserNS:      c.userNS,
+		MountInfo:   c.mountInfoPath,
+		Err:         err,
+	}
+}
+
+// createBindDestination creates the destination dest of the bind mount
+// of source in a writable container image, with its missing parent
+// directories. The destination is an empty file for file sources and a
+// directory otherwise.
+func (c *container) createBindDestination(source, dest, containerPath string) error {
+	if !c.engine.EngineConfig.GetWritableImage() {
+		return fmt.Errorf("destination %s doesn't exist in container and can't be created in a read-only image, use --writable or enable overlay or underlay", containerPath)
+	}
+	fi, err := os.Stat(source)
+	if err != nil {
+		return fmt.Errorf("while getting %s information: %s", source, err)
+	}
+
+	root := c.session.FinalPath()
+	rel, err := filepath.Rel(root, dest)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("destination %s is outside of the container root filesystem", containerPath)
 	}
 
+	// symlinks are refused, the destination was already resolved in the
+	// container and a symlink there would point outside of the container
+	if err := c.rpcOps.CreateBeneath(root, rel, !fi.IsDir()); err != nil {
+		return fmt.Errorf("while creating %s destination: %s", containerPath, err)
+	}
+
+	sylog.Verbosef("Created %s bind destination in container", containerPath)
 	return nil
 }
 
@@ -748,9 +942,25 @@ func (c *container) mountImage(mnt *mount.Point) error {
 	}
 
 	path := fmt.Sprintf("/dev/loop%d", number)
+	recordLoop(path)
 
 	sylog.Debugf("Mounting loop device %s to %s of type %s\n", path, mnt.Destination, mnt.Type)
 
+	if v := c.rootfsVerity; v != nil && mountType == "squashfs" && mnt.Source == v.source && offset == v.offset {
+		dev, err := c.setupVerity(path, v, sizelimit, maxDevices, shared)
+		if err != nil {
+			if c.engine.EngineConfig.File.DmVerity == "yes" {
+				return fmt.Errorf("while setting up dm-verity device: %s", err)
+			}
+			sylog.Warningf("Mounting root filesystem without dm-verity verification: %s", err)
+		} else {
+			sylog.Debugf("Mounting dm-verity device %s", dev)
+			verityDev = dev
+			record(journal.Verity, dev, nil)
+			path = dev
+		}
+	}
+
 	if mountType == "encryptfs" {
 		// pass the master processus ID only if a container IPC
 		// namespace was requested because cryptsetup requires
@@ -765,6 +975,7 @@ func (c *container) mountImage(mnt *mount.Point) error {
 		if err != nil {
 			return fmt.Errorf("unable to decrypt the file system: %s", err)
 		}
+		record(journal.Crypt, cryptDev, nil)
 
 		path = cryptDev
 
@@ -794,6 +1005,175 @@ func (c *container) mountImage(mnt *mount.Point) error {
 	return nil
 }
 
+// getRootfsVerity looks for the dm-verity hash tree of the root
+// filesystem partition, the root filesystem is mounted through a
+// dm-verity device when found, unless disabled by configuration.
+func (c *container) getRootfsVerity(img *image.Image, part *image.Section) error {
+	mode := c.engine.EngineConfig.File.DmVerity
+	if mode == "no" {
+		return nil
+	}
+
+	var hash *image.Section
+	for i, s := range img.Sections {
+		if s.Name == verity.DescriptorName {
+			hash = &img.Sections[i]
+			break
+		}
+	}
+	if hash == nil {
+		return nil
+	}
+
+	if imageDriver != nil && imageDriver.Features()&image.ImageFeature != 0 {
+		if mode == "yes" {
+			return fmt.Errorf("dm-verity is not supported with image driver %s", c.engine.EngineConfig.File.ImageDriver)
+		}
+		sylog.Warningf("Image driver %s in use, dm-verity hash tree ignored", c.engine.EngineConfig.File.ImageDriver)
+		return nil
+	}
+
+	f, err := os.Open(img.Source)
+	if err != nil {
+		return fmt.Errorf("while opening image %s: %s", img.Source, err)
+	}
+	defer f.Close()
+
+	// the descriptor is read once, the signature is verified over
+	// and the parameters are parsed from the same bytes, so the image
+	// can't be modified in between
+	data := make([]byte, hash.Size)
+	if _, err := f.ReadAt(data, int64(hash.Offset)); err != nil {
+		return fmt.Errorf("while reading dm-verity descriptor: %s", err)
+	}
+
+	params, err := verity.ReadParams(bytes.NewReader(data), 0)
+	if err != nil {
+		return fmt.Errorf("while reading dm-verity parameters: %s", err)
+	}
+
+	// the root hash proves nothing unless the hash tree descriptor is
+	// signed by a key the administrator trusts
+	if err := verifyVerityDescriptor(f, hash, data); err != nil {
+		if mode == "yes" {
+			return fmt.Errorf("dm-verity hash tree not trusted: %s", err)
+		}
+		sylog.Warningf("Mounting root filesystem without dm-verity verification, hash tree not trusted: %s", err)
+		return nil
+	}
+
+	if params.DataSize() > part.Size {
+		return fmt.Errorf("dm-verity hash tree covers more data than the root filesystem partition")
+	}
+
+	sylog.Debugf("Found dm-verity hash tree for root filesystem, root hash %s", params.RootHash)
+
+	c.rootfsVerity = &rootfsVerity{
+		source: img.Source,
+		offset: part.Offset,
+		hash:   *hash,
+		params: params,
+	}
+	return nil
+}
+
+// verityFile serves reads of the dm-verity descriptor data from the
+// bytes already read from the image instead of reading them again.
+type verityFile struct {
+	*os.File
+	offset int64
+	data   []byte
+}
+
+// ReadAt implements io.ReaderAt.
+func (v *verityFile) ReadAt(p []byte, off int64) (int, error) {
+	end := v.offset + int64(len(v.data))
+	n := 0
+
+	for len(p) > 0 {
+		var c int
+		var err error
+
+		switch {
+		case off >= v.offset && off < end:
+			c = copy(p, v.data[off-v.offset:])
+		case off < v.offset && off+int64(len(p)) > v.offset:
+			c, err = v.File.ReadAt(p[:v.offset-off], off)
+		default:
+			c, err = v.File.ReadAt(p, off)
+		}
+		n += c
+		if err != nil {
+			return n, err
+		}
+		p = p[c:]
+		off += int64(c)
+	}
+	return n, nil
+}
+
+// verifyVerityDescriptor checks that the dm-verity descriptor data of
+// the SIF image is covered by a valid signature of a key of the global
+// keyring. The descriptor data are read from data, not from f.
+func verifyVerityDescriptor(f *os.File, hash *image.Section, data []byte) error {
+	keyring := sypgp.NewHandle(buildcfg.SINGULARITY_CONFDIR, sypgp.GlobalHandleOpt())
+	kr, err := keyring.LoadPubKeyring()
+	if err != nil {
+		return fmt.Errorf("while loading global keyring: %s", err)
+	}
+
+	fimg, err := sif.LoadContainerFp(&verityFile{File: f, offset: int64(hash.Offset), data: data}, true)
+	if err != nil {
+		return fmt.Errorf("while loading SIF image: %s", err)
+	}
+
+	// the signed descriptor must point to the data already read
+	d, _, err := fimg.GetFromDescrID(hash.ID)
+	if err != nil {
+		return fmt.Errorf("while looking for dm-verity descriptor: %s", err)
+	}
+	if d.Fileoff != int64(hash.Offset) || d.Filelen != int64(hash.Size) {
+		return fmt.Errorf("dm-verity descriptor changed since image was loaded")
+	}
+
+	v, err := integrity.NewVerifier(&fimg, integrity.OptVerifyWithKeyRing(kr), integrity.OptVerifyObject(hash.ID))
+	if err != nil {
+		return err
+	}
+	if err := v.Verify(); err != nil {
+		return fmt.Errorf("no valid signature from the global keyring: %s", err)
+	}
+	return nil
+}
+
+// setupVerity sets up a dm-verity device verifying the root filesystem
+// loop device and returns the dm-verity device path.
+func (c *container) setupVerity(loopDev string, v *rootfsVerity, sizelimit uint64, maxDevices int, shared bool) (string, error) {
+	if sizelimit != 0 && v.params.DataSize() > sizelimit {
+		return "", fmt.Errorf("dm-verity hash tree covers more data than the partition")
+	}
+
+	info := loop.Info64{
+		Offset:    v.hash.Offset,
+		SizeLimit: v.hash.Size,
+		Flags:     loop.FlagsAutoClear | loop.FlagsReadOnly,
+	}
+	number, err := c.rpcOps.LoopDevice(v.source, os.O_RDONLY, info, maxDevices, shared)
+	if err != nil {
+		return "", fmt.Errorf("failed to find loop device for hash tree: %s", err)
+	}
+	hashDev := fmt.Sprintf("/dev/loop%d", number)
+	recordLoop(hashDev)
+
+	// dmsetup requires to run in the host IPC namespace
+	masterPid := 0
+	if c.ipcNS {
+		masterPid = os.Getpid()
+	}
+
+	return c.rpcOps.Verity(loopDev, hashDev, v.params, masterPid)
+}
+
 func (c *container) addRootfsMount(system *mount.System) error {
 	flags := uintptr(c.suidFlag | syscall.MS_NODEV)
 	rootfs := c.engine.EngineConfig.GetImage()
@@ -819,6 +1199,9 @@ func (c *container) addRootfsMount(system *mount.System) error {
 	switch part.Type {
 	case image.SQUASHFS:
 		mountType = "squashfs"
+		if err := c.getRootfsVerity(&imageObject, part); err != nil {
+			return err
+		}
 	case image.EXT3:
 		mountType = "ext3"
 	case image.ENCRYPTSQUASHFS:
@@ -1034,7 +1417,7 @@ func (c *container) addOverlayMount(system *mount.System) error {
 		}
 	}
 
-	if hasUpper {
+	if hasUpper && !c.dryRun {
 		if err := system.RunAfterTag(mount.PreLayerTag, c.overlayUpperWork); err != nil {
 			return err
 		}
@@ -1152,12 +1535,14 @@ func (c *container) addImageBindMount(system *mount.System) error {
 
 			src := filepath.Join(imgDest, imageSource)
 
-			system.RunAfterTag(mount.PreLayerTag, func(*mount.System) error {
-				if err := unix.Access(src, unix.R_OK); os.IsNotExist(err) {
-					return fmt.Errorf("%s doesn't exist in image %s", imageSource, img.Path)
-				}
-				return nil
-			})
+			if !c.dryRun {
+				system.RunAfterTag(mount.PreLayerTag, func(*mount.System) error {
+					if err := unix.Access(src, unix.R_OK); os.IsNotExist(err) {
+						return fmt.Errorf("%s doesn't exist in image %s", imageSource, img.Path)
+					}
+					return nil
+				})
+			}
 
 			if err := system.Points.AddBind(mount.UserbindsTag, src, destination, syscall.MS_BIND); err != nil {
 				return fmt.Errorf("while adding data bind %s -> %s: %s", src, destination, err)
@@ -1533,7 +1918,7 @@ func (c *container) addBindsMount(system *mount.System) error {
 		return nil
 	}
 
-	for _, bindpath := range c.engine.EngineConfig.File.BindPath {
+	for _, bindpath := range c.engine.configBindPaths() {
 		splitted := strings.Split(bindpath, ":")
 		src := splitted[0]
 		dst := ""
@@ -1600,6 +1985,10 @@ func (c *container) addHomeStagingDir(system *mount.System, source string, dest
 	if _, err := os.Stat(source); os.IsNotExist(err) {
 		bindSource = false
 	}
+	// a tmpfs home is the session home directory
+	if c.engine.EngineConfig.GetHomeTmpfs() {
+		bindSource = false
+	}
 
 	if bindSource {
 		sylog.Debugf("Staging home directory (%v) at %v\n", source, homeStage)
@@ -1668,8 +2057,9 @@ func (c *container) addHomeMount(system *mount.System) error {
 		return nil
 	}
 
-	// check if user attempt to mount a custom home when not allowed to
-	if c.engine.EngineConfig.GetCustomHome() && !c.engine.EngineConfig.File.UserBindControl {
+	// check if user attempt to mount a custom home when not allowed to,
+	// a tmpfs home doesn't mount any host directory
+	if c.engine.EngineConfig.GetCustomHome() && !c.engine.EngineConfig.GetHomeTmpfs() && !c.engine.EngineConfig.File.UserBindControl {
 		return fmt.Errorf("not mounting user requested home: user bind control is disallowed")
 	}
 
@@ -1759,7 +2149,12 @@ func (c *container) addUserbindsMount(system *mount.System) error {
 
 		sylog.Debugf("Adding %s to mount list\n", src)
 
-		if err := system.Points.AddBind(mount.UserbindsTag, src, dst, flags); err == mount.ErrMountExists {
+		var options []string
+		if missing := b.Missing(); missing != "" {
+			options = append(options, "missing="+missing)
+		}
+
+		if err := system.Points.AddBind(mount.UserbindsTag, src, dst, flags, options...); err == mount.ErrMountExists {
 			sylog.Warningf("While bind mounting '%s:%s': %s", src, dst, err)
 		} else if err != nil {
 			return fmt.Errorf("unable to add %s to mount list: %s", src, err)
@@ -1808,11 +2203,13 @@ func (c *container) addTmpMount(system *mount.System) error {
 			tmpSource = filepath.Join(workdir, tmpSource)
 			vartmpSource = filepath.Join(workdir, vartmpSource)
 
-			if err := fs.Mkdir(tmpSource, os.ModeSticky|0777); err != nil && !os.IsExist(err) {
-				return fmt.Errorf("failed to create %s: %s", tmpSource, err)
-			}
-			if err := fs.Mkdir(vartmpSource, os.ModeSticky|0777); err != nil && !os.IsExist(err) {
-				return fmt.Errorf("failed to create %s: %s", vartmpSource, err)
+			if !c.dryRun {
+				if err := fs.Mkdir(tmpSource, os.ModeSticky|0777); err != nil && !os.IsExist(err) {
+					return fmt.Errorf("failed to create %s: %s", tmpSource, err)
+				}
+				if err := fs.Mkdir(vartmpSource, os.ModeSticky|0777); err != nil && !os.IsExist(err) {
+					return fmt.Errorf("failed to create %s: %s", vartmpSource, err)
+				}
 			}
 		} else {
 			if _, err := c.session.GetPath(tmpSource); err != nil {
@@ -1877,6 +2274,8 @@ func (c *container) addScratchMount(system *mount.System) error {
 
 	if hasWorkdir {
 		workdir = filepath.Clean(workdir)
+	}
+	if hasWorkdir && !c.dryRun {
 		sourceDir := filepath.Join(workdir, scratchSessionDir)
 		if err := fs.MkdirAll(sourceDir, 0750); err != nil {
 			return fmt.Errorf("could not create scratch working directory %s: %s", sourceDir, err)
@@ -1891,8 +2290,10 @@ func (c *container) addScratchMount(system *mount.System) error {
 		fullSourceDir, _ := c.session.GetPath(src)
 		if hasWorkdir {
 			fullSourceDir = filepath.Join(workdir, scratchSessionDir, dir)
-			if err := fs.MkdirAll(fullSourceDir, 0750); err != nil {
-				return fmt.Errorf("could not create scratch working directory %s: %s", fullSourceDir, err)
+			if !c.dryRun {
+				if err := fs.MkdirAll(fullSourceDir, 0750); err != nil {
+					return fmt.Errorf("could not create scratch working directory %s: %s", fullSourceDir, err)
+				}
 			}
 		}
 		c.session.OverrideDir(dir, fullSourceDir)
@@ -1963,30 +2364,35 @@ func (c *container) addCwdMount(system *mount.System) error {
 		return nil
 	}
 
-	dest := fs.EvalRelative(cwd, c.session.FinalPath())
-	dest = filepath.Join(c.session.FinalPath(), dest)
+	// with a dry run the container content is not available, the
+	// mount is reported even if the directory is found within the
+	// container
+	if !c.dryRun {
+		dest := fs.EvalRelative(cwd, c.session.FinalPath())
+		dest = filepath.Join(c.session.FinalPath(), dest)
 
-	fi, err := c.rpcOps.Stat(dest)
-	if err != nil {
-		if os.IsNotExist(err) {
-			sylog.Verbosef("Not mounting CWD, %s doesn't exist within container", cwd)
+		fi, err := c.rpcOps.Stat(dest)
+		if err != nil {
+			if os.IsNotExist(err) {
+				sylog.Verbosef("Not mounting CWD, %s doesn't exist within container", cwd)
+			}
+			sylog.Verbosef("Not mounting CWD, while getting %s information: %s", cwd, err)
+			return nil
 		}
-		sylog.Verbosef("Not mounting CWD, while getting %s information: %s", cwd, err)
-		return nil
-	}
-	cst := fi.Sys().(*syscall.Stat_t)
+		cst := fi.Sys().(*syscall.Stat_t)
 
-	var hst syscall.Stat_t
-	if err := syscall.Stat(cwd, &hst); err != nil {
-		return err
-	}
-	// same ino/dev, the current working directory is available within the container
-	if hst.Dev == cst.Dev && hst.Ino == cst.Ino {
-		sylog.Verbosef("%s found within container", cwd)
-		return nil
-	} else if c.isMounted(dest) {
-		sylog.Verbosef("Not mounting CWD (already mounted in container): %s", cwd)
-		return nil
+		var hst syscall.Stat_t
+		if err := syscall.Stat(cwd, &hst); err != nil {
+			return err
+		}
+		// same ino/dev, the current working directory is available within the container
+		if hst.Dev == cst.Dev && hst.Ino == cst.Ino {
+			sylog.Verbosef("%s found within container", cwd)
+			return nil
+		} else if c.isMounted(dest) {
+			sylog.Verbosef("Not mounting CWD (already mounted in container): %s", cwd)
+			return nil
+		}
 	}
 
 	flags := uintptr(syscall.MS_BIND | c.suidFlag | syscall.MS_NODEV | syscall.MS_REC)
@@ -2052,6 +2458,175 @@ func (c *container) addLibsMount(system *mount.System) error {
 	return nil
 }
 
+// addAttestationMount computes the digest of image partitions mounted
+// in the container, records image files in the IMA measurement log and
+// exposes the resulting attestation document in the container.
+func (c *container) addAttestationMount(system *mount.System) error {
+	if !c.engine.EngineConfig.File.IMAMeasurement {
+		return nil
+	}
+
+	points := system.Points.GetAllImages()
+	if c.dryRun {
+		// the partitions are not measured without mounting them
+		points = nil
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Destination < points[j].Destination
+	})
+
+	hostname, _ := os.Hostname()
+	doc := ima.Document{
+		Version:  ima.DocumentVersion,
+		Hostname: hostname,
+		Time:     time.Now().UTC(),
+		Images:   make([]ima.Image, 0),
+	}
+	images := make(map[string]int)
+
+	for _, point := range points {
+		offset, err := mount.GetOffset(point.InternalOptions)
+		if err != nil {
+			return err
+		}
+		size, err := mount.GetSizeLimit(point.InternalOptions)
+		if err != nil {
+			return err
+		}
+
+		idx, ok := images[point.Source]
+		if !ok {
+			img := ima.Image{Path: point.Source}
+			img.IMAXattr, img.EVMXattr = ima.Xattrs(point.Source)
+			if ima.Enabled() {
+				img.Measurements, err = c.rpcOps.Measure(point.Source)
+				if err != nil {
+					sylog.Warningf("Could not get IMA measurements of %s: %s", point.Source, err)
+				}
+			}
+			doc.Images = append(doc.Images, img)
+			idx = len(doc.Images) - 1
+			images[point.Source] = idx
+		}
+
+		f, err := os.Open(point.Source)
+		if err != nil {
+			return fmt.Errorf("while opening image %s: %s", point.Source, err)
+		}
+		digest, err := ima.PartitionDigest(c.engine.digestAlgorithm(), f, offset, size)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("while computing %s partition digest: %s", point.Source, err)
+		}
+		sylog.Debugf("Image %s partition mounted on %s has digest %s", point.Source, point.Destination, digest)
+
+		doc.Images[idx].Partitions = append(doc.Images[idx].Partitions, ima.Partition{
+			Destination: point.Destination,
+			Type:        point.Type,
+			Offset:      offset,
+			Size:        size,
+			Digest:      digest,
+		})
+	}
+
+	content, err := json.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		return fmt.Errorf("while encoding attestation document: %s", err)
+	}
+	if err := c.session.AddFile(ima.DocumentPath, content); err != nil {
+		return fmt.Errorf("failed to add attestation session file: %s", err)
+	}
+	sessionFile, _ := c.session.GetPath(ima.DocumentPath)
+
+	sylog.Debugf("Adding %s to mount list\n", ima.DocumentPath)
+	flags := uintptr(syscall.MS_BIND | syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_RDONLY)
+	if err := system.Points.AddBind(mount.FilesTag, sessionFile, ima.DocumentPath, flags); err != nil {
+		return fmt.Errorf("unable to add %s to mount list: %s", ima.DocumentPath, err)
+	}
+	system.Points.AddRemount(mount.FilesTag, ima.DocumentPath, flags)
+	sylog.Verbosef("Default mount: %s:%s", ima.DocumentPath, ima.DocumentPath)
+	return nil
+}
+
+// addRuntimeInfoMount exposes the runtime information describing how
+// the container was launched in the container.
+func (c *container) addRuntimeInfoMount(system *mount.System) error {
+	cfg := c.engine.EngineConfig
+	if !cfg.File.RuntimeInfo {
+		return nil
+	}
+
+	info := inspect.RuntimeInfo{
+		Version:       buildcfg.PACKAGE_VERSION,
+		Started:       time.Now().UTC(),
+		Image:         cfg.GetImage(),
+		Binds:         make([]inspect.RuntimeBind, 0),
+		Overlays:      cfg.GetOverlayImage(),
+		Writable:      cfg.GetWritableImage(),
+		WritableTmpfs: cfg.GetWritableTmpfs(),
+		Contain:       cfg.GetContain(),
+		Fakeroot:      cfg.GetFakeroot(),
+		Namespaces:    make([]string, 0),
+		Flags:         cfg.GetCLIFlags(),
+	}
+	if info.Overlays == nil {
+		info.Overlays = make([]string, 0)
+	}
+	if info.Flags == nil {
+		info.Flags = make([]string, 0)
+	}
+	if cfg.GetInstance() {
+		info.Instance = c.engine.CommonConfig.ContainerID
+	}
+	if cfg.File.RuntimeInfoImageDigest {
+		if images := cfg.GetImageList(); len(images) > 0 {
+			digest, err := imageDigest(&images[0], c.engine.digestAlgorithm())
+			if err != nil {
+				sylog.Warningf("Could not compute image digest: %s", err)
+			}
+			info.ImageDigest = digest
+		}
+	}
+	for _, b := range cfg.GetBindPath() {
+		options := make([]string, 0, len(b.Options))
+		for name, o := range b.Options {
+			if o != nil && o.Value != "" {
+				name += "=" + o.Value
+			}
+			options = append(options, name)
+		}
+		sort.Strings(options)
+		info.Binds = append(info.Binds, inspect.RuntimeBind{
+			Source:      b.Source,
+			Destination: b.Destination,
+			Options:     strings.Join(options, ","),
+		})
+	}
+	if cfg.OciConfig.Linux != nil {
+		for _, ns := range cfg.OciConfig.Linux.Namespaces {
+			info.Namespaces = append(info.Namespaces, string(ns.Type))
+		}
+	}
+
+	content, err := json.MarshalIndent(info, "", "\t")
+	if err != nil {
+		return fmt.Errorf("while encoding runtime information: %s", err)
+	}
+	if err := c.session.AddFile(inspect.RuntimeInfoPath, append(content, '\n')); err != nil {
+		return fmt.Errorf("failed to add runtime information session file: %s", err)
+	}
+	sessionFile, _ := c.session.GetPath(inspect.RuntimeInfoPath)
+
+	sylog.Debugf("Adding %s to mount list\n", inspect.RuntimeInfoPath)
+	flags := uintptr(syscall.MS_BIND | syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_RDONLY)
+	if err := system.Points.AddBind(mount.FilesTag, sessionFile, inspect.RuntimeInfoPath, flags); err != nil {
+		return fmt.Errorf("unable to add %s to mount list: %s", inspect.RuntimeInfoPath, err)
+	}
+	system.Points.AddRemount(mount.FilesTag, inspect.RuntimeInfoPath, flags)
+	sylog.Verbosef("Default mount: %s:%s", inspect.RuntimeInfoPath, inspect.RuntimeInfoPath)
+	return nil
+}
+
 func (c *container) addFilesMount(system *mount.System) error {
 	files := c.engine.EngineConfig.GetFilesPath()
 
@@ -2109,7 +2684,11 @@ func (c *container) addIdentityMount(system *mount.System) error {
 		if err != nil {
 			sylog.Warningf("%s", err)
 		} else {
-			content, err := files.Passwd(passwd, home, uid)
+			// the container files are not read with a dry run
+			var content []byte
+			if !c.dryRun {
+				content, err = files.Passwd(passwd, home, uid)
+			}
 			if err != nil {
 				sylog.Warningf("%s", err)
 			} else {
@@ -2132,7 +2711,11 @@ func (c *container) addIdentityMount(system *mount.System) error {
 
 	if c.engine.EngineConfig.File.ConfigGroup {
 		group := filepath.Join(rootfs, "/etc/group")
-		content, err := files.Group(group, uid, c.engine.EngineConfig.GetTargetGID())
+		var content []byte
+		var err error
+		if !c.dryRun {
+			content, err = files.Group(group, uid, c.engine.EngineConfig.GetTargetGID())
+		}
 		if err != nil {
 			sylog.Warningf("%s", err)
 		} else {
@@ -2221,6 +2804,9 @@ func (c *container) addHostnameMount(system *mount.System) error {
 				return fmt.Errorf("unable to add %s to mount list: %s", hostnameFile, err)
 			}
 			sylog.Verbosef("Default mount: /etc/hostname:/etc/hostname")
+			if c.dryRun {
+				return nil
+			}
 			if _, err := c.rpcOps.SetHostname(hostname); err != nil {
 				return fmt.Errorf("failed to set container hostname: %s", err)
 			}
@@ -2335,6 +2921,15 @@ func (c *container) prepareNetworkSetup(system *mount.System, pid int) (func(con
 
 		networkSetup.SetEnvPath("/bin:/sbin:/usr/bin:/usr/sbin")
 
+		record(journal.Network, strings.Join(networks, ","), journal.NetworkData{
+			Networks:    networks,
+			ContainerID: strconv.Itoa(pid),
+			NsPath:      nspath,
+			ConfPath:    cniPath.Conf,
+			PluginPath:  cniPath.Plugin,
+			Args:        netargs,
+		})
+
 		if err := networkSetup.AddNetworks(ctx); err != nil {
 			return fmt.Errorf("%s", err)
 		}
@@ -2453,7 +3048,7 @@ func (c *container) addFuseMount(system *mount.System) (int, error) {
 		fds = append(fds, fuseMount.Fd)
 	}
 
-	if len(fds) > 0 {
+	if len(fds) > 0 && !c.dryRun {
 		newfds, err := c.getFuseFdFromRPC(fds)
 		if err != nil {
 			return usernsFd, err
@@ -0,0 +1,151 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hpcng/singularity/internal/pkg/runtime/engine/config/oci"
+	"github.com/hpcng/singularity/internal/pkg/runtime/engine/config/oci/generate"
+	"github.com/hpcng/singularity/internal/pkg/util/fs/layout"
+	"github.com/hpcng/singularity/internal/pkg/util/fs/mount"
+	"github.com/hpcng/singularity/pkg/inspect"
+	"github.com/hpcng/singularity/pkg/runtime/engine/config"
+	singularityConfig "github.com/hpcng/singularity/pkg/runtime/engine/singularity/config"
+	"github.com/hpcng/singularity/pkg/util/singularityconf"
+)
+
+func TestAddRuntimeInfoMount(t *testing.T) {
+	file, err := singularityconf.GetConfig(nil)
+	if err != nil {
+		t.Fatalf("failed to get default configuration: %s", err)
+	}
+	if file.RuntimeInfo {
+		t.Errorf("runtime information exposed by default")
+	}
+
+	tests := []struct {
+		name    string
+		enabled bool
+	}{
+		{"Disabled", false},
+		{"Enabled", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "runtime-info-")
+			if err != nil {
+				t.Fatalf("failed to create temporary directory: %s", err)
+			}
+			defer os.RemoveAll(dir)
+
+			conf := *file
+			conf.RuntimeInfo = tt.enabled
+
+			cfg := singularityConfig.NewConfig()
+			cfg.File = &conf
+			cfg.OciConfig = &oci.Config{}
+			g := generate.New(&cfg.OciConfig.Spec)
+			g.AddOrReplaceLinuxNamespace("pid", "")
+			cfg.SetImage("/image.sif")
+			cfg.SetBindPath([]singularityConfig.BindPath{
+				{Source: "/data", Destination: "/mnt", Options: map[string]*singularityConfig.BindOption{"ro": {}}},
+			})
+			cfg.SetCLIFlags([]string{"bind"})
+
+			system := &mount.System{Points: &mount.Points{}}
+			session, err := layout.NewSession(dir, "tmpfs", 0, system, nil)
+			if err != nil {
+				t.Fatalf("failed to create session: %s", err)
+			}
+			c := &container{
+				engine:  &EngineOperations{CommonConfig: &config.Common{}, EngineConfig: cfg},
+				session: session,
+			}
+			if err := c.addRuntimeInfoMount(system); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			points := system.Points.GetByDest(inspect.RuntimeInfoPath)
+			if !tt.enabled {
+				if len(points) > 0 {
+					t.Errorf("runtime information mounted while disabled")
+				}
+				return
+			}
+			if len(points) != 2 {
+				t.Fatalf("got %d mount points instead of a bind and its remount", len(points))
+			}
+
+			if err := session.Create(); err != nil {
+				t.Fatalf("failed to create session layout: %s", err)
+			}
+			data, err := ioutil.ReadFile(points[0].Source)
+			if err != nil {
+				t.Fatalf("failed to read runtime information: %s", err)
+			}
+			var info inspect.RuntimeInfo
+			if err := json.Unmarshal(data, &info); err != nil {
+				t.Fatalf("failed to decode runtime information: %s", err)
+			}
+			if info.Image != "/image.sif" {
+				t.Errorf("got image %s instead of /image.sif", info.Image)
+			}
+			if len(info.Binds) != 1 || info.Binds[0] != (inspect.RuntimeBind{Source: "/data", Destination: "/mnt", Options: "ro"}) {
+				t.Errorf("unexpected binds %+v", info.Binds)
+			}
+			if len(info.Namespaces) != 1 || info.Namespaces[0] != "pid" {
+				t.Errorf("unexpected namespaces %v", info.Namespaces)
+			}
+			if len(info.Flags) != 1 || info.Flags[0] != "bind" {
+				t.Errorf("unexpected flags %v", info.Flags)
+			}
+		})
+	}
+}
+
+func TestVerityFileReadAt(t *testing.T) {
+	f, err := ioutil.TempFile("", "verity-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := f.WriteString("0123456789"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file content of the descriptor data changed after being read
+	v := &verityFile{File: f, offset: 3, data: []byte("abcd")}
+
+	tests := []struct {
+		name     string
+		offset   int64
+		size     int
+		expected string
+	}{
+		{name: "Before", offset: 0, size: 3, expected: "012"},
+		{name: "Data", offset: 4, size: 2, expected: "bc"},
+		{name: "After", offset: 7, size: 3, expected: "789"},
+		{name: "Overlap", offset: 1, size: 8, expected: "12abcd78"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := make([]byte, tt.size)
+			if _, err := v.ReadAt(b, tt.offset); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if string(b) != tt.expected {
+				t.Errorf("got %q instead of %q", b, tt.expected)
+			}
+		})
+	}
+}
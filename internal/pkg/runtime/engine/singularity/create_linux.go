@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -13,6 +13,7 @@ import (
 
 	"github.com/hpcng/singularity/internal/pkg/runtime/engine/singularity/rpc/client"
 	singularityConfig "github.com/hpcng/singularity/pkg/runtime/engine/singularity/config"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
 )
 
 // CreateContainer is called from master process to prepare container
@@ -44,5 +45,17 @@ func (e *EngineOperations) CreateContainer(ctx context.Context, pid int, rpcConn
 		return fmt.Errorf("failed to initialize RPC client")
 	}
 
-	return create(ctx, e, rpcOps, pid)
+	if err := create(ctx, e, rpcOps, pid); err != nil {
+		return err
+	}
+
+	hooksPid = pid
+	if hooks := e.EngineConfig.OciConfig.Hooks; hooks != nil {
+		for _, stage := range [][]specs.Hook{hooks.Prestart, hooks.CreateRuntime} {
+			if errs := e.runOCIHooks(ctx, stage, specs.StateCreating, true); len(errs) > 0 {
+				return fmt.Errorf("while running OCI hooks: %s", errs[0])
+			}
+		}
+	}
+	return nil
 }
@@ -0,0 +1,246 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/runtime/engine/config/starter"
+	"github.com/hpcng/singularity/internal/pkg/util/fs/layout"
+	"github.com/hpcng/singularity/internal/pkg/util/fs/mount"
+	singularityConfig "github.com/hpcng/singularity/pkg/runtime/engine/singularity/config"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// sessionSource replaces the session directory path in the sources
+// and destinations of the planned mounts.
+const sessionSource = "<session>"
+
+// dryRunVFS is the session VFS of a dry run, the host filesystem
+// is read and the writes are only recorded.
+type dryRunVFS struct {
+	layout.VFS
+	created map[string]os.FileMode
+}
+
+func newDryRunVFS() *dryRunVFS {
+	return &dryRunVFS{
+		VFS:     layout.DefaultVFS,
+		created: make(map[string]os.FileMode),
+	}
+}
+
+func (v *dryRunVFS) Chown(string, int, int) error  { return nil }
+func (v *dryRunVFS) Lchown(string, int, int) error { return nil }
+
+func (v *dryRunVFS) Mkdir(name string, perm os.FileMode) error {
+	v.created[name] = os.ModeDir | perm
+	return nil
+}
+
+func (v *dryRunVFS) Symlink(oldname, newname string) error {
+	v.created[newname] = os.ModeSymlink | 0777
+	return nil
+}
+
+func (v *dryRunVFS) WriteFile(filename string, data []byte, perm os.FileMode) error {
+	v.created[filename] = perm
+	return nil
+}
+
+// Stat returns the information of the files created by the session
+// as if they were written.
+func (v *dryRunVFS) Stat(name string) (os.FileInfo, error) {
+	if mode, ok := v.created[filepath.Clean(name)]; ok {
+		return dryRunFile{name: filepath.Base(name), mode: mode}, nil
+	}
+	return v.VFS.Stat(name)
+}
+
+// dryRunFile is a file created by the session with a dry run.
+type dryRunFile struct {
+	name string
+	mode os.FileMode
+}
+
+func (f dryRunFile) Name() string       { return f.name }
+func (f dryRunFile) Size() int64        { return 0 }
+func (f dryRunFile) Mode() os.FileMode  { return f.mode }
+func (f dryRunFile) ModTime() time.Time { return time.Time{} }
+func (f dryRunFile) IsDir() bool        { return f.mode.IsDir() }
+func (f dryRunFile) Sys() interface{}   { return nil }
+
+// dryRun writes on the standard output the plan of the container set up
+// by the prepared configuration and tells starter to exit once stage 1
+// is completed instead of creating the container.
+func (e *EngineOperations) dryRun(starterConfig *starter.Config) error {
+	plan, err := e.planContainer(starterConfig.GetIsSUID())
+	if err != nil {
+		return fmt.Errorf("while planning container: %s", err)
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(plan); err != nil {
+		return fmt.Errorf("while writing container plan: %s", err)
+	}
+	starterConfig.SetDryRun(true)
+	return nil
+}
+
+// planContainer returns the plan of the container set up by the prepared
+// configuration, suid is set with the setuid workflow. The mount points
+// are registered and walked through in the order used to create the
+// container, without mounting them.
+func (e *EngineOperations) planContainer(suid bool) (*singularityConfig.ContainerPlan, error) {
+	cfg := e.EngineConfig
+	process := cfg.OciConfig.Process
+
+	plan := &singularityConfig.ContainerPlan{
+		Image:        cfg.GetImage(),
+		Starter:      "unprivileged",
+		Namespaces:   make([]string, 0),
+		Mounts:       make([]singularityConfig.PlannedMount, 0),
+		Args:         process.Args,
+		Cwd:          process.Cwd,
+		Env:          process.Env,
+		ContainerEnv: cfg.GetSingularityEnv(),
+		Security: singularityConfig.PlannedSecurity{
+			Fakeroot:   cfg.GetFakeroot(),
+			NoPrivs:    cfg.GetNoPrivs(),
+			KeepPrivs:  cfg.GetKeepPrivs(),
+			AllowSUID:  cfg.GetAllowSUID(),
+			NoNewPrivs: process.NoNewPrivileges,
+			Options:    cfg.GetSecurity(),
+			TargetUID:  cfg.GetTargetUID(),
+			TargetGID:  cfg.GetTargetGID(),
+			Cgroups:    cfg.GetCgroupsPath(),
+		},
+	}
+	if suid {
+		plan.Starter = "setuid"
+	}
+	if plan.Cwd == "" {
+		plan.Cwd = cfg.GetCwd()
+	}
+	if process.Capabilities != nil {
+		plan.Security.Capabilities = process.Capabilities.Effective
+	}
+	for _, l := range process.Rlimits {
+		plan.Security.Ulimits = append(plan.Security.Ulimits, fmt.Sprintf("%s=%d:%d", l.Type, l.Soft, l.Hard))
+	}
+	if linux := cfg.OciConfig.Linux; linux != nil {
+		for _, ns := range linux.Namespaces {
+			if ns.Type == specs.NetworkNamespace {
+				plan.Network = cfg.GetNetwork()
+			}
+			plan.Namespaces = append(plan.Namespaces, string(ns.Type))
+		}
+		plan.UIDMap = linux.UIDMappings
+		plan.GIDMap = linux.GIDMappings
+		plan.Security.Seccomp = linux.Seccomp != nil
+	}
+
+	// joining an instance doesn't mount anything
+	if cfg.GetInstanceJoin() {
+		return plan, nil
+	}
+
+	c := newContainer(e, nil, os.Getpid())
+	c.dryRun = true
+
+	system := &mount.System{
+		Points: &mount.Points{},
+		Mount: func(point *mount.Point, system *mount.System) error {
+			plan.Mounts = c.planMount(plan.Mounts, point, system.CurrentTag())
+			return nil
+		},
+	}
+	if _, err := c.addMounts(system); err != nil {
+		return nil, err
+	}
+	if err := system.MountAll(); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// planMount returns mounts with the mount point performed during
+// the stage tag, a remount point updates the options of the mount
+// point it applies to.
+func (c *container) planMount(mounts []singularityConfig.PlannedMount, point *mount.Point, tag mount.AuthorizedTag) []singularityConfig.PlannedMount {
+	dest := c.planPath(point.Destination)
+
+	options := make([]string, 0, len(point.Options))
+	remount := false
+	bind := false
+	for _, o := range point.Options {
+		switch o {
+		case "remount":
+			remount = true
+			continue
+		case "bind", "rbind":
+			bind = true
+		}
+		options = append(options, o)
+	}
+
+	if remount {
+		for i := len(mounts) - 1; i >= 0; i-- {
+			if mounts[i].Destination != dest {
+				continue
+			}
+		next:
+			for _, o := range options {
+				for _, mo := range mounts[i].Options {
+					if o == mo {
+						continue next
+					}
+				}
+				mounts[i].Options = append(mounts[i].Options, o)
+			}
+			break
+		}
+		return mounts
+	} else if point.Source == "" {
+		// propagation change
+		return mounts
+	}
+
+	source := point.Source
+	for _, img := range c.engine.EngineConfig.GetImageList() {
+		// image mount sources are the image file descriptors
+		if img.Source == source {
+			source = img.Path
+			break
+		}
+	}
+
+	fstype := point.Type
+	if fstype == "" && bind {
+		fstype = "bind"
+	}
+
+	return append(mounts, singularityConfig.PlannedMount{
+		Stage:       string(tag),
+		Source:      c.planPath(source),
+		Destination: dest,
+		Type:        fstype,
+		Options:     options,
+	})
+}
+
+// planPath returns path with the session directory replaced by
+// sessionSource.
+func (c *container) planPath(path string) string {
+	session := c.session.Path()
+	if path == session || strings.HasPrefix(path, session+"/") {
+		return sessionSource + strings.TrimPrefix(path, session)
+	}
+	return path
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcng/singularity/internal/pkg/runtime/engine/config/oci"
+	"github.com/hpcng/singularity/internal/pkg/runtime/engine/config/oci/generate"
+	"github.com/hpcng/singularity/pkg/image"
+	"github.com/hpcng/singularity/pkg/runtime/engine/config"
+	singularityConfig "github.com/hpcng/singularity/pkg/runtime/engine/singularity/config"
+	"github.com/hpcng/singularity/pkg/util/singularityconf"
+)
+
+func TestPlanContainer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plan-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	rootfs := filepath.Join(dir, "rootfs")
+	workdir := filepath.Join(dir, "workdir")
+	for _, d := range []string{rootfs, workdir} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatalf("failed to create directory: %s", err)
+		}
+	}
+
+	defer func(d string) { sessionDir = d }(sessionDir)
+	sessionDir, err = ioutil.TempDir(dir, "session-")
+	if err != nil {
+		t.Fatalf("failed to create session directory: %s", err)
+	}
+
+	img, err := image.Init(rootfs, false)
+	if err != nil {
+		t.Fatalf("failed to open image: %s", err)
+	}
+	defer img.File.Close()
+
+	file, err := singularityconf.GetConfig(nil)
+	if err != nil {
+		t.Fatalf("failed to get default configuration: %s", err)
+	}
+	file.BindPath = []string{"/etc/hosts", "/srv:/srv"}
+
+	newEngine := func(layer string) *EngineOperations {
+		cfg := singularityConfig.NewConfig()
+		cfg.File = file
+		cfg.OciConfig = &oci.Config{}
+		g := generate.New(&cfg.OciConfig.Spec)
+		g.SetProcessArgs([]string{"/bin/true"})
+		g.AddOrReplaceLinuxNamespace("mount", "")
+		g.AddOrReplaceLinuxNamespace("pid", "")
+		cfg.SetImage(rootfs)
+		cfg.SetImageList([]image.Image{*img})
+		cfg.SetSessionLayer(layer)
+		cfg.SetCwd(dir)
+		cfg.SetNoHome(true)
+		return &EngineOperations{
+			CommonConfig: &config.Common{EngineName: singularityConfig.Name},
+			EngineConfig: cfg,
+		}
+	}
+
+	// index returns the index of the mount at dest and its stage.
+	index := func(plan *singularityConfig.ContainerPlan, dest string) (int, string) {
+		for i, m := range plan.Mounts {
+			if m.Destination == dest {
+				return i, m.Stage
+			}
+		}
+		return -1, ""
+	}
+
+	for _, layer := range []string{singularityConfig.DefaultLayer, singularityConfig.UnderlayLayer} {
+		t.Run(layer, func(t *testing.T) {
+			e := newEngine(layer)
+			e.EngineConfig.SetBindPath([]singularityConfig.BindPath{
+				{Source: workdir, Destination: "/data", Options: map[string]*singularityConfig.BindOption{"ro": {}}},
+			})
+			plan, err := e.planContainer(true)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if plan.Starter != "setuid" || len(plan.Namespaces) != 2 || plan.Namespaces[1] != "pid" {
+				t.Errorf("unexpected starter %s and namespaces %v", plan.Starter, plan.Namespaces)
+			}
+
+			last := -1
+			for _, dest := range []string{sessionSource, sessionSource + "/rootfs", "/dev", "/etc/hosts", "/srv", "/proc", "/tmp", "/data", dir} {
+				i, stage := index(plan, dest)
+				if i < 0 {
+					t.Fatalf("no mount planned at %s: %v", dest, plan.Mounts)
+				} else if i < last {
+					t.Errorf("%s mounted at stage %s out of order", dest, stage)
+				}
+				last = i
+			}
+			if i, _ := index(plan, sessionSource+"/rootfs"); plan.Mounts[i].Source != rootfs {
+				t.Errorf("root filesystem planned from %s", plan.Mounts[i].Source)
+			}
+			if i, _ := index(plan, "/proc"); plan.Mounts[i].Type != "proc" {
+				t.Errorf("proc mounted with type %s in a PID namespace", plan.Mounts[i].Type)
+			}
+			i, _ := index(plan, "/data")
+			ro := false
+			for _, o := range plan.Mounts[i].Options {
+				ro = ro || o == "ro"
+			}
+			if !ro {
+				t.Errorf("read-only bind planned with options %v", plan.Mounts[i].Options)
+			}
+		})
+	}
+
+	t.Run("Contain", func(t *testing.T) {
+		e := newEngine(singularityConfig.DefaultLayer)
+		e.EngineConfig.SetContain(true)
+		e.EngineConfig.SetWorkdir(workdir)
+		plan, err := e.planContainer(false)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if plan.Starter != "unprivileged" {
+			t.Errorf("unexpected starter %s", plan.Starter)
+		}
+		if i, _ := index(plan, "/srv"); i >= 0 {
+			t.Errorf("configuration bind path planned with contain")
+		}
+		if i, _ := index(plan, dir); i >= 0 {
+			t.Errorf("current directory planned with contain")
+		}
+		if i, _ := index(plan, "/tmp"); i < 0 || plan.Mounts[i].Source != filepath.Join(workdir, "tmp") {
+			t.Errorf("/tmp not planned from the working directory")
+		}
+		if i, _ := index(plan, sessionSource+"/dev/shm"); i < 0 {
+			t.Errorf("no minimal /dev planned with contain")
+		}
+	})
+
+	// nothing is created by a dry run
+	for _, d := range []string{sessionDir, workdir, rootfs} {
+		entries, err := ioutil.ReadDir(d)
+		if err != nil {
+			t.Fatalf("failed to read directory %s: %s", d, err)
+		}
+		if len(entries) > 0 {
+			t.Errorf("%d entries created in %s by a dry run", len(entries), d)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hpcng/sif/pkg/integrity"
+	"github.com/hpcng/sif/pkg/sif"
+	"github.com/hpcng/singularity/internal/pkg/buildcfg"
+	"github.com/hpcng/singularity/internal/pkg/history"
+	"github.com/hpcng/singularity/internal/pkg/runtime/engine"
+	"github.com/hpcng/singularity/pkg/image"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/sypgp"
+)
+
+// historyRecord is the provenance record of the container run, it is
+// completed and written once the container process exits.
+var historyRecord *history.Record
+
+// historyStarted is closed once the image digest and signers of the
+// history record have been computed.
+var historyStarted chan struct{}
+
+// startHistory starts the provenance record of the container process
+// pid requested with --history, the image digest and signatures are
+// computed in the background as they may take a while for large images.
+func (e *EngineOperations) startHistory(pid int) {
+	if e.EngineConfig.GetHistoryDir() == "" || e.EngineConfig.GetInstanceJoin() {
+		return
+	}
+
+	started := time.Now()
+	r := &history.Record{
+		ID:      history.NewID(started, pid),
+		Image:   e.EngineConfig.GetImage(),
+		Signers: make([]string, 0),
+		Command: e.EngineConfig.OciConfig.Process.Args,
+		Cwd:     e.EngineConfig.OciConfig.Process.Cwd,
+		Binds:   make([]string, 0),
+		Flags:   e.EngineConfig.GetCLIFlags(),
+		Started: started,
+	}
+	if r.Flags == nil {
+		r.Flags = make([]string, 0)
+	}
+	if e.EngineConfig.GetInstance() {
+		r.Instance = e.CommonConfig.ContainerID
+	}
+	for _, b := range e.EngineConfig.GetBindPath() {
+		bind := b.Source + ":" + b.Destination
+		if _, ok := b.Options["ro"]; ok {
+			bind += ":ro"
+		}
+		r.Binds = append(r.Binds, bind)
+	}
+	historyRecord = r
+
+	historyStarted = make(chan struct{})
+	go func() {
+		defer close(historyStarted)
+
+		images := e.EngineConfig.GetImageList()
+		if len(images) == 0 {
+			return
+		}
+		digest, err := imageDigest(&images[0], e.digestAlgorithm())
+		if err != nil {
+			sylog.Debugf("Could not compute image digest: %s", err)
+		}
+		r.ImageDigest = digest
+
+		if images[0].Type == image.SIF {
+			signers, err := imageSigners(images[0].Path)
+			if err != nil {
+				sylog.Debugf("Could not verify image signatures: %s", err)
+			}
+			r.Signers = append(r.Signers, signers...)
+		}
+	}()
+}
+
+// writeHistory completes the provenance record with the exit information
+// info of the container process and writes it to the history directory.
+func (e *EngineOperations) writeHistory(info *engine.ExitInfo) {
+	if historyRecord == nil {
+		return
+	}
+	<-historyStarted
+
+	r := historyRecord
+	r.Finished = time.Now()
+	r.OOMKilled = info.OOMKilled
+	if s := info.Signal(); s != 0 {
+		r.Signal = s.String()
+	} else {
+		exitCode := info.ExitCode()
+		r.ExitCode = &exitCode
+	}
+	if err := history.Write(e.EngineConfig.GetHistoryDir(), r); err != nil {
+		sylog.Warningf("Could not record container run in history: %s", err)
+	}
+}
+
+// imageSigners returns the fingerprints of the entities which signed
+// all the objects of the SIF image at path, verified with the local and
+// global public keyrings.
+func imageSigners(path string) ([]string, error) {
+	f, err := sif.LoadContainer(path, true)
+	if err != nil {
+		return nil, fmt.Errorf("while loading image %s: %s", path, err)
+	}
+	defer f.UnloadContainer()
+
+	kr, err := sypgp.PublicKeyRing()
+	if err != nil {
+		return nil, fmt.Errorf("while loading public keyring: %s", err)
+	}
+	global := sypgp.NewHandle(buildcfg.SINGULARITY_CONFDIR, sypgp.GlobalHandleOpt())
+	gkr, err := global.LoadPubKeyring()
+	if err != nil {
+		return nil, fmt.Errorf("while loading global public keyring: %s", err)
+	}
+
+	v, err := integrity.NewVerifier(&f, integrity.OptVerifyWithKeyRing(sypgp.NewMultiKeyRing(gkr, kr)))
+	if err != nil {
+		return nil, err
+	}
+	if err := v.Verify(); err != nil {
+		return nil, err
+	}
+	fps, err := v.AllSignedBy()
+	if err != nil {
+		return nil, err
+	}
+	signers := make([]string, 0, len(fps))
+	for _, fp := range fps {
+		signers = append(signers, strings.ToUpper(hex.EncodeToString(fp[:])))
+	}
+	return signers, nil
+}
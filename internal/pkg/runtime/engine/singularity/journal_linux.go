@@ -0,0 +1,105 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/hpcng/singularity/internal/pkg/journal"
+	"github.com/hpcng/singularity/internal/pkg/util/priv"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/util/loop"
+)
+
+// openJournal releases the resources leaked by the previous containers
+// of the user and opens the journal recording the resources created for
+// this container.
+func (c *container) openJournal() error {
+	// journal directory is only writable by root, resources
+	// are only tracked with the setuid workflow or as root
+	if err := priv.Escalate(); err != nil {
+		priv.Drop()
+		sylog.Debugf("Container resources not journaled without setuid workflow")
+		return nil
+	}
+	defer priv.Drop()
+
+	if err := journal.RecoverResources(os.Getuid()); err != nil {
+		sylog.Warningf("Could not release resources of previous containers: %s", err)
+	}
+
+	j, err := journal.Open(journal.Dir, os.Getuid(), os.Getpid())
+	if err != nil {
+		return err
+	}
+	resourceJournal = j
+
+	return nil
+}
+
+// record adds the resource to the container journal, data is
+// the resource type specific data if not nil.
+func record(resource string, path string, data interface{}) {
+	if resourceJournal == nil {
+		return
+	}
+
+	e := journal.Entry{Type: resource, Path: path}
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			sylog.Warningf("Could not encode %s journal entry: %s", resource, err)
+			return
+		}
+		e.Data = b
+	}
+	if err := resourceJournal.Record(e); err != nil {
+		sylog.Warningf("Could not record %s %s: %s", resource, path, err)
+	}
+}
+
+// recordLoop adds the loop device path with the identity of its
+// backing image to the container journal.
+func recordLoop(path string) {
+	if resourceJournal == nil {
+		return
+	}
+
+	priv.Escalate()
+	f, err := os.Open(path)
+	priv.Drop()
+	if err != nil {
+		sylog.Warningf("Could not record loop device %s: %s", path, err)
+		return
+	}
+	// the device must not be held open to not prevent
+	// its automatic release
+	info, err := loop.GetStatusFromFd(f.Fd())
+	f.Close()
+	if err != nil {
+		sylog.Warningf("Could not record loop device %s: %s", path, err)
+		return
+	}
+	record(journal.Loop, path, journal.LoopData{
+		Device: info.Device,
+		Inode:  info.Inode,
+		Offset: info.Offset,
+	})
+}
+
+// closeJournal removes the container journal once all the container
+// resources were released.
+func closeJournal() {
+	if resourceJournal == nil {
+		return
+	}
+	priv.Escalate()
+	if err := resourceJournal.Close(); err != nil {
+		sylog.Errorf("could not close resource journal: %s", err)
+	}
+	priv.Drop()
+}
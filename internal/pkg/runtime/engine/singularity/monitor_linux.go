@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2019, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -11,7 +11,9 @@ import (
 	"syscall"
 
 	"github.com/hpcng/singularity/internal/pkg/plugin"
+	"github.com/hpcng/singularity/internal/pkg/runtime/engine"
 	singularitycallback "github.com/hpcng/singularity/pkg/plugin/callback/runtime/engine/singularity"
+	"github.com/hpcng/singularity/pkg/sylog"
 )
 
 // MonitorContainer is called from master once the container has
@@ -25,47 +27,60 @@ import (
 // Particularly here no additional privileges are gained as monitor does
 // not need them for wait4 and kill syscalls.
 func (e *EngineOperations) MonitorContainer(pid int, signals chan os.Signal) (syscall.WaitStatus, error) {
-	var status syscall.WaitStatus
+	info, err := e.WaitContainer(pid, signals)
+	return info.Status, err
+}
 
+// WaitContainer is called from master once the container has been
+// spawned, it blocks until the container exits and returns the container
+// process exit information. Signals received are propagated to the
+// container process if signal propagation is enabled.
+//
+// No additional privileges are gained as for MonitorContainer.
+func (e *EngineOperations) WaitContainer(pid int, signals chan os.Signal) (*engine.ExitInfo, error) {
 	callbackType := (singularitycallback.MonitorContainer)(nil)
 	callbacks, err := plugin.LoadCallbacks(callbackType)
 	if err != nil {
-		return status, fmt.Errorf("while loading plugins callbacks '%T': %s", callbackType, err)
+		return &engine.ExitInfo{Pid: pid}, fmt.Errorf("while loading plugins callbacks '%T': %s", callbackType, err)
 	}
 	if len(callbacks) > 1 {
-		return status, fmt.Errorf("multiple plugins have registered callback for '%T'", callbackType)
+		return &engine.ExitInfo{Pid: pid}, fmt.Errorf("multiple plugins have registered callback for '%T'", callbackType)
 	} else if len(callbacks) == 1 {
-		return callbacks[0].(singularitycallback.MonitorContainer)(e.CommonConfig, pid, signals)
+		status, err := callbacks[0].(singularitycallback.MonitorContainer)(e.CommonConfig, pid, signals)
+		return &engine.ExitInfo{Pid: pid, Status: status}, err
 	}
 
-	for {
-		s := <-signals
-		switch s {
-		case syscall.SIGCHLD:
-			if wpid, err := syscall.Wait4(pid, &status, syscall.WNOHANG, nil); err != nil {
-				return status, fmt.Errorf("error while waiting child: %s", err)
-			} else if wpid != pid {
-				continue
+	info, err := engine.WaitProcess(pid, signals, func(s syscall.Signal) error {
+		if e.EngineConfig.GetSignalPropagation() {
+			if err := syscall.Kill(pid, s); err != nil {
+				return fmt.Errorf("interrupted by signal %s", s.String())
 			}
-			return status, nil
-		case syscall.SIGURG:
-			// Ignore SIGURG, which is used for non-cooperative goroutine
-			// preemption starting with Go 1.14. For more information, see
-			// https://github.com/golang/go/issues/24543.
-			break
-		default:
-			if e.EngineConfig.GetSignalPropagation() {
-				if err := syscall.Kill(pid, s.(syscall.Signal)); err != nil {
-					return status, fmt.Errorf("interrupted by signal %s", s.String())
-				}
-			}
-			// Handle CTRL-Z and send ourself a SIGSTOP to implicitly send SIGCHLD
-			// signal to parent process as this process is the direct child
-			if s == syscall.SIGTSTP {
-				if err := syscall.Kill(os.Getpid(), syscall.SIGSTOP); err != nil {
-					return status, fmt.Errorf("received SIGTSTP but was not able to stop")
-				}
+		}
+		// Handle CTRL-Z and send ourself a SIGSTOP to implicitly send SIGCHLD
+		// signal to parent process as this process is the direct child
+		if s == syscall.SIGTSTP {
+			if err := syscall.Kill(os.Getpid(), syscall.SIGSTOP); err != nil {
+				return fmt.Errorf("received SIGTSTP but was not able to stop")
 			}
 		}
+		return nil
+	})
+
+	// the instance configuration must not change from now
+	stopConfigWatch()
+
+	// the cgroup is still there, it's removed during cleanup
+	if err == nil && cgroupManager != nil {
+		if n, err := cgroupManager.OOMKills(); err != nil {
+			sylog.Debugf("Could not read cgroup OOM kill events: %s", err)
+		} else {
+			info.OOMKilled = n > 0
+		}
+	}
+	if err == nil {
+		e.reportRusage(info)
+		e.sendAccountingStop(info)
+		e.writeHistory(info)
 	}
+	return info, err
 }
@@ -0,0 +1,141 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/hpcng/singularity/internal/pkg/ocihooks"
+	"github.com/hpcng/singularity/internal/pkg/util/exec"
+	"github.com/hpcng/singularity/internal/pkg/util/fs/mount"
+	"github.com/hpcng/singularity/pkg/sylog"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// hooksPid is the container process PID passed to the OCI hooks.
+var hooksPid int
+
+// prepareOCIHooks records the commands, environment variables and mounts of
+// the site OCI hooks matching the container in the engine configuration.
+// It's called during stage 1, hooks and mounts passed by the user are
+// discarded. Variables set with --env take precedence over the hooks ones.
+func (e *EngineOperations) prepareOCIHooks() error {
+	e.EngineConfig.OciConfig.Hooks = nil
+	e.EngineConfig.SetHookMounts(nil)
+
+	dirs := e.EngineConfig.File.OCIHooksDir
+	if len(dirs) == 0 || e.EngineConfig.GetInstanceJoin() {
+		return nil
+	}
+	hooks, err := ocihooks.Load(dirs...)
+	if err != nil {
+		return fmt.Errorf("while loading OCI hooks: %s", err)
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	var labels map[string]string
+	if images := e.EngineConfig.GetImageList(); len(images) > 0 {
+		labels, err = images[0].Labels()
+		if err != nil {
+			sylog.Debugf("Could not read image labels: %s", err)
+		}
+	}
+	// match the command executed by the exec action script
+	command := e.EngineConfig.OciConfig.Process.Args
+	if len(command) > 1 && command[0] == "/.singularity.d/actions/exec" {
+		command = command[1:]
+	}
+
+	matched := ocihooks.Matching(hooks, &ocihooks.Container{
+		Annotations:   e.EngineConfig.OciConfig.Annotations,
+		Labels:        labels,
+		Command:       command,
+		HasBindMounts: len(e.EngineConfig.GetBindPath()) > 0,
+	})
+	if len(matched) == 0 {
+		return nil
+	}
+
+	spec := &specs.Spec{Process: &specs.Process{}}
+	ocihooks.Apply(matched, spec)
+	e.EngineConfig.OciConfig.Hooks = spec.Hooks
+	e.EngineConfig.SetHookMounts(spec.Mounts)
+
+	env := e.EngineConfig.GetSingularityEnv()
+	if env == nil {
+		env = make(map[string]string)
+	}
+	for _, kv := range spec.Process.Env {
+		v := strings.SplitN(kv, "=", 2)
+		if _, ok := env[v[0]]; !ok {
+			env[v[0]] = v[1]
+		}
+	}
+	e.EngineConfig.SetSingularityEnv(env)
+
+	return nil
+}
+
+// runOCIHooks executes the OCI hooks of a lifecycle stage from the master
+// process, the container process is reported with status.
+func (e *EngineOperations) runOCIHooks(ctx context.Context, hooks []specs.Hook, status specs.ContainerState, stopOnError bool) []error {
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	id := e.CommonConfig.ContainerID
+	if id == "" {
+		id = strconv.Itoa(hooksPid)
+	}
+	state := &specs.State{
+		Version:     specs.Version,
+		ID:          id,
+		Status:      status,
+		Pid:         hooksPid,
+		Bundle:      e.EngineConfig.GetImage(),
+		Annotations: e.EngineConfig.OciConfig.Annotations,
+	}
+
+	opts := exec.HookOptions{
+		Stdout:      os.Stderr,
+		Stderr:      os.Stderr,
+		StopOnError: stopOnError,
+	}
+	return exec.Hooks(ctx, hooks, state, opts)
+}
+
+// addHookMounts adds the bind mounts requested by the site OCI hooks.
+func (c *container) addHookMounts(system *mount.System) error {
+	for _, m := range c.engine.EngineConfig.GetHookMounts() {
+		if _, err := os.Stat(m.Source); err != nil {
+			sylog.Warningf("Skipping OCI hook mount %s: %s", m.Source, err)
+			continue
+		}
+
+		flags := uintptr(syscall.MS_BIND | c.suidFlag | syscall.MS_NODEV | syscall.MS_REC)
+		for _, opt := range m.Options {
+			if opt == "ro" {
+				flags |= syscall.MS_RDONLY
+			}
+		}
+
+		sylog.Debugf("Adding OCI hook mount %s to mount list", m.Source)
+		if err := system.Points.AddBind(mount.BindsTag, m.Source, m.Destination, flags); err != nil {
+			return fmt.Errorf("unable to add %s to mount list: %s", m.Source, err)
+		}
+		if err := system.Points.AddRemount(mount.BindsTag, m.Destination, flags); err != nil {
+			return fmt.Errorf("unable to add %s for remount: %s", m.Destination, err)
+		}
+	}
+	return nil
+}
@@ -23,10 +23,14 @@ import (
 	fakerootutil "github.com/hpcng/singularity/internal/pkg/fakeroot"
 	"github.com/hpcng/singularity/internal/pkg/instance"
 	"github.com/hpcng/singularity/internal/pkg/plugin"
+	"github.com/hpcng/singularity/internal/pkg/quarantine"
 	"github.com/hpcng/singularity/internal/pkg/runtime/engine/config/starter"
 	"github.com/hpcng/singularity/internal/pkg/security"
+	"github.com/hpcng/singularity/internal/pkg/security/landlock"
 	"github.com/hpcng/singularity/internal/pkg/security/seccomp"
 	"github.com/hpcng/singularity/internal/pkg/syecl"
+	"github.com/hpcng/singularity/internal/pkg/util/digest"
+	"github.com/hpcng/singularity/internal/pkg/util/env"
 	"github.com/hpcng/singularity/internal/pkg/util/fs"
 	"github.com/hpcng/singularity/internal/pkg/util/fs/overlay"
 	"github.com/hpcng/singularity/internal/pkg/util/mainthread"
@@ -56,6 +60,18 @@ var nsProcName = map[specs.LinuxNamespaceType]string{
 	specs.UserNamespace:    "user",
 }
 
+// configurationFile returns the path of singularity.conf, a custom
+// configuration file is only allowed as root or with an unprivileged
+// installation.
+func (e *EngineOperations) configurationFile() string {
+	if buildcfg.SINGULARITY_SUID_INSTALL == 0 || os.Geteuid() == 0 {
+		if configFile := e.EngineConfig.GetConfigurationFile(); configFile != "" {
+			return configFile
+		}
+	}
+	return buildcfg.SINGULARITY_CONF_FILE
+}
+
 // PrepareConfig is called during stage1 to validate and prepare
 // container configuration. It is responsible for singularity
 // configuration file parsing, handling user input, reading capabilities,
@@ -74,13 +90,7 @@ func (e *EngineOperations) PrepareConfig(starterConfig *starter.Config) error {
 		return fmt.Errorf("bad engine configuration provided")
 	}
 
-	configurationFile := buildcfg.SINGULARITY_CONF_FILE
-	if buildcfg.SINGULARITY_SUID_INSTALL == 0 || os.Geteuid() == 0 {
-		configFile := e.EngineConfig.GetConfigurationFile()
-		if configFile != "" {
-			configurationFile = configFile
-		}
-	}
+	configurationFile := e.configurationFile()
 
 	e.EngineConfig.File, err = singularityconf.Parse(configurationFile)
 	if err != nil {
@@ -126,6 +136,17 @@ func (e *EngineOperations) PrepareConfig(starterConfig *starter.Config) error {
 		return fmt.Errorf("container process arguments not found")
 	}
 
+	if err := e.prepareUlimits(); err != nil {
+		return err
+	}
+
+	// the environment variables policy set in singularity.conf overrides
+	// any policy passed to the engine
+	if err := env.CheckPolicy(e.EngineConfig.File.EnvDeny, e.EngineConfig.File.EnvAllow); err != nil {
+		return err
+	}
+	e.EngineConfig.SetEnvPolicy(e.EngineConfig.File.EnvDeny, e.EngineConfig.File.EnvAllow)
+
 	uid := e.EngineConfig.GetTargetUID()
 	gids := e.EngineConfig.GetTargetGID()
 
@@ -148,8 +169,13 @@ func (e *EngineOperations) PrepareConfig(starterConfig *starter.Config) error {
 		}
 	}
 
+	if err := e.prepareOCIHooks(); err != nil {
+		return err
+	}
+
 	starterConfig.SetMasterPropagateMount(true)
 	starterConfig.SetNoNewPrivs(e.EngineConfig.OciConfig.Process.NoNewPrivileges)
+	starterConfig.SetSecurityAudit(e.EngineConfig.GetSecurityAudit())
 
 	if e.EngineConfig.OciConfig.Process != nil && e.EngineConfig.OciConfig.Process.Capabilities != nil {
 		starterConfig.SetCapabilities(capabilities.Permitted, e.EngineConfig.OciConfig.Process.Capabilities.Permitted)
@@ -162,6 +188,11 @@ func (e *EngineOperations) PrepareConfig(starterConfig *starter.Config) error {
 	// determine if engine need to propagate signals across processes
 	e.checkSignalPropagation()
 
+	// the container isn't created with a dry run
+	if e.EngineConfig.GetDryRun() {
+		return e.dryRun(starterConfig)
+	}
+
 	// We must call this here because at this point we haven't
 	// spawned the master process nor the RPC server. The assumption
 	// is that this function runs in stage 1 and that even if it's a
@@ -200,15 +231,9 @@ func (e *EngineOperations) prepareUserCaps(enforced bool) error {
 
 	e.EngineConfig.OciConfig.SetProcessNoNewPrivileges(true)
 
-	file, err := os.OpenFile(buildcfg.CAPABILITY_FILE, os.O_RDONLY, 0644)
-	if err != nil {
-		return fmt.Errorf("while opening capability config file: %s", err)
-	}
-	defer file.Close()
-
-	capConfig, err := capabilities.ReadFrom(file)
+	capConfig, err := readCapabilityConfig()
 	if err != nil {
-		return fmt.Errorf("while parsing capability config data: %s", err)
+		return err
 	}
 
 	pw, err := user.Current()
@@ -222,6 +247,12 @@ func (e *EngineOperations) prepareUserCaps(enforced bool) error {
 	}
 	caps = append(caps, e.EngineConfig.OciConfig.Process.Capabilities.Permitted...)
 
+	profileCaps, err := e.getProfileCaps(capConfig)
+	if err != nil {
+		return err
+	}
+	caps = append(caps, profileCaps...)
+
 	if enforced {
 		authorizedCaps, unauthorizedCaps := capConfig.CheckUserCaps(pw.Name, caps)
 		if len(authorizedCaps) > 0 {
@@ -296,6 +327,43 @@ func (e *EngineOperations) prepareUserCaps(enforced bool) error {
 	return nil
 }
 
+// readCapabilityConfig reads the capability configuration file.
+func readCapabilityConfig() (*capabilities.Config, error) {
+	file, err := os.OpenFile(buildcfg.CAPABILITY_FILE, os.O_RDONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("while opening capability config file: %s", err)
+	}
+	defer file.Close()
+
+	capConfig, err := capabilities.ReadFrom(file)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing capability config data: %s", err)
+	}
+	return capConfig, nil
+}
+
+// getProfileCaps returns the capabilities of the profiles requested
+// with --security capabilities:<profile>[,<profile>...].
+func (e *EngineOperations) getProfileCaps(capConfig *capabilities.Config) ([]string, error) {
+	param := security.GetParam(e.EngineConfig.GetSecurity(), "capabilities")
+	if param == "" {
+		return nil, nil
+	}
+
+	var caps []string
+
+	for _, profile := range strings.Split(param, ",") {
+		profile = strings.TrimSpace(profile)
+		profileCaps := capConfig.ListProfileCaps(profile)
+		if len(profileCaps) == 0 {
+			return nil, fmt.Errorf("capability profile %s is not defined", profile)
+		}
+		sylog.Debugf("Capability profile %s requested: %s", profile, strings.Join(profileCaps, ","))
+		caps = append(caps, profileCaps...)
+	}
+	return caps, nil
+}
+
 // prepareRootCaps is responsible for setting root capabilities
 // based on capability/configuration files and requested capabilities.
 func (e *EngineOperations) prepareRootCaps() error {
@@ -326,15 +394,9 @@ func (e *EngineOperations) prepareRootCaps() error {
 		e.EngineConfig.OciConfig.SetupPrivileged(true)
 		commonCaps = e.EngineConfig.OciConfig.Process.Capabilities.Permitted
 	case "file":
-		file, err := os.OpenFile(buildcfg.CAPABILITY_FILE, os.O_RDONLY, 0644)
+		capConfig, err := readCapabilityConfig()
 		if err != nil {
-			return fmt.Errorf("while opening capability config file: %s", err)
-		}
-		defer file.Close()
-
-		capConfig, err := capabilities.ReadFrom(file)
-		if err != nil {
-			return fmt.Errorf("while parsing capability config data: %s", err)
+			return err
 		}
 
 		commonCaps = append(commonCaps, capConfig.ListUserCaps("root")...)
@@ -362,6 +424,17 @@ func (e *EngineOperations) prepareRootCaps() error {
 	if len(ignoredCaps) > 0 {
 		sylog.Warningf("won't add unknown capability: %s", strings.Join(ignoredCaps, ","))
 	}
+	if security.GetParam(e.EngineConfig.GetSecurity(), "capabilities") != "" {
+		capConfig, err := readCapabilityConfig()
+		if err != nil {
+			return err
+		}
+		profileCaps, err := e.getProfileCaps(capConfig)
+		if err != nil {
+			return err
+		}
+		caps = append(caps, profileCaps...)
+	}
 	for _, cap := range caps {
 		found := false
 		for _, c := range commonCaps {
@@ -454,7 +527,7 @@ func (e *EngineOperations) prepareAutofs(starterConfig *starter.Config) error {
 	}
 
 	if !e.EngineConfig.GetContain() {
-		for _, bindpath := range e.EngineConfig.File.BindPath {
+		for _, bindpath := range e.configBindPaths() {
 			splitted := strings.Split(bindpath, ":")
 
 			fd, err := keepAutofsMount(splitted[0], autoFsPoints)
@@ -507,6 +580,10 @@ func (e *EngineOperations) prepareAutofs(starterConfig *starter.Config) error {
 // prepareContainerConfig is responsible for getting and applying
 // user supplied configuration for container creation.
 func (e *EngineOperations) prepareContainerConfig(starterConfig *starter.Config) error {
+	if err := e.expandUserBindPaths(); err != nil {
+		return err
+	}
+
 	// always set mount namespace
 	e.EngineConfig.OciConfig.AddOrReplaceLinuxNamespace(specs.MountNamespace, "")
 
@@ -623,23 +700,74 @@ func (e *EngineOperations) prepareContainerConfig(starterConfig *starter.Config)
 		e.EngineConfig.OciConfig.SetProcessApparmorProfile(param)
 	}
 	param = security.GetParam(e.EngineConfig.GetSecurity(), "seccomp")
-	if param != "" {
+	if param == seccomp.UnconfinedProfileName {
+		sylog.Debugf("Seccomp filtering disabled by user")
+	} else if param != "" {
 		sylog.Debugf("Applying seccomp rule from %s", param)
 		generator := &e.EngineConfig.OciConfig.Generator
 		if err := seccomp.LoadProfileFromFile(param, generator); err != nil {
 			return err
 		}
+	} else if err := e.loadDefaultSeccompProfile(); err != nil {
+		return err
+	}
+	param = security.GetParam(e.EngineConfig.GetSecurity(), "landlock")
+	if param != "" {
+		sylog.Debugf("Applying Landlock profile from %s", param)
+		profile, err := landlock.LoadProfileFromFile(param)
+		if err != nil {
+			return err
+		}
+		e.EngineConfig.SetLandlockProfile(profile)
 	}
 
 	// open file descriptors (autofs bug path)
 	return e.prepareAutofs(starterConfig)
 }
 
+// loadDefaultSeccompProfile applies the seccomp profile set in
+// singularity.conf with the configured allowed/denied system calls.
+func (e *EngineOperations) loadDefaultSeccompProfile() error {
+	profile := e.EngineConfig.File.SeccompProfile
+	if profile == "" || profile == seccomp.UnconfinedProfileName {
+		return nil
+	}
+	// don't warn about missing seccomp support for the default profile
+	if !seccomp.Enabled() {
+		sylog.Debugf("Seccomp support not enabled, ignoring %s seccomp profile", profile)
+		return nil
+	}
+
+	generator := &e.EngineConfig.OciConfig.Generator
+	if profile == seccomp.DefaultProfileName {
+		sylog.Debugf("Applying builtin seccomp profile")
+		if generator.Config.Linux == nil {
+			generator.Config.Linux = &specs.Linux{}
+		}
+		generator.Config.Linux.Seccomp = seccomp.DefaultProfile()
+	} else {
+		if !filepath.IsAbs(profile) {
+			return fmt.Errorf("seccomp profile %s in configuration file must be an absolute path", profile)
+		}
+		sylog.Debugf("Applying seccomp profile from %s", profile)
+		if err := seccomp.LoadProfileFromFile(profile, generator); err != nil {
+			return fmt.Errorf("while loading seccomp profile %s: %s", profile, err)
+		}
+	}
+
+	seccomp.Customize(
+		generator.Config.Linux.Seccomp,
+		e.EngineConfig.File.SeccompAllow,
+		e.EngineConfig.File.SeccompDeny,
+	)
+	return nil
+}
+
 // prepareInstanceJoinConfig is responsible for getting and
 // applying configuration to join a running instance.
 func (e *EngineOperations) prepareInstanceJoinConfig(starterConfig *starter.Config) error {
 	name := instance.ExtractName(e.EngineConfig.GetImage())
-	file, err := instance.Get(name, instance.SingSubDir)
+	file, err := instance.Find(name, instance.SingSubDir)
 	if err != nil {
 		return err
 	}
@@ -648,6 +776,18 @@ func (e *EngineOperations) prepareInstanceJoinConfig(starterConfig *starter.Conf
 	gid := os.Getgid()
 	suidRequired := uid != 0 && !file.UserNs
 
+	// system instance processes are owned by root, users
+	// must be allowed to join them
+	ownerUID := uid
+	ownerGID := gid
+	if file.System {
+		if !file.Authorized() {
+			return fmt.Errorf("not authorized to join system instance %s", name)
+		}
+		ownerUID = 0
+		ownerGID = 0
+	}
+
 	// basic checks:
 	// 1. a user must not use SUID workflow to join an instance
 	//    started with user namespace
@@ -743,8 +883,8 @@ func (e *EngineOperations) prepareInstanceJoinConfig(starterConfig *starter.Conf
 			return fmt.Errorf("error while getting information for instance task directory: %s", err)
 		}
 		st := fi.Sys().(*syscall.Stat_t)
-		if st.Uid != uint32(uid) || st.Gid != uint32(gid) {
-			return fmt.Errorf("instance process owned by %d:%d instead of %d:%d", st.Uid, st.Gid, uid, gid)
+		if st.Uid != uint32(ownerUID) || st.Gid != uint32(ownerGID) {
+			return fmt.Errorf("instance process owned by %d:%d instead of %d:%d", st.Uid, st.Gid, ownerUID, ownerGID)
 		}
 
 		ppid := -1
@@ -785,8 +925,8 @@ func (e *EngineOperations) prepareInstanceJoinConfig(starterConfig *starter.Conf
 			return fmt.Errorf("error while getting information for parent task directory: %s", err)
 		}
 		st = fi.Sys().(*syscall.Stat_t)
-		if st.Uid != uint32(uid) || st.Gid != uint32(gid) {
-			return fmt.Errorf("parent instance process owned by %d:%d instead of %d:%d", st.Uid, st.Gid, uid, gid)
+		if st.Uid != uint32(ownerUID) || st.Gid != uint32(ownerGID) {
+			return fmt.Errorf("parent instance process owned by %d:%d instead of %d:%d", st.Uid, st.Gid, ownerUID, ownerGID)
 		}
 
 		path, err = filepath.Abs("comm")
@@ -879,7 +1019,12 @@ func (e *EngineOperations) prepareInstanceJoinConfig(starterConfig *starter.Conf
 
 	// restore seccomp filter or apply a new one if provided
 	param = security.GetParam(e.EngineConfig.GetSecurity(), "seccomp")
-	if param != "" {
+	if param == seccomp.UnconfinedProfileName {
+		sylog.Debugf("Seccomp filtering disabled by user")
+		if e.EngineConfig.OciConfig.Linux != nil {
+			e.EngineConfig.OciConfig.Linux.Seccomp = nil
+		}
+	} else if param != "" {
 		sylog.Debugf("Applying seccomp rule from %s", param)
 		generator := &e.EngineConfig.OciConfig.Generator
 		if err := seccomp.LoadProfileFromFile(param, generator); err != nil {
@@ -892,6 +1037,19 @@ func (e *EngineOperations) prepareInstanceJoinConfig(starterConfig *starter.Conf
 		e.EngineConfig.OciConfig.Linux.Seccomp = instanceEngineConfig.OciConfig.Linux.Seccomp
 	}
 
+	// restore landlock profile or apply a new one if provided
+	param = security.GetParam(e.EngineConfig.GetSecurity(), "landlock")
+	if param != "" {
+		sylog.Debugf("Applying Landlock profile from %s", param)
+		profile, err := landlock.LoadProfileFromFile(param)
+		if err != nil {
+			return err
+		}
+		e.EngineConfig.SetLandlockProfile(profile)
+	} else {
+		e.EngineConfig.SetLandlockProfile(instanceEngineConfig.GetLandlockProfile())
+	}
+
 	if uid == 0 && !file.UserNs {
 		pid := os.Getppid()
 		path := fmt.Sprintf("/singularity/%d", file.Pid)
@@ -1118,6 +1276,20 @@ func (e *EngineOperations) loadImages(starterConfig *starter.Config) error {
 		return err
 	}
 
+	// squashfs overlay partitions, like the delta partitions created by
+	// build --delta-from, are only applied by the overlay layer
+	if img.Type == image.SIF && e.EngineConfig.GetSessionLayer() != singularityConfig.OverlayLayer {
+		overlays, err := img.GetOverlayPartitions()
+		if err != nil {
+			return fmt.Errorf("while getting overlay partitions in %s: %s", img.Path, err)
+		}
+		for _, o := range overlays {
+			if o.Type == image.SQUASHFS {
+				return fmt.Errorf("%s stores changes in a squashfs overlay partition which requires overlay support, it can't be used with the %q session layer", img.Path, e.EngineConfig.GetSessionLayer())
+			}
+		}
+	}
+
 	// first image is always the root filesystem
 	images = append(images, *img)
 	writableOverlayPath := ""
@@ -1345,6 +1517,18 @@ func (e *EngineOperations) loadImage(path string, writable bool) (*image.Image,
 		imgObject.Path = finalTarget
 	}
 
+	// only image files released from quarantine can be used, their
+	// digest is computed from the opened file
+	if e.EngineConfig.File.PullQuarantine && imgObject.Type != image.SANDBOX {
+		a, err := digest.Algorithm(e.EngineConfig.File.DigestAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		if err := quarantine.Check(imgObject.File, imgObject.Path, e.EngineConfig.File.QuarantineDir, buildcfg.QUARANTINEDIR, a); err != nil {
+			return nil, err
+		}
+	}
+
 	if len(e.EngineConfig.File.LimitContainerPaths) != 0 {
 		if authorized, err := imgObject.AuthorizedPath(e.EngineConfig.File.LimitContainerPaths); err != nil {
 			return nil, err
@@ -31,6 +31,7 @@ import (
 	"github.com/hpcng/singularity/internal/pkg/instance"
 	"github.com/hpcng/singularity/internal/pkg/plugin"
 	"github.com/hpcng/singularity/internal/pkg/security"
+	"github.com/hpcng/singularity/internal/pkg/security/landlock"
 	"github.com/hpcng/singularity/internal/pkg/util/env"
 	"github.com/hpcng/singularity/internal/pkg/util/fs/files"
 	"github.com/hpcng/singularity/internal/pkg/util/machine"
@@ -38,6 +39,7 @@ import (
 	"github.com/hpcng/singularity/internal/pkg/util/shell/interpreter"
 	"github.com/hpcng/singularity/internal/pkg/util/user"
 	singularitycallback "github.com/hpcng/singularity/pkg/plugin/callback/runtime/engine/singularity"
+	"github.com/hpcng/singularity/pkg/runtime/engine/config"
 	singularityConfig "github.com/hpcng/singularity/pkg/runtime/engine/singularity/config"
 	"github.com/hpcng/singularity/pkg/sylog"
 	"github.com/hpcng/singularity/pkg/util/rlimit"
@@ -140,13 +142,12 @@ func (e *EngineOperations) StartProcess(masterConnFd int) error {
 		}
 	}
 
-	// restore the stack size limit for setuid workflow
+	// set the resource limits requested with --ulimit or set in
+	// singularity.conf, and restore the stack size limit for setuid
+	// workflow
 	for _, limit := range e.EngineConfig.OciConfig.Process.Rlimits {
-		if limit.Type == "RLIMIT_STACK" {
-			if err := rlimit.Set(limit.Type, limit.Soft, limit.Hard); err != nil {
-				return fmt.Errorf("while restoring stack size limit: %s", err)
-			}
-			break
+		if err := rlimit.Set(limit.Type, limit.Soft, limit.Hard); err != nil {
+			return fmt.Errorf("while setting resource limits: %s", err)
 		}
 	}
 
@@ -154,6 +155,25 @@ func (e *EngineOperations) StartProcess(masterConnFd int) error {
 		return fmt.Errorf("failed to apply security configuration: %s", err)
 	}
 
+	// landlock ruleset is applied once all container paths are set up,
+	// the main thread is locked so it applies to the container process
+	if profile := e.EngineConfig.GetLandlockProfile(); len(profile) > 0 {
+		if landlock.Enabled() {
+			if err := landlock.Apply(profile); err != nil {
+				return fmt.Errorf("failed to apply landlock profile: %s", err)
+			}
+		} else {
+			p, err := landlock.ParseProfile(profile)
+			if err != nil {
+				return err
+			}
+			if !p.BestEffort {
+				return fmt.Errorf("landlock requested but not supported or enabled by kernel, requires kernel >= 5.13")
+			}
+			sylog.Warningf("landlock requested but not supported or enabled by kernel, running without landlock restrictions as allowed by the best effort profile")
+		}
+	}
+
 	// If necessary, set the umask that was saved from the calling environment
 	// https://github.com/hpcng/singularity/issues/5214
 	if e.EngineConfig.GetRestoreUmask() {
@@ -163,12 +183,12 @@ func (e *EngineOperations) StartProcess(masterConnFd int) error {
 
 	if (!isInstance && !shimProcess) || bootInstance || e.EngineConfig.GetInstanceJoin() {
 		args := e.EngineConfig.OciConfig.Process.Args
-		env := e.EngineConfig.OciConfig.Process.Env
+		environ := e.EngineConfig.OciConfig.Process.Env
 
 		if !bootInstance {
 			var err error
 
-			args, env, err = runActionScript(e.EngineConfig)
+			args, environ, err = runActionScript(e.EngineConfig)
 			if err != nil {
 				return err
 			} else if len(args) == 0 {
@@ -177,24 +197,33 @@ func (e *EngineOperations) StartProcess(masterConnFd int) error {
 			}
 		}
 
-		return e.execProcess(args, env)
+		deny, allow := e.EngineConfig.GetEnvPolicy()
+		environ, err := env.ApplyPolicy(environ, deny, allow)
+		if err != nil {
+			return err
+		}
+		return e.execProcess(args, environ)
 	}
 
 	errChan := make(chan error, 1)
 	statusChan := make(chan syscall.WaitStatus, 1)
 	cmdPid := -2
 
-	args, env, err := runActionScript(e.EngineConfig)
+	args, environ, err := runActionScript(e.EngineConfig)
 	if err != nil {
 		return err
 	} else if len(args) > 0 {
+		deny, allow := e.EngineConfig.GetEnvPolicy()
+		if environ, err = env.ApplyPolicy(environ, deny, allow); err != nil {
+			return err
+		}
 	cmdexec:
 		// Spawn and wait container process, signal handler
 		cmd := exec.Command(args[0], args[1:]...)
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 		cmd.Stdin = os.Stdin
-		cmd.Env = env
+		cmd.Env = environ
 		cmd.SysProcAttr = &syscall.SysProcAttr{
 			Setpgid: isInstance,
 		}
@@ -333,6 +362,19 @@ func (e *EngineOperations) PostStartProcess(ctx context.Context, pid int) error
 		}
 	}
 
+	if err := e.writeRunFiles(pid); err != nil {
+		return err
+	}
+
+	if hooks := e.EngineConfig.OciConfig.Hooks; hooks != nil {
+		for _, err := range e.runOCIHooks(ctx, hooks.Poststart, specs.StateRunning, false) {
+			sylog.Warningf("Poststart OCI hook failed: %s", err)
+		}
+	}
+
+	e.sendAccountingStart(pid)
+	e.startHistory(pid)
+
 	if e.EngineConfig.GetInstance() {
 		name := e.CommonConfig.ContainerID
 
@@ -340,9 +382,29 @@ func (e *EngineOperations) PostStartProcess(ctx context.Context, pid int) error
 			return fmt.Errorf("failed to change directory to /: %s", err)
 		}
 
-		file, err := instance.Add(name, instance.SingSubDir)
+		system := e.EngineConfig.GetSystemInstance()
+
+		var file *instance.File
+		var logErrPath, logOutPath string
+		var err error
+
+		if system {
+			file, err = instance.AddSystem(name, instance.SingSubDir)
+			if err != nil {
+				return err
+			}
+			logErrPath, logOutPath, err = instance.GetSystemLogFilePaths(name, instance.LogSubDir)
+			file.AllowUsers = e.EngineConfig.GetAllowUsers()
+			file.AllowGroups = e.EngineConfig.GetAllowGroups()
+		} else {
+			file, err = instance.Add(name, instance.SingSubDir)
+			if err != nil {
+				return err
+			}
+			logErrPath, logOutPath, err = instance.GetLogFilePaths(name, instance.LogSubDir)
+		}
 		if err != nil {
-			return err
+			return fmt.Errorf("could not find log paths: %s", err)
 		}
 
 		pw, err := user.CurrentOriginal()
@@ -350,11 +412,6 @@ func (e *EngineOperations) PostStartProcess(ctx context.Context, pid int) error
 			return err
 		}
 
-		logErrPath, logOutPath, err := instance.GetLogFilePaths(name, instance.LogSubDir)
-		if err != nil {
-			return fmt.Errorf("could not find log paths: %s", err)
-		}
-
 		file.User = pw.Name
 		file.Pid = pid
 		file.PPid = os.Getpid()
@@ -402,9 +459,18 @@ func (e *EngineOperations) PostStartProcess(ctx context.Context, pid int) error
 		if err != nil {
 			return err
 		}
+		if system {
+			file.JoinConfig, err = e.joinConfig()
+			if err != nil {
+				return err
+			}
+		}
 
 		err = file.Update()
 
+		// apply the configuration changes to the running instance
+		e.watchConfig()
+
 		// send SIGUSR1 to the parent process in order to tell it
 		// to detach container process and run as instance.
 		// Sleep a bit in case child would exit
@@ -418,6 +484,36 @@ func (e *EngineOperations) PostStartProcess(ctx context.Context, pid int) error
 	return nil
 }
 
+// joinConfig returns the configuration subset read from the join
+// file of a system instance by users joining it, the namespaces
+// and security settings are kept and everything else is dropped.
+func (e *EngineOperations) joinConfig() ([]byte, error) {
+	engineConfig := singularityConfig.NewConfig()
+	engineConfig.SetFakeroot(e.EngineConfig.GetFakeroot())
+	engineConfig.SetHomeDest(e.EngineConfig.GetHomeDest())
+	engineConfig.SetLandlockProfile(e.EngineConfig.GetLandlockProfile())
+
+	if l := e.EngineConfig.OciConfig.Linux; l != nil {
+		engineConfig.OciConfig.Linux = &specs.Linux{
+			Namespaces: l.Namespaces,
+			Seccomp:    l.Seccomp,
+		}
+	}
+	if p := e.EngineConfig.OciConfig.Process; p != nil {
+		engineConfig.OciConfig.Process = &specs.Process{
+			Capabilities:    p.Capabilities,
+			ApparmorProfile: p.ApparmorProfile,
+			SelinuxLabel:    p.SelinuxLabel,
+		}
+	}
+
+	return json.Marshal(&config.Common{
+		EngineName:   e.CommonConfig.EngineName,
+		ContainerID:  e.CommonConfig.ContainerID,
+		EngineConfig: engineConfig,
+	})
+}
+
 func (e *EngineOperations) setPathEnv() {
 	env := e.EngineConfig.OciConfig.Process.Env
 	for _, keyval := range env {
@@ -618,7 +714,8 @@ func (b *bufferCloser) Close() error {
 // after /.singularity.d/env/99-base.sh or /environment.
 // This handler turns all SINGUALRITYENV_KEY=VAL defined variables into their form:
 // export KEY=VAL. It can be sourced only once otherwise it returns an empty content.
-func injectEnvHandler(senv map[string]string) interpreter.OpenHandler {
+// The directories of injected host libraries are prepended to LD_LIBRARY_PATH.
+func injectEnvHandler(senv map[string]string, hostLibraryPath []string) interpreter.OpenHandler {
 	var once sync.Once
 
 	return func(_ string, _ int, _ os.FileMode) (io.ReadWriteCloser, error) {
@@ -649,6 +746,13 @@ func injectEnvHandler(senv map[string]string) interpreter.OpenHandler {
 				}
 				b.WriteString(fmt.Sprintf(snippet, key, shell.EscapeQuotes(value)))
 			}
+
+			if len(hostLibraryPath) > 0 {
+				hostLibsSnippet := `
+				export LD_LIBRARY_PATH="%s${LD_LIBRARY_PATH:+:$LD_LIBRARY_PATH}"
+				`
+				b.WriteString(fmt.Sprintf(hostLibsSnippet, shell.Escape(strings.Join(hostLibraryPath, ":"))))
+			}
 		})
 
 		return b, nil
@@ -813,7 +917,7 @@ func runActionScript(engineConfig *singularityConfig.EngineConfig) ([]string, []
 
 	// inject SINGULARITYENV_ defined variables
 	senv := engineConfig.GetSingularityEnv()
-	shell.RegisterOpenHandler("/.inject-singularity-env.sh", injectEnvHandler(senv))
+	shell.RegisterOpenHandler("/.inject-singularity-env.sh", injectEnvHandler(senv, engineConfig.GetHostLibraryPath()))
 
 	shell.RegisterOpenHandler("/.singularity.d/env/99-runtimevars.sh", runtimeVarsHandler(senv))
 
@@ -0,0 +1,98 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hpcng/singularity/internal/pkg/instance"
+	"github.com/hpcng/singularity/internal/pkg/test"
+	"github.com/hpcng/singularity/pkg/runtime/engine/config"
+	singularityConfig "github.com/hpcng/singularity/pkg/runtime/engine/singularity/config"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestJoinConfig(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	dir, err := ioutil.TempDir("", "join-config-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	systemDir := instance.SystemDir
+	instance.SystemDir = dir
+	defer func() { instance.SystemDir = systemDir }()
+
+	seccomp := &specs.LinuxSeccomp{
+		DefaultAction: specs.ActErrno,
+		Syscalls: []specs.LinuxSyscall{
+			{Names: []string{"read", "write"}, Action: specs.ActAllow},
+		},
+	}
+	landlock := []byte(`{"paths":[{"path":"/","access":["read"]}]}`)
+
+	e := &EngineOperations{
+		CommonConfig: &config.Common{EngineName: singularityConfig.Name, ContainerID: "service"},
+		EngineConfig: singularityConfig.NewConfig(),
+	}
+	e.EngineConfig.OciConfig.Linux = &specs.Linux{
+		Namespaces: []specs.LinuxNamespace{{Type: specs.PIDNamespace, Path: "/proc/1/ns/pid"}},
+		Seccomp:    seccomp,
+	}
+	e.EngineConfig.SetLandlockProfile(landlock)
+	e.EngineConfig.SetEncryptionKey([]byte("secret"))
+
+	file, err := instance.AddSystem("service", instance.SingSubDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	file.Pid = os.Getpid()
+	file.JoinConfig, err = e.joinConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := file.Update(); err != nil {
+		t.Fatalf("error while creating system instance: %s", err)
+	}
+	defer file.Delete()
+
+	// read the join file as users joining the instance do
+	b, err := ioutil.ReadFile(filepath.Dir(file.Path) + ".json")
+	if err != nil {
+		t.Fatalf("failed to read join file: %s", err)
+	}
+	join := &instance.File{}
+	if err := json.Unmarshal(b, join); err != nil {
+		t.Fatalf("failed to decode join file: %s", err)
+	}
+	joinEngineConfig := singularityConfig.NewConfig()
+	if err := json.Unmarshal(join.Config, &config.Common{EngineConfig: joinEngineConfig}); err != nil {
+		t.Fatalf("failed to decode join configuration: %s", err)
+	}
+
+	if joinEngineConfig.OciConfig.Linux == nil {
+		t.Fatalf("no linux configuration in join file")
+	}
+	if !reflect.DeepEqual(joinEngineConfig.OciConfig.Linux.Seccomp, seccomp) {
+		t.Errorf("got seccomp %+v, want %+v", joinEngineConfig.OciConfig.Linux.Seccomp, seccomp)
+	}
+	if !reflect.DeepEqual(joinEngineConfig.OciConfig.Linux.Namespaces, e.EngineConfig.OciConfig.Linux.Namespaces) {
+		t.Errorf("namespaces not kept in join file")
+	}
+	if string(joinEngineConfig.GetLandlockProfile()) != string(landlock) {
+		t.Errorf("got landlock profile %s, want %s", joinEngineConfig.GetLandlockProfile(), landlock)
+	}
+	if len(joinEngineConfig.GetEncryptionKey()) != 0 {
+		t.Errorf("encryption key leaked in join file")
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/buildcfg"
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/util/singularityconf"
+)
+
+// configReloadInterval is the interval between the checks of the
+// configuration files of a running instance.
+var configReloadInterval = 5 * time.Second
+
+// reloadableDirectives are the singularity.conf directives used by the
+// master process after the container start, their new values apply to
+// the running instances.
+var reloadableDirectives = map[string]bool{
+	"accounting hook":         true,
+	"accounting image digest": true,
+	"digest algorithm":        true,
+}
+
+// reloadLock protects the reloadable directives of the configuration
+// updated by the configuration watcher while read by the accounting.
+var reloadLock sync.RWMutex
+
+// configWatcher polls the configuration files of an instance and
+// reloads the directives applying to the running instance.
+type configWatcher struct {
+	sync.Mutex
+	stop    chan struct{}
+	done    chan struct{}
+	stopped bool
+	// last is the configuration last parsed, the changes are
+	// logged once.
+	last *singularityconf.File
+}
+
+var instanceConfigWatcher configWatcher
+
+// fileStamp identifies the content of a file by its modification time
+// and size, the zero value is a missing file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func stamp(path string) fileStamp {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: fi.ModTime(), size: fi.Size()}
+}
+
+// watchConfig starts the reload of the configuration files of
+// the instance until stopConfigWatch is called.
+func (e *EngineOperations) watchConfig() {
+	w := &instanceConfigWatcher
+	w.Lock()
+	defer w.Unlock()
+
+	// the container exited before its start completed
+	if w.stopped || w.stop != nil {
+		return
+	}
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+	last := *e.EngineConfig.File
+	w.last = &last
+
+	path := e.configurationFile()
+	stamps := map[string]fileStamp{
+		path:              stamp(path),
+		buildcfg.ECL_FILE: stamp(buildcfg.ECL_FILE),
+	}
+
+	go func() {
+		defer close(w.done)
+
+		ticker := time.NewTicker(configReloadInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+			}
+			if s := stamp(path); s != stamps[path] {
+				stamps[path] = s
+				e.reloadConfig(path, w)
+			}
+			if s := stamp(buildcfg.ECL_FILE); s != stamps[buildcfg.ECL_FILE] {
+				stamps[buildcfg.ECL_FILE] = s
+				sylog.Infof("%s changed, the execution control list applies to new containers", buildcfg.ECL_FILE)
+			}
+		}
+	}()
+}
+
+// stopConfigWatch stops the reload of the configuration files, once
+// returned the configuration is not modified anymore.
+func stopConfigWatch() {
+	w := &instanceConfigWatcher
+	w.Lock()
+	defer w.Unlock()
+
+	w.stopped = true
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+	}
+}
+
+// reloadConfig parses the configuration file path and applies the
+// reloadable directives changed to the running instance.
+func (e *EngineOperations) reloadConfig(path string, w *configWatcher) {
+	// a configuration file not owned by root is not trusted
+	// with the setuid workflow
+	if buildcfg.SINGULARITY_SUID_INSTALL == 1 && !fs.IsOwner(path, 0) {
+		sylog.Warningf("Not reloading %s: it must be owned by root", path)
+		return
+	}
+	config, err := singularityconf.Parse(path)
+	if err != nil {
+		sylog.Warningf("Not reloading %s, keeping the current configuration: %s", path, err)
+		return
+	}
+
+	last := w.last
+	w.last = config
+
+	var reloaded []string
+	for _, directive := range singularityconf.Changed(last, config) {
+		if reloadableDirectives[directive] {
+			reloaded = append(reloaded, directive)
+		} else {
+			sylog.Infof("Directive %q changed in %s, it applies to new containers", directive, path)
+		}
+	}
+	if len(reloaded) == 0 {
+		return
+	}
+	reloadLock.Lock()
+	singularityconf.Update(e.EngineConfig.File, config, reloaded...)
+	reloadLock.Unlock()
+	for _, directive := range reloaded {
+		sylog.Infof("Reloaded directive %q from %s", directive, path)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/hpcng/singularity/pkg/util/loop"
+	"github.com/hpcng/singularity/pkg/util/verity"
 )
 
 // MkdirArgs defines the arguments to mkdir.
@@ -46,6 +47,19 @@ type CryptArgs struct {
 	MasterPid int
 }
 
+// VerityArgs defines the arguments to set up a dm-verity device.
+type VerityArgs struct {
+	DataDev   string
+	HashDev   string
+	Params    verity.Params
+	MasterPid int
+}
+
+// MeasureArgs defines the arguments to measure an image file.
+type MeasureArgs struct {
+	Path string
+}
+
 // ChrootArgs defines the arguments to chroot.
 type ChrootArgs struct {
 	Root   string
@@ -130,6 +144,13 @@ type WriteFileArgs struct {
 	Perm     os.FileMode
 }
 
+// CreateBeneathArgs defines the arguments to createbeneath.
+type CreateBeneathArgs struct {
+	Root string
+	Path string
+	File bool
+}
+
 // FileInfo returns FileInfo interface to be passed as RPC argument.
 func FileInfo(fi os.FileInfo) os.FileInfo {
 	return &fileInfo{
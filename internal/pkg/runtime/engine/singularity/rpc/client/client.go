@@ -10,7 +10,9 @@ import (
 	"os"
 
 	args "github.com/hpcng/singularity/internal/pkg/runtime/engine/singularity/rpc"
+	"github.com/hpcng/singularity/internal/pkg/security/ima"
 	"github.com/hpcng/singularity/pkg/util/loop"
+	"github.com/hpcng/singularity/pkg/util/verity"
 )
 
 // RPC holds the state necessary for remote procedure calls.
@@ -55,6 +57,31 @@ func (t *RPC) Decrypt(offset uint64, path string, key []byte, masterPid int) (st
 	return reply, err
 }
 
+// Verity calls the Verity RPC using the supplied arguments.
+func (t *RPC) Verity(dataDev, hashDev string, params *verity.Params, masterPid int) (string, error) {
+	arguments := &args.VerityArgs{
+		DataDev:   dataDev,
+		HashDev:   hashDev,
+		Params:    *params,
+		MasterPid: masterPid,
+	}
+
+	var reply string
+	err := t.Client.Call(t.Name+".Verity", arguments, &reply)
+
+	return reply, err
+}
+
+// Measure calls the measure RPC using the supplied arguments.
+func (t *RPC) Measure(path string) ([]ima.Entry, error) {
+	arguments := &args.MeasureArgs{
+		Path: path,
+	}
+	var reply []ima.Entry
+	err := t.Client.Call(t.Name+".Measure", arguments, &reply)
+	return reply, err
+}
+
 // Mkdir calls the mkdir RPC using the supplied arguments.
 func (t *RPC) Mkdir(path string, perm os.FileMode) error {
 	arguments := &args.MkdirArgs{
@@ -226,6 +253,16 @@ func (t *RPC) Umask(mask int) int {
 	return reply
 }
 
+// CreateBeneath calls the CreateBeneath RPC using the supplied arguments.
+func (t *RPC) CreateBeneath(root, path string, file bool) error {
+	arguments := &args.CreateBeneathArgs{
+		Root: root,
+		Path: path,
+		File: file,
+	}
+	return t.Client.Call(t.Name+".CreateBeneath", arguments, nil)
+}
+
 // WriteFile calls the writefile RPC using the supplied arguments.
 func (t *RPC) WriteFile(filename string, data []byte, perm os.FileMode) error {
 	arguments := &args.WriteFileArgs{
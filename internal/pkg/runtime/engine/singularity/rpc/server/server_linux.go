@@ -15,6 +15,9 @@ import (
 	"syscall"
 
 	args "github.com/hpcng/singularity/internal/pkg/runtime/engine/singularity/rpc"
+	"github.com/hpcng/singularity/internal/pkg/security/audit"
+	"github.com/hpcng/singularity/internal/pkg/security/ima"
+	"github.com/hpcng/singularity/internal/pkg/util/bin"
 	"github.com/hpcng/singularity/internal/pkg/util/fs"
 	"github.com/hpcng/singularity/internal/pkg/util/mainthread"
 	"github.com/hpcng/singularity/internal/pkg/util/user"
@@ -23,6 +26,7 @@ import (
 	"github.com/hpcng/singularity/pkg/util/crypt"
 	"github.com/hpcng/singularity/pkg/util/loop"
 	"github.com/hpcng/singularity/pkg/util/namespaces"
+	"github.com/hpcng/singularity/pkg/util/verity"
 	"golang.org/x/sys/unix"
 )
 
@@ -40,6 +44,8 @@ type Methods int
 
 // Mount performs a mount with the specified arguments.
 func (t *Methods) Mount(arguments *args.MountArgs, mountErr *error) (err error) {
+	audit.Logf("mount(%q, %q, %q, 0x%x, %q)", arguments.Source, arguments.Target, arguments.Filesystem, arguments.Mountflags, arguments.Data)
+
 	mainthread.Execute(func() {
 		if arguments.Filesystem == "overlay" {
 			var oldEffective uint64
@@ -69,15 +75,75 @@ func (t *Methods) Mount(arguments *args.MountArgs, mountErr *error) (err error)
 
 // Decrypt decrypts the loop device.
 func (t *Methods) Decrypt(arguments *args.CryptArgs, reply *string) (err error) {
-	cryptName := ""
 	cryptDev := &crypt.Device{}
-	hasIPC := arguments.MasterPid > 0
+
+	audit.Logf("cryptsetup open %s", arguments.Loopdev)
+
+	caps := defaultEffective
+	caps |= uint64(1 << capabilities.Map["CAP_IPC_LOCK"].Value)
+
+	return inHostIPC(arguments.MasterPid, caps, func() error {
+		cryptName, err := cryptDev.Open(arguments.Key, arguments.Loopdev)
+		*reply = "/dev/mapper/" + cryptName
+		return err
+	})
+}
+
+// Verity sets up a dm-verity device for the loop device.
+func (t *Methods) Verity(arguments *args.VerityArgs, reply *string) (err error) {
+	audit.Logf("dmsetup create verity %s %s", arguments.DataDev, arguments.HashDev)
+
+	dmsetup, err := bin.Dmsetup()
+	if err != nil {
+		return err
+	}
+	if err := arguments.Params.Check(); err != nil {
+		return err
+	}
+
+	return inHostIPC(arguments.MasterPid, defaultEffective, func() error {
+		name, err := verity.Open(dmsetup, arguments.DataDev, arguments.HashDev, &arguments.Params)
+		*reply = "/dev/mapper/" + name
+		return err
+	})
+}
+
+// Measure opens the image file to record it in the IMA measurement log
+// and returns the measurement log entries of the image file.
+func (t *Methods) Measure(arguments *args.MeasureArgs, reply *[]ima.Entry) (err error) {
+	audit.Logf("ima measure %s", arguments.Path)
 
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
+	// the measurement log is only readable by root
 	caps := defaultEffective
-	caps |= uint64(1 << capabilities.Map["CAP_IPC_LOCK"].Value)
+	caps |= uint64(1 << capabilities.Map["CAP_DAC_READ_SEARCH"].Value)
+
+	oldEffective, err := capabilities.SetProcessEffective(caps)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, e := capabilities.SetProcessEffective(oldEffective)
+		if err == nil {
+			err = e
+		}
+	}()
+
+	*reply, err = ima.Measure(arguments.Path)
+	return err
+}
+
+// inHostIPC executes fn with the caps effective capabilities, device
+// mapper tools require to run in the host IPC namespace so fn is executed
+// in the host IPC namespace via the master process ID if it's greater than
+// zero which means that a container IPC namespace was requested.
+func inHostIPC(masterPid int, caps uint64, fn func() error) (err error) {
+	hasIPC := masterPid > 0
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 
 	if hasIPC {
 		// required for /proc/pid/ns/ipc access with namespaces.Enter
@@ -103,11 +169,7 @@ func (t *Methods) Decrypt(arguments *args.CryptArgs, reply *string) (err error)
 			return err
 		}
 
-		// cryptsetup requires to run in the host IPC namespace
-		// so we enter temporarily in the host IPC namespace
-		// via the master processus ID if its greater than zero
-		// which means that a container IPC namespace was requested
-		if err := namespaces.Enter(arguments.MasterPid, "ipc"); err != nil {
+		if err := namespaces.Enter(masterPid, "ipc"); err != nil {
 			return fmt.Errorf("while joining host IPC namespace: %s", err)
 		}
 	}
@@ -126,15 +188,13 @@ func (t *Methods) Decrypt(arguments *args.CryptArgs, reply *string) (err error)
 		}
 	}()
 
-	cryptName, err = cryptDev.Open(arguments.Key, arguments.Loopdev)
-
-	*reply = "/dev/mapper/" + cryptName
-
-	return err
+	return fn()
 }
 
 // Mkdir performs a mkdir with the specified arguments.
 func (t *Methods) Mkdir(arguments *args.MkdirArgs, reply *int) (err error) {
+	audit.Logf("mkdir(%q, %o)", arguments.Path, arguments.Perm)
+
 	mainthread.Execute(func() {
 		oldmask := syscall.Umask(0)
 		err = os.Mkdir(arguments.Path, arguments.Perm)
@@ -147,6 +207,8 @@ func (t *Methods) Mkdir(arguments *args.MkdirArgs, reply *int) (err error) {
 func (t *Methods) Chroot(arguments *args.ChrootArgs, reply *int) (err error) {
 	root := arguments.Root
 
+	audit.Logf("%s root filesystem to %q", arguments.Method, root)
+
 	if root != "." {
 		sylog.Debugf("Change current directory to %s", root)
 		if err := syscall.Chdir(root); err != nil {
@@ -245,6 +307,8 @@ func (t *Methods) LoopDevice(arguments *args.LoopArgs, reply *int) (err error) {
 	loopdev.Info = &arguments.Info
 	loopdev.Shared = arguments.Shared
 
+	audit.Logf("attach %q to loop device", arguments.Image)
+
 	if strings.HasPrefix(arguments.Image, "/proc/self/fd/") {
 		strFd := strings.TrimPrefix(arguments.Image, "/proc/self/fd/")
 		fd, err := strconv.ParseUint(strFd, 10, 32)
@@ -301,6 +365,7 @@ func (t *Methods) LoopDevice(arguments *args.LoopArgs, reply *int) (err error) {
 
 // SetHostname sets hostname with the specified arguments.
 func (t *Methods) SetHostname(arguments *args.HostnameArgs, reply *int) error {
+	audit.Logf("sethostname(%q)", arguments.Hostname)
 	return syscall.Sethostname([]byte(arguments.Hostname))
 }
 
@@ -342,6 +407,8 @@ func (t *Methods) SendFuseFd(arguments *args.SendFuseFdArgs, reply *int) error {
 // OpenSendFuseFd open a new /dev/fuse file descriptor and send it
 // over unix socket.
 func (t *Methods) OpenSendFuseFd(arguments *args.OpenSendFuseFdArgs, reply *int) error {
+	audit.Logf("open(\"/dev/fuse\")")
+
 	fd, err := unix.Open("/dev/fuse", unix.O_RDWR, 0)
 	if err != nil {
 		return fmt.Errorf("while opening /dev/fuse: %s", err)
@@ -354,6 +421,7 @@ func (t *Methods) OpenSendFuseFd(arguments *args.OpenSendFuseFdArgs, reply *int)
 
 // Symlink performs a symlink with the specified arguments.
 func (t *Methods) Symlink(arguments *args.SymlinkArgs, reply *int) error {
+	audit.Logf("symlink(%q, %q)", arguments.Old, arguments.New)
 	return os.Symlink(arguments.Old, arguments.New)
 }
 
@@ -369,11 +437,13 @@ func (t *Methods) ReadDir(arguments *args.ReadDirArgs, reply *args.ReadDirReply)
 
 // Chown performs a chown with the specified arguments.
 func (t *Methods) Chown(arguments *args.ChownArgs, reply *int) error {
+	audit.Logf("chown(%q, %d, %d)", arguments.Name, arguments.UID, arguments.GID)
 	return os.Chown(arguments.Name, arguments.UID, arguments.GID)
 }
 
 // Lchown performs a lchown with the specified arguments.
 func (t *Methods) Lchown(arguments *args.ChownArgs, reply *int) error {
+	audit.Logf("lchown(%q, %d, %d)", arguments.Name, arguments.UID, arguments.GID)
 	return os.Lchown(arguments.Name, arguments.UID, arguments.GID)
 }
 
@@ -395,8 +465,23 @@ func (t *Methods) Umask(arguments *args.UmaskArgs, reply *int) (err error) {
 	return nil
 }
 
+// CreateBeneath creates the missing components of a path beneath a root
+// directory without following symlinks.
+func (t *Methods) CreateBeneath(arguments *args.CreateBeneathArgs, reply *int) (err error) {
+	audit.Logf("create %q beneath %q", arguments.Path, arguments.Root)
+
+	mainthread.Execute(func() {
+		oldmask := syscall.Umask(0)
+		err = fs.CreateBeneath(arguments.Root, arguments.Path, arguments.File)
+		syscall.Umask(oldmask)
+	})
+	return err
+}
+
 // WriteFile creates an empty file if it doesn't exist or a file with the provided data.
 func (t *Methods) WriteFile(arguments *args.WriteFileArgs, reply *int) error {
+	audit.Logf("create file %q", arguments.Filename)
+
 	f, err := os.OpenFile(arguments.Filename, os.O_CREATE|os.O_WRONLY|os.O_EXCL, arguments.Perm)
 	if err != nil {
 		if !os.IsExist(err) {
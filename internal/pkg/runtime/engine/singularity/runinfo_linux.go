@@ -0,0 +1,70 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+)
+
+// runInfo holds the container run metadata written to the file
+// requested with --info-file.
+type runInfo struct {
+	Pid        int    `json:"pid"`
+	PPid       int    `json:"ppid"`
+	Instance   string `json:"instance,omitempty"`
+	Image      string `json:"image"`
+	CgroupPath string `json:"cgroupPath,omitempty"`
+}
+
+// writeRunFiles writes the container process PID and the run metadata
+// to the files requested with --pid-file and --info-file.
+func (e *EngineOperations) writeRunFiles(pid int) error {
+	if pidFile := e.EngineConfig.GetPidFile(); pidFile != "" {
+		if err := fs.WriteFileAtomic(pidFile, []byte(strconv.Itoa(pid)+"\n"), 0644); err != nil {
+			return fmt.Errorf("while writing pid file: %s", err)
+		}
+	}
+
+	infoFile := e.EngineConfig.GetInfoFile()
+	if infoFile == "" {
+		return nil
+	}
+
+	info := runInfo{
+		Pid:   pid,
+		PPid:  os.Getpid(),
+		Image: e.EngineConfig.GetImage(),
+	}
+	if e.EngineConfig.GetInstance() {
+		info.Instance = e.CommonConfig.ContainerID
+	}
+	if cgroupManager != nil {
+		info.CgroupPath = cgroupManager.Path
+	}
+
+	data, err := json.MarshalIndent(info, "", "\t")
+	if err != nil {
+		return fmt.Errorf("while encoding run information: %s", err)
+	}
+	if err := fs.WriteFileAtomic(infoFile, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("while writing info file: %s", err)
+	}
+	return nil
+}
+
+// removeRunFiles removes the files previously written by writeRunFiles.
+func (e *EngineOperations) removeRunFiles() {
+	for _, path := range []string{e.EngineConfig.GetPidFile(), e.EngineConfig.GetInfoFile()} {
+		if path != "" {
+			os.Remove(path)
+		}
+	}
+}
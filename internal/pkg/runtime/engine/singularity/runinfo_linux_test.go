@@ -0,0 +1,84 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcng/singularity/pkg/runtime/engine/config"
+	singularityConfig "github.com/hpcng/singularity/pkg/runtime/engine/singularity/config"
+)
+
+func TestWriteRunFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runinfo-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	pidFile := filepath.Join(dir, "pid")
+	infoFile := filepath.Join(dir, "info.json")
+
+	e := &EngineOperations{
+		CommonConfig: &config.Common{ContainerID: "test"},
+		EngineConfig: singularityConfig.NewConfig(),
+	}
+	e.EngineConfig.SetImage("/image.sif")
+	e.EngineConfig.SetInstance(true)
+	e.EngineConfig.SetPidFile(pidFile)
+	e.EngineConfig.SetInfoFile(infoFile)
+
+	if err := e.writeRunFiles(1234); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("failed to read pid file: %s", err)
+	}
+	if string(data) != "1234\n" {
+		t.Errorf("unexpected pid file content %q", data)
+	}
+
+	data, err = ioutil.ReadFile(infoFile)
+	if err != nil {
+		t.Fatalf("failed to read info file: %s", err)
+	}
+	var info runInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		t.Fatalf("failed to decode info file: %s", err)
+	}
+	expected := runInfo{Pid: 1234, PPid: os.Getpid(), Instance: "test", Image: "/image.sif"}
+	if info != expected {
+		t.Errorf("got info %+v instead of %+v", info, expected)
+	}
+
+	if _, err := os.Stat(pidFile + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary pid file not removed")
+	}
+
+	e.removeRunFiles()
+	for _, path := range []string{pidFile, infoFile} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s not removed", path)
+		}
+	}
+}
+
+func TestWriteRunFilesNone(t *testing.T) {
+	e := &EngineOperations{
+		CommonConfig: &config.Common{},
+		EngineConfig: singularityConfig.NewConfig(),
+	}
+	if err := e.writeRunFiles(1); err != nil {
+		t.Errorf("unexpected error without files: %s", err)
+	}
+	e.removeRunFiles()
+}
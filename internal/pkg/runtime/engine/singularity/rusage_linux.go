@@ -0,0 +1,132 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/runtime/engine"
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+// rusageSummary holds the container resource usage printed with
+// --rusage and written to the file requested with --rusage-file.
+// Times are expressed in seconds and sizes in bytes.
+type rusageSummary struct {
+	ExitCode    int          `json:"exitCode"`
+	Signal      string       `json:"signal,omitempty"`
+	OOMKilled   bool         `json:"oomKilled,omitempty"`
+	WallTime    float64      `json:"wallTime"`
+	UserTime    float64      `json:"userTime"`
+	SystemTime  float64      `json:"systemTime"`
+	MaxRSS      int64        `json:"maxRSS"`
+	BlockInput  int64        `json:"blockInput"`
+	BlockOutput int64        `json:"blockOutput"`
+	Cgroup      *cgroupUsage `json:"cgroup,omitempty"`
+}
+
+// cgroupUsage holds the resource usage accounted in the container
+// cgroup, it includes the processes not waited by the container process.
+type cgroupUsage struct {
+	CPUTime    float64 `json:"cpuTime"`
+	MemoryPeak uint64  `json:"memoryPeak,omitempty"`
+	IORead     uint64  `json:"ioRead"`
+	IOWrite    uint64  `json:"ioWrite"`
+}
+
+// newRusageSummary returns the resource usage summary of the container
+// process described by info, completed with the cgroup accounting if the
+// container has a cgroup.
+func newRusageSummary(info *engine.ExitInfo) *rusageSummary {
+	user, system := info.CPUTime()
+
+	r := &rusageSummary{
+		ExitCode:    info.ExitCode(),
+		OOMKilled:   info.OOMKilled,
+		WallTime:    info.WallTime.Seconds(),
+		UserTime:    user.Seconds(),
+		SystemTime:  system.Seconds(),
+		MaxRSS:      info.MaxRSS(),
+		BlockInput:  info.Rusage.Inblock,
+		BlockOutput: info.Rusage.Oublock,
+	}
+	if s := info.Signal(); s != 0 {
+		r.Signal = s.String()
+	}
+
+	if cgroupManager != nil {
+		u, err := cgroupManager.Usage()
+		if err != nil {
+			sylog.Debugf("Could not read cgroup resource usage: %s", err)
+		} else {
+			r.Cgroup = &cgroupUsage{
+				CPUTime:    u.CPUTime.Seconds(),
+				MemoryPeak: u.MemoryPeak,
+				IORead:     u.IORead,
+				IOWrite:    u.IOWrite,
+			}
+		}
+	}
+	return r
+}
+
+// print writes the resource usage summary in a human readable format.
+func (r *rusageSummary) print(w io.Writer) {
+	seconds := func(s float64) time.Duration {
+		return time.Duration(s * float64(time.Second)).Round(time.Millisecond)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "Container resource usage:")
+	fmt.Fprintf(tw, "  Wall time:\t%s\n", seconds(r.WallTime))
+	fmt.Fprintf(tw, "  User time:\t%s\n", seconds(r.UserTime))
+	fmt.Fprintf(tw, "  System time:\t%s\n", seconds(r.SystemTime))
+	fmt.Fprintf(tw, "  Max RSS:\t%s\n", fs.FindSize(r.MaxRSS))
+	fmt.Fprintf(tw, "  Block I/O:\t%d inputs, %d outputs\n", r.BlockInput, r.BlockOutput)
+	if r.Cgroup != nil {
+		fmt.Fprintf(tw, "  Cgroup CPU time:\t%s\n", seconds(r.Cgroup.CPUTime))
+		if r.Cgroup.MemoryPeak > 0 {
+			fmt.Fprintf(tw, "  Cgroup memory peak:\t%s\n", fs.FindSize(int64(r.Cgroup.MemoryPeak)))
+		}
+		fmt.Fprintf(tw, "  Cgroup I/O:\t%s read, %s written\n", fs.FindSize(int64(r.Cgroup.IORead)), fs.FindSize(int64(r.Cgroup.IOWrite)))
+	}
+	if r.OOMKilled {
+		fmt.Fprintln(tw, "  Killed by the OOM killer")
+	}
+	tw.Flush()
+}
+
+// reportRusage prints the container resource usage and writes it to
+// the file requested with --rusage-file.
+func (e *EngineOperations) reportRusage(info *engine.ExitInfo) {
+	path := e.EngineConfig.GetRusageFile()
+	if !e.EngineConfig.GetRusage() && path == "" {
+		return
+	}
+
+	r := newRusageSummary(info)
+	if e.EngineConfig.GetRusage() {
+		r.print(os.Stderr)
+	}
+	if path == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(r, "", "\t")
+	if err != nil {
+		sylog.Warningf("Could not encode resource usage: %s", err)
+		return
+	}
+	if err := fs.WriteFileAtomic(path, append(data, '\n'), 0644); err != nil {
+		sylog.Warningf("Could not write resource usage file: %s", err)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/hpcng/singularity/internal/pkg/util/fs/mount"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+const (
+	localtimePath = "/etc/localtime"
+	zoneinfoDir   = "/usr/share/zoneinfo"
+)
+
+// zoneinfoFile returns the host file describing the time zone tz, tz is
+// either host for the host /etc/localtime or a zone name of the host time
+// zone database. The returned path is always within the time zone
+// database for zone names as the file is read with privileges.
+func zoneinfoFile(tz string) (string, error) {
+	if tz == "host" {
+		return localtimePath, nil
+	}
+	if tz == "" || filepath.IsAbs(tz) || filepath.Clean(tz) != tz || strings.HasPrefix(tz, "..") {
+		return "", fmt.Errorf("invalid time zone %q", tz)
+	}
+
+	dir, err := filepath.EvalSymlinks(zoneinfoDir)
+	if err != nil {
+		return "", fmt.Errorf("while resolving %s: %s", zoneinfoDir, err)
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(dir, tz))
+	if err != nil {
+		return "", fmt.Errorf("time zone %s not found: %s", tz, err)
+	}
+	if !strings.HasPrefix(path, dir+string(os.PathSeparator)) {
+		return "", fmt.Errorf("time zone %s resolves outside of %s", tz, zoneinfoDir)
+	}
+	return path, nil
+}
+
+// addTimezoneMount binds a copy of the time zone file requested with --tz
+// as the container /etc/localtime, the TZ variable is set by the CLI.
+func (c *container) addTimezoneMount(system *mount.System) error {
+	tz := c.engine.EngineConfig.GetTimezone()
+	if tz == "" {
+		return nil
+	}
+
+	if _, err := os.Stat(filepath.Join(zoneinfoDir, tz)); os.IsNotExist(err) && tz == "UTC" {
+		// the C library defaults to UTC when the zone file is missing
+		sylog.Debugf("No UTC time zone file on host, relying on TZ variable")
+		return nil
+	}
+
+	path, err := zoneinfoFile(tz)
+	if err != nil {
+		return err
+	}
+
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) && tz == "host" {
+		sylog.Warningf("Host has no %s, skipping time zone setup", localtimePath)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("while getting time zone file information: %s", err)
+	} else if !fi.Mode().IsRegular() {
+		return fmt.Errorf("time zone file %s is not a regular file", path)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("while reading time zone file: %s", err)
+	}
+	if !bytes.HasPrefix(content, []byte("TZif")) {
+		return fmt.Errorf("%s is not a time zone file", path)
+	}
+
+	if err := c.session.AddFile(localtimePath, content); err != nil {
+		return fmt.Errorf("while adding %s session file: %s", localtimePath, err)
+	}
+	sessionFile, _ := c.session.GetPath(localtimePath)
+
+	sylog.Debugf("Adding %s to mount list for time zone %s", localtimePath, tz)
+	// #5465 like other localtime mounts a failure is not fatal, an image
+	// could have a dangling /etc/localtime symlink
+	if err := system.Points.AddBind(mount.FilesTag, sessionFile, localtimePath, syscall.MS_BIND, "skip-on-error"); err != nil {
+		return fmt.Errorf("unable to add %s to mount list: %s", localtimePath, err)
+	}
+	return nil
+}
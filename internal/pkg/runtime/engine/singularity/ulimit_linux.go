@@ -0,0 +1,98 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/util/rlimit"
+)
+
+// prepareUlimits adds the resource limits set in singularity.conf and the
+// limits requested with --ulimit to the container process limits. Limits
+// are applied by the container process once privileges are dropped, so
+// non-root users can't raise a hard limit above the one inherited from
+// their shell or set by the administrator in singularity.conf, such limits
+// are lowered with a warning.
+func (e *EngineOperations) prepareUlimits() error {
+	generator := &e.EngineConfig.OciConfig.Generator
+	privileged := os.Getuid() == 0
+
+	// hard limits allowed for users, set by the administrator
+	// in singularity.conf or inherited from the calling shell
+	allowed := make(map[string]uint64)
+	hardLimit := func(res string) (uint64, error) {
+		if hard, ok := allowed[res]; ok {
+			return hard, nil
+		}
+		_, hard, err := rlimit.Get(res)
+		return hard, err
+	}
+
+	// limits already present are recorded by the CLI to restore the
+	// original stack size limit for setuid workflow, they come from
+	// the user and are lowered like the ones requested with --ulimit
+	if !privileged {
+		for i, limit := range e.EngineConfig.OciConfig.Process.Rlimits {
+			hard, err := hardLimit(limit.Type)
+			if err != nil {
+				return err
+			}
+			cur, max := clampUlimit(limit.Type, limit.Soft, limit.Hard, hard)
+			e.EngineConfig.OciConfig.Process.Rlimits[i].Soft = cur
+			e.EngineConfig.OciConfig.Process.Rlimits[i].Hard = max
+		}
+	}
+
+	for _, limit := range e.EngineConfig.File.Ulimits {
+		res, cur, max, err := rlimit.Parse(limit)
+		if err != nil {
+			return fmt.Errorf("while parsing ulimit directive: %s", err)
+		}
+		if !privileged {
+			hard, err := hardLimit(res)
+			if err != nil {
+				return err
+			}
+			cur, max = clampUlimit(res, cur, max, hard)
+		}
+		sylog.Debugf("Setting %s to %d:%d from configuration", res, cur, max)
+		generator.AddProcessRlimits(res, max, cur)
+		allowed[res] = max
+	}
+
+	for _, limit := range e.EngineConfig.GetUlimits() {
+		res, cur, max, err := rlimit.Parse(limit)
+		if err != nil {
+			return err
+		}
+		if !privileged {
+			hard, err := hardLimit(res)
+			if err != nil {
+				return err
+			}
+			cur, max = clampUlimit(res, cur, max, hard)
+		}
+		sylog.Debugf("Setting %s to %d:%d", res, cur, max)
+		generator.AddProcessRlimits(res, max, cur)
+	}
+	return nil
+}
+
+// clampUlimit lowers the soft limit cur and the hard limit max of the
+// resource res to the hard limit hard, with a warning.
+func clampUlimit(res string, cur, max, hard uint64) (uint64, uint64) {
+	if max <= hard {
+		return cur, max
+	}
+	sylog.Warningf("%s hard limit can't be raised above %d, lowering it", res, hard)
+	if cur > hard {
+		cur = hard
+	}
+	return cur, hard
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/hpcng/singularity/internal/pkg/runtime/engine/config/oci"
+	"github.com/hpcng/singularity/internal/pkg/runtime/engine/config/oci/generate"
+	singularityConfig "github.com/hpcng/singularity/pkg/runtime/engine/singularity/config"
+	"github.com/hpcng/singularity/pkg/util/rlimit"
+	"github.com/hpcng/singularity/pkg/util/singularityconf"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestClampUlimit(t *testing.T) {
+	tests := []struct {
+		name          string
+		cur, max      uint64
+		hard          uint64
+		wantCur, want uint64
+	}{
+		{"below", 10, 20, 30, 10, 20},
+		{"equal", 10, 30, 30, 10, 30},
+		{"hard above", 10, 40, 30, 10, 30},
+		{"both above", 35, 40, 30, 30, 30},
+		{"unlimited", rlimit.Unlimited, rlimit.Unlimited, 30, 30, 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cur, max := clampUlimit("RLIMIT_NOFILE", tt.cur, tt.max, tt.hard)
+			if cur != tt.wantCur || max != tt.want {
+				t.Errorf("got %d:%d instead of %d:%d", cur, max, tt.wantCur, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrepareUlimits(t *testing.T) {
+	if os.Getuid() == 0 {
+		t.Skip("resource limits are not lowered for root")
+	}
+
+	_, hard, err := rlimit.Get("RLIMIT_NOFILE")
+	if err != nil {
+		t.Fatalf("failed to get RLIMIT_NOFILE: %s", err)
+	}
+	if hard == rlimit.Unlimited || hard < 2 {
+		t.Skipf("unexpected RLIMIT_NOFILE hard limit %d", hard)
+	}
+
+	newEngine := func(conf, user string) *EngineOperations {
+		e := &EngineOperations{EngineConfig: singularityConfig.NewConfig()}
+		e.EngineConfig.File = &singularityconf.File{}
+		if conf != "" {
+			e.EngineConfig.File.Ulimits = []string{conf}
+		}
+		if user != "" {
+			e.EngineConfig.SetUlimits([]string{user})
+		}
+		e.EngineConfig.OciConfig = &oci.Config{}
+		e.EngineConfig.OciConfig.Generator = *generate.New(&e.EngineConfig.OciConfig.Spec)
+		e.EngineConfig.OciConfig.Process = &specs.Process{}
+		return e
+	}
+	limit := func(e *EngineOperations) specs.POSIXRlimit {
+		for _, l := range e.EngineConfig.OciConfig.Process.Rlimits {
+			if l.Type == "RLIMIT_NOFILE" {
+				return l
+			}
+		}
+		t.Fatalf("RLIMIT_NOFILE not set")
+		return specs.POSIXRlimit{}
+	}
+
+	tests := []struct {
+		name     string
+		conf     string
+		user     string
+		recorded uint64
+		soft     uint64
+		hardWant uint64
+	}{
+		{
+			name:     "user limit",
+			user:     fmt.Sprintf("nofile=1:%d", hard-1),
+			soft:     1,
+			hardWant: hard - 1,
+		},
+		{
+			name:     "user limit lowered",
+			user:     fmt.Sprintf("nofile=1:%d", hard+1),
+			soft:     1,
+			hardWant: hard,
+		},
+		{
+			name:     "configuration limit lowered",
+			conf:     fmt.Sprintf("nofile=%d", hard+1),
+			soft:     hard,
+			hardWant: hard,
+		},
+		{
+			name:     "user limit lowered by configuration",
+			conf:     fmt.Sprintf("nofile=%d", hard-1),
+			user:     fmt.Sprintf("nofile=1:%d", hard),
+			soft:     1,
+			hardWant: hard - 1,
+		},
+		{
+			name:     "recorded limit lowered",
+			recorded: hard + 1,
+			soft:     hard,
+			hardWant: hard,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEngine(tt.conf, tt.user)
+			if tt.recorded != 0 {
+				e.EngineConfig.OciConfig.AddProcessRlimits("RLIMIT_NOFILE", tt.recorded, tt.recorded)
+			}
+			if err := e.prepareUlimits(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			l := limit(e)
+			if l.Soft != tt.soft || l.Hard != tt.hardWant {
+				t.Errorf("got %d:%d instead of %d:%d", l.Soft, l.Hard, tt.soft, tt.hardWant)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package engine
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ExitInfo describes how the container process terminated.
+type ExitInfo struct {
+	// Pid is the container process PID.
+	Pid int
+	// Status is the wait status of the container process.
+	Status syscall.WaitStatus
+	// Rusage is the resource usage of the container process
+	// and of its waited children.
+	Rusage syscall.Rusage
+	// OOMKilled reports if processes of the container were
+	// killed by the kernel OOM killer.
+	OOMKilled bool
+	// WallTime is the time elapsed from the beginning of the
+	// wait to the container process exit.
+	WallTime time.Duration
+}
+
+// ExitCode returns the exit code of the container process, or 128 plus
+// the signal number if it was terminated by a signal like shells do.
+func (i *ExitInfo) ExitCode() int {
+	if i.Status.Signaled() {
+		return 128 + int(i.Status.Signal())
+	}
+	return i.Status.ExitStatus()
+}
+
+// Signal returns the signal which terminated the container
+// process or zero if it exited normally.
+func (i *ExitInfo) Signal() syscall.Signal {
+	if i.Status.Signaled() {
+		return i.Status.Signal()
+	}
+	return 0
+}
+
+// MaxRSS returns the maximum resident set size of the container
+// process and of its waited children in bytes.
+func (i *ExitInfo) MaxRSS() int64 {
+	return i.Rusage.Maxrss * 1024
+}
+
+// CPUTime returns the user and system CPU time consumed by the
+// container process and by its waited children.
+func (i *ExitInfo) CPUTime() (user, system time.Duration) {
+	return time.Duration(i.Rusage.Utime.Nano()), time.Duration(i.Rusage.Stime.Nano())
+}
+
+// String returns a short description of the container process termination.
+func (i *ExitInfo) String() string {
+	if i.OOMKilled {
+		return fmt.Sprintf("killed by the OOM killer (exit code %d)", i.ExitCode())
+	}
+	if s := i.Signal(); s != 0 {
+		return fmt.Sprintf("interrupted by signal %s", s.String())
+	}
+	return fmt.Sprintf("exited with code %d", i.ExitCode())
+}
+
+// Waiter is implemented by engines returning structured exit information
+// about the container process, the engines implement WaitContainer with
+// WaitProcess and MonitorContainer is then a thin wrapper returning the
+// wait status only.
+type Waiter interface {
+	// WaitContainer is called from master once the container has
+	// been spawned. It will block until the container exits and
+	// returns the container process exit information.
+	WaitContainer(int, chan os.Signal) (*ExitInfo, error)
+}
+
+// Wait blocks until the container process pid exits and returns its exit
+// information, MonitorContainer is used for engines not implementing Waiter,
+// in which case only the wait status is reported.
+func (e *Engine) Wait(pid int, signals chan os.Signal) (*ExitInfo, error) {
+	if w, ok := e.Operations.(Waiter); ok {
+		return w.WaitContainer(pid, signals)
+	}
+	status, err := e.MonitorContainer(pid, signals)
+	return &ExitInfo{Pid: pid, Status: status}, err
+}
+
+// WaitProcess reaps the process pid once a SIGCHLD signal reports its
+// termination. The other signals received, except SIGURG, are passed to
+// forward, an error returned by forward interrupts the wait.
+func WaitProcess(pid int, signals <-chan os.Signal, forward func(syscall.Signal) error) (*ExitInfo, error) {
+	info := &ExitInfo{Pid: pid}
+	start := time.Now()
+
+	for {
+		s := <-signals
+		switch s {
+		case syscall.SIGCHLD:
+			if wpid, err := syscall.Wait4(pid, &info.Status, syscall.WNOHANG, &info.Rusage); err != nil {
+				return info, fmt.Errorf("error while waiting child: %s", err)
+			} else if wpid != pid {
+				continue
+			}
+			info.WallTime = time.Since(start)
+			return info, nil
+		case syscall.SIGURG:
+			// Ignore SIGURG, which is used for non-cooperative goroutine
+			// preemption starting with Go 1.14. For more information, see
+			// https://github.com/golang/go/issues/24543.
+			break
+		default:
+			if err := forward(s.(syscall.Signal)); err != nil {
+				return info, err
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package engine
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"testing"
+)
+
+func TestWaitProcess(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		exitCode int
+		signal   syscall.Signal
+		desc     string
+	}{
+		{
+			name:     "Exited",
+			script:   "exit 3",
+			exitCode: 3,
+			desc:     "exited with code 3",
+		},
+		{
+			name:     "Signaled",
+			script:   "kill -KILL $$",
+			exitCode: 128 + int(syscall.SIGKILL),
+			signal:   syscall.SIGKILL,
+			desc:     "interrupted by signal killed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, syscall.SIGCHLD)
+			defer signal.Stop(signals)
+
+			cmd := exec.Command("/bin/sh", "-c", tt.script)
+			if err := cmd.Start(); err != nil {
+				t.Skipf("could not start /bin/sh: %s", err)
+			}
+
+			info, err := WaitProcess(cmd.Process.Pid, signals, func(s syscall.Signal) error {
+				t.Errorf("unexpected signal %s forwarded", s)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if info.Pid != cmd.Process.Pid {
+				t.Errorf("got PID %d, expected %d", info.Pid, cmd.Process.Pid)
+			}
+			if info.ExitCode() != tt.exitCode {
+				t.Errorf("got exit code %d, expected %d", info.ExitCode(), tt.exitCode)
+			}
+			if info.Signal() != tt.signal {
+				t.Errorf("got signal %d, expected %d", info.Signal(), tt.signal)
+			}
+			if info.String() != tt.desc {
+				t.Errorf("got description %q, expected %q", info.String(), tt.desc)
+			}
+			if info.MaxRSS() <= 0 {
+				t.Errorf("resource usage not reported")
+			}
+			if info.WallTime <= 0 {
+				t.Errorf("wall time not reported")
+			}
+		})
+	}
+}
+
+func TestExitInfoOOM(t *testing.T) {
+	info := &ExitInfo{
+		Status:    syscall.WaitStatus(syscall.SIGKILL),
+		OOMKilled: true,
+	}
+	if info.ExitCode() != 137 {
+		t.Errorf("got exit code %d, expected 137", info.ExitCode())
+	}
+	if expected := "killed by the OOM killer (exit code 137)"; info.String() != expected {
+		t.Errorf("got description %q, expected %q", info.String(), expected)
+	}
+}
@@ -0,0 +1,18 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build !wasm_runtime
+
+package wasm
+
+import (
+	"context"
+)
+
+// Run returns ErrNotSupported, the embedded WASI runtime requires a
+// build with the wasm_runtime tag.
+func Run(ctx context.Context, module []byte, cfg Config) (int, error) {
+	return -1, ErrNotSupported
+}
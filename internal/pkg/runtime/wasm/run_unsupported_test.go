@@ -0,0 +1,19 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build !wasm_runtime
+
+package wasm
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	if _, err := Run(context.Background(), helloModule(), Config{}); err != ErrNotSupported {
+		t.Errorf("unexpected error %v instead of %v", err, ErrNotSupported)
+	}
+}
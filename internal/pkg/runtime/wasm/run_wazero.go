@@ -0,0 +1,76 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build wasm_runtime
+
+package wasm
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// Run instantiates and runs the WebAssembly module with WASI support,
+// it returns the module exit code.
+func Run(ctx context.Context, module []byte, cfg Config) (int, error) {
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	defer r.Close(ctx)
+
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		return -1, fmt.Errorf("while instantiating WASI: %s", err)
+	}
+
+	fsConfig := wazero.NewFSConfig()
+	for _, b := range cfg.Binds {
+		if b.Readonly {
+			fsConfig = fsConfig.WithReadOnlyDirMount(b.Source, b.Destination)
+		} else {
+			fsConfig = fsConfig.WithDirMount(b.Source, b.Destination)
+		}
+	}
+
+	modConfig := wazero.NewModuleConfig().
+		WithArgs(cfg.Args...).
+		WithFSConfig(fsConfig).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithRandSource(rand.Reader)
+	if cfg.Stdin != nil {
+		modConfig = modConfig.WithStdin(cfg.Stdin)
+	}
+	if cfg.Stdout != nil {
+		modConfig = modConfig.WithStdout(cfg.Stdout)
+	}
+	if cfg.Stderr != nil {
+		modConfig = modConfig.WithStderr(cfg.Stderr)
+	}
+	for _, env := range cfg.Env {
+		kv := strings.SplitN(env, "=", 2)
+		if len(kv) != 2 {
+			return -1, fmt.Errorf("bad environment variable %q, must be KEY=VALUE", env)
+		}
+		modConfig = modConfig.WithEnv(kv[0], kv[1])
+	}
+
+	mod, err := r.InstantiateWithConfig(ctx, module, modConfig)
+	if err != nil {
+		var exitErr *sys.ExitError
+		if errors.As(err, &exitErr) {
+			return int(exitErr.ExitCode()), nil
+		}
+		return -1, fmt.Errorf("while running WebAssembly module: %s", err)
+	}
+	mod.Close(ctx)
+
+	return 0, nil
+}
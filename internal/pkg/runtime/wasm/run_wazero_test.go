@@ -0,0 +1,40 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build wasm_runtime
+
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	var stdout bytes.Buffer
+
+	code, err := Run(context.Background(), helloModule(), Config{
+		Args:   []string{"hello"},
+		Env:    []string{"KEY=VALUE"},
+		Stdout: &stdout,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if code != 3 {
+		t.Errorf("unexpected exit code %d instead of 3", code)
+	}
+	if stdout.String() != "hi\n" {
+		t.Errorf("unexpected output %q", stdout.String())
+	}
+
+	if _, err := Run(context.Background(), helloModule(), Config{Env: []string{"KEY"}}); err == nil {
+		t.Errorf("unexpected success with bad environment variable")
+	}
+	if _, err := Run(context.Background(), magic, Config{}); err != nil {
+		t.Errorf("unexpected error with empty module: %s", err)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package wasm runs WebAssembly modules packaged in SIF images with an
+// embedded WASI runtime.
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/hpcng/sif/pkg/sif"
+	"github.com/hpcng/singularity/pkg/image"
+)
+
+// ModuleSuffix is the suffix of SIF data object names holding
+// a WebAssembly module.
+const ModuleSuffix = ".wasm"
+
+// ErrNoModule is returned when an image doesn't contain
+// a WebAssembly module.
+var ErrNoModule = errors.New("no WebAssembly module found")
+
+// ErrNotSupported is returned by Run when Singularity was built
+// without the embedded WASI runtime.
+var ErrNotSupported = errors.New("singularity was built without WebAssembly support (see ./mconfig --with-wasm)")
+
+// magic is the WebAssembly binary magic number followed by
+// the binary format version 1.
+var magic = []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+
+// Bind is a host directory exposed to the WebAssembly module.
+type Bind struct {
+	Source      string
+	Destination string
+	Readonly    bool
+}
+
+// Config holds the WebAssembly module run configuration.
+type Config struct {
+	// Args are the module arguments, the first one being
+	// the program name.
+	Args []string
+	// Env contains the KEY=VALUE environment variables.
+	Env []string
+	// Binds are the host directories accessible by the module,
+	// no host directory is accessible by default.
+	Binds  []Bind
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// FindModule returns the first SIF data object holding a WebAssembly
+// module, SIF data objects are generic data objects (type 7) named with
+// the .wasm suffix.
+func FindModule(img *image.Image) (*image.Section, error) {
+	if img.Type != image.SIF {
+		return nil, ErrNoModule
+	}
+	for i, s := range img.Sections {
+		if s.Type == uint32(sif.DataGeneric) && strings.HasSuffix(s.Name, ModuleSuffix) {
+			return &img.Sections[i], nil
+		}
+	}
+	return nil, ErrNoModule
+}
+
+// ReadModule reads the WebAssembly module from the image section.
+func ReadModule(img *image.Image, section *image.Section) ([]byte, error) {
+	r := io.NewSectionReader(img.File, int64(section.Offset), int64(section.Size))
+	module, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("while reading WebAssembly module %s: %s", section.Name, err)
+	}
+	if !bytes.HasPrefix(module, magic) {
+		return nil, fmt.Errorf("%s is not a WebAssembly module", section.Name)
+	}
+	return module, nil
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package wasm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcng/sif/pkg/sif"
+	"github.com/hpcng/singularity/pkg/image"
+	uuid "github.com/satori/go.uuid"
+)
+
+func section(id byte, content ...byte) []byte {
+	return append([]byte{id, byte(len(content))}, content...)
+}
+
+func name(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func concat(b ...[]byte) []byte {
+	return bytes.Join(b, nil)
+}
+
+// helloModule returns a WebAssembly module writing "hi\n" on the
+// standard output with fd_write and exiting with code 3.
+func helloModule() []byte {
+	wasi := name("wasi_snapshot_preview1")
+
+	return concat(
+		magic,
+		// type section: fd_write, proc_exit and _start signatures
+		section(1, concat(
+			[]byte{0x03},
+			[]byte{0x60, 0x04, 0x7f, 0x7f, 0x7f, 0x7f, 0x01, 0x7f},
+			[]byte{0x60, 0x01, 0x7f, 0x00},
+			[]byte{0x60, 0x00, 0x00},
+		)...),
+		// import section
+		section(2, concat(
+			[]byte{0x02},
+			wasi, name("fd_write"), []byte{0x00, 0x00},
+			wasi, name("proc_exit"), []byte{0x00, 0x01},
+		)...),
+		// function section
+		section(3, 0x01, 0x02),
+		// memory section with one page
+		section(5, 0x01, 0x00, 0x01),
+		// export section
+		section(7, concat(
+			[]byte{0x02},
+			name("memory"), []byte{0x02, 0x00},
+			name("_start"), []byte{0x00, 0x02},
+		)...),
+		// code section: fd_write(1, 0, 1, 16); proc_exit(3)
+		section(10,
+			0x01, 0x11, 0x00,
+			0x41, 0x01, 0x41, 0x00, 0x41, 0x01, 0x41, 0x10, 0x10, 0x00, 0x1a,
+			0x41, 0x03, 0x10, 0x01, 0x0b,
+		),
+		// data section: iovec {8, 3} followed by "hi\n"
+		section(11, concat(
+			[]byte{0x01, 0x00, 0x41, 0x00, 0x0b, 0x0b},
+			[]byte{0x08, 0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00},
+			[]byte("hi\n"),
+		)...),
+	)
+}
+
+func TestFindModule(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wasm-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	module := helloModule()
+	path := filepath.Join(dir, "hello.sif")
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		t.Fatalf("failed to generate uuid: %s", err)
+	}
+	f, err := sif.CreateContainer(sif.CreateInfo{
+		Pathname:   path,
+		Launchstr:  sif.HdrLaunch,
+		Sifversion: sif.HdrVersion,
+		ID:         id,
+		InputDescr: []sif.DescriptorInput{
+			{
+				Datatype: sif.DataGeneric,
+				Groupid:  sif.DescrDefaultGroup,
+				Link:     sif.DescrUnusedLink,
+				Fname:    "hello" + ModuleSuffix,
+				Data:     module,
+				Size:     int64(len(module)),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create SIF image: %s", err)
+	}
+	f.UnloadContainer()
+
+	img, err := image.Init(path, false)
+	if err != nil {
+		t.Fatalf("failed to open image: %s", err)
+	}
+	defer img.File.Close()
+
+	s, err := FindModule(img)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, err := ReadModule(img, s)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(data, module) {
+		t.Errorf("unexpected module content")
+	}
+
+	img.Sections = nil
+	if _, err := FindModule(img); err != ErrNoModule {
+		t.Errorf("unexpected error %v instead of %v", err, ErrNoModule)
+	}
+}
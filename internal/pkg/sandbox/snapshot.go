@@ -0,0 +1,206 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package sandbox manages the snapshots of sandbox container directories,
+// copies of the sandbox made with reflinks when supported by the filesystem,
+// to roll back the changes made in a writable sandbox.
+package sandbox
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+)
+
+// nameFormat is the time format of the default snapshot names.
+const nameFormat = "20060102-150405"
+
+var nameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// Snapshot is a named snapshot of a sandbox.
+type Snapshot struct {
+	Name string `json:"name"`
+	// Path is the copy of the sandbox directory.
+	Path    string    `json:"path"`
+	Created time.Time `json:"created"`
+}
+
+// Dir returns the directory holding the snapshots of the sandbox, next to
+// the sandbox directory for the copies to be on the same filesystem.
+func Dir(sandbox string) (string, error) {
+	abs, err := filepath.Abs(sandbox)
+	if err != nil {
+		return "", fmt.Errorf("while resolving %s path: %s", sandbox, err)
+	}
+	if !fs.IsDir(abs) {
+		return "", fmt.Errorf("%s is not a sandbox directory", sandbox)
+	}
+	return filepath.Join(filepath.Dir(abs), "."+filepath.Base(abs)+".snapshots"), nil
+}
+
+// CheckName returns an error if name is not a valid snapshot name.
+func CheckName(name string) error {
+	if !nameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid snapshot name %q, it must start with a letter or a digit followed by letters, digits, '_' or '-'", name)
+	}
+	return nil
+}
+
+// Get returns the snapshot name of the sandbox.
+func Get(sandbox, name string) (*Snapshot, error) {
+	if err := CheckName(name); err != nil {
+		return nil, err
+	}
+	dir, err := Dir(sandbox)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no snapshot named %s for %s", name, sandbox)
+	} else if err != nil {
+		return nil, fmt.Errorf("while getting %s snapshot information: %s", name, err)
+	}
+	return newSnapshot(dir, name, fi), nil
+}
+
+// List returns the snapshots of the sandbox sorted by creation time.
+func List(sandbox string) ([]*Snapshot, error) {
+	dir, err := Dir(sandbox)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("while reading snapshot directory: %s", err)
+	}
+
+	var snapshots []*Snapshot
+	for _, fi := range entries {
+		// incomplete snapshots have an invalid name
+		if !fi.IsDir() || CheckName(fi.Name()) != nil {
+			continue
+		}
+		snapshots = append(snapshots, newSnapshot(dir, fi.Name(), fi))
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		if !snapshots[i].Created.Equal(snapshots[j].Created) {
+			return snapshots[i].Created.Before(snapshots[j].Created)
+		}
+		return snapshots[i].Name < snapshots[j].Name
+	})
+	return snapshots, nil
+}
+
+// Create takes the snapshot name of the sandbox, named after the current
+// time if name is empty.
+func Create(sandbox, name string) (*Snapshot, error) {
+	if name == "" {
+		name = time.Now().Format(nameFormat)
+	}
+	if err := CheckName(name); err != nil {
+		return nil, err
+	}
+	if _, err := Get(sandbox, name); err == nil {
+		return nil, fmt.Errorf("snapshot %s already exists", name)
+	}
+	dir, err := Dir(sandbox)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("while creating snapshot directory: %s", err)
+	}
+
+	// the snapshot is visible once complete
+	tmp := filepath.Join(dir, name+".tmp")
+	if err := os.RemoveAll(tmp); err != nil {
+		return nil, fmt.Errorf("while removing incomplete snapshot: %s", err)
+	}
+	if err := os.Mkdir(tmp, 0700); err != nil {
+		return nil, fmt.Errorf("while creating snapshot: %s", err)
+	}
+	if err := fs.CopyTree(sandbox, filepath.Join(tmp, "rootfs")); err != nil {
+		os.RemoveAll(tmp)
+		return nil, fmt.Errorf("while copying %s: %s", sandbox, err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		os.RemoveAll(tmp)
+		return nil, fmt.Errorf("while creating snapshot: %s", err)
+	}
+	return Get(sandbox, name)
+}
+
+// Rollback replaces the content of the sandbox by the one of its
+// snapshot name, the snapshot is kept.
+func Rollback(sandbox, name string) error {
+	s, err := Get(sandbox, name)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(filepath.Dir(s.Path))
+	abs, err := filepath.Abs(sandbox)
+	if err != nil {
+		return fmt.Errorf("while resolving %s path: %s", sandbox, err)
+	}
+
+	// the sandbox is left untouched until the copy is complete
+	tmp := filepath.Join(dir, "rollback.tmp")
+	if err := os.RemoveAll(tmp); err != nil {
+		return fmt.Errorf("while removing incomplete rollback: %s", err)
+	}
+	if err := fs.CopyTree(s.Path, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return fmt.Errorf("while copying %s snapshot: %s", name, err)
+	}
+
+	previous := filepath.Join(dir, "previous.tmp")
+	if err := os.RemoveAll(previous); err != nil {
+		return fmt.Errorf("while removing incomplete rollback: %s", err)
+	}
+	if err := os.Rename(abs, previous); err != nil {
+		os.RemoveAll(tmp)
+		return fmt.Errorf("while replacing %s: %s", sandbox, err)
+	}
+	if err := os.Rename(tmp, abs); err != nil {
+		if err := os.Rename(previous, abs); err != nil {
+			return fmt.Errorf("while restoring %s, its content is in %s: %s", sandbox, previous, err)
+		}
+		return fmt.Errorf("while replacing %s: %s", sandbox, err)
+	}
+	if err := os.RemoveAll(previous); err != nil {
+		return fmt.Errorf("while removing %s previous content: %s", sandbox, err)
+	}
+	return nil
+}
+
+// Remove removes the snapshot name of the sandbox.
+func Remove(sandbox, name string) error {
+	s, err := Get(sandbox, name)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Dir(s.Path)); err != nil {
+		return fmt.Errorf("while removing %s snapshot: %s", name, err)
+	}
+	return nil
+}
+
+func newSnapshot(dir, name string, fi os.FileInfo) *Snapshot {
+	return &Snapshot{
+		Name:    name,
+		Path:    filepath.Join(dir, name, "rootfs"),
+		Created: fi.ModTime(),
+	}
+}
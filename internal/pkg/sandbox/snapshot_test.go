@@ -0,0 +1,94 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sandbox
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshots(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "sandbox-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	sandbox := filepath.Join(tmp, "rootfs")
+	file := filepath.Join(sandbox, "etc", "config")
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatalf("failed to create sandbox: %s", err)
+	}
+	if err := ioutil.WriteFile(file, []byte("original"), 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+
+	if _, err := Create(filepath.Join(tmp, "missing"), "base"); err == nil {
+		t.Errorf("unexpected success with a missing sandbox")
+	}
+	if _, err := Create(sandbox, "../base"); err == nil {
+		t.Errorf("unexpected success with an invalid name")
+	}
+
+	s, err := Create(sandbox, "base")
+	if err != nil {
+		t.Fatalf("failed to create snapshot: %s", err)
+	}
+	if s.Path != filepath.Join(tmp, ".rootfs.snapshots", "base", "rootfs") {
+		t.Errorf("unexpected snapshot path %s", s.Path)
+	}
+	if _, err := Create(sandbox, "base"); err == nil {
+		t.Errorf("unexpected success with an existing snapshot")
+	}
+	if _, err := Create(sandbox, ""); err != nil {
+		t.Fatalf("failed to create snapshot: %s", err)
+	}
+
+	snapshots, err := List(sandbox)
+	if err != nil {
+		t.Fatalf("failed to list snapshots: %s", err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("got %d snapshots instead of 2", len(snapshots))
+	}
+
+	if err := ioutil.WriteFile(file, []byte("modified"), 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(sandbox, "added"), nil, 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+
+	if err := Rollback(sandbox, "missing"); err == nil {
+		t.Errorf("unexpected success with a missing snapshot")
+	}
+	if err := Rollback(sandbox, "base"); err != nil {
+		t.Fatalf("failed to roll back: %s", err)
+	}
+	if b, err := ioutil.ReadFile(file); err != nil {
+		t.Errorf("failed to read file: %s", err)
+	} else if string(b) != "original" {
+		t.Errorf("got %q instead of the original content", b)
+	}
+	if _, err := os.Stat(filepath.Join(sandbox, "added")); !os.IsNotExist(err) {
+		t.Errorf("file added after the snapshot is still present")
+	}
+	// the snapshot is kept after a rollback
+	if _, err := Get(sandbox, "base"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	for _, s := range snapshots {
+		if err := Remove(sandbox, s.Name); err != nil {
+			t.Errorf("failed to remove snapshot: %s", err)
+		}
+	}
+	if snapshots, err := List(sandbox); err != nil || len(snapshots) != 0 {
+		t.Errorf("unexpected snapshots %v left: %v", snapshots, err)
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package audit logs privileged actions taken by starter
+// when the security audit mode is enabled.
+package audit
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+var (
+	enabled int32
+	writer  io.Writer = os.Stderr
+)
+
+// Enable enables the security audit logging.
+func Enable() {
+	atomic.StoreInt32(&enabled, 1)
+}
+
+// Enabled returns whether the security audit logging is enabled.
+func Enabled() bool {
+	return atomic.LoadInt32(&enabled) == 1
+}
+
+// Logf logs a privileged action if the security audit logging is
+// enabled, the format matches the one used by starter C code.
+func Logf(format string, a ...interface{}) {
+	if !Enabled() {
+		return
+	}
+	message := fmt.Sprintf(format, a...)
+	fmt.Fprintf(writer, "AUDIT:   [U=%d,E=%d,P=%d] %s\n", os.Getuid(), os.Geteuid(), os.Getpid(), message)
+}
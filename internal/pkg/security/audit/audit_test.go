@@ -0,0 +1,33 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package audit
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLogf(t *testing.T) {
+	var buf bytes.Buffer
+
+	writer = &buf
+
+	Logf("mount %s", "disabled")
+	if buf.Len() != 0 {
+		t.Errorf("unexpected message while audit is disabled: %s", buf.String())
+	}
+
+	Enable()
+	if !Enabled() {
+		t.Fatalf("audit not enabled")
+	}
+
+	Logf("mount %s", "enabled")
+	if msg := buf.String(); !strings.HasPrefix(msg, "AUDIT:") || !strings.HasSuffix(msg, "mount enabled\n") {
+		t.Errorf("unexpected audit message: %q", msg)
+	}
+}
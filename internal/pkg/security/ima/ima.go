@@ -0,0 +1,110 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package ima records container images in the kernel IMA measurement log
+// and builds the attestation document describing images used by a container.
+package ima
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	godigest "github.com/opencontainers/go-digest"
+)
+
+const (
+	// MeasurementsPath is the path of the IMA measurement log in ASCII format.
+	MeasurementsPath = "/sys/kernel/security/ima/ascii_runtime_measurements"
+	// DocumentPath is the path of the attestation document in the container.
+	DocumentPath = "/.singularity.d/attestation.json"
+	// DocumentVersion is the current attestation document format version.
+	DocumentVersion = 1
+)
+
+// Entry is an IMA measurement log entry.
+type Entry struct {
+	PCR          int    `json:"pcr"`
+	TemplateHash string `json:"templateHash"`
+	Template     string `json:"template"`
+	FileHash     string `json:"fileHash"`
+}
+
+// Partition describes an image partition mounted in the container.
+type Partition struct {
+	Destination string `json:"destination"`
+	Type        string `json:"type"`
+	Offset      uint64 `json:"offset"`
+	Size        uint64 `json:"size"`
+	Digest      string `json:"digest"`
+}
+
+// Image describes an image file used by the container.
+type Image struct {
+	Path string `json:"path"`
+	// IMAXattr and EVMXattr are the hex encoded security.ima and
+	// security.evm extended attributes of the image file if any.
+	IMAXattr     string      `json:"imaXattr,omitempty"`
+	EVMXattr     string      `json:"evmXattr,omitempty"`
+	Measurements []Entry     `json:"measurements,omitempty"`
+	Partitions   []Partition `json:"partitions"`
+}
+
+// Document is the attestation document exposed in the container.
+type Document struct {
+	Version  int       `json:"version"`
+	Hostname string    `json:"hostname"`
+	Time     time.Time `json:"time"`
+	Images   []Image   `json:"images"`
+}
+
+// ParseMeasurements returns the entries of the ASCII measurement log
+// read from r for the file path.
+func ParseMeasurements(r io.Reader, path string) ([]Entry, error) {
+	entries := make([]Entry, 0)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// format is: PCR template-hash template file-hash path [signature]
+		fields := strings.SplitN(scanner.Text(), " ", 5)
+		if len(fields) != 5 {
+			continue
+		}
+		// path may contain spaces, signature templates append a field
+		if fields[4] != path && !strings.HasPrefix(fields[4], path+" ") {
+			continue
+		}
+		pcr, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("bad PCR value %q in measurement log", fields[0])
+		}
+		entries = append(entries, Entry{
+			PCR:          pcr,
+			TemplateHash: fields[1],
+			Template:     fields[2],
+			FileHash:     fields[3],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("while reading measurement log: %s", err)
+	}
+	return entries, nil
+}
+
+// PartitionDigest returns the digest computed with the algorithm a
+// of the size bytes located at offset in r.
+func PartitionDigest(a godigest.Algorithm, r io.ReaderAt, offset, size uint64) (string, error) {
+	d := a.Digester()
+	n, err := io.Copy(d.Hash(), io.NewSectionReader(r, int64(offset), int64(size)))
+	if err != nil {
+		return "", err
+	} else if uint64(n) != size {
+		return "", fmt.Errorf("partition truncated: read %d bytes instead of %d", n, size)
+	}
+	return d.Digest().String(), nil
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package ima
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// Enabled returns whether the IMA measurement log is available or not.
+func Enabled() bool {
+	_, err := os.Stat(MeasurementsPath)
+	return err == nil
+}
+
+// Measure opens the file path for reading, the kernel then records it
+// in the measurement log if the IMA policy measures the file, and returns
+// the measurement log entries of the file. The measurement log is only
+// readable by root.
+func Measure(path string) ([]Entry, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, fmt.Errorf("while resolving %s: %s", path, err)
+	}
+
+	f, err := os.Open(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("while opening %s: %s", resolved, err)
+	}
+	f.Close()
+
+	log, err := os.Open(MeasurementsPath)
+	if err != nil {
+		return nil, fmt.Errorf("while opening IMA measurement log: %s", err)
+	}
+	defer log.Close()
+
+	return ParseMeasurements(log, resolved)
+}
+
+// Xattrs returns the hex encoded security.ima and security.evm
+// extended attributes of the file path, missing attributes are
+// returned as empty strings.
+func Xattrs(path string) (string, string) {
+	return getxattr(path, "security.ima"), getxattr(path, "security.evm")
+}
+
+func getxattr(path, name string) string {
+	size, err := unix.Getxattr(path, name, nil)
+	if err != nil || size <= 0 {
+		return ""
+	}
+	buf := make([]byte, size)
+	size, err = unix.Getxattr(path, name, buf)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(buf[:size])
+}
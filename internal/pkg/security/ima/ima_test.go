@@ -0,0 +1,83 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package ima
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	godigest "github.com/opencontainers/go-digest"
+)
+
+const measurements = `10 91f34b5c671d73504b274a919661cf80dab1e127 ima-ng sha1:1801e1be3e65ef1eaa5c16617bec8f1274eaf6b3 boot_aggregate
+10 8b1683287f61f96e5448f40bdef6df32be86486a ima-ng sha256:efdd249edec97caf9328a4a01baa99b7d660d1afc2e118b69137081c9b689954 /image.sif
+10 ed893b1a0bc54ea5cd57014ca0a0f087ce71e4af ima-sig sha256:ab12 /image.sif 0302046e
+10 30fa7707407d41b1a471dbd5a9b2bd7c139d7e5b ima-ng sha256:cd34 /image.sif.bak
+10 c31986d6d43d4e3b2387dd1fe65b6ad6f3b25d2e ima-ng sha256:ef56 /my image.sif
+`
+
+func TestParseMeasurements(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected []string
+	}{
+		{"NoEntry", "/other.sif", []string{}},
+		{"Entries", "/image.sif", []string{"sha256:efdd249edec97caf9328a4a01baa99b7d660d1afc2e118b69137081c9b689954", "sha256:ab12"}},
+		{"Spaces", "/my image.sif", []string{"sha256:ef56"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := ParseMeasurements(strings.NewReader(measurements), tt.path)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(entries) != len(tt.expected) {
+				t.Fatalf("unexpected number of entries: got %d instead of %d", len(entries), len(tt.expected))
+			}
+			for i, e := range entries {
+				if e.PCR != 10 || e.FileHash != tt.expected[i] {
+					t.Errorf("unexpected entry %d: %+v", i, e)
+				}
+			}
+		})
+	}
+
+	if _, err := ParseMeasurements(strings.NewReader("bad hash ima-ng sha256:ab12 /image.sif\n"), "/image.sif"); err == nil {
+		t.Errorf("unexpected success with bad PCR value")
+	}
+}
+
+func TestPartitionDigest(t *testing.T) {
+	data := []byte("headerpartitiontrailer")
+	sum := sha256.Sum256([]byte("partition"))
+
+	digest, err := PartitionDigest(godigest.SHA256, bytes.NewReader(data), 6, 9)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected := "sha256:" + hex.EncodeToString(sum[:]); digest != expected {
+		t.Errorf("unexpected digest %s instead of %s", digest, expected)
+	}
+
+	sum512 := sha512.Sum512([]byte("partition"))
+	digest, err = PartitionDigest(godigest.SHA512, bytes.NewReader(data), 6, 9)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected := "sha512:" + hex.EncodeToString(sum512[:]); digest != expected {
+		t.Errorf("unexpected digest %s instead of %s", digest, expected)
+	}
+
+	if _, err := PartitionDigest(godigest.SHA256, bytes.NewReader(data), 20, 9); err == nil {
+		t.Errorf("unexpected success with truncated partition")
+	}
+}
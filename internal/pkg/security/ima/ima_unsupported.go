@@ -0,0 +1,27 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build !linux
+
+package ima
+
+import (
+	"fmt"
+)
+
+// Enabled returns whether the IMA measurement log is available or not.
+func Enabled() bool {
+	return false
+}
+
+// Measure returns an error for unsupported platforms.
+func Measure(path string) ([]Entry, error) {
+	return nil, fmt.Errorf("IMA is not supported by OS")
+}
+
+// Xattrs returns empty attributes for unsupported platforms.
+func Xattrs(path string) (string, string) {
+	return "", ""
+}
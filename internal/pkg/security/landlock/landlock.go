@@ -0,0 +1,130 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package landlock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// Filesystem access rights as defined by the Landlock ABI version 1.
+const (
+	AccessFsExecute    uint64 = 1 << 0
+	AccessFsWriteFile  uint64 = 1 << 1
+	AccessFsReadFile   uint64 = 1 << 2
+	AccessFsReadDir    uint64 = 1 << 3
+	AccessFsRemoveDir  uint64 = 1 << 4
+	AccessFsRemoveFile uint64 = 1 << 5
+	AccessFsMakeChar   uint64 = 1 << 6
+	AccessFsMakeDir    uint64 = 1 << 7
+	AccessFsMakeReg    uint64 = 1 << 8
+	AccessFsMakeSock   uint64 = 1 << 9
+	AccessFsMakeFifo   uint64 = 1 << 10
+	AccessFsMakeBlock  uint64 = 1 << 11
+	AccessFsMakeSym    uint64 = 1 << 12
+
+	// AccessFsFile regroups access rights applying to regular files.
+	AccessFsFile = AccessFsExecute | AccessFsWriteFile | AccessFsReadFile
+	// AccessFsAll regroups all access rights supported by ABI version 1.
+	AccessFsAll uint64 = 1<<13 - 1
+)
+
+var accessRights = map[string]uint64{
+	"execute":     AccessFsExecute,
+	"write_file":  AccessFsWriteFile,
+	"read_file":   AccessFsReadFile,
+	"read_dir":    AccessFsReadDir,
+	"remove_dir":  AccessFsRemoveDir,
+	"remove_file": AccessFsRemoveFile,
+	"make_char":   AccessFsMakeChar,
+	"make_dir":    AccessFsMakeDir,
+	"make_reg":    AccessFsMakeReg,
+	"make_sock":   AccessFsMakeSock,
+	"make_fifo":   AccessFsMakeFifo,
+	"make_block":  AccessFsMakeBlock,
+	"make_sym":    AccessFsMakeSym,
+	// convenient aliases
+	"read":  AccessFsReadFile | AccessFsReadDir,
+	"write": AccessFsAll &^ (AccessFsExecute | AccessFsReadFile | AccessFsReadDir),
+	"all":   AccessFsAll,
+}
+
+// Rule grants access rights to a set of paths and to the
+// hierarchy beneath them.
+type Rule struct {
+	Paths  []string `json:"paths"`
+	Access []string `json:"access"`
+}
+
+// Profile describes a Landlock ruleset applied to the container
+// process. Paths are resolved inside the container.
+type Profile struct {
+	// HandledAccess lists the access rights restricted by the ruleset,
+	// all access rights are restricted when empty.
+	HandledAccess []string `json:"handledAccess,omitempty"`
+	Rules         []Rule   `json:"rules"`
+	// BestEffort allows the container to run unrestricted when
+	// Landlock is not supported or enabled by the kernel.
+	BestEffort bool `json:"bestEffort,omitempty"`
+}
+
+// ParseAccess returns the access rights mask corresponding to
+// the access right names.
+func ParseAccess(names []string) (uint64, error) {
+	var mask uint64
+
+	for _, name := range names {
+		access, ok := accessRights[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown landlock access right %q", name)
+		}
+		mask |= access
+	}
+	return mask, nil
+}
+
+// Handled returns the access rights mask restricted by the profile.
+func (p *Profile) Handled() (uint64, error) {
+	if len(p.HandledAccess) == 0 {
+		return AccessFsAll, nil
+	}
+	return ParseAccess(p.HandledAccess)
+}
+
+// ParseProfile parses and validates a JSON Landlock profile.
+func ParseProfile(data []byte) (*Profile, error) {
+	p := new(Profile)
+
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("while parsing landlock profile: %s", err)
+	}
+	if _, err := p.Handled(); err != nil {
+		return nil, err
+	}
+	for i, r := range p.Rules {
+		if len(r.Paths) == 0 {
+			return nil, fmt.Errorf("landlock profile rule %d has no paths", i)
+		}
+		if _, err := ParseAccess(r.Access); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// LoadProfileFromFile reads and validates the Landlock profile from
+// a JSON file and returns its content.
+func LoadProfileFromFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("while reading landlock profile: %s", err)
+	}
+	if _, err := ParseProfile(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package landlock
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/hpcng/singularity/pkg/sylog"
+	"golang.org/x/sys/unix"
+)
+
+// Landlock system calls share the same number on all architectures.
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	createRulesetVersion = 1 << 0
+	rulePathBeneath      = 1
+)
+
+type rulesetAttr struct {
+	handledAccessFs uint64
+}
+
+// pathBeneathAttr is packed on the kernel side, the
+// trailing padding added by Go is never read.
+type pathBeneathAttr struct {
+	allowedAccess uint64
+	parentFd      int32
+}
+
+// ABIVersion returns the Landlock ABI version supported by
+// the running kernel or an error if Landlock is not available.
+func ABIVersion() (int, error) {
+	v, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, createRulesetVersion)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(v), nil
+}
+
+// Enabled returns whether Landlock is supported and enabled
+// by the running kernel.
+func Enabled() bool {
+	v, err := ABIVersion()
+	return err == nil && v > 0
+}
+
+// Apply restricts the current thread and its future children
+// with the ruleset described by the JSON profile. Landlock only
+// applies to the calling thread, so the caller must be locked
+// on its OS thread.
+func Apply(data []byte) error {
+	p, err := ParseProfile(data)
+	if err != nil {
+		return err
+	}
+	handled, err := p.Handled()
+	if err != nil {
+		return err
+	}
+
+	attr := rulesetAttr{handledAccessFs: handled}
+	fd, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("while creating landlock ruleset: %s", errno)
+	}
+	defer syscall.Close(int(fd))
+
+	for _, r := range p.Rules {
+		access, err := ParseAccess(r.Access)
+		if err != nil {
+			return err
+		}
+		for _, path := range r.Paths {
+			if err := addPathRule(int(fd), path, access&handled); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("while setting no new privileges: %s", err)
+	}
+	if _, _, errno := syscall.Syscall(sysLandlockRestrictSelf, fd, 0, 0); errno != 0 {
+		return fmt.Errorf("while applying landlock ruleset: %s", errno)
+	}
+	return nil
+}
+
+func addPathRule(rulesetFd int, path string, access uint64) error {
+	pfd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if os.IsNotExist(err) {
+		sylog.Warningf("Landlock rule path %s doesn't exist in container, skipping it", path)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("while opening landlock rule path %s: %s", path, err)
+	}
+	defer syscall.Close(pfd)
+
+	var st syscall.Stat_t
+	if err := syscall.Fstat(pfd, &st); err != nil {
+		return fmt.Errorf("while getting %s information: %s", path, err)
+	}
+	// directory specific rights can't be granted on files
+	if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		access &= AccessFsFile
+	}
+	if access == 0 {
+		return nil
+	}
+
+	attr := pathBeneathAttr{allowedAccess: access, parentFd: int32(pfd)}
+	_, _, errno := syscall.Syscall6(sysLandlockAddRule, uintptr(rulesetFd), rulePathBeneath, uintptr(unsafe.Pointer(&attr)), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("while adding landlock rule for %s: %s", path, errno)
+	}
+	return nil
+}
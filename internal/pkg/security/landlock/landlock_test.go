@@ -0,0 +1,125 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package landlock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestParseProfile(t *testing.T) {
+	tests := []struct {
+		name        string
+		profile     string
+		handled     uint64
+		bestEffort  bool
+		expectError bool
+	}{
+		{
+			name:    "DefaultHandled",
+			profile: `{"rules": [{"paths": ["/usr"], "access": ["read", "execute"]}]}`,
+			handled: AccessFsAll,
+		},
+		{
+			name:    "CustomHandled",
+			profile: `{"handledAccess": ["write"], "rules": [{"paths": ["/tmp"], "access": ["write"]}]}`,
+			handled: AccessFsAll &^ (AccessFsExecute | AccessFsReadFile | AccessFsReadDir),
+		},
+		{
+			name:       "BestEffort",
+			profile:    `{"bestEffort": true, "rules": [{"paths": ["/usr"], "access": ["read"]}]}`,
+			handled:    AccessFsAll,
+			bestEffort: true,
+		},
+		{
+			name:        "UnknownAccess",
+			profile:     `{"rules": [{"paths": ["/usr"], "access": ["fly"]}]}`,
+			expectError: true,
+		},
+		{
+			name:        "NoPaths",
+			profile:     `{"rules": [{"access": ["read"]}]}`,
+			expectError: true,
+		},
+		{
+			name:        "BadJSON",
+			profile:     `{"rules": `,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParseProfile([]byte(tt.profile))
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if handled, _ := p.Handled(); handled != tt.handled {
+				t.Errorf("unexpected handled access: got %#x instead of %#x", handled, tt.handled)
+			}
+			if p.BestEffort != tt.bestEffort {
+				t.Errorf("unexpected best effort: got %v instead of %v", p.BestEffort, tt.bestEffort)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	if !Enabled() {
+		t.Skip("landlock not supported by kernel")
+	}
+
+	dir, err := ioutil.TempDir("", "landlock-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	allowed := filepath.Join(dir, "allowed")
+	denied := filepath.Join(dir, "denied")
+	for _, d := range []string{allowed, denied} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(d, "file"), []byte("content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	profile := `{"handledAccess": ["read"], "rules": [{"paths": ["` + allowed + `"], "access": ["read"]}]}`
+
+	errCh := make(chan error, 1)
+	go func() {
+		// the thread is restricted and is terminated
+		// with the goroutine as it's never unlocked
+		runtime.LockOSThread()
+
+		if err := Apply([]byte(profile)); err != nil {
+			errCh <- err
+			return
+		}
+		if _, err := ioutil.ReadFile(filepath.Join(allowed, "file")); err != nil {
+			errCh <- err
+			return
+		}
+		if _, err := ioutil.ReadFile(filepath.Join(denied, "file")); !os.IsPermission(err) {
+			t.Errorf("unexpected error while reading denied file: %v", err)
+		}
+		errCh <- nil
+	}()
+
+	if err := <-errCh; err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
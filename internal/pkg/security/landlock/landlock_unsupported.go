@@ -0,0 +1,27 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build !linux
+
+package landlock
+
+import (
+	"fmt"
+)
+
+// ABIVersion returns an error for unsupported platforms.
+func ABIVersion() (int, error) {
+	return 0, fmt.Errorf("landlock is not supported by OS")
+}
+
+// Enabled returns whether Landlock is supported or not.
+func Enabled() bool {
+	return false
+}
+
+// Apply returns an error for unsupported platforms.
+func Apply(data []byte) error {
+	return fmt.Errorf("landlock is not supported by OS")
+}
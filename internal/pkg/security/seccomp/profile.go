@@ -0,0 +1,135 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package seccomp
+
+import (
+	"runtime"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	// DefaultProfileName is the name designating the builtin seccomp profile.
+	DefaultProfileName = "default"
+	// UnconfinedProfileName is the name disabling seccomp filtering.
+	UnconfinedProfileName = "unconfined"
+)
+
+// defaultDeniedSyscalls lists system calls denied by the builtin
+// profile, they allow to modify the host system state (kernel modules,
+// clocks, swap, reboot...) or are known to be abused to escape from
+// containers, and are not required by regular workloads.
+var defaultDeniedSyscalls = []string{
+	"_sysctl",
+	"acct",
+	"bpf",
+	"clock_adjtime",
+	"clock_settime",
+	"create_module",
+	"delete_module",
+	"finit_module",
+	"get_kernel_syms",
+	"init_module",
+	"ioperm",
+	"iopl",
+	"kexec_file_load",
+	"kexec_load",
+	"lookup_dcookie",
+	"nfsservctl",
+	"open_by_handle_at",
+	"query_module",
+	"reboot",
+	"settimeofday",
+	"stime",
+	"swapoff",
+	"swapon",
+	"syslog",
+	"uselib",
+	"vm86",
+	"vm86old",
+}
+
+// compatArchitectures returns the architectures that can be
+// used by processes on the current architecture, so the filter
+// can't be bypassed with compatibility system calls.
+func compatArchitectures() []specs.Arch {
+	switch runtime.GOARCH {
+	case "amd64":
+		return []specs.Arch{specs.ArchX86_64, specs.ArchX86, specs.ArchX32}
+	case "386":
+		return []specs.Arch{specs.ArchX86}
+	case "arm64":
+		return []specs.Arch{specs.ArchAARCH64, specs.ArchARM}
+	case "arm":
+		return []specs.Arch{specs.ArchARM}
+	case "ppc64le":
+		return []specs.Arch{specs.ArchPPC64LE}
+	case "s390x":
+		return []specs.Arch{specs.ArchS390X, specs.ArchS390}
+	}
+	return nil
+}
+
+// DefaultProfile returns the builtin seccomp profile applied to
+// containers when no profile is requested.
+func DefaultProfile() *specs.LinuxSeccomp {
+	return &specs.LinuxSeccomp{
+		DefaultAction: specs.ActAllow,
+		Architectures: compatArchitectures(),
+		Syscalls: []specs.LinuxSyscall{
+			{
+				Names:  append([]string{}, defaultDeniedSyscalls...),
+				Action: specs.ActErrno,
+			},
+		},
+	}
+}
+
+// Customize modifies the seccomp profile to explicitly allow and
+// deny system calls, allowed and denied system calls are removed
+// from the existing rules before adding the corresponding rules.
+func Customize(config *specs.LinuxSeccomp, allow []string, deny []string) {
+	if config == nil || (len(allow) == 0 && len(deny) == 0) {
+		return
+	}
+
+	remove := make(map[string]bool)
+	for _, name := range allow {
+		remove[name] = true
+	}
+	for _, name := range deny {
+		remove[name] = true
+	}
+
+	syscalls := make([]specs.LinuxSyscall, 0, len(config.Syscalls)+2)
+	for _, s := range config.Syscalls {
+		names := make([]string, 0, len(s.Names))
+		for _, name := range s.Names {
+			if !remove[name] {
+				names = append(names, name)
+			}
+		}
+		if len(names) > 0 {
+			s.Names = names
+			syscalls = append(syscalls, s)
+		}
+	}
+
+	// a rule matching the default action is rejected
+	if len(allow) > 0 && config.DefaultAction != specs.ActAllow {
+		syscalls = append(syscalls, specs.LinuxSyscall{
+			Names:  allow,
+			Action: specs.ActAllow,
+		})
+	}
+	if len(deny) > 0 && config.DefaultAction != specs.ActErrno {
+		syscalls = append(syscalls, specs.LinuxSyscall{
+			Names:  deny,
+			Action: specs.ActErrno,
+		})
+	}
+	config.Syscalls = syscalls
+}
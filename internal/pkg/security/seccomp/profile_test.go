@@ -0,0 +1,39 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package seccomp
+
+import (
+	"reflect"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestCustomize(t *testing.T) {
+	config := &specs.LinuxSeccomp{
+		DefaultAction: specs.ActAllow,
+		Syscalls: []specs.LinuxSyscall{
+			{Names: []string{"reboot", "swapon", "mount"}, Action: specs.ActErrno},
+			{Names: []string{"bpf"}, Action: specs.ActKill},
+		},
+	}
+
+	Customize(config, []string{"mount", "bpf"}, []string{"ptrace"})
+
+	expected := []specs.LinuxSyscall{
+		{Names: []string{"reboot", "swapon"}, Action: specs.ActErrno},
+		{Names: []string{"ptrace"}, Action: specs.ActErrno},
+	}
+	if !reflect.DeepEqual(config.Syscalls, expected) {
+		t.Errorf("unexpected syscall rules: got %v instead of %v", config.Syscalls, expected)
+	}
+
+	// builtin denied syscalls must not be altered by customizations
+	Customize(DefaultProfile(), defaultDeniedSyscalls, nil)
+	if names := DefaultProfile().Syscalls[0].Names; !reflect.DeepEqual(names, defaultDeniedSyscalls) {
+		t.Errorf("builtin profile was modified: %v", names)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package signservice
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hpcng/singularity/pkg/sylog"
+	"golang.org/x/crypto/openpgp"
+)
+
+// CheckURL returns an error if the signing service URL u isn't an https URL,
+// which would let the token or key of the service be intercepted.
+func CheckURL(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return fmt.Errorf("invalid signing service URL %s: %s", u, err)
+	}
+	if parsed.Scheme != "https" || parsed.Host == "" {
+		return fmt.Errorf("signing service URL %s is not an https URL", u)
+	}
+	return nil
+}
+
+// PinnedKey returns the public key of the service checked against the
+// fingerprint pinned for the service URL in pinFile. The fingerprint of the
+// key is pinned the first time the service is used, a different key is
+// rejected until its pin is removed from pinFile.
+func (c *Client) PinnedKey(ctx context.Context, pinFile string) (*openpgp.Entity, error) {
+	e, err := c.PublicKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := strings.ToUpper(hex.EncodeToString(e.PrimaryKey.Fingerprint[:]))
+
+	pins := make(map[string]string)
+	b, err := ioutil.ReadFile(pinFile)
+	if err == nil {
+		if err := json.Unmarshal(b, &pins); err != nil {
+			return nil, fmt.Errorf("while decoding %s: %s", pinFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("while reading %s: %s", pinFile, err)
+	}
+
+	if pinned, ok := pins[c.URL]; ok {
+		if pinned != fingerprint {
+			return nil, fmt.Errorf("key %s of signing service %s doesn't match the pinned key %s, remove its entry from %s if the key was replaced", fingerprint, c.URL, pinned, pinFile)
+		}
+		return e, nil
+	}
+
+	pins[c.URL] = fingerprint
+	b, err = json.MarshalIndent(pins, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(pinFile), 0700); err != nil {
+		return nil, fmt.Errorf("while creating %s: %s", filepath.Dir(pinFile), err)
+	}
+	tmp := pinFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(b, '\n'), 0600); err != nil {
+		return nil, fmt.Errorf("while writing %s: %s", tmp, err)
+	}
+	if err := os.Rename(tmp, pinFile); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("while writing %s: %s", pinFile, err)
+	}
+	sylog.Warningf("Trusting key %s of signing service %s on first use, pinned in %s", fingerprint, c.URL, pinFile)
+	return e, nil
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package signservice implements a client of remote signing services, which
+// sign digests with keys kept on the service, like HSM backed keys. The
+// service API is:
+//
+//	GET  /v1/key  returns the armored OpenPGP public key of the signing key.
+//	POST /v1/sign signs the digest of the JSON request
+//	              {"fingerprint": "<hex>", "hash": "SHA256", "digest": "<base64>"}
+//	              and returns {"signature": "<base64>"}, a PKCS #1 v1.5
+//	              signature for RSA keys or an ASN.1 DER signature for ECDSA
+//	              keys.
+//
+// Requests are authenticated with a bearer token when set.
+package signservice
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hpcng/singularity/pkg/sylog"
+	useragent "github.com/hpcng/singularity/pkg/util/user-agent"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// Client is a client of a signing service.
+type Client struct {
+	// URL is the base URL of the service.
+	URL string
+	// Token is the bearer token authenticating the requests.
+	Token string
+	// HTTPClient is the client sending the requests.
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client of the signing service at url authenticated
+// with token.
+func NewClient(url, token string) *Client {
+	return &Client{
+		URL:        strings.TrimSuffix(url, "/"),
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *Client) do(ctx context.Context, method, route string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.URL+route, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", useragent.Value())
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("while requesting signing service: %s", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+			return nil, fmt.Errorf("access denied by signing service: %s", res.Status)
+		}
+		return nil, fmt.Errorf("signing service request failed: %s", res.Status)
+	}
+	return res, nil
+}
+
+// PublicKey returns the public key of the signing key of the service.
+func (c *Client) PublicKey(ctx context.Context) (*openpgp.Entity, error) {
+	res, err := c.do(ctx, http.MethodGet, "/v1/key", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	el, err := openpgp.ReadArmoredKeyRing(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("while reading signing service key: %s", err)
+	}
+	if len(el) != 1 {
+		return nil, fmt.Errorf("signing service returned %d keys, expected one", len(el))
+	}
+	return el[0], nil
+}
+
+// Entity returns the signing key of the service, its private key signs the
+// digests with the service.
+func (c *Client) Entity(ctx context.Context) (*openpgp.Entity, error) {
+	e, err := c.PublicKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch e.PrimaryKey.PubKeyAlgo {
+	case packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSASignOnly, packet.PubKeyAlgoECDSA:
+	default:
+		return nil, fmt.Errorf("unsupported signing service key algorithm %d", e.PrimaryKey.PubKeyAlgo)
+	}
+	fingerprint := strings.ToUpper(hex.EncodeToString(e.PrimaryKey.Fingerprint[:]))
+	sylog.Verbosef("Signing with key %s of %s", fingerprint, c.URL)
+
+	e.PrivateKey = &packet.PrivateKey{
+		PublicKey: *e.PrimaryKey,
+		PrivateKey: &remoteSigner{
+			ctx:         ctx,
+			c:           c,
+			fingerprint: fingerprint,
+			public:      e.PrimaryKey.PublicKey,
+		},
+	}
+	return e, nil
+}
+
+// remoteSigner is a crypto.Signer signing with the service.
+type remoteSigner struct {
+	ctx         context.Context
+	c           *Client
+	fingerprint string
+	public      crypto.PublicKey
+}
+
+func (s *remoteSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+// hashNames are the names of the hashes of the sign requests.
+var hashNames = map[crypto.Hash]string{
+	crypto.SHA256: "SHA256",
+	crypto.SHA384: "SHA384",
+	crypto.SHA512: "SHA512",
+}
+
+func (s *remoteSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	request := struct {
+		Fingerprint string `json:"fingerprint"`
+		Hash        string `json:"hash,omitempty"`
+		Digest      string `json:"digest"`
+	}{
+		Fingerprint: s.fingerprint,
+		Digest:      base64.StdEncoding.EncodeToString(digest),
+	}
+	// ECDSA digests are signed without hash options
+	if opts != nil {
+		name, ok := hashNames[opts.HashFunc()]
+		if !ok {
+			return nil, fmt.Errorf("unsupported signature hash %v", opts.HashFunc())
+		}
+		request.Hash = name
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.c.do(s.ctx, http.MethodPost, "/v1/sign", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var response struct {
+		Signature string `json:"signature"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("while decoding signing service response: %s", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(response.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("while decoding signature: %s", err)
+	}
+	if len(sig) == 0 {
+		return nil, errors.New("empty signature returned by signing service")
+	}
+	return sig, nil
+}
@@ -0,0 +1,200 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package signservice
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	useragent "github.com/hpcng/singularity/pkg/util/user-agent"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// testService is a signing service signing with the key of e.
+func testService(t *testing.T, e *openpgp.Entity, token string) *httptest.Server {
+	var pub bytes.Buffer
+	w, err := armor.Encode(&pub, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatalf("failed to armor key: %s", err)
+	}
+	if err := e.Serialize(w); err != nil {
+		t.Fatalf("failed to serialize key: %s", err)
+	}
+	w.Close()
+
+	hashes := map[string]crypto.Hash{"SHA256": crypto.SHA256, "SHA384": crypto.SHA384, "SHA512": crypto.SHA512}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/key":
+			w.Write(pub.Bytes())
+		case "/v1/sign":
+			var req struct {
+				Fingerprint string `json:"fingerprint"`
+				Hash        string `json:"hash"`
+				Digest      string `json:"digest"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			digest, err := base64.StdEncoding.DecodeString(req.Digest)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			sig, err := rsa.SignPKCS1v15(rand.Reader, e.PrivateKey.PrivateKey.(*rsa.PrivateKey), hashes[req.Hash], digest)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"signature": base64.StdEncoding.EncodeToString(sig)})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestClient(t *testing.T) {
+	useragent.InitValue("singularity", "3.0.0")
+
+	key, err := openpgp.NewEntity("Signer", "", "signer@example.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatalf("failed to create key: %s", err)
+	}
+	srv := testService(t, key, "token")
+	defer srv.Close()
+
+	ctx := context.Background()
+
+	if _, err := NewClient(srv.URL, "bad").Entity(ctx); err == nil || !strings.Contains(err.Error(), "access denied") {
+		t.Errorf("unexpected error with bad token: %v", err)
+	}
+
+	e, err := NewClient(srv.URL+"/", "token").Entity(ctx)
+	if err != nil {
+		t.Fatalf("failed to get entity: %s", err)
+	}
+	if e.PrimaryKey.Fingerprint != key.PrimaryKey.Fingerprint {
+		t.Fatalf("unexpected key %X", e.PrimaryKey.Fingerprint)
+	}
+
+	// sign like SIF integrity signatures and verify with the public key
+	var buf bytes.Buffer
+	w, err := clearsign.Encode(&buf, e.PrivateKey, &packet.Config{DefaultHash: crypto.SHA256})
+	if err != nil {
+		t.Fatalf("failed to sign: %s", err)
+	}
+	w.Write([]byte("signed content\n"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to sign: %s", err)
+	}
+
+	b, _ := clearsign.Decode(buf.Bytes())
+	if b == nil {
+		t.Fatalf("no signature found")
+	}
+	signer, err := openpgp.CheckDetachedSignature(openpgp.EntityList{key}, bytes.NewReader(b.Bytes), b.ArmoredSignature.Body)
+	if err != nil {
+		t.Fatalf("failed to verify signature: %s", err)
+	}
+	if signer.PrimaryKey.KeyId != key.PrimaryKey.KeyId {
+		t.Errorf("unexpected signer %X", signer.PrimaryKey.KeyId)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"https://signer.example", false},
+		{"https://signer.example:8443/api", false},
+		{"http://signer.example", true},
+		{"signer.example", true},
+		{"https://", true},
+	}
+	for _, tt := range tests {
+		if err := CheckURL(tt.url); (err != nil) != tt.wantErr {
+			t.Errorf("unexpected result for %s: %v", tt.url, err)
+		}
+	}
+}
+
+func TestPinnedKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signservice-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	pinFile := filepath.Join(dir, "signers.json")
+
+	newKey := func() *openpgp.Entity {
+		key, err := openpgp.NewEntity("Signer", "", "signer@example.com", &packet.Config{RSABits: 1024})
+		if err != nil {
+			t.Fatalf("failed to create key: %s", err)
+		}
+		return key
+	}
+	key := newKey()
+	srv := testService(t, key, "token")
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := NewClient(srv.URL, "token")
+
+	// the key is pinned on first use
+	for i := 0; i < 2; i++ {
+		e, err := c.PinnedKey(ctx, pinFile)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if e.PrimaryKey.Fingerprint != key.PrimaryKey.Fingerprint {
+			t.Fatalf("unexpected key %X", e.PrimaryKey.Fingerprint)
+		}
+	}
+	fi, err := os.Stat(pinFile)
+	if err != nil {
+		t.Fatalf("key not pinned: %s", err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("pin file created with mode %o", fi.Mode().Perm())
+	}
+
+	// another key of the same service is rejected
+	other := testService(t, newKey(), "token")
+	defer other.Close()
+	b, err := ioutil.ReadFile(pinFile)
+	if err != nil {
+		t.Fatalf("failed to read pin file: %s", err)
+	}
+	pinned := strings.Replace(string(b), srv.URL, other.URL, 1)
+	if err := ioutil.WriteFile(pinFile, []byte(pinned), 0600); err != nil {
+		t.Fatalf("failed to write pin file: %s", err)
+	}
+	if _, err := NewClient(other.URL, "token").PinnedKey(ctx, pinFile); err == nil || !strings.Contains(err.Error(), "doesn't match the pinned key") {
+		t.Errorf("unexpected error with a replaced key: %v", err)
+	}
+}
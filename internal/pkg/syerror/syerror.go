@@ -0,0 +1,251 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package syerror defines the taxonomy of the errors reported by the
+// commands, each error code having a stable exit code, a subsystem and a
+// remediation hint, and reports the fatal errors as JSON objects.
+package syerror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+// JSONEnv is the environment variable enabling the JSON error reports,
+// set for the processes started by the command line.
+const JSONEnv = "SINGULARITY_JSON_ERRORS"
+
+// Code is a stable error code.
+type Code string
+
+// Error codes, the exit codes follow sysexits(3).
+const (
+	Usage            Code = "USAGE"
+	NotFound         Code = "NOT_FOUND"
+	ImageFormat      Code = "IMAGE_FORMAT"
+	PermissionDenied Code = "PERMISSION_DENIED"
+	Quarantine       Code = "QUARANTINE"
+	Unauthorized     Code = "UNAUTHORIZED"
+	Network          Code = "NETWORK"
+	Signature        Code = "SIGNATURE"
+	Config           Code = "CONFIG"
+	Fakeroot         Code = "FAKEROOT"
+	Cgroups          Code = "CGROUPS"
+	InstanceNotFound Code = "INSTANCE_NOT_FOUND"
+	InstanceExists   Code = "INSTANCE_EXISTS"
+	NoSpace          Code = "NO_SPACE"
+	Canceled         Code = "CANCELED"
+	Container        Code = "CONTAINER"
+	Unknown          Code = "UNKNOWN"
+)
+
+// Class describes the errors of a code.
+type Class struct {
+	Code      Code
+	Subsystem string
+	ExitCode  int
+	Hint      string
+	// pattern matches the messages of the errors without code.
+	pattern *regexp.Regexp
+}
+
+// classes is the error taxonomy, messages are matched against the
+// patterns in order.
+var classes = []Class{
+	{
+		Code: Canceled, Subsystem: "cli", ExitCode: 130,
+		Hint:    "The command was interrupted, run it again to complete it.",
+		pattern: regexp.MustCompile(`(?i)context canceled`),
+	},
+	{
+		Code: Quarantine, Subsystem: "image", ExitCode: 77,
+		Hint:    "Release the pulled image with 'singularity release' once reviewed.",
+		pattern: regexp.MustCompile(`(?i)quarantine`),
+	},
+	{
+		Code: Fakeroot, Subsystem: "fakeroot", ExitCode: 77,
+		Hint:    "Ask the administrator to map subordinate IDs to the user with 'singularity config fakeroot --add'.",
+		pattern: regexp.MustCompile(`(?i)fakeroot|subuid|subgid`),
+	},
+	{
+		Code: Cgroups, Subsystem: "cgroups", ExitCode: 69,
+		Hint:    "Check the cgroups support of the host and the resource limits requested.",
+		pattern: regexp.MustCompile(`(?i)cgroup`),
+	},
+	{
+		Code: InstanceNotFound, Subsystem: "instance", ExitCode: 66,
+		Hint:    "List the running instances with 'singularity instance list'.",
+		pattern: regexp.MustCompile(`(?i)no instance found|instance \S+ not found`),
+	},
+	{
+		Code: InstanceExists, Subsystem: "instance", ExitCode: 73,
+		Hint:    "Stop the instance with 'singularity instance stop' or choose another instance name.",
+		pattern: regexp.MustCompile(`(?i)instance \S+ already exists`),
+	},
+	{
+		Code: Signature, Subsystem: "signature", ExitCode: 65,
+		Hint:    "Check the image signatures with 'singularity verify' and the keys of the keyring.",
+		pattern: regexp.MustCompile(`(?i)signature|failed to verify|verification failed|integrity`),
+	},
+	{
+		Code: Unauthorized, Subsystem: "registry", ExitCode: 77,
+		Hint:    "Log in to the registry with 'singularity remote login' or pass credentials with --docker-login.",
+		pattern: regexp.MustCompile(`(?i)unauthorized|authentication required|access denied|denied: requested access`),
+	},
+	{
+		Code: Network, Subsystem: "network", ExitCode: 69,
+		Hint:    "Check the network connection, the proxy settings and the registry or library URI.",
+		pattern: regexp.MustCompile(`(?i)connection refused|no such host|i/o timeout|network is unreachable|tls handshake|connection reset`),
+	},
+	{
+		Code: ImageFormat, Subsystem: "image", ExitCode: 65,
+		Hint:    "Check the image is a SIF file, a sandbox directory or a supported image URI.",
+		pattern: regexp.MustCompile(`(?i)image format not recognized|unsupported image format|not a valid sif`),
+	},
+	{
+		Code: NoSpace, Subsystem: "system", ExitCode: 74,
+		Hint:    "Free disk space or a quota, or set SINGULARITY_TMPDIR and SINGULARITY_CACHEDIR to a larger filesystem.",
+		pattern: regexp.MustCompile(`(?i)no space left on device|disk quota exceeded`),
+	},
+	{
+		Code: Config, Subsystem: "config", ExitCode: 78,
+		Hint:    "Check the singularity.conf settings with the administrator.",
+		pattern: regexp.MustCompile(`(?i)singularity\.conf|configuration file|by configuration|by the configuration|not allowed by`),
+	},
+	{
+		Code: PermissionDenied, Subsystem: "system", ExitCode: 77,
+		Hint:    "Check the file permissions, or run the command as root or with --fakeroot if required.",
+		pattern: regexp.MustCompile(`(?i)permission denied|operation not permitted|requires to be root|must be root|only root`),
+	},
+	{
+		Code: NotFound, Subsystem: "image", ExitCode: 66,
+		Hint:    "Check the path or URI of the image or file.",
+		pattern: regexp.MustCompile(`(?i)no such file or directory|not found|does not exist`),
+	},
+	{
+		Code: Container, Subsystem: "runtime", ExitCode: 70,
+		Hint:    "Run the command with --debug for the details of the container setup.",
+		pattern: regexp.MustCompile(`(?i)container creation failed|engine|starter`),
+	},
+	{
+		Code: Usage, Subsystem: "cli", ExitCode: 64,
+		Hint:    "Run the command with --help for its usage.",
+		pattern: regexp.MustCompile(`(?i)mutually exclusive|only one of|unknown flag|invalid argument`),
+	},
+	{
+		Code: Unknown, Subsystem: "cli", ExitCode: 255,
+		Hint: "Run the command with --debug for more details.",
+	},
+}
+
+// Lookup returns the class of code, the Unknown class if code is not in
+// the taxonomy.
+func Lookup(code Code) Class {
+	for _, c := range classes {
+		if c.Code == code {
+			return c
+		}
+	}
+	return classes[len(classes)-1]
+}
+
+// Error is an error with a code of the taxonomy.
+type Error struct {
+	Code Code
+	Err  error
+}
+
+// New returns the error err with code.
+func New(code Code, err error) *Error {
+	return &Error{Code: code, Err: err}
+}
+
+// Errorf returns an error with code formatted like fmt.Errorf.
+func Errorf(code Code, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Err: fmt.Errorf(format, a...)}
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Report is the JSON object describing an error.
+type Report struct {
+	Code      Code   `json:"code"`
+	Subsystem string `json:"subsystem"`
+	Message   string `json:"message"`
+	Hint      string `json:"hint,omitempty"`
+	ExitCode  int    `json:"exitCode"`
+}
+
+// Classify returns the report of the error message, the code is the
+// one of the first *Error found in args or, failing that, the one matched
+// by the message.
+func Classify(message string, args ...interface{}) Report {
+	class := Lookup(Unknown)
+	found := false
+	for _, a := range args {
+		var e *Error
+		if err, ok := a.(error); ok && errors.As(err, &e) {
+			class, found = Lookup(e.Code), true
+			break
+		}
+	}
+	if !found {
+		for _, c := range classes {
+			if c.pattern != nil && c.pattern.MatchString(message) {
+				class = c
+				break
+			}
+		}
+	}
+	return Report{
+		Code:      class.Code,
+		Subsystem: class.Subsystem,
+		Message:   message,
+		Hint:      class.Hint,
+		ExitCode:  class.ExitCode,
+	}
+}
+
+// Write writes the JSON report of the error message to w.
+func Write(w io.Writer, r Report) error {
+	return json.NewEncoder(w).Encode(r)
+}
+
+// Exit writes the JSON report of the error message to the standard error
+// and exits with the exit code of its class.
+func Exit(r Report) {
+	Write(os.Stderr, r)
+	os.Exit(r.ExitCode)
+}
+
+// Enable reports the messages of sylog.Fatalf as JSON objects on the
+// standard error and sets JSONEnv for the processes started by the
+// command.
+func Enable() {
+	os.Setenv(JSONEnv, "1")
+	sylog.SetFatalHandler(func(format string, a ...interface{}) {
+		message := strings.TrimRight(fmt.Sprintf(format, a...), "\n")
+		Exit(Classify(message, a...))
+	})
+}
+
+// Enabled returns true if JSON error reports are enabled by JSONEnv.
+func Enabled() bool {
+	return os.Getenv(JSONEnv) == "1"
+}
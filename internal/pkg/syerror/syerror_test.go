@@ -0,0 +1,77 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package syerror
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  string
+		args     []interface{}
+		code     Code
+		exitCode int
+	}{
+		{"ImageFormat", "While describing README.md: image format not recognized", nil, ImageFormat, 65},
+		{"NotFound", "could not open image /tmp/x.sif: stat /tmp/x.sif: no such file or directory", nil, NotFound, 66},
+		{"Network", "Unable to handle docker://alpine uri: dial tcp: lookup registry-1.docker.io: no such host", nil, Network, 69},
+		{"Unauthorized", "Failed to pull image: unauthorized: authentication required", nil, Unauthorized, 77},
+		{"Quarantine", "Pulled images are held in quarantine, pull alpine and release it before running it", nil, Quarantine, 77},
+		{"InstanceExists", "instance web already exists", nil, InstanceExists, 73},
+		{"InstanceNotFound", "no instance found", nil, InstanceNotFound, 66},
+		{"Permission", "Only root user can stop system instances", nil, PermissionDenied, 77},
+		{"Usage", "--system and --user are mutually exclusive", nil, Usage, 64},
+		{"Unknown", "something unexpected happened", nil, Unknown, 255},
+		{
+			name:     "ExplicitCode",
+			message:  "while pulling image: no such file or directory",
+			args:     []interface{}{fmt.Errorf("wrapped: %w", New(Network, errors.New("no such file or directory")))},
+			code:     Network,
+			exitCode: 69,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Classify(tt.message, tt.args...)
+			if r.Code != tt.code || r.ExitCode != tt.exitCode {
+				t.Errorf("got code %s (exit %d) instead of %s (exit %d)", r.Code, r.ExitCode, tt.code, tt.exitCode)
+			}
+			if r.Message != tt.message {
+				t.Errorf("got message %q instead of %q", r.Message, tt.message)
+			}
+			if r.Subsystem == "" || r.Hint == "" {
+				t.Errorf("missing subsystem or hint in %+v", r)
+			}
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	var b bytes.Buffer
+	if err := Write(&b, Classify("instance web already exists")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := `{"code":"INSTANCE_EXISTS","subsystem":"instance","message":"instance web already exists",` +
+		`"hint":"Stop the instance with 'singularity instance stop' or choose another instance name.","exitCode":73}` + "\n"
+	if b.String() != expected {
+		t.Errorf("got %s instead of %s", b.String(), expected)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	if c := Lookup("NO_SUCH_CODE"); c.Code != Unknown || c.ExitCode != 255 {
+		t.Errorf("got class %+v for an unknown code", c)
+	}
+	if c := Lookup(Config); c.ExitCode != 78 || c.Subsystem != "config" {
+		t.Errorf("unexpected class %+v for %s", c, Config)
+	}
+}
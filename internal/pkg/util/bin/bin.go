@@ -88,3 +88,38 @@ func cryptsetup(cfgpath string) (string, error) {
 	// use exec.LookPath to verify it's an executable.
 	return exec.LookPath(path)
 }
+
+// dmsetupDirs are the directories searched for dmsetup when
+// its path is not set in the configuration file.
+var dmsetupDirs = []string{"/usr/sbin", "/sbin", "/usr/bin", "/bin"}
+
+// Dmsetup returns the absolute path to the "dmsetup" program, either
+// set in the configuration file or found in system directories. The
+// user PATH is never used as dmsetup is executed with root privileges.
+func Dmsetup() (string, error) {
+	path := ""
+
+	if cfg := singularityconf.GetCurrentConfig(); cfg != nil {
+		path = cfg.DmsetupPath
+	} else {
+		cfg, err := singularityconf.Parse(buildcfg.SINGULARITY_CONF_FILE)
+		if err != nil {
+			return "", errors.Wrap(err, "unable to parse singularity configuration file")
+		}
+		path = cfg.DmsetupPath
+	}
+
+	if path != "" {
+		if !filepath.IsAbs(path) {
+			return "", errors.Errorf("dmsetup path %s is not absolute", path)
+		}
+		return exec.LookPath(path)
+	}
+
+	for _, dir := range dmsetupDirs {
+		if path, err := exec.LookPath(filepath.Join(dir, "dmsetup")); err == nil {
+			return path, nil
+		}
+	}
+	return "", errors.New("dmsetup not found")
+}
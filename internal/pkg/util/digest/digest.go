@@ -0,0 +1,102 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package digest provides the selection of the digest algorithm used to
+// compute image digests and cache keys. Digests are always recorded as
+// <algorithm>:<hex> so the algorithm can be changed without breaking
+// the digests already recorded.
+package digest
+
+import (
+	// register the hash functions of the supported algorithms
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hpcng/singularity/pkg/util/singularityconf"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+// Supported digest algorithms.
+const (
+	SHA256 = godigest.SHA256
+	SHA384 = godigest.SHA384
+	SHA512 = godigest.SHA512
+	// BLAKE3 is recognized for forward compatibility but no implementation
+	// is available in this build.
+	BLAKE3 = godigest.Algorithm("blake3")
+
+	// Default is the digest algorithm used if none is selected.
+	Default = SHA256
+)
+
+// Algorithm returns the digest algorithm named name, the default algorithm
+// if name is empty.
+func Algorithm(name string) (godigest.Algorithm, error) {
+	a := godigest.Algorithm(strings.ToLower(strings.TrimSpace(name)))
+	switch a {
+	case "":
+		return Default, nil
+	case SHA256, SHA384, SHA512:
+		if a.Available() {
+			return a, nil
+		}
+	case BLAKE3:
+		return "", fmt.Errorf("%s digest algorithm is not available in this build", a)
+	}
+	return "", fmt.Errorf("unsupported digest algorithm %q: must be one of %s, %s or %s", name, SHA256, SHA384, SHA512)
+}
+
+// Configured returns the digest algorithm selected by the digest algorithm
+// directive of the current configuration, the default algorithm if there
+// is no current configuration.
+func Configured() godigest.Algorithm {
+	if c := singularityconf.GetCurrentConfig(); c != nil {
+		if a, err := Algorithm(c.DigestAlgorithm); err == nil {
+			return a
+		}
+	}
+	return Default
+}
+
+// Parse parses the digest s of the form <algorithm>:<hex>.
+func Parse(s string) (godigest.Digest, error) {
+	d := godigest.Digest(strings.ToLower(strings.TrimSpace(s)))
+	i := strings.Index(string(d), ":")
+	if i <= 0 {
+		return "", fmt.Errorf("invalid digest %s: must be of the form <algorithm>:<hex>", s)
+	}
+	if _, err := Algorithm(string(d[:i])); err != nil {
+		return "", fmt.Errorf("invalid digest %s: %s", s, err)
+	}
+	if err := d.Validate(); err != nil {
+		return "", fmt.Errorf("invalid digest %s: %s", s, err)
+	}
+	return d, nil
+}
+
+// FromFile returns the digest of the file at path computed with the
+// algorithm a.
+func FromFile(a godigest.Algorithm, path string) (godigest.Digest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return a.FromReader(f)
+}
+
+// CacheKey returns the cache entry name of the digest d. The encoded
+// digest is used as is for the default algorithm, so existing cache
+// entries remain valid, and prefixed by the algorithm otherwise.
+func CacheKey(d godigest.Digest) string {
+	if d.Algorithm() == Default {
+		return d.Encoded()
+	}
+	return d.Algorithm().String() + "-" + d.Encoded()
+}
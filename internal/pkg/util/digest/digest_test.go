@@ -0,0 +1,75 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package digest
+
+import (
+	"strings"
+	"testing"
+
+	godigest "github.com/opencontainers/go-digest"
+)
+
+func TestAlgorithm(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected godigest.Algorithm
+		wantErr  bool
+	}{
+		{"", SHA256, false},
+		{"sha256", SHA256, false},
+		{"SHA512", SHA512, false},
+		{" sha384 ", SHA384, false},
+		{"blake3", "", true},
+		{"md5", "", true},
+	}
+
+	for _, tt := range tests {
+		a, err := Algorithm(tt.name)
+		if tt.wantErr && err == nil {
+			t.Errorf("unexpected success for %q", tt.name)
+		} else if !tt.wantErr && err != nil {
+			t.Errorf("unexpected error for %q: %s", tt.name, err)
+		} else if a != tt.expected {
+			t.Errorf("got algorithm %q for %q, expected %q", a, tt.name, tt.expected)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	sha256 := SHA256.FromString("content")
+	sha512 := SHA512.FromString("content")
+
+	tests := []struct {
+		digest  string
+		wantErr bool
+	}{
+		{sha256.String(), false},
+		{strings.ToUpper(sha512.String()), false},
+		{"sha256:1234", true},
+		{"blake3:" + sha256.Encoded(), true},
+		{sha256.Encoded(), true},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(tt.digest)
+		if tt.wantErr && err == nil {
+			t.Errorf("unexpected success for %q", tt.digest)
+		} else if !tt.wantErr && err != nil {
+			t.Errorf("unexpected error for %q: %s", tt.digest, err)
+		}
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	sha256 := SHA256.FromString("content")
+	if key := CacheKey(sha256); key != sha256.Encoded() {
+		t.Errorf("got cache key %s, expected %s", key, sha256.Encoded())
+	}
+	sha512 := SHA512.FromString("content")
+	if key := CacheKey(sha512); key != "sha512-"+sha512.Encoded() {
+		t.Errorf("got cache key %s, expected sha512-%s", key, sha512.Encoded())
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package env
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+// Policy removes the environment variables denied by the administrator
+// from the environment of the container process.
+type Policy struct {
+	deny  []*regexp.Regexp
+	allow []*regexp.Regexp
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		if p == "" {
+			continue
+		}
+		// patterns match the whole variable name
+		re, err := regexp.Compile("^(?:" + p + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("bad environment variable pattern %q: %s", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// NewPolicy returns a policy removing the variables whose name matches
+// one of the deny regular expressions, except those matching one of
+// the allow regular expressions.
+func NewPolicy(deny, allow []string) (*Policy, error) {
+	var err error
+
+	p := new(Policy)
+	if p.deny, err = compilePatterns(deny); err != nil {
+		return nil, err
+	}
+	if p.allow, err = compilePatterns(allow); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func matchAny(res []*regexp.Regexp, name string) bool {
+	for _, re := range res {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Denied returns if the variable name is removed by the policy.
+func (p *Policy) Denied(name string) bool {
+	return matchAny(p.deny, name) && !matchAny(p.allow, name)
+}
+
+// Apply returns the environment variables of environ, in the KEY=VALUE
+// format, allowed by the policy and the names of the variables removed.
+func (p *Policy) Apply(environ []string) ([]string, []string) {
+	if len(p.deny) == 0 {
+		return environ, nil
+	}
+
+	kept := make([]string, 0, len(environ))
+	var stripped []string
+	for _, e := range environ {
+		name := strings.SplitN(e, "=", 2)[0]
+		if p.Denied(name) {
+			stripped = append(stripped, name)
+			continue
+		}
+		kept = append(kept, e)
+	}
+	return kept, stripped
+}
+
+// CheckPolicy returns an error if the deny or allow regular expressions
+// set with the env deny and env allow directives are not valid.
+func CheckPolicy(deny, allow []string) error {
+	if _, err := NewPolicy(deny, allow); err != nil {
+		return fmt.Errorf("while parsing env deny and env allow directives: %s", err)
+	}
+	return nil
+}
+
+// ApplyPolicy removes the environment variables denied by the administrator
+// with the deny and allow regular expressions from environ.
+func ApplyPolicy(environ, deny, allow []string) ([]string, error) {
+	policy, err := NewPolicy(deny, allow)
+	if err != nil {
+		return nil, err
+	}
+	environ, stripped := policy.Apply(environ)
+	if len(stripped) > 0 {
+		sylog.Infof("Removed environment variables denied by the administrator: %s", strings.Join(stripped, ", "))
+	}
+	return environ, nil
+}
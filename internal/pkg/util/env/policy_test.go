@@ -0,0 +1,107 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package env
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	environ := []string{
+		"PATH=/bin",
+		"LD_PRELOAD=/tmp/inject.so",
+		"LD_LIBRARY_PATH=/.singularity.d/libs",
+		"LD_AUDIT_PATH=",
+		"PYTHONPATH=/tmp",
+		"MY_PYTHONPATH=/tmp",
+		"LD_PRELOADED",
+	}
+
+	tt := []struct {
+		name         string
+		deny         []string
+		allow        []string
+		wantEnv      []string
+		wantStripped []string
+		wantErr      bool
+	}{
+		{
+			name:    "no policy",
+			wantEnv: environ,
+		},
+		{
+			name:  "deny",
+			deny:  []string{"LD_PRELOAD", "PYTHON(PATH|STARTUP)"},
+			allow: []string{},
+			wantEnv: []string{
+				"PATH=/bin",
+				"LD_LIBRARY_PATH=/.singularity.d/libs",
+				"LD_AUDIT_PATH=",
+				"MY_PYTHONPATH=/tmp",
+				"LD_PRELOADED",
+			},
+			wantStripped: []string{"LD_PRELOAD", "PYTHONPATH"},
+		},
+		{
+			name:  "deny with allow",
+			deny:  []string{"LD_.*"},
+			allow: []string{"LD_LIBRARY_PATH"},
+			wantEnv: []string{
+				"PATH=/bin",
+				"LD_LIBRARY_PATH=/.singularity.d/libs",
+				"PYTHONPATH=/tmp",
+				"MY_PYTHONPATH=/tmp",
+			},
+			wantStripped: []string{"LD_PRELOAD", "LD_AUDIT_PATH", "LD_PRELOADED"},
+		},
+		{
+			name:    "bad pattern",
+			deny:    []string{"LD_("},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := NewPolicy(tc.deny, tc.allow)
+			if err != nil && !tc.wantErr {
+				t.Fatalf("unexpected error: %s", err)
+			} else if err == nil && tc.wantErr {
+				t.Fatalf("unexpected success")
+			} else if err != nil {
+				return
+			}
+
+			env, stripped := p.Apply(environ)
+			if !reflect.DeepEqual(env, tc.wantEnv) {
+				t.Errorf("got environment %v instead of %v", env, tc.wantEnv)
+			}
+			if !reflect.DeepEqual(stripped, tc.wantStripped) {
+				t.Errorf("got stripped variables %v instead of %v", stripped, tc.wantStripped)
+			}
+		})
+	}
+}
+
+func TestApplyPolicy(t *testing.T) {
+	environ := []string{"HOME=/root", "LD_PRELOAD=/lib/evil.so"}
+
+	env, err := ApplyPolicy(environ, []string{"LD_.*"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := []string{"HOME=/root"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("got environment %v instead of %v", env, want)
+	}
+
+	if _, err := ApplyPolicy(environ, []string{"LD_("}, nil); err == nil {
+		t.Errorf("unexpected success with a bad pattern")
+	}
+	if err := CheckPolicy(nil, []string{"LD_("}); err == nil {
+		t.Errorf("unexpected success with a bad allow pattern")
+	}
+}
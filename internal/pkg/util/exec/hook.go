@@ -1,4 +1,4 @@
-// Copyright (c) 2018, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -10,31 +10,98 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os/exec"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/opencontainers/runtime-spec/specs-go"
 )
 
-// Hook execute an OCI hook command and pass state over stdin.
-func Hook(ctx context.Context, hook *specs.Hook, state *specs.State) error {
-	var cancel context.CancelFunc
-	var timeout time.Duration
-	var cmd *exec.Cmd
+// HookOptions holds the options of OCI hooks execution.
+type HookOptions struct {
+	// Stdout and Stderr receive the output of the hooks,
+	// it's discarded if nil.
+	Stdout io.Writer
+	Stderr io.Writer
+	// Parallel executes the hooks concurrently.
+	Parallel bool
+	// StopOnError stops the execution at the first failing
+	// hook, hooks running concurrently are killed.
+	StopOnError bool
+}
 
-	if hook.Timeout != nil {
-		timeout = time.Duration(*hook.Timeout) * 1000 * time.Millisecond
+// Hooks executes the OCI hook commands in order, or concurrently
+// with opts.Parallel, and pass state over stdin. The errors of the
+// failed hooks are returned.
+func Hooks(ctx context.Context, hooks []specs.Hook, state *specs.State, opts HookOptions) []error {
+	var errs []error
+	var stdout, stderr io.Writer
+
+	if ctx == nil {
+		ctx = context.Background()
 	}
 
-	if timeout != 0 {
-		ctx, cancel = context.WithTimeout(ctx, timeout)
-		defer cancel()
+	// hooks running concurrently share the writers
+	if opts.Stdout != nil {
+		stdout = &lockedWriter{w: opts.Stdout}
+	}
+	if opts.Stderr != nil {
+		stderr = &lockedWriter{w: opts.Stderr}
+	}
+
+	if !opts.Parallel {
+		for i := range hooks {
+			if err := runHook(ctx, &hooks[i], state, stdout, stderr); err != nil {
+				errs = append(errs, err)
+				if opts.StopOnError {
+					break
+				}
+			}
+		}
+		return errs
 	}
 
-	if ctx != nil {
-		cmd = exec.CommandContext(ctx, hook.Path)
-	} else {
-		cmd = exec.Command(hook.Path)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := range hooks {
+		wg.Add(1)
+		go func(h *specs.Hook) {
+			defer wg.Done()
+
+			if err := runHook(ctx, h, state, stdout, stderr); err != nil {
+				mutex.Lock()
+				// hooks killed after the first failure are not reported
+				if ctx.Err() == nil || !opts.StopOnError {
+					errs = append(errs, err)
+				}
+				mutex.Unlock()
+				if opts.StopOnError {
+					cancel()
+				}
+			}
+		}(&hooks[i])
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// runHook executes an OCI hook command in its own process group, the
+// process group is killed once the hook timeout expires or ctx is done.
+func runHook(ctx context.Context, hook *specs.Hook, state *specs.State, stdout, stderr io.Writer) error {
+	if hook.Timeout != nil {
+		if *hook.Timeout <= 0 {
+			return fmt.Errorf("hook %s: timeout must be greater than zero", hook.Path)
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(*hook.Timeout)*time.Second)
+		defer cancel()
 	}
 
 	data, err := json.Marshal(state)
@@ -42,23 +109,50 @@ func Hook(ctx context.Context, hook *specs.Hook, state *specs.State) error {
 		return fmt.Errorf("failed to marshal state data: %s", err)
 	}
 
+	cmd := exec.Command(hook.Path)
 	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 	cmd.Env = hook.Env
 	cmd.Args = hook.Args
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
-	err = cmd.Start()
-	if err != nil {
+	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("failed to execute hook %s: %s", hook.Path, err)
 	}
 
-	err = cmd.Wait()
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// kill the hook and the processes it spawned, Wait
+		// returns once they closed the output pipes
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		if hook.Timeout != nil && ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("hook %s timed out after %d seconds", hook.Path, *hook.Timeout)
+		}
+		return fmt.Errorf("hook %s interrupted: %s", hook.Path, ctx.Err())
+	}
 	if err != nil {
-		return fmt.Errorf("hook execution failed: %s", err)
+		return fmt.Errorf("hook %s execution failed: %s", hook.Path, err)
 	}
 
-	if ctx != nil && ctx.Err() == context.DeadlineExceeded {
-		return fmt.Errorf("hook time out")
-	}
+	return nil
+}
+
+// lockedWriter serializes the writes of concurrent hooks.
+type lockedWriter struct {
+	sync.Mutex
+	w io.Writer
+}
 
-	return err
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.Lock()
+	defer l.Unlock()
+	return l.w.Write(p)
 }
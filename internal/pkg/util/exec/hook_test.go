@@ -0,0 +1,126 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package exec
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func shellHook(script string, timeout int) specs.Hook {
+	h := specs.Hook{
+		Path: "/bin/sh",
+		Args: []string{"sh", "-c", script},
+	}
+	if timeout > 0 {
+		h.Timeout = &timeout
+	}
+	return h
+}
+
+func TestHooks(t *testing.T) {
+	state := &specs.State{ID: "test"}
+
+	tests := []struct {
+		name           string
+		hooks          []specs.Hook
+		opts           HookOptions
+		expectedErrors int
+		expectedError  string
+		expectedOutput string
+		maxDuration    time.Duration
+	}{
+		{
+			name: "State",
+			hooks: []specs.Hook{
+				shellHook(`grep -q '"id":"test"'`, 0),
+			},
+		},
+		{
+			name: "Output",
+			hooks: []specs.Hook{
+				shellHook("echo hook", 0),
+			},
+			expectedOutput: "hook\n",
+		},
+		{
+			name: "Timeout",
+			hooks: []specs.Hook{
+				shellHook("sleep 60 & sleep 60", 1),
+			},
+			expectedErrors: 1,
+			expectedError:  "timed out",
+			maxDuration:    10 * time.Second,
+		},
+		{
+			name: "StopOnError",
+			hooks: []specs.Hook{
+				shellHook("false", 0),
+				shellHook("echo hook", 0),
+			},
+			opts:           HookOptions{StopOnError: true},
+			expectedErrors: 1,
+		},
+		{
+			name: "ContinueOnError",
+			hooks: []specs.Hook{
+				shellHook("false", 0),
+				shellHook("echo hook", 0),
+			},
+			expectedErrors: 1,
+			expectedOutput: "hook\n",
+		},
+		{
+			name: "Parallel",
+			hooks: []specs.Hook{
+				shellHook("sleep 2", 0),
+				shellHook("sleep 2", 0),
+				shellHook("sleep 2", 0),
+			},
+			opts:        HookOptions{Parallel: true},
+			maxDuration: 5 * time.Second,
+		},
+		{
+			name: "ParallelStopOnError",
+			hooks: []specs.Hook{
+				shellHook("false", 0),
+				shellHook("sleep 60", 0),
+			},
+			opts:           HookOptions{Parallel: true, StopOnError: true},
+			expectedErrors: 1,
+			maxDuration:    10 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+
+			tt.opts.Stdout = &out
+			start := time.Now()
+			errs := Hooks(context.Background(), tt.hooks, state, tt.opts)
+			if len(errs) != tt.expectedErrors {
+				t.Errorf("unexpected errors %v", errs)
+			}
+			if out.String() != tt.expectedOutput {
+				t.Errorf("unexpected output %q", out.String())
+			}
+			if d := time.Since(start); tt.maxDuration > 0 && d > tt.maxDuration {
+				t.Errorf("hooks took %s", d)
+			}
+			for _, err := range errs {
+				if !strings.Contains(err.Error(), tt.expectedError) {
+					t.Errorf("unexpected error %s", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package fs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// WriteFileAtomic writes data to the file path created with mode, which
+// is not altered by the process umask, the file is replaced atomically so readers never see a partially written
+// file and a crashed writer never leaves a truncated file. The data is
+// written to an anonymous O_TMPFILE file, or to a named temporary file on
+// filesystems not supporting O_TMPFILE like NFS, renamed to path once
+// synced.
+func WriteFileAtomic(path string, data []byte, mode os.FileMode) error {
+	dir := filepath.Dir(path)
+
+	tmpPath, err := writeTmpFile(dir, filepath.Base(path), data, mode)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("while replacing %s: %s", path, err)
+	}
+
+	// persist the rename, some filesystems don't support
+	// directory sync
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// writeTmpFile writes data to a temporary file of dir and returns its path.
+func writeTmpFile(dir, base string, data []byte, mode os.FileMode) (string, error) {
+	fd, err := unix.Open(dir, unix.O_TMPFILE|unix.O_WRONLY|unix.O_CLOEXEC, uint32(mode.Perm()))
+	if err == nil {
+		f := os.NewFile(uintptr(fd), base)
+		defer f.Close()
+
+		if err := f.Chmod(mode.Perm()); err != nil {
+			return "", fmt.Errorf("while setting temporary file permissions: %s", err)
+		}
+		if err := writeSync(f, data); err != nil {
+			return "", fmt.Errorf("while writing temporary file for %s: %s", base, err)
+		}
+
+		// the anonymous file is linked under a temporary name, a crash
+		// before that step doesn't leave any file behind
+		tmpPath := filepath.Join(dir, fmt.Sprintf(".%s.%d-%d.tmp", base, os.Getpid(), time.Now().UnixNano()))
+		procPath := fmt.Sprintf("/proc/self/fd/%d", fd)
+		err = unix.Linkat(unix.AT_FDCWD, procPath, unix.AT_FDCWD, tmpPath, unix.AT_SYMLINK_FOLLOW)
+		if err == nil {
+			return tmpPath, nil
+		}
+		// /proc may not be available, fallback to a named file
+	} else if err != unix.EOPNOTSUPP && err != unix.EISDIR && err != unix.EINVAL {
+		return "", fmt.Errorf("while creating temporary file for %s: %s", base, err)
+	}
+
+	f, err := ioutil.TempFile(dir, "."+base+".tmp-")
+	if err != nil {
+		return "", fmt.Errorf("while creating temporary file for %s: %s", base, err)
+	}
+	defer f.Close()
+
+	if err := f.Chmod(mode.Perm()); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("while setting temporary file permissions: %s", err)
+	}
+	if err := writeSync(f, data); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("while writing temporary file for %s: %s", base, err)
+	}
+	return f.Name(), nil
+}
+
+// writeSync writes data to f and flushes it to the storage.
+func writeSync(f *os.File, data []byte) error {
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	return f.Sync()
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package fs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// CreateBeneath creates the missing components of path, relative to the
+// directory root, as directories with mode 0755, except the last one which
+// is created as an empty file with mode 0644 if file is true. Components
+// are created and opened relative to their parent directory descriptor
+// without following symlinks, so path can't escape root even if its
+// content is modified concurrently, symlinks are refused instead.
+func CreateBeneath(root, path string, file bool) error {
+	rel := filepath.Clean(path)
+	if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+		return fmt.Errorf("%s is not a relative path beneath %s", path, root)
+	}
+
+	fd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: root, Err: err}
+	}
+	defer func() { unix.Close(fd) }()
+
+	if rel == "." {
+		return nil
+	}
+
+	current := root
+	elems := strings.Split(rel, string(filepath.Separator))
+	for i, elem := range elems {
+		current = filepath.Join(current, elem)
+
+		if i == len(elems)-1 && file {
+			f, err := unix.Openat(fd, elem, unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0644)
+			if err == unix.EEXIST {
+				var st unix.Stat_t
+				if err := unix.Fstatat(fd, elem, &st, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+					return &os.PathError{Op: "stat", Path: current, Err: err}
+				}
+				if st.Mode&unix.S_IFMT == unix.S_IFLNK {
+					return fmt.Errorf("%s is a symlink", current)
+				}
+				return nil
+			} else if err != nil {
+				return &os.PathError{Op: "create", Path: current, Err: err}
+			}
+			return unix.Close(f)
+		}
+
+		if err := unix.Mkdirat(fd, elem, 0755); err != nil && err != unix.EEXIST {
+			return &os.PathError{Op: "mkdir", Path: current, Err: err}
+		}
+		// a symlink is refused as it's not opened as a directory
+		next, err := unix.Openat(fd, elem, unix.O_PATH|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+		if err == unix.ENOTDIR || err == unix.ELOOP {
+			return fmt.Errorf("%s is not a directory or is a symlink", current)
+		} else if err != nil {
+			return &os.PathError{Op: "open", Path: current, Err: err}
+		}
+		unix.Close(fd)
+		fd = next
+	}
+	return nil
+}
@@ -6,10 +6,12 @@
 package fs
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -398,6 +400,18 @@ func CopyFileAtomic(from, to string, mode os.FileMode) (err error) {
 	return nil
 }
 
+// CopyTree copies the directory src to dst, preserving ownerships,
+// permissions and extended attributes.
+func CopyTree(src, dst string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("cp", "-a", "--reflink=auto", src, dst)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("cp failed: %v: %s", err, stderr.String())
+	}
+	return nil
+}
+
 // IsWritable returns true of the file that is passed in
 // is writable by the user (note: uid is checked, not euid).
 func IsWritable(path string) bool {
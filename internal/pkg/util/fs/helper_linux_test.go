@@ -11,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
 
 	"github.com/hpcng/singularity/internal/pkg/test"
@@ -788,3 +789,124 @@ func TestForceRemoveAll(t *testing.T) {
 		t.Errorf("ForceRemoveAll failed to remove %s", testDir)
 	}
 }
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atomic-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+
+	for _, content := range []string{`{"status":"created"}`, `{}`} {
+		if err := WriteFileAtomic(path, []byte(content), 0600); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if string(b) != content {
+			t.Errorf("got content %q, expected %q", b, content)
+		}
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("got mode %o, expected 0600", fi.Mode().Perm())
+	}
+
+	// no temporary file is left behind
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(files) != 1 {
+		t.Errorf("got %d files in %s, expected 1", len(files), dir)
+	}
+
+	// the mode is not altered by the umask
+	oldmask := syscall.Umask(077)
+	err = WriteFileAtomic(path, nil, 0644)
+	syscall.Umask(oldmask)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if fi, err := os.Stat(path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if fi.Mode().Perm() != 0644 {
+		t.Errorf("got mode %o, expected 0644", fi.Mode().Perm())
+	}
+
+	if err := WriteFileAtomic(filepath.Join(dir, "missing", "file"), nil, 0644); err == nil {
+		t.Errorf("unexpected success with missing parent directory")
+	}
+}
+
+func TestCreateBeneath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beneath-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := filepath.Join(dir, "root")
+	outside := filepath.Join(dir, "outside")
+	for _, d := range []string{root, outside} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatalf("failed to create %s: %s", d, err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatalf("failed to create symlink: %s", err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "file"), filepath.Join(root, "filelink")); err != nil {
+		t.Fatalf("failed to create symlink: %s", err)
+	}
+
+	tests := []struct {
+		name        string
+		path        string
+		file        bool
+		expectError bool
+	}{
+		{name: "Directories", path: "a/b/c"},
+		{name: "File", path: "a/d/file", file: true},
+		{name: "ExistingFile", path: "a/d/file", file: true},
+		{name: "SymlinkParent", path: "link/a", expectError: true},
+		{name: "SymlinkDir", path: "link", expectError: true},
+		{name: "SymlinkFile", path: "filelink", file: true, expectError: true},
+		{name: "Outside", path: "../outside/a", expectError: true},
+		{name: "Absolute", path: "/a", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CreateBeneath(root, tt.path, tt.file)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			fi, err := os.Lstat(filepath.Join(root, tt.path))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if fi.Mode().IsRegular() != tt.file {
+				t.Errorf("unexpected file mode %s", fi.Mode())
+			}
+		})
+	}
+
+	// nothing was created outside of root
+	if files, err := ioutil.ReadDir(outside); err != nil || len(files) != 0 {
+		t.Errorf("unexpected files created outside of root: %v %v", files, err)
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package mount
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/hpcng/singularity/pkg/util/fs/proc"
+)
+
+// Failure describes a failed mount operation.
+type Failure struct {
+	// Source is the resolved mount source, Destination the path in the
+	// container and Target the resolved mount point.
+	Source      string
+	Destination string
+	Target      string
+	// FSType is the filesystem type, empty for bind mounts.
+	FSType string
+	// Options are the mount options.
+	Options []string
+	// UserNS is set when mounting in a user namespace.
+	UserNS bool
+	// MountInfo is the mountinfo file of the mount namespace.
+	MountInfo string
+	// Err is the mount error.
+	Err error
+}
+
+// Cause is the identifier of a likely cause of a mount failure.
+type Cause string
+
+// Likely causes of mount failures.
+const (
+	// LockedFlags is reported when a bind mount in a user namespace
+	// drops the flags of the source mount, which are locked.
+	LockedFlags Cause = "locked-flags"
+	// NosuidParent is reported when the source is on a nosuid mount.
+	NosuidParent Cause = "nosuid-parent"
+	// FuseAllowOther is reported when the source is on a FUSE mount
+	// not accessible to other users.
+	FuseAllowOther Cause = "fuse-allow-other"
+	// OverlayNetworkFS is reported when overlay directories are on a
+	// network or parallel filesystem.
+	OverlayNetworkFS Cause = "overlay-network-fs"
+	// OverlayUserNS is reported when an overlay mount in a user
+	// namespace fails.
+	OverlayUserNS Cause = "overlay-userns"
+	// UnsupportedFS is reported when the kernel doesn't support the
+	// filesystem type.
+	UnsupportedFS Cause = "unsupported-fs"
+	// TypeMismatch is reported when binding a file on a directory or a
+	// directory on a file.
+	TypeMismatch Cause = "type-mismatch"
+	// ReadOnlyTarget is reported when the mount point is on a read-only
+	// filesystem.
+	ReadOnlyTarget Cause = "read-only-target"
+)
+
+// networkFS lists the network and parallel filesystems not supported as
+// overlay upper directory.
+var networkFS = map[string]bool{
+	"nfs":       true,
+	"nfs4":      true,
+	"cifs":      true,
+	"smb3":      true,
+	"lustre":    true,
+	"gpfs":      true,
+	"beegfs":    true,
+	"ceph":      true,
+	"glusterfs": true,
+}
+
+// Error is a mount error with its diagnostics.
+type Error struct {
+	Failure
+	// Message is the summary of the failure.
+	Message string
+	// SourceMount is the mount holding the source, if any.
+	SourceMount *proc.MountInfoEntry
+	// Namespace describes the namespace context of the mount.
+	Namespace string
+	// Causes are the likely causes of the failure with their message.
+	Causes map[Cause]string
+	// order lists Causes in detection order.
+	order []Cause
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Message)
+
+	line := func(label, format string, a ...interface{}) {
+		fmt.Fprintf(&b, "\n  %-14s %s", translate(label)+":", fmt.Sprintf(format, a...))
+	}
+	if e.Source != "" {
+		line("source", "%s", e.Source)
+	}
+	if e.Target != "" && e.Target != e.Destination {
+		line("destination", "%s (%s)", e.Destination, e.Target)
+	} else {
+		line("destination", "%s", e.Destination)
+	}
+	fstype := e.FSType
+	if fstype == "" {
+		fstype = "bind"
+	}
+	if e.SourceMount != nil {
+		line("filesystem", "%s, %s", fstype, fmt.Sprintf(translate("source-mount"),
+			e.SourceMount.FSType, e.SourceMount.Point, strings.Join(e.SourceMount.Options, ",")))
+	} else {
+		line("filesystem", "%s", fstype)
+	}
+	if len(e.Options) > 0 {
+		line("options", "%s", strings.Join(e.Options, ","))
+	}
+	if e.Namespace != "" {
+		line("namespace", "%s", e.Namespace)
+	}
+	for _, c := range e.order {
+		line("likely cause", "%s", e.Causes[c])
+	}
+	return b.String()
+}
+
+// Unwrap returns the mount error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Diagnose returns the error of the mount failure f summarized by message,
+// with the mount context and the likely causes found.
+func Diagnose(message string, f Failure) *Error {
+	e := &Error{
+		Failure:   f,
+		Message:   message,
+		Namespace: namespaceContext(f.UserNS),
+		Causes:    make(map[Cause]string),
+	}
+
+	var entries []proc.MountInfoEntry
+	if f.MountInfo != "" {
+		entries, _ = proc.GetMountInfoEntry(f.MountInfo)
+	}
+	lookup := func(path string) *proc.MountInfoEntry {
+		if entries == nil || path == "" {
+			return nil
+		}
+		entry, err := proc.FindParentMountEntry(path, entries)
+		if err != nil {
+			return nil
+		}
+		return entry
+	}
+	if f.FSType == "" {
+		e.SourceMount = lookup(f.Source)
+	}
+	e.diagnose(lookup, proc.HasFilesystem, fuseAllowOther())
+	return e
+}
+
+// diagnose finds the likely causes of the failure, lookup returns the
+// mount holding a path, hasFS returns whether the kernel supports a
+// filesystem and allowOther is true if user_allow_other is set in
+// /etc/fuse.conf.
+func (e *Error) diagnose(lookup func(string) *proc.MountInfoEntry, hasFS func(string) (bool, error), allowOther bool) {
+	var errno syscall.Errno
+	if !errors.As(e.Err, &errno) {
+		return
+	}
+	denied := errno == syscall.EPERM || errno == syscall.EACCES
+
+	switch errno {
+	case syscall.ENODEV:
+		if e.FSType != "" {
+			if ok, err := hasFS(e.FSType); err == nil && !ok {
+				e.add(UnsupportedFS, e.FSType)
+			}
+		}
+	case syscall.ENOTDIR:
+		e.add(TypeMismatch)
+	case syscall.EROFS:
+		e.add(ReadOnlyTarget)
+	}
+
+	if src := e.SourceMount; src != nil && denied {
+		if strings.HasPrefix(src.FSType, "fuse") && !HasOption(src.SuperOptions, "allow_other") && !HasOption(src.Options, "allow_other") {
+			if allowOther {
+				e.add(FuseAllowOther, src.Point, translate("fuse-remount"))
+			} else {
+				e.add(FuseAllowOther, src.Point, translate("fuse-conf"))
+			}
+		}
+		if e.UserNS {
+			var locked []string
+			for _, o := range []string{"ro", "nosuid", "nodev", "noexec"} {
+				if HasOption(src.Options, o) && !HasOption(e.Options, o) {
+					locked = append(locked, o)
+				}
+			}
+			if len(locked) > 0 {
+				e.add(LockedFlags, src.Point, strings.Join(locked, ","))
+			}
+		} else if HasOption(src.Options, "nosuid") {
+			e.add(NosuidParent, src.Point)
+		}
+	}
+
+	if e.FSType == "overlay" {
+		for _, o := range e.Options {
+			kv := strings.SplitN(o, "=", 2)
+			if len(kv) != 2 || (kv[0] != "lowerdir" && kv[0] != "upperdir" && kv[0] != "workdir") {
+				continue
+			}
+			for _, dir := range strings.Split(kv[1], ":") {
+				if m := lookup(dir); m != nil && (networkFS[m.FSType] || strings.HasPrefix(m.FSType, "fuse")) {
+					e.add(OverlayNetworkFS, dir, m.FSType)
+					break
+				}
+			}
+		}
+		if e.UserNS && (denied || errno == syscall.EINVAL) {
+			e.add(OverlayUserNS)
+		}
+	}
+}
+
+// add adds the cause c formatted with its arguments.
+func (e *Error) add(c Cause, a ...interface{}) {
+	if _, ok := e.Causes[c]; ok {
+		return
+	}
+	e.Causes[c] = fmt.Sprintf(translate(string(c)), a...)
+	e.order = append(e.order, c)
+}
+
+// HasOption returns whether options contains option.
+func HasOption(options []string, option string) bool {
+	for _, o := range options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// namespaceContext describes the mount and user namespaces of the
+// current process.
+func namespaceContext(userNS bool) string {
+	mnt, err := os.Readlink("/proc/self/ns/mnt")
+	if err != nil {
+		return ""
+	}
+	if !userNS {
+		return fmt.Sprintf(translate("host-userns"), mnt, os.Getuid())
+	}
+	user, _ := os.Readlink("/proc/self/ns/user")
+	return fmt.Sprintf(translate("userns"), mnt, user, os.Getuid())
+}
+
+// fuseAllowOther returns whether user_allow_other is set in /etc/fuse.conf.
+func fuseAllowOther() bool {
+	f, err := os.Open("/etc/fuse.conf")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "user_allow_other" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package mount
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/hpcng/singularity/pkg/util/fs/proc"
+)
+
+func TestDiagnose(t *testing.T) {
+	os.Setenv("LC_ALL", "C")
+	defer os.Unsetenv("LC_ALL")
+
+	home := &proc.MountInfoEntry{Point: "/home", FSType: "nfs4", Options: []string{"rw", "nosuid", "nodev"}}
+	fuse := &proc.MountInfoEntry{Point: "/mnt/s3", FSType: "fuse.s3fs", Options: []string{"rw", "nosuid", "nodev"}}
+	lookup := func(path string) *proc.MountInfoEntry {
+		switch {
+		case strings.HasPrefix(path, "/home"):
+			return home
+		case strings.HasPrefix(path, "/mnt/s3"):
+			return fuse
+		}
+		return &proc.MountInfoEntry{Point: "/", FSType: "ext4", Options: []string{"rw"}}
+	}
+	hasFS := func(fs string) (bool, error) { return fs != "zfs", nil }
+
+	tests := []struct {
+		name        string
+		failure     Failure
+		sourceMount *proc.MountInfoEntry
+		allowOther  bool
+		causes      []Cause
+	}{
+		{
+			name:        "LockedFlags",
+			failure:     Failure{Source: "/home/user/data", Options: []string{"rbind", "nodev"}, UserNS: true, Err: syscall.EPERM},
+			sourceMount: home,
+			causes:      []Cause{LockedFlags},
+		},
+		{
+			name:        "NosuidParent",
+			failure:     Failure{Source: "/home/user/data", Options: []string{"rbind"}, Err: syscall.EACCES},
+			sourceMount: home,
+			causes:      []Cause{NosuidParent},
+		},
+		{
+			name:        "FuseAllowOther",
+			failure:     Failure{Source: "/mnt/s3/bucket", Options: []string{"rbind", "nosuid", "nodev"}, UserNS: true, Err: syscall.EACCES},
+			sourceMount: fuse,
+			causes:      []Cause{FuseAllowOther},
+		},
+		{
+			name: "OverlayNetworkFS",
+			failure: Failure{
+				FSType:  "overlay",
+				Options: []string{"lowerdir=/rootfs", "upperdir=/home/user/overlay/upper", "workdir=/home/user/overlay/work"},
+				UserNS:  true,
+				Err:     syscall.EINVAL,
+			},
+			causes: []Cause{OverlayNetworkFS, OverlayUserNS},
+		},
+		{
+			name:    "UnsupportedFS",
+			failure: Failure{Source: "/dev/loop0", FSType: "zfs", Err: syscall.ENODEV},
+			causes:  []Cause{UnsupportedFS},
+		},
+		{
+			name:    "SupportedFS",
+			failure: Failure{Source: "/dev/loop0", FSType: "ext3", Err: syscall.ENODEV},
+		},
+		{
+			name:    "TypeMismatch",
+			failure: Failure{Source: "/etc/hosts", Err: syscall.ENOTDIR},
+			causes:  []Cause{TypeMismatch},
+		},
+		{
+			name:    "NotErrno",
+			failure: Failure{Source: "/etc/hosts", Err: fmt.Errorf("rpc error")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Error{Failure: tt.failure, SourceMount: tt.sourceMount, Causes: make(map[Cause]string)}
+			e.diagnose(lookup, hasFS, tt.allowOther)
+			if !reflect.DeepEqual(e.order, tt.causes) {
+				t.Errorf("got causes %v instead of %v", e.order, tt.causes)
+			}
+		})
+	}
+}
+
+func TestDiagnoseMessage(t *testing.T) {
+	home := &proc.MountInfoEntry{Point: "/home", FSType: "nfs4", Options: []string{"rw", "nosuid"}}
+	e := &Error{
+		Failure: Failure{
+			Source:      "/home/user/data",
+			Destination: "/data",
+			Target:      "/session/final/data",
+			Options:     []string{"rbind"},
+			Err:         syscall.EPERM,
+		},
+		Message:     "could not mount /home/user/data: operation not permitted",
+		SourceMount: home,
+		Namespace:   "mount namespace mnt:[1], host user namespace, uid 1000",
+		Causes:      make(map[Cause]string),
+	}
+
+	os.Setenv("LC_ALL", "en_US.UTF-8")
+	defer os.Unsetenv("LC_ALL")
+	e.diagnose(func(string) *proc.MountInfoEntry { return nil }, proc.HasFilesystem, false)
+
+	expected := `could not mount /home/user/data: operation not permitted
+  source:        /home/user/data
+  destination:   /data (/session/final/data)
+  filesystem:    bind, source on nfs4 mount /home (rw,nosuid)
+  options:       rbind
+  namespace:     mount namespace mnt:[1], host user namespace, uid 1000
+  likely cause:  the source mount /home is nosuid, mounts on it may be refused by the kernel or a security module`
+	if msg := e.Error(); msg != expected {
+		t.Errorf("got message:\n%s\ninstead of:\n%s", msg, expected)
+	}
+
+	os.Setenv("LC_ALL", "fr_FR.UTF-8")
+	if msg := e.Error(); !strings.Contains(msg, "système de fichiers: bind, source sur le montage nfs4 /home") {
+		t.Errorf("unexpected french message:\n%s", msg)
+	}
+	if !os.IsPermission(e.Unwrap()) {
+		t.Errorf("unexpected wrapped error %v", e.Unwrap())
+	}
+}
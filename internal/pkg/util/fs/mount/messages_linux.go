@@ -0,0 +1,82 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package mount
+
+import (
+	"os"
+	"strings"
+)
+
+// messages holds the translations of the mount diagnostics by language,
+// English messages are used for the missing languages and messages.
+var messages = map[string]map[string]string{
+	"en": {
+		"source":       "source",
+		"destination":  "destination",
+		"filesystem":   "filesystem",
+		"options":      "options",
+		"namespace":    "namespace",
+		"likely cause": "likely cause",
+		"source-mount": "source on %s mount %s (%s)",
+		"host-userns":  "mount namespace %s, host user namespace, uid %d",
+		"userns":       "mount namespace %s, user namespace %s, uid %d",
+
+		string(LockedFlags):      "the source mount %s has the %s flags, they are locked in a user namespace and must be kept by the bind mount",
+		string(NosuidParent):     "the source mount %s is nosuid, mounts on it may be refused by the kernel or a security module",
+		string(FuseAllowOther):   "the source is on the FUSE mount %s which is only accessible to the user who mounted it, %s",
+		string(OverlayNetworkFS): "the overlay directory %s is on a %s filesystem which doesn't support overlay upper and work directories",
+		string(OverlayUserNS):    "overlay mounts in a user namespace require Linux 5.11 or later, or a kernel allowing unprivileged overlay",
+		string(UnsupportedFS):    "the kernel doesn't support the %s filesystem, it is not listed in /proc/filesystems",
+		string(TypeMismatch):     "the source and the destination must be both directories or both files",
+		string(ReadOnlyTarget):   "the destination is on a read-only filesystem, use --writable-tmpfs or an overlay to create it",
+		"fuse-conf":              "this requires user_allow_other in /etc/fuse.conf and mounting it with -o allow_other",
+		"fuse-remount":           "mount it again with -o allow_other",
+	},
+	"fr": {
+		"source":       "source",
+		"destination":  "destination",
+		"filesystem":   "système de fichiers",
+		"options":      "options",
+		"namespace":    "espace de noms",
+		"likely cause": "cause probable",
+		"source-mount": "source sur le montage %s %s (%s)",
+		"host-userns":  "espace de noms de montage %s, espace de noms utilisateur de l'hôte, uid %d",
+		"userns":       "espace de noms de montage %s, espace de noms utilisateur %s, uid %d",
+
+		string(LockedFlags):      "le montage source %s a les options %s, elles sont verrouillées dans un espace de noms utilisateur et doivent être conservées par le montage",
+		string(NosuidParent):     "le montage source %s est nosuid, les montages sur celui-ci peuvent être refusés par le noyau ou un module de sécurité",
+		string(FuseAllowOther):   "la source est sur le montage FUSE %s accessible uniquement à l'utilisateur qui l'a monté, %s",
+		string(OverlayNetworkFS): "le répertoire d'overlay %s est sur un système de fichiers %s qui ne supporte pas les répertoires upper et work d'overlay",
+		string(OverlayUserNS):    "les montages overlay dans un espace de noms utilisateur nécessitent Linux 5.11 ou supérieur, ou un noyau autorisant les overlay non privilégiés",
+		string(UnsupportedFS):    "le noyau ne supporte pas le système de fichiers %s, il n'est pas listé dans /proc/filesystems",
+		string(TypeMismatch):     "la source et la destination doivent être toutes deux des répertoires ou toutes deux des fichiers",
+		string(ReadOnlyTarget):   "la destination est sur un système de fichiers en lecture seule, utilisez --writable-tmpfs ou un overlay pour la créer",
+		"fuse-conf":              "cela nécessite user_allow_other dans /etc/fuse.conf et de le monter avec -o allow_other",
+		"fuse-remount":           "montez-le à nouveau avec -o allow_other",
+	},
+}
+
+// language returns the language of the messages set by the LC_ALL,
+// LC_MESSAGES or LANG environment variables.
+func language() string {
+	for _, env := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		fields := strings.FieldsFunc(os.Getenv(env), func(r rune) bool {
+			return r == '_' || r == '.' || r == '@' || r == '-'
+		})
+		if len(fields) > 0 {
+			return strings.ToLower(fields[0])
+		}
+	}
+	return "en"
+}
+
+// translate returns the message key in the current language.
+func translate(key string) string {
+	if msg, ok := messages[language()][key]; ok {
+		return msg
+	}
+	return messages["en"][key]
+}
@@ -159,7 +159,7 @@ var authorizedFS = map[string]fsContext{
 	"fuse":    {false},
 }
 
-var internalOptions = []string{"loop", "offset", "sizelimit", "key", "skip-on-error"}
+var internalOptions = []string{"loop", "offset", "sizelimit", "key", "skip-on-error", "missing"}
 
 // Point describes a mount point
 type Point struct {
@@ -328,6 +328,18 @@ func SkipOnError(options []string) bool {
 	return false
 }
 
+// MissingPolicy returns the value of the missing internal option, the
+// policy applied when the mount destination doesn't exist, or an empty
+// string if the option isn't set
+func MissingPolicy(options []string) string {
+	for _, opt := range options {
+		if strings.HasPrefix(opt, "missing=") {
+			return strings.TrimPrefix(opt, "missing=")
+		}
+	}
+	return ""
+}
+
 // HasRemountFlag checks if remount flag is set or not.
 func HasRemountFlag(flags uintptr) bool {
 	return flags&syscall.MS_REMOUNT != 0
@@ -277,6 +277,20 @@ func TestBind(t *testing.T) {
 	if !hasBind {
 		t.Errorf("option rbind not applied for /mnt")
 	}
+	points.RemoveAll()
+
+	if err := points.AddBind(UserbindsTag, "/", "/mnt", syscall.MS_BIND, "missing=skip"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	bind = points.GetByDest("/mnt")
+	if policy := MissingPolicy(bind[0].InternalOptions); policy != "skip" {
+		t.Errorf("got missing policy %q instead of skip", policy)
+	}
+	for _, option := range bind[0].Options {
+		if option == "missing=skip" {
+			t.Errorf("internal option missing passed as mount option")
+		}
+	}
 }
 
 func TestRemount(t *testing.T) {
@@ -8,13 +8,10 @@ package overlay
 import (
 	"fmt"
 
+	fsutil "github.com/hpcng/singularity/internal/pkg/util/fs"
 	"golang.org/x/sys/unix"
 )
 
-// statfs is the function pointing to unix.Statfs and
-// also used by unit tests for mocking.
-var statfs = unix.Statfs
-
 type dir uint8
 
 const (
@@ -67,7 +64,7 @@ var incompatibleFs = map[int64]fs{
 func check(path string, d dir) error {
 	stfs := &unix.Statfs_t{}
 
-	if err := statfs(path, stfs); err != nil {
+	if err := fsutil.Statfs(path, stfs); err != nil {
 		return fmt.Errorf("could not retrieve underlying filesystem information for %s: %s", path, err)
 	}
 
@@ -8,6 +8,7 @@ package overlay
 import (
 	"testing"
 
+	fsutil "github.com/hpcng/singularity/internal/pkg/util/fs"
 	"golang.org/x/sys/unix"
 )
 
@@ -154,12 +155,12 @@ func TestCheckLowerUpper(t *testing.T) {
 
 		// mock statfs
 		if tt.fsType > 0 {
-			statfs = func(path string, st *unix.Statfs_t) error {
+			fsutil.Statfs = func(path string, st *unix.Statfs_t) error {
 				st.Type = tt.fsType
 				return nil
 			}
 		} else {
-			statfs = unix.Statfs
+			fsutil.Statfs = unix.Statfs
 		}
 
 		switch tt.dir {
@@ -0,0 +1,109 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package pfs detects the parallel filesystems and applies their
+// layout hints.
+package pfs
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Lustre is the name of the Lustre filesystem.
+	Lustre = "Lustre"
+	// GPFS is the name of the GPFS/Spectrum Scale filesystem.
+	GPFS = "GPFS"
+)
+
+var magics = map[int64]string{
+	0x0BD00BD0: Lustre,
+	0x47504653: GPFS,
+}
+
+var stripeSizeRegexp = regexp.MustCompile(`^[0-9]+[kKmMgG]?$`)
+
+// Detect returns the name of the parallel filesystem holding path,
+// or an empty string if path is not on a parallel filesystem.
+func Detect(path string) (string, error) {
+	stfs := &unix.Statfs_t{}
+	if err := fs.Statfs(path, stfs); err != nil {
+		return "", fmt.Errorf("could not retrieve underlying filesystem information for %s: %s", path, err)
+	}
+	return magics[int64(stfs.Type)], nil
+}
+
+// Stripe holds the striping hints of the files created in a directory.
+type Stripe struct {
+	// Count is the number of storage targets a file is striped
+	// over, -1 for all targets and 0 for the filesystem default.
+	Count int
+	// Size is the size of the stripes with an optional k, m or g
+	// unit suffix, an empty size is the filesystem default.
+	Size string
+}
+
+// ParseStripeCount parses a stripe count.
+func ParseStripeCount(s string) (int, error) {
+	count, err := strconv.Atoi(s)
+	if err != nil || count < -1 {
+		return 0, fmt.Errorf("invalid stripe count %q, it must be a number of storage targets or -1 for all", s)
+	}
+	return count, nil
+}
+
+// CheckStripeSize returns an error if s is not a valid stripe size.
+func CheckStripeSize(s string) error {
+	if !stripeSizeRegexp.MatchString(s) {
+		return fmt.Errorf("invalid stripe size %q, it must be a number of bytes with an optional k, m or g suffix", s)
+	}
+	return nil
+}
+
+// Apply sets the striping hints of the directory dir, inherited by the
+// files created in it. Striping hints are only supported on Lustre, GPFS
+// stripes files over all its disks with the block size of the filesystem.
+func (s Stripe) Apply(dir string) error {
+	name, err := Detect(dir)
+	if err != nil {
+		return err
+	}
+	switch name {
+	case Lustre:
+	case GPFS:
+		return fmt.Errorf("%s is located on a GPFS filesystem which doesn't support striping hints, its block size is set at filesystem creation", dir)
+	default:
+		return fmt.Errorf("%s is not located on a Lustre filesystem, striping hints are not supported", dir)
+	}
+
+	lfs, err := exec.LookPath("lfs")
+	if err != nil {
+		return fmt.Errorf("lfs not found in $PATH")
+	}
+
+	args := []string{"setstripe", "-c", strconv.Itoa(s.Count)}
+	if s.Size != "" {
+		if err := CheckStripeSize(s.Size); err != nil {
+			return err
+		}
+		args = append(args, "-S", s.Size)
+	}
+	args = append(args, dir)
+
+	errBuf := new(bytes.Buffer)
+	cmd := exec.Command(lfs, args...)
+	cmd.Stderr = errBuf
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("while setting %s striping: %s\nCommand error: %s", dir, err, errBuf)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package pfs
+
+import (
+	"testing"
+
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	"golang.org/x/sys/unix"
+)
+
+func TestDetect(t *testing.T) {
+	defer func() { fs.Statfs = unix.Statfs }()
+
+	if name, err := Detect("/"); err != nil || name != "" {
+		t.Errorf("unexpected root filesystem detection %q: %v", name, err)
+	}
+	if _, err := Detect("/non/existent/path"); err == nil {
+		t.Errorf("unexpected success with a non existent path")
+	}
+
+	for magic, expected := range magics {
+		fs.Statfs = func(path string, st *unix.Statfs_t) error {
+			st.Type = magic
+			return nil
+		}
+		if name, err := Detect("/"); err != nil || name != expected {
+			t.Errorf("got %q instead of %s: %v", name, expected, err)
+		}
+	}
+
+	// striping is only supported on Lustre
+	for _, magic := range []int64{0, 0x47504653} {
+		fs.Statfs = func(path string, st *unix.Statfs_t) error {
+			st.Type = magic
+			return nil
+		}
+		if err := (Stripe{Count: 4}).Apply("/"); err == nil {
+			t.Errorf("unexpected striping success with filesystem type %x", magic)
+		}
+	}
+}
+
+func TestStripeParsing(t *testing.T) {
+	for s, valid := range map[string]bool{"4": true, "-1": true, "0": true, "-2": false, "a": false} {
+		if _, err := ParseStripeCount(s); (err == nil) != valid {
+			t.Errorf("unexpected stripe count %q parsing result: %v", s, err)
+		}
+	}
+	for s, valid := range map[string]bool{"1048576": true, "4m": true, "1G": true, "4mb": false, "": false} {
+		if err := CheckStripeSize(s); (err == nil) != valid {
+			t.Errorf("unexpected stripe size %q check result: %v", s, err)
+		}
+	}
+}
@@ -31,6 +31,20 @@ func getConfig() (*singularityconf.File, error) {
 	return cfg, nil
 }
 
+// lookPath returns the path of the binary name located in dir which is
+// either empty, a directory or the full path to the binary.
+func lookPath(dir, name string) (string, error) {
+	p := dir
+
+	// If the path contains the binary name use it as is, otherwise add the binary name via filepath.Join
+	if !strings.HasSuffix(dir, name) {
+		p = filepath.Join(dir, name)
+	}
+
+	// exec.LookPath functions on absolute paths (ignoring $PATH) as well
+	return exec.LookPath(p)
+}
+
 // GetPath figures out where the mksquashfs binary is
 // and return an error is not available or not usable.
 func GetPath() (string, error) {
@@ -40,16 +54,31 @@ func GetPath() (string, error) {
 		return "", err
 	}
 
-	// p is either "" or the string value in the conf file
-	p := c.MksquashfsPath
+	return lookPath(c.MksquashfsPath, "mksquashfs")
+}
 
-	// If the path contains the binary name use it as is, otherwise add mksquashfs via filepath.Join
-	if !strings.HasSuffix(c.MksquashfsPath, "mksquashfs") {
-		p = filepath.Join(c.MksquashfsPath, "mksquashfs")
+// GetTar2sqfsPath figures out where the tar2sqfs binary is
+// and return an error is not available or not usable.
+func GetTar2sqfsPath() (string, error) {
+	c, err := getConfig()
+	if err != nil {
+		return "", err
 	}
 
-	// exec.LookPath functions on absolute paths (ignoring $PATH) as well
-	return exec.LookPath(p)
+	return lookPath(c.Tar2sqfsPath, "tar2sqfs")
+}
+
+// GetPacker returns the name of the tool used to create
+// squashfs images, either mksquashfs or tar2sqfs.
+func GetPacker() (string, error) {
+	c, err := getConfig()
+	if err != nil {
+		return "", err
+	}
+	if c.SquashfsPacker == "" {
+		return "mksquashfs", nil
+	}
+	return c.SquashfsPacker, nil
 }
 
 func GetProcs() (uint, error) {
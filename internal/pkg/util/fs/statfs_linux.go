@@ -0,0 +1,14 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package fs
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// Statfs is the function pointing to unix.Statfs used by the filesystem
+// packages, it's replaced by unit tests for mocking.
+var Statfs = unix.Statfs
@@ -0,0 +1,302 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package tmpdir selects the temporary directory of builds and image
+// conversions among the job scratch, node-local and tmpfs directories
+// available, based on the estimated space required, and tracks the
+// temporary directories created to report their usage and remove them.
+package tmpdir
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"golang.org/x/sys/unix"
+)
+
+// Prefix is the name prefix of the temporary directories created
+// by a Manager.
+const Prefix = "singularity-tmp-"
+
+// minFree is the free space required in a directory when the
+// required space is unknown.
+const minFree = 1 << 30
+
+// sampleInterval is the interval between two samplings of
+// the temporary directories usage.
+var sampleInterval = 10 * time.Second
+
+// tmpfsMagic is the tmpfs filesystem type.
+const tmpfsMagic = 0x01021994
+
+// scratchEnvs are the environment variables set by batch systems to
+// the job scratch directory, TMPDIR often pointing to it as well.
+var scratchEnvs = []string{
+	"SLURM_TMPDIR",
+	"PBS_JOBFS",
+	"_CONDOR_SCRATCH_DIR",
+	"TMPDIR",
+}
+
+// Config holds the temporary directory selection parameters.
+type Config struct {
+	// Dir is the directory explicitly set by the user, it
+	// disables the selection among candidates if not empty.
+	Dir string
+	// Candidates are the directories configured by the
+	// administrator, in order of preference.
+	Candidates []string
+	// Quota is the maximum size in bytes of a run temporary
+	// directory, 0 means unlimited.
+	Quota int64
+}
+
+// Manager selects and tracks the temporary directories of a run.
+type Manager struct {
+	config Config
+	dirs   []string
+
+	mutex sync.Mutex
+	peak  int64
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// New returns a temporary directory manager.
+func New(config Config) *Manager {
+	return &Manager{config: config}
+}
+
+// ScratchDir returns the job scratch directory set by the batch system,
+// or the system temporary directory if none is set.
+func ScratchDir() string {
+	for _, env := range scratchEnvs {
+		if dir := os.Getenv(env); dir != "" {
+			return dir
+		}
+	}
+	return os.TempDir()
+}
+
+// candidate is a directory where temporary directories can be created.
+type candidate struct {
+	path  string
+	free  int64
+	tmpfs bool
+}
+
+// candidates returns the writable directories where temporary
+// directories can be created, in order of preference.
+func (m *Manager) candidates() []candidate {
+	var paths []string
+
+	if m.config.Dir != "" {
+		paths = []string{m.config.Dir}
+	} else {
+		for _, env := range scratchEnvs {
+			if dir := os.Getenv(env); dir != "" {
+				paths = append(paths, dir)
+			}
+		}
+		paths = append(paths, m.config.Candidates...)
+		paths = append(paths, os.TempDir())
+	}
+
+	var candidates []candidate
+
+	seen := make(map[string]bool)
+	for _, p := range paths {
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+
+		var st unix.Statfs_t
+		if err := fs.Statfs(p, &st); err != nil {
+			sylog.Debugf("Ignoring temporary directory %s: %s", p, err)
+			continue
+		}
+		if err := unix.Access(p, unix.W_OK|unix.X_OK); err != nil {
+			sylog.Debugf("Ignoring temporary directory %s: %s", p, err)
+			continue
+		}
+		candidates = append(candidates, candidate{
+			path:  p,
+			free:  int64(st.Bavail) * int64(st.Bsize),
+			tmpfs: int64(st.Type) == tmpfsMagic,
+		})
+	}
+	return candidates
+}
+
+// fits returns whether size bytes fit in the candidate directory,
+// a zero size meaning an unknown size. As tmpfs consumes memory, it's
+// only selected for known sizes not exceeding half of its free space.
+func (c candidate) fits(size int64) bool {
+	if c.tmpfs {
+		return size > 0 && size <= c.free/2
+	}
+	if size == 0 {
+		return c.free >= minFree
+	}
+	return c.free >= size
+}
+
+// Select returns the directory where a temporary directory of size
+// bytes should be created, size is 0 if the required space is unknown.
+// The first candidate with enough free space is selected, or the one
+// with the most free space if none has enough.
+func (m *Manager) Select(size int64) (string, error) {
+	if q := m.config.Quota; q > 0 && size > q {
+		return "", fmt.Errorf("estimated temporary space %s exceeds the limit of %s per run", fs.FindSize(size), fs.FindSize(q))
+	}
+
+	candidates := m.candidates()
+	if len(candidates) == 0 {
+		if m.config.Dir != "" {
+			return "", fmt.Errorf("temporary directory %s is not writable", m.config.Dir)
+		}
+		return "", fmt.Errorf("no writable temporary directory found")
+	}
+
+	best := 0
+	for i, c := range candidates {
+		if c.fits(size) {
+			sylog.Debugf("Selected temporary directory %s with %s free", c.path, fs.FindSize(c.free))
+			return c.path, nil
+		}
+		if !c.tmpfs && (candidates[best].tmpfs || c.free > candidates[best].free) {
+			best = i
+		}
+	}
+
+	c := candidates[best]
+	if size > 0 {
+		sylog.Warningf("Temporary directory %s has only %s free while %s are estimated to be required, set SINGULARITY_TMPDIR to use another location", c.path, fs.FindSize(c.free), fs.FindSize(size))
+	} else {
+		sylog.Warningf("Temporary directory %s has only %s free, set SINGULARITY_TMPDIR to use another location", c.path, fs.FindSize(c.free))
+	}
+	return c.path, nil
+}
+
+// TempDir creates a temporary directory for a run requiring size bytes,
+// 0 if unknown, in the selected directory. The directory is removed by
+// Cleanup.
+func (m *Manager) TempDir(size int64) (string, error) {
+	parent, err := m.Select(size)
+	if err != nil {
+		return "", err
+	}
+	dir, err := ioutil.TempDir(parent, Prefix)
+	if err != nil {
+		return "", fmt.Errorf("while creating temporary directory in %s: %s", parent, err)
+	}
+	m.mutex.Lock()
+	m.dirs = append(m.dirs, dir)
+	m.mutex.Unlock()
+
+	if m.stop == nil {
+		m.stop = make(chan struct{})
+		m.done = make(chan struct{})
+		go m.sample()
+	}
+	return dir, nil
+}
+
+// sample records the peak usage of the temporary directories until
+// Cleanup is called, a warning is reported once the peak usage exceeds
+// the quota.
+func (m *Manager) sample() {
+	defer close(m.done)
+
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+
+	warned := false
+	for {
+		usage := m.Usage()
+
+		m.mutex.Lock()
+		if usage > m.peak {
+			m.peak = usage
+		}
+		m.mutex.Unlock()
+
+		if q := m.config.Quota; q > 0 && usage > q && !warned {
+			sylog.Warningf("Temporary directories use %s, exceeding the limit of %s per run", fs.FindSize(usage), fs.FindSize(q))
+			warned = true
+		}
+
+		select {
+		case <-ticker.C:
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// Usage returns the space in bytes currently used by the temporary
+// directories created by TempDir.
+func (m *Manager) Usage() int64 {
+	var usage int64
+
+	m.mutex.Lock()
+	dirs := m.dirs
+	m.mutex.Unlock()
+
+	for _, dir := range dirs {
+		filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if err == nil && fi.Mode().IsRegular() {
+				usage += fi.Size()
+			}
+			return nil
+		})
+	}
+	return usage
+}
+
+// Peak returns the peak space in bytes sampled while the temporary
+// directories created by TempDir were in use.
+func (m *Manager) Peak() int64 {
+	usage := m.Usage()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if usage > m.peak {
+		m.peak = usage
+	}
+	return m.peak
+}
+
+// Cleanup reports the peak usage of the temporary directories created
+// by TempDir and removes them.
+func (m *Manager) Cleanup() {
+	if m.stop == nil {
+		return
+	}
+	close(m.stop)
+	<-m.done
+	m.stop = nil
+
+	peak := m.Peak()
+	sylog.Verbosef("Temporary directories used up to %s", fs.FindSize(peak))
+	if q := m.config.Quota; q > 0 && peak > q {
+		sylog.Warningf("Temporary directories used up to %s, exceeding the limit of %s per run", fs.FindSize(peak), fs.FindSize(q))
+	}
+
+	for _, dir := range m.dirs {
+		if err := os.RemoveAll(dir); err != nil {
+			sylog.Errorf("Could not remove temporary directory %s: %s", dir, err)
+		}
+	}
+	m.dirs = nil
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package tmpdir
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	"golang.org/x/sys/unix"
+)
+
+func TestSelect(t *testing.T) {
+	root, err := ioutil.TempDir("", "tmpdir-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	local := filepath.Join(root, "local")
+	memory := filepath.Join(root, "memory")
+	for _, d := range []string{local, memory} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatalf("failed to create %s: %s", d, err)
+		}
+	}
+
+	const gib = 1 << 30
+
+	// mock statfs: 10GiB of tmpfs and 50GiB of local disk,
+	// the system temporary directory has 1MiB free
+	fs.Statfs = func(path string, st *unix.Statfs_t) error {
+		st.Bsize = 1
+		switch path {
+		case memory:
+			st.Type = tmpfsMagic
+			st.Bavail = 10 * gib
+		case local:
+			st.Bavail = 50 * gib
+		default:
+			st.Bavail = 1 << 20
+		}
+		return nil
+	}
+	defer func() { fs.Statfs = unix.Statfs }()
+
+	for _, env := range scratchEnvs {
+		if v, ok := os.LookupEnv(env); ok {
+			os.Unsetenv(env)
+			defer os.Setenv(env, v)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		config      Config
+		size        int64
+		expectedDir string
+		expectError bool
+	}{
+		{
+			name:        "SmallOnTmpfs",
+			config:      Config{Candidates: []string{memory, local}},
+			size:        gib,
+			expectedDir: memory,
+		},
+		{
+			name:        "LargeOnDisk",
+			config:      Config{Candidates: []string{memory, local}},
+			size:        20 * gib,
+			expectedDir: local,
+		},
+		{
+			name:        "UnknownOnDisk",
+			config:      Config{Candidates: []string{memory, local}},
+			expectedDir: local,
+		},
+		{
+			name:        "TooLargeMostFree",
+			config:      Config{Candidates: []string{memory, local}},
+			size:        100 * gib,
+			expectedDir: local,
+		},
+		{
+			name:        "Explicit",
+			config:      Config{Dir: memory, Candidates: []string{local}},
+			size:        20 * gib,
+			expectedDir: memory,
+		},
+		{
+			name:        "NotWritable",
+			config:      Config{Dir: filepath.Join(root, "missing")},
+			expectError: true,
+		},
+		{
+			name:        "Quota",
+			config:      Config{Candidates: []string{local}, Quota: gib},
+			size:        2 * gib,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := New(tt.config).Select(tt.size)
+			if err != nil && !tt.expectError {
+				t.Fatalf("unexpected error: %s", err)
+			} else if err == nil && tt.expectError {
+				t.Fatalf("unexpected success")
+			}
+			if dir != tt.expectedDir {
+				t.Errorf("selected %q instead of %q", dir, tt.expectedDir)
+			}
+		})
+	}
+}
+
+func TestTempDir(t *testing.T) {
+	root, err := ioutil.TempDir("", "tmpdir-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	m := New(Config{Dir: root})
+	dir, err := m.TempDir(0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if filepath.Dir(dir) != root {
+		t.Errorf("temporary directory %s not created in %s", dir, root)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), make([]byte, 4096), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if usage := m.Usage(); usage != 4096 {
+		t.Errorf("unexpected usage %d", usage)
+	}
+
+	m.Cleanup()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("temporary directory %s not removed", dir)
+	}
+	if peak := m.Peak(); peak != 4096 {
+		t.Errorf("unexpected peak usage %d", peak)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package hostlibs reads the sets of host libraries, like MPI or
+// interconnect libraries, declared by the administrator to be injected
+// into containers.
+//
+// The configuration file holds one section per set:
+//
+//	[ucx]
+//	enable = yes
+//	dir = /opt/ucx/lib
+//	libucp.so
+//	ucx_info
+//
+// Libraries (containing .so) and binaries are listed like in nvliblist.conf,
+// dir entries are library directories bound at the same location in the
+// container and prepended to its library search path. Enabled sets are
+// injected in all containers, other sets on user request.
+package hostlibs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/util/gpu"
+)
+
+// Set is a set of host libraries.
+type Set struct {
+	Name    string
+	Enabled bool
+	// Files are the libraries and binaries of the set.
+	Files []string
+	// Dirs are the library directories of the set.
+	Dirs []string
+}
+
+// Parse parses the host library sets configuration read from r.
+func Parse(r io.Reader) ([]Set, error) {
+	var sets []Set
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || len(line) == 2 {
+				return nil, fmt.Errorf("line %d: malformed set name %s", n, line)
+			}
+			name := line[1 : len(line)-1]
+			for _, s := range sets {
+				if s.Name == name {
+					return nil, fmt.Errorf("line %d: set %s declared twice", n, name)
+				}
+			}
+			sets = append(sets, Set{Name: name})
+			continue
+		}
+		if len(sets) == 0 {
+			return nil, fmt.Errorf("line %d: %s is not part of a set", n, line)
+		}
+		set := &sets[len(sets)-1]
+
+		if kv := strings.SplitN(line, "=", 2); len(kv) == 2 {
+			key := strings.TrimSpace(kv[0])
+			value := strings.TrimSpace(kv[1])
+
+			switch key {
+			case "enable":
+				switch value {
+				case "yes":
+					set.Enabled = true
+				case "no":
+					set.Enabled = false
+				default:
+					return nil, fmt.Errorf("line %d: enable must be yes or no", n)
+				}
+			case "dir":
+				if !strings.HasPrefix(value, "/") {
+					return nil, fmt.Errorf("line %d: library directory %s is not an absolute path", n, value)
+				}
+				set.Dirs = append(set.Dirs, value)
+			default:
+				return nil, fmt.Errorf("line %d: unknown key %s", n, key)
+			}
+			continue
+		}
+		set.Files = append(set.Files, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sets, nil
+}
+
+// Load reads the host library sets configuration file.
+func Load(path string) ([]Set, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sets, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing %s: %s", path, err)
+	}
+	return sets, nil
+}
+
+// Select returns the enabled sets and the sets requested by name,
+// an error is returned for unknown names.
+func Select(sets []Set, requested []string) ([]Set, error) {
+	want := make(map[string]bool)
+	for _, name := range requested {
+		want[name] = false
+	}
+
+	var selected []Set
+	for _, s := range sets {
+		_, ok := want[s.Name]
+		if s.Enabled || ok {
+			selected = append(selected, s)
+		}
+		want[s.Name] = true
+	}
+	for _, name := range requested {
+		if !want[name] {
+			return nil, fmt.Errorf("host library set %s is not configured", name)
+		}
+	}
+	return selected, nil
+}
+
+// Paths returns the resolved library, binary and library directory
+// paths of the sets found on the host, binaries are searched in
+// userEnvPath if not empty.
+func Paths(sets []Set, userEnvPath string) (libs []string, bins []string, dirs []string, err error) {
+	var files []string
+
+	if userEnvPath != "" {
+		oldPath := os.Getenv("PATH")
+		os.Setenv("PATH", userEnvPath)
+		defer os.Setenv("PATH", oldPath)
+	}
+
+	for _, s := range sets {
+		files = append(files, s.Files...)
+		for _, d := range s.Dirs {
+			if fi, err := os.Stat(d); err != nil || !fi.IsDir() {
+				sylog.Verbosef("Ignoring library directory %s of host library set %s: not found", d, s.Name)
+				continue
+			}
+			dirs = append(dirs, d)
+		}
+	}
+	if len(files) > 0 {
+		libs, bins, err = gpu.ResolvePaths(files)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return libs, bins, dirs, nil
+}
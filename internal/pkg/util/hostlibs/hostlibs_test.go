@@ -0,0 +1,100 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package hostlibs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+# interconnect libraries
+[libfabric]
+enable = yes
+dir = /opt/libfabric/lib
+libfabric.so
+
+[ucx]
+enable = no
+libucp.so
+ucx_info
+`
+
+func TestParse(t *testing.T) {
+	sets, err := Parse(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []Set{
+		{Name: "libfabric", Enabled: true, Files: []string{"libfabric.so"}, Dirs: []string{"/opt/libfabric/lib"}},
+		{Name: "ucx", Files: []string{"libucp.so", "ucx_info"}},
+	}
+	if !reflect.DeepEqual(sets, expected) {
+		t.Errorf("unexpected sets %+v", sets)
+	}
+
+	for _, config := range []string{
+		"libfabric.so",
+		"[]",
+		"[ucx\n",
+		"[ucx]\nenable = maybe",
+		"[ucx]\ndir = lib",
+		"[ucx]\nprefix = /opt",
+		"[ucx]\n[ucx]",
+	} {
+		if _, err := Parse(strings.NewReader(config)); err == nil {
+			t.Errorf("unexpected success for %q", config)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	sets, err := Parse(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		name        string
+		requested   []string
+		expected    []string
+		expectError bool
+	}{
+		{
+			name:     "Enabled",
+			expected: []string{"libfabric"},
+		},
+		{
+			name:      "Requested",
+			requested: []string{"ucx"},
+			expected:  []string{"libfabric", "ucx"},
+		},
+		{
+			name:        "Unknown",
+			requested:   []string{"mpich"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := Select(sets, tt.requested)
+			if err != nil && !tt.expectError {
+				t.Fatalf("unexpected error: %s", err)
+			} else if err == nil && tt.expectError {
+				t.Fatalf("unexpected success")
+			}
+			var names []string
+			for _, s := range selected {
+				names = append(names, s.Name)
+			}
+			if !reflect.DeepEqual(names, tt.expected) {
+				t.Errorf("selected %v instead of %v", names, tt.expected)
+			}
+		})
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -314,3 +315,128 @@ func CompatibleWith(arch string) bool {
 
 	return canEmulate(arch)
 }
+
+// qemuArch maps Go architecture names to the names used by
+// qemu-user-static binaries.
+var qemuArch = map[string]string{
+	"386":      "i386",
+	"amd64":    "x86_64",
+	"arm":      "arm",
+	"armbe":    "armeb",
+	"arm64":    "aarch64",
+	"arm64be":  "aarch64_be",
+	"s390x":    "s390x",
+	"ppc64":    "ppc64",
+	"ppc64le":  "ppc64le",
+	"mips":     "mips",
+	"mipsle":   "mipsel",
+	"mips64":   "mips64",
+	"mips64le": "mips64el",
+}
+
+// QemuPath returns the path of the qemu-user-static interpreter
+// able to emulate the architecture passed in argument.
+func QemuPath(arch string) (string, error) {
+	name, ok := qemuArch[arch]
+	if !ok {
+		return "", ErrUnknownArch
+	}
+	for _, bin := range []string{"qemu-" + name + "-static", "qemu-" + name} {
+		if path, err := exec.LookPath(bin); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no qemu-user-static interpreter found for %s in PATH", arch)
+}
+
+// elfMask returns the binfmt_misc mask associated to the format magic,
+// it ignores the ELF ABI version/padding bytes and allows both
+// executables and shared objects (ET_EXEC and ET_DYN).
+func (f format) elfMask() []byte {
+	mask := make([]byte, len(f.ElfMagic))
+	for i := range mask {
+		mask[i] = 0xff
+	}
+	for i := 7; i < 16; i++ {
+		mask[i] = 0x00
+	}
+	if f.Endianness == binary.LittleEndian {
+		mask[16] = 0xfe
+	} else {
+		mask[17] = 0xfe
+	}
+	return mask
+}
+
+func escapeBinfmt(b []byte) string {
+	var s strings.Builder
+	for _, c := range b {
+		fmt.Fprintf(&s, "\\x%02x", c)
+	}
+	return s.String()
+}
+
+// RegisterEmulator registers the interpreter as a binfmt_misc handler
+// for the architecture passed in argument. The handler is registered with
+// the fix-binary flag so the interpreter is usable from within the build
+// root filesystem without being copied into it. This requires privileges.
+func RegisterEmulator(arch, interpreter string) error {
+	var format format
+
+	for _, f := range formats {
+		if arch == f.Arch {
+			format = f
+			break
+		}
+	}
+	if format.Arch == "" {
+		return ErrUnknownArch
+	}
+
+	content, _ := ioutil.ReadFile(filepath.Join(binfmtMisc, "status"))
+	if string(content) != "enabled\n" {
+		return fmt.Errorf("binfmt_misc is not mounted or not enabled in %s", binfmtMisc)
+	}
+
+	rule := fmt.Sprintf(
+		":singularity-%s:M::%s:%s:%s:F",
+		qemuArch[arch],
+		escapeBinfmt(format.ElfMagic),
+		escapeBinfmt(format.elfMask()),
+		interpreter,
+	)
+
+	sylog.Debugf("Registering binfmt_misc handler %s for %s", interpreter, arch)
+
+	if err := ioutil.WriteFile(filepath.Join(binfmtMisc, "register"), []byte(rule), 0); err != nil {
+		return fmt.Errorf("while registering binfmt_misc handler for %s: %s", arch, err)
+	}
+	return nil
+}
+
+// EnsureEmulation checks that binaries of the architecture passed in argument
+// can be executed on the current machine, and if not it attempts to register
+// a qemu-user-static interpreter as binfmt_misc handler.
+func EnsureEmulation(arch string) error {
+	if CompatibleWith(arch) {
+		return nil
+	}
+
+	interpreter, err := QemuPath(arch)
+	if err != nil {
+		return err
+	}
+
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("%s emulation is not registered in binfmt_misc and requires root privileges to be registered", arch)
+	}
+
+	if err := RegisterEmulator(arch, interpreter); err != nil {
+		return err
+	}
+
+	if !canEmulate(arch) {
+		return fmt.Errorf("%s emulation is still unavailable after binfmt_misc registration", arch)
+	}
+	return nil
+}
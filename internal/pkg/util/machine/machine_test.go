@@ -0,0 +1,88 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package machine
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+)
+
+func TestCompatibleWith(t *testing.T) {
+	if !CompatibleWith(runtime.GOARCH) {
+		t.Errorf("host architecture %s reported as incompatible", runtime.GOARCH)
+	}
+	if runtime.GOARCH == "amd64" && !CompatibleWith("386") {
+		t.Errorf("386 reported as incompatible with amd64")
+	}
+	if CompatibleWith("unknown") {
+		t.Errorf("unknown architecture reported as compatible")
+	}
+}
+
+func TestQemuPath(t *testing.T) {
+	if _, err := QemuPath("unknown"); err != ErrUnknownArch {
+		t.Errorf("got error %v instead of %v", err, ErrUnknownArch)
+	}
+	for arch := range qemuArch {
+		found := false
+		for _, f := range formats {
+			if f.Arch == arch {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("qemu architecture %s has no ELF format", arch)
+		}
+	}
+}
+
+func TestElfMask(t *testing.T) {
+	tests := []struct {
+		arch string
+		// typeByte is the index of the byte of the ELF type
+		// allowing both ET_EXEC and ET_DYN
+		typeByte int
+	}{
+		{"amd64", 16},
+		{"s390x", 17},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.arch, func(t *testing.T) {
+			var format format
+			for _, f := range formats {
+				if f.Arch == tt.arch {
+					format = f
+					break
+				}
+			}
+
+			mask := format.elfMask()
+			if len(mask) != len(format.ElfMagic) {
+				t.Fatalf("mask length %d doesn't match magic length %d", len(mask), len(format.ElfMagic))
+			}
+			for i := 7; i < 16; i++ {
+				if mask[i] != 0 {
+					t.Errorf("ABI byte %d is not ignored", i)
+				}
+			}
+			if mask[tt.typeByte] != 0xfe {
+				t.Errorf("type byte %d mask is %#x instead of 0xfe", tt.typeByte, mask[tt.typeByte])
+			}
+			if !bytes.Equal(mask[:7], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}) {
+				t.Errorf("ELF identification bytes are not matched: %x", mask[:7])
+			}
+		})
+	}
+}
+
+func TestEscapeBinfmt(t *testing.T) {
+	if s := escapeBinfmt([]byte{0x7f, 'E', 0x00}); s != `\x7f\x45\x00` {
+		t.Errorf("unexpected escaped string %q", s)
+	}
+}
@@ -9,12 +9,29 @@ import (
 	"os"
 	"runtime"
 	"syscall"
+
+	"github.com/hpcng/singularity/internal/pkg/security/audit"
 )
 
+// caller returns the name of the function calling Escalate or Drop.
+func caller() string {
+	pc, _, _, ok := runtime.Caller(2)
+	if !ok {
+		return "unknown"
+	}
+	if f := runtime.FuncForPC(pc); f != nil {
+		return f.Name()
+	}
+	return "unknown"
+}
+
 // Escalate escalates thread privileges.
 func Escalate() error {
 	runtime.LockOSThread()
 	uid := os.Getuid()
+	if audit.Enabled() {
+		audit.Logf("setresuid(%d, 0, %d): escalate thread privileges from %s", uid, uid, caller())
+	}
 	return syscall.Setresuid(uid, 0, uid)
 }
 
@@ -22,5 +39,8 @@ func Escalate() error {
 func Drop() error {
 	defer runtime.UnlockOSThread()
 	uid := os.Getuid()
+	if audit.Enabled() {
+		audit.Logf("setresuid(%d, %d, 0): drop thread privileges from %s", uid, uid, caller())
+	}
 	return syscall.Setresuid(uid, uid, 0)
 }
@@ -0,0 +1,129 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package ptypool tracks the PTY pairs and the stream pipes of a container
+// process, so their file descriptors are closed on every error path.
+package ptypool
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/kr/pty"
+)
+
+// Pool holds the files of the tracked file descriptors, keeping
+// a reference also prevents the garbage collector from closing them.
+type Pool struct {
+	mutex sync.Mutex
+	files map[int]*os.File
+}
+
+// New returns an empty pool.
+func New() *Pool {
+	return &Pool{files: make(map[int]*os.File)}
+}
+
+// Add tracks the file descriptors of files.
+func (p *Pool) Add(files ...*os.File) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, f := range files {
+		p.files[int(f.Fd())] = f
+	}
+}
+
+// Open allocates a PTY pair, the terminal is resized to size if not nil.
+func (p *Pool) Open(size *pty.Winsize) (master *os.File, slave *os.File, err error) {
+	master, slave, err = pty.Open()
+	if err != nil {
+		return nil, nil, fmt.Errorf("while allocating PTY pair: %s", err)
+	}
+	if size != nil {
+		if err := pty.Setsize(slave, size); err != nil {
+			master.Close()
+			slave.Close()
+			return nil, nil, fmt.Errorf("while setting terminal size: %s", err)
+		}
+	}
+	p.Add(master, slave)
+	return master, slave, nil
+}
+
+// Pipe allocates a pipe.
+func (p *Pool) Pipe() (r *os.File, w *os.File, err error) {
+	r, w, err = os.Pipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("while creating pipe: %s", err)
+	}
+	p.Add(r, w)
+	return r, w, nil
+}
+
+// Inherit tracks the file descriptor fd inherited from another stage
+// and returns its file, nil is returned for -1.
+func (p *Pool) Inherit(fd int, name string) *os.File {
+	if fd == -1 {
+		return nil
+	}
+	if f := p.File(fd); f != nil {
+		return f
+	}
+	f := os.NewFile(uintptr(fd), name)
+	p.Add(f)
+	return f
+}
+
+// File returns the file of the tracked file descriptor fd or nil.
+func (p *Pool) File(fd int) *os.File {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.files[fd]
+}
+
+// Close closes the tracked file descriptors fds, untracked file
+// descriptors are ignored. The first error is returned.
+func (p *Pool) Close(fds ...int) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var err error
+
+	for _, fd := range fds {
+		f, ok := p.files[fd]
+		if !ok {
+			continue
+		}
+		delete(p.files, fd)
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// CloseAll closes all the tracked file descriptors, the first
+// error is returned.
+func (p *Pool) CloseAll() error {
+	p.mutex.Lock()
+	fds := make([]int, 0, len(p.files))
+	for fd := range p.files {
+		fds = append(fds, fd)
+	}
+	p.mutex.Unlock()
+
+	return p.Close(fds...)
+}
+
+// Len returns the number of tracked file descriptors.
+func (p *Pool) Len() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return len(p.files)
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package ptypool
+
+import (
+	"io/ioutil"
+	"syscall"
+	"testing"
+
+	"github.com/kr/pty"
+)
+
+// openFds returns the number of file descriptors opened by the process.
+func openFds(t *testing.T) int {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatalf("while reading /proc/self/fd: %s", err)
+	}
+	return len(fds)
+}
+
+func TestCloseAll(t *testing.T) {
+	before := openFds(t)
+
+	p := New()
+	if _, _, err := p.Pipe(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, _, err := p.Open(&pty.Winsize{Rows: 24, Cols: 80}); err != nil {
+		t.Logf("PTY allocation not available: %s", err)
+	}
+	if p.Len() == 0 {
+		t.Fatalf("no file descriptor tracked")
+	}
+	if n := openFds(t); n != before+p.Len() {
+		t.Errorf("got %d file descriptors opened, expected %d", n, before+p.Len())
+	}
+
+	if err := p.CloseAll(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if p.Len() != 0 {
+		t.Errorf("%d file descriptors still tracked", p.Len())
+	}
+	if n := openFds(t); n != before {
+		t.Errorf("file descriptors leaked: got %d opened, expected %d", n, before)
+	}
+}
+
+func TestClose(t *testing.T) {
+	before := openFds(t)
+
+	p := New()
+	r, w, err := p.Pipe()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	rfd, wfd := int(r.Fd()), int(w.Fd())
+
+	if err := p.Close(rfd); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if p.File(rfd) != nil {
+		t.Errorf("closed file descriptor %d still tracked", rfd)
+	}
+	if p.File(wfd) != w {
+		t.Errorf("file descriptor %d not tracked", wfd)
+	}
+	// untracked file descriptors are ignored
+	if err := p.Close(rfd, -1); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	p.CloseAll()
+	if n := openFds(t); n != before {
+		t.Errorf("file descriptors leaked: got %d opened, expected %d", n, before)
+	}
+}
+
+func TestInherit(t *testing.T) {
+	before := openFds(t)
+
+	var fds [2]int
+	if err := syscall.Pipe2(fds[:], syscall.O_CLOEXEC); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	p := New()
+	if f := p.Inherit(-1, "none"); f != nil {
+		t.Errorf("unexpected file returned for -1")
+	}
+	f := p.Inherit(fds[0], "read")
+	if f == nil || int(f.Fd()) != fds[0] {
+		t.Fatalf("unexpected file returned for %d", fds[0])
+	}
+	if p.Inherit(fds[0], "read") != f {
+		t.Errorf("file descriptor %d tracked twice", fds[0])
+	}
+	p.Inherit(fds[1], "write")
+	if p.Len() != 2 {
+		t.Errorf("got %d file descriptors tracked, expected 2", p.Len())
+	}
+
+	p.CloseAll()
+	if n := openFds(t); n != before {
+		t.Errorf("file descriptors leaked: got %d opened, expected %d", n, before)
+	}
+}
@@ -1,4 +1,4 @@
-// Copyright (c) 2019-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2019-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -6,14 +6,16 @@
 package starter
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"syscall"
 
 	"github.com/hpcng/singularity/internal/pkg/buildcfg"
+	"github.com/hpcng/singularity/internal/pkg/syerror"
 	"github.com/hpcng/singularity/pkg/runtime/engine/config"
 	"github.com/hpcng/singularity/pkg/sylog"
 	"golang.org/x/sys/unix"
@@ -73,6 +75,14 @@ func LoadOverlayModule(load bool) CommandOp {
 	}
 }
 
+// WithContext sets a context killing the starter command and the
+// processes it started when done. Context is ignored for Exec.
+func WithContext(ctx context.Context) CommandOp {
+	return func(c *Command) {
+		c.ctx = ctx
+	}
+}
+
 // Command a starter command to execute.
 type Command struct {
 	path   string
@@ -80,6 +90,7 @@ type Command struct {
 	stdin  io.Reader
 	stdout io.Writer
 	stderr io.Writer
+	ctx    context.Context
 }
 
 // Exec executes the starter binary in place of the caller if
@@ -108,8 +119,32 @@ func Run(name string, config *config.Common, ops ...CommandOp) error {
 	cmd.Stdout = c.stdout
 	cmd.Stderr = c.stderr
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("while running %s: %s", c.path, err)
+	if c.ctx == nil {
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("while running %s: %w", c.path, err)
+		}
+		return nil
+	}
+
+	// starter runs in its own process group, inherited by the
+	// container process, to kill them all once the context is done
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("while running %s: %w", c.path, err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-c.ctx.Done():
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		case <-done:
+		}
+	}()
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("while running %s: %w", c.path, err)
 	}
 	return nil
 }
@@ -127,9 +162,9 @@ func (c *Command) init(config *config.Common, ops ...CommandOp) error {
 		return fmt.Errorf("%s not found, please check your installation", c.path)
 	}
 
-	data, err := json.Marshal(config)
+	data, err := marshalConfig(config, c.path)
 	if err != nil {
-		return fmt.Errorf("while marshaling config: %s", err)
+		return err
 	}
 
 	envConfig, err := copyConfigToEnv(data)
@@ -138,6 +173,15 @@ func (c *Command) init(config *config.Common, ops ...CommandOp) error {
 	}
 
 	c.env = append(c.env, sylog.GetEnvVar())
+	if syerror.Enabled() {
+		c.env = append(c.env, syerror.JSONEnv+"=1")
+	}
+	// locale of the mount diagnostics
+	for _, env := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value, ok := os.LookupEnv(env); ok {
+			c.env = append(c.env, env+"="+value)
+		}
+	}
 	c.env = append(c.env, envConfig...)
 
 	return nil
@@ -0,0 +1,86 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package starter
+
+import (
+	"debug/elf"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hpcng/singularity/internal/pkg/buildcfg"
+	"github.com/hpcng/singularity/pkg/runtime/engine/config"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+// ConfigVersions returns the minimum and maximum engine configuration
+// versions supported by the starter binary located at path. Starter
+// binaries from releases without configuration versioning only
+// support the version 0.
+func ConfigVersions(path string) (uint32, uint32, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("while opening starter binary %s: %s", path, err)
+	}
+	defer f.Close()
+
+	s := f.Section(buildcfg.ENGINE_CONFIG_VERSION_SECTION)
+	if s == nil {
+		return 0, 0, nil
+	}
+
+	data, err := s.Data()
+	if err != nil {
+		return 0, 0, fmt.Errorf("while reading starter configuration versions: %s", err)
+	} else if len(data) < 8 {
+		return 0, 0, fmt.Errorf("bad starter configuration versions section size %d", len(data))
+	}
+
+	return f.ByteOrder.Uint32(data[0:4]), f.ByteOrder.Uint32(data[4:8]), nil
+}
+
+// negotiateVersion returns the engine configuration version to use
+// with a starter supporting versions from min to max.
+func negotiateVersion(min, max uint32) (uint32, error) {
+	version := config.CurrentVersion
+	if max < version {
+		version = max
+	}
+	if version < min || version < config.MinVersion {
+		return 0, fmt.Errorf(
+			"starter supports engine configuration versions %d to %d while singularity supports versions %d to %d, please check your installation",
+			min, max, config.MinVersion, config.CurrentVersion,
+		)
+	}
+	return version, nil
+}
+
+// marshalConfig returns the JSON engine configuration in a format
+// understood by the starter binary located at path, an older or
+// newer starter may be installed.
+func marshalConfig(c *config.Common, path string) ([]byte, error) {
+	c.Version = config.CurrentVersion
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("while marshaling config: %s", err)
+	}
+
+	min, max, err := ConfigVersions(path)
+	if err != nil {
+		return nil, err
+	}
+	version, err := negotiateVersion(min, max)
+	if err != nil {
+		return nil, err
+	}
+	if version == config.CurrentVersion {
+		return data, nil
+	}
+
+	sylog.Debugf("Converting engine configuration to version %d for %s", version, path)
+
+	return config.Convert(data, version)
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package volume
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hpcng/singularity/internal/pkg/util/fs/pfs"
+	singularityConfig "github.com/hpcng/singularity/pkg/runtime/engine/singularity/config"
+)
+
+// Mount is a mount parsed from a --mount specification.
+type Mount struct {
+	Bind singularityConfig.BindPath
+	// Stripe holds the striping hints applied to the mounted
+	// directory if any.
+	Stripe *pfs.Stripe
+}
+
+// ParseMount parses the --mount specification spec, a comma separated
+// list of key=value fields, and returns the corresponding mount. The
+// type field is volume to mount a named volume of the volume directory
+// dir, or bind to mount a host path. The src (or source) field is the
+// volume name or the host path, the dst (or destination, or target)
+// field is the absolute path in the container. The ro (or readonly) field
+// mounts read-only, it takes an optional boolean value. The stripe-count
+// and stripe-size fields set the striping hints of a directory located
+// on a parallel filesystem.
+func ParseMount(dir, spec string) (*Mount, error) {
+	var typ, src, dst string
+	var stripe *pfs.Stripe
+	readonly := false
+
+	for _, field := range strings.Split(spec, ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		key := kv[0]
+		value := ""
+		if len(kv) == 2 {
+			value = kv[1]
+		}
+
+		switch key {
+		case "type":
+			typ = value
+		case "src", "source":
+			src = value
+		case "dst", "destination", "target":
+			dst = value
+		case "ro", "readonly":
+			if len(kv) == 1 {
+				readonly = true
+				break
+			}
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q in mount %q", key, value, spec)
+			}
+			readonly = b
+		case "stripe-count":
+			count, err := pfs.ParseStripeCount(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s in mount %q", err, spec)
+			}
+			if stripe == nil {
+				stripe = &pfs.Stripe{}
+			}
+			stripe.Count = count
+		case "stripe-size":
+			if err := pfs.CheckStripeSize(value); err != nil {
+				return nil, fmt.Errorf("%s in mount %q", err, spec)
+			}
+			if stripe == nil {
+				stripe = &pfs.Stripe{}
+			}
+			stripe.Size = value
+		default:
+			return nil, fmt.Errorf("unknown field %q in mount %q", key, spec)
+		}
+	}
+
+	if src == "" {
+		return nil, fmt.Errorf("no source in mount %q", spec)
+	} else if !filepath.IsAbs(dst) {
+		return nil, fmt.Errorf("destination must be an absolute path in mount %q", spec)
+	}
+
+	switch typ {
+	case "volume":
+		v, err := Get(dir, src)
+		if err != nil {
+			return nil, err
+		} else if stripe != nil && v.Type != DirType {
+			return nil, fmt.Errorf("striping hints are only supported by %s volumes in mount %q", DirType, spec)
+		}
+		return &Mount{Bind: v.Bind(dst, readonly), Stripe: stripe}, nil
+	case "bind":
+		bind := singularityConfig.BindPath{
+			Source:      src,
+			Destination: dst,
+			Options:     make(map[string]*singularityConfig.BindOption),
+		}
+		if readonly {
+			bind.Options["ro"] = &singularityConfig.BindOption{}
+		}
+		return &Mount{Bind: bind, Stripe: stripe}, nil
+	case "":
+		return nil, fmt.Errorf("no type in mount %q", spec)
+	}
+	return nil, fmt.Errorf("unsupported type %s in mount %q, supported types are volume and bind", typ, spec)
+}
@@ -0,0 +1,250 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package volume manages the named volumes of the user volume directory,
+// directories or EXT3 images holding persistent data mounted in
+// containers with --mount type=volume.
+package volume
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/util/fs/pfs"
+	singularityConfig "github.com/hpcng/singularity/pkg/runtime/engine/singularity/config"
+	"github.com/hpcng/singularity/pkg/syfs"
+)
+
+const (
+	// DirType is the type of the volumes stored in a directory.
+	DirType = "dir"
+	// Ext3Type is the type of the volumes stored in an EXT3 image.
+	Ext3Type = "ext3"
+
+	// ext3Suffix is the file name suffix of the EXT3 volume images.
+	ext3Suffix = ".ext3"
+	// minExt3Size is the minimum size in MiB of an EXT3 volume.
+	minExt3Size = 64
+)
+
+var nameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// Volume is a named volume.
+type Volume struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Path string `json:"path"`
+	// Size is the size of the EXT3 image, the size of the
+	// directory volumes is not computed.
+	Size    int64     `json:"size,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// Dir returns the volume directory of the user singularity directory.
+func Dir() string {
+	return filepath.Join(syfs.ConfigDir(), "volumes")
+}
+
+// CheckName returns an error if name is not a valid volume name.
+func CheckName(name string) error {
+	if !nameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid volume name %q, it must start with a letter or a digit followed by letters, digits, '_' or '-'", name)
+	}
+	return nil
+}
+
+// Get returns the volume name of the volume directory dir.
+func Get(dir, name string) (*Volume, error) {
+	if err := CheckName(name); err != nil {
+		return nil, err
+	}
+
+	for _, typ := range []string{DirType, Ext3Type} {
+		path := volumePath(dir, name, typ)
+		fi, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("while getting %s volume information: %s", name, err)
+		}
+		return newVolume(name, typ, path, fi), nil
+	}
+	return nil, fmt.Errorf("no volume named %s, create it with 'singularity volume create %s'", name, name)
+}
+
+// List returns the volumes of the volume directory dir sorted by name.
+func List(dir string) ([]*Volume, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("while reading volume directory: %s", err)
+	}
+
+	var volumes []*Volume
+	for _, fi := range entries {
+		name, typ := fi.Name(), DirType
+		if !fi.IsDir() {
+			if !fi.Mode().IsRegular() || !strings.HasSuffix(name, ext3Suffix) {
+				continue
+			}
+			name, typ = strings.TrimSuffix(name, ext3Suffix), Ext3Type
+		}
+		if CheckName(name) != nil {
+			continue
+		}
+		volumes = append(volumes, newVolume(name, typ, filepath.Join(dir, fi.Name()), fi))
+	}
+
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].Name < volumes[j].Name
+	})
+	return volumes, nil
+}
+
+// Create creates the volume name of type typ in the volume directory
+// dir, size is the size in MiB of EXT3 volumes. The striping hints
+// stripe, if not nil, are applied to directory volumes.
+func Create(dir, name, typ string, size int, stripe *pfs.Stripe) (*Volume, error) {
+	if err := CheckName(name); err != nil {
+		return nil, err
+	}
+	if _, err := Get(dir, name); err == nil {
+		return nil, fmt.Errorf("volume %s already exists", name)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("while creating volume directory: %s", err)
+	}
+
+	if stripe != nil && typ != DirType {
+		return nil, fmt.Errorf("striping hints are only supported by %s volumes", DirType)
+	}
+
+	path := volumePath(dir, name, typ)
+
+	switch typ {
+	case DirType:
+		if err := os.Mkdir(path, 0755); err != nil {
+			return nil, fmt.Errorf("while creating %s volume: %s", name, err)
+		}
+		if stripe != nil {
+			if err := stripe.Apply(path); err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("while creating %s volume: %s", name, err)
+			}
+		}
+	case Ext3Type:
+		if err := createExt3(path, size); err != nil {
+			return nil, fmt.Errorf("while creating %s volume: %s", name, err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown volume type %s, supported types are %s and %s", typ, DirType, Ext3Type)
+	}
+
+	return Get(dir, name)
+}
+
+// Remove removes the volume name of the volume directory dir
+// with its data.
+func Remove(dir, name string) error {
+	v, err := Get(dir, name)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(v.Path); err != nil {
+		return fmt.Errorf("while removing %s volume: %s", name, err)
+	}
+	return nil
+}
+
+// Bind returns the bind path mounting the volume v at destination in
+// the container, read-only if readonly is true.
+func (v *Volume) Bind(destination string, readonly bool) singularityConfig.BindPath {
+	bind := singularityConfig.BindPath{
+		Source:      v.Path,
+		Destination: destination,
+		Options:     make(map[string]*singularityConfig.BindOption),
+	}
+	if v.Type == Ext3Type {
+		bind.Options["image-src"] = &singularityConfig.BindOption{Value: "/"}
+	}
+	if readonly {
+		bind.Options["ro"] = &singularityConfig.BindOption{}
+	}
+	return bind
+}
+
+func newVolume(name, typ, path string, fi os.FileInfo) *Volume {
+	v := &Volume{
+		Name:    name,
+		Type:    typ,
+		Path:    path,
+		Created: fi.ModTime(),
+	}
+	if typ == Ext3Type {
+		v.Size = fi.Size()
+	}
+	return v
+}
+
+func volumePath(dir, name, typ string) string {
+	if typ == Ext3Type {
+		return filepath.Join(dir, name+ext3Suffix)
+	}
+	return filepath.Join(dir, name)
+}
+
+// createExt3 creates an EXT3 image of size MiB at path, its root
+// directory is owned by the current user.
+func createExt3(path string, size int) error {
+	if size < minExt3Size {
+		return fmt.Errorf("image size must be equal or greater than %d MiB", minExt3Size)
+	}
+
+	mkfs, err := exec.LookPath("mkfs.ext3")
+	if err != nil {
+		return fmt.Errorf("mkfs.ext3 not found in $PATH")
+	}
+
+	// the image root directory takes the permissions
+	// and the owner of this directory
+	root, err := ioutil.TempDir("", "volume-")
+	if err != nil {
+		return fmt.Errorf("while creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	if err := os.Chmod(root, 0755); err != nil {
+		return fmt.Errorf("while setting temporary directory permissions: %s", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(int64(size) << 20)
+	f.Close()
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("while setting image size: %s", err)
+	}
+
+	errBuf := new(bytes.Buffer)
+	cmd := exec.Command(mkfs, "-q", "-F", "-d", root, path)
+	cmd.Stderr = errBuf
+	if err := cmd.Run(); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("while creating ext3 filesystem: %s\nCommand error: %s", err, errBuf)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package volume
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcng/singularity/internal/pkg/util/fs/pfs"
+)
+
+func TestVolumes(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "volume-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	dir := filepath.Join(tmp, "volumes")
+
+	if _, err := Create(dir, "../data", DirType, 0, nil); err == nil {
+		t.Errorf("unexpected success with an invalid name")
+	}
+	if _, err := Create(dir, "data", "nfs", 0, nil); err == nil {
+		t.Errorf("unexpected success with an unknown type")
+	}
+
+	v, err := Create(dir, "data", DirType, 0, nil)
+	if err != nil {
+		t.Fatalf("failed to create volume: %s", err)
+	}
+	if v.Type != DirType || v.Path != filepath.Join(dir, "data") {
+		t.Errorf("unexpected volume %+v", v)
+	}
+	if _, err := Create(dir, "data", Ext3Type, minExt3Size, nil); err == nil {
+		t.Errorf("unexpected success with an existing volume")
+	}
+	// the temporary directory is not on Lustre
+	if _, err := Create(dir, "striped", DirType, 0, &pfs.Stripe{Count: 2}); err == nil {
+		t.Errorf("unexpected success with striping hints")
+	} else if _, err := Get(dir, "striped"); err == nil {
+		t.Errorf("unexpected volume left after striping failure")
+	}
+
+	expected := []string{"data"}
+	if _, err := exec.LookPath("mkfs.ext3"); err == nil {
+		v, err := Create(dir, "scratch", Ext3Type, minExt3Size, nil)
+		if err != nil {
+			t.Fatalf("failed to create ext3 volume: %s", err)
+		}
+		if v.Size != minExt3Size<<20 {
+			t.Errorf("unexpected ext3 volume size %d", v.Size)
+		}
+		expected = append(expected, "scratch")
+	}
+
+	list, err := List(dir)
+	if err != nil {
+		t.Fatalf("failed to list volumes: %s", err)
+	}
+	if len(list) != len(expected) {
+		t.Fatalf("got %d volumes instead of %d", len(list), len(expected))
+	}
+	for i, v := range list {
+		if v.Name != expected[i] {
+			t.Errorf("got volume %s instead of %s", v.Name, expected[i])
+		}
+	}
+
+	if err := Remove(dir, "data"); err != nil {
+		t.Errorf("failed to remove volume: %s", err)
+	}
+	if _, err := Get(dir, "data"); err == nil {
+		t.Errorf("unexpected removed volume")
+	}
+}
+
+func TestParseMount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "volume-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := Create(dir, "data", DirType, 0, nil); err != nil {
+		t.Fatalf("failed to create volume: %s", err)
+	}
+
+	tests := []struct {
+		spec     string
+		source   string
+		dest     string
+		readonly bool
+		stripe   bool
+		fail     bool
+	}{
+		{spec: "type=volume,src=data,dst=/data", source: filepath.Join(dir, "data"), dest: "/data"},
+		{spec: "type=volume,source=data,target=/data,ro", source: filepath.Join(dir, "data"), dest: "/data", readonly: true},
+		{spec: "type=bind,src=/opt,dst=/mnt,readonly=false", source: "/opt", dest: "/mnt"},
+		{spec: "type=volume,src=missing,dst=/data", fail: true},
+		{spec: "type=volume,src=data,dst=data", fail: true},
+		{spec: "src=data,dst=/data", fail: true},
+		{spec: "type=tmpfs,dst=/data", fail: true},
+		{spec: "type=volume,src=data,dst=/data,size=1", fail: true},
+		{spec: "type=bind,src=/scratch,dst=/scratch,stripe-count=4,stripe-size=4m", source: "/scratch", dest: "/scratch", stripe: true},
+		{spec: "type=bind,src=/scratch,dst=/scratch,stripe-count=x", fail: true},
+	}
+
+	for _, tt := range tests {
+		m, err := ParseMount(dir, tt.spec)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("unexpected success for %q", tt.spec)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("unexpected error for %q: %s", tt.spec, err)
+			continue
+		}
+		if m.Bind.Source != tt.source || m.Bind.Destination != tt.dest || m.Bind.Readonly() != tt.readonly {
+			t.Errorf("unexpected bind %+v for %q", m.Bind, tt.spec)
+		}
+		if (m.Stripe != nil) != tt.stripe {
+			t.Errorf("unexpected stripe %+v for %q", m.Stripe, tt.spec)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package workflow generates the container requirements of the CWL,
+// Nextflow and Snakemake workflow engines running Singularity images.
+package workflow
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Image describes a container image of a workflow.
+type Image struct {
+	// URI is the image URI, pinned to its digest when the transport
+	// supports it, or the absolute path of a local image.
+	URI string
+	// Digest is the image digest, sha256:<hex>.
+	Digest string
+	// Options are the runtime options recommended by the image, like
+	// its bind paths.
+	Options []Option
+}
+
+// Option is a runtime option of the image, the value of boolean options
+// is true.
+type Option struct {
+	Name  string
+	Value string
+}
+
+// Pin returns the URI of the image ref of the transport scheme pinned to
+// its transport digest, and the digest as sha256:<hex>. Only docker and
+// library references can be pinned, other references are returned as is.
+func Pin(scheme, ref, digest string) (string, string) {
+	hex := strings.TrimPrefix(strings.TrimPrefix(digest, "sha256:"), "sha256.")
+	if digest == "" || len(hex) != 64 {
+		return ref, digest
+	}
+	digest = "sha256:" + hex
+
+	name := strings.TrimPrefix(ref, scheme+"://")
+	if n := strings.Index(name, "@"); n >= 0 {
+		name = name[:n]
+	}
+	if n := strings.LastIndex(name, ":"); n > strings.LastIndex(name, "/") {
+		name = name[:n]
+	}
+
+	switch scheme {
+	case "docker":
+		return "docker://" + name + "@" + digest, digest
+	case "library":
+		return "library://" + name + ":sha256." + hex, digest
+	}
+	return ref, digest
+}
+
+// RuntimeOptions returns the command line options of the runtime
+// options recommended by the image.
+func (i *Image) RuntimeOptions() []string {
+	options := make([]string, 0, len(i.Options))
+	for _, o := range i.Options {
+		if o.Value == "true" {
+			options = append(options, "--"+o.Name)
+		} else {
+			options = append(options, "--"+o.Name+"="+o.Value)
+		}
+	}
+	return options
+}
+
+// Environment returns the SINGULARITY_ environment variables setting the
+// runtime options recommended by the image, for the engines without
+// runtime options setting. The values of repeated options are joined
+// with commas.
+func (i *Image) Environment() []string {
+	var env []string
+	index := make(map[string]int)
+	for _, o := range i.Options {
+		if o.Name == "env" {
+			env = append(env, "SINGULARITYENV_"+o.Value)
+			continue
+		}
+		name := "SINGULARITY_" + strings.ToUpper(strings.ReplaceAll(o.Name, "-", "_"))
+		if n, ok := index[name]; ok {
+			env[n] += "," + o.Value
+			continue
+		}
+		index[name] = len(env)
+		env = append(env, name+"="+o.Value)
+	}
+	return env
+}
+
+// header returns the comment describing the image, with the comment
+// prefix of the descriptor language.
+func (i *Image) header(comment string) string {
+	if i.Digest == "" {
+		return fmt.Sprintf("%s %s\n", comment, i.URI)
+	}
+	return fmt.Sprintf("%s %s (%s)\n", comment, i.URI, i.Digest)
+}
+
+// CWL returns the DockerRequirement of a CWL tool running the image with
+// cwltool --singularity, the runtime options are set in the environment.
+func (i *Image) CWL() string {
+	var b bytes.Buffer
+	b.WriteString(i.header("#"))
+	if env := i.Environment(); len(env) > 0 {
+		quoted := make([]string, len(env))
+		for n, e := range env {
+			quoted[n] = quote(e)
+		}
+		fmt.Fprintf(&b, "# run with: %s cwltool --singularity\n", strings.Join(quoted, " "))
+	}
+	b.WriteString("requirements:\n  DockerRequirement:\n")
+	if ref := strings.TrimPrefix(i.URI, "docker://"); ref != i.URI {
+		fmt.Fprintf(&b, "    dockerPull: %q\n", ref)
+	} else {
+		fmt.Fprintf(&b, "    dockerImageId: %q\n", i.URI)
+	}
+	return b.String()
+}
+
+// Nextflow returns the configuration scope of a Nextflow pipeline running
+// the image with the Singularity engine.
+func (i *Image) Nextflow() string {
+	var b bytes.Buffer
+	b.WriteString(i.header("//"))
+	fmt.Fprintf(&b, "process.container = %s\n", groovyQuote(i.URI))
+	b.WriteString("singularity.enabled = true\n")
+	if options := i.RuntimeOptions(); len(options) > 0 {
+		fmt.Fprintf(&b, "singularity.runOptions = %s\n", groovyQuote(strings.Join(options, " ")))
+	}
+	return b.String()
+}
+
+// Snakemake returns the container directive of a Snakemake rule running
+// the image, the runtime options are given with --singularity-args.
+func (i *Image) Snakemake() string {
+	var b bytes.Buffer
+	b.WriteString(i.header("#"))
+	if options := i.RuntimeOptions(); len(options) > 0 {
+		fmt.Fprintf(&b, "# run with: snakemake --use-singularity --singularity-args %s\n", quote(strings.Join(options, " ")))
+	} else {
+		b.WriteString("# run with: snakemake --use-singularity\n")
+	}
+	fmt.Fprintf(&b, "container: %q\n", i.URI)
+	return b.String()
+}
+
+// quote returns s single quoted for a shell.
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// groovyQuote returns s as a single quoted Groovy string.
+func groovyQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package workflow
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testHex = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestPin(t *testing.T) {
+	tests := []struct {
+		name   string
+		scheme string
+		ref    string
+		digest string
+		uri    string
+	}{
+		{"DockerTag", "docker", "docker://python:3.9", testHex, "docker://python@sha256:" + testHex},
+		{"DockerRegistryPort", "docker", "docker://localhost:5000/app", testHex, "docker://localhost:5000/app@sha256:" + testHex},
+		{"DockerDigest", "docker", "docker://python@sha256:" + testHex, "sha256:" + testHex, "docker://python@sha256:" + testHex},
+		{"Library", "library", "library://entity/collection/image:latest", "sha256." + testHex, "library://entity/collection/image:sha256." + testHex},
+		{"Oras", "oras", "oras://registry/image:v1", testHex, "oras://registry/image:v1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uri, digest := Pin(tt.scheme, tt.ref, tt.digest)
+			if uri != tt.uri {
+				t.Errorf("got URI %s instead of %s", uri, tt.uri)
+			}
+			if digest != "sha256:"+testHex {
+				t.Errorf("got digest %s instead of sha256:%s", digest, testHex)
+			}
+		})
+	}
+
+	if uri, digest := Pin("docker", "docker://python", ""); uri != "docker://python" || digest != "" {
+		t.Errorf("unexpected pinning without digest: %s %s", uri, digest)
+	}
+}
+
+func TestDescriptors(t *testing.T) {
+	img := Image{
+		URI:    "docker://python@sha256:" + testHex,
+		Digest: "sha256:" + testHex,
+		Options: []Option{
+			{Name: "bind", Value: "/data"},
+			{Name: "bind", Value: "/scratch:/tmp"},
+			{Name: "nv", Value: "true"},
+			{Name: "env", Value: "MODE=it's"},
+		},
+	}
+
+	expectedEnv := []string{"SINGULARITY_BIND=/data,/scratch:/tmp", "SINGULARITY_NV=true", "SINGULARITYENV_MODE=it's"}
+	if env := img.Environment(); !reflect.DeepEqual(env, expectedEnv) {
+		t.Errorf("got environment %v instead of %v", env, expectedEnv)
+	}
+
+	header := "docker://python@sha256:" + testHex + " (sha256:" + testHex + ")\n"
+	tests := []struct {
+		name     string
+		got      string
+		expected string
+	}{
+		{
+			name: "CWL",
+			got:  img.CWL(),
+			expected: "# " + header +
+				`# run with: 'SINGULARITY_BIND=/data,/scratch:/tmp' 'SINGULARITY_NV=true' 'SINGULARITYENV_MODE=it'\''s' cwltool --singularity
+requirements:
+  DockerRequirement:
+    dockerPull: "python@sha256:` + testHex + `"
+`,
+		},
+		{
+			name: "Nextflow",
+			got:  img.Nextflow(),
+			expected: "// " + header +
+				`process.container = 'docker://python@sha256:` + testHex + `'
+singularity.enabled = true
+singularity.runOptions = '--bind=/data --bind=/scratch:/tmp --nv --env=MODE=it\'s'
+`,
+		},
+		{
+			name: "Snakemake",
+			got:  img.Snakemake(),
+			expected: "# " + header +
+				`# run with: snakemake --use-singularity --singularity-args '--bind=/data --bind=/scratch:/tmp --nv --env=MODE=it'\''s'
+container: "docker://python@sha256:` + testHex + `"
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.expected {
+				t.Errorf("got:\n%s\ninstead of:\n%s", tt.got, tt.expected)
+			}
+		})
+	}
+
+	local := Image{URI: "/images/app.sif"}
+	if cwl := local.CWL(); !strings.Contains(cwl, `dockerImageId: "/images/app.sif"`) || strings.Contains(cwl, "run with") {
+		t.Errorf("unexpected CWL requirement of local image:\n%s", cwl)
+	}
+}
@@ -48,6 +48,24 @@ $(sessiondir_INSTALL):
 INSTALLFILES += $(sessiondir_INSTALL)
 
 
+# quotadir
+quotadir_INSTALL := $(DESTDIR)$(LOCALSTATEDIR)/singularity/quota
+$(quotadir_INSTALL):
+	@echo " INSTALL" $@
+	$(V)umask 0022 && mkdir -p $@
+
+INSTALLFILES += $(quotadir_INSTALL)
+
+
+# quarantinedir
+quarantinedir_INSTALL := $(DESTDIR)$(LOCALSTATEDIR)/singularity/quarantine
+$(quarantinedir_INSTALL):
+	@echo " INSTALL" $@
+	$(V)umask 0022 && mkdir -p $@
+
+INSTALLFILES += $(quarantinedir_INSTALL)
+
+
 # run-singularity script
 run_singularity := $(SOURCEDIR)/scripts/run-singularity
 
@@ -106,6 +124,18 @@ $(nvidia_liblist_INSTALL): $(nvidia_liblist)
 INSTALLFILES += $(nvidia_liblist_INSTALL)
 
 
+# host library sets config file
+hostlibs_conf := $(SOURCEDIR)/etc/hostlibs.conf
+
+hostlibs_conf_INSTALL := $(DESTDIR)$(SYSCONFDIR)/singularity/hostlibs.conf
+$(hostlibs_conf_INSTALL): $(hostlibs_conf)
+	@echo " INSTALL" $@
+	$(V)umask 0022 && mkdir -p $(@D)
+	$(V)install -m 0644 $< $@
+
+INSTALLFILES += $(hostlibs_conf_INSTALL)
+
+
 # rocm liblist config file
 rocm_liblist := $(SOURCEDIR)/etc/rocmliblist.conf
 
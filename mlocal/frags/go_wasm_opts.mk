@@ -0,0 +1 @@
+GO_TAGS += wasm_runtime
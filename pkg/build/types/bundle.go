@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 
 	ocitypes "github.com/containers/image/v5/types"
@@ -31,6 +32,7 @@ type Bundle struct {
 
 	RootfsPath string `json:"rootfsPath"` // where actual fs to chroot will appear
 	TmpDir     string `json:"tmpPath"`    // where temp files required during build will appear
+	BuildLog   string `json:"buildLog"`   // compressed build log stored in the image, if any
 
 	parentPath string // parent directory for RootfsPath
 }
@@ -49,6 +51,12 @@ type Options struct {
 	KeyServerOpts []scskeyclient.Option
 	// contains docker credentials if specified.
 	DockerAuthConfig *ocitypes.DockerAuthConfig
+	// DockerConfig is the path of the docker config.json the registry
+	// credentials are looked up in when DockerAuthConfig is not set.
+	DockerConfig string
+	// RegistriesConf is the path of a registries.conf whose registries
+	// not configured by the system are added to the system configuration.
+	RegistriesConf string
 	// EncryptionKeyInfo specifies the key used for filesystem
 	// encryption if applicable.
 	// A nil value indicates encryption should not occur.
@@ -57,6 +65,9 @@ type Options struct {
 	ImgCache *cache.Handle
 	// NoTest indicates if build should skip running the test script.
 	NoTest bool `json:"noTest"`
+	// DebugShell starts an interactive shell in the build sandbox when the
+	// %post or %test section fails.
+	DebugShell bool `json:"debugShell"`
 	// Force automatically deletes an existing container at build destination while performing build.
 	Force bool `json:"force"`
 	// Update detects and builds using an existing sandbox container at build destination.
@@ -75,6 +86,52 @@ type Options struct {
 	// To warn when the above is needed, we need to know if the target of this
 	// bundle will be a sandbox
 	SandboxTarget bool
+	// Arch is the target architecture of the build, an empty value
+	// means the host architecture.
+	Arch string `json:"arch"`
+	// WhiteoutMode controls how the whiteouts of OCI layers are
+	// extracted, an empty value applies them.
+	WhiteoutMode string `json:"whiteoutMode"`
+	// SandboxPerms controls the normalization of the permissions of
+	// sandbox targets.
+	SandboxPerms SandboxPerms `json:"sandboxPerms"`
+	// Limits are the resource limits of the build sections.
+	Limits Limits `json:"limits"`
+}
+
+// Limits describes the resource limits applied to the build, a zero value
+// means no limit.
+type Limits struct {
+	// CPUs is the number of CPUs the %post and %test sections can use.
+	CPUs float64 `json:"cpus"`
+	// Memory is the memory limit of the %post and %test sections in bytes.
+	Memory int64 `json:"memory"`
+	// Disk is the maximum size of the build sandbox in bytes.
+	Disk int64 `json:"disk"`
+}
+
+// Cgroups returns whether the limits require cgroups.
+func (l Limits) Cgroups() bool {
+	return l.CPUs > 0 || l.Memory > 0
+}
+
+// SandboxPerms describes how the permissions of a sandbox are normalized
+// once assembled, since extracted layers often contain unsafe modes.
+type SandboxPerms struct {
+	// Umask is removed from the permissions of all files and directories.
+	Umask os.FileMode `json:"umask"`
+	// StripSetuid removes the setuid and setgid bits of regular files.
+	StripSetuid bool `json:"stripSetuid"`
+	// FixWorldWritable removes the world writable bit of files and
+	// directories, except sticky directories like /tmp.
+	FixWorldWritable bool `json:"fixWorldWritable"`
+	// Report is the path of the JSON report of the modified permissions.
+	Report string `json:"report"`
+}
+
+// Enabled returns whether the permissions are normalized.
+func (p SandboxPerms) Enabled() bool {
+	return p.Umask != 0 || p.StripSetuid || p.FixWorldWritable
 }
 
 // NewEncryptedBundle creates an Encrypted Bundle environment.
@@ -102,6 +159,14 @@ func (b *Bundle) RunSection(s string) bool {
 	return false
 }
 
+// TargetArch returns the architecture the bundle is built for.
+func (b *Bundle) TargetArch() string {
+	if b.Opts.Arch != "" {
+		return b.Opts.Arch
+	}
+	return runtime.GOARCH
+}
+
 // Remove cleans up any bundle files.
 func (b *Bundle) Remove() error {
 	var errors []string
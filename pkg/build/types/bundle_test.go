@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 )
@@ -134,3 +135,15 @@ func TestBundle_RunSections(t *testing.T) {
 		})
 	}
 }
+
+func TestTargetArch(t *testing.T) {
+	b := Bundle{}
+	if arch := b.TargetArch(); arch != runtime.GOARCH {
+		t.Errorf("got %q instead of the host architecture %q", arch, runtime.GOARCH)
+	}
+
+	b.Opts.Arch = "s390x"
+	if arch := b.TargetArch(); arch != "s390x" {
+		t.Errorf("got %q instead of the requested architecture s390x", arch)
+	}
+}
@@ -36,11 +36,12 @@ type ImageData struct {
 
 // ImageScripts contains scripts that are used after build time.
 type ImageScripts struct {
-	Help        Script `json:"help"`
-	Environment Script `json:"environment"`
-	Runscript   Script `json:"runScript"`
-	Test        Script `json:"test"`
-	Startscript Script `json:"startScript"`
+	Help           Script `json:"help"`
+	Environment    Script `json:"environment"`
+	Runscript      Script `json:"runScript"`
+	Test           Script `json:"test"`
+	Startscript    Script `json:"startScript"`
+	Shutdownscript Script `json:"shutdownScript"`
 }
 
 // Data contains any scripts, metadata, etc... that the Builder may
@@ -186,6 +187,7 @@ func populateRaw(d *Definition, w io.Writer) {
 	writeSectionIfExists(w, "runscript", d.ImageData.Runscript)
 	writeSectionIfExists(w, "test", d.ImageData.Test)
 	writeSectionIfExists(w, "startscript", d.ImageData.Startscript)
+	writeSectionIfExists(w, "shutdownscript", d.ImageData.Shutdownscript)
 	writeSectionIfExists(w, "pre", d.BuildData.Pre)
 	writeSectionIfExists(w, "setup", d.BuildData.Setup)
 	writeSectionIfExists(w, "post", d.BuildData.Post)
@@ -306,11 +306,12 @@ func populateDefinition(sections map[string]*types.Script, files *[]types.Files,
 
 	d.ImageData = types.ImageData{
 		ImageScripts: types.ImageScripts{
-			Help:        *sections["help"],
-			Environment: *sections["environment"],
-			Runscript:   *sections["runscript"],
-			Test:        *sections["test"],
-			Startscript: *sections["startscript"],
+			Help:           *sections["help"],
+			Environment:    *sections["environment"],
+			Runscript:      *sections["runscript"],
+			Test:           *sections["test"],
+			Startscript:    *sections["startscript"],
+			Shutdownscript: *sections["shutdownscript"],
 		},
 		Labels: GetLabels(sections["labels"].Script),
 	}
@@ -543,16 +544,17 @@ func isEmpty(d types.Definition) bool {
 // validSections just contains a list of all the valid sections a definition file
 // could contain. If any others are found, an error will generate
 var validSections = map[string]bool{
-	"help":        true,
-	"setup":       true,
-	"files":       true,
-	"labels":      true,
-	"environment": true,
-	"pre":         true,
-	"post":        true,
-	"runscript":   true,
-	"test":        true,
-	"startscript": true,
+	"help":           true,
+	"setup":          true,
+	"files":          true,
+	"labels":         true,
+	"environment":    true,
+	"pre":            true,
+	"post":           true,
+	"runscript":      true,
+	"test":           true,
+	"startscript":    true,
+	"shutdownscript": true,
 }
 
 var appSections = map[string]bool{
@@ -190,6 +190,7 @@ func TestParseDefinitionFile(t *testing.T) {
 		{"MultipleFiles", "testdata_good/multiplefiles/multiplefiles", "testdata_good/multiplefiles/multiplefiles.json"},
 		{"QuotedFiles", "testdata_good/quotedfiles/quotedfiles", "testdata_good/quotedfiles/quotedfiles.json"},
 		{"Shebang", "testdata_good/shebang/shebang", "testdata_good/shebang/shebang.json"},
+		{"ShutdownScript", "testdata_good/shutdownscript/shutdownscript", "testdata_good/shutdownscript/shutdownscript.json"},
 	}
 
 	for _, tt := range tests {
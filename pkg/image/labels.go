@@ -0,0 +1,49 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/hpcng/singularity/pkg/inspect"
+)
+
+// Labels returns the container labels of a SIF or sandbox image. SIF labels
+// are read from the inspect metadata descriptor, a nil map is returned for
+// other image formats or if the image has no labels.
+func (i *Image) Labels() (map[string]string, error) {
+	switch i.Type {
+	case SIF:
+		r, err := NewSectionReader(i, SIFDescInspectMetadataJSON, -1)
+		if err == ErrNoSection {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		metadata := new(inspect.Metadata)
+		if err := json.NewDecoder(r).Decode(metadata); err != nil {
+			return nil, fmt.Errorf("while decoding inspect metadata: %s", err)
+		}
+		return metadata.Attributes.Labels, nil
+	case SANDBOX:
+		data, err := ioutil.ReadFile(filepath.Join(i.Path, ".singularity.d", "labels.json"))
+		if os.IsNotExist(err) {
+			return nil, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("while reading labels: %s", err)
+		}
+		labels := make(map[string]string)
+		if err := json.Unmarshal(data, &labels); err != nil {
+			return nil, fmt.Errorf("while decoding labels: %s", err)
+		}
+		return labels, nil
+	}
+	return nil, nil
+}
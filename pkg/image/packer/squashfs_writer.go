@@ -0,0 +1,647 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package packer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// squashfs 4.0 on-disk format constants
+const (
+	sqfsMagic         = 0x73717368
+	sqfsSuperSize     = 96
+	sqfsBlockSize     = 128 * 1024
+	sqfsBlockLog      = 17
+	sqfsMetadataSize  = 8192
+	sqfsGzip          = 1
+	sqfsPadding       = 4096
+	sqfsMaxDirEntries = 256
+	sqfsInvalidBlock  = 0xffffffffffffffff
+	sqfsInvalidFrag   = 0xffffffff
+	sqfsInvalidXattr  = 0xffffffff
+
+	sqfsFlagNoFragments = 0x0010
+	sqfsFlagNoXattrs    = 0x0200
+
+	sqfsUncompressedMetadata = 0x8000
+	sqfsUncompressedBlock    = 1 << 24
+)
+
+// squashfs inode types
+const (
+	sqfsDirType uint16 = iota + 1
+	sqfsFileType
+	sqfsSymlinkType
+	sqfsBlkdevType
+	sqfsChrdevType
+	sqfsFifoType
+	sqfsSocketType
+	sqfsLdirType
+	sqfsLregType
+)
+
+// SquashfsWriter is a squashfs packer creating gzip compressed
+// squashfs images without any external tool, it doesn't support
+// fragments and extended attributes
+type SquashfsWriter struct {
+	// AllRoot sets the owner of all files to root
+	AllRoot bool
+}
+
+// NewSquashfsWriter initializes and returns a SquashfsWriter packer instance
+func NewSquashfsWriter() *SquashfsWriter {
+	return &SquashfsWriter{}
+}
+
+// sqfsNode represents a file of the source directory tree.
+type sqfsNode struct {
+	path     string
+	name     string
+	fi       os.FileInfo
+	children []*sqfsNode
+	// link points to the first node of a hard link set
+	link     *sqfsNode
+	nlink    uint32
+	inodeNum uint32
+	ref      uint64
+	written  bool
+
+	// regular file data location
+	size        uint64
+	sparse      uint64
+	blocksStart uint64
+	blockSizes  []uint32
+}
+
+// sqfsInode identifies a file on the host filesystem.
+type sqfsInode struct {
+	dev uint64
+	ino uint64
+}
+
+// metadataWriter writes data as a sequence of compressed
+// 8KiB metadata blocks.
+type metadataWriter struct {
+	buf         bytes.Buffer
+	out         bytes.Buffer
+	blockStarts []uint64
+}
+
+// position returns the location of the next byte written, as
+// the metadata block start relative to the table start and
+// the offset within the uncompressed block.
+func (m *metadataWriter) position() (uint32, uint16) {
+	return uint32(m.out.Len()), uint16(m.buf.Len())
+}
+
+// ref returns the inode reference of the next byte written.
+func (m *metadataWriter) ref() uint64 {
+	block, offset := m.position()
+	return uint64(block)<<16 | uint64(offset)
+}
+
+func (m *metadataWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		l := sqfsMetadataSize - m.buf.Len()
+		if l > len(p) {
+			l = len(p)
+		}
+		m.buf.Write(p[:l])
+		p = p[l:]
+		if m.buf.Len() == sqfsMetadataSize {
+			if err := m.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (m *metadataWriter) flush() error {
+	if m.buf.Len() == 0 {
+		return nil
+	}
+
+	data := m.buf.Bytes()
+	header := uint16(len(data)) | sqfsUncompressedMetadata
+
+	c, err := compress(data)
+	if err != nil {
+		return err
+	}
+	if len(c) < len(data) {
+		header = uint16(len(c))
+		data = c
+	}
+
+	m.blockStarts = append(m.blockStarts, uint64(m.out.Len()))
+	binary.Write(&m.out, binary.LittleEndian, header)
+	m.out.Write(data)
+	m.buf.Reset()
+
+	return nil
+}
+
+// compress returns data compressed with zlib.
+func compress(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+
+	w, err := zlib.NewWriterLevel(&b, zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// countWriter tracks the current offset in the image.
+type countWriter struct {
+	w      io.Writer
+	offset uint64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.offset += uint64(n)
+	return n, err
+}
+
+// sqfsImage holds the state of an image being created.
+type sqfsImage struct {
+	allRoot bool
+	out     *countWriter
+	inodes  metadataWriter
+	dirs    metadataWriter
+	ids     []uint32
+	idIndex map[uint32]uint16
+	count   uint32
+	block   []byte
+}
+
+// Create makes a squashfs filesystem from the source directory to a
+// destination file
+func (s SquashfsWriter) Create(src string, dest string) (err error) {
+	img := &sqfsImage{
+		allRoot: s.AllRoot,
+		idIndex: make(map[uint32]uint16),
+		block:   make([]byte, sqfsBlockSize),
+	}
+
+	root, err := img.scan(src, "", make(map[sqfsInode]*sqfsNode))
+	if err != nil {
+		return err
+	}
+	if !root.fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", src)
+	}
+	img.number(root)
+
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("while creating %s: %s", dest, err)
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("while closing %s: %s", dest, cerr)
+		}
+	}()
+
+	// data blocks are written right after the super block
+	if _, err := f.Seek(sqfsSuperSize, io.SeekStart); err != nil {
+		return fmt.Errorf("while seeking %s: %s", dest, err)
+	}
+	bw := bufio.NewWriter(f)
+	img.out = &countWriter{w: bw, offset: sqfsSuperSize}
+
+	if err := img.writeData(root); err != nil {
+		return err
+	}
+	if err := img.writeDir(root, img.count+1); err != nil {
+		return err
+	}
+	if err := img.inodes.flush(); err != nil {
+		return err
+	}
+	if err := img.dirs.flush(); err != nil {
+		return err
+	}
+
+	sb, err := img.writeTables()
+	if err != nil {
+		return err
+	}
+	sb.rootInode = root.ref
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("while writing %s: %s", dest, err)
+	}
+	if pad := sb.bytesUsed % sqfsPadding; pad != 0 {
+		if err := f.Truncate(int64(sb.bytesUsed + sqfsPadding - pad)); err != nil {
+			return fmt.Errorf("while padding %s: %s", dest, err)
+		}
+	}
+	if _, err := f.WriteAt(sb.bytes(), 0); err != nil {
+		return fmt.Errorf("while writing super block: %s", err)
+	}
+	return nil
+}
+
+// scan builds the tree of the directory located at path.
+func (i *sqfsImage) scan(path, name string, links map[sqfsInode]*sqfsNode) (*sqfsNode, error) {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &sqfsNode{path: path, name: name, fi: fi, nlink: 1}
+
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && !fi.IsDir() && st.Nlink > 1 {
+		key := sqfsInode{dev: uint64(st.Dev), ino: st.Ino}
+		if first, ok := links[key]; ok {
+			first.nlink++
+			n.link = first
+			return n, nil
+		}
+		links[key] = n
+	}
+
+	if !fi.IsDir() {
+		return n, nil
+	}
+
+	// entries are sorted by name as required by squashfs
+	list, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range list {
+		c, err := i.scan(filepath.Join(path, e.Name()), e.Name(), links)
+		if err != nil {
+			return nil, err
+		}
+		n.children = append(n.children, c)
+	}
+	return n, nil
+}
+
+// number assigns inode numbers in tree order.
+func (i *sqfsImage) number(n *sqfsNode) {
+	if n.link == nil {
+		i.count++
+		n.inodeNum = i.count
+	}
+	for _, c := range n.children {
+		i.number(c)
+	}
+}
+
+// writeData writes data blocks of all regular files.
+func (i *sqfsImage) writeData(n *sqfsNode) error {
+	if n.link == nil && n.fi.Mode().IsRegular() {
+		if err := i.writeFile(n); err != nil {
+			return fmt.Errorf("while writing %s data: %s", n.path, err)
+		}
+	}
+	for _, c := range n.children {
+		if err := i.writeData(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *sqfsImage) writeFile(n *sqfsNode) error {
+	f, err := os.Open(n.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	n.blocksStart = i.out.offset
+
+	for {
+		l, err := io.ReadFull(f, i.block)
+		if err == io.EOF {
+			break
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		block := i.block[:l]
+		n.size += uint64(l)
+
+		if isZero(block) {
+			n.sparse += uint64(l)
+			n.blockSizes = append(n.blockSizes, 0)
+			continue
+		}
+
+		size := uint32(l) | sqfsUncompressedBlock
+		c, err := compress(block)
+		if err != nil {
+			return err
+		}
+		if len(c) < l {
+			size = uint32(len(c))
+			block = c
+		}
+		if _, err := i.out.Write(block); err != nil {
+			return err
+		}
+		n.blockSizes = append(n.blockSizes, size)
+	}
+	return nil
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// id returns the index of id in the id table.
+func (i *sqfsImage) id(id uint32) uint16 {
+	if i.allRoot {
+		id = 0
+	}
+	idx, ok := i.idIndex[id]
+	if !ok {
+		idx = uint16(len(i.ids))
+		i.idIndex[id] = idx
+		i.ids = append(i.ids, id)
+	}
+	return idx
+}
+
+// inodeType returns the basic squashfs inode type of a file.
+func inodeType(mode os.FileMode) uint16 {
+	switch {
+	case mode.IsDir():
+		return sqfsDirType
+	case mode&os.ModeSymlink != 0:
+		return sqfsSymlinkType
+	case mode&os.ModeCharDevice != 0:
+		return sqfsChrdevType
+	case mode&os.ModeDevice != 0:
+		return sqfsBlkdevType
+	case mode&os.ModeNamedPipe != 0:
+		return sqfsFifoType
+	case mode&os.ModeSocket != 0:
+		return sqfsSocketType
+	}
+	return sqfsFileType
+}
+
+// writeHeader writes the common inode header.
+func (i *sqfsImage) writeHeader(n *sqfsNode, typ uint16) {
+	var perm uint16
+	var uid, gid uint32
+
+	if st, ok := n.fi.Sys().(*syscall.Stat_t); ok {
+		perm = uint16(st.Mode) & 07777
+		uid, gid = st.Uid, st.Gid
+	} else {
+		perm = uint16(n.fi.Mode().Perm())
+	}
+
+	n.ref = i.inodes.ref()
+	n.written = true
+
+	i.write(&i.inodes, typ, perm, i.id(uid), i.id(gid), uint32(n.fi.ModTime().Unix()), n.inodeNum)
+}
+
+// write writes little endian values into w, writes to
+// metadata writers never fail.
+func (i *sqfsImage) write(w io.Writer, values ...interface{}) {
+	for _, v := range values {
+		binary.Write(w, binary.LittleEndian, v)
+	}
+}
+
+// writeInode writes the inode of a non directory file.
+func (i *sqfsImage) writeInode(n *sqfsNode) error {
+	typ := inodeType(n.fi.Mode())
+
+	switch typ {
+	case sqfsFileType:
+		if n.blocksStart > 0xffffffff || n.size > 0xffffffff || n.nlink > 1 {
+			i.writeHeader(n, sqfsLregType)
+			i.write(&i.inodes, n.blocksStart, n.size, n.sparse, n.nlink, uint32(sqfsInvalidFrag), uint32(0), uint32(sqfsInvalidXattr))
+		} else {
+			i.writeHeader(n, sqfsFileType)
+			i.write(&i.inodes, uint32(n.blocksStart), uint32(sqfsInvalidFrag), uint32(0), uint32(n.size))
+		}
+		i.write(&i.inodes, n.blockSizes)
+	case sqfsSymlinkType:
+		target, err := os.Readlink(n.path)
+		if err != nil {
+			return fmt.Errorf("while reading link %s: %s", n.path, err)
+		}
+		i.writeHeader(n, typ)
+		i.write(&i.inodes, n.nlink, uint32(len(target)), []byte(target))
+	case sqfsBlkdevType, sqfsChrdevType:
+		var rdev uint64
+		if st, ok := n.fi.Sys().(*syscall.Stat_t); ok {
+			rdev = uint64(st.Rdev)
+		}
+		major, minor := unix.Major(rdev), unix.Minor(rdev)
+		i.writeHeader(n, typ)
+		i.write(&i.inodes, n.nlink, (minor&0xff)|(major<<8)|((minor&^0xff)<<12))
+	default:
+		i.writeHeader(n, typ)
+		i.write(&i.inodes, n.nlink)
+	}
+	return nil
+}
+
+// dirEntry is an entry of a directory listing.
+type dirEntry struct {
+	name     string
+	ref      uint64
+	inodeNum uint32
+	typ      uint16
+}
+
+// writeDir writes the inodes of the directory content followed by
+// its listing and its inode.
+func (i *sqfsImage) writeDir(n *sqfsNode, parent uint32) error {
+	entries := make([]dirEntry, 0, len(n.children))
+	nlink := uint32(2)
+
+	for _, c := range n.children {
+		target := c
+		if c.link != nil {
+			target = c.link
+		}
+		if target.fi.IsDir() {
+			nlink++
+		}
+		if !target.written {
+			var err error
+			if target.fi.IsDir() {
+				err = i.writeDir(target, n.inodeNum)
+			} else {
+				err = i.writeInode(target)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		entries = append(entries, dirEntry{
+			name:     c.name,
+			ref:      target.ref,
+			inodeNum: target.inodeNum,
+			typ:      inodeType(target.fi.Mode()),
+		})
+	}
+
+	block, offset := i.dirs.position()
+	size := i.writeListing(entries)
+
+	// directory size accounts for the implicit . and .. entries
+	size += 3
+	if size > 0xffff {
+		i.writeHeader(n, sqfsLdirType)
+		i.write(&i.inodes, nlink, size, block, parent, uint16(0), offset, uint32(sqfsInvalidXattr))
+	} else {
+		i.writeHeader(n, sqfsDirType)
+		i.write(&i.inodes, block, nlink, uint16(size), offset, parent)
+	}
+	return nil
+}
+
+// writeListing writes directory entries into the directory table
+// and returns the size of the listing.
+func (i *sqfsImage) writeListing(entries []dirEntry) uint32 {
+	var listing bytes.Buffer
+
+	for len(entries) > 0 {
+		block := uint32(entries[0].ref >> 16)
+		base := entries[0].inodeNum
+
+		// entries sharing a header must have their inode in the same
+		// metadata block and an inode number close to the base one
+		count := 1
+		for ; count < len(entries) && count < sqfsMaxDirEntries; count++ {
+			e := entries[count]
+			diff := int64(e.inodeNum) - int64(base)
+			if uint32(e.ref>>16) != block || diff < -32768 || diff > 32767 {
+				break
+			}
+		}
+
+		i.write(&listing, uint32(count-1), block, base)
+		for _, e := range entries[:count] {
+			i.write(&listing, uint16(e.ref&0xffff), int16(int64(e.inodeNum)-int64(base)), e.typ, uint16(len(e.name)-1), []byte(e.name))
+		}
+		entries = entries[count:]
+	}
+
+	i.dirs.Write(listing.Bytes())
+
+	return uint32(listing.Len())
+}
+
+// superBlock holds the squashfs super block fields.
+type superBlock struct {
+	inodeCount      uint32
+	rootInode       uint64
+	bytesUsed       uint64
+	idTableStart    uint64
+	inodeTableStart uint64
+	dirTableStart   uint64
+	fragTableStart  uint64
+	idCount         uint16
+}
+
+func (s superBlock) bytes() []byte {
+	var b bytes.Buffer
+
+	values := []interface{}{
+		uint32(sqfsMagic),
+		s.inodeCount,
+		uint32(time.Now().Unix()),
+		uint32(sqfsBlockSize),
+		uint32(0),
+		uint16(sqfsGzip),
+		uint16(sqfsBlockLog),
+		uint16(sqfsFlagNoFragments | sqfsFlagNoXattrs),
+		s.idCount,
+		uint16(4),
+		uint16(0),
+		s.rootInode,
+		s.bytesUsed,
+		s.idTableStart,
+		uint64(sqfsInvalidBlock),
+		s.inodeTableStart,
+		s.dirTableStart,
+		s.fragTableStart,
+		uint64(sqfsInvalidBlock),
+	}
+	for _, v := range values {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	return b.Bytes()
+}
+
+// writeTables writes the inode, directory and id tables after
+// the data blocks and returns the corresponding super block.
+func (i *sqfsImage) writeTables() (superBlock, error) {
+	sb := superBlock{
+		inodeCount: i.count,
+		idCount:    uint16(len(i.ids)),
+	}
+
+	sb.inodeTableStart = i.out.offset
+	if _, err := i.out.Write(i.inodes.out.Bytes()); err != nil {
+		return sb, fmt.Errorf("while writing inode table: %s", err)
+	}
+	sb.dirTableStart = i.out.offset
+	if _, err := i.out.Write(i.dirs.out.Bytes()); err != nil {
+		return sb, fmt.Errorf("while writing directory table: %s", err)
+	}
+	// there is no fragment, the fragment table is empty
+	sb.fragTableStart = i.out.offset
+
+	var ids metadataWriter
+	i.write(&ids, i.ids)
+	if err := ids.flush(); err != nil {
+		return sb, err
+	}
+	idStart := i.out.offset
+	if _, err := i.out.Write(ids.out.Bytes()); err != nil {
+		return sb, fmt.Errorf("while writing id table: %s", err)
+	}
+	sb.idTableStart = i.out.offset
+	for _, start := range ids.blockStarts {
+		if err := binary.Write(i.out, binary.LittleEndian, idStart+start); err != nil {
+			return sb, fmt.Errorf("while writing id table index: %s", err)
+		}
+	}
+	sb.bytesUsed = i.out.offset
+
+	return sb, nil
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package packer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSquashfsWriter(t *testing.T) {
+	image, err := ioutil.TempFile("", "packer-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	image.Close()
+	defer os.Remove(image.Name())
+
+	s := NewSquashfsWriter()
+	s.AllRoot = true
+
+	if err := s.Create("squashfs.go", image.Name()); err == nil {
+		t.Errorf("unexpected success with a non directory source")
+	}
+	if err := s.Create(".", image.Name()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	b, err := ioutil.ReadFile(image.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b)%sqfsPadding != 0 {
+		t.Errorf("image size %d is not a multiple of %d", len(b), sqfsPadding)
+	}
+
+	var sb struct {
+		Magic      uint32
+		InodeCount uint32
+		ModTime    uint32
+		BlockSize  uint32
+		FragCount  uint32
+		Comp       uint16
+		BlockLog   uint16
+		Flags      uint16
+		IDCount    uint16
+		Major      uint16
+		Minor      uint16
+		RootInode  uint64
+		BytesUsed  uint64
+	}
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &sb); err != nil {
+		t.Fatalf("while reading super block: %s", err)
+	}
+
+	files, err := ioutil.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case sb.Magic != sqfsMagic:
+		t.Errorf("bad magic %x", sb.Magic)
+	case sb.Major != 4 || sb.Minor != 0:
+		t.Errorf("bad version %d.%d", sb.Major, sb.Minor)
+	case sb.Comp != sqfsGzip:
+		t.Errorf("bad compression %d", sb.Comp)
+	case sb.InodeCount != uint32(len(files)+1):
+		t.Errorf("unexpected inode count %d instead of %d", sb.InodeCount, len(files)+1)
+	case sb.IDCount != 1:
+		t.Errorf("unexpected id count %d with all root", sb.IDCount)
+	case sb.BytesUsed > uint64(len(b)):
+		t.Errorf("bytes used %d greater than image size %d", sb.BytesUsed, len(b))
+	}
+
+	checkArchive(t, image.Name(), []string{"squashfs.go", "squashfs_writer.go"})
+}
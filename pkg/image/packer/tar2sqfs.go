@@ -0,0 +1,172 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package packer
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// Tar2sqfs represents a squashfs packer using tar2sqfs from
+// squashfs-tools-ng, the source directory is streamed as a
+// tar archive on the tar2sqfs standard input
+type Tar2sqfs struct {
+	Tar2sqfsPath string
+	// AllRoot sets the owner of all files to root
+	AllRoot bool
+}
+
+// NewTar2sqfs initializes and returns a Tar2sqfs packer instance
+func NewTar2sqfs() *Tar2sqfs {
+	t := &Tar2sqfs{}
+	t.Tar2sqfsPath, _ = exec.LookPath("tar2sqfs")
+	return t
+}
+
+// HasTar2sqfs returns if tar2sqfs binary has set or not
+func (t Tar2sqfs) HasTar2sqfs() bool {
+	return t.Tar2sqfsPath != ""
+}
+
+// Create makes a squashfs filesystem from the source directory to a
+// destination file, opts are passed as is to tar2sqfs
+func (t Tar2sqfs) Create(src string, dest string, opts []string) error {
+	var stderr bytes.Buffer
+
+	if !t.HasTar2sqfs() {
+		return fmt.Errorf("could not create squashfs, tar2sqfs not found")
+	}
+
+	// tar2sqfs takes args of the form: [options] destination
+	args := append([]string{}, opts...)
+	args = append(args, dest)
+
+	pr, pw := io.Pipe()
+
+	cmd := exec.Command(t.Tar2sqfsPath, args...)
+	cmd.Stdin = pr
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("while starting tar2sqfs: %v", err)
+	}
+
+	go func() {
+		pw.CloseWithError(t.writeTar(src, pw))
+	}()
+
+	err := cmd.Wait()
+	// unblock the tar writer if tar2sqfs exited early
+	pr.Close()
+	if err != nil {
+		return fmt.Errorf("create command failed: %v: %s", err, stderr.String())
+	}
+	return nil
+}
+
+type inode struct {
+	dev uint64
+	ino uint64
+}
+
+// writeTar writes a tar archive of the src directory content to w.
+func (t Tar2sqfs) writeTar(src string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	links := make(map[inode]string)
+
+	err := filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		// sockets can't be stored in a tar archive
+		if fi.Mode()&os.ModeSocket != 0 {
+			return nil
+		}
+
+		target := ""
+		if fi.Mode()&os.ModeSymlink != 0 {
+			target, err = os.Readlink(path)
+			if err != nil {
+				return fmt.Errorf("while reading link %s: %s", path, err)
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(fi, target)
+		if err != nil {
+			return fmt.Errorf("while creating tar header for %s: %s", path, err)
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		if t.AllRoot {
+			hdr.Uid, hdr.Gid = 0, 0
+			hdr.Uname, hdr.Gname = "root", "root"
+		}
+
+		// file capabilities and other extended attributes are
+		// stored by tar2sqfs
+		xattrs, err := Xattrs(path)
+		if err != nil {
+			return fmt.Errorf("while reading extended attributes of %s: %s", path, err)
+		}
+		for name, value := range xattrs {
+			if !squashfsXattr(name) {
+				continue
+			}
+			if hdr.PAXRecords == nil {
+				hdr.PAXRecords = make(map[string]string)
+			}
+			hdr.PAXRecords["SCHILY.xattr."+name] = value
+		}
+
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok && fi.Mode().IsRegular() && st.Nlink > 1 {
+			key := inode{dev: uint64(st.Dev), ino: st.Ino}
+			if first, ok := links[key]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = first
+				hdr.Size = 0
+				return tw.WriteHeader(hdr)
+			}
+			links[key] = hdr.Name
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("while writing tar header for %s: %s", path, err)
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if _, err := io.Copy(tw, f); err != nil {
+			return fmt.Errorf("while writing %s to tar archive: %s", path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
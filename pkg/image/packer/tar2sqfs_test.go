@@ -0,0 +1,95 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package packer
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestTar2sqfsWriteTar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tar2sqfs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "dir", "file"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(dir, "dir", "file"), filepath.Join(dir, "hardlink")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("dir/file", filepath.Join(dir, "symlink")); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	tp := Tar2sqfs{AllRoot: true}
+	if err := tp.writeTar(dir, &buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	headers := make(map[string]*tar.Header)
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("while reading tar archive: %s", err)
+		}
+		if hdr.Uid != 0 || hdr.Gid != 0 {
+			t.Errorf("%s is not owned by root", hdr.Name)
+		}
+		headers[hdr.Name] = hdr
+	}
+
+	if hdr, ok := headers["dir/"]; !ok || hdr.Typeflag != tar.TypeDir {
+		t.Errorf("dir/ is missing or is not a directory")
+	}
+	if hdr, ok := headers["symlink"]; !ok || hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != "dir/file" {
+		t.Errorf("symlink is missing or has a wrong target")
+	}
+	// the first file walked is stored, the second one is a hard link to it
+	file, hasFile := headers["dir/file"]
+	link, hasLink := headers["hardlink"]
+	if !hasFile || !hasLink {
+		t.Fatalf("dir/file or hardlink is missing")
+	}
+	if file.Typeflag != tar.TypeReg || file.Size != 7 {
+		t.Errorf("dir/file is not a regular file of 7 bytes")
+	}
+	if link.Typeflag != tar.TypeLink || link.Linkname != "dir/file" {
+		t.Errorf("hardlink is not a hard link to dir/file")
+	}
+}
+
+func TestTar2sqfs(t *testing.T) {
+	tp := NewTar2sqfs()
+	tp.Tar2sqfsPath = ""
+	if err := tp.Create(".", "/dev/null", nil); err == nil {
+		t.Errorf("unexpected success with empty tar2sqfs path")
+	}
+
+	tp.Tar2sqfsPath, _ = exec.LookPath("false")
+	if tp.Tar2sqfsPath == "" {
+		t.Skip("false not found, skipping")
+	}
+	if err := tp.Create(".", "/dev/null", nil); err == nil {
+		t.Errorf("unexpected success with non-zero exit code")
+	}
+}
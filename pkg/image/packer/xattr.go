@@ -0,0 +1,76 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package packer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// capabilityXattr is the extended attribute holding file capabilities.
+	capabilityXattr = "security.capability"
+	// aclXattrPrefix is the prefix of the POSIX ACLs extended attributes.
+	aclXattrPrefix = "system.posix_acl_"
+)
+
+// XattrStats counts the files of a directory tree with extended attributes.
+type XattrStats struct {
+	// Files is the number of files with extended attributes stored in
+	// squashfs images.
+	Files int
+	// Capabilities is the number of files with file capabilities.
+	Capabilities int
+	// ACLs is the number of files with POSIX ACLs, which can't be stored
+	// in squashfs images.
+	ACLs int
+}
+
+// squashfsXattr returns whether the extended attribute name can be stored
+// in a squashfs image, which only supports the user, trusted and security
+// namespaces.
+func squashfsXattr(name string) bool {
+	return strings.HasPrefix(name, "user.") ||
+		strings.HasPrefix(name, "trusted.") ||
+		strings.HasPrefix(name, "security.")
+}
+
+// ScanXattrs returns the extended attributes statistics of the src directory
+// tree, files which can't be read are ignored.
+func ScanXattrs(src string) (XattrStats, error) {
+	var stats XattrStats
+
+	err := filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		xattrs, err := Xattrs(path)
+		if err != nil || len(xattrs) == 0 {
+			return nil
+		}
+
+		stored, acl := false, false
+		for name := range xattrs {
+			if squashfsXattr(name) {
+				stored = true
+			} else if strings.HasPrefix(name, aclXattrPrefix) {
+				acl = true
+			}
+		}
+		if stored {
+			stats.Files++
+		}
+		if _, ok := xattrs[capabilityXattr]; ok {
+			stats.Capabilities++
+		}
+		if acl {
+			stats.ACLs++
+		}
+		return nil
+	})
+	return stats, err
+}
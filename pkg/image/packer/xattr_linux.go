@@ -0,0 +1,48 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package packer
+
+import (
+	"bytes"
+
+	"golang.org/x/sys/unix"
+)
+
+// Xattrs returns the extended attributes of path without following symbolic
+// links, no attributes are returned if the filesystem doesn't support them.
+func Xattrs(path string) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err == unix.ENOTSUP || size <= 0 {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	names := make([]byte, size)
+	size, err = unix.Llistxattr(path, names)
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs := make(map[string]string)
+	for _, name := range bytes.Split(names[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		n := string(name)
+		vsize, err := unix.Lgetxattr(path, n, nil)
+		if err != nil {
+			// attributes may be removed meanwhile or not readable
+			continue
+		}
+		value := make([]byte, vsize)
+		vsize, err = unix.Lgetxattr(path, n, value)
+		if err != nil {
+			continue
+		}
+		xattrs[n] = string(value[:vsize])
+	}
+	return xattrs, nil
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package packer
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestXattrs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xattr-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "plain"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Lsetxattr(file, "user.test", []byte("value"), 0); err != nil {
+		t.Skipf("extended attributes not supported: %s", err)
+	}
+
+	stats, err := ScanXattrs(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if stats.Files != 1 || stats.Capabilities != 0 || stats.ACLs != 0 {
+		t.Errorf("unexpected statistics: %+v", stats)
+	}
+
+	var buf bytes.Buffer
+
+	tp := Tar2sqfs{}
+	if err := tp.writeTar(dir, &buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			t.Fatalf("file is missing")
+		} else if err != nil {
+			t.Fatalf("while reading tar archive: %s", err)
+		}
+		if hdr.Name != "file" {
+			continue
+		}
+		if v := hdr.PAXRecords["SCHILY.xattr.user.test"]; v != "value" {
+			t.Errorf("got user.test extended attribute %q, want %q", v, "value")
+		}
+		return
+	}
+}
+
+func TestSquashfsXattr(t *testing.T) {
+	for name, want := range map[string]bool{
+		"user.test":                true,
+		"trusted.overlay.opaque":   true,
+		"security.capability":      true,
+		"system.posix_acl_access":  false,
+		"system.posix_acl_default": false,
+	} {
+		if got := squashfsXattr(name); got != want {
+			t.Errorf("got %v for %s, want %v", got, name, want)
+		}
+	}
+}
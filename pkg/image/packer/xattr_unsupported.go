@@ -0,0 +1,13 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build !linux
+
+package packer
+
+// Xattrs returns no extended attributes for unsupported platforms.
+func Xattrs(path string) (map[string]string, error) {
+	return nil, nil
+}
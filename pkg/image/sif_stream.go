@@ -0,0 +1,138 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package image
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hpcng/sif/pkg/sif"
+)
+
+// SIFStreamRootFs identifies the primary system partition of a SIF image
+// when streaming a data object.
+const SIFStreamRootFs = "rootfs"
+
+// sifStream reads a SIF image forward only, keeping track of the current
+// offset.
+type sifStream struct {
+	r      io.Reader
+	offset int64
+}
+
+func (s *sifStream) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.offset += int64(n)
+	return n, err
+}
+
+// skipTo moves the stream to offset, seeking when supported by the underlying
+// reader, discarding the data read otherwise.
+func (s *sifStream) skipTo(offset int64) error {
+	if offset < s.offset {
+		return fmt.Errorf("data at offset %d was already read, a SIF stream can only be read forward", offset)
+	}
+	if seeker, ok := s.r.(io.Seeker); ok {
+		// pipes implement io.Seeker but return an error
+		if _, err := seeker.Seek(offset, io.SeekStart); err == nil {
+			s.offset = offset
+			return nil
+		}
+	}
+	n, err := io.CopyN(ioutil.Discard, s.r, offset-s.offset)
+	s.offset += n
+	return err
+}
+
+// matchDescriptor returns whether the data object descriptor d is identified
+// by object: SIFStreamRootFs for the primary system partition, a descriptor
+// ID or a descriptor name.
+func matchDescriptor(d sif.Descriptor, object string) bool {
+	if !d.Used {
+		return false
+	}
+	if object == SIFStreamRootFs {
+		ptype, err := d.GetPartType()
+		return err == nil && ptype == sif.PartPrimSys
+	}
+	if id, err := strconv.ParseUint(object, 10, 32); err == nil {
+		return d.ID == uint32(id)
+	}
+	return d.GetName() == object
+}
+
+// checkDescriptors returns an error if the descriptors announced by the
+// SIF global header don't fit in the descriptors area, or in the image
+// file when r is a regular file.
+func checkDescriptors(r io.Reader, header *sif.Header) error {
+	size := int64(binary.Size(sif.Descriptor{}))
+	if header.Descroff < 0 || header.Descrlen < 0 || header.Dtotal > header.Descrlen/size {
+		return fmt.Errorf("SIF global header announces %d descriptors exceeding the descriptors area", header.Dtotal)
+	}
+
+	f, ok := r.(*os.File)
+	if !ok {
+		return nil
+	}
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil
+	}
+	if header.Descroff > fi.Size() || header.Dtotal*size > fi.Size()-header.Descroff {
+		return fmt.Errorf("SIF global header announces %d descriptors exceeding the image size", header.Dtotal)
+	}
+	return nil
+}
+
+// NewSIFStreamReader reads the SIF global header and descriptors from r and
+// returns a reader streaming the data of the data object identified by object,
+// either SIFStreamRootFs for the primary system partition, a descriptor ID or
+// a descriptor name, along with its descriptor. The image is read forward
+// only, without loading it in memory or in a temporary file, so r can be a
+// pipe or the standard input.
+func NewSIFStreamReader(r io.Reader, object string) (io.Reader, *sif.Descriptor, error) {
+	s := &sifStream{r: r}
+
+	var header sif.Header
+	if err := binary.Read(s, binary.LittleEndian, &header); err != nil {
+		return nil, nil, fmt.Errorf("while reading SIF global header: %s", err)
+	}
+	if magic := strings.TrimRight(string(header.Magic[:]), "\000"); magic != sif.HdrMagic {
+		return nil, nil, fmt.Errorf("SIF magic not found")
+	}
+	if header.Dtotal <= 0 {
+		return nil, nil, fmt.Errorf("SIF image has no descriptor")
+	}
+	if err := checkDescriptors(r, &header); err != nil {
+		return nil, nil, err
+	}
+
+	if err := s.skipTo(header.Descroff); err != nil {
+		return nil, nil, fmt.Errorf("while reaching SIF descriptors: %s", err)
+	}
+
+	// descriptors are read one at a time, the stream stops at the
+	// descriptors of a truncated image
+	var d sif.Descriptor
+	for i := int64(0); i < header.Dtotal; i++ {
+		if err := binary.Read(s, binary.LittleEndian, &d); err != nil {
+			return nil, nil, fmt.Errorf("while reading SIF descriptors: %s", err)
+		}
+		if !matchDescriptor(d, object) {
+			continue
+		}
+		if err := s.skipTo(d.Fileoff); err != nil {
+			return nil, nil, fmt.Errorf("while reaching data object %d: %s", d.ID, err)
+		}
+		return io.LimitReader(s, d.Filelen), &d, nil
+	}
+	return nil, nil, fmt.Errorf("no data object %q found", object)
+}
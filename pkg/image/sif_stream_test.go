@@ -0,0 +1,164 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/hpcng/sif/pkg/sif"
+)
+
+func TestNewSIFStreamReader(t *testing.T) {
+	fp, err := os.Open(testSquash)
+	if err != nil {
+		t.Fatalf("failed to open %s: %s", testSquash, err)
+	}
+	defer fp.Close()
+
+	squash, err := ioutil.ReadFile(testSquash)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", testSquash, err)
+	}
+	content := []byte("section content")
+
+	primPart := sif.DescriptorInput{
+		Datatype: sif.DataPartition,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Fname:    "primPart",
+		Fp:       fp,
+		Extra: *bytes.NewBuffer([]byte{
+			0x01, 0x00, 0x00, 0x00, // fstype
+			0x02, 0x00, 0x00, 0x00, // part type
+		}),
+	}
+	primPart.Extra.WriteString(sif.GetSIFArch(runtime.GOARCH))
+
+	section := sif.DescriptorInput{
+		Datatype: sif.DataGeneric,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Fname:    "section",
+		Fp:       bytes.NewReader(content),
+		Size:     int64(len(content)),
+	}
+
+	path := createSIF(t, []sif.DescriptorInput{primPart, section}, false)
+	defer os.Remove(path)
+
+	fimg, err := sif.LoadContainer(path, true)
+	if err != nil {
+		t.Fatalf("failed to load %s: %s", path, err)
+	}
+	sectionID := fimg.DescrArr[1].ID
+	fimg.UnloadContainer()
+
+	tests := []struct {
+		name     string
+		object   string
+		seekable bool
+		expected []byte
+		wantErr  bool
+	}{
+		{"RootFs", SIFStreamRootFs, false, squash, false},
+		{"RootFsSeekable", SIFStreamRootFs, true, squash, false},
+		{"Name", "section", false, content, false},
+		{"ID", strconv.Itoa(int(sectionID)), false, content, false},
+		{"Unknown", "unknown", false, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatalf("failed to open %s: %s", path, err)
+			}
+			defer f.Close()
+
+			// hide the file methods to read it as a pipe
+			var r io.Reader = struct{ io.Reader }{f}
+			if tt.seekable {
+				r = f
+			}
+
+			sr, d, err := NewSIFStreamReader(r, tt.object)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if d.Filelen != int64(len(tt.expected)) {
+				t.Errorf("got data object size %d, expected %d", d.Filelen, len(tt.expected))
+			}
+			b, err := ioutil.ReadAll(sr)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !bytes.Equal(b, tt.expected) {
+				t.Errorf("unexpected data object content")
+			}
+		})
+	}
+
+	if _, _, err := NewSIFStreamReader(bytes.NewReader(squash), SIFStreamRootFs); err == nil {
+		t.Errorf("unexpected success with a squashfs image")
+	}
+
+	// corrupted headers announcing more descriptors than the image
+	// holds are rejected before reading the descriptors
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", path, err)
+	}
+	var header sif.Header
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &header); err != nil {
+		t.Fatalf("failed to read SIF header: %s", err)
+	}
+	corrupt := func(h sif.Header) string {
+		var buf bytes.Buffer
+		if err := binary.Write(&buf, binary.LittleEndian, &h); err != nil {
+			t.Fatalf("failed to write SIF header: %s", err)
+		}
+		f, err := ioutil.TempFile("", "sif-stream-")
+		if err != nil {
+			t.Fatalf("failed to create temporary file: %s", err)
+		}
+		defer f.Close()
+		if _, err := f.Write(append(buf.Bytes(), b[buf.Len():]...)); err != nil {
+			t.Fatalf("failed to write %s: %s", f.Name(), err)
+		}
+		return f.Name()
+	}
+
+	h := header
+	h.Dtotal = 1 << 40
+	descrlen := h
+	descrlen.Descrlen = 1 << 62
+	descrlen.Dtotal = 1 << 40
+	for name, h := range map[string]sif.Header{"Dtotal": h, "Descrlen": descrlen} {
+		path := corrupt(h)
+		defer os.Remove(path)
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("failed to open %s: %s", path, err)
+		}
+		if _, _, err := NewSIFStreamReader(f, SIFStreamRootFs); err == nil {
+			t.Errorf("%s: unexpected success with a corrupted header", name)
+		}
+		f.Close()
+	}
+}
@@ -7,13 +7,19 @@
 package unpacker
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/hpcng/singularity/pkg/sylog"
 	"github.com/hpcng/singularity/pkg/util/namespaces"
@@ -71,6 +77,21 @@ func (s *Squashfs) HasUnsquashfs() bool {
 	return s.UnsquashfsPath != ""
 }
 
+// imageHasXattrs returns whether the squashfs filesystem extracted from
+// filename, or from reader passed on the standard input, stores extended
+// attributes.
+func imageHasXattrs(filename string, reader io.Reader, stdin bool) bool {
+	if stdin {
+		return hasXattrs(reader.(*os.File))
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	return hasXattrs(f)
+}
+
 func (s *Squashfs) extract(files []string, reader io.Reader, dest string) (err error) {
 	if !s.HasUnsquashfs() {
 		return fmt.Errorf("could not extract squashfs data, unsquashfs not found")
@@ -127,6 +148,16 @@ func (s *Squashfs) extract(files []string, reader io.Reader, dest string) (err e
 		opts = append(opts, "-no-xattrs")
 	}
 
+	// file capabilities and other extended attributes of the image
+	// can't be restored with these options
+	if len(opts) > 0 && imageHasXattrs(filename, reader, stdin) {
+		if ok {
+			sylog.Warningf("Only user extended attributes are restored as a non-root user, file capabilities and other extended attributes of the image are dropped")
+		} else {
+			sylog.Warningf("The filesystem of %s doesn't support extended attributes, file capabilities and extended attributes of the image are dropped", filepath.Dir(dest))
+		}
+	}
+
 	// non real root users could not create pseudo devices so we compare
 	// the host UID (to include fake root user) and apply a filter at extraction (#5690)
 	hostuid, err := namespaces.HostUID()
@@ -196,6 +227,30 @@ func (s *Squashfs) extract(files []string, reader io.Reader, dest string) (err e
 	return nil
 }
 
+const (
+	// squashfsMagic is the magic number of a squashfs superblock.
+	squashfsMagic = "hsqs"
+	// xattrTableOffset is the offset in the superblock of the extended
+	// attributes table start, which is set to squashfsInvalidBlock when
+	// no extended attributes are stored.
+	xattrTableOffset     = 56
+	squashfsInvalidBlock = ^uint64(0)
+)
+
+// hasXattrs returns whether the squashfs filesystem image stores
+// extended attributes, according to its superblock.
+func hasXattrs(image io.ReaderAt) bool {
+	b := make([]byte, xattrTableOffset+8)
+	if _, err := image.ReadAt(b, 0); err != nil {
+		sylog.Debugf("Could not read squashfs superblock: %s", err)
+		return false
+	}
+	if string(b[:4]) != squashfsMagic {
+		return false
+	}
+	return binary.LittleEndian.Uint64(b[xattrTableOffset:]) != squashfsInvalidBlock
+}
+
 // ExtractAll extracts a squashfs filesystem read from reader to a
 // destination directory.
 func (s *Squashfs) ExtractAll(reader io.Reader, dest string) error {
@@ -224,3 +279,86 @@ func TestUserXattr(path string) (ok bool, err error) {
 	}
 	return true, nil
 }
+
+// Entry describes a file entry of a squashfs filesystem as reported
+// by unsquashfs long listing.
+type Entry struct {
+	// Path is the absolute path of the entry inside the filesystem.
+	Path string
+	// Mode is the ls-like mode string of the entry (eg: -rwxr-xr-x).
+	Mode string
+	// UID and GID are the numeric owner and group of the entry.
+	UID int
+	GID int
+	// Size is the entry size for regular files and symlinks.
+	Size int64
+	// ModTime is the modification time, with a minute resolution.
+	ModTime time.Time
+	// Target is the symlink target if the entry is a symlink.
+	Target string
+}
+
+var listRegex = regexp.MustCompile(`^(\S{10}) (\d+)/(\d+)\s+(\d+|\d+,\s*\d+) (\d{4}-\d{2}-\d{2} \d{2}:\d{2}) squashfs-root(.*)$`)
+
+// List returns the entries of the squashfs filesystem image passed
+// in argument, the root directory entry is reported with path "/".
+func (s *Squashfs) List(filename string) ([]Entry, error) {
+	if !s.HasUnsquashfs() {
+		return nil, fmt.Errorf("could not list squashfs data, unsquashfs not found")
+	}
+
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(s.UnsquashfsPath, "-lln", filename)
+	cmd.Stderr = &stderr
+
+	sylog.Debugf("Calling %s %v", s.UnsquashfsPath, cmd.Args[1:])
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("list command failed: %s: %s", stderr.String(), err)
+	}
+
+	return parseList(bytes.NewReader(out))
+}
+
+// parseList parses unsquashfs -lln output.
+func parseList(r io.Reader) ([]Entry, error) {
+	var err error
+
+	entries := make([]Entry, 0)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		m := listRegex.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+
+		e := Entry{Mode: m[1]}
+		e.UID, _ = strconv.Atoi(m[2])
+		e.GID, _ = strconv.Atoi(m[3])
+		if !strings.Contains(m[4], ",") {
+			e.Size, _ = strconv.ParseInt(m[4], 10, 64)
+		}
+		e.ModTime, err = time.ParseInLocation("2006-01-02 15:04", m[5], time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing modification time %q: %s", m[5], err)
+		}
+
+		e.Path = m[6]
+		if e.Mode[0] == 'l' {
+			if i := strings.Index(e.Path, " -> "); i >= 0 {
+				e.Target = e.Path[i+4:]
+				e.Path = e.Path[:i]
+			}
+		}
+		if e.Path == "" {
+			e.Path = "/"
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, scanner.Err()
+}
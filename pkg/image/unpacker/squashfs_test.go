@@ -8,11 +8,16 @@ package unpacker
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"golang.org/x/sys/unix"
 )
 
 func createArchive(t *testing.T) *os.File {
@@ -126,3 +131,122 @@ func TestMain(m *testing.M) {
 	cmdFunc = unsquashfsCmd
 	os.Exit(m.Run())
 }
+
+func TestParseList(t *testing.T) {
+	const listing = `Parallel unsquashfs: Using 4 processors
+3 inodes (2 blocks) to write
+
+drwxr-xr-x 0/0                    61 2021-06-15 10:22 squashfs-root
+-rwsr-xr-x 0/0                  1024 2021-06-15 10:22 squashfs-root/bin/su
+lrwxrwxrwx 1000/1000               7 2021-06-15 10:23 squashfs-root/bin/sh -> busybox
+crw-rw-rw- 0/0               1,    3 2021-06-15 10:22 squashfs-root/dev/null
+-rw-r--r-- 0/0                     5 2021-06-15 10:22 squashfs-root/file with space
+`
+	entries, err := parseList(strings.NewReader(listing))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []Entry{
+		{Path: "/", Mode: "drwxr-xr-x", Size: 61},
+		{Path: "/bin/su", Mode: "-rwsr-xr-x", Size: 1024},
+		{Path: "/bin/sh", Mode: "lrwxrwxrwx", UID: 1000, GID: 1000, Size: 7, Target: "busybox"},
+		{Path: "/dev/null", Mode: "crw-rw-rw-"},
+		{Path: "/file with space", Mode: "-rw-r--r--", Size: 5},
+	}
+
+	if len(entries) != len(expected) {
+		t.Fatalf("unexpected number of entries: got %d instead of %d", len(entries), len(expected))
+	}
+	for i, e := range expected {
+		got := entries[i]
+		if got.ModTime.IsZero() {
+			t.Errorf("missing modification time for %s", e.Path)
+		}
+		got.ModTime = e.ModTime
+		if got != e {
+			t.Errorf("unexpected entry: got %+v instead of %+v", got, e)
+		}
+	}
+}
+
+func TestHasXattrs(t *testing.T) {
+	superblock := func(magic string, table uint64) *bytes.Reader {
+		b := make([]byte, 96)
+		copy(b, magic)
+		binary.LittleEndian.PutUint64(b[xattrTableOffset:], table)
+		return bytes.NewReader(b)
+	}
+
+	tests := []struct {
+		name  string
+		image *bytes.Reader
+		want  bool
+	}{
+		{"xattrs", superblock(squashfsMagic, 1234), true},
+		{"no xattrs", superblock(squashfsMagic, squashfsInvalidBlock), false},
+		{"not squashfs", superblock("sqsh", 1234), false},
+		{"truncated", bytes.NewReader([]byte(squashfsMagic)), false},
+	}
+	for _, tt := range tests {
+		if got := hasXattrs(tt.image); got != tt.want {
+			t.Errorf("%s: got %v instead of %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExtractXattrs(t *testing.T) {
+	s := NewSquashfs()
+	if !s.HasUnsquashfs() {
+		t.Skip("unsquashfs not found")
+	}
+	mk, err := exec.LookPath("mksquashfs")
+	if err != nil {
+		t.Skip("mksquashfs not found")
+	}
+
+	dir, err := ioutil.TempDir("", "unpacker-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(src, "file")
+	if err := ioutil.WriteFile(file, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Lsetxattr(file, "user.test", []byte("value"), 0); err != nil {
+		t.Skipf("extended attributes not supported: %s", err)
+	}
+
+	image := filepath.Join(dir, "image.sqfs")
+	if out, err := exec.Command(mk, src, image, "-noappend", "-no-progress").CombinedOutput(); err != nil {
+		t.Fatalf("failed to create image: %s: %s", out, err)
+	}
+	f, err := os.Open(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if !hasXattrs(f) {
+		t.Errorf("extended attributes not found in image")
+	}
+
+	dest := filepath.Join(dir, "dest")
+	if err := s.ExtractAll(f, dest); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	value := make([]byte, 16)
+	n, err := unix.Lgetxattr(filepath.Join(dest, "file"), "user.test", value)
+	if err != nil {
+		t.Fatalf("extended attribute not restored: %s", err)
+	}
+	if string(value[:n]) != "value" {
+		t.Errorf("got extended attribute %q instead of %q", value[:n], "value")
+	}
+}
@@ -19,14 +19,15 @@ type AppAttributes struct {
 
 // Attributes describes metadata attributes of Singularity containers.
 type Attributes struct {
-	Apps        map[string]*AppAttributes `json:"apps,omitempty"`
-	Environment map[string]string         `json:"environment,omitempty"`
-	Labels      map[string]string         `json:"labels,omitempty"`
-	Runscript   string                    `json:"runscript,omitempty"`
-	Test        string                    `json:"test,omitempty"`
-	Helpfile    string                    `json:"helpfile,omitempty"`
-	Deffile     string                    `json:"deffile,omitempty"`
-	Startscript string                    `json:"startscript,omitempty"`
+	Apps           map[string]*AppAttributes `json:"apps,omitempty"`
+	Environment    map[string]string         `json:"environment,omitempty"`
+	Labels         map[string]string         `json:"labels,omitempty"`
+	Runscript      string                    `json:"runscript,omitempty"`
+	Test           string                    `json:"test,omitempty"`
+	Helpfile       string                    `json:"helpfile,omitempty"`
+	Deffile        string                    `json:"deffile,omitempty"`
+	Startscript    string                    `json:"startscript,omitempty"`
+	Shutdownscript string                    `json:"shutdownscript,omitempty"`
 }
 
 // Data holds the container metadata attributes.
@@ -0,0 +1,65 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package inspect
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// RuntimeInfoPath is the path of the runtime information document in the
+// container, a read-only file describing how the container was launched.
+const RuntimeInfoPath = "/.singularity.d/runtime.json"
+
+// RuntimeBind describes a bind mount requested by the user.
+type RuntimeBind struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Options     string `json:"options,omitempty"`
+}
+
+// RuntimeInfo describes how a container was launched, for processes
+// within the container to record the provenance of their results.
+type RuntimeInfo struct {
+	// Version is the version of Singularity which launched the container.
+	Version string `json:"version"`
+	// Started is the time the container was launched.
+	Started time.Time `json:"started"`
+	// Image is the path of the image on the host.
+	Image string `json:"image"`
+	// ImageDigest is the digest of the image file if enabled by the
+	// administrator, directory images have no digest.
+	ImageDigest string `json:"imageDigest,omitempty"`
+	// Instance is the instance name, if any.
+	Instance string        `json:"instance,omitempty"`
+	Binds    []RuntimeBind `json:"binds"`
+	Overlays []string      `json:"overlays"`
+	// Writable is set when the image is mounted read-write.
+	Writable      bool     `json:"writable"`
+	WritableTmpfs bool     `json:"writableTmpfs"`
+	Contain       bool     `json:"contain"`
+	Fakeroot      bool     `json:"fakeroot"`
+	Namespaces    []string `json:"namespaces"`
+	// Flags are the names of the command line flags, their values are
+	// not recorded as they could hold sensitive data.
+	Flags []string `json:"flags"`
+}
+
+// ReadRuntimeInfo returns the runtime information of the container the
+// calling process runs in.
+func ReadRuntimeInfo() (*RuntimeInfo, error) {
+	data, err := ioutil.ReadFile(RuntimeInfoPath)
+	if err != nil {
+		return nil, fmt.Errorf("while reading runtime information: %s", err)
+	}
+	info := new(RuntimeInfo)
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil, fmt.Errorf("while decoding runtime information: %s", err)
+	}
+	return info, nil
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package ociruntime
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// AttachProtocolVersion is the version of the framed attach protocol
+// implemented by this package. The version supported by a container
+// runtime is advertised in the AttachProtocol field of the container
+// state, clients must use the raw byte stream protocol when it's 0.
+// Version 2 adds the ExitFrame, version 3 adds the ProbeFrame.
+const AttachProtocolVersion = 3
+
+// AttachMagic is sent by the attach clients speaking the framed protocol
+// right after connecting (and after the attach token if any), it's
+// followed by a HelloFrame holding the client protocol version. The
+// runtime answers with a HelloFrame holding the negotiated version, or
+// with an ErrorFrame before closing the connection. Clients not sending
+// the magic use the raw byte stream protocol. Probe clients send a
+// ProbeFrame instead of the HelloFrame.
+const AttachMagic = "SINGULARITY-ATTACH"
+
+// maxFrameSize is the maximum frame payload size accepted by ReadFrame.
+const maxFrameSize = 1 << 20
+
+// FrameType is the type of an attach protocol frame.
+type FrameType uint8
+
+const (
+	// HelloFrame holds the protocol version as a single byte.
+	HelloFrame FrameType = iota + 1
+	// StdinFrame holds data sent by client to the container process
+	// standard input.
+	StdinFrame
+	// StdoutFrame holds data written by the container process on its
+	// standard output, or on the terminal when there is one.
+	StdoutFrame
+	// StderrFrame holds data written by the container process on its
+	// standard error.
+	StderrFrame
+	// ControlFrame holds a JSON encoded Control sent by client,
+	// only the ConsoleSize field is handled over the attach socket.
+	ControlFrame
+	// ErrorFrame holds an error message sent by the runtime.
+	ErrorFrame
+	// ExitFrame holds a JSON encoded ExitStatus sent by the runtime
+	// once the container process exited and its output was sent,
+	// before closing the connection. Sent since protocol version 2.
+	ExitFrame
+	// ProbeFrame holds a JSON encoded Probe sent by a probe client
+	// right after the magic, the runtime answers with a ProbeFrame
+	// holding the JSON encoded ProbeResult before closing the
+	// connection. Probe clients receive no container output. Handled
+	// since protocol version 3.
+	ProbeFrame
+)
+
+// ExitStatus describes how the container process terminated.
+type ExitStatus struct {
+	// ExitCode is the exit code of the container process, or 128 plus
+	// the signal number if it was terminated by a signal.
+	ExitCode int `json:"exitCode"`
+	// Signal is the signal which terminated the container process,
+	// zero if it exited normally.
+	Signal int `json:"signal,omitempty"`
+	// Desc is a short description of the termination.
+	Desc string `json:"desc,omitempty"`
+}
+
+// Framer writes attach protocol frames to an underlying writer,
+// concurrent frame writes are serialized.
+type Framer struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+// NewFramer returns a Framer writing frames to w.
+func NewFramer(w io.Writer) *Framer {
+	return &Framer{w: w}
+}
+
+// WriteFrame writes a frame of type t holding payload.
+func (f *Framer) WriteFrame(t FrameType, payload []byte) error {
+	if len(payload) > maxFrameSize {
+		return fmt.Errorf("frame payload of %d bytes exceeds %d bytes", len(payload), maxFrameSize)
+	}
+	// header and payload are written at once to not
+	// split the frame with concurrent writers
+	buf := make([]byte, 5+len(payload))
+	buf[0] = byte(t)
+	binary.BigEndian.PutUint32(buf[1:5], uint32(len(payload)))
+	copy(buf[5:], payload)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	_, err := f.w.Write(buf)
+	return err
+}
+
+// Writer returns a writer wrapping the data written to it
+// in frames of type t.
+func (f *Framer) Writer(t FrameType) io.Writer {
+	return &frameWriter{framer: f, frameType: t}
+}
+
+type frameWriter struct {
+	framer    *Framer
+	frameType FrameType
+}
+
+func (w *frameWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxFrameSize {
+			chunk = chunk[:maxFrameSize]
+		}
+		if err := w.framer.WriteFrame(w.frameType, chunk); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// ReadFrame reads a frame from r and returns its type and payload.
+func ReadFrame(r io.Reader) (FrameType, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:5])
+	if size > maxFrameSize {
+		return 0, nil, fmt.Errorf("frame payload of %d bytes exceeds %d bytes", size, maxFrameSize)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return FrameType(header[0]), payload, nil
+}
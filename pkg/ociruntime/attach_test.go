@@ -0,0 +1,86 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package ociruntime
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+)
+
+func TestFrames(t *testing.T) {
+	var buf bytes.Buffer
+
+	framer := NewFramer(&buf)
+	if err := framer.WriteFrame(HelloFrame, []byte{AttachProtocolVersion}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := framer.Writer(StdoutFrame).Write([]byte("output")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := framer.WriteFrame(StderrFrame, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := framer.WriteFrame(StdinFrame, make([]byte, maxFrameSize+1)); err == nil {
+		t.Errorf("unexpected success with an oversized frame")
+	}
+
+	expected := []struct {
+		frameType FrameType
+		payload   string
+	}{
+		{HelloFrame, string([]byte{AttachProtocolVersion})},
+		{StdoutFrame, "output"},
+		{StderrFrame, ""},
+	}
+	for _, e := range expected {
+		ft, payload, err := ReadFrame(&buf)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if ft != e.frameType || string(payload) != e.payload {
+			t.Errorf("got frame %d %q instead of %d %q", ft, payload, e.frameType, e.payload)
+		}
+	}
+	if _, _, err := ReadFrame(&buf); err != io.EOF {
+		t.Errorf("got %v instead of EOF", err)
+	}
+
+	// truncated frame
+	buf.Write([]byte{byte(StdoutFrame), 0, 0, 0, 4, 'a'})
+	if _, _, err := ReadFrame(&buf); err != io.ErrUnexpectedEOF {
+		t.Errorf("got %v instead of unexpected EOF", err)
+	}
+}
+
+func TestExitFrame(t *testing.T) {
+	var buf bytes.Buffer
+
+	sent := ExitStatus{ExitCode: 128 + 9, Signal: 9, Desc: "interrupted by signal killed"}
+	data, err := json.Marshal(&sent)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := NewFramer(&buf).WriteFrame(ExitFrame, data); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	ft, payload, err := ReadFrame(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ft != ExitFrame {
+		t.Fatalf("got frame %d instead of %d", ft, ExitFrame)
+	}
+	var received ExitStatus
+	if err := json.Unmarshal(payload, &received); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if received != sent {
+		t.Errorf("got exit status %+v instead of %+v", received, sent)
+	}
+}
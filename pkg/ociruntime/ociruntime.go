@@ -32,6 +32,9 @@ type State struct {
 	ExitDesc      string `json:"exitDesc,omitempty"`
 	AttachSocket  string `json:"attachSocket,omitempty"`
 	ControlSocket string `json:"controlSocket,omitempty"`
+	// AttachProtocol is the framed attach protocol version
+	// supported by the runtime, 0 for the raw byte stream.
+	AttachProtocol int `json:"attachProtocol,omitempty"`
 }
 
 // Control is used to pass information for container control
@@ -43,3 +46,22 @@ type Control struct {
 	Pause          bool       `json:"pause,omitempty"`
 	Resume         bool       `json:"resume,omitempty"`
 }
+
+// Probe is a short command run in a running container to check its
+// health, it's sent on the attach socket in a ProbeFrame and executed as
+// an exec of the container process.
+type Probe struct {
+	Args []string `json:"args"`
+	// Timeout is the time in seconds after which the command is killed.
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// ProbeResult is the result of a probe command, ExitCode is -1 if the
+// command couldn't be executed or was killed, with Error describing why.
+type ProbeResult struct {
+	ExitCode int `json:"exitCode"`
+	// Output holds the beginning of the combined standard and error
+	// outputs of the command.
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
@@ -8,6 +8,7 @@ package cli
 import (
 	"github.com/hpcng/singularity/pkg/cmdline"
 	"github.com/hpcng/singularity/pkg/runtime/engine/config"
+	"github.com/hpcng/singularity/pkg/transport"
 )
 
 // Command callback allows to add/modify commands and/or flags.
@@ -22,3 +23,9 @@ type Command func(*cmdline.CommandManager)
 // allows plugins to modify/alter runtime engine configuration. This
 // is the place to inject custom binds.
 type SingularityEngineConfig func(*config.Common)
+
+// Transport callback allows to add image transports.
+// This callback is called in cmd/internal/cli/transport.go and
+// returns the transports to register by URI scheme, allowing plugins
+// to add image sources to the pull, push and action commands.
+type Transport func() map[string]transport.Transport
@@ -14,6 +14,8 @@ import (
 // Common provides the basis for all engine configs. Anything that can not be
 // properly described through the OCI config can be stored as a generic JSON []byte.
 type Common struct {
+	// Version is the version of the JSON configuration format, see CurrentVersion.
+	Version     uint32 `json:"version,omitempty"`
 	EngineName  string `json:"engineName"`
 	ContainerID string `json:"containerID"`
 	// EngineConfig is the raw JSON representation of the Engine's underlying config.
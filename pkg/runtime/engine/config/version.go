@@ -0,0 +1,194 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// CurrentVersion is the version of the JSON engine configuration
+	// format produced by the CLI and expected by the engines. Version 2
+	// adds settings unknown to older engines, see fieldsV2.
+	CurrentVersion uint32 = 2
+	// MinVersion is the oldest JSON engine configuration format version
+	// which can be converted to and from the current version. Version 0
+	// corresponds to configurations without version field.
+	MinVersion uint32 = 0
+)
+
+// Converter converts a raw JSON engine configuration from one
+// version to the adjacent version.
+type Converter func(map[string]json.RawMessage) error
+
+var (
+	// upgraders contains converters from the version
+	// used as key to the next version.
+	upgraders = map[uint32]Converter{
+		0: func(map[string]json.RawMessage) error { return nil },
+		1: func(map[string]json.RawMessage) error { return nil },
+	}
+	// downgraders contains converters from the version
+	// used as key to the previous version.
+	downgraders = map[uint32]Converter{
+		1: func(map[string]json.RawMessage) error { return nil },
+		2: downgradeV2,
+	}
+)
+
+// fieldsV2 are the paths in the engine configuration of the settings
+// added by version 2 for the singularity engine (under jsonConfig) and
+// the OCI engine. Older engines silently ignore them, which changes the
+// container execution (e.g. a dry run really runs the container), so a
+// configuration setting them can't be converted to version 1. Only
+// informational fields like jsonConfig.cliFlags are left out.
+var fieldsV2 = []string{
+	"jsonConfig.landlockProfile",
+	"jsonConfig.hostLibraryPath",
+	"jsonConfig.bindEnv",
+	"jsonConfig.timezone",
+	"jsonConfig.homeTmpfs",
+	"jsonConfig.systemInstance",
+	"jsonConfig.allowUsers",
+	"jsonConfig.allowGroups",
+	"jsonConfig.securityAudit",
+	"jsonConfig.pidFile",
+	"jsonConfig.infoFile",
+	"jsonConfig.historyDir",
+	"jsonConfig.rusage",
+	"jsonConfig.rusageFile",
+	"jsonConfig.ulimits",
+	"jsonConfig.envDeny",
+	"jsonConfig.envAllow",
+	"jsonConfig.hookMounts",
+	"jsonConfig.dryRun",
+	"parallelHooks",
+	"stdinMode",
+	"stdinPath",
+	"attachMode",
+	"attachToken",
+	"consoleBuffer",
+	"envDeny",
+	"envAllow",
+}
+
+// downgradeV2 converts a version 2 configuration to version 1, it fails
+// if a setting unknown to version 1 engines is set.
+func downgradeV2(cfg map[string]json.RawMessage) error {
+	for _, field := range fieldsV2 {
+		value := lookupField(cfg["engineConfig"], strings.Split(field, "."))
+		if !isEmptyValue(value) {
+			return fmt.Errorf("engine configuration field %s is not supported by version 1 engines", field)
+		}
+	}
+	return nil
+}
+
+// lookupField returns the raw JSON value at path in the JSON object data,
+// or nil if there is none.
+func lookupField(data json.RawMessage, path []string) json.RawMessage {
+	for _, key := range path {
+		obj := make(map[string]json.RawMessage)
+		if err := json.Unmarshal(data, &obj); err != nil {
+			// not an object, the engine has no such field
+			return nil
+		}
+		data = obj[key]
+	}
+	return data
+}
+
+// isEmptyValue returns if the raw JSON value is absent or holds a zero
+// value.
+func isEmptyValue(value json.RawMessage) bool {
+	switch string(bytes.TrimSpace(value)) {
+	case "", "null", `""`, "[]", "{}", "0", "false":
+		return true
+	}
+	return false
+}
+
+// ErrIncompatibleVersion is returned when a configuration version
+// can't be converted to the requested version.
+type ErrIncompatibleVersion struct {
+	Version uint32
+	Target  uint32
+}
+
+func (e *ErrIncompatibleVersion) Error() string {
+	return fmt.Sprintf(
+		"engine configuration version %d can't be converted to version %d (supported versions: %d to %d), "+
+			"singularity and starter binaries are probably from different releases, please check your installation",
+		e.Version, e.Target, MinVersion, CurrentVersion,
+	)
+}
+
+// GetVersion returns the version of the raw JSON engine configuration.
+func GetVersion(data []byte) (uint32, error) {
+	cfg := struct {
+		Version uint32 `json:"version"`
+	}{}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return 0, fmt.Errorf("while reading engine configuration version: %s", err)
+	}
+	return cfg.Version, nil
+}
+
+// CheckVersion returns an error if the version can't be converted
+// to or from the current version.
+func CheckVersion(version uint32) error {
+	if version < MinVersion || version > CurrentVersion {
+		return &ErrIncompatibleVersion{Version: version, Target: CurrentVersion}
+	}
+	return nil
+}
+
+// Convert converts the raw JSON engine configuration to the target
+// version by applying converters step by step.
+func Convert(data []byte, target uint32) ([]byte, error) {
+	version, err := GetVersion(data)
+	if err != nil {
+		return nil, err
+	}
+	if version == target {
+		return data, nil
+	}
+	if CheckVersion(version) != nil || CheckVersion(target) != nil {
+		return nil, &ErrIncompatibleVersion{Version: version, Target: target}
+	}
+
+	cfg := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("while parsing engine configuration: %s", err)
+	}
+
+	for version != target {
+		next := version + 1
+		convert := upgraders[version]
+		if target < version {
+			next = version - 1
+			convert = downgraders[version]
+		}
+		if convert == nil {
+			return nil, &ErrIncompatibleVersion{Version: version, Target: target}
+		}
+		if err := convert(cfg); err != nil {
+			return nil, fmt.Errorf("while converting engine configuration from version %d to %d: %s", version, next, err)
+		}
+		version = next
+	}
+
+	// configuration without version field are version 0
+	delete(cfg, "version")
+	if version > 0 {
+		cfg["version"] = json.RawMessage(fmt.Sprint(version))
+	}
+
+	return json.Marshal(cfg)
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package config
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	current, err := json.Marshal(&Common{Version: CurrentVersion, EngineName: "test"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		target  uint32
+		version uint32
+		wantErr bool
+	}{
+		{
+			name:    "LegacyToCurrent",
+			data:    `{"engineName":"test"}`,
+			target:  CurrentVersion,
+			version: CurrentVersion,
+		},
+		{
+			name:    "CurrentToLegacy",
+			data:    string(current),
+			target:  0,
+			version: 0,
+		},
+		{
+			name:    "SameVersion",
+			data:    string(current),
+			target:  CurrentVersion,
+			version: CurrentVersion,
+		},
+		{
+			name:    "NewerVersion",
+			data:    `{"version":4294967295,"engineName":"test"}`,
+			target:  CurrentVersion,
+			wantErr: true,
+		},
+		{
+			name:    "NewerTarget",
+			data:    string(current),
+			target:  CurrentVersion + 1,
+			wantErr: true,
+		},
+		{
+			name:    "CurrentToV1",
+			data:    `{"version":2,"engineName":"test","engineConfig":{"jsonConfig":{"image":"test.sif"}}}`,
+			target:  1,
+			version: 1,
+		},
+		{
+			name:    "CurrentToV1EmptySecurity",
+			data:    `{"version":2,"engineName":"test","engineConfig":{"jsonConfig":{"ulimits":[]},"attachToken":""}}`,
+			target:  1,
+			version: 1,
+		},
+		{
+			name:    "CurrentToV1Landlock",
+			data:    `{"version":2,"engineName":"test","engineConfig":{"jsonConfig":{"landlockProfile":"e30="}}}`,
+			target:  1,
+			wantErr: true,
+		},
+		{
+			name:    "CurrentToLegacyEnvDeny",
+			data:    `{"version":2,"engineName":"test","engineConfig":{"jsonConfig":{"envDeny":["LD_*"]}}}`,
+			target:  0,
+			wantErr: true,
+		},
+		{
+			name:    "CurrentToV1AttachToken",
+			data:    `{"version":2,"engineName":"test","engineConfig":{"attachToken":"secret"}}`,
+			target:  1,
+			wantErr: true,
+		},
+		{
+			name:    "CurrentToV1DryRun",
+			data:    `{"version":2,"engineName":"test","engineConfig":{"jsonConfig":{"dryRun":true}}}`,
+			target:  1,
+			wantErr: true,
+		},
+		{
+			name:    "CurrentToV1SystemInstance",
+			data:    `{"version":2,"engineName":"test","engineConfig":{"jsonConfig":{"systemInstance":true,"allowUsers":["bob"]}}}`,
+			target:  1,
+			wantErr: true,
+		},
+		{
+			name:    "CurrentToV1CLIFlags",
+			data:    `{"version":2,"engineName":"test","engineConfig":{"jsonConfig":{"cliFlags":["--contain"]}}}`,
+			target:  1,
+			version: 1,
+		},
+		{
+			name:    "BadJSON",
+			data:    `{"version":`,
+			target:  CurrentVersion,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Convert([]byte(tt.data), tt.target)
+			if err != nil && !tt.wantErr {
+				t.Fatalf("unexpected error: %s", err)
+			} else if err == nil && tt.wantErr {
+				t.Fatalf("unexpected success")
+			} else if err != nil {
+				return
+			}
+
+			version, err := GetVersion(data)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if version != tt.version {
+				t.Errorf("unexpected version %d instead of %d", version, tt.version)
+			}
+
+			c := new(Common)
+			if err := json.Unmarshal(data, c); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if c.EngineName != "test" {
+				t.Errorf("unexpected engine name %q", c.EngineName)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var (
+	// bindVarRegexp matches the ${NAME} variables of bind paths.
+	bindVarRegexp = regexp.MustCompile(`\$\{([^}]*)\}`)
+	// bindVarNameRegexp matches valid variable names.
+	bindVarNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// safeBindValueRegexp matches the values allowed for environment
+	// variables expanded in bind paths set by the administrator.
+	safeBindValueRegexp = regexp.MustCompile(`^[A-Za-z0-9_@+=-][A-Za-z0-9._@+=-]*$`)
+)
+
+// BindVars holds the values of the variables expanded in bind paths.
+type BindVars struct {
+	// Identity holds the variables describing the user (USER, UID,
+	// GID and HOME) determined by the runtime, they take precedence
+	// over environment variables.
+	Identity map[string]string
+	// Env holds the host environment variables referenced by
+	// bind paths.
+	Env map[string]string
+}
+
+// BindVarNames returns the names of the ${NAME} variables referenced
+// by path.
+func BindVarNames(path string) []string {
+	var names []string
+
+	for _, m := range bindVarRegexp.FindAllStringSubmatch(path, -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
+
+// Expand returns path with its ${NAME} variables replaced by their value.
+// An error is returned for undefined variables. With strict set, values
+// of environment variables must be a single path component to not let
+// users redirect bind paths set by the administrator elsewhere.
+func (v BindVars) Expand(path string, strict bool) (string, error) {
+	var err error
+
+	expanded := bindVarRegexp.ReplaceAllStringFunc(path, func(m string) string {
+		name := m[2 : len(m)-1]
+		if err != nil {
+			return m
+		}
+		if !bindVarNameRegexp.MatchString(name) {
+			err = fmt.Errorf("invalid variable name %q", name)
+			return m
+		}
+		if value, ok := v.Identity[name]; ok {
+			return value
+		}
+		value, ok := v.Env[name]
+		if !ok || value == "" {
+			err = fmt.Errorf("variable %s is not set", name)
+			return m
+		}
+		if strict && !safeBindValueRegexp.MatchString(value) {
+			err = fmt.Errorf("value %q of variable %s is not allowed", value, name)
+			return m
+		}
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+	return expanded, nil
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBindVarNames(t *testing.T) {
+	names := BindVarNames("/scratch/${USER}/${SLURM_JOB_ID}:/scratch")
+	if want := []string{"USER", "SLURM_JOB_ID"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v instead of %v", names, want)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	vars := BindVars{
+		Identity: map[string]string{
+			"USER": "alice",
+			"HOME": "/home/alice",
+		},
+		Env: map[string]string{
+			"USER":         "bob",
+			"SLURM_JOB_ID": "1234",
+			"SCRATCH":      "/lustre/alice",
+			"PARENT":       "..",
+		},
+	}
+
+	tests := []struct {
+		name        string
+		path        string
+		strict      bool
+		expected    string
+		expectError bool
+	}{
+		{
+			name:     "NoVariable",
+			path:     "/opt:/opt",
+			strict:   true,
+			expected: "/opt:/opt",
+		},
+		{
+			name:     "Identity",
+			path:     "/scratch/${USER}:${HOME}/scratch",
+			strict:   true,
+			expected: "/scratch/alice:/home/alice/scratch",
+		},
+		{
+			name:     "Job",
+			path:     "/local/${SLURM_JOB_ID}:/tmp",
+			strict:   true,
+			expected: "/local/1234:/tmp",
+		},
+		{
+			name:        "Unset",
+			path:        "/local/${PBS_JOBID}",
+			strict:      true,
+			expectError: true,
+		},
+		{
+			name:        "StrictPath",
+			path:        "${SCRATCH}:/scratch",
+			strict:      true,
+			expectError: true,
+		},
+		{
+			name:        "StrictParent",
+			path:        "/local/${PARENT}/etc",
+			strict:      true,
+			expectError: true,
+		},
+		{
+			name:     "UserPath",
+			path:     "${SCRATCH}",
+			expected: "/lustre/alice",
+		},
+		{
+			name:        "InvalidName",
+			path:        "/local/${SLURM-JOB}",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, err := vars.Expand(tt.path, tt.strict)
+			if err != nil && !tt.expectError {
+				t.Fatalf("unexpected error: %s", err)
+			} else if err == nil && tt.expectError {
+				t.Fatalf("unexpected success: %s", path)
+			}
+			if path != tt.expected {
+				t.Errorf("got %q instead of %q", path, tt.expected)
+			}
+		})
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/hpcng/singularity/internal/pkg/runtime/engine/config/oci"
 	"github.com/hpcng/singularity/pkg/image"
 	"github.com/hpcng/singularity/pkg/util/singularityconf"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
 )
 
 // Name is the name of the runtime.
@@ -100,17 +101,42 @@ func (b *BindPath) Readonly() bool {
 	return b.Options != nil && b.Options["ro"] != nil
 }
 
+const (
+	// MissingError fails the container setup when the bind
+	// destination doesn't exist in the container.
+	MissingError = "error"
+	// MissingSkip skips the bind with a warning when the bind
+	// destination doesn't exist in the container.
+	MissingSkip = "skip"
+	// MissingCreate creates the bind destination when it doesn't
+	// exist in a writable container image.
+	MissingCreate = "create"
+)
+
+// Missing returns the value of option missing, the policy applied
+// when the bind destination doesn't exist in the container, or an
+// empty string if the option wasn't set.
+func (b *BindPath) Missing() string {
+	if b.Options != nil && b.Options["missing"] != nil {
+		return b.Options["missing"].Value
+	}
+	return ""
+}
+
 // JSONConfig stores engine specific confguration that is allowed to be set by the user.
 type JSONConfig struct {
 	ScratchDir        []string          `json:"scratchdir,omitempty"`
 	OverlayImage      []string          `json:"overlayImage,omitempty"`
 	NetworkArgs       []string          `json:"networkArgs,omitempty"`
 	Security          []string          `json:"security,omitempty"`
+	LandlockProfile   []byte            `json:"landlockProfile,omitempty"`
 	FilesPath         []string          `json:"filesPath,omitempty"`
 	LibrariesPath     []string          `json:"librariesPath,omitempty"`
+	HostLibraryPath   []string          `json:"hostLibraryPath,omitempty"`
 	FuseMount         []FuseMount       `json:"fuseMount,omitempty"`
 	ImageList         []image.Image     `json:"imageList,omitempty"`
 	BindPath          []BindPath        `json:"bindpath,omitempty"`
+	BindEnv           map[string]string `json:"bindEnv,omitempty"`
 	SingularityEnv    map[string]string `json:"singularityEnv,omitempty"`
 	UnixSocketPair    [2]int            `json:"unixSocketPair,omitempty"`
 	OpenFd            []int             `json:"openFd,omitempty"`
@@ -129,6 +155,7 @@ type JSONConfig struct {
 	Hostname          string            `json:"hostname,omitempty"`
 	Network           string            `json:"network,omitempty"`
 	DNS               string            `json:"dns,omitempty"`
+	Timezone          string            `json:"timezone,omitempty"`
 	Cwd               string            `json:"cwd,omitempty"`
 	SessionLayer      string            `json:"sessionLayer,omitempty"`
 	ConfigurationFile string            `json:"configurationFile,omitempty"`
@@ -140,9 +167,13 @@ type JSONConfig struct {
 	Nv                bool              `json:"nv,omitempty"`
 	Rocm              bool              `json:"rocm,omitempty"`
 	CustomHome        bool              `json:"customHome,omitempty"`
+	HomeTmpfs         bool              `json:"homeTmpfs,omitempty"`
 	Instance          bool              `json:"instance,omitempty"`
 	InstanceJoin      bool              `json:"instanceJoin,omitempty"`
 	BootInstance      bool              `json:"bootInstance,omitempty"`
+	SystemInstance    bool              `json:"systemInstance,omitempty"`
+	AllowUsers        []string          `json:"allowUsers,omitempty"`
+	AllowGroups       []string          `json:"allowGroups,omitempty"`
 	RunPrivileged     bool              `json:"runPrivileged,omitempty"`
 	AllowSUID         bool              `json:"allowSUID,omitempty"`
 	KeepPrivs         bool              `json:"keepPrivs,omitempty"`
@@ -159,8 +190,20 @@ type JSONConfig struct {
 	Fakeroot          bool              `json:"fakeroot,omitempty"`
 	SignalPropagation bool              `json:"signalPropagation,omitempty"`
 	RestoreUmask      bool              `json:"restoreUmask,omitempty"`
+	SecurityAudit     bool              `json:"securityAudit,omitempty"`
+	PidFile           string            `json:"pidFile,omitempty"`
+	InfoFile          string            `json:"infoFile,omitempty"`
+	HistoryDir        string            `json:"historyDir,omitempty"`
+	Rusage            bool              `json:"rusage,omitempty"`
+	RusageFile        string            `json:"rusageFile,omitempty"`
+	Ulimits           []string          `json:"ulimits,omitempty"`
+	EnvDeny           []string          `json:"envDeny,omitempty"`
+	EnvAllow          []string          `json:"envAllow,omitempty"`
+	HookMounts        []specs.Mount     `json:"hookMounts,omitempty"`
+	CLIFlags          []string          `json:"cliFlags,omitempty"`
 	DeleteTempDir     string            `json:"deleteTempDir,omitempty"`
 	Umask             int               `json:"umask,omitempty"`
+	DryRun            bool              `json:"dryRun,omitempty"`
 }
 
 // SetImage sets the container image path to be used by EngineConfig.JSON.
@@ -293,6 +336,18 @@ func (e *EngineConfig) GetCustomHome() bool {
 	return e.JSON.CustomHome
 }
 
+// SetHomeTmpfs sets if the container home directory is a temporary
+// directory of the session instead of a host directory.
+func (e *EngineConfig) SetHomeTmpfs(tmpfs bool) {
+	e.JSON.HomeTmpfs = tmpfs
+}
+
+// GetHomeTmpfs returns if the container home directory is a temporary
+// directory of the session.
+func (e *EngineConfig) GetHomeTmpfs() bool {
+	return e.JSON.HomeTmpfs
+}
+
 // ParseBindPath parses a string and returns all encountered
 // bind paths as array.
 func ParseBindPath(paths []string) ([]BindPath, error) {
@@ -303,6 +358,7 @@ func ParseBindPath(paths []string) ([]BindPath, error) {
 		"rw":        true,
 		"image-src": false,
 		"id":        false,
+		"missing":   false,
 	}
 
 	// there is a better regular expression to handle
@@ -482,6 +538,12 @@ func newBindPath(bind string, validOptions map[string]bool) (BindPath, error) {
 				return bp, fmt.Errorf("%s is not a valid bind option", value)
 			}
 		}
+
+		switch bp.Missing() {
+		case "", MissingError, MissingSkip, MissingCreate:
+		default:
+			return bp, fmt.Errorf("%s is not a valid missing bind option value, supported values are %s, %s and %s", bp.Missing(), MissingError, MissingSkip, MissingCreate)
+		}
 	}
 
 	return bp, nil
@@ -497,6 +559,18 @@ func (e *EngineConfig) GetBindPath() []BindPath {
 	return e.JSON.BindPath
 }
 
+// SetBindEnv sets the host environment variables referenced
+// by bind paths.
+func (e *EngineConfig) SetBindEnv(env map[string]string) {
+	e.JSON.BindEnv = env
+}
+
+// GetBindEnv retrieves the host environment variables referenced
+// by bind paths.
+func (e *EngineConfig) GetBindEnv() map[string]string {
+	return e.JSON.BindEnv
+}
+
 // SetCommand sets action command to execute.
 func (e *EngineConfig) SetCommand(command string) {
 	e.JSON.Command = command
@@ -557,6 +631,37 @@ func (e *EngineConfig) GetBootInstance() bool {
 	return e.JSON.BootInstance
 }
 
+// SetSystemInstance sets if the instance is a system instance
+// visible to other users.
+func (e *EngineConfig) SetSystemInstance(system bool) {
+	e.JSON.SystemInstance = system
+}
+
+// GetSystemInstance returns if the instance is a system instance.
+func (e *EngineConfig) GetSystemInstance() bool {
+	return e.JSON.SystemInstance
+}
+
+// SetAllowUsers sets the users allowed to join the system instance.
+func (e *EngineConfig) SetAllowUsers(users []string) {
+	e.JSON.AllowUsers = users
+}
+
+// GetAllowUsers returns the users allowed to join the system instance.
+func (e *EngineConfig) GetAllowUsers() []string {
+	return e.JSON.AllowUsers
+}
+
+// SetAllowGroups sets the groups allowed to join the system instance.
+func (e *EngineConfig) SetAllowGroups(groups []string) {
+	e.JSON.AllowGroups = groups
+}
+
+// GetAllowGroups returns the groups allowed to join the system instance.
+func (e *EngineConfig) GetAllowGroups() []string {
+	return e.JSON.AllowGroups
+}
+
 // SetAddCaps sets bounding/effective/permitted/inheritable/ambient capabilities to add.
 func (e *EngineConfig) SetAddCaps(caps string) {
 	e.JSON.AddCaps = caps
@@ -737,6 +842,17 @@ func (e *EngineConfig) GetDNS() string {
 	return e.JSON.DNS
 }
 
+// SetTimezone sets the container time zone, either host to use the
+// host time zone or a zone name of the host time zone database.
+func (e *EngineConfig) SetTimezone(tz string) {
+	e.JSON.Timezone = tz
+}
+
+// GetTimezone returns the container time zone.
+func (e *EngineConfig) GetTimezone() string {
+	return e.JSON.Timezone
+}
+
 // SetImageList sets image list containing opened images.
 func (e *EngineConfig) SetImageList(list []image.Image) {
 	e.JSON.ImageList = list
@@ -793,6 +909,18 @@ func (e *EngineConfig) GetSecurity() []string {
 	return e.JSON.Security
 }
 
+// SetLandlockProfile sets the JSON Landlock profile applied to the
+// container process.
+func (e *EngineConfig) SetLandlockProfile(profile []byte) {
+	e.JSON.LandlockProfile = profile
+}
+
+// GetLandlockProfile returns the JSON Landlock profile applied to the
+// container process.
+func (e *EngineConfig) GetLandlockProfile() []byte {
+	return e.JSON.LandlockProfile
+}
+
 // SetCgroupsPath sets path to cgroups profile.
 func (e *EngineConfig) SetCgroupsPath(path string) {
 	e.JSON.CgroupsPath = path
@@ -841,6 +969,18 @@ func (e *EngineConfig) GetLibrariesPath() []string {
 	return e.JSON.LibrariesPath
 }
 
+// SetHostLibraryPath sets the directories of injected host libraries
+// prepended to the container library search path.
+func (e *EngineConfig) SetHostLibraryPath(dirs []string) {
+	e.JSON.HostLibraryPath = dirs
+}
+
+// GetHostLibraryPath returns the directories of injected host libraries
+// prepended to the container library search path.
+func (e *EngineConfig) GetHostLibraryPath() []string {
+	return e.JSON.HostLibraryPath
+}
+
 // SetFilesPath sets files to bind in container (eg: --nv).
 func (e *EngineConfig) SetFilesPath(files []string) {
 	e.JSON.FilesPath = files
@@ -997,6 +1137,127 @@ func (e *EngineConfig) GetRestoreUmask() bool {
 	return e.JSON.RestoreUmask
 }
 
+// SetSecurityAudit sets whether privileged operations done by starter
+// are logged for auditing purpose.
+func (e *EngineConfig) SetSecurityAudit(audit bool) {
+	e.JSON.SecurityAudit = audit
+}
+
+// GetSecurityAudit returns whether privileged operations done by starter
+// are logged for auditing purpose.
+func (e *EngineConfig) GetSecurityAudit() bool {
+	return e.JSON.SecurityAudit
+}
+
+// SetPidFile sets the path of the file where the container
+// process PID is written.
+func (e *EngineConfig) SetPidFile(path string) {
+	e.JSON.PidFile = path
+}
+
+// GetPidFile returns the path of the file where the container
+// process PID is written.
+func (e *EngineConfig) GetPidFile() string {
+	return e.JSON.PidFile
+}
+
+// SetInfoFile sets the path of the file where the container
+// run metadata are written in JSON format.
+func (e *EngineConfig) SetInfoFile(path string) {
+	e.JSON.InfoFile = path
+}
+
+// GetInfoFile returns the path of the file where the container
+// run metadata are written in JSON format.
+func (e *EngineConfig) GetInfoFile() string {
+	return e.JSON.InfoFile
+}
+
+// SetHistoryDir sets the directory where the provenance record
+// of the container run is written.
+func (e *EngineConfig) SetHistoryDir(dir string) {
+	e.JSON.HistoryDir = dir
+}
+
+// GetHistoryDir returns the directory where the provenance record
+// of the container run is written.
+func (e *EngineConfig) GetHistoryDir() string {
+	return e.JSON.HistoryDir
+}
+
+// SetRusage sets if the container resource usage is
+// printed once the container exits.
+func (e *EngineConfig) SetRusage(rusage bool) {
+	e.JSON.Rusage = rusage
+}
+
+// GetRusage returns if the container resource usage is
+// printed once the container exits.
+func (e *EngineConfig) GetRusage() bool {
+	return e.JSON.Rusage
+}
+
+// SetRusageFile sets the path of the file where the container
+// resource usage is written in JSON format once it exits.
+func (e *EngineConfig) SetRusageFile(path string) {
+	e.JSON.RusageFile = path
+}
+
+// GetRusageFile returns the path of the file where the container
+// resource usage is written in JSON format once it exits.
+func (e *EngineConfig) GetRusageFile() string {
+	return e.JSON.RusageFile
+}
+
+// SetUlimits sets the container process resource limits
+// requested by the user in the name=soft[:hard] format.
+func (e *EngineConfig) SetUlimits(limits []string) {
+	e.JSON.Ulimits = limits
+}
+
+// GetUlimits returns the container process resource limits
+// requested by the user in the name=soft[:hard] format.
+func (e *EngineConfig) GetUlimits() []string {
+	return e.JSON.Ulimits
+}
+
+// SetEnvPolicy sets the regular expressions of the environment variables
+// removed from the container process environment, and of the variables
+// kept despite matching a deny expression.
+func (e *EngineConfig) SetEnvPolicy(deny, allow []string) {
+	e.JSON.EnvDeny = deny
+	e.JSON.EnvAllow = allow
+}
+
+// GetEnvPolicy returns the regular expressions of the environment
+// variables denied and allowed in the container process environment.
+func (e *EngineConfig) GetEnvPolicy() ([]string, []string) {
+	return e.JSON.EnvDeny, e.JSON.EnvAllow
+}
+
+// SetHookMounts sets the bind mounts requested by the site OCI hooks
+// matching the container.
+func (e *EngineConfig) SetHookMounts(mounts []specs.Mount) {
+	e.JSON.HookMounts = mounts
+}
+
+// GetHookMounts returns the bind mounts requested by the site OCI hooks
+// matching the container.
+func (e *EngineConfig) GetHookMounts() []specs.Mount {
+	return e.JSON.HookMounts
+}
+
+// SetCLIFlags sets the names of the command line flags set by
+// the user, reported in the accounting records.
+func (e *EngineConfig) SetCLIFlags(flags []string) {
+	e.JSON.CLIFlags = flags
+}
+
+// GetCLIFlags returns the names of the command line flags set by the user.
+func (e *EngineConfig) GetCLIFlags() []string {
+	return e.JSON.CLIFlags
+}
+
 // SetUmask sets the umask to be used in the container launched process.
 func (e *EngineConfig) SetUmask(umask int) {
 	e.JSON.Umask = umask
@@ -1006,3 +1267,15 @@ func (e *EngineConfig) SetUmask(umask int) {
 func (e *EngineConfig) GetUmask() int {
 	return e.JSON.Umask
 }
+
+// SetDryRun sets whether the engine reports the plan of the container
+// instead of setting it up.
+func (e *EngineConfig) SetDryRun(dryRun bool) {
+	e.JSON.DryRun = dryRun
+}
+
+// GetDryRun returns whether the engine reports the plan of the container
+// instead of setting it up.
+func (e *EngineConfig) GetDryRun() bool {
+	return e.JSON.DryRun
+}
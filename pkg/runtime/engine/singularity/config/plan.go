@@ -0,0 +1,56 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// PlannedMount is a mount performed while setting up the container.
+type PlannedMount struct {
+	// Stage is the mount stage, mounts are performed stage by stage.
+	Stage       string   `json:"stage"`
+	Source      string   `json:"source"`
+	Destination string   `json:"destination"`
+	Type        string   `json:"type"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// PlannedSecurity holds the security options applied to the container
+// process.
+type PlannedSecurity struct {
+	Fakeroot     bool     `json:"fakeroot"`
+	NoPrivs      bool     `json:"noPrivs"`
+	KeepPrivs    bool     `json:"keepPrivs"`
+	AllowSUID    bool     `json:"allowSetuid"`
+	NoNewPrivs   bool     `json:"noNewPrivs"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	Options      []string `json:"options,omitempty"`
+	Seccomp      bool     `json:"seccomp"`
+	TargetUID    int      `json:"targetUid,omitempty"`
+	TargetGID    []int    `json:"targetGid,omitempty"`
+	Cgroups      string   `json:"cgroups,omitempty"`
+	Ulimits      []string `json:"ulimits,omitempty"`
+}
+
+// ContainerPlan describes how a container is set up, reported by the engine
+// with a dry run instead of setting it up.
+type ContainerPlan struct {
+	Image      string                 `json:"image"`
+	Starter    string                 `json:"starter"`
+	Namespaces []string               `json:"namespaces"`
+	Network    string                 `json:"network,omitempty"`
+	UIDMap     []specs.LinuxIDMapping `json:"uidMappings,omitempty"`
+	GIDMap     []specs.LinuxIDMapping `json:"gidMappings,omitempty"`
+	Mounts     []PlannedMount         `json:"mounts"`
+	Args       []string               `json:"args"`
+	Cwd        string                 `json:"cwd"`
+	Env        []string               `json:"env"`
+	// ContainerEnv are the variables set by the container scripts,
+	// like the SINGULARITYENV_ variables.
+	ContainerEnv map[string]string `json:"containerEnv,omitempty"`
+	Security     PlannedSecurity   `json:"security"`
+}
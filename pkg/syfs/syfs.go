@@ -22,6 +22,7 @@ const (
 	RemoteConfFile = "remote.yaml"
 	RemoteCache    = "remote-cache"
 	DockerConfFile = "docker-config.json"
+	SignersFile    = "signers.json"
 	singularityDir = ".singularity"
 )
 
@@ -72,6 +73,12 @@ func DockerConf() string {
 	return filepath.Join(ConfigDir(), DockerConfFile)
 }
 
+// Signers returns the file holding the key fingerprints pinned for the
+// signing services trusted by verify.
+func Signers() string {
+	return filepath.Join(ConfigDir(), SignersFile)
+}
+
 // ConfigDirForUsername returns the directory where the singularity
 // configuration and data for the specified username is located.
 func ConfigDirForUsername(username string) (string, error) {
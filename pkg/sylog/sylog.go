@@ -33,6 +33,9 @@ var (
 
 var logWriter = (io.Writer)(os.Stderr)
 
+// fatalHandler reports the fatal messages in place of writef when set.
+var fatalHandler func(format string, a ...interface{})
+
 func init() {
 	level, err := strconv.Atoi(os.Getenv(messageLevelEnv))
 	if err == nil {
@@ -95,10 +98,22 @@ func getLoggerLevel() messageLevel {
 // Fatalf is equivalent to a call to Errorf followed by os.Exit(255). Code that
 // may be imported by other projects should NOT use Fatalf.
 func Fatalf(format string, a ...interface{}) {
-	writef(FatalLevel, format, a...)
+	if fatalHandler != nil {
+		fatalHandler(format, a...)
+	} else {
+		writef(FatalLevel, format, a...)
+	}
 	os.Exit(255)
 }
 
+// SetFatalHandler sets the function reporting the messages of Fatalf in
+// place of the logger, the handler may exit with its own code, otherwise
+// Fatalf exits with code 255 once it returns. A nil handler restores the
+// logger.
+func SetFatalHandler(h func(format string, a ...interface{})) {
+	fatalHandler = h
+}
+
 // Errorf writes an ERROR level message to the log but does not exit. This
 // should be called when an error is being returned to the calling thread
 func Errorf(format string, a ...interface{}) {
@@ -34,6 +34,9 @@ func Verbosef(format string, a ...interface{}) {}
 // Debugf is a dummy function doing nothing
 func Debugf(format string, a ...interface{}) {}
 
+// SetFatalHandler is a dummy function doing nothing.
+func SetFatalHandler(h func(format string, a ...interface{})) {}
+
 // SetLevel is a dummy function doing nothing.
 func SetLevel(l int, color bool) {}
 
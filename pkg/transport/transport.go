@@ -0,0 +1,86 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package transport defines the interface of the image transports, which
+// handle the image references of a URI scheme (eg: library://, docker://),
+// and the registry of the transports by scheme. The commands pulling and
+// pushing images look up the transport of a reference in the registry, new
+// image sources are added by registering their transport, from a plugin
+// with the cli.Transport callback.
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrUnsupported is returned by the transports not supporting an operation,
+// like pushing to a read-only source.
+var ErrUnsupported = errors.New("operation not supported by transport")
+
+// Transport is an image transport. The image references are given with
+// their scheme (eg: docker://alpine:latest).
+type Transport interface {
+	// Resolve returns the normalized form of the image reference ref, or an
+	// error if ref is malformed.
+	Resolve(ctx context.Context, ref string) (string, error)
+	// Digest returns the digest of the current content of the image ref,
+	// which identifies the image in the cache. An empty digest means the
+	// image can't be cached.
+	Digest(ctx context.Context, ref string) (string, error)
+	// Fetch downloads the image ref to the SIF image at path.
+	Fetch(ctx context.Context, ref, path string) error
+	// Push uploads the SIF image at path to ref, ErrUnsupported is
+	// returned if the transport doesn't support pushing images.
+	Push(ctx context.Context, path, ref string) error
+}
+
+var (
+	mu         sync.RWMutex
+	transports = make(map[string]Transport)
+)
+
+// Register registers the transport t for the image references of scheme,
+// an error is returned if scheme already has a transport.
+func Register(scheme string, t Transport) error {
+	if scheme == "" {
+		return fmt.Errorf("empty transport scheme")
+	} else if t == nil {
+		return fmt.Errorf("nil transport for scheme %s", scheme)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := transports[scheme]; ok {
+		return fmt.Errorf("transport already registered for scheme %s", scheme)
+	}
+	transports[scheme] = t
+	return nil
+}
+
+// Get returns the transport registered for scheme, nil if there is none.
+func Get(scheme string) Transport {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return transports[scheme]
+}
+
+// Schemes returns the sorted schemes of the registered transports.
+func Schemes() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	schemes := make([]string, 0, len(transports))
+	for s := range transports {
+		schemes = append(schemes, s)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package transport
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+type testTransport struct{}
+
+func (testTransport) Resolve(_ context.Context, ref string) (string, error) { return ref, nil }
+func (testTransport) Digest(context.Context, string) (string, error)        { return "", nil }
+func (testTransport) Fetch(context.Context, string, string) error           { return nil }
+func (testTransport) Push(context.Context, string, string) error            { return ErrUnsupported }
+
+func TestRegister(t *testing.T) {
+	defer func() {
+		transports = make(map[string]Transport)
+	}()
+
+	if err := Register("ipfs", testTransport{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := Register("dav", testTransport{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := Register("ipfs", testTransport{}); err == nil {
+		t.Errorf("unexpected success registering a scheme twice")
+	}
+	if err := Register("", testTransport{}); err == nil {
+		t.Errorf("unexpected success registering an empty scheme")
+	}
+	if err := Register("artifactory", nil); err == nil {
+		t.Errorf("unexpected success registering a nil transport")
+	}
+
+	if Get("ipfs") == nil {
+		t.Errorf("registered transport not found")
+	}
+	if Get("artifactory") != nil {
+		t.Errorf("unexpected transport for unregistered scheme")
+	}
+	if got, want := Schemes(), []string{"dav", "ipfs"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got schemes %v, want %v", got, want)
+	}
+}
@@ -18,18 +18,20 @@ import (
 type Caplist map[string][]string
 
 // Config is the in memory representation of the user/group capability
-// authorizations as set by an admin
+// authorizations and the named capability profiles as set by an admin
 type Config struct {
-	Users  Caplist `json:"users,omitempty"`
-	Groups Caplist `json:"groups,omitempty"`
+	Users    Caplist `json:"users,omitempty"`
+	Groups   Caplist `json:"groups,omitempty"`
+	Profiles Caplist `json:"profiles,omitempty"`
 }
 
 // ReadFrom reads a capability configuration from an io.Reader and returns a capability
 // config with the set of authorized user/group capabilities
 func ReadFrom(r io.Reader) (*Config, error) {
 	c := &Config{
-		Users:  make(Caplist),
-		Groups: make(Caplist),
+		Users:    make(Caplist),
+		Groups:   make(Caplist),
+		Profiles: make(Caplist),
 	}
 
 	// read all data from r into b
@@ -125,6 +127,27 @@ func (c *Config) AddGroupCaps(group string, caps []string) error {
 	return nil
 }
 
+// AddProfileCaps adds a capability set to the named profile
+func (c *Config) AddProfileCaps(profile string, caps []string) error {
+	if err := c.checkCaps(caps); err != nil {
+		return err
+	}
+	for _, cap := range caps {
+		present := false
+		for _, c := range c.Profiles[profile] {
+			if c == cap {
+				present = true
+			}
+		}
+		if !present {
+			c.Profiles[profile] = append(c.Profiles[profile], cap)
+		} else {
+			sylog.Warningf("Won't add capability '%s', already assigned to profile %s", cap, profile)
+		}
+	}
+	return nil
+}
+
 // DropUserCaps drops a set of capabilities for user
 func (c *Config) DropUserCaps(user string, caps []string) error {
 	if err := c.checkCaps(caps); err != nil {
@@ -179,6 +202,34 @@ func (c *Config) DropGroupCaps(group string, caps []string) error {
 	return nil
 }
 
+// DropProfileCaps drops a set of capabilities from the named profile,
+// the profile is removed once it doesn't contain any capability
+func (c *Config) DropProfileCaps(profile string, caps []string) error {
+	if err := c.checkCaps(caps); err != nil {
+		return err
+	}
+	if _, ok := c.Profiles[profile]; !ok {
+		return fmt.Errorf("profile '%s' doesn't have any capability assigned", profile)
+	}
+	for _, cap := range caps {
+		dropped := false
+		for i := len(c.Profiles[profile]) - 1; i >= 0; i-- {
+			if c.Profiles[profile][i] == cap {
+				c.Profiles[profile] = append(c.Profiles[profile][:i], c.Profiles[profile][i+1:]...)
+				dropped = true
+				break
+			}
+		}
+		if !dropped {
+			sylog.Warningf("Won't drop capability '%s', not assigned to profile %s", cap, profile)
+		}
+	}
+	if len(c.Profiles[profile]) == 0 {
+		delete(c.Profiles, profile)
+	}
+	return nil
+}
+
 // ListUserCaps returns a capability list authorized for user
 func (c *Config) ListUserCaps(user string) []string {
 	return c.Users[user]
@@ -189,6 +240,16 @@ func (c *Config) ListGroupCaps(group string) []string {
 	return c.Groups[group]
 }
 
+// ListProfileCaps returns the capability list of the named profile
+func (c *Config) ListProfileCaps(profile string) []string {
+	return c.Profiles[profile]
+}
+
+// ListAllProfiles returns all capability profiles
+func (c *Config) ListAllProfiles() Caplist {
+	return c.Profiles
+}
+
 // ListAllCaps returns capability list for both authorized users and groups
 func (c *Config) ListAllCaps() (Caplist, Caplist) {
 	return c.Users, c.Groups
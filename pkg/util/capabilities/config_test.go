@@ -21,8 +21,9 @@ func TestReadFromWriteTo(t *testing.T) {
 		{
 			name: "empty config",
 			c: Config{
-				Users:  map[string][]string{},
-				Groups: map[string][]string{},
+				Users:    map[string][]string{},
+				Groups:   map[string][]string{},
+				Profiles: map[string][]string{},
 			},
 		},
 		{
@@ -36,6 +37,9 @@ func TestReadFromWriteTo(t *testing.T) {
 					"user1": {"CAP_SYS_ADMIN"},
 					"user2": {"CAP_SYS_ADMIN", "CAP_DAC_OVERRIDE"},
 				},
+				Profiles: map[string][]string{
+					"ping": {"CAP_NET_RAW"},
+				},
 			},
 		},
 	}
@@ -537,6 +541,35 @@ func TestListCaps(t *testing.T) {
 	}
 }
 
+func TestProfileCaps(t *testing.T) {
+	conf := Config{
+		Profiles: map[string][]string{},
+	}
+
+	if err := conf.AddProfileCaps("ping", []string{"CAP_NET_RAW", "CAP_NET_RAW"}); err != nil {
+		t.Fatalf("unexpected error while adding profile capabilities: %s", err)
+	}
+	if err := conf.AddProfileCaps("ping", []string{"CAP_BAD"}); err == nil {
+		t.Errorf("unexpected success while adding unknown capability")
+	}
+	if !reflect.DeepEqual(conf.ListProfileCaps("ping"), []string{"CAP_NET_RAW"}) {
+		t.Errorf("profile cap lookup failed: %v", conf.ListProfileCaps("ping"))
+	}
+	if !reflect.DeepEqual(conf.ListAllProfiles(), conf.Profiles) {
+		t.Error("all profiles lookup failed")
+	}
+
+	if err := conf.DropProfileCaps("unknown", []string{"CAP_NET_RAW"}); err == nil {
+		t.Errorf("unexpected success while dropping capabilities from unknown profile")
+	}
+	if err := conf.DropProfileCaps("ping", []string{"CAP_NET_RAW"}); err != nil {
+		t.Fatalf("unexpected error while dropping profile capabilities: %s", err)
+	}
+	if _, ok := conf.Profiles["ping"]; ok {
+		t.Errorf("empty profile not removed")
+	}
+}
+
 type capCheckTest struct {
 	name         string
 	id           string
@@ -46,3 +46,60 @@ func (b *TerminalBuffer) Line() []byte {
 	copy(tmp, b.data)
 	return tmp
 }
+
+// RingBuffer keeps the last bytes written to it up to its size.
+type RingBuffer struct {
+	data  []byte
+	start int
+	full  bool
+	mutex sync.Mutex
+}
+
+// NewRingBuffer returns a RingBuffer keeping the last size bytes.
+func NewRingBuffer(size int) *RingBuffer {
+	return &RingBuffer{data: make([]byte, size)}
+}
+
+// Write implements the write interface to store the last written bytes.
+func (b *RingBuffer) Write(p []byte) (n int, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	n = len(p)
+	size := len(b.data)
+	if size == 0 {
+		return n, nil
+	}
+	if len(p) >= size {
+		copy(b.data, p[len(p)-size:])
+		b.start = 0
+		b.full = true
+		return n, nil
+	}
+
+	c := copy(b.data[b.start:], p)
+	if c < len(p) {
+		copy(b.data, p[c:])
+		b.full = true
+	}
+	b.start = (b.start + len(p)) % size
+	if b.start == 0 {
+		b.full = true
+	}
+	return n, nil
+}
+
+// Bytes returns the bytes kept by the buffer, the oldest first.
+func (b *RingBuffer) Bytes() []byte {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if !b.full {
+		tmp := make([]byte, b.start)
+		copy(tmp, b.data[:b.start])
+		return tmp
+	}
+	tmp := make([]byte, 0, len(b.data))
+	tmp = append(tmp, b.data[b.start:]...)
+	return append(tmp, b.data[:b.start]...)
+}
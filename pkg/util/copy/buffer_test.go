@@ -43,3 +43,35 @@ func TestNewTerminalBuffer(t *testing.T) {
 		t.Errorf("unexpected line returned")
 	}
 }
+
+func TestRingBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		writes []string
+		expect string
+	}{
+		{"Empty", 4, nil, ""},
+		{"NotFull", 4, []string{"ab"}, "ab"},
+		{"Full", 4, []string{"ab", "cd"}, "abcd"},
+		{"Wrap", 4, []string{"abc", "def"}, "cdef"},
+		{"WrapTwice", 4, []string{"abc", "def", "ghi"}, "fghi"},
+		{"Larger", 4, []string{"a", "bcdefgh"}, "efgh"},
+		{"Disabled", 0, []string{"abc"}, ""},
+	}
+
+	for _, tt := range tests {
+		b := NewRingBuffer(tt.size)
+		for _, w := range tt.writes {
+			n, err := b.Write([]byte(w))
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", tt.name, err)
+			} else if n != len(w) {
+				t.Errorf("%s: wrong number of bytes written", tt.name)
+			}
+		}
+		if got := string(b.Bytes()); got != tt.expect {
+			t.Errorf("%s: got %q instead of %q", tt.name, got, tt.expect)
+		}
+	}
+}
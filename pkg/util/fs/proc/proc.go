@@ -8,11 +8,13 @@ package proc
 import (
 	"bufio"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"golang.org/x/sys/unix"
 )
@@ -337,3 +339,138 @@ func Getppid(pid int) (int, error) {
 
 	return -1, fmt.Errorf("no parent process ID found")
 }
+
+// clockTicks is the kernel USER_HZ value used to express process times in
+// /proc/<pid>/stat, it is 100 on all supported architectures.
+const clockTicks = 100
+
+// Stat holds process information parsed from /proc/<pid>/stat
+// and /proc/<pid>/status.
+type Stat struct {
+	Pid  int
+	PPid int
+	// UID is the real user ID of the process.
+	UID int
+	// State is the process state (eg: R for running, T for stopped).
+	State string
+	// StartTime is the time at which the process started.
+	StartTime time.Time
+	// CPUTime is the user and system time consumed by the process.
+	CPUTime time.Duration
+	// RSS is the resident set size of the process in bytes.
+	RSS int64
+}
+
+// bootTime returns the system boot time read from /proc/stat.
+func bootTime() (time.Time, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not open /proc/stat: %s", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var btime int64
+		if n, _ := fmt.Sscanf(scanner.Text(), "btime %d", &btime); n == 1 {
+			return time.Unix(btime, 0), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("no boot time found in /proc/stat")
+}
+
+// GetStat returns the process information for the corresponding
+// process ID passed in parameter.
+func GetStat(pid int) (*Stat, error) {
+	path := fmt.Sprintf("/proc/%d/stat", pid)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %s", path, err)
+	}
+
+	// the command name enclosed in parenthesis may contain spaces,
+	// fields are parsed after the closing parenthesis starting with
+	// the process state
+	i := strings.LastIndexByte(string(data), ')')
+	if i < 0 {
+		return nil, fmt.Errorf("bad format for %s", path)
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("bad format for %s", path)
+	}
+
+	values := make(map[int]int64)
+	for _, index := range []int{1, 11, 12, 19, 21} {
+		v, err := strconv.ParseInt(fields[index], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad field %d in %s: %s", index+3, path, err)
+		}
+		values[index] = v
+	}
+
+	btime, err := bootTime()
+	if err != nil {
+		return nil, err
+	}
+
+	st := &Stat{
+		Pid:       pid,
+		PPid:      int(values[1]),
+		UID:       -1,
+		State:     fields[0],
+		StartTime: btime.Add(time.Duration(values[19]) * time.Second / clockTicks),
+		CPUTime:   time.Duration(values[11]+values[12]) * time.Second / clockTicks,
+		RSS:       values[21] * int64(os.Getpagesize()),
+	}
+
+	status := fmt.Sprintf("/proc/%d/status", pid)
+	f, err := os.Open(status)
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %s", status, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if n, _ := fmt.Sscanf(scanner.Text(), "Uid:\t%d", &st.UID); n == 1 {
+			break
+		}
+	}
+	if st.UID < 0 {
+		return nil, fmt.Errorf("no user ID found in %s", status)
+	}
+
+	return st, nil
+}
+
+// IO holds the storage I/O counters of a process parsed from
+// /proc/<pid>/io.
+type IO struct {
+	// ReadBytes is the number of bytes read from storage.
+	ReadBytes int64
+	// WriteBytes is the number of bytes written to storage.
+	WriteBytes int64
+}
+
+// GetIO returns the storage I/O counters for the corresponding process
+// ID passed in parameter, they are only readable by the process owner.
+func GetIO(pid int) (*IO, error) {
+	path := fmt.Sprintf("/proc/%d/io", pid)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %s", path, err)
+	}
+	defer f.Close()
+
+	io := new(IO)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fmt.Sscanf(scanner.Text(), "read_bytes: %d", &io.ReadBytes)
+		fmt.Sscanf(scanner.Text(), "write_bytes: %d", &io.WriteBytes)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read %s: %s", path, err)
+	}
+	return io, nil
+}
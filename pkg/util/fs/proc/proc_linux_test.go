@@ -11,6 +11,7 @@ import (
 	"os/exec"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/hpcng/singularity/internal/pkg/test"
 )
@@ -498,3 +499,44 @@ func TestGetppid(t *testing.T) {
 		}
 	}
 }
+
+func TestGetStat(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	st, err := GetStat(os.Getpid())
+	if err != nil {
+		t.Fatalf("unexpected failure: %s", err)
+	}
+	if st.PPid != os.Getppid() {
+		t.Errorf("unexpected parent process ID returned: got %d instead of %d", st.PPid, os.Getppid())
+	}
+	if st.UID != os.Getuid() {
+		t.Errorf("unexpected user ID returned: got %d instead of %d", st.UID, os.Getuid())
+	}
+	if st.StartTime.After(time.Now()) {
+		t.Errorf("unexpected process start time in the future: %s", st.StartTime)
+	}
+	if st.RSS <= 0 {
+		t.Errorf("unexpected resident set size %d", st.RSS)
+	}
+	if st.State != "R" {
+		t.Errorf("unexpected process state %q", st.State)
+	}
+
+	if _, err := GetStat(0); err == nil {
+		t.Errorf("unexpected success for process ID 0")
+	}
+}
+
+func TestGetIO(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	if _, err := GetIO(os.Getpid()); err != nil {
+		t.Fatalf("unexpected failure: %s", err)
+	}
+	if _, err := GetIO(0); err == nil {
+		t.Errorf("unexpected success for process ID 0")
+	}
+}
@@ -155,6 +155,13 @@ func paths(gpuFileList []string) ([]string, []string, error) {
 	return libraries, binaries, nil
 }
 
+// ResolvePaths takes a list of library/binary files like the ones of a
+// gpu lib list config file and returns the resolved library and binary
+// paths to be bound into the container.
+func ResolvePaths(files []string) ([]string, []string, error) {
+	return paths(files)
+}
+
 // ldcache retrieves a map of absolute path of a library to it's bare name using the system ld cache via `ldconfig -p`
 func ldCache() (map[string]string, error) {
 	// walk through the ldconfig output and add entries which contain the filenames
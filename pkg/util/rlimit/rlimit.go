@@ -20,3 +20,18 @@ func Set(res string, cur uint64, max uint64) error {
 func Get(res string) (cur uint64, max uint64, err error) {
 	return 0, 0, fmt.Errorf("not supported on this platform")
 }
+
+// SetProcess sets soft and hard resource limit of the process pid
+func SetProcess(pid int, res string, cur uint64, max uint64) error {
+	return fmt.Errorf("not supported on this platform")
+}
+
+// GetProcess retrieves soft and hard resource limit of the process pid
+func GetProcess(pid int, res string) (cur uint64, max uint64, err error) {
+	return 0, 0, fmt.Errorf("not supported on this platform")
+}
+
+// Parse parses a resource limit in the docker format name=soft[:hard]
+func Parse(limit string) (res string, cur uint64, max uint64, err error) {
+	return "", 0, 0, fmt.Errorf("not supported on this platform")
+}
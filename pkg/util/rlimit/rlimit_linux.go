@@ -1,4 +1,4 @@
-// Copyright (c) 2018, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -7,9 +7,16 @@ package rlimit
 
 import (
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"syscall"
+	"unsafe"
 )
 
+// Unlimited is the value of an unlimited resource limit.
+const Unlimited = math.MaxUint64
+
 var resource = map[string]int{
 	"RLIMIT_CPU":        0,
 	"RLIMIT_FSIZE":      1,
@@ -68,3 +75,92 @@ func Get(res string) (cur uint64, max uint64, err error) {
 
 	return
 }
+
+// SetProcess sets soft and hard resource limit of the process pid with
+// prlimit(2), raising the hard limit requires CAP_SYS_RESOURCE.
+func SetProcess(pid int, res string, cur uint64, max uint64) error {
+	resVal, ok := resource[res]
+	if !ok {
+		return fmt.Errorf("%s is not a valid resource type", res)
+	}
+
+	rlim := syscall.Rlimit{Cur: cur, Max: max}
+	if err := prlimit(pid, resVal, &rlim, nil); err != nil {
+		return fmt.Errorf("failed to set resource limit %s of process %d: %w", res, pid, err)
+	}
+
+	return nil
+}
+
+// GetProcess retrieves soft and hard resource limit of the process pid
+func GetProcess(pid int, res string) (cur uint64, max uint64, err error) {
+	var rlim syscall.Rlimit
+
+	resVal, ok := resource[res]
+	if !ok {
+		err = fmt.Errorf("%s is not a valid resource type", res)
+		return
+	}
+
+	if err = prlimit(pid, resVal, nil, &rlim); err != nil {
+		err = fmt.Errorf("failed to get resource limit %s of process %d: %w", res, pid, err)
+		return
+	}
+
+	return rlim.Cur, rlim.Max, nil
+}
+
+func prlimit(pid int, res int, newLimit *syscall.Rlimit, oldLimit *syscall.Rlimit) error {
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(res), uintptr(unsafe.Pointer(newLimit)), uintptr(unsafe.Pointer(oldLimit)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Parse parses a resource limit in the docker format name=soft[:hard]
+// like nofile=4096:8192. The name is case insensitive and the RLIMIT_
+// prefix is optional, the hard limit defaults to the soft limit and
+// "unlimited" or -1 denote an unlimited value. It returns the resource
+// type like RLIMIT_NOFILE with the soft and hard limits.
+func Parse(limit string) (res string, cur uint64, max uint64, err error) {
+	split := strings.SplitN(limit, "=", 2)
+	if len(split) != 2 || split[1] == "" {
+		err = fmt.Errorf("%q is not a valid resource limit, expected name=soft[:hard]", limit)
+		return
+	}
+
+	res = strings.ToUpper(strings.TrimSpace(split[0]))
+	if !strings.HasPrefix(res, "RLIMIT_") {
+		res = "RLIMIT_" + res
+	}
+	if _, ok := resource[res]; !ok {
+		err = fmt.Errorf("%s is not a valid resource type", split[0])
+		return
+	}
+
+	values := strings.SplitN(split[1], ":", 2)
+	if cur, err = parseValue(values[0]); err != nil {
+		err = fmt.Errorf("invalid soft limit for %s: %s", res, err)
+		return
+	}
+	max = cur
+	if len(values) == 2 {
+		if max, err = parseValue(values[1]); err != nil {
+			err = fmt.Errorf("invalid hard limit for %s: %s", res, err)
+			return
+		}
+	}
+	if cur > max {
+		err = fmt.Errorf("soft limit of %s is greater than its hard limit", res)
+	}
+	return
+}
+
+func parseValue(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	if s == "unlimited" || s == "-1" {
+		return Unlimited, nil
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
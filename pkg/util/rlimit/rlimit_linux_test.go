@@ -6,6 +6,9 @@
 package rlimit
 
 import (
+	"errors"
+	"os/exec"
+	"syscall"
 	"testing"
 
 	"github.com/hpcng/singularity/internal/pkg/test"
@@ -39,3 +42,77 @@ func TestGetSet(t *testing.T) {
 		t.Errorf("resource limit RLIMIT_FAKE doesn't exist")
 	}
 }
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		limit   string
+		res     string
+		cur     uint64
+		max     uint64
+		wantErr bool
+	}{
+		{limit: "nofile=4096:8192", res: "RLIMIT_NOFILE", cur: 4096, max: 8192},
+		{limit: "NOFILE=4096", res: "RLIMIT_NOFILE", cur: 4096, max: 4096},
+		{limit: "RLIMIT_CORE=0:unlimited", res: "RLIMIT_CORE", cur: 0, max: Unlimited},
+		{limit: "stack=-1", res: "RLIMIT_STACK", cur: Unlimited, max: Unlimited},
+		{limit: "nofile=8192:4096", wantErr: true},
+		{limit: "fake=1", wantErr: true},
+		{limit: "nofile", wantErr: true},
+		{limit: "nofile=", wantErr: true},
+		{limit: "nofile=a:b", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		res, cur, max, err := Parse(tt.limit)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("unexpected success for %q", tt.limit)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", tt.limit, err)
+		} else if res != tt.res || cur != tt.cur || max != tt.max {
+			t.Errorf("%q: got %s=%d:%d, expected %s=%d:%d", tt.limit, res, cur, max, tt.res, tt.cur, tt.max)
+		}
+	}
+}
+
+func TestGetSetProcess(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start process: %s", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	pid := cmd.Process.Pid
+
+	cur, max, err := GetProcess(pid, "RLIMIT_NOFILE")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cur < 2 {
+		t.Skipf("soft limit %d too low", cur)
+	}
+
+	if err := SetProcess(pid, "RLIMIT_NOFILE", cur/2, max); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if c, m, err := GetProcess(pid, "RLIMIT_NOFILE"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	} else if c != cur/2 || m != max {
+		t.Errorf("got %d:%d instead of %d:%d", c, m, cur/2, max)
+	}
+
+	if err := SetProcess(pid, "RLIMIT_FAKE", cur, max); err == nil {
+		t.Errorf("resource limit RLIMIT_FAKE doesn't exist")
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+	if err := SetProcess(pid, "RLIMIT_NOFILE", cur, max); !errors.Is(err, syscall.ESRCH) {
+		t.Errorf("got error %v instead of ESRCH for an exited process", err)
+	}
+}
@@ -52,18 +52,48 @@ type File struct {
 	LimitContainerOwners    []string `directive:"limit container owners"`
 	LimitContainerGroups    []string `directive:"limit container groups"`
 	LimitContainerPaths     []string `directive:"limit container paths"`
+	MaxContainersPerUser    uint     `default:"0" directive:"max containers per user"`
+	MaxInstancesPerUser     uint     `default:"0" directive:"max instances per user"`
+	InstanceStateDir        string   `directive:"instance state dir"`
+	MaxMemoryPerUser        uint     `default:"0" directive:"max memory per user"`
+	Ulimits                 []string `directive:"ulimit"`
+	OCIHooksDir             []string `directive:"oci hooks dir"`
+	AccountingHook          string   `directive:"accounting hook"`
+	AccountingImageDigest   bool     `default:"no" authorized:"yes,no" directive:"accounting image digest"`
+	CacheQuota              []string `directive:"cache quota"`
+	SystemCacheDir          string   `directive:"system cache dir"`
 	AllowNetUsers           []string `directive:"allow net users"`
 	AllowNetGroups          []string `directive:"allow net groups"`
 	AllowNetNetworks        []string `directive:"allow net networks"`
 	RootDefaultCapabilities string   `default:"full" authorized:"full,file,no" directive:"root default capabilities"`
+	SeccompProfile          string   `default:"default" directive:"seccomp profile"`
+	SeccompAllow            []string `directive:"seccomp allow"`
+	SeccompDeny             []string `directive:"seccomp deny"`
+	EnvDeny                 []string `directive:"env deny"`
+	EnvAllow                []string `directive:"env allow"`
 	MemoryFSType            string   `default:"tmpfs" authorized:"tmpfs,ramfs" directive:"memory fs type"`
 	CniConfPath             string   `directive:"cni configuration path"`
 	CniPluginPath           string   `directive:"cni plugin path"`
 	MksquashfsPath          string   `directive:"mksquashfs path"`
 	MksquashfsProcs         uint     `default:"0" directive:"mksquashfs procs"`
 	MksquashfsMem           string   `directive:"mksquashfs mem"`
+	SquashfsPacker          string   `default:"mksquashfs" authorized:"mksquashfs,tar2sqfs,builtin" directive:"squashfs packer"`
+	Tar2sqfsPath            string   `directive:"tar2sqfs path"`
+	TmpDirCandidates        []string `directive:"tmp dir candidates"`
+	MaxTmpDirSize           uint     `default:"0" directive:"max tmp dir size"`
 	CryptsetupPath          string   `directive:"cryptsetup path"`
+	DmVerity                string   `default:"try" authorized:"yes,no,try" directive:"dm verity"`
+	DmsetupPath             string   `directive:"dmsetup path"`
+	IMAMeasurement          bool     `default:"no" authorized:"yes,no" directive:"ima measurement"`
+	RuntimeInfo             bool     `default:"no" authorized:"yes,no" directive:"runtime info"`
+	RuntimeInfoImageDigest  bool     `default:"no" authorized:"yes,no" directive:"runtime info image digest"`
 	ImageDriver             string   `directive:"image driver"`
+	DigestAlgorithm         string   `default:"sha256" authorized:"sha256,sha384,sha512" directive:"digest algorithm"`
+	PullQuarantine          bool     `default:"no" authorized:"yes,no" directive:"pull quarantine"`
+	QuarantineDir           string   `directive:"quarantine dir"`
+	QuarantineVerify        bool     `default:"yes" authorized:"yes,no" directive:"quarantine verify"`
+	QuarantineScanCommand   string   `directive:"quarantine scan command"`
+	RegistryMirrors         []string `directive:"registry mirror"`
 }
 
 const TemplateAsset = `# SINGULARITY.CONF
@@ -172,9 +202,15 @@ mount hostfs = {{ if eq .MountHostfs true }}yes{{ else }}no{{ end }}
 # NOTE: these are ignored if singularity is invoked with --contain except
 # for /etc/hosts and /etc/localtime. When invoked with --contain and --net,
 # /etc/hosts would contain a default generated content for localhost resolution.
+# Paths can reference ${USER}, ${UID}, ${GID} and ${HOME} which are set from
+# the user identity, and host environment variables like ${SLURM_JOB_ID} whose
+# value must be a single path component made of letters, digits and the
+# characters ._@+=- (not starting with a dot). Bind paths referencing a
+# variable which is not set, or not allowed, are skipped.
 #bind path = /etc/singularity/default-nsswitch.conf:/etc/nsswitch.conf
 #bind path = /opt
 #bind path = /scratch
+#bind path = /local/scratch/${SLURM_JOB_ID}:/scratch
 {{ range $path := .BindPath }}
 {{- if ne $path "" -}}
 bind path = {{$path}}
@@ -256,6 +292,132 @@ sessiondir max size = {{ .SessiondirMaxSize }}
 {{- if eq $index 0 }}limit container paths = {{ else }}, {{ end }}{{$path}}
 {{- end }}
 
+# MAX CONTAINERS PER USER: [UINT]
+# DEFAULT: 0
+# Set the maximum number of containers, instances included, a user can run
+# simultaneously on this host. A value of 0 means unlimited. This feature only
+# applies when Singularity is running in SUID mode and the user is non-root.
+max containers per user = {{ .MaxContainersPerUser }}
+
+# MAX INSTANCES PER USER: [UINT]
+# DEFAULT: 0
+# Set the maximum number of instances a user can run simultaneously on this
+# host. A value of 0 means unlimited. This feature only applies when
+# Singularity is running in SUID mode and the user is non-root.
+max instances per user = {{ .MaxInstancesPerUser }}
+
+# INSTANCE STATE DIR: [STRING]
+# DEFAULT: Undefined
+# Set the directory where the instance state files are stored, like a node
+# local directory on diskless compute nodes or a shared filesystem. User
+# instance files are stored in a directory per user created on demand, so
+# the directory must be writable by all users with the sticky bit set
+# (mode 1777), system instance files are stored in its .system directory
+# created by root, it's rejected if another user created it beforehand.
+# If not set, user instance files are stored in the user home directory and
+# system instance files in the Singularity local state directory. Users can
+# also set the SINGULARITY_INSTANCE_STATEDIR environment variable to store
+# their instance files in another directory.
+#instance state dir =
+{{ if ne .InstanceStateDir "" }}instance state dir = {{ .InstanceStateDir }}{{ end }}
+
+# MAX MEMORY PER USER: [UINT]
+# DEFAULT: 0
+# Set the maximum amount of memory (in MB) that containers of a user can
+# reserve simultaneously with the memory limit of the cgroups file passed with
+# --apply-cgroups. Containers without memory limit don't reserve memory. A
+# value of 0 means unlimited. This feature only applies when Singularity is
+# running in SUID mode and the user is non-root.
+max memory per user = {{ .MaxMemoryPerUser }}
+
+# ULIMIT: [STRING]
+# DEFAULT: Undefined
+# Set the default resource limits of the container processes in the docker
+# format name=soft[:hard], the hard limit defaults to the soft limit and
+# unlimited or -1 denote an unlimited value. This directive can be specified
+# multiple times, users can override these limits with the --ulimit option
+# but non-root users can't raise a hard limit above the one of their shell
+# or the one set here, even in setuid mode a limit set here can't raise the
+# hard limit of the shell.
+# Resources without default limit are inherited from the calling shell.
+#ulimit = nofile=4096:8192
+#ulimit = core=0
+{{ range $limit := .Ulimits }}
+{{- if ne $limit "" -}}
+ulimit = {{$limit}}
+{{ end -}}
+{{ end }}
+
+# OCI HOOKS DIR: [STRING]
+# DEFAULT: Undefined
+# Directories of OCI hook definitions, in the hooks.d JSON format used by
+# podman, run by the native and OCI engines for the matching containers.
+# Definitions can also inject environment variables and bind mounts and
+# match the image labels with "when": {"labels": {...}}. Directories must
+# be owned by root and not writable by other users, a definition replaces
+# the one with the same file name of a previous directory.
+# The native engine runs the prestart, createRuntime, poststart and poststop
+# hooks from the engine master process.
+#oci hooks dir = /usr/share/containers/oci/hooks.d
+#oci hooks dir = /etc/containers/oci/hooks.d
+{{ range $dir := .OCIHooksDir }}
+{{- if ne $dir "" -}}
+oci hooks dir = {{$dir}}
+{{ end -}}
+{{ end }}
+
+# ACCOUNTING HOOK: [STRING]
+# DEFAULT: Undefined
+# Absolute path of an executable owned by root receiving a JSON record on
+# its standard input at each container start and stop, for site accounting.
+# Records hold the event (start or stop), the user, the image, the image
+# digest if enabled below, the instance name, the names of the command line
+# flags, the container command and, for stop events, the duration and exit
+# status. The hook is executed by the engine master process and killed after
+# 10 seconds, its failures are ignored and don't affect the container.
+#accounting hook = /usr/local/libexec/singularity-accounting
+{{ if ne .AccountingHook "" }}accounting hook = {{ .AccountingHook }}{{ end }}
+
+# ACCOUNTING IMAGE DIGEST: [BOOL]
+# DEFAULT: no
+# Add the digest of image files, computed with the digest algorithm, to the
+# accounting records. The image is hashed in the background once the
+# container started, which can be costly for large images.
+accounting image digest = {{ if eq .AccountingImageDigest true }}yes{{ else }}no{{ end }}
+
+# CACHE QUOTA: [STRING]
+# DEFAULT: Undefined
+# Maximum size of a cache type, of the form <type>:<size> where type is one
+# of library, oci (or oci-tmp), blob, shub, oras or net and size is a number
+# of bytes optionally followed by a K, M, G or T unit (powers of 1024). When
+# a cache type exceeds its quota, the least recently used entries are evicted
+# as images are pulled. Blob quotas are enforced before fetching an image,
+# the blob cache may exceed its quota by the size of the image being pulled.
+# This directive can be specified multiple times, users can override quotas
+# with the SINGULARITY_CACHE_QUOTA environment variable holding a comma
+# separated list of quotas.
+#cache quota = blob:20G
+#cache quota = library:10G
+{{ range $quota := .CacheQuota }}
+{{- if ne $quota "" -}}
+cache quota = {{$quota}}
+{{ end -}}
+{{ end }}
+
+# SYSTEM CACHE DIR: [STRING]
+# DEFAULT: Undefined
+# Absolute path of a read-only cache shared by all users, on shared storage
+# for example, consulted before the user cache so popular images are fetched
+# once per site. This is the 'cache' directory of a regular cache, populated
+# by the administrator by pulling images with SINGULARITY_CACHEDIR set to its
+# parent directory. Entries and blobs pulled in this cache are made readable
+# by all users. Blobs of the system cache are linked into the user caches
+# once their digest is verified, entries which are not readable by the user
+# are ignored. The system cache must be owned by root and only writable by
+# its owner.
+#system cache dir = /shared/singularity/cache
+{{ if ne .SystemCacheDir "" }}system cache dir = {{ .SystemCacheDir }}{{ end }}
+
 # ALLOW CONTAINER ${TYPE}: [BOOL]
 # DEFAULT: yes
 # This feature limits what kind of containers that Singularity will allow
@@ -321,6 +483,57 @@ always use rocm = {{ if eq .AlwaysUseRocm true }}yes{{ else }}no{{ end }}
 # - no: no capabilities (same as --no-privs)
 root default capabilities = {{ .RootDefaultCapabilities }}
 
+# SECCOMP PROFILE: [STRING]
+# DEFAULT: default
+# Define the seccomp profile applied to containers when the user doesn't
+# request one with --security seccomp:<profile.json>. Users can disable
+# filtering with --security seccomp:unconfined.
+# - default: builtin profile denying system calls modifying the host state
+#   (kernel modules, clocks, swap, reboot ...)
+# - unconfined: no seccomp filtering
+# - absolute path to a site JSON seccomp profile
+# Seccomp profiles are only applied when Singularity is built with seccomp
+# support.
+seccomp profile = {{ .SeccompProfile }}
+
+# SECCOMP ALLOW: [STRING]
+# DEFAULT: NULL
+# Comma separated list of system calls explicitly allowed by the seccomp
+# profile set above, allowing to relax the builtin or site profile.
+#seccomp allow = syslog, acct
+{{ range $index, $name := .SeccompAllow }}
+{{- if eq $index 0 }}seccomp allow = {{ else }}, {{ end }}{{$name}}
+{{- end }}
+
+# SECCOMP DENY: [STRING]
+# DEFAULT: NULL
+# Comma separated list of system calls denied with EPERM by the seccomp
+# profile set above, in addition to the system calls denied by the profile.
+#seccomp deny = userfaultfd, keyctl
+{{ range $index, $name := .SeccompDeny }}
+{{- if eq $index 0 }}seccomp deny = {{ else }}, {{ end }}{{$name}}
+{{- end }}
+
+# ENV DENY: [STRING]
+# DEFAULT: NULL
+# Comma separated list of regular expressions matching the whole name of the
+# environment variables removed from the container process environment before
+# its execution, with both the singularity and oci commands. The stripped
+# variables are logged. Expressions can't contain a comma.
+#env deny = LD_PRELOAD, LD_AUDIT, LD_.*_PATH, PYTHON(PATH|STARTUP)
+{{ range $index, $pattern := .EnvDeny }}
+{{- if eq $index 0 }}env deny = {{ else }}, {{ end }}{{$pattern}}
+{{- end }}
+
+# ENV ALLOW: [STRING]
+# DEFAULT: NULL
+# Comma separated list of regular expressions matching the whole name of the
+# environment variables kept despite matching an env deny expression.
+#env allow = LD_LIBRARY_PATH
+{{ range $index, $pattern := .EnvAllow }}
+{{- if eq $index 0 }}env allow = {{ else }}, {{ end }}{{$pattern}}
+{{- end }}
+
 # MEMORY FS TYPE: [tmpfs/ramfs]
 # DEFAULT: tmpfs
 # This feature allow to choose temporary filesystem type used by Singularity.
@@ -364,6 +577,47 @@ mksquashfs procs = {{ .MksquashfsProcs }}
 # mksquashfs mem = 1G
 {{ if ne .MksquashfsMem "" }}mksquashfs mem = {{ .MksquashfsMem }}{{ end }}
 
+# SQUASHFS PACKER: [STRING]
+# DEFAULT: mksquashfs
+# This allows the administrator to select the tool used to create squashfs
+# images. Valid values are mksquashfs, tar2sqfs (from squashfs-tools-ng) and
+# builtin. When tar2sqfs is selected, the mksquashfs procs value is used as the
+# number of compressor jobs, and mksquashfs mem is ignored. The builtin packer
+# doesn't require any external tool, it is also used when mksquashfs is not
+# found, but it creates larger images as it doesn't support fragments and
+# extended attributes.
+squashfs packer = {{ .SquashfsPacker }}
+
+# TAR2SQFS PATH: [STRING]
+# DEFAULT: Undefined
+# This allows the administrator to specify the location for tar2sqfs if it is not
+# installed in a standard system location
+# tar2sqfs path =
+{{ if ne .Tar2sqfsPath "" }}tar2sqfs path = {{ .Tar2sqfsPath }}{{ end }}
+
+# TMP DIR CANDIDATES: [STRING]
+# DEFAULT: Undefined
+# List of directories, in order of preference, where temporary build
+# directories and sandboxes of converted images are created when the user
+# doesn't set SINGULARITY_TMPDIR, like node-local NVMe drives or tmpfs mounts.
+# Job scratch directories set by the batch system (SLURM_TMPDIR, PBS_JOBFS,
+# _CONDOR_SCRATCH_DIR) are tried first and the system temporary directory
+# last. The first directory with enough free space for the estimated size is
+# selected, tmpfs directories are only selected when half of their free space
+# is enough as they consume memory.
+#tmp dir candidates = /local/nvme/tmp, /dev/shm
+{{ range $index, $path := .TmpDirCandidates }}
+{{- if eq $index 0 }}tmp dir candidates = {{ else }}, {{ end }}{{$path}}
+{{- end }}
+
+# MAX TMP DIR SIZE: [UINT]
+# DEFAULT: 0
+# Set the maximum size (in MB) of the temporary directory used by a single
+# build or image conversion. Runs whose estimated size exceeds this limit are
+# refused and a warning is reported when a run exceeds it. A value of 0 means
+# unlimited.
+max tmp dir size = {{ .MaxTmpDirSize }}
+
 # CRYPTSETUP PATH: [STRING]
 # DEFAULT: Undefined
 # This allows the administrator to specify the location of cryptsetup if
@@ -372,6 +626,115 @@ mksquashfs procs = {{ .MksquashfsProcs }}
 # recorded at build time.
 # cryptsetup path =
 {{ if ne .CryptsetupPath "" }}cryptsetup path = {{ .CryptsetupPath }}{{ end }}
+
+# DM VERITY: [yes/no/try]
+# DEFAULT: try
+# SIF images built with --verity contain a dm-verity hash tree of their root
+# filesystem partition. When enabled, the root filesystem is mounted through
+# a dm-verity device, so any tampering with the image data is detected when
+# it is read. The hash tree must be signed by a key of the global keyring,
+# otherwise its root hash can't be trusted. If 'try' is chosen, images are
+# mounted without verification when the hash tree isn't signed by a trusted
+# key or when the dm-verity device can't be set up (no setuid workflow,
+# missing dmsetup or kernel support). If 'yes' is chosen, the container fails
+# to start in these cases. This option has no effect for images without hash
+# tree.
+dm verity = {{ .DmVerity }}
+
+# DMSETUP PATH: [STRING]
+# DEFAULT: Undefined
+# This allows the administrator to specify the location of dmsetup used to
+# set up dm-verity devices. If this value is undefined, dmsetup is searched
+# in /usr/sbin, /sbin, /usr/bin and /bin.
+# dmsetup path =
+{{ if ne .DmsetupPath "" }}dmsetup path = {{ .DmsetupPath }}{{ end }}
+
+# IMA MEASUREMENT: [BOOL]
+# DEFAULT: no
+# When enabled, the digest of each image partition mounted in a container is
+# computed and image files are opened by the privileged setup process so the
+# kernel IMA subsystem records them in its measurement log according to the IMA
+# policy (eg: ima_policy=tcb). An attestation document listing partition
+# digests, IMA measurements and EVM extended attributes of images is available
+# in the container at /.singularity.d/attestation.json. Measurement log entries
+# are only reported with the setuid workflow.
+ima measurement = {{ if eq .IMAMeasurement true }}yes{{ else }}no{{ end }}
+
+# RUNTIME INFO: [BOOL]
+# DEFAULT: no
+# Expose the image, binds, overlays, namespaces and command line flags a
+# container was launched with in the read-only file /.singularity.d/runtime.json
+# within the container, for workflows recording the provenance of their
+# results. It can be displayed with 'singularity inspect --runtime' from inside
+# the container. As the bind sources and command line flags may reveal host
+# paths to the container processes, this is disabled by default.
+runtime info = {{ if eq .RuntimeInfo true }}yes{{ else }}no{{ end }}
+
+# RUNTIME INFO IMAGE DIGEST: [BOOL]
+# DEFAULT: no
+# Add the digest of the image file to the runtime information, computed with
+# the digest algorithm below. This requires the whole image to be read
+# before the container starts, which may take a while for large images.
+runtime info image digest = {{ if eq .RuntimeInfoImageDigest true }}yes{{ else }}no{{ end }}
+
+# DIGEST ALGORITHM: [STRING]
+# DEFAULT: sha256
+# This selects the digest algorithm used to compute the digests of images
+# reported by accounting and IMA measurement, the cache keys of http(s) pulls
+# and the digests of verify manifests, valid values are sha256, sha384 and
+# sha512. Digests are recorded with their algorithm (eg: sha512:<hex>) and
+# digests computed with any supported algorithm are still verified. SIF
+# signatures are always computed with sha256.
+digest algorithm = {{ .DigestAlgorithm }}
+
+# PULL QUARANTINE: [BOOL]
+# DEFAULT: no
+# When enabled, images pulled with 'singularity pull' or 'singularity prefetch'
+# and images built from registries or libraries are placed in a quarantine
+# directory instead of their destination, until they pass the quarantine
+# policy below and are released with 'singularity release'. Released images
+# are recorded by digest in a directory owned by root, only root can release
+# or approve images, and only image files with a released digest can be run.
+# Images can't be pulled to the cache by URI from the action commands (run,
+# exec, shell...) when quarantine is enabled.
+pull quarantine = {{ if eq .PullQuarantine true }}yes{{ else }}no{{ end }}
+
+# QUARANTINE DIR: [STRING]
+# DEFAULT: Undefined
+# Directory holding the quarantined images, the quarantine directory of the
+# user singularity directory ($HOME/.singularity/quarantine) by default.
+# quarantine dir =
+{{ if ne .QuarantineDir "" }}quarantine dir = {{ .QuarantineDir }}{{ end }}
+
+# QUARANTINE VERIFY: [BOOL]
+# DEFAULT: yes
+# Require valid signatures of quarantined images before releasing them.
+quarantine verify = {{ if eq .QuarantineVerify true }}yes{{ else }}no{{ end }}
+
+# QUARANTINE SCAN COMMAND: [STRING]
+# DEFAULT: Undefined
+# Absolute path of a command executed with the path of a quarantined image
+# as argument before releasing it, the image is only released if the command
+# exits successfully (eg: a vulnerability or malware scanner).
+# quarantine scan command =
+{{ if ne .QuarantineScanCommand "" }}quarantine scan command = {{ .QuarantineScanCommand }}{{ end }}
+
+# REGISTRY MIRROR: [STRING]
+# DEFAULT: Undefined
+# Mirror of a registry the docker and oras images are pulled from, of the
+# form <registry>=<mirror> where mirror is a registry host optionally
+# followed by a repository path prefix (eg: a pull-through cache proxying
+# Docker Hub). This directive can be specified multiple times, the mirrors of
+# a registry are tried in order and the registry itself is used when the
+# image can't be pulled from any of them. Images are never pushed to mirrors.
+#registry mirror = docker.io=harbor.example.com/dockerhub-proxy
+#registry mirror = docker.io=mirror.gcr.io
+{{ range $mirror := .RegistryMirrors }}
+{{- if ne $mirror "" -}}
+registry mirror = {{$mirror}}
+{{ end -}}
+{{ end }}
+
 # SHARED LOOP DEVICES: [BOOL]
 # DEFAULT: no
 # Allow to share same images associated with loop devices to minimize loop
@@ -70,6 +70,36 @@ func HasDirective(directive string) bool {
 	return false
 }
 
+// Changed returns the directives having different values in old
+// and new, in the order of the File fields.
+func Changed(old, new *File) []string {
+	var changed []string
+
+	o := reflect.ValueOf(old).Elem()
+	n := reflect.ValueOf(new).Elem()
+	for i := 0; i < o.NumField(); i++ {
+		if !reflect.DeepEqual(o.Field(i).Interface(), n.Field(i).Interface()) {
+			changed = append(changed, o.Type().Field(i).Tag.Get("directive"))
+		}
+	}
+
+	return changed
+}
+
+// Update sets the values of directives in file to their values
+// in from, unknown directives are ignored.
+func Update(file, from *File, directives ...string) {
+	dst := reflect.ValueOf(file).Elem()
+	src := reflect.ValueOf(from).Elem()
+	for _, directive := range directives {
+		for i := 0; i < dst.NumField(); i++ {
+			if dst.Type().Field(i).Tag.Get("directive") == directive {
+				dst.Field(i).Set(src.Field(i))
+			}
+		}
+	}
+}
+
 // GetConfig sets the corresponding interface fields associated
 // with directives.
 func GetConfig(directives Directives) (*File, error) {
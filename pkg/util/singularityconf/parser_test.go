@@ -148,3 +148,33 @@ func TestHasDirective(t *testing.T) {
 		t.Errorf("'fake directive' should not be present")
 	}
 }
+
+func TestChangedUpdate(t *testing.T) {
+	old, err := GetConfig(nil)
+	if err != nil {
+		t.Fatalf("failed to get the default configuration: %s", err)
+	}
+	new, err := GetConfig(Directives{
+		"accounting hook": {"/usr/local/bin/account"},
+		"bind path":       {"/etc/hosts"},
+	})
+	if err != nil {
+		t.Fatalf("failed to get the configuration: %s", err)
+	}
+
+	changed := Changed(old, new)
+	if !reflect.DeepEqual(changed, []string{"bind path", "accounting hook"}) {
+		t.Errorf("got changed directives %v instead of [bind path accounting hook]", changed)
+	}
+
+	Update(old, new, "accounting hook", "fake directive")
+	if old.AccountingHook != "/usr/local/bin/account" {
+		t.Errorf("accounting hook not updated")
+	}
+	if reflect.DeepEqual(old.BindPath, new.BindPath) {
+		t.Errorf("bind path updated")
+	}
+	if changed := Changed(old, new); !reflect.DeepEqual(changed, []string{"bind path"}) {
+		t.Errorf("got changed directives %v instead of [bind path] after update", changed)
+	}
+}
@@ -55,3 +55,47 @@ func Set(key string, value string) error {
 
 	return ioutil.WriteFile(path, []byte(value), 0000)
 }
+
+// ipcKeys are the sysctl keys isolated by the IPC namespace,
+// in addition to the fs.mqueue ones.
+var ipcKeys = map[string]bool{
+	"kernel.msgmax":          true,
+	"kernel.msgmnb":          true,
+	"kernel.msgmni":          true,
+	"kernel.sem":             true,
+	"kernel.shmall":          true,
+	"kernel.shmmax":          true,
+	"kernel.shmmni":          true,
+	"kernel.shm_rmid_forced": true,
+}
+
+// utsKeys are the sysctl keys isolated by the UTS namespace.
+var utsKeys = map[string]bool{
+	"kernel.domainname": true,
+	"kernel.hostname":   true,
+}
+
+// Namespace returns the namespace type isolating the sysctl key: ipc,
+// net or uts. An error is returned for keys not isolated by a namespace
+// as they can't be set for a container without affecting the host.
+func Namespace(key string) (string, error) {
+	key = strings.TrimSpace(key)
+	if strings.Contains(key, "/") {
+		key = strings.Replace(key, "/", ".", -1)
+	}
+	for _, c := range strings.Split(key, ".") {
+		if c == "" {
+			return "", fmt.Errorf("invalid sysctl key %s", key)
+		}
+	}
+
+	switch {
+	case ipcKeys[key] || strings.HasPrefix(key, "fs.mqueue."):
+		return "ipc", nil
+	case strings.HasPrefix(key, "net."):
+		return "net", nil
+	case utsKeys[key]:
+		return "uts", nil
+	}
+	return "", fmt.Errorf("sysctl %s is not isolated by a namespace", key)
+}
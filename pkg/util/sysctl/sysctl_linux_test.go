@@ -36,3 +36,33 @@ func TestGetSet(t *testing.T) {
 		t.Errorf("shoud have failed, key doesn't exists")
 	}
 }
+
+func TestNamespace(t *testing.T) {
+	tests := []struct {
+		key         string
+		expectedNs  string
+		expectError bool
+	}{
+		{key: "kernel.shmmax", expectedNs: "ipc"},
+		{key: "fs.mqueue.msg_max", expectedNs: "ipc"},
+		{key: "net.ipv4.ip_forward", expectedNs: "net"},
+		{key: "net/core/somaxconn", expectedNs: "net"},
+		{key: "kernel.domainname", expectedNs: "uts"},
+		{key: "kernel.pid_max", expectError: true},
+		{key: "vm.swappiness", expectError: true},
+		{key: "net..ipv4", expectError: true},
+		{key: "net/../kernel/pid_max", expectError: true},
+	}
+
+	for _, tt := range tests {
+		ns, err := Namespace(tt.key)
+		if err != nil && !tt.expectError {
+			t.Errorf("unexpected error for %s: %s", tt.key, err)
+		} else if err == nil && tt.expectError {
+			t.Errorf("unexpected success for %s", tt.key)
+		}
+		if ns != tt.expectedNs {
+			t.Errorf("unexpected namespace %q for %s", ns, tt.key)
+		}
+	}
+}
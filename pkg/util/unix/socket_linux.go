@@ -0,0 +1,38 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package unix
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// PeerCredentials returns the credentials of the process
+// connected to the unix socket conn.
+func PeerCredentials(conn net.Conn) (*syscall.Ucred, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a unix socket connection", conn.LocalAddr())
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get raw connection: %s", err)
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to control raw connection: %s", err)
+	} else if credErr != nil {
+		return nil, fmt.Errorf("failed to get peer credentials: %s", credErr)
+	}
+	return cred, nil
+}
@@ -6,9 +6,11 @@
 package unix
 
 import (
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"syscall"
 	"testing"
 	"time"
 
@@ -90,3 +92,52 @@ func TestCreateSocket(t *testing.T) {
 		}
 	}
 }
+
+func TestPeerCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peercred-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ln, err := CreateSocket(filepath.Join(dir, "socket"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	credCh := make(chan *syscall.Ucred, 1)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Error(err)
+			credCh <- nil
+			return
+		}
+		defer conn.Close()
+
+		cred, err := PeerCredentials(conn)
+		if err != nil {
+			t.Error(err)
+		}
+		credCh <- cred
+	}()
+
+	conn, err := Dial(filepath.Join(dir, "socket"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	cred := <-credCh
+	if cred == nil {
+		return
+	}
+	if cred.Uid != uint32(os.Getuid()) || cred.Gid != uint32(os.Getgid()) {
+		t.Errorf("unexpected peer credentials uid=%d gid=%d", cred.Uid, cred.Gid)
+	}
+	if cred.Pid != int32(os.Getpid()) {
+		t.Errorf("unexpected peer pid %d", cred.Pid)
+	}
+}
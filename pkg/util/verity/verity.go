@@ -0,0 +1,196 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package verity creates dm-verity hash trees for read-only image partitions
+// and sets up dm-verity devices verifying partition data on read.
+package verity
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+const (
+	// DescriptorName is the name of the SIF descriptor holding the
+	// dm-verity hash tree of the primary system partition.
+	DescriptorName = "dm-verity"
+	// BlockSize is the data and hash block size used by hash trees.
+	BlockSize = 4096
+	// HeaderSize is the size of the header holding the hash tree
+	// parameters, the hash tree starts right after it.
+	HeaderSize = BlockSize
+
+	hashAlgorithm = "sha256"
+	formatVersion = 1
+	saltSize      = 32
+	// maximum number of tree levels supported by the kernel
+	maxLevels = 63
+)
+
+// Params holds the dm-verity parameters stored in the hash tree
+// header, they are required to set up the dm-verity device.
+type Params struct {
+	Version       int    `json:"version"`
+	HashAlgorithm string `json:"hashAlgorithm"`
+	DataBlockSize uint32 `json:"dataBlockSize"`
+	HashBlockSize uint32 `json:"hashBlockSize"`
+	DataBlocks    uint64 `json:"dataBlocks"`
+	Salt          string `json:"salt"`
+	RootHash      string `json:"rootHash"`
+}
+
+// Check returns an error if parameters are not supported.
+func (p *Params) Check() error {
+	if p.Version != formatVersion {
+		return fmt.Errorf("unsupported dm-verity format version %d", p.Version)
+	}
+	if p.HashAlgorithm != hashAlgorithm {
+		return fmt.Errorf("unsupported dm-verity hash algorithm %s", p.HashAlgorithm)
+	}
+	if p.DataBlockSize != BlockSize || p.HashBlockSize != BlockSize {
+		return fmt.Errorf("unsupported dm-verity block sizes %d/%d", p.DataBlockSize, p.HashBlockSize)
+	}
+	if p.DataBlocks == 0 {
+		return fmt.Errorf("dm-verity hash tree doesn't cover any data block")
+	}
+	if _, err := hex.DecodeString(p.Salt); err != nil {
+		return fmt.Errorf("bad dm-verity salt: %s", err)
+	}
+	if h, err := hex.DecodeString(p.RootHash); err != nil || len(h) != sha256.Size {
+		return fmt.Errorf("bad dm-verity root hash %q", p.RootHash)
+	}
+	return nil
+}
+
+// DataSize returns the size of the data covered by the hash tree.
+func (p *Params) DataSize() uint64 {
+	return p.DataBlocks * uint64(p.DataBlockSize)
+}
+
+// Table returns the device-mapper table of the dm-verity device
+// verifying dataDev with the hash tree stored in hashDev.
+func (p *Params) Table(dataDev, hashDev string) string {
+	return fmt.Sprintf(
+		"0 %d verity %d %s %s %d %d %d %d %s %s %s",
+		p.DataSize()/512, p.Version, dataDev, hashDev,
+		p.DataBlockSize, p.HashBlockSize, p.DataBlocks,
+		HeaderSize/p.HashBlockSize, p.HashAlgorithm, p.RootHash, p.Salt,
+	)
+}
+
+func hashBlock(salt, block, dst []byte) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write(block)
+	return h.Sum(dst)
+}
+
+// CreateHashTree reads size bytes of data from r and returns the
+// dm-verity (format version 1) parameters and the hash tree with the
+// highest tree level first, as expected by the kernel. The data size
+// must be a multiple of the block size.
+func CreateHashTree(r io.Reader, size int64, salt []byte) (*Params, []byte, error) {
+	if size <= 0 || size%BlockSize != 0 {
+		return nil, nil, fmt.Errorf("data size %d is not a multiple of %d bytes", size, BlockSize)
+	}
+
+	blocks := uint64(size / BlockSize)
+	block := make([]byte, BlockSize)
+	digests := make([]byte, 0, blocks*sha256.Size)
+
+	for i := uint64(0); i < blocks; i++ {
+		if _, err := io.ReadFull(r, block); err != nil {
+			return nil, nil, fmt.Errorf("while reading data block %d: %s", i, err)
+		}
+		digests = hashBlock(salt, block, digests)
+	}
+
+	// same computation as the kernel, a single data block
+	// doesn't require any tree level
+	levels := 0
+	for levels < maxLevels && (blocks-1)>>(7*uint(levels)) > 0 {
+		levels++
+	}
+
+	treeLevels := make([][]byte, 0, levels)
+	for i := 0; i < levels; i++ {
+		n := (len(digests) + BlockSize - 1) / BlockSize
+		level := make([]byte, n*BlockSize)
+		copy(level, digests)
+		treeLevels = append(treeLevels, level)
+
+		digests = make([]byte, 0, n*sha256.Size)
+		for j := 0; j < n; j++ {
+			digests = hashBlock(salt, level[j*BlockSize:(j+1)*BlockSize], digests)
+		}
+	}
+
+	var tree bytes.Buffer
+	for i := len(treeLevels) - 1; i >= 0; i-- {
+		tree.Write(treeLevels[i])
+	}
+
+	p := &Params{
+		Version:       formatVersion,
+		HashAlgorithm: hashAlgorithm,
+		DataBlockSize: BlockSize,
+		HashBlockSize: BlockSize,
+		DataBlocks:    blocks,
+		Salt:          hex.EncodeToString(salt),
+		RootHash:      hex.EncodeToString(digests[:sha256.Size]),
+	}
+	return p, tree.Bytes(), nil
+}
+
+// Create reads size bytes of data from r and returns the dm-verity
+// object data composed of the parameters header followed by the hash
+// tree computed with a random salt.
+func Create(r io.Reader, size int64) ([]byte, *Params, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, fmt.Errorf("while generating salt: %s", err)
+	}
+
+	p, tree, err := CreateHashTree(r, size, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header, err := json.Marshal(p)
+	if err != nil {
+		return nil, nil, fmt.Errorf("while encoding dm-verity parameters: %s", err)
+	}
+	if len(header) > HeaderSize {
+		return nil, nil, fmt.Errorf("dm-verity parameters exceed header size")
+	}
+
+	data := make([]byte, HeaderSize, HeaderSize+len(tree))
+	copy(data, header)
+
+	return append(data, tree...), p, nil
+}
+
+// ReadParams reads and checks the dm-verity parameters from the
+// header located at offset.
+func ReadParams(r io.ReaderAt, offset int64) (*Params, error) {
+	header := make([]byte, HeaderSize)
+	if _, err := r.ReadAt(header, offset); err != nil {
+		return nil, fmt.Errorf("while reading dm-verity header: %s", err)
+	}
+
+	p := new(Params)
+	if err := json.Unmarshal(bytes.TrimRight(header, "\x00"), p); err != nil {
+		return nil, fmt.Errorf("while decoding dm-verity parameters: %s", err)
+	}
+	if err := p.Check(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package verity
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/util/fs/lock"
+)
+
+// Open creates a read-only dm-verity device verifying dataDev with
+// the hash tree stored in hashDev, it returns the device-mapper name
+// assigned to the device which can be later used to close it.
+func Open(dmsetup string, dataDev, hashDev string, p *Params) (string, error) {
+	if !filepath.IsAbs(dmsetup) {
+		return "", fmt.Errorf("dmsetup path %q is not absolute", dmsetup)
+	}
+
+	fd, err := lock.Exclusive("/dev/mapper")
+	if err != nil {
+		return "", fmt.Errorf("unable to acquire lock on /dev/mapper")
+	}
+	defer lock.Release(fd)
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return "", fmt.Errorf("id generation failed: %v", err)
+	}
+	name := "singularity-verity-" + id.String()
+
+	cmd := exec.Command(dmsetup, "create", name, "--readonly", "--table", p.Table(dataDev, hashDev))
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: 0, Gid: 0}
+	sylog.Debugf("Running %s %s", cmd.Path, strings.Join(cmd.Args, " "))
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("dmsetup create failed: %s: %v", strings.TrimSpace(string(out)), err)
+	}
+
+	for attempt := 0; true; attempt++ {
+		_, err := os.Stat("/dev/mapper/" + name)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		delayNext := 100 * (1 << attempt) * time.Millisecond
+		if delayNext-1 >= 25500*time.Millisecond {
+			return "", fmt.Errorf("device /dev/mapper/%s did not show up", name)
+		}
+		time.Sleep(delayNext)
+	}
+
+	sylog.Debugf("Successfully opened dm-verity device %s for %s", name, dataDev)
+	return name, nil
+}
+
+// Close removes the dm-verity device.
+func Close(dmsetup string, name string) error {
+	cmd := exec.Command(dmsetup, "remove", name)
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: 0, Gid: 0}
+	sylog.Debugf("Running %s %s", cmd.Path, strings.Join(cmd.Args, " "))
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("dmsetup remove failed: %s: %v", strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package verity
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func sum(salt []byte, data []byte) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func pad(data []byte) []byte {
+	b := make([]byte, BlockSize)
+	copy(b, data)
+	return b
+}
+
+func dataBlocks(n int) []byte {
+	data := make([]byte, n*BlockSize)
+	for i := 0; i < n; i++ {
+		data[i*BlockSize] = byte(i)
+		data[i*BlockSize+1] = byte(i >> 8)
+	}
+	return data
+}
+
+func TestCreateHashTree(t *testing.T) {
+	salt := []byte("salt")
+
+	if _, _, err := CreateHashTree(bytes.NewReader(make([]byte, 100)), 100, salt); err == nil {
+		t.Errorf("unexpected success with unaligned data size")
+	}
+
+	// single data block: root hash is the data block hash
+	data := dataBlocks(1)
+	p, tree, err := CreateHashTree(bytes.NewReader(data), int64(len(data)), salt)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(tree) != 0 || p.RootHash != hex.EncodeToString(sum(salt, data)) {
+		t.Errorf("unexpected single block hash tree")
+	}
+
+	// two data blocks: one level
+	data = dataBlocks(2)
+	p, tree, err = CreateHashTree(bytes.NewReader(data), int64(len(data)), salt)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	level0 := pad(append(sum(salt, data[:BlockSize]), sum(salt, data[BlockSize:])...))
+	if !bytes.Equal(tree, level0) {
+		t.Errorf("unexpected hash tree for two data blocks")
+	}
+	if p.RootHash != hex.EncodeToString(sum(salt, level0)) {
+		t.Errorf("unexpected root hash for two data blocks")
+	}
+
+	// 129 data blocks: two levels, highest level first
+	data = dataBlocks(129)
+	p, tree, err = CreateHashTree(bytes.NewReader(data), int64(len(data)), salt)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var digests []byte
+	for i := 0; i < 129; i++ {
+		digests = append(digests, sum(salt, data[i*BlockSize:(i+1)*BlockSize])...)
+	}
+	l0a, l0b := digests[:BlockSize], pad(digests[BlockSize:])
+	level1 := pad(append(sum(salt, l0a), sum(salt, l0b)...))
+
+	expected := append(append(append([]byte{}, level1...), l0a...), l0b...)
+	if !bytes.Equal(tree, expected) {
+		t.Errorf("unexpected hash tree for 129 data blocks")
+	}
+	if p.RootHash != hex.EncodeToString(sum(salt, level1)) || p.DataBlocks != 129 {
+		t.Errorf("unexpected parameters for 129 data blocks: %+v", p)
+	}
+}
+
+func TestCreateReadParams(t *testing.T) {
+	data := dataBlocks(4)
+
+	obj, p, err := Create(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(obj) != HeaderSize+BlockSize {
+		t.Errorf("unexpected object size %d", len(obj))
+	}
+
+	// prepend some data to check offset handling
+	r := bytes.NewReader(append(make([]byte, 10), obj...))
+	read, err := ReadParams(r, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if *read != *p {
+		t.Errorf("unexpected parameters read: %+v instead of %+v", read, p)
+	}
+
+	table := read.Table("/dev/loop0", "/dev/loop1")
+	expected := "0 32 verity 1 /dev/loop0 /dev/loop1 4096 4096 4 1 sha256 " + p.RootHash + " " + p.Salt
+	if table != expected {
+		t.Errorf("unexpected table %q", table)
+	}
+
+	read.Version = 0
+	if err := read.Check(); err == nil {
+		t.Errorf("unexpected success with unsupported version")
+	}
+}